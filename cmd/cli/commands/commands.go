@@ -43,13 +43,21 @@ var envConfigs = map[string]config.Config{
 	},
 }
 
-func GetOrchardDB(env string) (*db.DB, error) {
+func GetConfig(env string) (config.Config, error) {
 	cfg := envConfigs[DefaultENV]
 	if _cfg, ok := envConfigs[env]; env != "" && !ok {
-		return nil, fmt.Errorf("invalid env value")
+		return cfg, fmt.Errorf("invalid env value")
 	} else if ok {
 		cfg = _cfg
 	}
+	return cfg, nil
+}
+
+func GetOrchardDB(env string) (*db.DB, error) {
+	cfg, err := GetConfig(env)
+	if err != nil {
+		return nil, err
+	}
 	dbClient, err := db.New(cfg)
 	if err != nil {
 		return nil, err
@@ -58,11 +66,9 @@ func GetOrchardDB(env string) (*db.DB, error) {
 }
 
 func GetAuth0Client(env string) (*clients.Auth0Client, error) {
-	cfg := envConfigs[DefaultENV]
-	if _cfg, ok := envConfigs[env]; env != "" && !ok {
-		return nil, fmt.Errorf("invalid env value")
-	} else if ok {
-		cfg = _cfg
+	cfg, err := GetConfig(env)
+	if err != nil {
+		return nil, err
 	}
 	auth0Client := clients.NewAuth0Client(cfg)
 	return auth0Client, nil
@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+func GetMigrateCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "migrate",
+		Usage: "apply the sql migrations under internal/db/migrations that haven't been applied yet",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only list the pending migrations",
+				Value: false,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			return migrate(context.Background(), c.String("env"), c.Bool("dry-run"))
+		},
+	}
+}
+
+func migrate(ctx context.Context, env string, dryRun bool) error {
+	dbClient, err := GetOrchardDB(env)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		pending, err := dbClient.PendingMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			fmt.Println("No pending migrations")
+		}
+		for _, migration := range pending {
+			fmt.Println("Pending migration", migration.Version)
+		}
+		return nil
+	}
+
+	applied, err := dbClient.Migrate(ctx)
+	for _, version := range applied {
+		fmt.Println("Applied migration", version)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println("No pending migrations")
+	}
+	return nil
+}
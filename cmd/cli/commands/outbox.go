@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+func GetOutboxCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "outbox",
+		Usage: "inspect and retry outbox messages (bouncer cache busts, auth0 provisioning)",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "dead-letters",
+				Usage: "list outbox messages that exhausted their retries",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "tenant",
+						Aliases: []string{"t"},
+						Usage:   "only list dead letters for the given tenant id",
+					},
+					&cli.IntFlag{
+						Name:  "limit",
+						Usage: "max number of dead letters to list",
+						Value: 100,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return listDeadLetters(context.Background(), c.String("env"), c.String("tenant"), c.Int("limit"))
+				},
+			},
+			{
+				Name:      "requeue",
+				Usage:     "move dead outbox messages back to pending so they are retried",
+				ArgsUsage: "<outbox id>...",
+				Action: func(c *cli.Context) error {
+					return requeueDeadLetters(context.Background(), c.String("env"), c.Args().Slice()...)
+				},
+			},
+		},
+	}
+}
+
+func listDeadLetters(ctx context.Context, env, tenantID string, limit int) error {
+	dbClient, err := GetOrchardDB(env)
+	if err != nil {
+		return err
+	}
+
+	msgs, err := dbClient.NewOutboxService().GetDeadLetters(ctx, tenantID, limit, 0)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.MarshalIndent(msgs, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(raw))
+
+	return nil
+}
+
+func requeueDeadLetters(ctx context.Context, env string, ids ...string) error {
+	if len(ids) == 0 {
+		return fmt.Errorf("at least one outbox id is required")
+	}
+
+	dbClient, err := GetOrchardDB(env)
+	if err != nil {
+		return err
+	}
+
+	svc := dbClient.NewOutboxService()
+	for _, id := range ids {
+		fmt.Println("Requeueing outbox message", id)
+		if err := svc.Requeue(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/loupe-co/orchard/internal/permcatalog"
+	authPb "github.com/loupe-co/protos/src/common/auth"
+	"github.com/urfave/cli/v2"
+)
+
+func GetPermissionsCommand() *cli.Command {
+	setFlag := &cli.StringFlag{
+		Name:    "set",
+		Aliases: []string{"s"},
+		Usage:   "permission set name, e.g. 'Group'",
+		Value:   authPb.PermissionSet_Group.String(),
+	}
+	tenantFlag := &cli.StringFlag{
+		Name:     "tenant",
+		Aliases:  []string{"t"},
+		Usage:    "tenant id",
+		Required: true,
+	}
+	return &cli.Command{
+		Name:  "permissions",
+		Usage: "list permission sets and permissions and decode the permission masks stored in sql",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "list every permission set and permission with its bits",
+				Action: func(c *cli.Context) error {
+					return listPermissions()
+				},
+			},
+			{
+				Name:      "decode",
+				Usage:     "print the permissions in a mask",
+				ArgsUsage: "<mask>",
+				Flags:     []cli.Flag{setFlag},
+				Action: func(c *cli.Context) error {
+					return decodePermissions(c.String("set"), c.Args().First())
+				},
+			},
+			{
+				Name:      "encode",
+				Usage:     "print the mask for named permissions",
+				ArgsUsage: "<permission>...",
+				Flags:     []cli.Flag{setFlag},
+				Action: func(c *cli.Context) error {
+					return encodePermissions(c.String("set"), c.Args().Slice()...)
+				},
+			},
+			{
+				Name:  "roles",
+				Usage: "print a tenant's system roles with their permissions decoded",
+				Flags: []cli.Flag{
+					tenantFlag,
+					&cli.StringFlag{
+						Name:    "query",
+						Aliases: []string{"q"},
+						Usage:   "only roles with names matching query",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return printSystemRolePermissions(context.Background(), c.String("env"), c.String("tenant"), c.String("query"))
+				},
+			},
+			{
+				Name:      "viewers",
+				Usage:     "print people's group viewer permissions decoded",
+				ArgsUsage: "<person id>...",
+				Flags:     []cli.Flag{tenantFlag},
+				Action: func(c *cli.Context) error {
+					return printGroupViewerPermissions(context.Background(), c.String("env"), c.String("tenant"), c.Args().Slice()...)
+				},
+			},
+		},
+	}
+}
+
+func listPermissions() error {
+	for _, s := range permcatalog.Sets() {
+		fmt.Printf("%s (%d): %s\n", s.Name, s.Set, s.Description)
+		for _, p := range s.Permissions {
+			fmt.Printf("  %-16s %#-8x %s\n", p.Name, p.Bits, p.Description)
+		}
+	}
+	return nil
+}
+
+func decodePermissions(setName, mask string) error {
+	set, err := permcatalog.ParseSet(setName)
+	if err != nil {
+		return err
+	}
+	bits, err := strconv.ParseInt(mask, 0, 64)
+	if err != nil {
+		return fmt.Errorf("invalid mask %q: %w", mask, err)
+	}
+	fmt.Println(permcatalog.Format(set, bits))
+	return nil
+}
+
+func encodePermissions(setName string, names ...string) error {
+	set, err := permcatalog.ParseSet(setName)
+	if err != nil {
+		return err
+	}
+	bits, err := permcatalog.Encode(set, names)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d (%#x)\n", bits, bits)
+	return nil
+}
+
+func printSystemRolePermissions(ctx context.Context, env, tenantID, query string) error {
+	dbClient, err := GetOrchardDB(env)
+	if err != nil {
+		return err
+	}
+
+	roles, err := dbClient.NewSystemRoleService().Search(ctx, tenantID, query)
+	if err != nil {
+		return err
+	}
+
+	for _, sr := range roles {
+		fmt.Printf("%s %s (%s)\n", sr.ID, sr.Name, sr.Status)
+		for set, bits := range sr.Permissions {
+			if bits == 0 {
+				continue
+			}
+			fmt.Printf("  %-16s %s\n", authPb.PermissionSet(set), permcatalog.Format(authPb.PermissionSet(set), bits))
+		}
+	}
+
+	return nil
+}
+
+func printGroupViewerPermissions(ctx context.Context, env, tenantID string, personIDs ...string) error {
+	if len(personIDs) == 0 {
+		return fmt.Errorf("at least one person id is required")
+	}
+
+	dbClient, err := GetOrchardDB(env)
+	if err != nil {
+		return err
+	}
+
+	viewers, err := dbClient.NewGroupViewerService().GetPersonsViewableGroups(ctx, tenantID, personIDs...)
+	if err != nil {
+		return err
+	}
+
+	for _, gv := range viewers {
+		fmt.Printf("%s views %s: %s\n", gv.PersonID, gv.GroupID, permcatalog.Format(authPb.PermissionSet_Group, gv.Permissions))
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/loupe-co/go-common/fixtures"
+	"github.com/loupe-co/orchard/internal/cache"
+	"github.com/loupe-co/orchard/internal/clients"
+	"github.com/loupe-co/orchard/internal/handlers"
+	"github.com/urfave/cli/v2"
+)
+
+func GetReconcileAuth0Command() *cli.Command {
+	return &cli.Command{
+		Name:  "reconcile-auth0",
+		Usage: "compare provisioned people in orchard with their auth0 users and report (or fix) any discrepancies",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:    "tenant",
+				Aliases: []string{"t"},
+				Usage:   "tenant id(s) to reconcile, defaults to all active tenants",
+			},
+			&cli.BoolFlag{
+				Name:  "fix",
+				Usage: "if fix is passed, discrepancies are corrected on the side that isn't the source of truth",
+				Value: false,
+			},
+			&cli.StringFlag{
+				Name:  "source-of-truth",
+				Usage: "which side wins when fixing, either 'orchard' or 'auth0'",
+				Value: handlers.ReconcileSourceOrchard,
+			},
+			&cli.StringFlag{
+				Name:    "out",
+				Aliases: []string{"o"},
+				Usage:   "file to write the discrepancy report to",
+				Value:   "./auth0_reconcile_report.json",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			env := c.String("env")
+			opts := handlers.ReconcileAuth0Options{
+				Fix:           c.Bool("fix"),
+				SourceOfTruth: c.String("source-of-truth"),
+			}
+			fmt.Printf("Attempting to reconcile auth0 users with orchard (fix:%v, sourceOfTruth:%s)\n", opts.Fix, opts.SourceOfTruth)
+			return reconcileAuth0(context.Background(), env, c.StringSlice("tenant"), opts, c.String("out"))
+		},
+	}
+}
+
+func reconcileAuth0(ctx context.Context, env string, tenantIDs []string, opts handlers.ReconcileAuth0Options, out string) error {
+	cfg, err := GetConfig(env)
+	if err != nil {
+		return err
+	}
+
+	dbClient, err := GetOrchardDB(env)
+	if err != nil {
+		return err
+	}
+
+	auth0Client, err := GetAuth0Client(env)
+	if err != nil {
+		return err
+	}
+
+	if len(tenantIDs) == 0 {
+		fmt.Println("Getting active tenants...")
+		tenants, err := dbClient.NewTenantService().GetActiveTenants(ctx)
+		if err != nil {
+			return err
+		}
+		for _, tenant := range tenants {
+			tenantIDs = append(tenantIDs, tenant.ID)
+		}
+	}
+
+	if opts.Fix {
+		unlock, ok, err := dbClient.TryLock(ctx, handlers.Auth0ReconcileLock)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("another auth0 reconciliation is running, try again once it finishes")
+		}
+		defer unlock()
+	}
+
+	// Fixes change people, so the server's cached hierarchy reads have to be invalidated as well
+	hierarchyCache, err := cache.NewFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Reconciling only talks to auth0 and the database, the other clients are no-op fakes rather than nil
+	fakes := clients.NewFakes()
+	h := handlers.New(cfg, dbClient, fakes.Tenant, fakes.CRM, auth0Client, fakes.Bouncer, hierarchyCache)
+
+	reports := []*handlers.Auth0ReconcileReport{}
+	for _, tenantID := range tenantIDs {
+		fmt.Println("Reconciling auth0 users for tenant", tenantID)
+		report, err := h.ReconcileAuth0(ctx, tenantID, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Found %d discrepancies for tenant %s\n", len(report.Discrepancies), tenantID)
+		reports = append(reports, report)
+	}
+
+	raw, err := json.MarshalIndent(map[string]interface{}{"reports": reports}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return fixtures.WriteTestResult(out, raw)
+}
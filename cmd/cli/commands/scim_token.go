@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+)
+
+func GetSCIMTokenCommand() *cli.Command {
+	tenantFlag := &cli.StringFlag{
+		Name:     "tenant",
+		Aliases:  []string{"t"},
+		Usage:    "tenant id the scim token belongs to",
+		Required: true,
+	}
+	return &cli.Command{
+		Name:  "scim-token",
+		Usage: "manage the bearer tokens IdPs use to provision people through scim",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "create",
+				Usage: "create a new scim bearer token for a tenant, the token is only printed once",
+				Flags: []cli.Flag{
+					tenantFlag,
+					&cli.StringFlag{
+						Name:    "description",
+						Aliases: []string{"d"},
+						Usage:   "what the token is used for, e.g. 'okta'",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return createSCIMToken(context.Background(), c.String("env"), c.String("tenant"), c.String("description"))
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "list a tenant's scim tokens",
+				Flags: []cli.Flag{tenantFlag},
+				Action: func(c *cli.Context) error {
+					return listSCIMTokens(context.Background(), c.String("env"), c.String("tenant"))
+				},
+			},
+			{
+				Name:      "revoke",
+				Usage:     "revoke scim tokens so they can no longer be used",
+				ArgsUsage: "<token id>...",
+				Flags:     []cli.Flag{tenantFlag},
+				Action: func(c *cli.Context) error {
+					return revokeSCIMTokens(context.Background(), c.String("env"), c.String("tenant"), c.Args().Slice()...)
+				},
+			},
+		},
+	}
+}
+
+func createSCIMToken(ctx context.Context, env, tenantID, description string) error {
+	dbClient, err := GetOrchardDB(env)
+	if err != nil {
+		return err
+	}
+
+	token, err := dbClient.NewSCIMTokenService().Create(ctx, tenantID, description)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Created scim token, store it now as it can't be shown again:")
+	fmt.Println(token)
+
+	return nil
+}
+
+func listSCIMTokens(ctx context.Context, env, tenantID string) error {
+	dbClient, err := GetOrchardDB(env)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := dbClient.NewSCIMTokenService().GetTenantTokens(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(raw))
+
+	return nil
+}
+
+func revokeSCIMTokens(ctx context.Context, env, tenantID string, ids ...string) error {
+	if len(ids) == 0 {
+		return fmt.Errorf("at least one scim token id is required")
+	}
+
+	dbClient, err := GetOrchardDB(env)
+	if err != nil {
+		return err
+	}
+
+	svc := dbClient.NewSCIMTokenService()
+	for _, id := range ids {
+		fmt.Println("Revoking scim token", id)
+		if err := svc.Revoke(ctx, tenantID, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -10,6 +10,7 @@ func GetSyncCommand() *cli.Command {
 		Usage: "sync various user/group data to/from orchard",
 		Subcommands: []*cli.Command{
 			GetImportAuth0Command(),
+			GetReconcileAuth0Command(),
 		},
 	}
 }
@@ -37,6 +37,11 @@ func getApp() *cli.App {
 		Commands: []*cli.Command{
 			commands.GetSyncCommand(),
 			commands.GetUpdateGroupTypesCommand(),
+			commands.GetOutboxCommand(),
+			commands.GetSCIMTokenCommand(),
+			commands.GetPermissionsCommand(),
+			commands.GetReconcileAuth0Command(),
+			commands.GetMigrateCommand(),
 		},
 	}
 	return app
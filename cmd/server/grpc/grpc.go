@@ -3,7 +3,7 @@ package grpc
 import (
 	"context"
 
-	"github.com/loupe-co/bouncer/pkg/client"
+	"github.com/loupe-co/orchard/internal/cache"
 	"github.com/loupe-co/orchard/internal/clients"
 	"github.com/loupe-co/orchard/internal/config"
 	"github.com/loupe-co/orchard/internal/db"
@@ -12,21 +12,45 @@ import (
 	servicePb "github.com/loupe-co/protos/src/services/orchard"
 )
 
+// OrchardGRPCServer serves the handlers. Hierarchy reads go through the hierarchy cache, the handlers invalidate it
+// whenever they change a tenant's hierarchy.
 type OrchardGRPCServer struct {
-	cfg      config.Config
-	db       *db.DB
-	handlers *handlers.Handlers
+	cfg            config.Config
+	db             db.Store
+	handlers       *handlers.Handlers
+	hierarchyCache *cache.HierarchyCache
 }
 
-func New(cfg config.Config, dbClient *db.DB, tenantClient *clients.TenantClient, crmClient *clients.CRMClient, auth0Client *clients.Auth0Client, bouncerClient *client.BouncerClient) *OrchardGRPCServer {
-	h := handlers.New(cfg, dbClient, tenantClient, crmClient, auth0Client, bouncerClient)
+func New(cfg config.Config, dbClient db.Store, tenantClient clients.TenantService, crmDataSource clients.CRMDataSource, identityProvider clients.IdentityProvider, bouncerClient clients.AuthCacheBuster, hierarchyCache *cache.HierarchyCache) *OrchardGRPCServer {
+	h := handlers.New(cfg, dbClient, tenantClient, crmDataSource, identityProvider, bouncerClient, hierarchyCache)
 	return &OrchardGRPCServer{
-		cfg:      cfg,
-		db:       dbClient,
-		handlers: h,
+		cfg:            cfg,
+		db:             dbClient,
+		handlers:       h,
+		hierarchyCache: hierarchyCache,
 	}
 }
 
+// RunOutboxDispatcher delivers queued bouncer cache busts and auth0 provisioning until the context is cancelled
+func (server *OrchardGRPCServer) RunOutboxDispatcher(ctx context.Context) error {
+	return server.handlers.RunOutboxDispatcher(ctx)
+}
+
+// DispatchOutbox delivers one batch of due outbox messages right away instead of waiting for the dispatcher's next tick
+func (server *OrchardGRPCServer) DispatchOutbox(ctx context.Context) (int, error) {
+	return server.handlers.DispatchOutbox(ctx)
+}
+
+// RunGroupViewerSweeper deletes expired group viewer grants and busts bouncer's cache when grants start or end until the context is cancelled
+func (server *OrchardGRPCServer) RunGroupViewerSweeper(ctx context.Context) error {
+	return server.handlers.RunGroupViewerSweeper(ctx)
+}
+
+// RunAuth0Reconciler reconciles provisioned people with auth0 on the configured schedule until the context is cancelled
+func (server *OrchardGRPCServer) RunAuth0Reconciler(ctx context.Context) error {
+	return server.handlers.RunAuth0Reconciler(ctx)
+}
+
 func (server *OrchardGRPCServer) GetUserTeam(ctx context.Context, in *servicePb.GetUserTeamRequest) (*servicePb.GetUserTeamResponse, error) {
 	return server.handlers.GetUserTeam(ctx, in)
 }
@@ -72,7 +96,7 @@ func (server *OrchardGRPCServer) UpdateGroupSyncMetadata(ctx context.Context, in
 }
 
 func (server *OrchardGRPCServer) GetLegacyTeamStructure(ctx context.Context, in *servicePb.GetLegacyTeamStructureRequest) (*servicePb.GetLegacyTeamStructureResponse, error) {
-	return server.handlers.GetLegacyTeamStructure(ctx, in)
+	return cache.ReadThrough(ctx, server.hierarchyCache, in.TenantId, "GetLegacyTeamStructure", in, server.handlers.GetLegacyTeamStructure)
 }
 
 // System Roles
@@ -96,6 +120,10 @@ func (server *OrchardGRPCServer) GetSystemRoles(ctx context.Context, in *service
 	return server.handlers.GetSystemRoles(ctx, in)
 }
 
+func (server *OrchardGRPCServer) GetSystemRoleDiffs(ctx context.Context, in *servicePb.GetSystemRoleDiffsRequest) (*servicePb.GetSystemRoleDiffsResponse, error) {
+	return server.handlers.GetSystemRoleDiffs(ctx, in)
+}
+
 func (server *OrchardGRPCServer) UpdateSystemRole(ctx context.Context, in *servicePb.UpdateSystemRoleRequest) (*servicePb.UpdateSystemRoleResponse, error) {
 	return server.handlers.UpdateSystemRole(ctx, in)
 }
@@ -104,6 +132,30 @@ func (server *OrchardGRPCServer) DeleteSystemRoleById(ctx context.Context, in *s
 	return server.handlers.DeleteSystemRoleById(ctx, in)
 }
 
+func (server *OrchardGRPCServer) DeleteSystemRole(ctx context.Context, in *servicePb.DeleteSystemRoleRequest) (*servicePb.DeleteSystemRoleResponse, error) {
+	return server.handlers.DeleteSystemRole(ctx, in)
+}
+
+func (server *OrchardGRPCServer) GetSystemRoleUsage(ctx context.Context, in *servicePb.GetSystemRoleUsageRequest) (*servicePb.GetSystemRoleUsageResponse, error) {
+	return server.handlers.GetSystemRoleUsage(ctx, in)
+}
+
+func (server *OrchardGRPCServer) GetRoleAssignmentRules(ctx context.Context, in *servicePb.GetRoleAssignmentRulesRequest) (*servicePb.GetRoleAssignmentRulesResponse, error) {
+	return server.handlers.GetRoleAssignmentRules(ctx, in)
+}
+
+func (server *OrchardGRPCServer) SetRoleAssignmentRules(ctx context.Context, in *servicePb.SetRoleAssignmentRulesRequest) (*servicePb.SetRoleAssignmentRulesResponse, error) {
+	return server.handlers.SetRoleAssignmentRules(ctx, in)
+}
+
+func (server *OrchardGRPCServer) PreviewRoleAssignmentRules(ctx context.Context, in *servicePb.PreviewRoleAssignmentRulesRequest) (*servicePb.PreviewRoleAssignmentRulesResponse, error) {
+	return server.handlers.PreviewRoleAssignmentRules(ctx, in)
+}
+
+func (server *OrchardGRPCServer) ApplyRoleAssignmentRules(ctx context.Context, in *servicePb.ApplyRoleAssignmentRulesRequest) (*servicePb.ApplyRoleAssignmentRulesResponse, error) {
+	return server.handlers.ApplyRoleAssignmentRules(ctx, in)
+}
+
 func (server *OrchardGRPCServer) UpsertCRMRoles(ctx context.Context, in *servicePb.UpsertCRMRolesRequest) (*servicePb.UpsertCRMRolesResponse, error) {
 	return server.handlers.UpsertCRMRoles(ctx, in)
 }
@@ -133,6 +185,7 @@ func (server *OrchardGRPCServer) InsertGroupViewer(ctx context.Context, in *serv
 	return server.handlers.InsertGroupViewer(ctx, in)
 }
 
+// Viewer reads aren't cached, grants start and end with the clock rather than with a write that invalidates the tenant
 func (server *OrchardGRPCServer) GetGroupViewers(ctx context.Context, in *servicePb.IdRequest) (*servicePb.GetGroupViewersResponse, error) {
 	return server.handlers.GetGroupViewers(ctx, in)
 }
@@ -141,6 +194,18 @@ func (server *OrchardGRPCServer) GetPersonViewableGroups(ctx context.Context, in
 	return server.handlers.GetPersonViewableGroups(ctx, in)
 }
 
+func (server *OrchardGRPCServer) GetEffectivePermissions(ctx context.Context, in *servicePb.GetEffectivePermissionsRequest) (*servicePb.GetEffectivePermissionsResponse, error) {
+	return server.handlers.GetEffectivePermissions(ctx, in)
+}
+
+func (server *OrchardGRPCServer) CheckAccess(ctx context.Context, in *servicePb.CheckAccessRequest) (*servicePb.CheckAccessResponse, error) {
+	return server.handlers.CheckAccess(ctx, in)
+}
+
+func (server *OrchardGRPCServer) GetPermissionCatalog(ctx context.Context, in *servicePb.GetPermissionCatalogRequest) (*servicePb.GetPermissionCatalogResponse, error) {
+	return server.handlers.GetPermissionCatalog(ctx, in)
+}
+
 func (server *OrchardGRPCServer) SetPersonViewableGroups(ctx context.Context, in *servicePb.SetPersonViewableGroupsRequest) (*servicePb.SetPersonViewableGroupsResponse, error) {
 	return server.handlers.SetPersonViewableGroups(ctx, in)
 }
@@ -153,6 +218,43 @@ func (server *OrchardGRPCServer) DeleteGroupViewerById(ctx context.Context, in *
 	return server.handlers.DeleteGroupViewerById(ctx, in)
 }
 
+func (server *OrchardGRPCServer) SetGroupViewers(ctx context.Context, in *servicePb.SetGroupViewersRequest) (*servicePb.SetGroupViewersResponse, error) {
+	return server.handlers.SetGroupViewers(ctx, in)
+}
+
+func (server *OrchardGRPCServer) CopyViewerGrants(ctx context.Context, in *servicePb.CopyViewerGrantsRequest) (*servicePb.CopyViewerGrantsResponse, error) {
+	return server.handlers.CopyViewerGrants(ctx, in)
+}
+
+func (server *OrchardGRPCServer) ApplySubtreeViewers(ctx context.Context, in *servicePb.ApplySubtreeViewersRequest) (*servicePb.ApplySubtreeViewersResponse, error) {
+	return server.handlers.ApplySubtreeViewers(ctx, in)
+}
+
+// Access review
+func (server *OrchardGRPCServer) OpenAccessReview(ctx context.Context, in *servicePb.OpenAccessReviewRequest) (*servicePb.OpenAccessReviewResponse, error) {
+	return server.handlers.OpenAccessReview(ctx, in)
+}
+
+func (server *OrchardGRPCServer) GetAccessReviews(ctx context.Context, in *servicePb.GetAccessReviewsRequest) (*servicePb.GetAccessReviewsResponse, error) {
+	return server.handlers.GetAccessReviews(ctx, in)
+}
+
+func (server *OrchardGRPCServer) GetAccessReviewItems(ctx context.Context, in *servicePb.GetAccessReviewItemsRequest) (*servicePb.GetAccessReviewItemsResponse, error) {
+	return server.handlers.GetAccessReviewItems(ctx, in)
+}
+
+func (server *OrchardGRPCServer) RecordAccessReviewDecisions(ctx context.Context, in *servicePb.RecordAccessReviewDecisionsRequest) (*servicePb.RecordAccessReviewDecisionsResponse, error) {
+	return server.handlers.RecordAccessReviewDecisions(ctx, in)
+}
+
+func (server *OrchardGRPCServer) CloseAccessReview(ctx context.Context, in *servicePb.CloseAccessReviewRequest) (*servicePb.CloseAccessReviewResponse, error) {
+	return server.handlers.CloseAccessReview(ctx, in)
+}
+
+func (server *OrchardGRPCServer) GetAccessReviewReport(ctx context.Context, in *servicePb.GetAccessReviewReportRequest) (*servicePb.GetAccessReviewReportResponse, error) {
+	return server.handlers.GetAccessReviewReport(ctx, in)
+}
+
 // Person
 func (server *OrchardGRPCServer) CreatePerson(ctx context.Context, in *servicePb.CreatePersonRequest) (*servicePb.CreatePersonResponse, error) {
 	return server.handlers.CreatePerson(ctx, in)
@@ -170,6 +272,10 @@ func (server *OrchardGRPCServer) SearchPeople(ctx context.Context, in *servicePb
 	return server.handlers.SearchPeople(ctx, in)
 }
 
+func (server *OrchardGRPCServer) SearchDirectory(ctx context.Context, in *servicePb.SearchDirectoryRequest) (*servicePb.SearchDirectoryResponse, error) {
+	return server.handlers.SearchDirectory(ctx, in)
+}
+
 func (server *OrchardGRPCServer) GetGroupMembers(ctx context.Context, in *servicePb.GetGroupMembersRequest) (*servicePb.GetGroupMembersResponse, error) {
 	return server.handlers.GetGroupMembers(ctx, in)
 }
@@ -224,7 +330,7 @@ func (server *OrchardGRPCServer) GetManagerAndParentIDs(ctx context.Context, in
 }
 
 func (server *OrchardGRPCServer) GetGroupSubTree(ctx context.Context, in *servicePb.GetGroupSubTreeRequest) (*servicePb.GetGroupSubTreeResponse, error) {
-	return server.handlers.GetGroupSubTree(ctx, in)
+	return cache.ReadThrough(ctx, server.hierarchyCache, in.TenantId, "GetGroupSubTree", in, server.handlers.GetGroupSubTree)
 }
 
 func (server *OrchardGRPCServer) UpdateGroup(ctx context.Context, in *servicePb.UpdateGroupRequest) (*servicePb.UpdateGroupResponse, error) {
@@ -258,3 +364,12 @@ func (server *OrchardGRPCServer) GetPeopleByEmail(ctx context.Context, in *servi
 func (server *OrchardGRPCServer) GetOutreachUserCommitMappings(ctx context.Context, in *servicePb.GetOutreachUserCommitMappingsRequest) (*servicePb.GetOutreachUserCommitMappingsResponse, error) {
 	return server.handlers.GetOutreachUserCommitMappings(ctx, in)
 }
+
+// Export
+func (server *OrchardGRPCServer) ExportPeople(in *servicePb.ExportPeopleRequest, stream servicePb.Orchard_ExportPeopleServer) error {
+	return server.handlers.ExportPeople(in, stream)
+}
+
+func (server *OrchardGRPCServer) ExportGroups(in *servicePb.ExportGroupsRequest, stream servicePb.Orchard_ExportGroupsServer) error {
+	return server.handlers.ExportGroups(in, stream)
+}
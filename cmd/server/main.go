@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"math"
 	"os"
 	"syscall"
+	"time"
 
 	bouncer "github.com/loupe-co/bouncer/pkg/client"
 	common "github.com/loupe-co/go-common"
@@ -13,6 +15,8 @@ import (
 	logGRPC "github.com/loupe-co/go-loupe-logger/grpc"
 	"github.com/loupe-co/go-loupe-logger/log"
 	grpcHandlers "github.com/loupe-co/orchard/cmd/server/grpc"
+	"github.com/loupe-co/orchard/cmd/server/scim"
+	"github.com/loupe-co/orchard/internal/cache"
 	"github.com/loupe-co/orchard/internal/clients"
 	"github.com/loupe-co/orchard/internal/config"
 	"github.com/loupe-co/orchard/internal/db"
@@ -56,10 +60,17 @@ func main() {
 		return
 	}
 
-	crmClient, err := clients.NewCRMClient(cfg)
-	if err != nil {
-		log.Errorf("error getting crm client: %s", err.Error())
-		return
+	var crmDataSource clients.CRMDataSource
+	if cfg.CRMDataSource == "file" {
+		log.Info(fmt.Sprintf("using crm snapshots from %s, crm-data-access will not be called", cfg.CRMSnapshotDir))
+		crmDataSource = clients.NewFileCRMDataSource(cfg.CRMSnapshotDir)
+	} else {
+		crmClient, err := clients.NewCRMClient(cfg)
+		if err != nil {
+			log.Errorf("error getting crm client: %s", err.Error())
+			return
+		}
+		crmDataSource = crmClient
 	}
 
 	bouncerClient, err := bouncer.NewBouncerClient(
@@ -73,10 +84,20 @@ func main() {
 		return
 	}
 
-	auth0Client := clients.NewAuth0Client(cfg)
+	var identityProvider clients.IdentityProvider = clients.NewAuth0Client(cfg)
+	if cfg.IdentityProvider == "memory" {
+		log.Info("using in-memory identity provider, people will not be provisioned in auth0")
+		identityProvider = clients.NewMemoryIdentityProvider()
+	}
+
+	hierarchyCache, err := cache.NewFromConfig(cfg)
+	if err != nil {
+		log.Errorf("error getting hierarchy cache: %s", err.Error())
+		return
+	}
 
 	// Create grpc server
-	orchardServer := grpcHandlers.New(cfg, dbClient, tenantClient, crmClient, auth0Client, bouncerClient)
+	orchardServer := grpcHandlers.New(cfg, dbClient, tenantClient, crmDataSource, identityProvider, bouncerClient, hierarchyCache)
 	grpcServer := common.NewGRPCServer(
 		cfg.GRPCHost,
 		cfg.GRPCPort,
@@ -88,6 +109,42 @@ func main() {
 		servicePb.RegisterOrchardServer(server, orchardServer)
 	})
 
+	// Deliver outbox messages (bouncer cache busts, auth0 provisioning), sweep group viewer grants and reconcile auth0 in the background
+	bgCtx, cancelBackground := context.WithCancel(context.Background())
+	defer cancelBackground()
+	go func() {
+		if err := orchardServer.RunOutboxDispatcher(bgCtx); err != nil {
+			log.Errorf("error running outbox dispatcher: %s", err.Error())
+		}
+	}()
+	go func() {
+		if err := orchardServer.RunGroupViewerSweeper(bgCtx); err != nil {
+			log.Errorf("error running group viewer sweeper: %s", err.Error())
+		}
+	}()
+	go func() {
+		if err := orchardServer.RunAuth0Reconciler(bgCtx); err != nil {
+			log.Errorf("error running auth0 reconciler: %s", err.Error())
+		}
+	}()
+
+	// Serve SCIM provisioning for IdPs alongside grpc if a port is configured
+	if cfg.SCIMPort > 0 {
+		scimServer := scim.New(cfg, dbClient, orchardServer)
+		go func() {
+			if err := scimServer.ListenAndServe(); err != nil {
+				log.Errorf("error running scim server: %s", err.Error())
+			}
+		}()
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := scimServer.Shutdown(shutdownCtx); err != nil {
+				log.Errorf("error shutting down scim server: %s", err.Error())
+			}
+		}()
+	}
+
 	// Create EKG server (AKA health checks)
 	ekgServer := ekg.New()
 	ekgServer.Handle("sql", db.DefaultHealthCheckPolicy, dbClient.HealthCheck)
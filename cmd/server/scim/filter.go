@@ -0,0 +1,125 @@
+package scim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// filterClause is a single `attribute operator value` comparison from a SCIM filter
+type filterClause struct {
+	Attr  string
+	Op    string
+	Value interface{}
+}
+
+var filterOps = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+// parseFilter parses the subset of the SCIM filter grammar IdPs send in practice: comparisons joined with `and`.
+// `or`, `not` and value paths (emails[type eq "work"]) are rejected as invalid filters.
+func parseFilter(filter string) ([]filterClause, error) {
+	tokens, err := tokenizeFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	clauses := []filterClause{}
+	for i := 0; i < len(tokens); {
+		if len(clauses) > 0 {
+			if !strings.EqualFold(tokens[i], "and") {
+				return nil, fmt.Errorf("unsupported filter operator %q, only 'and' is supported", tokens[i])
+			}
+			i++
+			if i >= len(tokens) {
+				return nil, fmt.Errorf("filter can't end with 'and'")
+			}
+		}
+
+		attr := tokens[i]
+		if strings.ContainsAny(attr, "[]()") {
+			return nil, fmt.Errorf("unsupported filter attribute %q", attr)
+		}
+		if i+1 >= len(tokens) {
+			return nil, fmt.Errorf("missing operator for filter attribute %q", attr)
+		}
+		op := strings.ToLower(tokens[i+1])
+		if !filterOps[op] {
+			return nil, fmt.Errorf("unsupported filter operator %q", tokens[i+1])
+		}
+		if op == "pr" {
+			clauses = append(clauses, filterClause{Attr: attr, Op: op})
+			i += 2
+			continue
+		}
+		if i+2 >= len(tokens) {
+			return nil, fmt.Errorf("missing value for filter attribute %q", attr)
+		}
+		value, err := parseFilterValue(tokens[i+2])
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, filterClause{Attr: attr, Op: op, Value: value})
+		i += 3
+	}
+
+	return clauses, nil
+}
+
+func tokenizeFilter(filter string) ([]string, error) {
+	tokens := []string{}
+	current := strings.Builder{}
+	inQuotes := false
+	escaped := false
+	for _, r := range strings.TrimSpace(filter) {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case inQuotes && r == '\\':
+			current.WriteRune(r)
+			escaped = true
+		case r == '"':
+			current.WriteRune(r)
+			inQuotes = !inQuotes
+		case !inQuotes && (r == ' ' || r == '\t'):
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated string in filter")
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens, nil
+}
+
+func parseFilterValue(token string) (interface{}, error) {
+	if strings.HasPrefix(token, "\"") {
+		value, err := strconv.Unquote(token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter value %s", token)
+		}
+		return value, nil
+	}
+	switch strings.ToLower(token) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	if n, err := strconv.ParseFloat(token, 64); err == nil {
+		return n, nil
+	}
+	return nil, fmt.Errorf("invalid filter value %s", token)
+}
@@ -0,0 +1,387 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/models"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+)
+
+const (
+	groupsPath = "/scim/v2/Groups"
+)
+
+type memberRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+type groupResource struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	DisplayName string      `json:"displayName"`
+	Members     []memberRef `json:"members,omitempty"`
+	Meta        *meta       `json:"meta,omitempty"`
+}
+
+func toGroupResource(g *models.Group, members []*models.Person) *groupResource {
+	resource := &groupResource{
+		Schemas:     []string{SchemaGroup},
+		ID:          g.ID,
+		DisplayName: g.Name,
+	}
+	for _, p := range members {
+		resource.Members = append(resource.Members, memberRef{Value: p.ID, Display: p.Name.String})
+	}
+	m := newMeta("Group", fmt.Sprintf("%s/%s", groupsPath, g.ID), g.CreatedAt, g.UpdatedAt)
+	resource.Meta = &m
+	return resource
+}
+
+// Groups are created and arranged in orchard (or synced from the CRM), the IdP can only manage their membership.
+// A person belongs to a single group, so adding them to a group moves them out of their current one.
+func (server *Server) handleGroups(w http.ResponseWriter, r *http.Request) {
+	id := resourceID(r, groupsPath)
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		server.listGroups(w, r)
+	case r.Method == http.MethodGet:
+		server.getGroup(w, r, id)
+	case r.Method == http.MethodPut && id != "":
+		server.replaceGroup(w, r, id)
+	case r.Method == http.MethodPatch && id != "":
+		server.patchGroup(w, r, id)
+	case r.Method == http.MethodPost, r.Method == http.MethodDelete:
+		writeError(w, http.StatusNotImplemented, "", "groups are managed in orchard, only group membership can be provisioned")
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+	}
+}
+
+func groupMatches(g *models.Group, clauses []filterClause) (bool, error) {
+	for _, clause := range clauses {
+		var field string
+		switch strings.ToLower(clause.Attr) {
+		case "id":
+			field = g.ID
+		case "displayname":
+			field = g.Name
+		default:
+			return false, fmt.Errorf("unsupported filter attribute %q", clause.Attr)
+		}
+		if clause.Op == "pr" {
+			if field == "" {
+				return false, nil
+			}
+			continue
+		}
+
+		value, ok := clause.Value.(string)
+		if !ok {
+			return false, fmt.Errorf("invalid value for filter attribute %q", clause.Attr)
+		}
+		field, value = strings.ToLower(field), strings.ToLower(value)
+
+		var match bool
+		switch clause.Op {
+		case "eq":
+			match = field == value
+		case "ne":
+			match = field != value
+		case "co":
+			match = strings.Contains(field, value)
+		case "sw":
+			match = strings.HasPrefix(field, value)
+		case "ew":
+			match = strings.HasSuffix(field, value)
+		default:
+			return false, fmt.Errorf("unsupported operator %q for group filters", clause.Op)
+		}
+		if !match {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// listGroups doesn't include members, IdPs fetch a single group to read its members
+func (server *Server) listGroups(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID := getTenantID(ctx)
+
+	clauses, err := parseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, scimTypeInvalidFilter, err.Error())
+		return
+	}
+
+	groups, err := server.db.NewGroupService().Search(ctx, tenantID, "")
+	if err != nil {
+		log.WithContext(ctx).WithTenantID(tenantID).Error(errors.Wrap(err, "error searching groups for scim"))
+		writeError(w, http.StatusInternalServerError, "", "error listing groups")
+		return
+	}
+
+	matches := []*models.Group{}
+	for _, g := range groups {
+		if g.Status != statusActive {
+			continue
+		}
+		ok, err := groupMatches(g, clauses)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, scimTypeInvalidFilter, err.Error())
+			return
+		}
+		if ok {
+			matches = append(matches, g)
+		}
+	}
+
+	startIndex, limit, offset := getPage(r)
+	resources := []interface{}{}
+	for i := offset; i < len(matches) && len(resources) < limit; i++ {
+		resources = append(resources, toGroupResource(matches[i], nil))
+	}
+
+	writeJSON(w, http.StatusOK, &listResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: int64(len(matches)),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// getGroupWithMembers returns the group and its members, writing a 404 and returning nil if it doesn't exist in the tenant
+func (server *Server) getGroupWithMembers(ctx context.Context, w http.ResponseWriter, id string) (*models.Group, []*models.Person) {
+	tenantID := getTenantID(ctx)
+	logger := log.WithContext(ctx).WithTenantID(tenantID).WithCustom("groupId", id)
+
+	group, err := server.db.NewGroupService().GetByID(ctx, id, tenantID)
+	if err != nil {
+		logger.Error(errors.Wrap(err, "error getting group for scim"))
+		writeError(w, http.StatusInternalServerError, "", "error getting group")
+		return nil, nil
+	}
+	if group == nil || group.Status != statusActive {
+		writeError(w, http.StatusNotFound, "", fmt.Sprintf("group %s not found", id))
+		return nil, nil
+	}
+
+	members, err := server.db.NewPersonService().GetPeopleByGroupId(ctx, tenantID, id)
+	if err != nil {
+		logger.Error(errors.Wrap(err, "error getting group members for scim"))
+		writeError(w, http.StatusInternalServerError, "", "error getting group members")
+		return nil, nil
+	}
+
+	return group, members
+}
+
+func (server *Server) getGroup(w http.ResponseWriter, r *http.Request, id string) {
+	group, members := server.getGroupWithMembers(r.Context(), w, id)
+	if group == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, toGroupResource(group, members))
+}
+
+func (server *Server) replaceGroup(w http.ResponseWriter, r *http.Request, id string) {
+	ctx := r.Context()
+
+	resource := &groupResource{}
+	if err := readJSON(r, resource); err != nil {
+		writeError(w, http.StatusBadRequest, scimTypeInvalidSyntax, "invalid group resource")
+		return
+	}
+
+	group, members := server.getGroupWithMembers(ctx, w, id)
+	if group == nil {
+		return
+	}
+	if resource.DisplayName != "" && resource.DisplayName != group.Name {
+		writeError(w, http.StatusBadRequest, scimTypeMutability, "group displayName is managed in orchard")
+		return
+	}
+
+	memberIDs := map[string]bool{}
+	for _, member := range resource.Members {
+		memberIDs[member.Value] = true
+	}
+
+	add, remove := []string{}, []string{}
+	current := map[string]bool{}
+	for _, p := range members {
+		current[p.ID] = true
+		if !memberIDs[p.ID] {
+			remove = append(remove, p.ID)
+		}
+	}
+	for memberID := range memberIDs {
+		if !current[memberID] {
+			add = append(add, memberID)
+		}
+	}
+
+	server.updateGroupMembers(ctx, w, group, add, remove)
+}
+
+func (server *Server) patchGroup(w http.ResponseWriter, r *http.Request, id string) {
+	ctx := r.Context()
+
+	patch := &patchRequest{}
+	if err := readJSON(r, patch); err != nil {
+		writeError(w, http.StatusBadRequest, scimTypeInvalidSyntax, "invalid patch request")
+		return
+	}
+
+	group, members := server.getGroupWithMembers(ctx, w, id)
+	if group == nil {
+		return
+	}
+
+	add, remove := []string{}, []string{}
+	for _, op := range patch.Operations {
+		opType := strings.ToLower(op.Op)
+		path := strings.ToLower(op.Path)
+		switch {
+		case path == "displayname" || (path == "" && strings.Contains(strings.ToLower(string(op.Value)), "displayname")):
+			writeError(w, http.StatusBadRequest, scimTypeMutability, "group displayName is managed in orchard")
+			return
+		case (path == "members" || path == "") && (opType == "add" || opType == "replace"):
+			refs, err := decodeMembers(op.Value, path == "")
+			if err != nil {
+				writeError(w, http.StatusBadRequest, scimTypeInvalidValue, err.Error())
+				return
+			}
+			if opType == "replace" {
+				for _, p := range members {
+					remove = append(remove, p.ID)
+				}
+			}
+			for _, ref := range refs {
+				add = append(add, ref.Value)
+			}
+		case path == "members" && opType == "remove":
+			if len(op.Value) == 0 {
+				for _, p := range members {
+					remove = append(remove, p.ID)
+				}
+				continue
+			}
+			refs, err := decodeMembers(op.Value, false)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, scimTypeInvalidValue, err.Error())
+				return
+			}
+			for _, ref := range refs {
+				remove = append(remove, ref.Value)
+			}
+		case strings.HasPrefix(path, "members[value eq ") && opType == "remove":
+			// Okta removes members with a value path, e.g. members[value eq "2819c223"]
+			memberID := strings.Trim(strings.TrimSuffix(strings.TrimPrefix(op.Path[len("members[value eq "):], " "), "]"), `"`)
+			remove = append(remove, memberID)
+		default:
+			writeError(w, http.StatusBadRequest, scimTypeInvalidPath, fmt.Sprintf("unsupported patch op %q for path %q", op.Op, op.Path))
+			return
+		}
+	}
+
+	// a member that is removed and re-added in the same request stays in the group
+	added := map[string]bool{}
+	for _, memberID := range add {
+		added[memberID] = true
+	}
+	kept := []string{}
+	for _, memberID := range remove {
+		if !added[memberID] {
+			kept = append(kept, memberID)
+		}
+	}
+
+	server.updateGroupMembers(ctx, w, group, add, kept)
+}
+
+// decodeMembers reads a list of member references, or an object with a members list when the patch op has no path
+func decodeMembers(raw json.RawMessage, wrapped bool) ([]memberRef, error) {
+	refs := []memberRef{}
+	if wrapped {
+		value := struct {
+			Members []memberRef `json:"members"`
+		}{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("invalid value for members")
+		}
+		return value.Members, nil
+	}
+	if err := json.Unmarshal(raw, &refs); err != nil {
+		return nil, fmt.Errorf("invalid value for members")
+	}
+	return refs, nil
+}
+
+// updateGroupMembers moves people in and out of the group with UpdatePerson, so a move derives their type, applies the tenant's
+// role assignment rules and busts their auth cache the same as one made through gRPC. Every member is checked before anyone is moved.
+func (server *Server) updateGroupMembers(ctx context.Context, w http.ResponseWriter, group *models.Group, add, remove []string) {
+	tenantID := getTenantID(ctx)
+	svc := server.db.NewPersonService()
+
+	changes := map[string]string{}
+	for _, memberID := range remove {
+		changes[memberID] = ""
+	}
+	for _, memberID := range add {
+		changes[memberID] = group.ID
+	}
+
+	moves := []string{}
+	for memberID, groupID := range changes {
+		person, err := svc.GetByID(ctx, memberID, tenantID)
+		if err != nil || person == nil {
+			writeError(w, http.StatusBadRequest, scimTypeInvalidValue, fmt.Sprintf("member %s not found", memberID))
+			return
+		}
+		// removing someone who already moved to another group is a no-op
+		if groupID == "" && person.GroupID.String != group.ID {
+			continue
+		}
+		if person.GroupID.String == groupID {
+			continue
+		}
+		moves = append(moves, memberID)
+	}
+	sort.Strings(moves)
+
+	for _, memberID := range moves {
+		_, err := server.handlers.UpdatePerson(ctx, &servicePb.UpdatePersonRequest{
+			TenantId: tenantID,
+			PersonId: memberID,
+			Person: &orchardPb.Person{
+				Id:        memberID,
+				TenantId:  tenantID,
+				GroupId:   changes[memberID],
+				UpdatedBy: db.DefaultSCIMSyncID,
+			},
+			OnlyFields: []string{"group_id"},
+		})
+		if err != nil {
+			writeHandlerError(w, err, "error updating group members")
+			return
+		}
+	}
+
+	group, members := server.getGroupWithMembers(ctx, w, group.ID)
+	if group == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, toGroupResource(group, members))
+}
@@ -0,0 +1,267 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/config"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/handlers"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	SchemaUser                   = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaEnterpriseUser         = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	SchemaGroup                  = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse           = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp                = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                  = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceProviderConfig  = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	contentTypeSCIM              = "application/scim+json"
+	defaultPageSize              = 100
+	maxPageSize                  = 1000
+	scimTypeInvalidFilter        = "invalidFilter"
+	scimTypeInvalidValue         = "invalidValue"
+	scimTypeInvalidPath          = "invalidPath"
+	scimTypeUniqueness           = "uniqueness"
+	scimTypeMutability           = "mutability"
+	scimTypeInvalidSyntax        = "invalidSyntax"
+	scimRequestTimeout           = 30 * time.Second
+	scimMaxRequestBodyBytes      = 1 << 20
+	scimServiceProviderConfigDoc = "https://datatracker.ietf.org/doc/html/rfc7644"
+)
+
+type tenantIDKey struct{}
+
+// Server is a SCIM 2.0 endpoint that lets an IdP (Okta, Azure AD) provision people and group membership into orchard.
+// Each request is authenticated with a per-tenant bearer token, see db.SCIMTokenService. Reads go to the store, writes go through
+// the grpc handlers so people changed by an IdP are provisioned, busted, typed and given roles like everyone else.
+type Server struct {
+	cfg      config.Config
+	db       db.Store
+	handlers *handlers.Handlers
+	http     *http.Server
+}
+
+func New(cfg config.Config, dbClient db.Store, h *handlers.Handlers) *Server {
+	server := &Server{
+		cfg:      cfg,
+		db:       dbClient,
+		handlers: h,
+	}
+	server.http = &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", cfg.GRPCHost, cfg.SCIMPort),
+		Handler:           server.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server
+}
+
+// Handler returns the SCIM routes, everything except the discovery endpoints requires a bearer token
+func (server *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/scim/v2/ServiceProviderConfig", server.handleServiceProviderConfig)
+	mux.Handle("/scim/v2/Users", server.authenticate(http.HandlerFunc(server.handleUsers)))
+	mux.Handle("/scim/v2/Users/", server.authenticate(http.HandlerFunc(server.handleUsers)))
+	mux.Handle("/scim/v2/Groups", server.authenticate(http.HandlerFunc(server.handleGroups)))
+	mux.Handle("/scim/v2/Groups/", server.authenticate(http.HandlerFunc(server.handleGroups)))
+	return mux
+}
+
+// ListenAndServe serves SCIM requests until Shutdown is called
+func (server *Server) ListenAndServe() error {
+	log.Info(fmt.Sprintf("SCIM server listening on %s", server.http.Addr))
+	if err := server.http.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (server *Server) Shutdown(ctx context.Context) error {
+	return server.http.Shutdown(ctx)
+}
+
+func (server *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if !strings.HasPrefix(strings.ToLower(authHeader), "bearer ") {
+			writeError(w, http.StatusUnauthorized, "", "missing bearer token")
+			return
+		}
+		token := strings.TrimSpace(authHeader[len("bearer "):])
+		if token == "" {
+			writeError(w, http.StatusUnauthorized, "", "missing bearer token")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), scimRequestTimeout)
+		defer cancel()
+
+		tenantID, err := server.db.NewSCIMTokenService().GetTenantID(ctx, token)
+		if err != nil {
+			log.WithContext(ctx).Error(err)
+			writeError(w, http.StatusInternalServerError, "", "error checking bearer token")
+			return
+		}
+		if tenantID == "" {
+			writeError(w, http.StatusUnauthorized, "", "invalid bearer token")
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, scimMaxRequestBodyBytes)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, tenantIDKey{}, tenantID)))
+	})
+}
+
+func getTenantID(ctx context.Context) string {
+	tenantID, _ := ctx.Value(tenantIDKey{}).(string)
+	return tenantID
+}
+
+// resourceID returns the id following the resource prefix, e.g. /scim/v2/Users/{id}
+func resourceID(r *http.Request, prefix string) string {
+	return strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+}
+
+type scimError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	SCIMType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, scimType, detail string) {
+	writeJSON(w, status, &scimError{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(status),
+		SCIMType: scimType,
+		Detail:   detail,
+	})
+}
+
+// writeHandlerError responds to a failed grpc handler call with the SCIM error matching its status code. The handlers log
+// their own errors.
+func writeHandlerError(w http.ResponseWriter, err error, detail string) {
+	st := status.Convert(err)
+	switch st.Code() {
+	case codes.InvalidArgument:
+		writeError(w, http.StatusBadRequest, scimTypeInvalidValue, st.Message())
+	case codes.AlreadyExists:
+		writeError(w, http.StatusConflict, scimTypeUniqueness, "a user with the given userName already exists")
+	case codes.NotFound:
+		writeError(w, http.StatusNotFound, "", st.Message())
+	default:
+		writeError(w, http.StatusInternalServerError, "", detail)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", contentTypeSCIM)
+	w.WriteHeader(status)
+	if body == nil {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Errorf("error writing scim response: %s", err.Error())
+	}
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	defer r.Body.Close()
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+type listResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int64         `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// getPage reads the 1-based startIndex and count query params, returning a limit and offset
+func getPage(r *http.Request) (startIndex, limit, offset int) {
+	startIndex = 1
+	if v, err := strconv.Atoi(r.URL.Query().Get("startIndex")); err == nil && v > 1 {
+		startIndex = v
+	}
+	limit = defaultPageSize
+	if v, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && v >= 0 {
+		limit = v
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return startIndex, limit, startIndex - 1
+}
+
+type meta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Location     string `json:"location,omitempty"`
+}
+
+func newMeta(resourceType, location string, createdAt, updatedAt time.Time) meta {
+	return meta{
+		ResourceType: resourceType,
+		Created:      createdAt.UTC().Format(time.RFC3339),
+		LastModified: updatedAt.UTC().Format(time.RFC3339),
+		Location:     location,
+	}
+}
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type filterSupported struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type authenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type serviceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	DocumentationURI      string                 `json:"documentationUri"`
+	Patch                 supported              `json:"patch"`
+	Bulk                  supported              `json:"bulk"`
+	Filter                filterSupported        `json:"filter"`
+	ChangePassword        supported              `json:"changePassword"`
+	Sort                  supported              `json:"sort"`
+	ETag                  supported              `json:"etag"`
+	AuthenticationSchemes []authenticationScheme `json:"authenticationSchemes"`
+}
+
+func (server *Server) handleServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, &serviceProviderConfig{
+		Schemas:          []string{SchemaServiceProviderConfig},
+		DocumentationURI: scimServiceProviderConfigDoc,
+		Patch:            supported{Supported: true},
+		Bulk:             supported{Supported: false},
+		Filter:           filterSupported{Supported: true, MaxResults: maxPageSize},
+		ChangePassword:   supported{Supported: false},
+		Sort:             supported{Supported: false},
+		ETag:             supported{Supported: false},
+		AuthenticationSchemes: []authenticationScheme{
+			{Type: "oauthbearertoken", Name: "OAuth Bearer Token", Description: "per-tenant bearer token issued by orchard"},
+		},
+	})
+}
@@ -0,0 +1,826 @@
+package scim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/loupe-co/go-common/fixtures"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/clients"
+	"github.com/loupe-co/orchard/internal/config"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/handlers"
+	"github.com/loupe-co/orchard/internal/models"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+const (
+	testPatID   = "527470d1-d895-49f3-a9d4-48d8e37f6317"
+	testAlexID  = "bc739427-ba29-4cac-993c-0fe7296d5514"
+	testDinoID  = "f387bfca-a7bd-4d96-bbe1-b654edfcd900"
+	testWillID  = "740adf33-2db0-46f8-924f-4c604408b866"
+	testGrantID = "4c763cfe-6406-4221-913c-f5db90224f44"
+)
+
+func TestMain(m *testing.M) {
+	os.Setenv("PROJECT_ID", "local")
+	log.InitLogger()
+	fixtures.InitTestFixtures("../../../fixtures", "../../../fixtures/results")
+	os.Exit(m.Run())
+}
+
+// testSCIM is a SCIM server over a memory store seeded from the fixtures, with a bearer token for the default tenant
+type testSCIM struct {
+	server   *httptest.Server
+	store    *db.MemoryStore
+	handlers *handlers.Handlers
+	fakes    *clients.Fakes
+	token    string
+}
+
+func newTestSCIM() (*testSCIM, error) {
+	store := db.NewMemoryStore()
+	ctx := context.Background()
+
+	seeds := map[string]interface{}{
+		"system_roles": &[]*models.SystemRole{},
+		"groups":       &[]*models.Group{},
+		"people":       &[]*models.Person{},
+	}
+	for key, rows := range seeds {
+		raw, _, _, err := jsonparser.Get(fixtures.Data["seed"], key)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s seed data: %w", key, err)
+		}
+		if err := json.Unmarshal(raw, rows); err != nil {
+			return nil, fmt.Errorf("error parsing %s seed data: %w", key, err)
+		}
+	}
+	for _, sr := range *seeds["system_roles"].(*[]*models.SystemRole) {
+		if err := store.NewSystemRoleService().Insert(ctx, sr); err != nil {
+			return nil, err
+		}
+	}
+	for _, g := range *seeds["groups"].(*[]*models.Group) {
+		if err := store.NewGroupService().Insert(ctx, g); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range *seeds["people"].(*[]*models.Person) {
+		if err := store.NewPersonService().Insert(ctx, p); err != nil {
+			return nil, err
+		}
+	}
+	store.SetTenant(&models.Tenant{
+		ID:                db.DefaultTenantID,
+		Status:            "active",
+		Name:              "test",
+		GroupSyncState:    "inactive",
+		GroupSyncMetadata: types.JSON("{}"),
+	})
+
+	token, err := store.NewSCIMTokenService().Create(ctx, db.DefaultTenantID, "scim tests")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := config.Config{}
+	h, fakes := handlers.NewWithFakes(cfg, store)
+	return &testSCIM{
+		server:   httptest.NewServer(New(cfg, store, h).Handler()),
+		store:    store,
+		handlers: h,
+		fakes:    fakes,
+		token:    token,
+	}, nil
+}
+
+func (ts *testSCIM) Close() {
+	ts.server.Close()
+}
+
+// do sends a SCIM request with the given bearer token, body is sent as is when it's raw json. The response is decoded
+// into out when it's not nil, the raw body is returned either way.
+func (ts *testSCIM) do(method, path, token string, body interface{}, out interface{}) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		raw, ok := body.([]byte)
+		if !ok {
+			var err error
+			if raw, err = json.Marshal(body); err != nil {
+				return 0, nil, err
+			}
+		}
+		reader = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequest(method, ts.server.URL+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", contentTypeSCIM)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+	raw, err := io.ReadAll(res.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if out != nil && len(raw) > 0 {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return res.StatusCode, raw, err
+		}
+	}
+	return res.StatusCode, raw, nil
+}
+
+// listedUsers decodes the users of a ListResponse
+type listedUsers struct {
+	TotalResults int64           `json:"totalResults"`
+	StartIndex   int             `json:"startIndex"`
+	ItemsPerPage int             `json:"itemsPerPage"`
+	Resources    []*userResource `json:"Resources"`
+}
+
+func TestSCIMAuth(t *testing.T) {
+	ts, err := newTestSCIM()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	defer ts.Close()
+
+	otherToken, err := ts.store.NewSCIMTokenService().Create(context.Background(), "11111111-1111-1111-1111-111111111111", "other tenant")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	revokedToken, err := ts.store.NewSCIMTokenService().Create(context.Background(), db.DefaultTenantID, "revoked")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	tokens, err := ts.store.NewSCIMTokenService().GetTenantTokens(context.Background(), db.DefaultTenantID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	for _, tok := range tokens {
+		if tok.Description == "revoked" {
+			if err := ts.store.NewSCIMTokenService().Revoke(context.Background(), db.DefaultTenantID, tok.ID); err != nil {
+				t.Log(err)
+				t.Fail()
+				return
+			}
+		}
+	}
+
+	cases := []struct {
+		name   string
+		path   string
+		token  string
+		header string
+		status int
+	}{
+		{name: "no token", path: usersPath, status: http.StatusUnauthorized},
+		{name: "basic auth", path: usersPath, header: "Basic " + ts.token, status: http.StatusUnauthorized},
+		{name: "empty bearer", path: usersPath, header: "Bearer  ", status: http.StatusUnauthorized},
+		{name: "unknown token", path: usersPath, token: "not-a-token", status: http.StatusUnauthorized},
+		{name: "revoked token", path: usersPath, token: revokedToken, status: http.StatusUnauthorized},
+		{name: "valid token", path: usersPath, token: ts.token, status: http.StatusOK},
+		{name: "lower case scheme", path: usersPath, header: "bearer " + ts.token, status: http.StatusOK},
+		{name: "other tenant's user", path: usersPath + "/" + testPatID, token: otherToken, status: http.StatusNotFound},
+		{name: "own tenant's user", path: usersPath + "/" + testPatID, token: ts.token, status: http.StatusOK},
+		{name: "groups need a token", path: groupsPath, status: http.StatusUnauthorized},
+		{name: "discovery needs no token", path: "/scim/v2/ServiceProviderConfig", status: http.StatusOK},
+	}
+	for _, c := range cases {
+		req, err := http.NewRequest(http.MethodGet, ts.server.URL+c.path, nil)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		switch {
+		case c.header != "":
+			req.Header.Set("Authorization", c.header)
+		case c.token != "":
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode != c.status {
+			t.Logf("%s: expected status %d, but got %d", c.name, c.status, res.StatusCode)
+			t.Fail()
+		}
+	}
+
+	// the other tenant's token only lists the other tenant's users
+	list := &listedUsers{}
+	if _, _, err := ts.do(http.MethodGet, usersPath, otherToken, nil, list); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if list.TotalResults != 0 || len(list.Resources) != 0 {
+		t.Logf("expected the other tenant to have no users, but got %d", list.TotalResults)
+		t.Fail()
+	}
+}
+
+func TestSCIMListUsersFilters(t *testing.T) {
+	testData, _, _, err := jsonparser.Get(fixtures.Data["scim"], "TestSCIMListUsersFilters")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	cases := []struct {
+		Filter string   `json:"filter"`
+		Emails []string `json:"emails"`
+		Count  int      `json:"count"`
+	}{}
+	if err := json.Unmarshal(testData, &cases); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	ts, err := newTestSCIM()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	defer ts.Close()
+
+	for _, c := range cases {
+		list := &listedUsers{}
+		status, raw, err := ts.do(http.MethodGet, usersPath+"?filter="+url.QueryEscape(c.Filter), ts.token, nil, list)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if status != http.StatusOK {
+			t.Logf("%s: expected status 200, but got %d %s", c.Filter, status, raw)
+			t.Fail()
+			continue
+		}
+
+		emails := []string{}
+		for _, u := range list.Resources {
+			emails = append(emails, u.UserName)
+		}
+		want := c.Emails
+		if want == nil {
+			if len(emails) != c.Count || list.TotalResults != int64(c.Count) {
+				t.Logf("%s: expected %d users, but got %d of %d", c.Filter, c.Count, len(emails), list.TotalResults)
+				t.Fail()
+			}
+			continue
+		}
+		sort.Strings(emails)
+		sort.Strings(want)
+		if strings.Join(emails, ",") != strings.Join(want, ",") {
+			t.Logf("%s: expected %v, but got %v", c.Filter, want, emails)
+			t.Fail()
+		}
+	}
+}
+
+func TestSCIMListUsersInvalidFilters(t *testing.T) {
+	testData, _, _, err := jsonparser.Get(fixtures.Data["scim"], "TestSCIMListUsersInvalidFilters")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	filters := []string{}
+	if err := json.Unmarshal(testData, &filters); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	ts, err := newTestSCIM()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	defer ts.Close()
+
+	for _, filter := range filters {
+		res := &scimError{}
+		status, _, err := ts.do(http.MethodGet, usersPath+"?filter="+url.QueryEscape(filter), ts.token, nil, res)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if status != http.StatusBadRequest || res.SCIMType != scimTypeInvalidFilter {
+			t.Logf("%s: expected an invalidFilter error, but got %d %q", filter, status, res.SCIMType)
+			t.Fail()
+		}
+	}
+}
+
+func TestSCIMListUsersPaging(t *testing.T) {
+	testData, _, _, err := jsonparser.Get(fixtures.Data["scim"], "TestSCIMListUsersPaging")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	cases := []struct {
+		Query      string   `json:"query"`
+		StartIndex int      `json:"start_index"`
+		Items      []string `json:"items"`
+	}{}
+	if err := json.Unmarshal(testData, &cases); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	ts, err := newTestSCIM()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	defer ts.Close()
+
+	for _, c := range cases {
+		list := &listedUsers{}
+		status, raw, err := ts.do(http.MethodGet, usersPath+"?"+c.Query, ts.token, nil, list)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if status != http.StatusOK {
+			t.Logf("%s: expected status 200, but got %d %s", c.Query, status, raw)
+			t.Fail()
+			continue
+		}
+
+		names := []string{}
+		for _, u := range list.Resources {
+			names = append(names, u.DisplayName)
+		}
+		if list.TotalResults != 10 || list.StartIndex != c.StartIndex || list.ItemsPerPage != len(c.Items) ||
+			strings.Join(names, ",") != strings.Join(c.Items, ",") {
+			t.Logf("%s: expected startIndex %d and %v of 10, but got startIndex %d, itemsPerPage %d and %v of %d",
+				c.Query, c.StartIndex, c.Items, list.StartIndex, list.ItemsPerPage, names, list.TotalResults)
+			t.Fail()
+		}
+	}
+}
+
+func TestSCIMCreateUser(t *testing.T) {
+	ts, err := newTestSCIM()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	defer ts.Close()
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["scim"], "TestSCIMCreateUser")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	created := &userResource{}
+	status, raw, err := ts.do(http.MethodPost, usersPath, ts.token, testData, created)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if status != http.StatusCreated || created.ID == "" {
+		t.Logf("expected the user to be created, but got %d %s", status, raw)
+		t.Fail()
+		return
+	}
+
+	person, err := ts.store.NewPersonService().GetByID(context.Background(), created.ID, db.DefaultTenantID)
+	if err != nil || person == nil {
+		t.Logf("expected the created person to be saved, but got %v", err)
+		t.Fail()
+		return
+	}
+	if !strings.EqualFold(person.Email.String, "jordan.lee@canopy.io") || person.Name.String != "Jordan Lee" || person.ManagerID.String != testPatID ||
+		person.Status != statusActive || !person.IsProvisioned || person.CreatedBy != db.DefaultSCIMSyncID {
+		t.Logf("expected an active, provisioned Jordan Lee managed by Pat and created by scim, but got %s %s %s %s %v %s",
+			person.Email.String, person.Name.String, person.ManagerID.String, person.Status, person.IsProvisioned, person.CreatedBy)
+		t.Fail()
+	}
+
+	if _, err := ts.handlers.DispatchOutbox(context.Background()); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if emails := ts.fakes.Identity.ProvisionedEmails(); len(emails) != 1 || !strings.EqualFold(emails[0], "jordan.lee@canopy.io") {
+		t.Logf("expected the created user to be provisioned, but got %v", emails)
+		t.Fail()
+	}
+
+	if err := fixtures.WriteTestResult("../../../fixtures/results/TestSCIMCreateUser.json", raw); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+}
+
+func TestSCIMCreateUserInactive(t *testing.T) {
+	ts, err := newTestSCIM()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	defer ts.Close()
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["scim"], "TestSCIMCreateUserInactive")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	created := &userResource{}
+	status, raw, err := ts.do(http.MethodPost, usersPath, ts.token, testData, created)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if status != http.StatusCreated || created.Active == nil || *created.Active {
+		t.Logf("expected the user to be created inactive, but got %d %s", status, raw)
+		t.Fail()
+		return
+	}
+
+	person, err := ts.store.NewPersonService().GetByID(context.Background(), created.ID, db.DefaultTenantID)
+	if err != nil || person == nil {
+		t.Logf("expected the created person to be saved, but got %v", err)
+		t.Fail()
+		return
+	}
+	if person.Status != statusInactive || person.IsProvisioned {
+		t.Logf("expected an inactive, unprovisioned person, but got %s %v", person.Status, person.IsProvisioned)
+		t.Fail()
+	}
+}
+
+func TestSCIMCreateUserDuplicate(t *testing.T) {
+	ts, err := newTestSCIM()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	defer ts.Close()
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["scim"], "TestSCIMCreateUserDuplicate")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	res := &scimError{}
+	status, _, err := ts.do(http.MethodPost, usersPath, ts.token, testData, res)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if status != http.StatusConflict || res.SCIMType != scimTypeUniqueness {
+		t.Logf("expected a uniqueness conflict, but got %d %q", status, res.SCIMType)
+		t.Fail()
+	}
+
+	// missing userName is a bad request
+	status, _, err = ts.do(http.MethodPost, usersPath, ts.token, []byte(`{"schemas":[],"displayName":"No Email"}`), res)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if status != http.StatusBadRequest || res.SCIMType != scimTypeInvalidValue {
+		t.Logf("expected a user without a userName to be an invalidValue error, but got %d %q", status, res.SCIMType)
+		t.Fail()
+	}
+}
+
+func TestSCIMPatchUser(t *testing.T) {
+	ts, err := newTestSCIM()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	defer ts.Close()
+
+	personID, err := jsonparser.GetString(fixtures.Data["scim"], "TestSCIMPatchUser", "person_id")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	patch, _, _, err := jsonparser.Get(fixtures.Data["scim"], "TestSCIMPatchUser", "patch")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// add and replace, with and without a path
+	updated := &userResource{}
+	status, raw, err := ts.do(http.MethodPatch, usersPath+"/"+personID, ts.token, patch, updated)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if status != http.StatusOK {
+		t.Logf("expected the patch to succeed, but got %d %s", status, raw)
+		t.Fail()
+		return
+	}
+	person, err := ts.store.NewPersonService().GetByID(context.Background(), personID, db.DefaultTenantID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if person.Name.String != "Daniel Langfield" || person.FirstName.String != "Daniel" || person.Email.String != "daniel@canopy.io" ||
+		person.ManagerID.String != testPatID || person.Status != statusInactive || person.IsProvisioned || person.UpdatedBy != db.DefaultSCIMSyncID {
+		t.Logf("expected the patched name, email, manager and inactive status, but got %s %s %s %s %s %v %s", person.Name.String,
+			person.FirstName.String, person.Email.String, person.ManagerID.String, person.Status, person.IsProvisioned, person.UpdatedBy)
+		t.Fail()
+	}
+
+	if _, err := ts.handlers.DispatchOutbox(context.Background()); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	busted := map[string]bool{}
+	for _, id := range ts.fakes.Bouncer.BustedUserIDs(db.DefaultTenantID) {
+		busted[id] = true
+	}
+	if !busted[personID] {
+		t.Logf("expected the deactivated person's auth cache to be busted, but got %v", busted)
+		t.Fail()
+	}
+
+	// remove
+	remove, _, _, err := jsonparser.Get(fixtures.Data["scim"], "TestSCIMPatchUser", "remove")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	updated = &userResource{}
+	status, raw, err = ts.do(http.MethodPatch, usersPath+"/"+personID, ts.token, remove, updated)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if status != http.StatusOK || updated.Enterprise != nil || updated.Name.GivenName != "" {
+		t.Logf("expected the manager and given name to be removed, but got %d %s", status, raw)
+		t.Fail()
+	}
+
+	// every bad op leaves the person alone
+	badRaw, _, _, err := jsonparser.Get(fixtures.Data["scim"], "TestSCIMPatchUser", "bad")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	bad := []json.RawMessage{}
+	if err := json.Unmarshal(badRaw, &bad); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	for _, op := range bad {
+		res := &scimError{}
+		status, _, err := ts.do(http.MethodPatch, usersPath+"/"+personID, ts.token, []byte(op), res)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if status != http.StatusBadRequest {
+			t.Logf("%s: expected a bad request, but got %d", op, status)
+			t.Fail()
+		}
+	}
+	after, err := ts.store.NewPersonService().GetByID(context.Background(), personID, db.DefaultTenantID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if after.Email.String != "daniel@canopy.io" || after.Name.String != "Daniel Langfield" {
+		t.Logf("expected bad patches not to change the person, but got %s %s", after.Email.String, after.Name.String)
+		t.Fail()
+	}
+
+	status, _, err = ts.do(http.MethodPatch, usersPath+"/00000000-0000-0000-0000-0000000000ff", ts.token, patch, nil)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if status != http.StatusNotFound {
+		t.Logf("expected patching an unknown user to be a 404, but got %d", status)
+		t.Fail()
+	}
+}
+
+// groupMemberIDs returns the ids of the people in the group, sorted
+func (ts *testSCIM) groupMemberIDs(groupID string) ([]string, error) {
+	people, err := ts.store.NewPersonService().GetPeopleByGroupId(context.Background(), db.DefaultTenantID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, p := range people {
+		ids = append(ids, p.ID)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func TestSCIMPatchGroup(t *testing.T) {
+	ts, err := newTestSCIM()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	defer ts.Close()
+
+	groupID, err := jsonparser.GetString(fixtures.Data["scim"], "TestSCIMPatchGroup", "group_id")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	patch := func(name string, wantStatus int) bool {
+		body, _, _, err := jsonparser.Get(fixtures.Data["scim"], "TestSCIMPatchGroup", name)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return false
+		}
+		status, raw, err := ts.do(http.MethodPatch, groupsPath+"/"+groupID, ts.token, body, nil)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return false
+		}
+		if status != wantStatus {
+			t.Logf("%s: expected status %d, but got %d %s", name, wantStatus, status, raw)
+			t.Fail()
+			return false
+		}
+		return true
+	}
+	expectMembers := func(name string, want ...string) {
+		got, err := ts.groupMemberIDs(groupID)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		sort.Strings(want)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Logf("%s: expected members %v, but got %v", name, want, got)
+			t.Fail()
+		}
+	}
+
+	// add moves Alex and Dino out of their groups, Grant stays
+	if !patch("add", http.StatusOK) {
+		return
+	}
+	expectMembers("add", testGrantID, testAlexID, testDinoID)
+	alex, err := ts.store.NewPersonService().GetByID(context.Background(), testAlexID, db.DefaultTenantID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if alex.IsSynced || alex.UpdatedBy != db.DefaultSCIMSyncID {
+		t.Logf("expected a moved member to be marked as not synced by scim, but got %v %s", alex.IsSynced, alex.UpdatedBy)
+		t.Fail()
+	}
+	if _, err := ts.handlers.DispatchOutbox(context.Background()); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	busted := ts.fakes.Bouncer.BustedUserIDs(db.DefaultTenantID)
+	sort.Strings(busted)
+	if want := []string{testAlexID, testDinoID}; strings.Join(busted, ",") != strings.Join(want, ",") {
+		t.Logf("expected only the moved members to be busted, but got %v", busted)
+		t.Fail()
+	}
+
+	// Okta's value path remove
+	if !patch("remove", http.StatusOK) {
+		return
+	}
+	expectMembers("remove", testGrantID, testDinoID)
+
+	// replace drops everyone not listed
+	if !patch("replace", http.StatusOK) {
+		return
+	}
+	expectMembers("replace", testDinoID, testWillID)
+
+	// an unknown member fails the whole patch before anyone moves
+	if !patch("unknown_member", http.StatusBadRequest) {
+		return
+	}
+	expectMembers("unknown_member", testDinoID, testWillID)
+
+	if !patch("rename", http.StatusBadRequest) {
+		return
+	}
+}
+
+func TestSCIMListGroupsPaging(t *testing.T) {
+	ts, err := newTestSCIM()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	defer ts.Close()
+
+	list := &listResponse{}
+	status, raw, err := ts.do(http.MethodGet, groupsPath+"?startIndex=10&count=5", ts.token, nil, list)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if status != http.StatusOK || list.TotalResults != 11 || list.StartIndex != 10 || list.ItemsPerPage != 2 || len(list.Resources) != 2 {
+		t.Logf("expected the last 2 of 11 groups from startIndex 10, but got %d %s", status, raw)
+		t.Fail()
+	}
+
+	status, raw, err = ts.do(http.MethodGet, groupsPath+"?filter="+url.QueryEscape(`displayName sw "cs"`), ts.token, nil, list)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if status != http.StatusOK || list.TotalResults != 2 {
+		t.Logf("expected CS and CS Managers, but got %d %s", status, raw)
+		t.Fail()
+	}
+
+	if err := fixtures.WriteTestResult("../../../fixtures/results/TestSCIMListGroupsPaging.json", raw); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+}
@@ -0,0 +1,624 @@
+package scim
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/models"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+	null "github.com/volatiletech/null/v8"
+)
+
+const (
+	usersPath      = "/scim/v2/Users"
+	statusActive   = "active"
+	statusInactive = "inactive"
+)
+
+type userName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type userEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type managerRef struct {
+	Value       string `json:"value,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+type enterpriseUser struct {
+	Manager *managerRef `json:"manager,omitempty"`
+}
+
+type groupRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+type userResource struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id,omitempty"`
+	ExternalID  string          `json:"externalId,omitempty"`
+	UserName    string          `json:"userName"`
+	Name        *userName       `json:"name,omitempty"`
+	DisplayName string          `json:"displayName,omitempty"`
+	Emails      []userEmail     `json:"emails,omitempty"`
+	Active      *bool           `json:"active,omitempty"`
+	Enterprise  *enterpriseUser `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User,omitempty"`
+	Groups      []groupRef      `json:"groups,omitempty"`
+	Meta        *meta           `json:"meta,omitempty"`
+}
+
+// primaryEmail returns the email orchard keys people on, userName wins over the emails list
+func (u *userResource) primaryEmail() string {
+	if strings.Contains(u.UserName, "@") {
+		return strings.TrimSpace(u.UserName)
+	}
+	for _, email := range u.Emails {
+		if email.Primary {
+			return strings.TrimSpace(email.Value)
+		}
+	}
+	if len(u.Emails) > 0 {
+		return strings.TrimSpace(u.Emails[0].Value)
+	}
+	return strings.TrimSpace(u.UserName)
+}
+
+func toUserResource(p *models.Person) *userResource {
+	active := p.Status == statusActive
+	u := &userResource{
+		Schemas:     []string{SchemaUser},
+		ID:          p.ID,
+		UserName:    p.Email.String,
+		DisplayName: p.Name.String,
+		Name: &userName{
+			Formatted:  p.Name.String,
+			GivenName:  p.FirstName.String,
+			FamilyName: p.LastName.String,
+		},
+		Active: &active,
+	}
+	if p.Email.String != "" {
+		u.Emails = []userEmail{{Value: p.Email.String, Type: "work", Primary: true}}
+	}
+	if p.ManagerID.String != "" {
+		u.Schemas = append(u.Schemas, SchemaEnterpriseUser)
+		u.Enterprise = &enterpriseUser{Manager: &managerRef{Value: p.ManagerID.String}}
+	}
+	if p.GroupID.String != "" {
+		u.Groups = []groupRef{{Value: p.GroupID.String}}
+	}
+	m := newMeta("User", fmt.Sprintf("%s/%s", usersPath, p.ID), p.CreatedAt, p.UpdatedAt)
+	u.Meta = &m
+	return u
+}
+
+// applyUserResource copies the writable attributes of a SCIM user onto the person record, used by POST and PUT
+func applyUserResource(p *models.Person, u *userResource) {
+	email := u.primaryEmail()
+	p.Email = null.NewString(email, email != "")
+
+	firstName, lastName, formatted := "", "", ""
+	if u.Name != nil {
+		firstName, lastName, formatted = u.Name.GivenName, u.Name.FamilyName, u.Name.Formatted
+	}
+	p.FirstName = null.NewString(firstName, firstName != "")
+	p.LastName = null.NewString(lastName, lastName != "")
+	p.Name = null.NewString(displayName(u.DisplayName, formatted, firstName, lastName), true)
+
+	managerID := ""
+	if u.Enterprise != nil && u.Enterprise.Manager != nil {
+		managerID = u.Enterprise.Manager.Value
+	}
+	p.ManagerID = null.NewString(managerID, managerID != "")
+
+	setActive(p, u.Active == nil || *u.Active)
+}
+
+// displayName picks the person's full name, falling back to their first and last name
+func displayName(display, formatted, givenName, familyName string) string {
+	if strings.TrimSpace(display) != "" {
+		return strings.TrimSpace(display)
+	}
+	if strings.TrimSpace(formatted) != "" {
+		return strings.TrimSpace(formatted)
+	}
+	return strings.TrimSpace(givenName + " " + familyName)
+}
+
+// setActive maps SCIM active onto orchard's status, inactive people are also unprovisioned from auth0
+func setActive(p *models.Person, active bool) {
+	p.IsProvisioned = active
+	p.Status = statusInactive
+	if active {
+		p.Status = statusActive
+	}
+}
+
+var userFilterFields = map[string]string{
+	"id":              "id",
+	"username":        "email",
+	"emails":          "email",
+	"emails.value":    "email",
+	"displayname":     "name",
+	"name.formatted":  "name",
+	"name.givenname":  "first_name",
+	"name.familyname": "last_name",
+	"active":          "status",
+	strings.ToLower(SchemaEnterpriseUser + ":manager.value"): "manager_id",
+	"meta.created":      "created_at",
+	"meta.lastmodified": "updated_at",
+}
+
+// userFilters translates a parsed SCIM filter into person search filters
+func userFilters(clauses []filterClause) ([]db.PersonFilter, error) {
+	filters := []db.PersonFilter{}
+	for _, clause := range clauses {
+		field, ok := userFilterFields[strings.ToLower(clause.Attr)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported filter attribute %q", clause.Attr)
+		}
+
+		if field == "status" {
+			active, ok := clause.Value.(bool)
+			if !ok || (clause.Op != "eq" && clause.Op != "ne") {
+				return nil, fmt.Errorf("active can only be compared with eq or ne and a boolean")
+			}
+			op := "EQ"
+			if active == (clause.Op == "ne") {
+				op = "NEQ"
+			}
+			filters = append(filters, db.PersonFilter{Field: field, Op: op, Values: []interface{}{statusActive}})
+			continue
+		}
+
+		if clause.Op == "pr" {
+			filters = append(filters, db.PersonFilter{Field: field, Op: "NEQ", Values: []interface{}{""}})
+			continue
+		}
+
+		value := clause.Value
+		if f, ok := value.(float64); ok {
+			value = fmt.Sprintf("%v", f)
+		}
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid value for filter attribute %q", clause.Attr)
+		}
+		if field == "created_at" || field == "updated_at" {
+			t, err := time.Parse(time.RFC3339, str)
+			if err != nil {
+				return nil, fmt.Errorf("invalid date for filter attribute %q", clause.Attr)
+			}
+			value = t
+		}
+
+		switch clause.Op {
+		case "eq":
+			if field == "id" || field == "manager_id" || field == "created_at" || field == "updated_at" {
+				filters = append(filters, db.PersonFilter{Field: field, Op: "EQ", Values: []interface{}{value}})
+			} else {
+				// string attributes are case insensitive in SCIM
+				filters = append(filters, db.PersonFilter{Field: field, Op: "ILIKE", Values: []interface{}{escapeLike(str)}})
+			}
+		case "ne":
+			filters = append(filters, db.PersonFilter{Field: field, Op: "NEQ", Values: []interface{}{value}})
+		case "co":
+			filters = append(filters, db.PersonFilter{Field: field, Op: "ILIKE", Values: []interface{}{"%" + escapeLike(str) + "%"}})
+		case "sw":
+			filters = append(filters, db.PersonFilter{Field: field, Op: "ILIKE", Values: []interface{}{escapeLike(str) + "%"}})
+		case "ew":
+			filters = append(filters, db.PersonFilter{Field: field, Op: "ILIKE", Values: []interface{}{"%" + escapeLike(str)}})
+		case "gt":
+			filters = append(filters, db.PersonFilter{Field: field, Op: "GT", Values: []interface{}{value}})
+		case "ge":
+			filters = append(filters, db.PersonFilter{Field: field, Op: "GTE", Values: []interface{}{value}})
+		case "lt":
+			filters = append(filters, db.PersonFilter{Field: field, Op: "LT", Values: []interface{}{value}})
+		case "le":
+			filters = append(filters, db.PersonFilter{Field: field, Op: "LTE", Values: []interface{}{value}})
+		}
+	}
+	if err := db.ValidateFilter(db.Filter{And: filters}, db.PersonFilterColumns); len(filters) > 0 && err != nil {
+		return nil, fmt.Errorf("unsupported filter: %s", err)
+	}
+	return filters, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (server *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	id := resourceID(r, usersPath)
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		server.listUsers(w, r)
+	case r.Method == http.MethodGet:
+		server.getUser(w, r, id)
+	case r.Method == http.MethodPost && id == "":
+		server.createUser(w, r)
+	case r.Method == http.MethodPut && id != "":
+		server.replaceUser(w, r, id)
+	case r.Method == http.MethodPatch && id != "":
+		server.patchUser(w, r, id)
+	case r.Method == http.MethodDelete && id != "":
+		server.deleteUser(w, r, id)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+	}
+}
+
+func (server *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID := getTenantID(ctx)
+	logger := log.WithContext(ctx).WithTenantID(tenantID)
+
+	clauses, err := parseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, scimTypeInvalidFilter, err.Error())
+		return
+	}
+	filters, err := userFilters(clauses)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, scimTypeInvalidFilter, err.Error())
+		return
+	}
+
+	startIndex, limit, offset := getPage(r)
+	people, total, err := server.db.NewPersonService().Search(ctx, tenantID, "", limit, offset, filters...)
+	if err != nil {
+		logger.Error(errors.Wrap(err, "error searching people for scim"))
+		writeError(w, http.StatusInternalServerError, "", "error listing users")
+		return
+	}
+
+	resources := make([]interface{}, len(people))
+	for i, p := range people {
+		resources[i] = toUserResource(p)
+	}
+
+	writeJSON(w, http.StatusOK, &listResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// getPerson returns the person, writing a 404 and returning nil if they don't exist in the tenant
+func (server *Server) getPerson(ctx context.Context, w http.ResponseWriter, id string) *models.Person {
+	tenantID := getTenantID(ctx)
+	person, err := server.db.NewPersonService().GetByID(ctx, id, tenantID)
+	if err == sql.ErrNoRows || (err == nil && person == nil) {
+		writeError(w, http.StatusNotFound, "", fmt.Sprintf("user %s not found", id))
+		return nil
+	}
+	if err != nil {
+		log.WithContext(ctx).WithTenantID(tenantID).WithCustom("personId", id).Error(errors.Wrap(err, "error getting person for scim"))
+		writeError(w, http.StatusInternalServerError, "", "error getting user")
+		return nil
+	}
+	return person
+}
+
+func (server *Server) getUser(w http.ResponseWriter, r *http.Request, id string) {
+	person := server.getPerson(r.Context(), w, id)
+	if person == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, toUserResource(person))
+}
+
+func (server *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tenantID := getTenantID(ctx)
+
+	u := &userResource{}
+	if err := readJSON(r, u); err != nil {
+		writeError(w, http.StatusBadRequest, scimTypeInvalidSyntax, "invalid user resource")
+		return
+	}
+	if u.primaryEmail() == "" {
+		writeError(w, http.StatusBadRequest, scimTypeInvalidValue, "userName can't be empty")
+		return
+	}
+
+	person := &models.Person{TenantID: tenantID}
+	applyUserResource(person, u)
+	if !server.validManager(ctx, w, person) {
+		return
+	}
+
+	pb, err := server.db.NewPersonService().ToProto(person)
+	if err != nil {
+		log.WithContext(ctx).WithTenantID(tenantID).Error(errors.Wrap(err, "error converting person db model to proto"))
+		writeError(w, http.StatusInternalServerError, "", "error creating user")
+		return
+	}
+	pb.CreatedBy = db.DefaultSCIMSyncID
+	pb.UpdatedBy = db.DefaultSCIMSyncID
+
+	// CreatePerson checks the email is free, provisions the person and applies the tenant's role assignment rules
+	res, err := server.handlers.CreatePerson(ctx, &servicePb.CreatePersonRequest{TenantId: tenantID, Person: pb})
+	if err != nil {
+		writeHandlerError(w, err, "error creating user")
+		return
+	}
+
+	// CreatePerson always creates active people, an IdP can create them inactive
+	person.ID = res.Person.Id
+	if !person.IsProvisioned {
+		if err := server.updatePerson(ctx, person); err != nil {
+			writeHandlerError(w, err, "error creating user")
+			return
+		}
+	}
+
+	created := server.getPerson(ctx, w, person.ID)
+	if created == nil {
+		return
+	}
+	writeJSON(w, http.StatusCreated, toUserResource(created))
+}
+
+func (server *Server) replaceUser(w http.ResponseWriter, r *http.Request, id string) {
+	ctx := r.Context()
+
+	u := &userResource{}
+	if err := readJSON(r, u); err != nil {
+		writeError(w, http.StatusBadRequest, scimTypeInvalidSyntax, "invalid user resource")
+		return
+	}
+	if u.primaryEmail() == "" {
+		writeError(w, http.StatusBadRequest, scimTypeInvalidValue, "userName can't be empty")
+		return
+	}
+
+	person := server.getPerson(ctx, w, id)
+	if person == nil {
+		return
+	}
+	previousEmail := person.Email.String
+	applyUserResource(person, u)
+	server.updateUser(ctx, w, person, previousEmail)
+}
+
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type patchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []patchOperation `json:"Operations"`
+}
+
+func (server *Server) patchUser(w http.ResponseWriter, r *http.Request, id string) {
+	ctx := r.Context()
+
+	patch := &patchRequest{}
+	if err := readJSON(r, patch); err != nil {
+		writeError(w, http.StatusBadRequest, scimTypeInvalidSyntax, "invalid patch request")
+		return
+	}
+
+	person := server.getPerson(ctx, w, id)
+	if person == nil {
+		return
+	}
+	previousEmail := person.Email.String
+
+	for _, op := range patch.Operations {
+		if err := applyUserPatch(person, op); err != nil {
+			writeError(w, http.StatusBadRequest, scimTypeInvalidPath, err.Error())
+			return
+		}
+	}
+	if person.Email.String == "" {
+		writeError(w, http.StatusBadRequest, scimTypeInvalidValue, "userName can't be removed")
+		return
+	}
+
+	server.updateUser(ctx, w, person, previousEmail)
+}
+
+// applyUserPatch applies a single add/replace/remove operation. Operations without a path carry a partial user resource as the value.
+func applyUserPatch(p *models.Person, op patchOperation) error {
+	opType := strings.ToLower(op.Op)
+	if opType != "add" && opType != "replace" && opType != "remove" {
+		return fmt.Errorf("unsupported patch op %q", op.Op)
+	}
+
+	if op.Path == "" {
+		if opType == "remove" {
+			return fmt.Errorf("remove requires a path")
+		}
+		values := map[string]json.RawMessage{}
+		if err := json.Unmarshal(op.Value, &values); err != nil {
+			return fmt.Errorf("patch value must be an object when no path is given")
+		}
+		for path, value := range values {
+			if err := applyUserPatch(p, patchOperation{Op: op.Op, Path: path, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var str string
+	if opType != "remove" {
+		// most attributes are strings, complex values are decoded per path below
+		_ = json.Unmarshal(op.Value, &str)
+	}
+
+	switch strings.ToLower(op.Path) {
+	case "active":
+		active := false
+		if opType != "remove" {
+			if err := json.Unmarshal(op.Value, &active); err != nil {
+				// Azure AD sends active as the string "True"/"False"
+				active = strings.EqualFold(str, "true")
+			}
+		}
+		setActive(p, active)
+	case "username", "emails", `emails[type eq "work"].value`, "emails.value":
+		if strings.ToLower(op.Path) == "emails" && opType != "remove" {
+			emails := []userEmail{}
+			if err := json.Unmarshal(op.Value, &emails); err != nil {
+				return fmt.Errorf("invalid value for emails")
+			}
+			str = (&userResource{Emails: emails}).primaryEmail()
+		}
+		str = strings.TrimSpace(str)
+		p.Email = null.NewString(str, str != "")
+	case "displayname", "name.formatted":
+		p.Name = null.NewString(str, str != "")
+	case "name.givenname":
+		p.FirstName = null.NewString(str, str != "")
+	case "name.familyname":
+		p.LastName = null.NewString(str, str != "")
+	case "name":
+		name := userName{}
+		if opType != "remove" {
+			if err := json.Unmarshal(op.Value, &name); err != nil {
+				return fmt.Errorf("invalid value for name")
+			}
+		}
+		p.FirstName = null.NewString(name.GivenName, name.GivenName != "")
+		p.LastName = null.NewString(name.FamilyName, name.FamilyName != "")
+		if n := displayName(name.Formatted, "", name.GivenName, name.FamilyName); n != "" {
+			p.Name = null.NewString(n, true)
+		}
+	case strings.ToLower(SchemaEnterpriseUser + ":manager"), strings.ToLower(SchemaEnterpriseUser + ":manager.value"):
+		managerID := str
+		if opType != "remove" && managerID == "" {
+			manager := managerRef{}
+			if err := json.Unmarshal(op.Value, &manager); err != nil {
+				return fmt.Errorf("invalid value for manager")
+			}
+			managerID = manager.Value
+		}
+		if opType == "remove" {
+			managerID = ""
+		}
+		p.ManagerID = null.NewString(managerID, managerID != "")
+	case "externalid":
+		// orchard doesn't store the IdP's external id, ignore it rather than failing the whole patch
+	default:
+		return fmt.Errorf("unsupported patch path %q", op.Path)
+	}
+
+	return nil
+}
+
+var scimPersonUpdateWhitelist = []string{"name", "first_name", "last_name", "email", "manager_id", "is_provisioned", "status"}
+
+// updateUser saves a person changed through PUT or PATCH and responds with the updated resource
+func (server *Server) updateUser(ctx context.Context, w http.ResponseWriter, person *models.Person, previousEmail string) {
+	tenantID := getTenantID(ctx)
+
+	if !strings.EqualFold(previousEmail, person.Email.String) {
+		existing, err := server.db.NewPersonService().GetByEmail(ctx, tenantID, person.Email.String)
+		if err != nil {
+			log.WithContext(ctx).WithTenantID(tenantID).Error(errors.Wrap(err, "error checking for existing person by email"))
+			writeError(w, http.StatusInternalServerError, "", "error updating user")
+			return
+		}
+		if existing != nil && existing.ID != person.ID {
+			writeError(w, http.StatusConflict, scimTypeUniqueness, "a user with the given userName already exists")
+			return
+		}
+	}
+	if !server.validManager(ctx, w, person) {
+		return
+	}
+
+	if err := server.updatePerson(ctx, person); err != nil {
+		writeHandlerError(w, err, "error updating user")
+		return
+	}
+
+	updated := server.getPerson(ctx, w, person.ID)
+	if updated == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, toUserResource(updated))
+}
+
+// updatePerson saves the SCIM attributes of a person with UpdatePerson, which provisions them under their new email, re-provisions
+// the email they stopped using and busts their auth cache in the same transaction as the update
+func (server *Server) updatePerson(ctx context.Context, person *models.Person) error {
+	pb, err := server.db.NewPersonService().ToProto(person)
+	if err != nil {
+		err = errors.Wrap(err, "error converting person db model to proto")
+		log.WithContext(ctx).WithTenantID(person.TenantID).WithCustom("personId", person.ID).Error(err)
+		return err
+	}
+	pb.UpdatedBy = db.DefaultSCIMSyncID
+
+	_, err = server.handlers.UpdatePerson(ctx, &servicePb.UpdatePersonRequest{
+		TenantId:   person.TenantID,
+		PersonId:   person.ID,
+		Person:     pb,
+		OnlyFields: append([]string{}, scimPersonUpdateWhitelist...),
+	})
+	return err
+}
+
+// validManager checks the enterprise manager reference points at a person in the same tenant
+func (server *Server) validManager(ctx context.Context, w http.ResponseWriter, person *models.Person) bool {
+	if person.ManagerID.String == "" {
+		return true
+	}
+	if person.ManagerID.String == person.ID {
+		writeError(w, http.StatusBadRequest, scimTypeInvalidValue, "a user can't be their own manager")
+		return false
+	}
+	manager, err := server.db.NewPersonService().GetByID(ctx, person.ManagerID.String, person.TenantID)
+	if err == sql.ErrNoRows || (err == nil && manager == nil) {
+		writeError(w, http.StatusBadRequest, scimTypeInvalidValue, fmt.Sprintf("manager %s not found", person.ManagerID.String))
+		return false
+	}
+	if err != nil {
+		log.WithContext(ctx).WithTenantID(person.TenantID).Error(errors.Wrap(err, "error getting manager for scim"))
+		writeError(w, http.StatusInternalServerError, "", "error checking manager")
+		return false
+	}
+	return true
+}
+
+// deleteUser soft deletes the person and unprovisions them, orchard never hard deletes people on behalf of an IdP
+func (server *Server) deleteUser(w http.ResponseWriter, r *http.Request, id string) {
+	ctx := r.Context()
+
+	person := server.getPerson(ctx, w, id)
+	if person == nil {
+		return
+	}
+
+	setActive(person, false)
+	if err := server.updatePerson(ctx, person); err != nil {
+		writeHandlerError(w, err, "error deleting user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
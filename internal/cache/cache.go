@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/config"
+	"google.golang.org/protobuf/proto"
+)
+
+const keyPrefix = "orchard:hierarchy"
+
+// Backend stores cached responses and the per-tenant generation counters. Get returns nil for a missing key.
+type Backend interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	GetInt(ctx context.Context, key string) (int64, error)
+	Incr(ctx context.Context, key string) (int64, error)
+}
+
+// HierarchyCache is a read-through cache of hierarchy reads. Responses are keyed by tenant, the tenant's generation,
+// the RPC and its request, so bumping the generation with Invalidate orphans every response cached for the tenant and
+// they age out with the ttl. A nil HierarchyCache caches nothing.
+type HierarchyCache struct {
+	backend Backend
+	ttl     time.Duration
+}
+
+func New(backend Backend, ttl time.Duration) *HierarchyCache {
+	return &HierarchyCache{
+		backend: backend,
+		ttl:     ttl,
+	}
+}
+
+// NewFromConfig returns the cache selected by HIERARCHY_CACHE, which is nil when caching is off
+func NewFromConfig(cfg config.Config) (*HierarchyCache, error) {
+	ttl := time.Duration(cfg.HierarchyCacheTTLSeconds) * time.Second
+	switch cfg.HierarchyCache {
+	case "redis":
+		if cfg.RedisHost == "" {
+			return nil, errors.New("REDIS_HOST is required for the redis hierarchy cache")
+		}
+		return New(NewRedisBackend(cfg.RedisHost, cfg.RedisUser, cfg.RedisPassword), ttl), nil
+	case "memory":
+		return New(NewMemoryBackend(), ttl), nil
+	case "", "none":
+		return nil, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown hierarchy cache %q", cfg.HierarchyCache))
+	}
+}
+
+func generationKey(tenantID string) string {
+	return fmt.Sprintf("%s:%s:gen", keyPrefix, tenantID)
+}
+
+func responseKey(tenantID string, generation int64, method string, in proto.Message) (string, error) {
+	request, err := proto.MarshalOptions{Deterministic: true}.Marshal(in)
+	if err != nil {
+		return "", errors.Wrap(err, "error marshalling request for cache key")
+	}
+	sum := sha256.Sum256(request)
+	return fmt.Sprintf("%s:%s:%d:%s:%s", keyPrefix, tenantID, generation, method, hex.EncodeToString(sum[:])), nil
+}
+
+// Invalidate drops every response cached for the tenant. Failing to invalidate is logged rather than returned, callers
+// have already made their change and the ttl bounds how long stale responses can be served.
+func (cache *HierarchyCache) Invalidate(ctx context.Context, tenantID string) {
+	if cache == nil || tenantID == "" {
+		return
+	}
+	if _, err := cache.backend.Incr(ctx, generationKey(tenantID)); err != nil {
+		log.WithContext(ctx).WithTenantID(tenantID).Error(errors.Wrap(err, "error invalidating hierarchy cache"))
+	}
+}
+
+// ReadThrough returns the cached response of method for in, or calls fetch and caches its response. Errors are never
+// cached, and a cache that can't be reached only costs the fetch.
+func ReadThrough[Req proto.Message, Res proto.Message](ctx context.Context, cache *HierarchyCache, tenantID, method string, in Req, fetch func(context.Context, Req) (Res, error)) (Res, error) {
+	if cache == nil || tenantID == "" {
+		return fetch(ctx, in)
+	}
+	logger := log.WithContext(ctx).WithTenantID(tenantID).WithCustom("method", method)
+
+	// The generation is read before fetching, so a response fetched while the tenant was invalidated is cached under the
+	// old generation and never served
+	generation, err := cache.backend.GetInt(ctx, generationKey(tenantID))
+	if err != nil {
+		logger.Error(errors.Wrap(err, "error getting hierarchy cache generation"))
+		return fetch(ctx, in)
+	}
+	key, err := responseKey(tenantID, generation, method, in)
+	if err != nil {
+		logger.Error(err)
+		return fetch(ctx, in)
+	}
+
+	data, err := cache.backend.Get(ctx, key)
+	if err != nil {
+		logger.Error(errors.Wrap(err, "error getting cached hierarchy response"))
+	}
+	if data != nil {
+		var empty Res
+		res := empty.ProtoReflect().New().Interface().(Res)
+		err := proto.Unmarshal(data, res)
+		if err == nil {
+			return res, nil
+		}
+		logger.Error(errors.Wrap(err, "error unmarshalling cached hierarchy response"))
+	}
+
+	res, err := fetch(ctx, in)
+	if err != nil {
+		return res, err
+	}
+	data, err = proto.Marshal(res)
+	if err != nil {
+		logger.Error(errors.Wrap(err, "error marshalling hierarchy response for cache"))
+		return res, nil
+	}
+	if err := cache.backend.Set(ctx, key, data, cache.ttl); err != nil {
+		logger.Error(errors.Wrap(err, "error caching hierarchy response"))
+	}
+	return res, nil
+}
@@ -0,0 +1,243 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/config"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	testTenantID      = "00000000-0000-0000-0000-000000000000"
+	testOtherTenantID = "5aa7aabb-12ea-4c6e-ac71-35a8dcfdb5ac"
+)
+
+func TestMain(m *testing.M) {
+	os.Setenv("PROJECT_ID", "local")
+	log.InitLogger()
+	os.Exit(m.Run())
+}
+
+// countingFetch answers with the request's value and a counter, so a cached response is the one from an earlier call
+type countingFetch struct {
+	calls int
+	err   error
+}
+
+func (f *countingFetch) fetch(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return wrapperspb.String(in.Value + ":" + strconv.Itoa(f.calls)), nil
+}
+
+func readThrough(cache *HierarchyCache, tenantID, value string, f *countingFetch) (string, error) {
+	res, err := ReadThrough(context.Background(), cache, tenantID, "GetTest", wrapperspb.String(value), f.fetch)
+	if err != nil {
+		return "", err
+	}
+	return res.Value, nil
+}
+
+func TestReadThrough(t *testing.T) {
+	cache := New(NewMemoryBackend(), time.Minute)
+	f := &countingFetch{}
+
+	first, err := readThrough(cache, testTenantID, "a", f)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	second, err := readThrough(cache, testTenantID, "a", f)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if f.calls != 1 || first != second {
+		t.Logf("expected the second read to be served from the cache, but got %d fetches and %q then %q", f.calls, first, second)
+		t.Fail()
+		return
+	}
+
+	// the request and the tenant are both part of the key
+	if _, err := readThrough(cache, testTenantID, "b", f); err != nil || f.calls != 2 {
+		t.Log("expected a different request to be fetched, but got", f.calls, "fetches", err)
+		t.Fail()
+		return
+	}
+	if _, err := readThrough(cache, testOtherTenantID, "a", f); err != nil || f.calls != 3 {
+		t.Log("expected the same request for another tenant to be fetched, but got", f.calls, "fetches", err)
+		t.Fail()
+		return
+	}
+	// no tenant, nothing to invalidate it by
+	for i := 0; i < 2; i++ {
+		if _, err := readThrough(cache, "", "a", f); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+	}
+	if f.calls != 5 {
+		t.Logf("expected reads without a tenant to never be cached, but got %d fetches", f.calls)
+		t.Fail()
+		return
+	}
+}
+
+func TestReadThroughInvalidate(t *testing.T) {
+	cache := New(NewMemoryBackend(), time.Minute)
+	f := &countingFetch{}
+
+	before, err := readThrough(cache, testTenantID, "a", f)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if _, err := readThrough(cache, testOtherTenantID, "a", f); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	cache.Invalidate(context.Background(), testTenantID)
+
+	after, err := readThrough(cache, testTenantID, "a", f)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if f.calls != 3 || before == after {
+		t.Logf("expected bumping the generation to orphan the cached response, but got %d fetches", f.calls)
+		t.Fail()
+		return
+	}
+	if _, err := readThrough(cache, testOtherTenantID, "a", f); err != nil || f.calls != 3 {
+		t.Log("expected another tenant's responses to survive the invalidation, but got", f.calls, "fetches", err)
+		t.Fail()
+		return
+	}
+}
+
+func TestReadThroughErrorsNotCached(t *testing.T) {
+	cache := New(NewMemoryBackend(), time.Minute)
+	f := &countingFetch{err: errors.New("db is down")}
+
+	if _, err := readThrough(cache, testTenantID, "a", f); err == nil {
+		t.Log("expected the fetch error to be returned")
+		t.Fail()
+		return
+	}
+	f.err = nil
+	if _, err := readThrough(cache, testTenantID, "a", f); err != nil || f.calls != 2 {
+		t.Log("expected a failed fetch to not be cached, but got", f.calls, "fetches", err)
+		t.Fail()
+		return
+	}
+}
+
+func TestReadThroughTTL(t *testing.T) {
+	cache := New(NewMemoryBackend(), 20*time.Millisecond)
+	f := &countingFetch{}
+
+	if _, err := readThrough(cache, testTenantID, "a", f); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, err := readThrough(cache, testTenantID, "a", f); err != nil || f.calls != 2 {
+		t.Log("expected an expired response to be fetched again, but got", f.calls, "fetches", err)
+		t.Fail()
+		return
+	}
+}
+
+// failingBackend can't be reached
+type failingBackend struct{}
+
+func (failingBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+func (failingBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return errors.New("connection refused")
+}
+func (failingBackend) GetInt(ctx context.Context, key string) (int64, error) {
+	return 0, errors.New("connection refused")
+}
+func (failingBackend) Incr(ctx context.Context, key string) (int64, error) {
+	return 0, errors.New("connection refused")
+}
+
+func TestReadThroughUnreachableBackend(t *testing.T) {
+	cache := New(failingBackend{}, time.Minute)
+	f := &countingFetch{}
+
+	for i := 1; i <= 2; i++ {
+		res, err := readThrough(cache, testTenantID, "a", f)
+		if err != nil || res == "" || f.calls != i {
+			t.Log("expected every read to be fetched when the cache can't be reached, but got", f.calls, "fetches", err)
+			t.Fail()
+			return
+		}
+	}
+	// logged, not returned or panicked
+	cache.Invalidate(context.Background(), testTenantID)
+}
+
+func TestNilCache(t *testing.T) {
+	for _, name := range []string{"", "none"} {
+		cache, err := NewFromConfig(config.Config{HierarchyCache: name})
+		if err != nil || cache != nil {
+			t.Logf("expected HIERARCHY_CACHE=%q to turn caching off, but got %v %v", name, cache, err)
+			t.Fail()
+			return
+		}
+
+		f := &countingFetch{}
+		for i := 0; i < 2; i++ {
+			if _, err := readThrough(cache, testTenantID, "a", f); err != nil {
+				t.Log(err)
+				t.Fail()
+				return
+			}
+		}
+		if f.calls != 2 {
+			t.Logf("expected a nil cache to fetch every read, but got %d fetches", f.calls)
+			t.Fail()
+			return
+		}
+		// a nil cache is safe to invalidate
+		cache.Invalidate(context.Background(), testTenantID)
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	cache, err := NewFromConfig(config.Config{HierarchyCache: "memory", HierarchyCacheTTLSeconds: 60})
+	if err != nil || cache == nil || cache.ttl != time.Minute {
+		t.Log("expected a memory cache with the configured ttl, but got", cache, err)
+		t.Fail()
+		return
+	}
+	if _, err := NewFromConfig(config.Config{HierarchyCache: "redis"}); err == nil {
+		t.Log("expected the redis cache to require REDIS_HOST")
+		t.Fail()
+		return
+	}
+	if _, err := NewFromConfig(config.Config{HierarchyCache: "memcached"}); err == nil {
+		t.Log("expected an unknown cache to be refused")
+		t.Fail()
+		return
+	}
+}
@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memoryMaxEntries is when Set starts sweeping expired entries, orphaned generations are otherwise only dropped on Get
+const memoryMaxEntries = 10000
+
+type memoryItem struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// MemoryBackend is a Backend local to the process, for local runs and tests
+type MemoryBackend struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+}
+
+var _ Backend = (*MemoryBackend)(nil)
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		items: map[string]memoryItem{},
+	}
+}
+
+// get expects backend.mu to be held
+func (backend *MemoryBackend) get(key string) ([]byte, bool) {
+	item, ok := backend.items[key]
+	if !ok {
+		return nil, false
+	}
+	if !item.expiresAt.IsZero() && time.Now().After(item.expiresAt) {
+		delete(backend.items, key)
+		return nil, false
+	}
+	return item.value, true
+}
+
+func (backend *MemoryBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	value, ok := backend.get(key)
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (backend *MemoryBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	if len(backend.items) >= memoryMaxEntries {
+		now := time.Now()
+		for k, item := range backend.items {
+			if !item.expiresAt.IsZero() && now.After(item.expiresAt) {
+				delete(backend.items, k)
+			}
+		}
+	}
+
+	item := memoryItem{value: append([]byte{}, value...)}
+	if ttl > 0 {
+		item.expiresAt = time.Now().Add(ttl)
+	}
+	backend.items[key] = item
+	return nil
+}
+
+func (backend *MemoryBackend) GetInt(ctx context.Context, key string) (int64, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	value, ok := backend.get(key)
+	if !ok {
+		return 0, nil
+	}
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
+func (backend *MemoryBackend) Incr(ctx context.Context, key string) (int64, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	n := int64(0)
+	if value, ok := backend.get(key); ok {
+		parsed, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		n = parsed
+	}
+	n++
+	backend.items[key] = memoryItem{value: []byte(strconv.FormatInt(n, 10))}
+	return n, nil
+}
+
+// Flush drops everything, e.g. between tests
+func (backend *MemoryBackend) Flush() {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	backend.items = map[string]memoryItem{}
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisBackend is the Backend shared by every orchard instance
+type RedisBackend struct {
+	client *redis.Client
+}
+
+var _ Backend = (*RedisBackend)(nil)
+
+func NewRedisBackend(addr, user, password string) *RedisBackend {
+	return &RedisBackend{
+		client: redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Username: user,
+			Password: password,
+		}),
+	}
+}
+
+func (backend *RedisBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := backend.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (backend *RedisBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return backend.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (backend *RedisBackend) GetInt(ctx context.Context, key string) (int64, error) {
+	n, err := backend.client.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (backend *RedisBackend) Incr(ctx context.Context, key string) (int64, error) {
+	return backend.client.Incr(ctx, key).Result()
+}
+
+func (backend *RedisBackend) Close() error {
+	return backend.client.Close()
+}
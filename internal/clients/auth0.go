@@ -1,8 +1,12 @@
 package clients
 
 import (
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"sort"
 	"strings"
 	"time"
@@ -40,6 +44,14 @@ var LegacySuperAdminRoleMappings = strUtil.Strings{
 	"95f00236-3b8c-4806-bec1-fbf532b7ad10",
 }
 
+const (
+	// auth0SearchLimit is the most users user search returns for one query, however it's paged
+	auth0SearchLimit = 1000
+	// auth0Connection is the connection provisioned users are created in
+	auth0Connection         = "email"
+	auth0ExportPollInterval = 2 * time.Second
+)
+
 type Auth0Client struct {
 	cfg config.Config
 }
@@ -81,40 +93,17 @@ func (ac Auth0Client) Provision(ctx context.Context, personRecords []*models.Per
 		return err
 	}
 
-	var (
-		isPrimaryIndex      int
-		primaryUserID       string
-		primaryUserEmail    string
-		primaryUserTenantID string
-		previousCreatedAt   time.Time
-	)
-	tenantContexts := make([]TenantContext, len(personRecords))
-
-	for i, person := range personRecords {
-		if i == 0 || person.CreatedAt.Before(previousCreatedAt) {
-			primaryUserID = person.ID
-			primaryUserEmail = strings.TrimSpace(person.Email.String)
-			primaryUserTenantID = person.TenantID
-			previousCreatedAt = person.CreatedAt
-			isPrimaryIndex = i
-		}
-
-		tenantContexts[i] = TenantContext{
-			TenantID:  person.TenantID,
-			UserID:    person.ID,
-			IsPrimary: false,
-		}
-	}
-
-	// set primary
-	tenantContexts[isPrimaryIndex].IsPrimary = true
+	tenantContexts, primary := BuildTenantContexts(personRecords)
+	primaryUserID := primary.ID
+	primaryUserEmail := strings.TrimSpace(primary.Email.String)
+	primaryUserTenantID := primary.TenantID
 
 	logger := log.WithTenantID(primaryUserTenantID).WithCustom("userId", primaryUserID)
 
 	provisionedUser := &management.User{
 		Email:         auth0.String(primaryUserEmail),
 		EmailVerified: auth0.Bool(true),
-		Connection:    auth0.String("email"),
+		Connection:    auth0.String(auth0Connection),
 		AppMetadata: map[string]interface{}{
 			"license":         &Auth0License{IsActive: true},
 			"person_id":       primaryUserID,
@@ -251,6 +240,207 @@ func (ac Auth0Client) GetRoleUsers(ctx context.Context, roleID string) ([]*orcha
 	return people, nil
 }
 
+// GetIdentityByUserID gets the provisioning state of the auth0 user for the given orchard person, nil if there isn't one
+func (ac Auth0Client) GetIdentityByUserID(ctx context.Context, tenantID, userID string) (*Identity, error) {
+	spanCtx, span := log.StartSpan(ctx, "AuthO.GetIdentityByUserID")
+	defer span.End()
+
+	logger := log.WithTenantID(tenantID).WithCustom("userId", userID)
+
+	client, err := ac.getClient(spanCtx)
+	if err != nil {
+		err := errors.Wrap(err, "error getting auth0 management client")
+		logger.Error(err)
+		return nil, err
+	}
+
+	user, err := ac.getByUserID(spanCtx, client, tenantID, userID)
+	if err != nil {
+		err := errors.Wrap(err, "error getting user from auth0")
+		logger.Error(err)
+		return nil, err
+	}
+	if user == nil {
+		return nil, nil
+	}
+
+	return convertIdentities([]*management.User{user})[0], nil
+}
+
+// GetIdentitiesByEmail gets the provisioning state of every auth0 user with the given email, most used first
+func (ac Auth0Client) GetIdentitiesByEmail(ctx context.Context, tenantID, email string) ([]*Identity, error) {
+	spanCtx, span := log.StartSpan(ctx, "AuthO.GetIdentitiesByEmail")
+	defer span.End()
+
+	logger := log.WithTenantID(tenantID).WithCustom("email", email)
+
+	client, err := ac.getClient(spanCtx)
+	if err != nil {
+		err := errors.Wrap(err, "error getting auth0 management client")
+		logger.Error(err)
+		return nil, err
+	}
+
+	users, err := ac.searchUserByEmail(spanCtx, client, tenantID, email)
+	if err != nil {
+		err := errors.Wrap(err, "error searching users by email")
+		logger.Error(err)
+		return nil, err
+	}
+
+	return convertIdentities(users), nil
+}
+
+// ListTenantIdentities gets the provisioning state of every auth0 user with a context in the given tenant, regardless of license state.
+// User search stops at auth0SearchLimit results however it's paged, so bigger tenants are read from a user export instead.
+func (ac Auth0Client) ListTenantIdentities(ctx context.Context, tenantID string) ([]*Identity, error) {
+	spanCtx, span := log.StartSpan(ctx, "AuthO.ListTenantIdentities")
+	defer span.End()
+
+	logger := log.WithTenantID(tenantID)
+
+	client, err := ac.getClient(spanCtx)
+	if err != nil {
+		err := errors.Wrap(err, "error getting auth0 management client")
+		logger.Error(err)
+		return nil, err
+	}
+
+	q := fmt.Sprintf(`app_metadata.tenant_id:"%s" OR app_metadata.tenant_contexts.tenant_id:"%s"`, tenantID, tenantID)
+	users, total, err := ac.listUsers(spanCtx, client, q, 0, 50)
+	if err != nil {
+		err := errors.Wrap(err, "error listing users by tenantId")
+		logger.Error(err)
+		return nil, err
+	}
+
+	identities := convertIdentities(users)
+	if total > auth0SearchLimit {
+		logger.WithCustom("total", total).Warn("tenant has more auth0 users than user search returns, exporting them instead")
+		identities, err = ac.exportTenantIdentities(spanCtx, client, tenantID)
+		if err != nil {
+			err := errors.Wrap(err, "error exporting users by tenantId")
+			logger.Error(err)
+			return nil, err
+		}
+	} else {
+		for i := 1; len(users) > 0 && len(identities) < total; i++ {
+			users, _, err = ac.listUsers(spanCtx, client, q, i, 50)
+			if err != nil {
+				err := errors.Wrap(err, "error listing users by tenantId")
+				logger.Error(err)
+				return nil, err
+			}
+			identities = append(identities, convertIdentities(users)...)
+		}
+	}
+
+	// Reconciling against a partial list would report (and fix) every missing user as unprovisioned
+	if len(identities) < total {
+		err := errors.New(fmt.Sprintf("only got %d of the %d auth0 users in the tenant", len(identities), total))
+		logger.Error(err)
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// exportTenantIdentities runs a user export job over the connection provisioned users are created in,
+// keeping the users with a context in the given tenant
+func (ac Auth0Client) exportTenantIdentities(ctx context.Context, client *management.Management, tenantID string) ([]*Identity, error) {
+	spanCtx, span := log.StartSpan(ctx, "AuthO.ExportTenantIdentities")
+	defer span.End()
+
+	connection, err := client.Connection.ReadByName(auth0Connection)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting auth0 connection")
+	}
+
+	job := &management.Job{
+		ConnectionID: connection.ID,
+		Format:       auth0.String("json"),
+		Fields: []map[string]interface{}{
+			{"name": "user_id"},
+			{"name": "email"},
+			{"name": "app_metadata"},
+		},
+	}
+	if err := client.Job.ExportUsers(job); err != nil {
+		return nil, errors.Wrap(err, "error starting auth0 user export")
+	}
+
+	for job.Status == nil || *job.Status != "completed" {
+		if job.Status != nil && *job.Status == "failed" {
+			return nil, errors.New(fmt.Sprintf("auth0 user export %s failed", auth0.StringValue(job.ID)))
+		}
+		select {
+		case <-spanCtx.Done():
+			return nil, spanCtx.Err()
+		case <-time.After(auth0ExportPollInterval):
+		}
+		if job, err = client.Job.Read(auth0.StringValue(job.ID)); err != nil {
+			return nil, errors.Wrap(err, "error getting auth0 user export")
+		}
+	}
+	if job.Location == nil {
+		return nil, errors.New(fmt.Sprintf("auth0 user export %s has no file", auth0.StringValue(job.ID)))
+	}
+
+	req, err := http.NewRequestWithContext(spanCtx, http.MethodGet, *job.Location, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error downloading auth0 user export")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("error downloading auth0 user export, got status %d", res.StatusCode))
+	}
+
+	gz, err := gzip.NewReader(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading auth0 user export")
+	}
+	defer gz.Close()
+
+	return readExportedIdentities(gz, tenantID)
+}
+
+// readExportedIdentities reads a user export, one json user per line, keeping the users with a context in the given tenant
+func readExportedIdentities(r io.Reader, tenantID string) ([]*Identity, error) {
+	identities := []*Identity{}
+	decoder := json.NewDecoder(r)
+	for {
+		user := &management.User{}
+		if err := decoder.Decode(user); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "error reading auth0 user export")
+		}
+		identity := convertIdentities([]*management.User{user})[0]
+		if identity.TenantID == tenantID || hasTenantContext(identity, tenantID) {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+func (ac Auth0Client) listUsers(ctx context.Context, client *management.Management, q string, page, take int) ([]*management.User, int, error) {
+	_, span := log.StartSpan(ctx, "AuthO.ListUsers")
+	defer span.End()
+
+	users, err := client.User.List(management.Query(q), management.IncludeTotals(true), management.Page(page), management.PerPage(take), management.Parameter("search_engine", "v3"))
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "error getting list of users from auth0")
+	}
+	if users == nil || len(users.Users) == 0 {
+		return nil, 0, nil
+	}
+	return users.Users, users.Total, nil
+}
+
 func (ac Auth0Client) getRoleUsers(ctx context.Context, client *management.Management, roleID string, page, take int) ([]*management.User, int, error) {
 	_, span := log.StartSpan(ctx, "AuthO.GetRoleUsers")
 	defer span.End()
@@ -354,6 +544,25 @@ func convertUsers(users []*management.User) []*orchardPb.Person {
 	return people
 }
 
+func convertIdentities(users []*management.User) []*Identity {
+	identities := make([]*Identity, 0, len(users))
+	for _, user := range users {
+		identity := &Identity{}
+		// app_metadata comes back as generic json, so round trip it to get typed tenant contexts and license
+		if raw, err := json.Marshal(user.AppMetadata); err == nil {
+			_ = json.Unmarshal(raw, identity)
+		}
+		if user.ID != nil {
+			identity.UserID = *user.ID
+		}
+		if user.Email != nil {
+			identity.Email = *user.Email
+		}
+		identities = append(identities, identity)
+	}
+	return identities
+}
+
 func convertUser(user *management.User) *orchardPb.Person {
 	person := &orchardPb.Person{}
 
@@ -0,0 +1,39 @@
+package clients
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadExportedIdentities(t *testing.T) {
+	tenantID := "00000000-0000-0000-0000-000000000000"
+	export := strings.Join([]string{
+		`{"user_id":"email|pat","email":"pat@canopy.io","app_metadata":{"person_id":"p1","tenant_id":"00000000-0000-0000-0000-000000000000","license":{"is_active":true},"tenant_contexts":[{"tenant_id":"00000000-0000-0000-0000-000000000000","user_id":"p1","is_primary":true}]}}`,
+		`{"user_id":"email|dan","email":"dan@canopy.io","app_metadata":{"person_id":"p2","tenant_id":"5aa7aabb-12ea-4c6e-ac71-35a8dcfdb5ac","license":{"is_active":false},"tenant_contexts":[{"tenant_id":"5aa7aabb-12ea-4c6e-ac71-35a8dcfdb5ac","user_id":"p2","is_primary":true},{"tenant_id":"00000000-0000-0000-0000-000000000000","user_id":"p3"}]}}`,
+		`{"user_id":"email|other","email":"other@canopy.io","app_metadata":{"person_id":"p4","tenant_id":"5aa7aabb-12ea-4c6e-ac71-35a8dcfdb5ac"}}`,
+		`{"user_id":"email|none","email":"none@canopy.io"}`,
+	}, "\n")
+
+	identities, err := readExportedIdentities(strings.NewReader(export), tenantID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(identities) != 2 || identities[0].UserID != "email|pat" || identities[1].UserID != "email|dan" {
+		t.Log("expected the users with a primary or secondary context in the tenant, but got", identities)
+		t.Fail()
+		return
+	}
+	if !identities[0].License.IsActive || identities[0].PersonID != "p1" || identities[1].License.IsActive || len(identities[1].TenantContexts) != 2 {
+		t.Logf("expected app_metadata to be read into the identities, but got %+v and %+v", identities[0], identities[1])
+		t.Fail()
+		return
+	}
+
+	if _, err := readExportedIdentities(strings.NewReader(export+"\n{\"user_id\":"), tenantID); err == nil {
+		t.Log("expected a truncated export to fail rather than return the users read so far")
+		t.Fail()
+		return
+	}
+}
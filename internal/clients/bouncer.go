@@ -0,0 +1,16 @@
+package clients
+
+import (
+	"context"
+
+	bouncer "github.com/loupe-co/bouncer/pkg/client"
+	bouncerPb "github.com/loupe-co/protos/src/services/bouncer"
+)
+
+// AuthCacheBuster clears cached auth data in bouncer after a change to someone's permissions, bouncer.BouncerClient is the production implementation
+type AuthCacheBuster interface {
+	BustAuthCache(ctx context.Context, in *bouncerPb.BustAuthCacheRequest) (*bouncerPb.BustAuthCacheResponse, error)
+	MultiBustAuthCache(ctx context.Context, reqs ...*bouncerPb.BustAuthCacheRequest) error
+}
+
+var _ AuthCacheBuster = (*bouncer.BouncerClient)(nil)
@@ -0,0 +1,60 @@
+package clients
+
+import (
+	"context"
+
+	bouncerPb "github.com/loupe-co/protos/src/services/bouncer"
+)
+
+// FakeBouncer is an AuthCacheBuster that records busts instead of calling bouncer
+type FakeBouncer struct {
+	CallRecorder
+}
+
+var _ AuthCacheBuster = (*FakeBouncer)(nil)
+
+func NewFakeBouncer() *FakeBouncer {
+	return &FakeBouncer{}
+}
+
+func (client *FakeBouncer) BustAuthCache(ctx context.Context, in *bouncerPb.BustAuthCacheRequest) (*bouncerPb.BustAuthCacheResponse, error) {
+	if err := client.record("BustAuthCache", in); err != nil {
+		return nil, err
+	}
+	return &bouncerPb.BustAuthCacheResponse{}, nil
+}
+
+func (client *FakeBouncer) MultiBustAuthCache(ctx context.Context, reqs ...*bouncerPb.BustAuthCacheRequest) error {
+	args := make([]interface{}, len(reqs))
+	for i, req := range reqs {
+		args[i] = req
+	}
+	return client.record("MultiBustAuthCache", args...)
+}
+
+// Busts returns every bust request made through either method, in order
+func (client *FakeBouncer) Busts() []*bouncerPb.BustAuthCacheRequest {
+	busts := []*bouncerPb.BustAuthCacheRequest{}
+	for _, call := range client.Calls("") {
+		for _, arg := range call.Args {
+			if req, ok := arg.(*bouncerPb.BustAuthCacheRequest); ok {
+				busts = append(busts, req)
+			}
+		}
+	}
+	return busts
+}
+
+// BustedUserIDs returns the distinct users whose auth cache was busted in the tenant, an empty user id means the whole tenant (or everything) was busted
+func (client *FakeBouncer) BustedUserIDs(tenantID string) []string {
+	seen := map[string]bool{}
+	userIDs := []string{}
+	for _, req := range client.Busts() {
+		if req.TenantId != tenantID || seen[req.UserId] {
+			continue
+		}
+		seen[req.UserId] = true
+		userIDs = append(userIDs, req.UserId)
+	}
+	return userIDs
+}
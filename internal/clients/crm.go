@@ -13,6 +13,15 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 )
 
+// CRMDataSource is where CRM roles and people are synced from, CRMClient (crm-data-access) is the production implementation.
+// Both calls are paged, an empty next token means there are no more pages.
+type CRMDataSource interface {
+	GetLatestChangedPeople(ctx context.Context, tenantID string, changeSince *timestamp.Timestamp, limit int, token string) ([]*orchardPb.Person, int, string, error)
+	GetLatestCRMRoles(ctx context.Context, tenantID string, changeSince *timestamp.Timestamp, limit int, token string) ([]*orchardPb.CRMRole, int, string, error)
+}
+
+var _ CRMDataSource = (*CRMClient)(nil)
+
 type CRMClient struct {
 	conn   *grpc.ClientConn
 	client servicePb.CrmDataAccessClient
@@ -0,0 +1,85 @@
+package clients
+
+import (
+	"context"
+	"sync"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+)
+
+// FakeCRMDataSource is an in-memory CRMDataSource that records its calls, pages the same way as FileCRMDataSource
+type FakeCRMDataSource struct {
+	CallRecorder
+
+	mu     sync.RWMutex
+	people map[string][]*orchardPb.Person
+	roles  map[string][]*orchardPb.CRMRole
+}
+
+var _ CRMDataSource = (*FakeCRMDataSource)(nil)
+
+func NewFakeCRMDataSource() *FakeCRMDataSource {
+	return &FakeCRMDataSource{
+		people: map[string][]*orchardPb.Person{},
+		roles:  map[string][]*orchardPb.CRMRole{},
+	}
+}
+
+// SetPeople replaces the crm users returned for the tenant
+func (client *FakeCRMDataSource) SetPeople(tenantID string, people ...*orchardPb.Person) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.people[tenantID] = people
+}
+
+// SetCRMRoles replaces the crm roles returned for the tenant
+func (client *FakeCRMDataSource) SetCRMRoles(tenantID string, roles ...*orchardPb.CRMRole) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.roles[tenantID] = roles
+}
+
+func (client *FakeCRMDataSource) GetLatestChangedPeople(ctx context.Context, tenantID string, changeSince *timestamp.Timestamp, limit int, token string) ([]*orchardPb.Person, int, string, error) {
+	if err := client.record("GetLatestChangedPeople", tenantID, changeSince, limit, token); err != nil {
+		return nil, 0, "", err
+	}
+
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+
+	changed := []*orchardPb.Person{}
+	for _, p := range client.people[tenantID] {
+		if p.UpdatedAt == nil || changedSince(p.UpdatedAt.AsTime(), changeSince) {
+			changed = append(changed, p)
+		}
+	}
+
+	start, end, nextToken, err := getSnapshotPage(len(changed), limit, token)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	return changed[start:end], len(changed), nextToken, nil
+}
+
+func (client *FakeCRMDataSource) GetLatestCRMRoles(ctx context.Context, tenantID string, changeSince *timestamp.Timestamp, limit int, token string) ([]*orchardPb.CRMRole, int, string, error) {
+	if err := client.record("GetLatestCRMRoles", tenantID, changeSince, limit, token); err != nil {
+		return nil, 0, "", err
+	}
+
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+
+	changed := []*orchardPb.CRMRole{}
+	for _, r := range client.roles[tenantID] {
+		if r.UpdatedAt == nil || changedSince(r.UpdatedAt.AsTime(), changeSince) {
+			changed = append(changed, r)
+		}
+	}
+
+	start, end, nextToken, err := getSnapshotPage(len(changed), limit, token)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	return changed[start:end], len(changed), nextToken, nil
+}
@@ -0,0 +1,342 @@
+package clients
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/go-loupe-logger/log"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	crmSnapshotRolesFile  = "crm_roles"
+	crmSnapshotPeopleFile = "people"
+	// crmSnapshotListSep separates the values of list columns (role_ids, crm_role_ids) in csv snapshots
+	crmSnapshotListSep = ";"
+)
+
+// FileCRMDataSource reads CRM roles and people from snapshots on disk instead of crm-data-access, so a tenant's sync can be
+// replayed locally, tested hermetically or fed from an offline CRM export. Snapshots are laid out per tenant:
+//
+//	<dir>/<tenant id>/crm_roles.json (or .csv)
+//	<dir>/<tenant id>/people.json (or .csv)
+//
+// JSON snapshots are an array of records, CSV snapshots have a header row using the same snake_case names as the json fields.
+// Snapshots are re-read on every call, so they can be edited between syncs.
+type FileCRMDataSource struct {
+	dir string
+}
+
+var _ CRMDataSource = (*FileCRMDataSource)(nil)
+
+func NewFileCRMDataSource(dir string) *FileCRMDataSource {
+	return &FileCRMDataSource{dir: dir}
+}
+
+// CRMRoleRecord is a single crm role in a snapshot
+type CRMRoleRecord struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	ParentID    string    `json:"parent_id"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// CRMPersonRecord is a single crm user in a snapshot
+type CRMPersonRecord struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	Email      string    `json:"email"`
+	PhotoURL   string    `json:"photo_url"`
+	ManagerID  string    `json:"manager_id"`
+	GroupID    string    `json:"group_id"`
+	RoleIDs    []string  `json:"role_ids"`
+	CRMRoleIDs []string  `json:"crm_role_ids"`
+	Status     string    `json:"status"`
+	Type       string    `json:"type"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (client *FileCRMDataSource) GetLatestChangedPeople(ctx context.Context, tenantID string, changeSince *timestamp.Timestamp, limit int, token string) ([]*orchardPb.Person, int, string, error) {
+	_, span := log.StartSpan(ctx, "FileCRM.GetLatestChangedPeople")
+	defer span.End()
+
+	raw, rows, err := client.readSnapshot(tenantID, crmSnapshotPeopleFile)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	records := []*CRMPersonRecord{}
+	if raw != nil {
+		if err := json.Unmarshal(raw, &records); err != nil {
+			return nil, 0, "", errors.Wrap(err, "error decoding people snapshot")
+		}
+	}
+	for i, row := range rows {
+		record, err := parsePersonRow(row)
+		if err != nil {
+			return nil, 0, "", errors.Wrap(err, fmt.Sprintf("error parsing row %d of people snapshot", i+2))
+		}
+		records = append(records, record)
+	}
+
+	changed := []*CRMPersonRecord{}
+	for _, record := range records {
+		if changedSince(record.UpdatedAt, changeSince) {
+			changed = append(changed, record)
+		}
+	}
+
+	start, end, nextToken, err := getSnapshotPage(len(changed), limit, token)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	people := make([]*orchardPb.Person, 0, end-start)
+	for _, record := range changed[start:end] {
+		status := orchardPb.BasicStatus_Active
+		if strings.EqualFold(record.Status, orchardPb.BasicStatus_Inactive.String()) {
+			status = orchardPb.BasicStatus_Inactive
+		}
+		people = append(people, &orchardPb.Person{
+			Id:         record.ID,
+			TenantId:   tenantID,
+			Name:       record.Name,
+			FirstName:  record.FirstName,
+			LastName:   record.LastName,
+			Email:      record.Email,
+			PhotoUrl:   record.PhotoURL,
+			ManagerId:  record.ManagerID,
+			GroupId:    record.GroupID,
+			RoleIds:    record.RoleIDs,
+			CrmRoleIds: record.CRMRoleIDs,
+			Status:     status,
+			Type:       record.Type,
+			UpdatedAt:  timestamppb.New(record.UpdatedAt),
+		})
+	}
+
+	return people, len(changed), nextToken, nil
+}
+
+func (client *FileCRMDataSource) GetLatestCRMRoles(ctx context.Context, tenantID string, changeSince *timestamp.Timestamp, limit int, token string) ([]*orchardPb.CRMRole, int, string, error) {
+	_, span := log.StartSpan(ctx, "FileCRM.GetLatestCRMRoles")
+	defer span.End()
+
+	raw, rows, err := client.readSnapshot(tenantID, crmSnapshotRolesFile)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	records := []*CRMRoleRecord{}
+	if raw != nil {
+		if err := json.Unmarshal(raw, &records); err != nil {
+			return nil, 0, "", errors.Wrap(err, "error decoding crm roles snapshot")
+		}
+	}
+	for i, row := range rows {
+		record, err := parseRoleRow(row)
+		if err != nil {
+			return nil, 0, "", errors.Wrap(err, fmt.Sprintf("error parsing row %d of crm roles snapshot", i+2))
+		}
+		records = append(records, record)
+	}
+
+	changed := []*CRMRoleRecord{}
+	for _, record := range records {
+		if changedSince(record.UpdatedAt, changeSince) {
+			changed = append(changed, record)
+		}
+	}
+
+	start, end, nextToken, err := getSnapshotPage(len(changed), limit, token)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	roles := make([]*orchardPb.CRMRole, 0, end-start)
+	for _, record := range changed[start:end] {
+		roles = append(roles, &orchardPb.CRMRole{
+			Id:          record.ID,
+			TenantId:    tenantID,
+			Name:        record.Name,
+			Description: record.Description,
+			ParentId:    record.ParentID,
+			UpdatedAt:   timestamppb.New(record.UpdatedAt),
+		})
+	}
+
+	return roles, len(changed), nextToken, nil
+}
+
+// changedSince treats records without an updated_at as always changed, so snapshots don't need timestamps
+func changedSince(updatedAt time.Time, changeSince *timestamp.Timestamp) bool {
+	if updatedAt.IsZero() || changeSince == nil || !changeSince.IsValid() {
+		return true
+	}
+	return !updatedAt.Before(changeSince.AsTime())
+}
+
+// getSnapshotPage returns the slice bounds for the page starting at the token, tokens are the offset of the next page
+func getSnapshotPage(total, limit int, token string) (start, end int, nextToken string, err error) {
+	if token != "" {
+		start, err = strconv.Atoi(token)
+		if err != nil || start < 0 {
+			return 0, 0, "", errors.New(fmt.Sprintf("invalid page token %q", token)).WithCode(codes.InvalidArgument)
+		}
+	}
+	if start > total {
+		start = total
+	}
+	end = total
+	if limit > 0 && start+limit < total {
+		end = start + limit
+		nextToken = strconv.Itoa(end)
+	}
+	return start, end, nextToken, nil
+}
+
+// readSnapshot reads <dir>/<tenantID>/<name>.json, falling back to <name>.csv. Either the raw json or the csv rows are returned,
+// a tenant without a snapshot has neither.
+func (client *FileCRMDataSource) readSnapshot(tenantID, name string) ([]byte, []map[string]string, error) {
+	if tenantID == "" {
+		return nil, nil, errors.New("tenantId can't be empty").WithCode(codes.InvalidArgument)
+	}
+
+	// sync requests can carry a license suffix on the tenant id, e.g. <tenant id>::create_and_close
+	tenantID = strings.Split(tenantID, "::")[0]
+	base := filepath.Join(client.dir, filepath.Base(tenantID), name)
+
+	raw, err := os.ReadFile(base + ".json")
+	if err == nil {
+		return raw, nil, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, nil, errors.Wrap(err, "error reading crm snapshot")
+	}
+
+	f, err := os.Open(base + ".csv")
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error reading crm snapshot")
+	}
+	defer f.Close()
+
+	rows, err := readCSVRows(f)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, fmt.Sprintf("error reading crm snapshot %s.csv", base))
+	}
+
+	return nil, rows, nil
+}
+
+// readCSVRows reads a csv with a header row into one map per row keyed by the lower cased header
+func readCSVRows(r io.Reader) ([]map[string]string, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, col := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(col))
+	}
+
+	rows := []map[string]string{}
+	for {
+		values, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(header))
+		for i, col := range header {
+			if i < len(values) {
+				row[col] = strings.TrimSpace(values[i])
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func parseRoleRow(row map[string]string) (*CRMRoleRecord, error) {
+	updatedAt, err := parseSnapshotTime(row["updated_at"])
+	if err != nil {
+		return nil, err
+	}
+	return &CRMRoleRecord{
+		ID:          row["id"],
+		Name:        row["name"],
+		Description: row["description"],
+		ParentID:    row["parent_id"],
+		UpdatedAt:   updatedAt,
+	}, nil
+}
+
+func parsePersonRow(row map[string]string) (*CRMPersonRecord, error) {
+	updatedAt, err := parseSnapshotTime(row["updated_at"])
+	if err != nil {
+		return nil, err
+	}
+	return &CRMPersonRecord{
+		ID:         row["id"],
+		Name:       row["name"],
+		FirstName:  row["first_name"],
+		LastName:   row["last_name"],
+		Email:      row["email"],
+		PhotoURL:   row["photo_url"],
+		ManagerID:  row["manager_id"],
+		GroupID:    row["group_id"],
+		RoleIDs:    splitSnapshotList(row["role_ids"]),
+		CRMRoleIDs: splitSnapshotList(row["crm_role_ids"]),
+		Status:     row["status"],
+		Type:       row["type"],
+		UpdatedAt:  updatedAt,
+	}, nil
+}
+
+func parseSnapshotTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid updated_at %q, expected RFC3339", value)
+	}
+	return t, nil
+}
+
+func splitSnapshotList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	values := []string{}
+	for _, v := range strings.Split(value, crmSnapshotListSep) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
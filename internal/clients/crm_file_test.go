@@ -0,0 +1,291 @@
+package clients
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// the snapshots under fixtures/crm_file are one tenant per case
+const (
+	crmFileTestDir       = "../../fixtures/crm_file"
+	crmFileJSONTenant    = "11111111-1111-1111-1111-111111111111"
+	crmFileCSVTenant     = "22222222-2222-2222-2222-222222222222"
+	crmFileBadTenant     = "33333333-3333-3333-3333-333333333333"
+	crmFileRaggedTenant  = "44444444-4444-4444-4444-444444444444"
+	crmFileMissingTenant = "55555555-5555-5555-5555-555555555555"
+)
+
+func crmRoleIDs(roles []*orchardPb.CRMRole) string {
+	ids := make([]string, len(roles))
+	for i, role := range roles {
+		ids[i] = role.Id
+	}
+	return strings.Join(ids, ",")
+}
+
+func crmPersonIDs(people []*orchardPb.Person) string {
+	ids := make([]string, len(people))
+	for i, person := range people {
+		ids[i] = person.Id
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestFileCRMRolesJSON(t *testing.T) {
+	client := NewFileCRMDataSource(crmFileTestDir)
+	ctx := context.Background()
+
+	roles, total, token, err := client.GetLatestCRMRoles(ctx, crmFileJSONTenant, nil, 0, "")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if crmRoleIDs(roles) != "r1,r2,r3,r4" || total != 4 || token != "" {
+		t.Logf("expected every role on one page without a limit, but got %s of %d with token %q", crmRoleIDs(roles), total, token)
+		t.Fail()
+		return
+	}
+	if roles[1].ParentId != "r1" || roles[0].Description != "Runs sales" || roles[1].TenantId != crmFileJSONTenant || !roles[2].UpdatedAt.AsTime().Equal(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Logf("expected the snapshot fields to be read into the roles, but got %+v", roles[:3])
+		t.Fail()
+		return
+	}
+}
+
+func TestFileCRMRolesPages(t *testing.T) {
+	client := NewFileCRMDataSource(crmFileTestDir)
+	ctx := context.Background()
+
+	expected := []struct {
+		ids   string
+		token string
+	}{
+		{ids: "r1,r2,r3", token: "3"},
+		{ids: "r4", token: ""},
+	}
+	token := ""
+	for i, page := range expected {
+		roles, total, nextToken, err := client.GetLatestCRMRoles(ctx, crmFileJSONTenant, nil, 3, token)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if crmRoleIDs(roles) != page.ids || total != 4 || nextToken != page.token {
+			t.Logf("expected page %d to be %s with token %q, but got %s of %d with token %q", i, page.ids, page.token, crmRoleIDs(roles), total, nextToken)
+			t.Fail()
+			return
+		}
+		token = nextToken
+	}
+
+	// a limit that lands on the last record doesn't hand out a token for an empty page
+	roles, _, token, err := client.GetLatestCRMRoles(ctx, crmFileJSONTenant, nil, 4, "")
+	if err != nil || len(roles) != 4 || token != "" {
+		t.Log("expected a full last page to end the paging, but got", crmRoleIDs(roles), token, err)
+		t.Fail()
+		return
+	}
+	roles, _, token, err = client.GetLatestCRMRoles(ctx, crmFileJSONTenant, nil, 3, "10")
+	if err != nil || len(roles) != 0 || token != "" {
+		t.Log("expected a token past the end to return nothing, but got", crmRoleIDs(roles), token, err)
+		t.Fail()
+		return
+	}
+	for _, bad := range []string{"abc", "-1"} {
+		if _, _, _, err := client.GetLatestCRMRoles(ctx, crmFileJSONTenant, nil, 3, bad); err == nil {
+			t.Logf("expected page token %q to be refused", bad)
+			t.Fail()
+			return
+		}
+	}
+}
+
+func TestFileCRMChangeSince(t *testing.T) {
+	client := NewFileCRMDataSource(crmFileTestDir)
+	ctx := context.Background()
+	changeSince := timestamppb.New(time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC))
+
+	// updated exactly at changeSince counts as changed, a record without updated_at always does
+	roles, total, token, err := client.GetLatestCRMRoles(ctx, crmFileJSONTenant, changeSince, 2, "")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if crmRoleIDs(roles) != "r2,r3" || total != 3 || token != "2" {
+		t.Logf("expected the first page of roles changed since february, but got %s of %d with token %q", crmRoleIDs(roles), total, token)
+		t.Fail()
+		return
+	}
+	roles, _, token, err = client.GetLatestCRMRoles(ctx, crmFileJSONTenant, changeSince, 2, token)
+	if err != nil || crmRoleIDs(roles) != "r4" || token != "" {
+		t.Log("expected the second page to hold the role without updated_at, but got", crmRoleIDs(roles), token, err)
+		t.Fail()
+		return
+	}
+
+	people, total, _, err := client.GetLatestChangedPeople(ctx, crmFileJSONTenant, changeSince, 0, "")
+	if err != nil || crmPersonIDs(people) != "p2,p3" || total != 2 {
+		t.Log("expected the people changed since february, but got", crmPersonIDs(people), total, err)
+		t.Fail()
+		return
+	}
+	people, _, _, err = client.GetLatestChangedPeople(ctx, crmFileCSVTenant, changeSince, 0, "")
+	if err != nil || crmPersonIDs(people) != "p2,p3" {
+		t.Log("expected the csv people changed since february, but got", crmPersonIDs(people), err)
+		t.Fail()
+		return
+	}
+}
+
+func TestFileCRMPeopleJSON(t *testing.T) {
+	client := NewFileCRMDataSource(crmFileTestDir)
+
+	people, total, token, err := client.GetLatestChangedPeople(context.Background(), crmFileJSONTenant, nil, 2, "")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if crmPersonIDs(people) != "p1,p2" || total != 3 || token != "2" {
+		t.Logf("expected the first page of people, but got %s of %d with token %q", crmPersonIDs(people), total, token)
+		t.Fail()
+		return
+	}
+	dan := people[1]
+	if dan.ManagerId != "p1" || dan.Email != "dan@canopy.io" || dan.Type != "ic" || strings.Join(dan.CrmRoleIds, ",") != "r2" || dan.TenantId != crmFileJSONTenant {
+		t.Logf("expected the snapshot fields to be read into the person, but got %+v", dan)
+		t.Fail()
+		return
+	}
+	if people[0].Status != orchardPb.BasicStatus_Active || dan.Status != orchardPb.BasicStatus_Inactive {
+		t.Log("expected statuses to be matched without case, but got", people[0].Status, dan.Status)
+		t.Fail()
+		return
+	}
+}
+
+func TestFileCRMCSV(t *testing.T) {
+	client := NewFileCRMDataSource(crmFileTestDir)
+	ctx := context.Background()
+
+	roles, total, _, err := client.GetLatestCRMRoles(ctx, crmFileCSVTenant, nil, 0, "")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if crmRoleIDs(roles) != "r1,r2" || total != 2 || roles[0].Description != "Runs sales, and forecasting" || roles[1].ParentId != "r1" || roles[0].ParentId != "" {
+		t.Logf("expected the csv roles with headers matched without case and values trimmed, but got %+v", roles)
+		t.Fail()
+		return
+	}
+
+	// sync requests can carry a license suffix on the tenant id
+	people, total, token, err := client.GetLatestChangedPeople(ctx, crmFileCSVTenant+"::create_and_close", nil, 2, "")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if crmPersonIDs(people) != "p1,p2" || total != 3 || token != "2" {
+		t.Logf("expected the first page of csv people, but got %s of %d with token %q", crmPersonIDs(people), total, token)
+		t.Fail()
+		return
+	}
+	dan := people[1]
+	if strings.Join(dan.RoleIds, ",") != "sr1,sr2" || strings.Join(dan.CrmRoleIds, ",") != "r2,r1" || len(people[0].RoleIds) != 0 {
+		t.Logf("expected list columns to be split on %q, but got %v and %v", crmSnapshotListSep, dan.RoleIds, dan.CrmRoleIds)
+		t.Fail()
+		return
+	}
+	if dan.GroupId != "g1" || dan.Status != orchardPb.BasicStatus_Inactive || !dan.UpdatedAt.AsTime().Equal(time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Logf("expected the csv columns to be read into the person, but got %+v", dan)
+		t.Fail()
+		return
+	}
+
+	people, _, token, err = client.GetLatestChangedPeople(ctx, crmFileCSVTenant, nil, 2, token)
+	if err != nil || crmPersonIDs(people) != "p3" || token != "" {
+		t.Log("expected the last page of csv people, but got", crmPersonIDs(people), token, err)
+		t.Fail()
+		return
+	}
+}
+
+func TestFileCRMSnapshotDir(t *testing.T) {
+	// the snapshot the local server syncs from by default
+	client := NewFileCRMDataSource("../../fixtures/crm")
+	ctx := context.Background()
+
+	roles, total, _, err := client.GetLatestCRMRoles(ctx, "00000000-0000-0000-0000-000000000000", nil, 0, "")
+	if err != nil || len(roles) != 8 || total != 8 {
+		t.Log("expected the 8 default crm roles, but got", len(roles), total, err)
+		t.Fail()
+		return
+	}
+	people, total, _, err := client.GetLatestChangedPeople(ctx, "00000000-0000-0000-0000-000000000000", nil, 0, "")
+	if err != nil || len(people) != 10 || total != 10 {
+		t.Log("expected the 10 default crm people, but got", len(people), total, err)
+		t.Fail()
+		return
+	}
+}
+
+func TestFileCRMMissingSnapshot(t *testing.T) {
+	client := NewFileCRMDataSource(crmFileTestDir)
+	ctx := context.Background()
+
+	roles, total, token, err := client.GetLatestCRMRoles(ctx, crmFileMissingTenant, nil, 10, "")
+	if err != nil || len(roles) != 0 || total != 0 || token != "" {
+		t.Log("expected a tenant without a snapshot to have no roles, but got", len(roles), total, token, err)
+		t.Fail()
+		return
+	}
+	people, total, _, err := client.GetLatestChangedPeople(ctx, crmFileMissingTenant, nil, 10, "")
+	if err != nil || len(people) != 0 || total != 0 {
+		t.Log("expected a tenant without a snapshot to have no people, but got", len(people), total, err)
+		t.Fail()
+		return
+	}
+
+	if _, _, _, err := client.GetLatestCRMRoles(ctx, "", nil, 10, ""); err == nil {
+		t.Log("expected an empty tenant id to be refused")
+		t.Fail()
+		return
+	}
+	// the tenant id can't reach outside the snapshot dir
+	if roles, _, _, err := client.GetLatestCRMRoles(ctx, "../crm/00000000-0000-0000-0000-000000000000", nil, 0, ""); err != nil || len(roles) != 0 {
+		t.Log("expected a tenant id with a path to be confined to the snapshot dir, but got", len(roles), err)
+		t.Fail()
+		return
+	}
+}
+
+func TestFileCRMMalformed(t *testing.T) {
+	client := NewFileCRMDataSource(crmFileTestDir)
+	ctx := context.Background()
+
+	if _, _, _, err := client.GetLatestChangedPeople(ctx, crmFileBadTenant, nil, 0, ""); err == nil {
+		t.Log("expected a truncated json snapshot to fail")
+		t.Fail()
+		return
+	}
+	if _, _, _, err := client.GetLatestCRMRoles(ctx, crmFileBadTenant, nil, 0, ""); err == nil {
+		t.Log("expected a csv row with a bad updated_at to fail")
+		t.Fail()
+		return
+	}
+	if _, _, _, err := client.GetLatestChangedPeople(ctx, crmFileRaggedTenant, nil, 0, ""); err == nil {
+		t.Log("expected a csv row with missing columns to fail")
+		t.Fail()
+		return
+	}
+}
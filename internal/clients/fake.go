@@ -0,0 +1,90 @@
+package clients
+
+import (
+	"sync"
+)
+
+// Call is a single recorded call to a fake client
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// CallRecorder records the calls made to a fake client and can make a method fail, it is embedded by the fakes in this package
+type CallRecorder struct {
+	mu    sync.Mutex
+	calls []Call
+	errs  map[string]error
+}
+
+// record stores the call and returns the error the method was set to fail with, if any
+func (r *CallRecorder) record(method string, args ...interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, Call{Method: method, Args: args})
+	return r.errs[method]
+}
+
+// Calls returns the recorded calls to the given method in the order they were made, or every call if method is empty
+func (r *CallRecorder) Calls(method string) []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	calls := []Call{}
+	for _, call := range r.calls {
+		if method == "" || call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (r *CallRecorder) CallCount(method string) int {
+	return len(r.Calls(method))
+}
+
+// FailWith makes every following call to the method return err, a nil err clears it
+func (r *CallRecorder) FailWith(method string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.errs == nil {
+		r.errs = map[string]error{}
+	}
+	if err == nil {
+		delete(r.errs, method)
+		return
+	}
+	r.errs[method] = err
+}
+
+// Reset forgets the recorded calls and configured errors
+func (r *CallRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = nil
+	r.errs = nil
+}
+
+// Fakes are in-memory stand-ins for every external client orchard talks to, so handlers can run without network access
+type Fakes struct {
+	Tenant   *FakeTenantService
+	CRM      *FakeCRMDataSource
+	Identity *FakeIdentityProvider
+	Bouncer  *FakeBouncer
+}
+
+func NewFakes() *Fakes {
+	return &Fakes{
+		Tenant:   NewFakeTenantService(),
+		CRM:      NewFakeCRMDataSource(),
+		Identity: NewFakeIdentityProvider(),
+		Bouncer:  NewFakeBouncer(),
+	}
+}
+
+// Reset clears the recorded calls of every fake, seeded data is kept
+func (f *Fakes) Reset() {
+	f.Tenant.CallRecorder.Reset()
+	f.CRM.CallRecorder.Reset()
+	f.Identity.CallRecorder.Reset()
+	f.Bouncer.CallRecorder.Reset()
+}
@@ -0,0 +1,55 @@
+package clients
+
+import (
+	"context"
+
+	"github.com/loupe-co/orchard/internal/models"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+)
+
+// IdentityProvider is where provisioned people get their login, Auth0Client is the production implementation
+type IdentityProvider interface {
+	// Provision creates or updates the login for a set of person records sharing an email, one tenant context per record
+	Provision(ctx context.Context, personRecords []*models.Person) error
+	// Unprovision removes the login for the given person, returns a NotFound error if there isn't one
+	Unprovision(ctx context.Context, tenantID, userID string) error
+	GetIdentityByUserID(ctx context.Context, tenantID, userID string) (*Identity, error)
+	GetIdentitiesByEmail(ctx context.Context, tenantID, email string) ([]*Identity, error)
+	ListTenantIdentities(ctx context.Context, tenantID string) ([]*Identity, error)
+	// ImportUsers lists the licensed users of a tenant as people
+	ImportUsers(ctx context.Context, tenantID string) ([]*orchardPb.Person, error)
+	GetRoleUsers(ctx context.Context, roleID string) ([]*orchardPb.Person, error)
+}
+
+// Identity is the provisioning state of a single login in the identity provider
+type Identity struct {
+	UserID         string          `json:"user_id"`
+	Email          string          `json:"email"`
+	PersonID       string          `json:"person_id"`
+	TenantID       string          `json:"tenant_id"`
+	License        Auth0License    `json:"license"`
+	TenantContexts []TenantContext `json:"tenant_contexts"`
+}
+
+// BuildTenantContexts gets the tenant contexts for a set of person records sharing an email, the earliest created record is the primary
+func BuildTenantContexts(personRecords []*models.Person) ([]TenantContext, *models.Person) {
+	if len(personRecords) == 0 {
+		return nil, nil
+	}
+
+	primaryIndex := 0
+	tenantContexts := make([]TenantContext, len(personRecords))
+	for i, person := range personRecords {
+		if person.CreatedAt.Before(personRecords[primaryIndex].CreatedAt) {
+			primaryIndex = i
+		}
+		tenantContexts[i] = TenantContext{
+			TenantID:  person.TenantID,
+			UserID:    person.ID,
+			IsPrimary: false,
+		}
+	}
+	tenantContexts[primaryIndex].IsPrimary = true
+
+	return tenantContexts, personRecords[primaryIndex]
+}
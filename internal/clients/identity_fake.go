@@ -0,0 +1,81 @@
+package clients
+
+import (
+	"context"
+
+	"github.com/loupe-co/orchard/internal/models"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+)
+
+// FakeIdentityProvider is a MemoryIdentityProvider that also records its calls
+type FakeIdentityProvider struct {
+	CallRecorder
+	*MemoryIdentityProvider
+}
+
+var _ IdentityProvider = (*FakeIdentityProvider)(nil)
+
+func NewFakeIdentityProvider() *FakeIdentityProvider {
+	return &FakeIdentityProvider{MemoryIdentityProvider: NewMemoryIdentityProvider()}
+}
+
+func (fp *FakeIdentityProvider) Provision(ctx context.Context, personRecords []*models.Person) error {
+	if err := fp.record("Provision", personRecords); err != nil {
+		return err
+	}
+	return fp.MemoryIdentityProvider.Provision(ctx, personRecords)
+}
+
+func (fp *FakeIdentityProvider) Unprovision(ctx context.Context, tenantID, userID string) error {
+	if err := fp.record("Unprovision", tenantID, userID); err != nil {
+		return err
+	}
+	return fp.MemoryIdentityProvider.Unprovision(ctx, tenantID, userID)
+}
+
+func (fp *FakeIdentityProvider) GetIdentityByUserID(ctx context.Context, tenantID, userID string) (*Identity, error) {
+	if err := fp.record("GetIdentityByUserID", tenantID, userID); err != nil {
+		return nil, err
+	}
+	return fp.MemoryIdentityProvider.GetIdentityByUserID(ctx, tenantID, userID)
+}
+
+func (fp *FakeIdentityProvider) GetIdentitiesByEmail(ctx context.Context, tenantID, email string) ([]*Identity, error) {
+	if err := fp.record("GetIdentitiesByEmail", tenantID, email); err != nil {
+		return nil, err
+	}
+	return fp.MemoryIdentityProvider.GetIdentitiesByEmail(ctx, tenantID, email)
+}
+
+func (fp *FakeIdentityProvider) ListTenantIdentities(ctx context.Context, tenantID string) ([]*Identity, error) {
+	if err := fp.record("ListTenantIdentities", tenantID); err != nil {
+		return nil, err
+	}
+	return fp.MemoryIdentityProvider.ListTenantIdentities(ctx, tenantID)
+}
+
+func (fp *FakeIdentityProvider) ImportUsers(ctx context.Context, tenantID string) ([]*orchardPb.Person, error) {
+	if err := fp.record("ImportUsers", tenantID); err != nil {
+		return nil, err
+	}
+	return fp.MemoryIdentityProvider.ImportUsers(ctx, tenantID)
+}
+
+func (fp *FakeIdentityProvider) GetRoleUsers(ctx context.Context, roleID string) ([]*orchardPb.Person, error) {
+	if err := fp.record("GetRoleUsers", roleID); err != nil {
+		return nil, err
+	}
+	return fp.MemoryIdentityProvider.GetRoleUsers(ctx, roleID)
+}
+
+// ProvisionedEmails returns the primary email of every Provision call, in order
+func (fp *FakeIdentityProvider) ProvisionedEmails() []string {
+	emails := []string{}
+	for _, call := range fp.Calls("Provision") {
+		records, _ := call.Args[0].([]*models.Person)
+		if _, primary := BuildTenantContexts(records); primary != nil {
+			emails = append(emails, primary.Email.String)
+		}
+	}
+	return emails
+}
@@ -0,0 +1,225 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/orchard/internal/models"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	"google.golang.org/grpc/codes"
+)
+
+// MemoryIdentityProvider is an in-memory IdentityProvider for tests and local development, it follows the same
+// provisioning rules as Auth0Client without talking to auth0.
+type MemoryIdentityProvider struct {
+	mu    sync.RWMutex
+	users map[string]*Identity
+	roles map[string][]string
+}
+
+var _ IdentityProvider = (*MemoryIdentityProvider)(nil)
+var _ IdentityProvider = (*Auth0Client)(nil)
+
+func NewMemoryIdentityProvider() *MemoryIdentityProvider {
+	return &MemoryIdentityProvider{
+		users: map[string]*Identity{},
+		roles: map[string][]string{},
+	}
+}
+
+func (mp *MemoryIdentityProvider) Provision(ctx context.Context, personRecords []*models.Person) error {
+	if len(personRecords) == 0 {
+		return errors.New("personRecords cannot be empty for provisioning").WithCode(codes.InvalidArgument)
+	}
+
+	tenantContexts, primary := BuildTenantContexts(personRecords)
+	email := strings.TrimSpace(primary.Email.String)
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	var identity *Identity
+	if existing := mp.searchByEmail(email); len(existing) > 0 {
+		identity = existing[0]
+	} else {
+		identity = &Identity{
+			UserID: fmt.Sprintf("email|%s", uuid.NewString()),
+			Email:  email,
+		}
+		mp.users[identity.UserID] = identity
+	}
+
+	identity.PersonID = primary.ID
+	identity.TenantID = primary.TenantID
+	identity.License = Auth0License{IsActive: true}
+	identity.TenantContexts = tenantContexts
+
+	return nil
+}
+
+func (mp *MemoryIdentityProvider) Unprovision(ctx context.Context, tenantID, userID string) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	identity := mp.getByUserID(tenantID, userID)
+	if identity == nil {
+		return errors.New("user not found in identity provider").WithCode(codes.NotFound)
+	}
+
+	delete(mp.users, identity.UserID)
+	for roleID, members := range mp.roles {
+		kept := members[:0]
+		for _, member := range members {
+			if member != identity.UserID {
+				kept = append(kept, member)
+			}
+		}
+		mp.roles[roleID] = kept
+	}
+
+	return nil
+}
+
+func (mp *MemoryIdentityProvider) GetIdentityByUserID(ctx context.Context, tenantID, userID string) (*Identity, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	identity := mp.getByUserID(tenantID, userID)
+	if identity == nil {
+		return nil, nil
+	}
+	return copyIdentity(identity), nil
+}
+
+func (mp *MemoryIdentityProvider) GetIdentitiesByEmail(ctx context.Context, tenantID, email string) ([]*Identity, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	users := mp.searchByEmail(email)
+	identities := make([]*Identity, len(users))
+	for i, identity := range users {
+		identities[i] = copyIdentity(identity)
+	}
+	return identities, nil
+}
+
+func (mp *MemoryIdentityProvider) ListTenantIdentities(ctx context.Context, tenantID string) ([]*Identity, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	identities := []*Identity{}
+	for _, identity := range mp.sortedUsers() {
+		if identity.TenantID == tenantID || hasTenantContext(identity, tenantID) {
+			identities = append(identities, copyIdentity(identity))
+		}
+	}
+	return identities, nil
+}
+
+func (mp *MemoryIdentityProvider) ImportUsers(ctx context.Context, tenantID string) ([]*orchardPb.Person, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	people := []*orchardPb.Person{}
+	for _, identity := range mp.sortedUsers() {
+		if !identity.License.IsActive {
+			continue
+		}
+		if identity.TenantID == tenantID || hasTenantContext(identity, tenantID) {
+			people = append(people, identityToPerson(identity))
+		}
+	}
+	return people, nil
+}
+
+func (mp *MemoryIdentityProvider) GetRoleUsers(ctx context.Context, roleID string) ([]*orchardPb.Person, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	people := []*orchardPb.Person{}
+	for _, userID := range mp.roles[roleID] {
+		if identity, ok := mp.users[userID]; ok {
+			people = append(people, identityToPerson(identity))
+		}
+	}
+	return people, nil
+}
+
+// AddRoleUsers assigns the given identity provider user ids to a role
+func (mp *MemoryIdentityProvider) AddRoleUsers(roleID string, userIDs ...string) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.roles[roleID] = append(mp.roles[roleID], userIDs...)
+}
+
+// SetIdentity adds or replaces an identity directly, useful for seeding drift between orchard and the identity provider
+func (mp *MemoryIdentityProvider) SetIdentity(identity *Identity) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if identity.UserID == "" {
+		identity.UserID = fmt.Sprintf("email|%s", uuid.NewString())
+	}
+	mp.users[identity.UserID] = copyIdentity(identity)
+}
+
+func (mp *MemoryIdentityProvider) getByUserID(tenantID, userID string) *Identity {
+	for _, identity := range mp.sortedUsers() {
+		if identity.TenantID == tenantID && identity.PersonID == userID {
+			return identity
+		}
+		for _, tc := range identity.TenantContexts {
+			if tc.TenantID == tenantID && tc.UserID == userID {
+				return identity
+			}
+		}
+	}
+	return nil
+}
+
+func (mp *MemoryIdentityProvider) searchByEmail(email string) []*Identity {
+	email = strings.ToLower(strings.TrimSpace(email))
+	users := []*Identity{}
+	for _, identity := range mp.sortedUsers() {
+		if strings.ToLower(identity.Email) == email {
+			users = append(users, identity)
+		}
+	}
+	return users
+}
+
+func (mp *MemoryIdentityProvider) sortedUsers() []*Identity {
+	users := make([]*Identity, 0, len(mp.users))
+	for _, identity := range mp.users {
+		users = append(users, identity)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].UserID < users[j].UserID })
+	return users
+}
+
+func hasTenantContext(identity *Identity, tenantID string) bool {
+	for _, tc := range identity.TenantContexts {
+		if tc.TenantID == tenantID {
+			return true
+		}
+	}
+	return false
+}
+
+func copyIdentity(identity *Identity) *Identity {
+	c := *identity
+	c.TenantContexts = append([]TenantContext(nil), identity.TenantContexts...)
+	return &c
+}
+
+func identityToPerson(identity *Identity) *orchardPb.Person {
+	return &orchardPb.Person{
+		Id:       identity.PersonID,
+		TenantId: identity.TenantID,
+		Email:    identity.Email,
+	}
+}
@@ -14,6 +14,16 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// TenantService is how orchard reads tenant data, TenantClient (tenant-service) is the production implementation
+type TenantService interface {
+	GetProvisionedUsers(ctx context.Context, tenantID string) ([]*orchardPb.Person, error)
+	GetTenantLastFullDataSync(ctx context.Context, tenantID string) (*timestamppb.Timestamp, error)
+	GetTenantByID(ctx context.Context, tenantID string) (*tenant.Tenant, error)
+	IsOutreachUserSyncEnabled(ctx context.Context, tenantData *tenant.Tenant) (bool, error)
+}
+
+var _ TenantService = (*TenantClient)(nil)
+
 type TenantClient struct {
 	conn   *grpc.ClientConn
 	client servicePb.TenantClient
@@ -0,0 +1,85 @@
+package clients
+
+import (
+	"context"
+	"sync"
+
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	"github.com/loupe-co/protos/src/common/tenant"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// FakeTenantService is an in-memory TenantService that records its calls
+type FakeTenantService struct {
+	CallRecorder
+
+	mu               sync.RWMutex
+	tenants          map[string]*tenant.Tenant
+	lastFullDataSync map[string]*timestamppb.Timestamp
+	provisionedUsers map[string][]*orchardPb.Person
+}
+
+var _ TenantService = (*FakeTenantService)(nil)
+
+func NewFakeTenantService() *FakeTenantService {
+	return &FakeTenantService{
+		tenants:          map[string]*tenant.Tenant{},
+		lastFullDataSync: map[string]*timestamppb.Timestamp{},
+		provisionedUsers: map[string][]*orchardPb.Person{},
+	}
+}
+
+func (client *FakeTenantService) SetTenant(t *tenant.Tenant) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.tenants[t.Id] = t
+}
+
+func (client *FakeTenantService) SetLastFullDataSync(tenantID string, lastSync *timestamppb.Timestamp) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.lastFullDataSync[tenantID] = lastSync
+}
+
+func (client *FakeTenantService) SetProvisionedUsers(tenantID string, people ...*orchardPb.Person) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.provisionedUsers[tenantID] = people
+}
+
+func (client *FakeTenantService) GetProvisionedUsers(ctx context.Context, tenantID string) ([]*orchardPb.Person, error) {
+	if err := client.record("GetProvisionedUsers", tenantID); err != nil {
+		return nil, err
+	}
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	return client.provisionedUsers[tenantID], nil
+}
+
+func (client *FakeTenantService) GetTenantLastFullDataSync(ctx context.Context, tenantID string) (*timestamppb.Timestamp, error) {
+	if err := client.record("GetTenantLastFullDataSync", tenantID); err != nil {
+		return nil, err
+	}
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	// a tenant that never had a full data sync has no timestamp
+	return client.lastFullDataSync[tenantID], nil
+}
+
+// GetTenantByID returns nil for unknown tenants, the same as TenantClient
+func (client *FakeTenantService) GetTenantByID(ctx context.Context, tenantID string) (*tenant.Tenant, error) {
+	if err := client.record("GetTenantByID", tenantID); err != nil {
+		return nil, err
+	}
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+	return client.tenants[tenantID], nil
+}
+
+func (client *FakeTenantService) IsOutreachUserSyncEnabled(ctx context.Context, tenantData *tenant.Tenant) (bool, error) {
+	if err := client.record("IsOutreachUserSyncEnabled", tenantData); err != nil {
+		return false, err
+	}
+	// the setting is read from the tenant's data sync settings, which doesn't need tenant-service
+	return (&TenantClient{}).IsOutreachUserSyncEnabled(ctx, tenantData)
+}
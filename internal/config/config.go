@@ -4,6 +4,7 @@ type Config struct {
 	ProjectID             string `env:"PROJECT_ID" envDefault:"local"`
 	GRPCHost              string `env:"GRPC_HOST" envDefault:"127.0.0.1"`
 	GRPCPort              int    `env:"GRPC_PORT" envDefault:"50051"`
+	SCIMPort              int    `env:"SCIM_PORT" envDefault:"0"`
 	DBHost                string `env:"DB_HOST" envDefault:"127.0.0.1"`
 	DBPort                int    `env:"DB_PORT" envDefault:"5432"`
 	DBPassword            string `env:"DB_PASSWORD" envDefault:"" yaml:"postgres_password"`
@@ -13,6 +14,8 @@ type Config struct {
 	DBDebug               bool   `env:"DB_DEBUG" envDefault:"false"`
 	TenantServiceAddr     string `env:"TENANT_SERVICE_ADDR" envDefault:""`
 	CRMServiceAddr        string `env:"CRM_SERVICE_ADDR" envDefault:""`
+	CRMDataSource         string `env:"CRM_DATA_SOURCE" envDefault:"grpc"`
+	CRMSnapshotDir        string `env:"CRM_SNAPSHOT_DIR" envDefault:"./fixtures/crm"`
 	Auth0Issuer           string `env:"AUTH_0_ISSUER" envDefault:"auth.loupe.co"`
 	Auth0Audience         string `env:"AUTH_0_AUDIENCE" envDefault:"Ub9IKZnGYUh7oM42iPBumI32cLWmVNWC"`
 	Auth0Domain           string `env:"AUTH_0_DOMAIN" envDefault:"https://loupe.auth0.com/"`
@@ -22,11 +25,24 @@ type Config struct {
 	Auth0RoleIDAdmin      string `env:"AUTH_0_ROLE_ADMIN" envDefault:"rol_6tBbx6gNRYgb47wM"`
 	Auth0RoleIDManager    string `env:"AUTH_0_ROLE_MANAGER" envDefault:"rol_510TUetL44xR7zmm"`
 	Auth0RoleIDUser       string `env:"AUTH_0_ROLE_USER" envDefault:"rol_JbKBz2HaApjrd7yW"`
+	IdentityProvider      string `env:"IDENTITY_PROVIDER" envDefault:"auth0"`
 	BouncerAddr           string `env:"BOUNCER_ADDR" envDefault:"" json:"bouncerAddr"`
 	RedisHost             string `env:"REDIS_HOST" envDefault:""`
 	RedisUser             string `env:"REDIS_USER" envDefault:""`
 	RedisPassword         string `env:"REDIS_PASS" envDefault:"" yaml:"redis_password"`
-	SentryDSN             string `env:"SENTRY_DSN" envDefault:"" yaml:"sentry_dsn" exportENV:"SENTRY_DSN"`
-	SyncUsersBatchSize    int    `env:"SYNC_USERS_BATCH_SIZE" envDefault:"1000"`
-	SyncRolesBatchSize    int    `env:"SYNC_ROLES_BATCH_SIZE" envDefault:"500"`
+	// Hierarchy reads are cached in redis, memory or not at all (none)
+	HierarchyCache           string `env:"HIERARCHY_CACHE" envDefault:"none"`
+	HierarchyCacheTTLSeconds int    `env:"HIERARCHY_CACHE_TTL_SECONDS" envDefault:"600"`
+	SentryDSN                string `env:"SENTRY_DSN" envDefault:"" yaml:"sentry_dsn" exportENV:"SENTRY_DSN"`
+	SyncUsersBatchSize       int    `env:"SYNC_USERS_BATCH_SIZE" envDefault:"1000"`
+	SyncRolesBatchSize       int    `env:"SYNC_ROLES_BATCH_SIZE" envDefault:"500"`
+	OutboxBatchSize          int    `env:"OUTBOX_BATCH_SIZE" envDefault:"100"`
+	OutboxIntervalSeconds    int    `env:"OUTBOX_INTERVAL_SECONDS" envDefault:"5"`
+	OutboxMaxAttempts        int    `env:"OUTBOX_MAX_ATTEMPTS" envDefault:"10"`
+	// Expired group viewer grants are deleted, and bouncer told about started and ended ones, on this interval
+	GroupViewerSweepIntervalSeconds int `env:"GROUP_VIEWER_SWEEP_INTERVAL_SECONDS" envDefault:"60"`
+	// Auth0 reconciliation is disabled unless an interval is set
+	Auth0ReconcileIntervalMinutes int    `env:"AUTH_0_RECONCILE_INTERVAL_MINUTES" envDefault:"0"`
+	Auth0ReconcileFix             bool   `env:"AUTH_0_RECONCILE_FIX" envDefault:"false"`
+	Auth0ReconcileSourceOfTruth   string `env:"AUTH_0_RECONCILE_SOURCE_OF_TRUTH" envDefault:"orchard"`
 }
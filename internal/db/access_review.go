@@ -0,0 +1,361 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+const (
+	AccessReviewStatusOpen   = "open"
+	AccessReviewStatusClosed = "closed"
+
+	AccessReviewKindGroupViewer = "group_viewer"
+	AccessReviewKindSystemRole  = "system_role"
+
+	AccessReviewDecisionPending = "pending"
+	AccessReviewDecisionKeep    = "keep"
+	AccessReviewDecisionRevoke  = "revoke"
+
+	AccessReviewOutcomeKept    = "kept"
+	AccessReviewOutcomeRevoked = "revoked"
+	// AccessReviewOutcomeGone is an item that wasn't kept but whose grant or role assignment was already gone at close
+	AccessReviewOutcomeGone = "gone"
+)
+
+// ErrAccessReviewNotOpen is returned when a campaign that has to be open isn't, or doesn't exist
+var ErrAccessReviewNotOpen = fmt.Errorf("access review campaign isn't open")
+
+// AccessReviewCampaign is a tenant's review of its group viewer grants and elevated system role assignments
+type AccessReviewCampaign struct {
+	ID       string `boil:"id" json:"id"`
+	TenantID string `boil:"tenant_id" json:"tenant_id"`
+	Name     string `boil:"name" json:"name"`
+	Status   string `boil:"status" json:"status"`
+	// SystemRoleTypes are the system role types whose assignments are reviewed
+	SystemRoleTypes types.StringArray `boil:"system_role_types" json:"system_role_types"`
+	DueAt           null.Time         `boil:"due_at" json:"due_at,omitempty"`
+	CreatedBy       string            `boil:"created_by" json:"created_by"`
+	CreatedAt       time.Time         `boil:"created_at" json:"created_at"`
+	ClosedBy        null.String       `boil:"closed_by" json:"closed_by,omitempty"`
+	ClosedAt        null.Time         `boil:"closed_at" json:"closed_at,omitempty"`
+	UpdatedAt       time.Time         `boil:"updated_at" json:"updated_at"`
+}
+
+// AccessReviewItem is one grant or role assignment under review, as it was when the campaign opened
+type AccessReviewItem struct {
+	ID           string      `boil:"id" json:"id"`
+	CampaignID   string      `boil:"campaign_id" json:"campaign_id"`
+	TenantID     string      `boil:"tenant_id" json:"tenant_id"`
+	Kind         string      `boil:"kind" json:"kind"`
+	PersonID     string      `boil:"person_id" json:"person_id"`
+	GroupID      null.String `boil:"group_id" json:"group_id,omitempty"`
+	SystemRoleID null.String `boil:"system_role_id" json:"system_role_id,omitempty"`
+	Permissions  int64       `boil:"permissions" json:"permissions"`
+	ReviewerID   string      `boil:"reviewer_id" json:"reviewer_id"`
+	Decision     string      `boil:"decision" json:"decision"`
+	DecidedBy    null.String `boil:"decided_by" json:"decided_by,omitempty"`
+	DecidedAt    null.Time   `boil:"decided_at" json:"decided_at,omitempty"`
+	Comment      null.String `boil:"comment" json:"comment,omitempty"`
+	Outcome      null.String `boil:"outcome" json:"outcome,omitempty"`
+	CreatedAt    time.Time   `boil:"created_at" json:"created_at"`
+	UpdatedAt    time.Time   `boil:"updated_at" json:"updated_at"`
+}
+
+// accessReviewSubject is a grant or role assignment found by the snapshot, with the person's manager
+type accessReviewSubject struct {
+	Kind         string      `boil:"kind"`
+	PersonID     string      `boil:"person_id"`
+	GroupID      null.String `boil:"group_id"`
+	SystemRoleID null.String `boil:"system_role_id"`
+	Permissions  int64       `boil:"permissions"`
+	ManagerID    null.String `boil:"manager_id"`
+}
+
+// newAccessReviewItem makes the pending item for a snapshotted subject. The reviewer is the person's manager, or whoever opened
+// the campaign for people without one, nobody reviews their own access.
+func newAccessReviewItem(campaign *AccessReviewCampaign, subject *accessReviewSubject) *AccessReviewItem {
+	reviewerID := subject.ManagerID.String
+	if reviewerID == "" || reviewerID == subject.PersonID {
+		reviewerID = campaign.CreatedBy
+	}
+	return &AccessReviewItem{
+		ID:           MakeID(),
+		CampaignID:   campaign.ID,
+		TenantID:     campaign.TenantID,
+		Kind:         subject.Kind,
+		PersonID:     subject.PersonID,
+		GroupID:      subject.GroupID,
+		SystemRoleID: subject.SystemRoleID,
+		Permissions:  subject.Permissions,
+		ReviewerID:   reviewerID,
+		Decision:     AccessReviewDecisionPending,
+		CreatedAt:    campaign.CreatedAt,
+		UpdatedAt:    campaign.CreatedAt,
+	}
+}
+
+type AccessReviewService struct {
+	*DBService
+}
+
+func (db *DB) NewAccessReviewService() AccessReviewRepository {
+	return &AccessReviewService{
+		DBService: db.NewDBService(),
+	}
+}
+
+const (
+	insertAccessReviewCampaignQuery = `INSERT INTO access_review_campaign (id, tenant_id, name, status, system_role_types, due_at, created_by, created_at, updated_at)
+	VALUES ($1, $2, $3, 'open', $4, $5, $6, $7, $7);`
+
+	// Grants that already ended are left out, the sweeper removes them anyway
+	accessReviewSubjectsQuery = `SELECT 'group_viewer' AS kind, gv.person_id, gv.group_id, NULL AS system_role_id, gv.permissions, p.manager_id
+	FROM group_viewer gv INNER JOIN person p ON p.id::TEXT = gv.person_id::TEXT AND p.tenant_id::TEXT = gv.tenant_id::TEXT
+	WHERE gv.tenant_id::TEXT = $1 AND (gv.ends_at IS NULL OR gv.ends_at > $3)
+	UNION ALL
+	SELECT 'system_role' AS kind, p.id AS person_id, NULL AS group_id, r.role_id AS system_role_id, 0 AS permissions, p.manager_id
+	FROM person p CROSS JOIN LATERAL UNNEST(p.role_ids) AS r (role_id)
+		INNER JOIN system_role sr ON sr.id::TEXT = r.role_id
+	WHERE p.tenant_id::TEXT = $1 AND sr.type = ANY ($2)
+	ORDER BY kind, person_id, group_id, system_role_id;`
+
+	insertAccessReviewItemsQuery = `INSERT INTO access_review_item
+		(id, campaign_id, tenant_id, kind, person_id, group_id, system_role_id, permissions, reviewer_id, decision, created_at, updated_at)
+	SELECT id, $2, $3, kind, person_id, NULLIF(group_id, ''), NULLIF(system_role_id, ''), permissions, reviewer_id, 'pending', $4, $4
+	FROM UNNEST($1::UUID[], $5::TEXT[], $6::TEXT[], $7::TEXT[], $8::TEXT[], $9::BIGINT[], $10::TEXT[])
+		AS i (id, kind, person_id, group_id, system_role_id, permissions, reviewer_id);`
+)
+
+// Open inserts the campaign and snapshots the tenant's group viewer grants and its assignments of the campaign's system role
+// types as pending items, returning them
+func (svc *AccessReviewService) Open(ctx context.Context, campaign *AccessReviewCampaign) ([]*AccessReviewItem, error) {
+	spanCtx, span := log.StartSpan(ctx, "AccessReview.Open")
+	defer span.End()
+
+	campaign.Status = AccessReviewStatusOpen
+	_, err := queries.Raw(insertAccessReviewCampaignQuery, campaign.ID, campaign.TenantID, campaign.Name, campaign.SystemRoleTypes,
+		campaign.DueAt, campaign.CreatedBy, campaign.CreatedAt).ExecContext(spanCtx, svc.GetContextExecutor())
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolationCode {
+		return nil, fmt.Errorf("tenant already has an open access review campaign")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	subjects := []*accessReviewSubject{}
+	err = queries.Raw(accessReviewSubjectsQuery, campaign.TenantID, pq.Array([]string(campaign.SystemRoleTypes)), campaign.CreatedAt).
+		Bind(spanCtx, svc.GetContextExecutor(), &subjects)
+	if err != nil && err != sql.ErrNoRows {
+		log.WithTenantID(campaign.TenantID).WithCustom("query", accessReviewSubjectsQuery).Error(err)
+		return nil, err
+	}
+
+	items := make([]*AccessReviewItem, len(subjects))
+	var ids, kinds, personIDs, groupIDs, roleIDs, reviewerIDs []string
+	var permissions []int64
+	for i, subject := range subjects {
+		items[i] = newAccessReviewItem(campaign, subject)
+		ids = append(ids, items[i].ID)
+		kinds = append(kinds, items[i].Kind)
+		personIDs = append(personIDs, items[i].PersonID)
+		groupIDs = append(groupIDs, items[i].GroupID.String)
+		roleIDs = append(roleIDs, items[i].SystemRoleID.String)
+		permissions = append(permissions, items[i].Permissions)
+		reviewerIDs = append(reviewerIDs, items[i].ReviewerID)
+	}
+	if len(items) == 0 {
+		return items, nil
+	}
+
+	_, err = queries.Raw(insertAccessReviewItemsQuery, pq.Array(ids), campaign.ID, campaign.TenantID, campaign.CreatedAt,
+		pq.Array(kinds), pq.Array(personIDs), pq.Array(groupIDs), pq.Array(roleIDs), pq.Array(permissions), pq.Array(reviewerIDs)).
+		ExecContext(spanCtx, svc.GetContextExecutor())
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+const (
+	getAccessReviewCampaignQuery = `SELECT * FROM access_review_campaign WHERE tenant_id = $1 AND id = $2;`
+
+	getAccessReviewCampaignsQuery = `SELECT * FROM access_review_campaign
+	WHERE tenant_id = $1 AND ($2 = '' OR status = $2)
+	ORDER BY created_at DESC;`
+
+	getAccessReviewItemsQuery = `SELECT * FROM access_review_item
+	WHERE tenant_id = $1 AND campaign_id = $2 AND ($3 = '' OR reviewer_id = $3) AND ($4 = '' OR decision = $4)
+	ORDER BY kind, person_id, group_id, system_role_id;`
+)
+
+// GetCampaign returns sql.ErrNoRows if the tenant has no campaign with id
+func (svc *AccessReviewService) GetCampaign(ctx context.Context, tenantID, id string) (*AccessReviewCampaign, error) {
+	spanCtx, span := log.StartSpan(ctx, "AccessReview.GetCampaign")
+	defer span.End()
+
+	campaign := &AccessReviewCampaign{}
+	if err := queries.Raw(getAccessReviewCampaignQuery, tenantID, id).Bind(spanCtx, svc.GetContextExecutor(), campaign); err != nil {
+		return nil, err
+	}
+	return campaign, nil
+}
+
+// GetCampaigns lists a tenant's campaigns newest first, an empty status lists them all
+func (svc *AccessReviewService) GetCampaigns(ctx context.Context, tenantID, status string) ([]*AccessReviewCampaign, error) {
+	spanCtx, span := log.StartSpan(ctx, "AccessReview.GetCampaigns")
+	defer span.End()
+
+	results := []*AccessReviewCampaign{}
+	if err := queries.Raw(getAccessReviewCampaignsQuery, tenantID, status).Bind(spanCtx, svc.GetContextExecutor(), &results); err != nil && err != sql.ErrNoRows {
+		log.WithTenantID(tenantID).WithCustom("query", getAccessReviewCampaignsQuery).Error(err)
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetItems lists a campaign's items, optionally only the ones for a reviewer or with a decision
+func (svc *AccessReviewService) GetItems(ctx context.Context, tenantID, campaignID, reviewerID, decision string) ([]*AccessReviewItem, error) {
+	spanCtx, span := log.StartSpan(ctx, "AccessReview.GetItems")
+	defer span.End()
+
+	results := []*AccessReviewItem{}
+	err := queries.Raw(getAccessReviewItemsQuery, tenantID, campaignID, reviewerID, decision).Bind(spanCtx, svc.GetContextExecutor(), &results)
+	if err != nil && err != sql.ErrNoRows {
+		log.WithTenantID(tenantID).WithCustom("query", getAccessReviewItemsQuery).Error(err)
+		return nil, err
+	}
+	return results, nil
+}
+
+const (
+	decideAccessReviewItemQuery = `UPDATE access_review_item i SET decision = $4, decided_by = $5, decided_at = $6, comment = NULLIF($7, ''), updated_at = $6
+	FROM access_review_campaign c
+	WHERE i.tenant_id = $1 AND i.campaign_id = $2 AND i.id = $3 AND c.id = i.campaign_id AND c.status = 'open';`
+)
+
+// Decide records a keep or revoke decision on an item, decisions can be changed until the campaign closes
+func (svc *AccessReviewService) Decide(ctx context.Context, tenantID, campaignID, itemID, decision, decidedBy, comment string, at time.Time) error {
+	spanCtx, span := log.StartSpan(ctx, "AccessReview.Decide")
+	defer span.End()
+
+	res, err := queries.Raw(decideAccessReviewItemQuery, tenantID, campaignID, itemID, decision, decidedBy, at, comment).ExecContext(spanCtx, svc.GetContextExecutor())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n != 1 {
+		return ErrAccessReviewNotOpen
+	}
+	return nil
+}
+
+const (
+	closeAccessReviewCampaignQuery = `UPDATE access_review_campaign SET status = 'closed', closed_by = $3, closed_at = $4, updated_at = $4
+	WHERE tenant_id = $1 AND id = $2 AND status = 'open';`
+
+	// Items that weren't kept are revoked when their grant or role assignment still exists, the rest were already removed
+	markRevokedGroupViewerItemsQuery = `UPDATE access_review_item i SET outcome = 'revoked', updated_at = $3
+	FROM group_viewer gv
+	WHERE i.tenant_id = $1 AND i.campaign_id = $2 AND i.kind = 'group_viewer' AND i.decision <> 'keep'
+		AND gv.tenant_id::TEXT = i.tenant_id AND gv.person_id::TEXT = i.person_id AND gv.group_id::TEXT = i.group_id;`
+
+	revokeGroupViewersQuery = `DELETE FROM group_viewer gv
+	USING access_review_item i
+	WHERE i.tenant_id = $1 AND i.campaign_id = $2 AND i.kind = 'group_viewer' AND i.outcome = 'revoked'
+		AND gv.tenant_id::TEXT = i.tenant_id AND gv.person_id::TEXT = i.person_id AND gv.group_id::TEXT = i.group_id;`
+
+	markRevokedSystemRoleItemsQuery = `UPDATE access_review_item i SET outcome = 'revoked', updated_at = $3
+	FROM person p
+	WHERE i.tenant_id = $1 AND i.campaign_id = $2 AND i.kind = 'system_role' AND i.decision <> 'keep'
+		AND p.tenant_id::TEXT = i.tenant_id AND p.id::TEXT = i.person_id AND i.system_role_id = ANY (p.role_ids);`
+
+	revokeSystemRolesQuery = `UPDATE person p SET
+		role_ids = ARRAY(SELECT r FROM UNNEST(p.role_ids) AS r WHERE r <> ALL (t.role_ids)),
+		role_ids_locked = TRUE,
+		updated_at = $3,
+		updated_by = $4
+	FROM (
+		SELECT person_id, ARRAY_AGG(system_role_id) AS role_ids
+		FROM access_review_item
+		WHERE tenant_id = $1 AND campaign_id = $2 AND kind = 'system_role' AND outcome = 'revoked'
+		GROUP BY person_id
+	) t
+	WHERE p.tenant_id::TEXT = $1 AND p.id::TEXT = t.person_id;`
+
+	markRemainingItemsQuery = `UPDATE access_review_item SET outcome = CASE WHEN decision = 'keep' THEN 'kept' ELSE 'gone' END, updated_at = $3
+	WHERE tenant_id = $1 AND campaign_id = $2 AND outcome IS NULL;`
+)
+
+// Close closes an open campaign and revokes every item that wasn't kept, rejected and unreviewed alike: group viewer grants are
+// deleted and system roles taken off the person, whose roles are then locked so user sync doesn't give them back. Should run in a transaction. It returns the items with their outcomes.
+func (svc *AccessReviewService) Close(ctx context.Context, tenantID, campaignID, closedBy string, at time.Time) ([]*AccessReviewItem, error) {
+	spanCtx, span := log.StartSpan(ctx, "AccessReview.Close")
+	defer span.End()
+
+	res, err := queries.Raw(closeAccessReviewCampaignQuery, tenantID, campaignID, closedBy, at).ExecContext(spanCtx, svc.GetContextExecutor())
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n != 1 {
+		return nil, ErrAccessReviewNotOpen
+	}
+
+	steps := []struct {
+		query string
+		args  []interface{}
+	}{
+		{markRevokedGroupViewerItemsQuery, []interface{}{tenantID, campaignID, at}},
+		{revokeGroupViewersQuery, []interface{}{tenantID, campaignID}},
+		{markRevokedSystemRoleItemsQuery, []interface{}{tenantID, campaignID, at}},
+		{revokeSystemRolesQuery, []interface{}{tenantID, campaignID, at, closedBy}},
+		{markRemainingItemsQuery, []interface{}{tenantID, campaignID, at}},
+	}
+	for _, step := range steps {
+		if _, err := queries.Raw(step.query, step.args...).ExecContext(spanCtx, svc.GetContextExecutor()); err != nil {
+			log.WithTenantID(tenantID).WithCustom("campaignId", campaignID).WithCustom("query", step.query).Error(err)
+			return nil, err
+		}
+	}
+
+	return svc.GetItems(spanCtx, tenantID, campaignID, "", "")
+}
+
+const (
+	getRevokedSystemRolesQuery = `SELECT DISTINCT person_id, system_role_id FROM access_review_item
+	WHERE tenant_id = $1 AND kind = 'system_role' AND outcome = 'revoked';`
+)
+
+type revokedSystemRole struct {
+	PersonID     string `boil:"person_id"`
+	SystemRoleID string `boil:"system_role_id"`
+}
+
+// GetRevokedSystemRoles returns the system roles access reviews have revoked from each of a tenant's people, keyed by person id
+func (svc *AccessReviewService) GetRevokedSystemRoles(ctx context.Context, tenantID string) (map[string][]string, error) {
+	spanCtx, span := log.StartSpan(ctx, "AccessReview.GetRevokedSystemRoles")
+	defer span.End()
+
+	rows := []*revokedSystemRole{}
+	if err := queries.Raw(getRevokedSystemRolesQuery, tenantID).Bind(spanCtx, svc.GetContextExecutor(), &rows); err != nil && err != sql.ErrNoRows {
+		log.WithTenantID(tenantID).WithCustom("query", getRevokedSystemRolesQuery).Error(err)
+		return nil, err
+	}
+
+	results := map[string][]string{}
+	for _, row := range rows {
+		results[row.PersonID] = append(results[row.PersonID], row.SystemRoleID)
+	}
+	return results, nil
+}
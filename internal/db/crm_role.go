@@ -20,7 +20,7 @@ type CRMRoleService struct {
 	*DBService
 }
 
-func (db *DB) NewCRMRoleService() *CRMRoleService {
+func (db *DB) NewCRMRoleService() CRMRoleRepository {
 	return &CRMRoleService{
 		DBService: db.NewDBService(),
 	}
@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"time"
 
@@ -18,6 +19,7 @@ import (
 const DefaultDBTimeout = 30 * time.Second
 const DefaultTenantID = "00000000-0000-0000-0000-000000000000"
 const DefaultOutreachSyncID = "00000000-0000-0000-0000-000000000001"
+const DefaultSCIMSyncID = "00000000-0000-0000-0000-000000000002"
 
 var (
 	DefaultHealthCheckPolicy = ekg.HealthCheckPolicy{
@@ -70,6 +72,34 @@ func (db *DB) NewTransaction(ctx context.Context) (*sql.Tx, error) {
 	return db.db.BeginTx(ctx, nil)
 }
 
+const (
+	tryAdvisoryLockQuery = `SELECT pg_try_advisory_lock(hashtext($1));`
+	advisoryUnlockQuery  = `SELECT pg_advisory_unlock(hashtext($1));`
+)
+
+// TryLock takes the session advisory lock called name on a connection of its own, so a background job only runs on one
+// replica at a time. ok is false when another session holds the lock, otherwise unlock has to be called to release it.
+func (db *DB) TryLock(ctx context.Context, name string) (unlock func(), ok bool, err error) {
+	conn, err := db.db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := conn.QueryRowContext(ctx, tryAdvisoryLockQuery, name).Scan(&ok); err != nil || !ok {
+		conn.Close()
+		return nil, false, err
+	}
+	return func() {
+		// Not the caller's context, a cancelled job still has to give the lock back before its connection returns to the pool
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultDBTimeout)
+		defer cancel()
+		if _, err := conn.ExecContext(ctx, advisoryUnlockQuery, name); err != nil {
+			// a connection that may still hold the lock mustn't go back to the pool
+			conn.Raw(func(driverConn interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, true, nil
+}
+
 type ModelExecuter interface {
 	GetTransaction() *sql.Tx
 }
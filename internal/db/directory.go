@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/models"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+const (
+	DirectoryPerson = "person"
+	DirectoryGroup  = "group"
+
+	// directoryPrefixBoost is added to the score of a row with a field that starts with the query, so "jon" ranks
+	// "Jon Smith" above "Jonathan Smith" above "Ben Jonson"
+	directoryPrefixBoost = 0.5
+)
+
+// DirectorySearch is a ranked search across a tenant's people and groups
+type DirectorySearch struct {
+	Query string
+	// ViewerID limits the results to the people and groups in the subtrees of the groups ViewerID can view, empty searches the whole tenant
+	ViewerID string
+	People   bool
+	Groups   bool
+	Limit    int
+}
+
+// DirectoryHit is a search result, either a person or a group. CRMRoleNames are the names of the group's crm roles that matched.
+type DirectoryHit struct {
+	Type         string
+	Score        float64
+	Person       *models.Person
+	Group        *models.Group
+	CRMRoleNames []string
+}
+
+type DirectoryService struct {
+	*DBService
+}
+
+func (db *DB) NewDirectoryService() DirectoryRepository {
+	return &DirectoryService{
+		DBService: db.NewDBService(),
+	}
+}
+
+// Both queries match with pg_trgm word similarity, which tolerates typos and finds "jon" in "Jonathan Smith". The <% operator is what
+// the trigram indexes in migrations/0003_directory_search.sql serve, the score is recomputed from word_similarity for ranking.
+const (
+	directoryViewableCTE = `WITH viewable AS (
+		SELECT g.group_path
+		FROM group_viewer gv INNER JOIN "group" g ON g.id = gv.group_id AND g.tenant_id = gv.tenant_id
+		WHERE gv.tenant_id = $1 AND gv.person_id = $3 AND nlevel(g.group_path) > 0
+			AND (gv.starts_at IS NULL OR gv.starts_at <= NOW()) AND (gv.ends_at IS NULL OR gv.ends_at > NOW())
+	)`
+
+	directoryPeopleQuery = directoryViewableCTE + `
+	SELECT p.*,
+		GREATEST(
+			word_similarity($2, LOWER(COALESCE(p.name, ''))),
+			word_similarity($2, LOWER(COALESCE(p.first_name, ''))),
+			word_similarity($2, LOWER(COALESCE(p.last_name, ''))),
+			word_similarity($2, LOWER(COALESCE(p.email, '')))
+		) + CASE WHEN LOWER(COALESCE(p.name, '')) LIKE $5 OR LOWER(COALESCE(p.first_name, '')) LIKE $5
+			OR LOWER(COALESCE(p.last_name, '')) LIKE $5 OR LOWER(COALESCE(p.email, '')) LIKE $5 THEN $6 ELSE 0 END AS score
+	FROM person p
+	WHERE p.tenant_id = $1
+		AND ($2 <% LOWER(COALESCE(p.name, '')) OR $2 <% LOWER(COALESCE(p.first_name, ''))
+			OR $2 <% LOWER(COALESCE(p.last_name, '')) OR $2 <% LOWER(COALESCE(p.email, '')))
+		AND ($3 = '' OR EXISTS (
+			SELECT 1 FROM "group" pg INNER JOIN viewable v ON pg.group_path <@ v.group_path
+			WHERE pg.id = p.group_id AND pg.tenant_id = p.tenant_id
+		))
+	ORDER BY score DESC, p.id
+	LIMIT $4`
+
+	directoryGroupsQuery = directoryViewableCTE + `
+	SELECT g.*, COALESCE(cr.names, '{}') AS crm_role_names,
+		GREATEST(word_similarity($2, LOWER(g.name)), COALESCE(cr.score, 0))
+			+ CASE WHEN LOWER(g.name) LIKE $5 THEN $6 ELSE 0 END AS score
+	FROM "group" g
+	LEFT JOIN LATERAL (
+		SELECT ARRAY_AGG(c.name ORDER BY c.name) AS names, MAX(word_similarity($2, LOWER(c.name))) AS score
+		FROM crm_role c
+		WHERE c.tenant_id = g.tenant_id AND c.id = ANY (g.crm_role_ids) AND $2 <% LOWER(c.name)
+	) cr ON TRUE
+	WHERE g.tenant_id = $1 AND g.status = 'active'
+		AND ($2 <% LOWER(g.name) OR cr.names IS NOT NULL)
+		AND ($3 = '' OR EXISTS (SELECT 1 FROM viewable v WHERE g.group_path <@ v.group_path))
+	ORDER BY score DESC, g.id
+	LIMIT $4`
+)
+
+type directoryPersonRow struct {
+	models.Person `boil:",bind"`
+	Score         float64 `boil:"score"`
+}
+
+type directoryGroupRow struct {
+	models.Group `boil:",bind"`
+	CRMRoleNames types.StringArray `boil:"crm_role_names"`
+	Score        float64           `boil:"score"`
+}
+
+// Search returns the best search.Limit people and groups matching search.Query, ordered by score
+func (svc *DirectoryService) Search(ctx context.Context, tenantID string, search DirectorySearch) ([]*DirectoryHit, error) {
+	spanCtx, span := log.StartSpan(ctx, "Directory.Search")
+	defer span.End()
+
+	query := strings.ToLower(strings.TrimSpace(search.Query))
+	prefix := escapeLike(query) + "%"
+	hits := []*DirectoryHit{}
+
+	if search.People {
+		rows := []*directoryPersonRow{}
+		err := queries.Raw(directoryPeopleQuery, tenantID, query, search.ViewerID, search.Limit, prefix, directoryPrefixBoost).Bind(spanCtx, svc.GetContextExecutor(), &rows)
+		if err != nil && err != sql.ErrNoRows {
+			log.WithTenantID(tenantID).WithCustom("query", directoryPeopleQuery).Error(err)
+			return nil, err
+		}
+		for _, row := range rows {
+			p := row.Person
+			hits = append(hits, &DirectoryHit{Type: DirectoryPerson, Score: row.Score, Person: &p})
+		}
+	}
+
+	if search.Groups {
+		rows := []*directoryGroupRow{}
+		err := queries.Raw(directoryGroupsQuery, tenantID, query, search.ViewerID, search.Limit, prefix, directoryPrefixBoost).Bind(spanCtx, svc.GetContextExecutor(), &rows)
+		if err != nil && err != sql.ErrNoRows {
+			log.WithTenantID(tenantID).WithCustom("query", directoryGroupsQuery).Error(err)
+			return nil, err
+		}
+		for _, row := range rows {
+			g := row.Group
+			hits = append(hits, &DirectoryHit{Type: DirectoryGroup, Score: row.Score, Group: &g, CRMRoleNames: row.CRMRoleNames})
+		}
+	}
+
+	return rankDirectoryHits(hits, search.Limit), nil
+}
+
+// rankDirectoryHits merges people and groups by score, ties go to people and then to the lower id so the order is stable
+func rankDirectoryHits(hits []*DirectoryHit, limit int) []*DirectoryHit {
+	sort.SliceStable(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Type != b.Type {
+			return a.Type == DirectoryPerson
+		}
+		return a.id() < b.id()
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+func (hit *DirectoryHit) id() string {
+	if hit.Person != nil {
+		return hit.Person.ID
+	}
+	if hit.Group != nil {
+		return hit.Group.ID
+	}
+	return ""
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
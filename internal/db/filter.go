@@ -0,0 +1,284 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+// Filter is a search filter expression. A leaf compares the column Field with Values using Op, a group is the AND or OR
+// of its children. Columns are checked against the searched table's whitelist before any sql is built, and every value
+// is bound to its own placeholder.
+type Filter struct {
+	Field  string
+	Op     string
+	Values []interface{}
+	And    []Filter
+	Or     []Filter
+}
+
+// PersonFilter is a Filter on person columns, a list of them is ANDed
+type PersonFilter = Filter
+
+type filterColumnKind int
+
+const (
+	filterKindID filterColumnKind = iota
+	filterKindText
+	filterKindBool
+	filterKindNumber
+	filterKindTime
+	filterKindArray
+	// filterKindEnum is a postgres enum, it can't be pattern matched or ordered against text so only equality applies
+	filterKindEnum
+)
+
+// PersonFilterColumns are the person columns that can be filtered on
+var PersonFilterColumns = map[string]filterColumnKind{
+	"id":             filterKindID,
+	"tenant_id":      filterKindID,
+	"name":           filterKindText,
+	"first_name":     filterKindText,
+	"last_name":      filterKindText,
+	"email":          filterKindText,
+	"manager_id":     filterKindID,
+	"group_id":       filterKindID,
+	"role_ids":       filterKindArray,
+	"crm_role_ids":   filterKindArray,
+	"is_provisioned": filterKindBool,
+	"is_synced":      filterKindBool,
+	"status":         filterKindEnum,
+	"type":           filterKindEnum,
+	"created_at":     filterKindTime,
+	"created_by":     filterKindID,
+	"updated_at":     filterKindTime,
+	"updated_by":     filterKindID,
+}
+
+// GroupFilterColumns are the group columns that can be filtered on
+var GroupFilterColumns = map[string]filterColumnKind{
+	"id":           filterKindID,
+	"tenant_id":    filterKindID,
+	"name":         filterKindText,
+	"type":         filterKindEnum,
+	"status":       filterKindEnum,
+	"parent_id":    filterKindID,
+	"role_ids":     filterKindArray,
+	"crm_role_ids": filterKindArray,
+	"order":        filterKindNumber,
+	"created_at":   filterKindTime,
+	"created_by":   filterKindID,
+	"updated_at":   filterKindTime,
+	"updated_by":   filterKindID,
+}
+
+var (
+	equalityFilterOps = []string{"EQ", "NEQ", "IN", "NIN", "ISNULL", "NOTNULL"}
+	rangeFilterOps    = []string{"GT", "GTE", "LT", "LTE"}
+	filterOpsByKind   = map[filterColumnKind][]string{
+		filterKindID:     equalityFilterOps,
+		filterKindBool:   equalityFilterOps,
+		filterKindText:   append(append([]string{"LIKE", "ILIKE"}, equalityFilterOps...), rangeFilterOps...),
+		filterKindNumber: append(append([]string{}, equalityFilterOps...), rangeFilterOps...),
+		filterKindTime:   append(append([]string{}, equalityFilterOps...), rangeFilterOps...),
+		filterKindArray:  {"EQANY", "OVERLAP", "ISNULL", "NOTNULL"},
+		filterKindEnum:   {"EQ", "NEQ", "IN", "NIN"},
+	}
+)
+
+// ValidateFilter checks that filter only uses whitelisted columns, operators that apply to them and the right number of
+// values. Its errors are meant for the caller that sent the filter.
+func ValidateFilter(filter Filter, columns map[string]filterColumnKind) error {
+	if len(filter.And) > 0 || len(filter.Or) > 0 {
+		if len(filter.And) > 0 && len(filter.Or) > 0 {
+			return fmt.Errorf("a filter group can't have both and and or")
+		}
+		if filter.Field != "" {
+			return fmt.Errorf("a filter group can't also filter on %s", filter.Field)
+		}
+		for _, child := range append(filter.And, filter.Or...) {
+			if err := ValidateFilter(child, columns); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if filter.Field == "" {
+		return fmt.Errorf("filter field is required")
+	}
+	kind, ok := columns[filter.Field]
+	if !ok {
+		return fmt.Errorf("can't filter on %s", filter.Field)
+	}
+	if !containsString(filterOpsByKind[kind], filter.Op) {
+		return fmt.Errorf("can't filter %s with %s", filter.Field, filter.Op)
+	}
+
+	switch filter.Op {
+	case "ISNULL", "NOTNULL":
+		if len(filter.Values) != 0 {
+			return fmt.Errorf("%s takes no values", filter.Op)
+		}
+	case "IN", "NIN", "OVERLAP":
+		if len(filter.Values) == 0 {
+			return fmt.Errorf("%s needs at least one value", filter.Op)
+		}
+	default:
+		if len(filter.Values) != 1 {
+			return fmt.Errorf("%s takes exactly one value", filter.Op)
+		}
+	}
+	return nil
+}
+
+// compileFilter builds the sql of a validated filter with placeholders numbered from paramIdx, it returns the args to
+// bind in order and the next free param index
+func compileFilter(filter Filter, paramIdx int) (string, []interface{}, int) {
+	if len(filter.And) > 0 || len(filter.Or) > 0 {
+		children, joiner := filter.And, " AND "
+		if len(filter.Or) > 0 {
+			children, joiner = filter.Or, " OR "
+		}
+		clauses := make([]string, len(children))
+		args := []interface{}{}
+		for i, child := range children {
+			clause, childArgs, next := compileFilter(child, paramIdx)
+			clauses[i] = clause
+			args = append(args, childArgs...)
+			paramIdx = next
+		}
+		return "(" + strings.Join(clauses, joiner) + ")", args, paramIdx
+	}
+
+	column := fmt.Sprintf("%q", filter.Field)
+	placeholder := func() string {
+		p := fmt.Sprintf("$%d", paramIdx)
+		paramIdx++
+		return p
+	}
+
+	switch filter.Op {
+	case "ISNULL":
+		return column + " IS NULL", nil, paramIdx
+	case "NOTNULL":
+		return column + " IS NOT NULL", nil, paramIdx
+	case "IN", "NIN":
+		placeholders := make([]string, len(filter.Values))
+		for i := range filter.Values {
+			placeholders[i] = placeholder()
+		}
+		op := "IN"
+		if filter.Op == "NIN" {
+			op = "NOT IN"
+		}
+		return fmt.Sprintf("%s %s (%s)", column, op, strings.Join(placeholders, ", ")), filter.Values, paramIdx
+	case "EQANY":
+		return fmt.Sprintf("%s = ANY (%s)", placeholder(), column), filter.Values, paramIdx
+	case "OVERLAP":
+		values := make(types.StringArray, len(filter.Values))
+		for i, v := range filter.Values {
+			values[i] = fmt.Sprint(v)
+		}
+		return fmt.Sprintf("%s && %s", column, placeholder()), []interface{}{values}, paramIdx
+	}
+
+	ops := map[string]string{"EQ": "=", "NEQ": "<>", "GT": ">", "GTE": ">=", "LT": "<", "LTE": "<=", "LIKE": "LIKE", "ILIKE": "ILIKE"}
+	return fmt.Sprintf("%s %s %s", column, ops[filter.Op], placeholder()), filter.Values, paramIdx
+}
+
+// filterQueryMods validates the ANDed filters and returns them as a where clause bound from paramIdx
+func filterQueryMods(filters []Filter, columns map[string]filterColumnKind, paramIdx int) ([]qm.QueryMod, int, error) {
+	if len(filters) == 0 {
+		return nil, paramIdx, nil
+	}
+	filter := Filter{And: filters}
+	if err := ValidateFilter(filter, columns); err != nil {
+		return nil, paramIdx, err
+	}
+	clause, args, next := compileFilter(filter, paramIdx)
+	return []qm.QueryMod{qm.And(clause, args...)}, next, nil
+}
+
+// matchFilter evaluates a validated filter against a row the way the compiled sql would, a null column only matches ISNULL
+func matchFilter(row interface{}, filter Filter) (bool, error) {
+	if len(filter.And) > 0 {
+		for _, child := range filter.And {
+			ok, err := matchFilter(row, child)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	if len(filter.Or) > 0 {
+		for _, child := range filter.Or {
+			ok, err := matchFilter(row, child)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+
+	column, ok := getColumn(row, filter.Field)
+	if !ok {
+		// only a filter ValidateFilter would have refused gets here, so fail it the same way
+		return false, fmt.Errorf("can't filter on %s", filter.Field)
+	}
+	isNull := column == nil || (reflect.ValueOf(column).Kind() == reflect.Slice && reflect.ValueOf(column).IsNil())
+	switch filter.Op {
+	case "ISNULL":
+		return isNull, nil
+	case "NOTNULL":
+		return !isNull, nil
+	}
+	if isNull || len(filter.Values) == 0 {
+		return false, nil
+	}
+	value := filter.Values[0]
+
+	switch filter.Op {
+	case "NEQ":
+		return compareMemoryValues(column, value) != 0, nil
+	case "IN", "NIN":
+		in := false
+		for _, v := range filter.Values {
+			if compareMemoryValues(column, v) == 0 {
+				in = true
+			}
+		}
+		return in == (filter.Op == "IN"), nil
+	case "GT":
+		return compareMemoryValues(column, value) > 0, nil
+	case "GTE":
+		return compareMemoryValues(column, value) >= 0, nil
+	case "LT":
+		return compareMemoryValues(column, value) < 0, nil
+	case "LTE":
+		return compareMemoryValues(column, value) <= 0, nil
+	case "LIKE":
+		return matchLike(fmt.Sprint(column), fmt.Sprint(value)), nil
+	case "ILIKE":
+		return matchLike(strings.ToLower(fmt.Sprint(column)), strings.ToLower(fmt.Sprint(value))), nil
+	case "EQANY":
+		values, ok := column.(types.StringArray)
+		return ok && containsString(values, fmt.Sprint(value)), nil
+	case "OVERLAP":
+		values, ok := column.(types.StringArray)
+		if !ok {
+			return false, nil
+		}
+		for _, v := range filter.Values {
+			if containsString(values, fmt.Sprint(v)) {
+				return true, nil
+			}
+		}
+		return false, nil
+	default:
+		return compareMemoryValues(column, value) == 0, nil
+	}
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/loupe-co/orchard/internal/models"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+func TestCompileFilterNested(t *testing.T) {
+	filters := []Filter{
+		{Field: "group_id", Op: "IN", Values: []interface{}{"g1", "g2", "g3"}},
+		{Field: "is_synced", Op: "EQ", Values: []interface{}{false}},
+		{Or: []Filter{
+			{And: []Filter{
+				{Field: "status", Op: "EQ", Values: []interface{}{"active"}},
+				{Field: "name", Op: "ILIKE", Values: []interface{}{"%pat%"}},
+			}},
+			{Field: "role_ids", Op: "EQANY", Values: []interface{}{"r1"}},
+			{Field: "manager_id", Op: "ISNULL"},
+		}},
+		{Field: "crm_role_ids", Op: "OVERLAP", Values: []interface{}{"c1", "c2"}},
+		{Field: "type", Op: "NIN", Values: []interface{}{"internal"}},
+	}
+	if err := ValidateFilter(Filter{And: filters}, PersonFilterColumns); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// $1 is already taken by the tenant id in searchQueryMods
+	clause, args, next := compileFilter(Filter{And: filters}, 2)
+
+	wantClause := `("group_id" IN ($2, $3, $4) AND "is_synced" = $5 AND (("status" = $6 AND "name" ILIKE $7) OR $8 = ANY ("role_ids") OR "manager_id" IS NULL) AND "crm_role_ids" && $9 AND "type" NOT IN ($10))`
+	if clause != wantClause {
+		t.Logf("expected clause\n%s\nbut got\n%s", wantClause, clause)
+		t.Fail()
+		return
+	}
+	if next != 11 {
+		t.Logf("expected the next free param to be 11, but got %d", next)
+		t.Fail()
+		return
+	}
+
+	wantArgs := []interface{}{"g1", "g2", "g3", false, "active", "%pat%", "r1", types.StringArray{"c1", "c2"}, "internal"}
+	if fmt.Sprintf("%#v", args) != fmt.Sprintf("%#v", wantArgs) {
+		t.Logf("expected args %#v, but got %#v", wantArgs, args)
+		t.Fail()
+		return
+	}
+}
+
+func TestSearchQueryModsSQL(t *testing.T) {
+	filters := []PersonFilter{
+		{Field: "group_id", Op: "IN", Values: []interface{}{"g1", "g2"}},
+		{Or: []Filter{
+			{Field: "status", Op: "EQ", Values: []interface{}{"active"}},
+			{And: []Filter{
+				{Field: "is_provisioned", Op: "EQ", Values: []interface{}{true}},
+				{Field: "email", Op: "ILIKE", Values: []interface{}{"%@canopy.io"}},
+			}},
+		}},
+	}
+	mods, next, err := searchQueryMods(DefaultTenantID, "Pat", filters)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	sql, args := queries.BuildQuery(models.People(mods...).Query)
+
+	wantFilter := `("group_id" IN ($3, $4) AND ("status" = $5 OR ("is_provisioned" = $6 AND "email" ILIKE $7)))`
+	if !strings.Contains(sql, "tenant_id=$1") || !strings.Contains(sql, "LIKE $2") || !strings.Contains(sql, wantFilter) {
+		t.Logf("expected the tenant, search and filter placeholders to follow each other, but got\n%s", sql)
+		t.Fail()
+		return
+	}
+	wantArgs := []interface{}{DefaultTenantID, "%pat%", "g1", "g2", "active", true, "%@canopy.io"}
+	if fmt.Sprintf("%#v", args) != fmt.Sprintf("%#v", wantArgs) || next != 8 {
+		t.Logf("expected args %#v and next param 8, but got %#v and %d", wantArgs, args, next)
+		t.Fail()
+		return
+	}
+}
+
+func TestFilterQueryModsParamIndex(t *testing.T) {
+	filters := []Filter{
+		{Field: "email", Op: "IN", Values: []interface{}{"a@canopy.io", "b@canopy.io"}},
+		{Or: []Filter{
+			{Field: "first_name", Op: "EQ", Values: []interface{}{"Pat"}},
+			{Field: "last_name", Op: "EQ", Values: []interface{}{"Rodgers"}},
+		}},
+	}
+	mods, next, err := filterQueryMods(filters, PersonFilterColumns, 3)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(mods) != 1 || next != 7 {
+		t.Logf("expected one where clause using params $3 to $6, but got %d mods and next param %d", len(mods), next)
+		t.Fail()
+		return
+	}
+
+	if _, _, err := filterQueryMods([]Filter{{Field: "password", Op: "EQ", Values: []interface{}{"x"}}}, PersonFilterColumns, 1); err == nil {
+		t.Log("expected an unknown column to be refused before any sql is built")
+		t.Fail()
+		return
+	}
+}
+
+func TestValidateFilterEnumColumns(t *testing.T) {
+	valid := []Filter{
+		{Field: "status", Op: "EQ", Values: []interface{}{"active"}},
+		{Field: "status", Op: "NEQ", Values: []interface{}{"inactive"}},
+		{Field: "type", Op: "IN", Values: []interface{}{"ic", "player_coach"}},
+		{Field: "type", Op: "NIN", Values: []interface{}{"internal"}},
+	}
+	for _, f := range valid {
+		if err := ValidateFilter(f, PersonFilterColumns); err != nil {
+			t.Logf("expected %s %s to be allowed, but got %s", f.Field, f.Op, err)
+			t.Fail()
+		}
+	}
+
+	invalid := []Filter{
+		{Field: "status", Op: "ILIKE", Values: []interface{}{"act%"}},
+		{Field: "status", Op: "LIKE", Values: []interface{}{"act%"}},
+		{Field: "type", Op: "GT", Values: []interface{}{"ic"}},
+		{Field: "type", Op: "ISNULL"},
+		{Field: "status", Op: "IN"},
+	}
+	for _, f := range invalid {
+		if err := ValidateFilter(f, PersonFilterColumns); err == nil {
+			t.Logf("expected %s %s to be refused", f.Field, f.Op)
+			t.Fail()
+		}
+		if err := ValidateFilter(f, GroupFilterColumns); err == nil {
+			t.Logf("expected group %s %s to be refused", f.Field, f.Op)
+			t.Fail()
+		}
+	}
+}
@@ -23,7 +23,7 @@ type GroupService struct {
 	*DBService
 }
 
-func (db *DB) NewGroupService() *GroupService {
+func (db *DB) NewGroupService() GroupRepository {
 	return &GroupService{
 		DBService: db.NewDBService(),
 	}
@@ -151,22 +151,98 @@ func (svc *GroupService) CheckDuplicateCRMRoleIDs(ctx context.Context, id, tenan
 	return result.HasDups, nil
 }
 
-func (svc *GroupService) Search(ctx context.Context, tenantID, query string) ([]*models.Group, error) {
+// groupSearchQueryMods builds the where clauses shared by Search and SearchPage, it returns the next free param index
+func groupSearchQueryMods(tenantID, query string, filters []Filter) ([]qm.QueryMod, int, error) {
+	queryParts := []qm.QueryMod{}
+	queryParts = append(queryParts, qm.Where("tenant_id=$1", tenantID))
+	paramIdx := 2
+
+	if query != "" {
+		searchClause := fmt.Sprintf("LOWER(name) LIKE $%d", paramIdx)
+		queryParts = append(queryParts, qm.And(searchClause, "%"+strings.ToLower(query)+"%"))
+		paramIdx++
+	}
+
+	filterParts, paramIdx, err := filterQueryMods(filters, GroupFilterColumns, paramIdx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return append(queryParts, filterParts...), paramIdx, nil
+}
+
+func (svc *GroupService) Search(ctx context.Context, tenantID, query string, filters ...Filter) ([]*models.Group, error) {
 	spanCtx, span := log.StartSpan(ctx, "Group.Search")
 	defer span.End()
 
+	queryParts, _, err := groupSearchQueryMods(tenantID, query, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	queryParts = append(queryParts, qm.OrderBy("\"order\", name"))
+
+	groups, err := models.Groups(queryParts...).All(spanCtx, svc.GetContextExecutor())
+	if err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// SearchPage is Search with a sortable, keyset paginated page. The total is only counted when page.CountTotal is set.
+func (svc *GroupService) SearchPage(ctx context.Context, tenantID, query string, page Page, filters ...Filter) ([]*models.Group, int64, error) {
+	spanCtx, span := log.StartSpan(ctx, "Group.SearchPage")
+	defer span.End()
+
+	queryParts, paramIdx, err := groupSearchQueryMods(tenantID, query, filters)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := int64(0)
+	if page.CountTotal {
+		count, err := models.Groups(queryParts...).Count(spanCtx, svc.GetContextExecutor())
+		if err != nil {
+			return nil, 0, err
+		}
+		total = count
+	}
+
+	column, ok := GroupSortColumns[page.SortBy]
+	if !ok {
+		return nil, total, fmt.Errorf("can't sort groups by %q", page.SortBy)
+	}
+	queryParts = append(queryParts, pageQueryMods(page, column, paramIdx)...)
+
+	groups, err := models.Groups(queryParts...).All(spanCtx, svc.GetContextExecutor())
+	if err != nil && err != sql.ErrNoRows {
+		return nil, total, err
+	}
+	return groups, total, nil
+}
+
+// SearchAfter returns up to limit groups matching query with an id after afterID ordered by id, for keyset pagination
+func (svc *GroupService) SearchAfter(ctx context.Context, tenantID, query, afterID string, limit int) ([]*models.Group, error) {
+	spanCtx, span := log.StartSpan(ctx, "Group.SearchAfter")
+	defer span.End()
+
 	queryParts := []qm.QueryMod{}
 	queryParts = append(queryParts, qm.Where("tenant_id=$1", tenantID))
+	paramIdx := 2
 
 	if query != "" {
-		searchClause := "LOWER(name) LIKE $2"
-		queryParts = append(queryParts, qm.Where(searchClause, "%"+strings.ToLower(query)+"%"))
+		searchClause := fmt.Sprintf("LOWER(name) LIKE $%d", paramIdx)
+		queryParts = append(queryParts, qm.And(searchClause, "%"+strings.ToLower(query)+"%"))
+		paramIdx++
+	}
+	if afterID != "" {
+		queryParts = append(queryParts, qm.And(fmt.Sprintf("id > $%d", paramIdx), afterID))
 	}
 
-	queryParts = append(queryParts, qm.OrderBy("\"order\", name"))
+	queryParts = append(queryParts, qm.OrderBy("id"), qm.Limit(limit))
 
 	groups, err := models.Groups(queryParts...).All(spanCtx, svc.GetContextExecutor())
-	if err != nil {
+	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
 
@@ -274,18 +350,20 @@ func buildRootGroupSelectorClause(g string, vg []string) string {
 }
 
 const (
-	GetManagerAndParentIDsQuery = `SELECT p.manager_id, g.id AS parent_id
+	// Player-coaches still report the marker they used to be stored as, for callers that look for it
+	GetManagerAndParentIDsQuery = `SELECT p.manager_id, g.id AS parent_id, p."type" AS person_type
 	FROM "person" p 
 	INNER JOIN "group" g ON p.group_id = g.id and g.tenant_id=p.tenant_id 
 	WHERE g.tenant_id =$1 and p.id=$2`
 )
 
 type GetManagerAndParentIDsResult struct {
-	ManagerID sql.NullString `json:"managerID" boil:"manager_id"`
-	ParentID  sql.NullString `json:"parentID" boil:"parent_id"`
+	ManagerID  sql.NullString `json:"managerID" boil:"manager_id"`
+	ParentID   sql.NullString `json:"parentID" boil:"parent_id"`
+	PersonType sql.NullString `json:"personType" boil:"person_type"`
 }
 
-func (svc *GroupService) GetManagerAndParentIDs(ctx context.Context, tenantID, personID string) (managerID, parentID string, err error) {
+func (svc *GroupService) GetManagerAndParentIDs(ctx context.Context, tenantID, personID string) (managerID, parentID, personType string, err error) {
 	spanCtx, span := log.StartSpan(ctx, "Group.GetManagerAndParentIDs")
 	defer span.End()
 
@@ -309,6 +387,10 @@ func (svc *GroupService) GetManagerAndParentIDs(ctx context.Context, tenantID, p
 		parentID = res.ParentID.String
 	}
 
+	if res.PersonType.Valid {
+		personType = res.PersonType.String
+	}
+
 	return
 }
 
@@ -351,9 +433,10 @@ func (svc *GroupService) GetGroupSubTree(ctx context.Context, tenantID, groupID
 		statusPart = `AND p."status" = 'active'`
 	}
 
+	// player-coaches are kept with the reps, whatever the type of their group
 	managerExclusionPart := "TRUE"
 	if excludeManagerUsers {
-		managerExclusionPart = `("group".type  = 'ic')`
+		managerExclusionPart = `("group".type  = 'ic' OR p."type" = 'player_coach')`
 	}
 
 	query := strings.ReplaceAll(getGroupSubTreeQuery, "{PERSON_SELECT}", personSelect)
@@ -786,13 +869,15 @@ const (
 	WHERE "group".id = groups.id AND "group".tenant_id = groups.tenant_id
 	`
 
+	// Player-coach is set on the person rather than derived from their group, so their group gaining or losing children doesn't
+	// change it. Moving a player-coach to a manager group does, see UpdatePerson.
 	updatePersonTypesQuery = `UPDATE person
 	SET "type" = pg."type"::person_type, updated_by = '00000000-0000-0000-0000-000000000000', updated_at = CURRENT_TIMESTAMP
 	FROM (
 		SELECT p.id, p.tenant_id, COALESCE(g."type"::text, 'ic') AS "type"
 		FROM person p
 		LEFT OUTER JOIN "group" g ON p.group_id = g.id AND p.tenant_id = g.tenant_id
-		WHERE p.tenant_id = $1
+		WHERE p.tenant_id = $1 AND p."type" <> 'player_coach'
 	) pg
 	WHERE person.id = pg.id AND person.tenant_id = pg.tenant_id`
 )
@@ -914,3 +999,69 @@ func (svc *GroupService) IsDescendant(ctx context.Context, sourceID string, targ
 	}
 	return DescendantCode(res.Code.Int32), nil
 }
+
+const (
+	getSubTreeIDsQuery = `SELECT g.id
+	FROM "group" g
+	WHERE g.tenant_id = $2 AND g.status = 'active'
+		AND g.group_path <@ (SELECT group_path FROM "group" WHERE id = $1 AND tenant_id = $2 AND nlevel(group_path) > 0)
+	ORDER BY g.group_path`
+)
+
+type groupIDResult struct {
+	ID string `boil:"id"`
+}
+
+// GetSubTreeIDs returns the ids of groupID and its active descendants, parents before their children
+func (svc *GroupService) GetSubTreeIDs(ctx context.Context, tenantID, groupID string) ([]string, error) {
+	spanCtx, span := log.StartSpan(ctx, "Group.GetSubTreeIDs")
+	defer span.End()
+
+	results := []*groupIDResult{}
+	if err := queries.Raw(getSubTreeIDsQuery, groupID, tenantID).Bind(spanCtx, svc.GetContextExecutor(), &results); err != nil && err != sql.ErrNoRows {
+		log.WithTenantID(tenantID).WithCustom("groupId", groupID).WithCustom("query", getSubTreeIDsQuery).Error(err)
+		return nil, err
+	}
+	ids := make([]string, len(results))
+	for i, result := range results {
+		ids[i] = result.ID
+	}
+	return ids, nil
+}
+
+const (
+	getGroupsByRoleIDQuery = `SELECT * FROM "group" WHERE ($1 = '' OR tenant_id = $1) AND $2 = ANY (role_ids) ORDER BY tenant_id, name, id;`
+
+	replaceGroupRoleIDQuery = `UPDATE "group" SET
+		role_ids = CASE WHEN $3 = ANY (role_ids) THEN ARRAY_REMOVE(role_ids, $2) ELSE ARRAY_REPLACE(role_ids, $2, $3) END,
+		updated_by = $4,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE ($1 = '' OR tenant_id = $1) AND $2 = ANY (role_ids)
+	RETURNING *;`
+)
+
+// GetGroupsByRoleID returns the groups whose members get a system role, an empty tenantID looks in every tenant
+func (svc *GroupService) GetGroupsByRoleID(ctx context.Context, tenantID, roleID string) ([]*models.Group, error) {
+	spanCtx, span := log.StartSpan(ctx, "Group.GetGroupsByRoleID")
+	defer span.End()
+
+	groups := []*models.Group{}
+	if err := queries.Raw(getGroupsByRoleIDQuery, tenantID, roleID).Bind(spanCtx, svc.GetContextExecutor(), &groups); err != nil && err != sql.ErrNoRows {
+		log.WithTenantID(tenantID).WithCustom("roleId", roleID).WithCustom("query", getGroupsByRoleIDQuery).Error(err)
+		return nil, err
+	}
+	return groups, nil
+}
+
+// ReplaceRoleID gives every group with roleID replacementID instead, an empty tenantID replaces it in every tenant. It returns the
+// groups that changed.
+func (svc *GroupService) ReplaceRoleID(ctx context.Context, tenantID, roleID, replacementID, userID string) ([]*models.Group, error) {
+	spanCtx, span := log.StartSpan(ctx, "Group.ReplaceRoleID")
+	defer span.End()
+
+	groups := []*models.Group{}
+	if err := queries.Raw(replaceGroupRoleIDQuery, tenantID, roleID, replacementID, userID).Bind(spanCtx, svc.GetContextExecutor(), &groups); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return groups, nil
+}
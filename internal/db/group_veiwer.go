@@ -3,10 +3,14 @@ package db
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/loupe-co/go-loupe-logger/log"
 	"github.com/loupe-co/orchard/internal/models"
+	"github.com/loupe-co/orchard/internal/permcatalog"
+	authPb "github.com/loupe-co/protos/src/common/auth"
 	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	"github.com/volatiletech/null/v8"
 	"github.com/volatiletech/sqlboiler/v4/boil"
 	"github.com/volatiletech/sqlboiler/v4/queries"
 	"github.com/volatiletech/sqlboiler/v4/queries/qm"
@@ -17,7 +21,7 @@ type GroupViewerService struct {
 	*DBService
 }
 
-func (db *DB) NewGroupViewerService() *GroupViewerService {
+func (db *DB) NewGroupViewerService() GroupViewerRepository {
 	return &GroupViewerService{
 		DBService: db.NewDBService(),
 	}
@@ -27,11 +31,22 @@ func (svc *GroupViewerService) FromProto(gv *orchardPb.GroupViewer) *models.Grou
 	createdAt := gv.CreatedAt.AsTime()
 	updatedAt := gv.UpdatedAt.AsTime()
 
+	var startsAt, endsAt null.Time
+	if gv.StartsAt != nil {
+		startsAt = null.TimeFrom(gv.StartsAt.AsTime())
+	}
+	if gv.EndsAt != nil {
+		endsAt = null.TimeFrom(gv.EndsAt.AsTime())
+	}
+
 	return &models.GroupViewer{
 		TenantID:    gv.TenantId,
 		GroupID:     gv.GroupId,
 		PersonID:    gv.PersonId,
 		Permissions: gv.Permissions,
+		StartsAt:    startsAt,
+		EndsAt:      endsAt,
+		DelegatedBy: null.NewString(gv.DelegatedBy, gv.DelegatedBy != ""),
 		CreatedAt:   createdAt,
 		CreatedBy:   gv.CreatedBy,
 		UpdatedAt:   updatedAt,
@@ -44,21 +59,45 @@ func (svc *GroupViewerService) ToProto(gv *models.GroupViewer) (*orchardPb.Group
 
 	updatedAt := timestamppb.New(gv.UpdatedAt)
 
+	var startsAt, endsAt *timestamppb.Timestamp
+	if gv.StartsAt.Valid {
+		startsAt = timestamppb.New(gv.StartsAt.Time)
+	}
+	if gv.EndsAt.Valid {
+		endsAt = timestamppb.New(gv.EndsAt.Time)
+	}
+
 	return &orchardPb.GroupViewer{
-		TenantId:    gv.TenantID,
-		GroupId:     gv.GroupID,
-		PersonId:    gv.PersonID,
-		Permissions: gv.Permissions,
-		CreatedAt:   createdAt,
-		CreatedBy:   gv.CreatedBy,
-		UpdatedAt:   updatedAt,
-		UpdatedBy:   gv.UpdatedBy,
+		TenantId:         gv.TenantID,
+		GroupId:          gv.GroupID,
+		PersonId:         gv.PersonID,
+		Permissions:      gv.Permissions,
+		NamedPermissions: permcatalog.Named(authPb.PermissionSet_Group, gv.Permissions),
+		StartsAt:         startsAt,
+		EndsAt:           endsAt,
+		DelegatedBy:      gv.DelegatedBy.String,
+		Active:           GroupViewerActive(gv, time.Now()),
+		CreatedAt:        createdAt,
+		CreatedBy:        gv.CreatedBy,
+		UpdatedAt:        updatedAt,
+		UpdatedBy:        gv.UpdatedBy,
 	}, nil
 }
 
+// GroupViewerActive is whether a grant is inside its validity window at t
+func GroupViewerActive(gv *models.GroupViewer, t time.Time) bool {
+	return (!gv.StartsAt.Valid || !gv.StartsAt.Time.After(t)) && (!gv.EndsAt.Valid || gv.EndsAt.Time.After(t))
+}
+
+// groupViewerActive is the sql condition for a grant that is inside its validity window now, on group_viewer aliased as alias
+func groupViewerActive(alias string) string {
+	return fmt.Sprintf("(%[1]s.starts_at IS NULL OR %[1]s.starts_at <= NOW()) AND (%[1]s.ends_at IS NULL OR %[1]s.ends_at > NOW())", alias)
+}
+
 var (
 	groupViewerInsertWhitelist = []string{
 		"tenant_id", "group_id", "person_id", "permissions",
+		"starts_at", "ends_at", "delegated_by",
 		"created_at", "created_by", "updated_at", "updated_by",
 	}
 )
@@ -69,10 +108,10 @@ func (svc *GroupViewerService) Insert(ctx context.Context, gv *models.GroupViewe
 	return gv.Insert(spanCtx, svc.GetContextExecutor(), boil.Whitelist(groupViewerInsertWhitelist...))
 }
 
-const (
+var (
 	getGroupViewersQuery = `SELECT p.*
 	FROM group_viewer gv INNER JOIN person p ON p.id = gv.person_id AND p.tenant_id = gv.tenant_id
-	WHERE gv.group_id = $1 AND gv.tenant_id = $2;`
+	WHERE gv.group_id = $1 AND gv.tenant_id = $2 AND ` + groupViewerActive("gv") + `;`
 )
 
 func (svc *GroupViewerService) GetGroupViewers(ctx context.Context, tenantID, groupID string) ([]*models.Person, error) {
@@ -87,10 +126,10 @@ func (svc *GroupViewerService) GetGroupViewers(ctx context.Context, tenantID, gr
 	return results, nil
 }
 
-const (
+var (
 	getPersonViewableGroupsQuery = `SELECT g.*
 	FROM group_viewer gv INNER JOIN "group" g ON g.id = gv.group_id AND g.tenant_id = gv.tenant_id
-	WHERE gv.person_id = $1 AND gv.tenant_id = $2;`
+	WHERE gv.person_id = $1 AND gv.tenant_id = $2 AND ` + groupViewerActive("gv") + `;`
 )
 
 func (svc *GroupViewerService) GetPersonViewableGroups(ctx context.Context, tenantID, personID string) ([]*models.Group, error) {
@@ -115,6 +154,7 @@ func (svc *GroupViewerService) GetPersonsViewableGroups(ctx context.Context, ten
 	groupViewers, err := models.GroupViewers(
 		qm.WhereIn("person_id IN ?", idsParam...),
 		qm.And(fmt.Sprintf("tenant_id::TEXT = $%d", len(peepIds)+1), tenantID),
+		qm.And(groupViewerActive("group_viewer")),
 	).All(spanCtx, svc.GetContextExecutor())
 
 	if err != nil {
@@ -126,7 +166,7 @@ func (svc *GroupViewerService) GetPersonsViewableGroups(ctx context.Context, ten
 
 var (
 	defaultGroupViewerUpdateWhitelist = []string{
-		"permissions", "updated_at", "updated_by",
+		"permissions", "starts_at", "ends_at", "delegated_by", "activated_at", "updated_at", "updated_by",
 	}
 )
 
@@ -158,3 +198,73 @@ func (svc *GroupViewerService) DeleteByID(ctx context.Context, tenantID, groupID
 	}
 	return nil
 }
+
+// GetPersonGrants returns all of a person's group viewer grants, including ones that haven't started or have ended
+func (svc *GroupViewerService) GetPersonGrants(ctx context.Context, tenantID, personID string) ([]*models.GroupViewer, error) {
+	spanCtx, span := log.StartSpan(ctx, "GroupViewer.GetPersonGrants")
+	defer span.End()
+	return models.GroupViewers(
+		models.GroupViewerWhere.TenantID.EQ(tenantID),
+		models.GroupViewerWhere.PersonID.EQ(personID),
+	).All(spanCtx, svc.GetContextExecutor())
+}
+
+// GetGroupGrants returns all the group viewer grants on the groups, including ones that haven't started or have ended
+func (svc *GroupViewerService) GetGroupGrants(ctx context.Context, tenantID string, groupIDs ...string) ([]*models.GroupViewer, error) {
+	spanCtx, span := log.StartSpan(ctx, "GroupViewer.GetGroupGrants")
+	defer span.End()
+	if len(groupIDs) == 0 {
+		return []*models.GroupViewer{}, nil
+	}
+	return models.GroupViewers(
+		models.GroupViewerWhere.TenantID.EQ(tenantID),
+		models.GroupViewerWhere.GroupID.IN(groupIDs),
+		qm.OrderBy("group_id, person_id"),
+	).All(spanCtx, svc.GetContextExecutor())
+}
+
+const (
+	expireGroupViewersQuery = `DELETE FROM group_viewer
+	WHERE (tenant_id, person_id, group_id) IN (
+		SELECT tenant_id, person_id, group_id FROM group_viewer
+		WHERE ends_at <= $1
+		ORDER BY ends_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING *;`
+
+	activateGroupViewersQuery = `UPDATE group_viewer SET activated_at = $1
+	WHERE (tenant_id, person_id, group_id) IN (
+		SELECT tenant_id, person_id, group_id FROM group_viewer
+		WHERE starts_at <= $1 AND activated_at IS NULL AND (ends_at IS NULL OR ends_at > $1)
+		ORDER BY starts_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING *;`
+)
+
+// DeleteExpired deletes up to limit grants that ended at or before now and returns them
+func (svc *GroupViewerService) DeleteExpired(ctx context.Context, now time.Time, limit int) ([]*models.GroupViewer, error) {
+	spanCtx, span := log.StartSpan(ctx, "GroupViewer.DeleteExpired")
+	defer span.End()
+	results := []*models.GroupViewer{}
+	if err := queries.Raw(expireGroupViewersQuery, now, limit).Bind(spanCtx, svc.GetContextExecutor(), &results); err != nil {
+		log.WithContext(spanCtx).WithCustom("query", expireGroupViewersQuery).Error(err)
+		return nil, err
+	}
+	return results, nil
+}
+
+// MarkStarted sets activated_at on up to limit grants that started at or before now and haven't been marked yet, and returns them
+func (svc *GroupViewerService) MarkStarted(ctx context.Context, now time.Time, limit int) ([]*models.GroupViewer, error) {
+	spanCtx, span := log.StartSpan(ctx, "GroupViewer.MarkStarted")
+	defer span.End()
+	results := []*models.GroupViewer{}
+	if err := queries.Raw(activateGroupViewersQuery, now, limit).Bind(spanCtx, svc.GetContextExecutor(), &results); err != nil {
+		log.WithContext(spanCtx).WithCustom("query", activateGroupViewersQuery).Error(err)
+		return nil, err
+	}
+	return results, nil
+}
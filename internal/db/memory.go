@@ -0,0 +1,685 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loupe-co/orchard/internal/models"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+// memoryBindTxStatement is the only statement the in-memory driver understands, it links a *sql.Tx to the snapshot its connection began
+const memoryBindTxStatement = "orchard-memory: bind transaction"
+
+// MemoryStore is a Store that keeps every table in memory, so handlers and anything built on the repositories can run without postgres.
+// It implements the same hierarchy semantics as the sql: ltree group paths, subtree queries, crm role group sync and person group assignment.
+//
+// Transactions are real *sql.Tx values backed by an in-memory driver, so handler code that begins, commits and rolls back transactions
+// runs unchanged. A transaction reads and writes a snapshot of the store taken when it began and logs its writes. Commit replaces the
+// store with the snapshot when nothing else was written in the meantime, otherwise it replays the logged writes on top of what was,
+// like sql statements running against the latest rows. Replayed writes that create ids create new ones.
+type MemoryStore struct {
+	mu      sync.Mutex
+	state   *memoryState
+	txs     map[*sql.Tx]*memoryTx
+	pending map[string]*memoryTx
+	locks   map[string]bool
+	sqlDB   *sql.DB
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	store := &MemoryStore{
+		state:   newMemoryState(),
+		txs:     map[*sql.Tx]*memoryTx{},
+		pending: map[string]*memoryTx{},
+		locks:   map[string]bool{},
+	}
+	store.sqlDB = sql.OpenDB(&memoryConnector{store: store})
+	return store
+}
+
+func (store *MemoryStore) NewTransaction(ctx context.Context) (*sql.Tx, error) {
+	tx, err := store.sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	key := MakeID()
+	if _, err := tx.ExecContext(ctx, memoryBindTxStatement, key); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	store.mu.Lock()
+	memTx, ok := store.pending[key]
+	if ok {
+		delete(store.pending, key)
+		memTx.sqlTx = tx
+		store.txs[tx] = memTx
+	}
+	store.mu.Unlock()
+	if !ok {
+		tx.Rollback()
+		return nil, fmt.Errorf("error binding in-memory transaction")
+	}
+
+	return tx, nil
+}
+
+// TryLock is the advisory lock of DB.TryLock, held within the process
+func (store *MemoryStore) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.locks[name] {
+		return nil, false, nil
+	}
+	store.locks[name] = true
+	return func() {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		delete(store.locks, name)
+	}, true, nil
+}
+
+func (store *MemoryStore) NewGroupService() GroupRepository {
+	return &MemoryGroupService{memoryService: store.newMemoryService()}
+}
+
+func (store *MemoryStore) NewPersonService() PersonRepository {
+	return &MemoryPersonService{memoryService: store.newMemoryService()}
+}
+
+func (store *MemoryStore) NewCRMRoleService() CRMRoleRepository {
+	return &MemoryCRMRoleService{memoryService: store.newMemoryService()}
+}
+
+func (store *MemoryStore) NewSystemRoleService() SystemRoleRepository {
+	return &MemorySystemRoleService{memoryService: store.newMemoryService()}
+}
+
+func (store *MemoryStore) NewGroupViewerService() GroupViewerRepository {
+	return &MemoryGroupViewerService{memoryService: store.newMemoryService()}
+}
+
+func (store *MemoryStore) NewTenantService() TenantRepository {
+	return &MemoryTenantService{memoryService: store.newMemoryService()}
+}
+
+func (store *MemoryStore) NewOutboxService() OutboxRepository {
+	return &MemoryOutboxService{memoryService: store.newMemoryService()}
+}
+
+func (store *MemoryStore) NewDirectoryService() DirectoryRepository {
+	return &MemoryDirectoryService{memoryService: store.newMemoryService()}
+}
+
+func (store *MemoryStore) NewAccessReviewService() AccessReviewRepository {
+	return &MemoryAccessReviewService{memoryService: store.newMemoryService()}
+}
+
+func (store *MemoryStore) NewRoleAssignmentRuleService() RoleAssignmentRuleRepository {
+	return &MemoryRoleAssignmentRuleService{memoryService: store.newMemoryService()}
+}
+
+func (store *MemoryStore) NewSCIMTokenService() SCIMTokenRepository {
+	return &MemorySCIMTokenService{memoryService: store.newMemoryService()}
+}
+
+// Reset drops every row, transactions that are still open keep their snapshot but can no longer be committed
+func (store *MemoryStore) Reset() {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.state = newMemoryState()
+	for sqlTx, memTx := range store.txs {
+		memTx.done = true
+		delete(store.txs, sqlTx)
+	}
+}
+
+type memoryKey struct {
+	tenantID string
+	id       string
+}
+
+type memoryGroupViewerKey struct {
+	tenantID string
+	groupID  string
+	personID string
+}
+
+// memoryState is every table, rows are copied in and out so callers never share memory with the store
+type memoryState struct {
+	tenants      map[string]*models.Tenant
+	groups       map[memoryKey]*models.Group
+	people       map[memoryKey]*models.Person
+	crmRoles     map[memoryKey]*models.CRMRole
+	systemRoles  map[string]*models.SystemRole
+	groupViewers map[memoryGroupViewerKey]*models.GroupViewer
+	outbox       map[string]*OutboxMessage
+
+	accessReviewCampaigns map[string]*AccessReviewCampaign
+	accessReviewItems     map[string]*AccessReviewItem
+
+	roleAssignmentRules map[string]*RoleAssignmentRule
+
+	scimTokens map[string]*SCIMToken
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{
+		tenants:      map[string]*models.Tenant{},
+		groups:       map[memoryKey]*models.Group{},
+		people:       map[memoryKey]*models.Person{},
+		crmRoles:     map[memoryKey]*models.CRMRole{},
+		systemRoles:  map[string]*models.SystemRole{},
+		groupViewers: map[memoryGroupViewerKey]*models.GroupViewer{},
+		outbox:       map[string]*OutboxMessage{},
+
+		accessReviewCampaigns: map[string]*AccessReviewCampaign{},
+		accessReviewItems:     map[string]*AccessReviewItem{},
+
+		roleAssignmentRules: map[string]*RoleAssignmentRule{},
+
+		scimTokens: map[string]*SCIMToken{},
+	}
+}
+
+func (state *memoryState) clone() *memoryState {
+	return &memoryState{
+		tenants:      cloneRows(state.tenants, copyTenant),
+		groups:       cloneRows(state.groups, copyGroup),
+		people:       cloneRows(state.people, copyPerson),
+		crmRoles:     cloneRows(state.crmRoles, copyCRMRole),
+		systemRoles:  cloneRows(state.systemRoles, copySystemRole),
+		groupViewers: cloneRows(state.groupViewers, copyGroupViewer),
+		outbox:       cloneRows(state.outbox, copyOutboxMessage),
+
+		accessReviewCampaigns: cloneRows(state.accessReviewCampaigns, copyAccessReviewCampaign),
+		accessReviewItems:     cloneRows(state.accessReviewItems, copyAccessReviewItem),
+
+		roleAssignmentRules: cloneRows(state.roleAssignmentRules, copyRoleAssignmentRule),
+
+		scimTokens: cloneRows(state.scimTokens, copySCIMToken),
+	}
+}
+
+func cloneRows[K comparable, V any](rows map[K]*V, copyRow func(*V) *V) map[K]*V {
+	res := make(map[K]*V, len(rows))
+	for k, v := range rows {
+		res[k] = copyRow(v)
+	}
+	return res
+}
+
+// tenantGroups returns a tenant's groups ordered by id, so results that postgres leaves unordered are at least stable
+func (state *memoryState) tenantGroups(tenantID string) []*models.Group {
+	groups := []*models.Group{}
+	for _, g := range state.groups {
+		if g.TenantID == tenantID {
+			groups = append(groups, g)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+	return groups
+}
+
+// tenantPeople returns a tenant's people ordered by id
+func (state *memoryState) tenantPeople(tenantID string) []*models.Person {
+	people := []*models.Person{}
+	for _, p := range state.people {
+		if p.TenantID == tenantID {
+			people = append(people, p)
+		}
+	}
+	sort.Slice(people, func(i, j int) bool { return people[i].ID < people[j].ID })
+	return people
+}
+
+// tenantCRMRoles returns a tenant's crm roles ordered by id
+func (state *memoryState) tenantCRMRoles(tenantID string) []*models.CRMRole {
+	crmRoles := []*models.CRMRole{}
+	for _, cr := range state.crmRoles {
+		if cr.TenantID == tenantID {
+			crmRoles = append(crmRoles, cr)
+		}
+	}
+	sort.Slice(crmRoles, func(i, j int) bool { return crmRoles[i].ID < crmRoles[j].ID })
+	return crmRoles
+}
+
+type memoryTx struct {
+	store *MemoryStore
+	// base is the committed state the snapshot was taken from, state the snapshot and writes every write made to it
+	base   *memoryState
+	state  *memoryState
+	writes []func(state *memoryState) error
+	sqlTx  *sql.Tx
+	done   bool
+}
+
+func (tx *memoryTx) Commit() error {
+	tx.store.mu.Lock()
+	defer tx.store.mu.Unlock()
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.finish()
+	if tx.store.state == tx.base {
+		tx.store.state = tx.state
+		return nil
+	}
+	// Something else committed since the transaction began, replay its writes so that isn't lost
+	state := tx.store.state.clone()
+	for _, write := range tx.writes {
+		if err := write(state); err != nil {
+			return err
+		}
+	}
+	tx.store.state = state
+	return nil
+}
+
+func (tx *memoryTx) Rollback() error {
+	tx.store.mu.Lock()
+	defer tx.store.mu.Unlock()
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.finish()
+	return nil
+}
+
+func (tx *memoryTx) finish() {
+	tx.done = true
+	if tx.sqlTx != nil {
+		delete(tx.store.txs, tx.sqlTx)
+	}
+}
+
+type memoryConnector struct {
+	store *MemoryStore
+}
+
+func (connector *memoryConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &memoryConn{store: connector.store}, nil
+}
+
+func (connector *memoryConnector) Driver() driver.Driver {
+	return memoryDriver{}
+}
+
+type memoryDriver struct{}
+
+func (memoryDriver) Open(name string) (driver.Conn, error) {
+	return nil, fmt.Errorf("the in-memory store can only be opened with NewMemoryStore")
+}
+
+type memoryConn struct {
+	store *MemoryStore
+	tx    *memoryTx
+}
+
+func (conn *memoryConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errMemorySQL(query)
+}
+
+func (conn *memoryConn) Close() error {
+	return nil
+}
+
+func (conn *memoryConn) Begin() (driver.Tx, error) {
+	return conn.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (conn *memoryConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	conn.store.mu.Lock()
+	defer conn.store.mu.Unlock()
+	conn.tx = &memoryTx{store: conn.store, base: conn.store.state, state: conn.store.state.clone()}
+	return conn.tx, nil
+}
+
+func (conn *memoryConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if query != memoryBindTxStatement || conn.tx == nil || len(args) != 1 {
+		return nil, errMemorySQL(query)
+	}
+	key, _ := args[0].Value.(string)
+	conn.store.mu.Lock()
+	defer conn.store.mu.Unlock()
+	conn.store.pending[key] = conn.tx
+	return driver.RowsAffected(0), nil
+}
+
+func errMemorySQL(query string) error {
+	return fmt.Errorf("the in-memory store can't run sql, use a repository method instead: %s", query)
+}
+
+// memoryService is the in-memory counterpart of DBService
+type memoryService struct {
+	store *MemoryStore
+	tx    *sql.Tx
+}
+
+func (store *MemoryStore) newMemoryService() *memoryService {
+	return &memoryService{store: store}
+}
+
+func (svc *memoryService) GetTransaction() *sql.Tx {
+	return svc.tx
+}
+
+func (svc *memoryService) SetTransaction(tx *sql.Tx) {
+	svc.tx = tx
+}
+
+func (svc *memoryService) Rollback() error {
+	if svc.tx == nil {
+		return nil
+	}
+	return svc.tx.Rollback()
+}
+
+func (svc *memoryService) Commit() error {
+	if svc.tx == nil {
+		return nil
+	}
+	return svc.tx.Commit()
+}
+
+// read runs fn against the transaction's snapshot, or the committed state when there is no transaction. fn must not modify the state.
+func (svc *memoryService) read(fn func(state *memoryState) error) error {
+	svc.store.mu.Lock()
+	defer svc.store.mu.Unlock()
+	if svc.tx == nil {
+		return fn(svc.store.state)
+	}
+	memTx, ok := svc.store.txs[svc.tx]
+	if !ok || memTx.done {
+		return sql.ErrTxDone
+	}
+	return fn(memTx.state)
+}
+
+// write runs fn against the transaction's snapshot and logs it for commit. Without a transaction fn runs against a copy of the
+// committed state that replaces it only if fn succeeds, so every write is atomic like a single sql statement.
+func (svc *memoryService) write(fn func(state *memoryState) error) error {
+	svc.store.mu.Lock()
+	defer svc.store.mu.Unlock()
+	if svc.tx == nil {
+		state := svc.store.state.clone()
+		if err := fn(state); err != nil {
+			return err
+		}
+		svc.store.state = state
+		return nil
+	}
+	memTx, ok := svc.store.txs[svc.tx]
+	if !ok || memTx.done {
+		return sql.ErrTxDone
+	}
+	if err := fn(memTx.state); err != nil {
+		return err
+	}
+	memTx.writes = append(memTx.writes, fn)
+	return nil
+}
+
+// readCommitted runs fn against the committed state, ignoring the transaction like methods that query svc.db directly
+func (svc *memoryService) readCommitted(fn func(state *memoryState) error) error {
+	svc.store.mu.Lock()
+	defer svc.store.mu.Unlock()
+	return fn(svc.store.state)
+}
+
+func errMemoryDuplicate(table string) error {
+	return fmt.Errorf("models: unable to insert into %s: duplicate key value violates unique constraint \"%s_pkey\"", table, table)
+}
+
+// setColumns copies the whitelisted columns from src to dst, both must point to the same sqlboiler model
+func setColumns(dst, src interface{}, columns []string) error {
+	dstVal := reflect.ValueOf(dst).Elem()
+	srcVal := reflect.ValueOf(src).Elem()
+	typ := dstVal.Type()
+	for _, column := range columns {
+		found := false
+		for i := 0; i < typ.NumField(); i++ {
+			if strings.Split(typ.Field(i).Tag.Get("boil"), ",")[0] == column {
+				dstVal.Field(i).Set(srcVal.Field(i))
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("models: unable to update %s: column %q does not exist", typ.Name(), column)
+		}
+	}
+	return nil
+}
+
+// getColumn returns a model's column value with nulls as nil, used to evaluate filters against rows
+func getColumn(row interface{}, column string) (interface{}, bool) {
+	val := reflect.ValueOf(row).Elem()
+	typ := val.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		if strings.Split(typ.Field(i).Tag.Get("boil"), ",")[0] != column {
+			continue
+		}
+		field := val.Field(i).Interface()
+		// null types are compared by their value, arrays and json as they are
+		if valuer, ok := field.(driver.Valuer); ok && val.Field(i).Kind() != reflect.Slice {
+			v, err := valuer.Value()
+			if err != nil {
+				return nil, true
+			}
+			return v, true
+		}
+		return field, true
+	}
+	return nil, false
+}
+
+// pathLabel converts an id to the label used for it in an ltree group path
+func pathLabel(id string) string {
+	return strings.ReplaceAll(id, "-", "_")
+}
+
+// pathContains is the ltree ancestor <@ descendant check: true when path is ancestor or one of its descendants
+func pathContains(ancestor, path string) bool {
+	return ancestor == "" || path == ancestor || strings.HasPrefix(path, ancestor+".")
+}
+
+// pathLevel is ltree nlevel
+func pathLevel(path string) int {
+	if path == "" {
+		return 0
+	}
+	return strings.Count(path, ".") + 1
+}
+
+// hasOverlap is the postgres array && operator
+func hasOverlap(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchLike implements sql LIKE, % matches any run of characters, _ a single character and \ escapes either
+func matchLike(value, pattern string) bool {
+	v := []rune(value)
+	p := []rune(pattern)
+	var match func(vi, pi int) bool
+	match = func(vi, pi int) bool {
+		for pi < len(p) {
+			switch p[pi] {
+			case '%':
+				for pi < len(p) && p[pi] == '%' {
+					pi++
+				}
+				if pi == len(p) {
+					return true
+				}
+				for i := vi; i <= len(v); i++ {
+					if match(i, pi) {
+						return true
+					}
+				}
+				return false
+			case '_':
+				if vi >= len(v) {
+					return false
+				}
+				vi++
+				pi++
+			default:
+				if p[pi] == '\\' && pi+1 < len(p) {
+					pi++
+				}
+				if vi >= len(v) || v[vi] != p[pi] {
+					return false
+				}
+				vi++
+				pi++
+			}
+		}
+		return vi == len(v)
+	}
+	return match(0, 0)
+}
+
+// pageRows applies sql LIMIT and OFFSET to already ordered rows
+func pageRows[T any](rows []T, limit, offset int) []T {
+	if offset > len(rows) {
+		offset = len(rows)
+	}
+	if offset > 0 {
+		rows = rows[offset:]
+	}
+	if limit >= 0 && limit < len(rows) {
+		rows = rows[:limit]
+	}
+	return rows
+}
+
+// afterIDPage sorts rows by id and returns up to limit of them with an id after afterID, like a keyset page ordered by id
+func afterIDPage[T any](rows []T, id func(T) string, afterID string, limit int) []T {
+	sort.SliceStable(rows, func(i, j int) bool {
+		return id(rows[i]) < id(rows[j])
+	})
+	start := sort.Search(len(rows), func(i int) bool {
+		return id(rows[i]) > afterID
+	})
+	return pageRows(rows[start:], limit, 0)
+}
+
+func copyStrings(values types.StringArray) types.StringArray {
+	if values == nil {
+		return nil
+	}
+	return append(make(types.StringArray, 0, len(values)), values...)
+}
+
+func copyInt64s(values types.Int64Array) types.Int64Array {
+	if values == nil {
+		return nil
+	}
+	return append(make(types.Int64Array, 0, len(values)), values...)
+}
+
+func copyGroup(g *models.Group) *models.Group {
+	c := *g
+	c.RoleIds = copyStrings(g.RoleIds)
+	c.CRMRoleIds = copyStrings(g.CRMRoleIds)
+	c.R = nil
+	return &c
+}
+
+func copyPerson(p *models.Person) *models.Person {
+	c := *p
+	c.RoleIds = copyStrings(p.RoleIds)
+	c.CRMRoleIds = copyStrings(p.CRMRoleIds)
+	c.R = nil
+	return &c
+}
+
+func copyCRMRole(cr *models.CRMRole) *models.CRMRole {
+	c := *cr
+	c.R = nil
+	return &c
+}
+
+func copySystemRole(sr *models.SystemRole) *models.SystemRole {
+	c := *sr
+	c.Permissions = copyInt64s(sr.Permissions)
+	c.GrantedPermissions = copyInt64s(sr.GrantedPermissions)
+	c.RevokedPermissions = copyInt64s(sr.RevokedPermissions)
+	c.R = nil
+	return &c
+}
+
+func copyGroupViewer(gv *models.GroupViewer) *models.GroupViewer {
+	c := *gv
+	c.R = nil
+	return &c
+}
+
+func copyTenant(t *models.Tenant) *models.Tenant {
+	c := *t
+	c.GroupSyncMetadata = append(types.JSON(nil), t.GroupSyncMetadata...)
+	c.PrelaunchState = append(types.JSON(nil), t.PrelaunchState...)
+	c.Permissions = copyInt64s(t.Permissions)
+	c.R = nil
+	return &c
+}
+
+func copyOutboxMessage(msg *OutboxMessage) *OutboxMessage {
+	c := *msg
+	c.Payload = append(types.JSON(nil), msg.Payload...)
+	return &c
+}
+
+func copyAccessReviewCampaign(campaign *AccessReviewCampaign) *AccessReviewCampaign {
+	c := *campaign
+	c.SystemRoleTypes = copyStrings(campaign.SystemRoleTypes)
+	return &c
+}
+
+func copyAccessReviewItem(item *AccessReviewItem) *AccessReviewItem {
+	c := *item
+	return &c
+}
+
+func copyRoleAssignmentRule(rule *RoleAssignmentRule) *RoleAssignmentRule {
+	c := *rule
+	return &c
+}
+
+func copySCIMToken(token *SCIMToken) *SCIMToken {
+	c := *token
+	return &c
+}
+
+func memoryNow() time.Time {
+	return time.Now().UTC()
+}
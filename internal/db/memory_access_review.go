@@ -0,0 +1,265 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+// MemoryAccessReviewService is the in-memory AccessReviewRepository
+type MemoryAccessReviewService struct {
+	*memoryService
+}
+
+var _ AccessReviewRepository = (*MemoryAccessReviewService)(nil)
+
+// sortAccessReviewItems orders items like the sql: by kind, person, group then system role
+func sortAccessReviewItems(items []*AccessReviewItem) {
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.PersonID != b.PersonID {
+			return a.PersonID < b.PersonID
+		}
+		if a.GroupID.String != b.GroupID.String {
+			return a.GroupID.String < b.GroupID.String
+		}
+		return a.SystemRoleID.String < b.SystemRoleID.String
+	})
+}
+
+func (svc *MemoryAccessReviewService) Open(ctx context.Context, campaign *AccessReviewCampaign) ([]*AccessReviewItem, error) {
+	items := []*AccessReviewItem{}
+	err := svc.write(func(state *memoryState) error {
+		for _, c := range state.accessReviewCampaigns {
+			if c.TenantID == campaign.TenantID && c.Status == AccessReviewStatusOpen {
+				return fmt.Errorf("tenant already has an open access review campaign")
+			}
+		}
+		if _, ok := state.accessReviewCampaigns[campaign.ID]; ok {
+			return errMemoryDuplicate("access_review_campaign")
+		}
+
+		campaign.Status = AccessReviewStatusOpen
+		campaign.UpdatedAt = campaign.CreatedAt
+		state.accessReviewCampaigns[campaign.ID] = copyAccessReviewCampaign(campaign)
+
+		for _, gv := range state.groupViewers {
+			if gv.TenantID != campaign.TenantID || (gv.EndsAt.Valid && !gv.EndsAt.Time.After(campaign.CreatedAt)) {
+				continue
+			}
+			p, ok := state.people[memoryKey{tenantID: gv.TenantID, id: gv.PersonID}]
+			if !ok {
+				continue
+			}
+			items = append(items, newAccessReviewItem(campaign, &accessReviewSubject{
+				Kind:        AccessReviewKindGroupViewer,
+				PersonID:    gv.PersonID,
+				GroupID:     null.StringFrom(gv.GroupID),
+				Permissions: gv.Permissions,
+				ManagerID:   p.ManagerID,
+			}))
+		}
+
+		for _, p := range state.tenantPeople(campaign.TenantID) {
+			for _, roleID := range p.RoleIds {
+				sr, ok := state.systemRoles[roleID]
+				if !ok || !containsString(campaign.SystemRoleTypes, sr.Type) {
+					continue
+				}
+				items = append(items, newAccessReviewItem(campaign, &accessReviewSubject{
+					Kind:         AccessReviewKindSystemRole,
+					PersonID:     p.ID,
+					SystemRoleID: null.StringFrom(roleID),
+					ManagerID:    p.ManagerID,
+				}))
+			}
+		}
+
+		for _, item := range items {
+			state.accessReviewItems[item.ID] = copyAccessReviewItem(item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortAccessReviewItems(items)
+	return items, nil
+}
+
+func (svc *MemoryAccessReviewService) GetCampaign(ctx context.Context, tenantID, id string) (*AccessReviewCampaign, error) {
+	var result *AccessReviewCampaign
+	err := svc.read(func(state *memoryState) error {
+		campaign, ok := state.accessReviewCampaigns[id]
+		if !ok || campaign.TenantID != tenantID {
+			return sql.ErrNoRows
+		}
+		result = copyAccessReviewCampaign(campaign)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (svc *MemoryAccessReviewService) GetCampaigns(ctx context.Context, tenantID, status string) ([]*AccessReviewCampaign, error) {
+	results := []*AccessReviewCampaign{}
+	err := svc.read(func(state *memoryState) error {
+		for _, campaign := range state.accessReviewCampaigns {
+			if campaign.TenantID == tenantID && (status == "" || campaign.Status == status) {
+				results = append(results, copyAccessReviewCampaign(campaign))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].CreatedAt.Equal(results[j].CreatedAt) {
+			return results[i].CreatedAt.After(results[j].CreatedAt)
+		}
+		return results[i].ID < results[j].ID
+	})
+	return results, nil
+}
+
+func (svc *MemoryAccessReviewService) GetItems(ctx context.Context, tenantID, campaignID, reviewerID, decision string) ([]*AccessReviewItem, error) {
+	results := []*AccessReviewItem{}
+	err := svc.read(func(state *memoryState) error {
+		results = state.accessReviewCampaignItems(tenantID, campaignID, reviewerID, decision)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// accessReviewCampaignItems returns copies of a campaign's items in sql order
+func (state *memoryState) accessReviewCampaignItems(tenantID, campaignID, reviewerID, decision string) []*AccessReviewItem {
+	results := []*AccessReviewItem{}
+	for _, item := range state.accessReviewItems {
+		if item.TenantID != tenantID || item.CampaignID != campaignID {
+			continue
+		}
+		if (reviewerID != "" && item.ReviewerID != reviewerID) || (decision != "" && item.Decision != decision) {
+			continue
+		}
+		results = append(results, copyAccessReviewItem(item))
+	}
+	sortAccessReviewItems(results)
+	return results
+}
+
+// openAccessReviewCampaign returns the tenant's campaign if it is open
+func (state *memoryState) openAccessReviewCampaign(tenantID, campaignID string) (*AccessReviewCampaign, error) {
+	campaign, ok := state.accessReviewCampaigns[campaignID]
+	if !ok || campaign.TenantID != tenantID || campaign.Status != AccessReviewStatusOpen {
+		return nil, ErrAccessReviewNotOpen
+	}
+	return campaign, nil
+}
+
+func (svc *MemoryAccessReviewService) Decide(ctx context.Context, tenantID, campaignID, itemID, decision, decidedBy, comment string, at time.Time) error {
+	return svc.write(func(state *memoryState) error {
+		if _, err := state.openAccessReviewCampaign(tenantID, campaignID); err != nil {
+			return err
+		}
+		item, ok := state.accessReviewItems[itemID]
+		if !ok || item.TenantID != tenantID || item.CampaignID != campaignID {
+			return ErrAccessReviewNotOpen
+		}
+		item.Decision = decision
+		item.DecidedBy = null.StringFrom(decidedBy)
+		item.DecidedAt = null.TimeFrom(at)
+		item.Comment = null.NewString(comment, comment != "")
+		item.UpdatedAt = at
+		return nil
+	})
+}
+
+func (svc *MemoryAccessReviewService) Close(ctx context.Context, tenantID, campaignID, closedBy string, at time.Time) ([]*AccessReviewItem, error) {
+	results := []*AccessReviewItem{}
+	err := svc.write(func(state *memoryState) error {
+		campaign, err := state.openAccessReviewCampaign(tenantID, campaignID)
+		if err != nil {
+			return err
+		}
+		campaign.Status = AccessReviewStatusClosed
+		campaign.ClosedBy = null.StringFrom(closedBy)
+		campaign.ClosedAt = null.TimeFrom(at)
+		campaign.UpdatedAt = at
+
+		for _, item := range state.accessReviewItems {
+			if item.TenantID != tenantID || item.CampaignID != campaignID {
+				continue
+			}
+			item.UpdatedAt = at
+			if item.Decision == AccessReviewDecisionKeep {
+				item.Outcome = null.StringFrom(AccessReviewOutcomeKept)
+				continue
+			}
+			item.Outcome = null.StringFrom(AccessReviewOutcomeGone)
+			switch item.Kind {
+			case AccessReviewKindGroupViewer:
+				key := memoryGroupViewerKey{tenantID: tenantID, groupID: item.GroupID.String, personID: item.PersonID}
+				if _, ok := state.groupViewers[key]; ok {
+					delete(state.groupViewers, key)
+					item.Outcome = null.StringFrom(AccessReviewOutcomeRevoked)
+				}
+			case AccessReviewKindSystemRole:
+				p, ok := state.people[memoryKey{tenantID: tenantID, id: item.PersonID}]
+				if !ok || !containsString(p.RoleIds, item.SystemRoleID.String) {
+					continue
+				}
+				roleIDs := types.StringArray{}
+				for _, roleID := range p.RoleIds {
+					if roleID != item.SystemRoleID.String {
+						roleIDs = append(roleIDs, roleID)
+					}
+				}
+				p.RoleIds = roleIDs
+				p.RoleIdsLocked = true
+				p.UpdatedAt = at
+				p.UpdatedBy = closedBy
+				item.Outcome = null.StringFrom(AccessReviewOutcomeRevoked)
+			}
+		}
+
+		results = state.accessReviewCampaignItems(tenantID, campaignID, "", "")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (svc *MemoryAccessReviewService) GetRevokedSystemRoles(ctx context.Context, tenantID string) (map[string][]string, error) {
+	results := map[string][]string{}
+	err := svc.read(func(state *memoryState) error {
+		for _, item := range state.accessReviewItems {
+			if item.TenantID != tenantID || item.Kind != AccessReviewKindSystemRole || item.Outcome.String != AccessReviewOutcomeRevoked {
+				continue
+			}
+			if !containsString(results[item.PersonID], item.SystemRoleID.String) {
+				results[item.PersonID] = append(results[item.PersonID], item.SystemRoleID.String)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,241 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/loupe-co/orchard/internal/models"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+)
+
+// MemoryCRMRoleService is the in-memory CRMRoleRepository
+type MemoryCRMRoleService struct {
+	*memoryService
+}
+
+var _ CRMRoleRepository = (*MemoryCRMRoleService)(nil)
+
+func (svc *MemoryCRMRoleService) FromProto(cr *orchardPb.CRMRole) *models.CRMRole {
+	return (&CRMRoleService{}).FromProto(cr)
+}
+
+func (svc *MemoryCRMRoleService) ToProto(cr *models.CRMRole) (*orchardPb.CRMRole, error) {
+	return (&CRMRoleService{}).ToProto(cr)
+}
+
+func (svc *MemoryCRMRoleService) Insert(ctx context.Context, cr *models.CRMRole) error {
+	if cr.UpdatedAt.IsZero() {
+		cr.UpdatedAt = memoryNow()
+	}
+	return svc.write(func(state *memoryState) error {
+		key := memoryKey{cr.TenantID, cr.ID}
+		if _, ok := state.crmRoles[key]; ok {
+			return errMemoryDuplicate("crm_role")
+		}
+		state.crmRoles[key] = copyCRMRole(cr)
+		return nil
+	})
+}
+
+func (svc *MemoryCRMRoleService) UpsertAll(ctx context.Context, crmRoles []*models.CRMRole) error {
+	if len(crmRoles) == 0 {
+		return nil
+	}
+	return svc.write(func(state *memoryState) error {
+		for _, role := range crmRoles {
+			key := memoryKey{role.TenantID, role.ID}
+			existing, ok := state.crmRoles[key]
+			if !ok {
+				state.crmRoles[key] = &models.CRMRole{
+					ID:          role.ID,
+					TenantID:    role.TenantID,
+					Name:        role.Name,
+					Description: role.Description,
+					ParentID:    role.ParentID,
+					UpdatedAt:   role.UpdatedAt,
+				}
+				continue
+			}
+			existing.Name = role.Name
+			existing.Description = role.Description
+			existing.ParentID = role.ParentID
+			existing.UpdatedAt = role.UpdatedAt
+		}
+		return nil
+	})
+}
+
+// outreachView returns a copy of the role identified by its outreach id, like the isOutreach lookups
+func outreachView(cr *models.CRMRole, isOutreach bool) *models.CRMRole {
+	res := copyCRMRole(cr)
+	if isOutreach {
+		res.ID = res.OutreachID.String
+	}
+	return res
+}
+
+func (svc *MemoryCRMRoleService) GetByID(ctx context.Context, id, tenantID string, isOutreach bool) (*models.CRMRole, error) {
+	var crmRole *models.CRMRole
+	err := svc.read(func(state *memoryState) error {
+		for _, cr := range state.tenantCRMRoles(tenantID) {
+			if (isOutreach && cr.OutreachID.Valid && cr.OutreachID.String == id) || (!isOutreach && cr.ID == id) {
+				crmRole = outreachView(cr, isOutreach)
+				return nil
+			}
+		}
+		return sql.ErrNoRows
+	})
+	if err != nil {
+		return nil, err
+	}
+	return crmRole, nil
+}
+
+func (svc *MemoryCRMRoleService) getByIDs(tenantID string, byOutreachID bool, ids []string) ([]*models.CRMRole, error) {
+	crmRoles := []*models.CRMRole{}
+	err := svc.read(func(state *memoryState) error {
+		for _, cr := range state.tenantCRMRoles(tenantID) {
+			if (byOutreachID && cr.OutreachID.Valid && containsString(ids, cr.OutreachID.String)) || (!byOutreachID && containsString(ids, cr.ID)) {
+				crmRoles = append(crmRoles, copyCRMRole(cr))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return crmRoles, nil
+}
+
+func (svc *MemoryCRMRoleService) GetByIDs(ctx context.Context, tenantID string, isOutreach bool, ids ...string) ([]*models.CRMRole, error) {
+	crmRoles, err := svc.getByIDs(tenantID, isOutreach, ids)
+	if err != nil {
+		return nil, err
+	}
+	if isOutreach {
+		for _, cr := range crmRoles {
+			cr.ID = cr.OutreachID.String
+		}
+	}
+	return crmRoles, nil
+}
+
+func commitMappings(crmRoles []*models.CRMRole) (map[string]string, map[string]string) {
+	outreachToCommitMapping := map[string]string{}
+	commitToOutreachMapping := map[string]string{}
+	for _, cr := range crmRoles {
+		outreachToCommitMapping[cr.OutreachID.String] = cr.ID
+		commitToOutreachMapping[cr.ID] = cr.OutreachID.String
+	}
+	return outreachToCommitMapping, commitToOutreachMapping
+}
+
+func (svc *MemoryCRMRoleService) GetOutreachCommitMappingsByCommitIDs(ctx context.Context, tenantID string, ids ...string) (map[string]string, map[string]string, error) {
+	crmRoles, err := svc.getByIDs(tenantID, false, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	outreachToCommitMapping, commitToOutreachMapping := commitMappings(crmRoles)
+	return outreachToCommitMapping, commitToOutreachMapping, nil
+}
+
+func (svc *MemoryCRMRoleService) GetOutreachCommitMappingsByOutreachIDs(ctx context.Context, tenantID string, ids ...string) (map[string]string, map[string]string, error) {
+	crmRoles, err := svc.getByIDs(tenantID, true, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	outreachToCommitMapping, commitToOutreachMapping := commitMappings(crmRoles)
+	return outreachToCommitMapping, commitToOutreachMapping, nil
+}
+
+func (svc *MemoryCRMRoleService) GetUnsynced(ctx context.Context, tenantID string, isOutreach bool) ([]*models.CRMRole, error) {
+	results := []*models.CRMRole{}
+	err := svc.read(func(state *memoryState) error {
+		groups := state.tenantGroups(tenantID)
+		for _, cr := range state.tenantCRMRoles(tenantID) {
+			synced := false
+			for _, g := range groups {
+				if containsString(g.CRMRoleIds, cr.ID) {
+					synced = true
+					break
+				}
+			}
+			if !synced {
+				results = append(results, outreachView(cr, isOutreach))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (svc *MemoryCRMRoleService) Search(ctx context.Context, tenantID, query string, limit, offset int, isOutreach bool) ([]*models.CRMRole, int64, error) {
+	pattern := "%" + strings.ToLower(query) + "%"
+	crmRoles := []*models.CRMRole{}
+	err := svc.read(func(state *memoryState) error {
+		for _, cr := range state.crmRoles {
+			if tenantID != "" && cr.TenantID != tenantID {
+				continue
+			}
+			if query != "" && !matchLike(strings.ToLower(cr.Name), pattern) {
+				continue
+			}
+			crmRoles = append(crmRoles, copyCRMRole(cr))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	total := int64(len(crmRoles))
+
+	sort.Slice(crmRoles, func(i, j int) bool {
+		a, b := crmRoles[i], crmRoles[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.TenantID != b.TenantID {
+			return a.TenantID < b.TenantID
+		}
+		return a.ID < b.ID
+	})
+	crmRoles = pageRows(crmRoles, limit, offset)
+
+	results := make([]*models.CRMRole, len(crmRoles))
+	for i, cr := range crmRoles {
+		results[i] = outreachView(cr, isOutreach)
+	}
+	return results, total, nil
+}
+
+func (svc *MemoryCRMRoleService) DeleteByID(ctx context.Context, id, tenantID string) error {
+	return svc.write(func(state *memoryState) error {
+		key := memoryKey{tenantID, id}
+		if _, ok := state.crmRoles[key]; !ok {
+			return fmt.Errorf("error deleting crmRole: delete affected 0 rows")
+		}
+		delete(state.crmRoles, key)
+		return nil
+	})
+}
+
+func (svc *MemoryCRMRoleService) DeleteUnSynced(ctx context.Context, tenantID string, syncedIDs ...interface{}) error {
+	ids := make([]string, len(syncedIDs))
+	for i, id := range syncedIDs {
+		ids[i] = fmt.Sprint(id)
+	}
+	return svc.write(func(state *memoryState) error {
+		for _, cr := range state.tenantCRMRoles(tenantID) {
+			if !containsString(ids, cr.ID) {
+				delete(state.crmRoles, memoryKey{tenantID, cr.ID})
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/loupe-co/orchard/internal/models"
+)
+
+// MemoryDirectoryService is the in-memory DirectoryRepository
+type MemoryDirectoryService struct {
+	*memoryService
+}
+
+var _ DirectoryRepository = (*MemoryDirectoryService)(nil)
+
+// memoryWordSimilarityThreshold is pg_trgm's default pg_trgm.word_similarity_threshold
+const memoryWordSimilarityThreshold = 0.6
+
+// trigrams is pg_trgm's trigram set of s: every word is lower cased and padded with two spaces in front and one behind
+func trigrams(s string) map[string]struct{} {
+	set := map[string]struct{}{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127)
+	}) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = struct{}{}
+		}
+	}
+	return set
+}
+
+// wordSimilarity approximates pg_trgm word_similarity: the share of query's trigrams that are also in text. pg_trgm only counts the
+// best contiguous extent of text, which scores a multi word query against scattered words lower than this does.
+func wordSimilarity(query, text string) float64 {
+	queryTrigrams := trigrams(query)
+	if len(queryTrigrams) == 0 {
+		return 0
+	}
+	textTrigrams := trigrams(text)
+	shared := 0
+	for t := range queryTrigrams {
+		if _, ok := textTrigrams[t]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(queryTrigrams))
+}
+
+// directoryScore is the score the directory queries give fields, 0 when none of them match
+func directoryScore(query string, fields ...string) float64 {
+	score, prefix := 0.0, false
+	for _, field := range fields {
+		field = strings.ToLower(field)
+		if s := wordSimilarity(query, field); s > score {
+			score = s
+		}
+		prefix = prefix || strings.HasPrefix(field, query)
+	}
+	if score < memoryWordSimilarityThreshold {
+		return 0
+	}
+	if prefix {
+		score += directoryPrefixBoost
+	}
+	return score
+}
+
+func (svc *MemoryDirectoryService) Search(ctx context.Context, tenantID string, search DirectorySearch) ([]*DirectoryHit, error) {
+	query := strings.ToLower(strings.TrimSpace(search.Query))
+	hits := []*DirectoryHit{}
+
+	err := svc.read(func(state *memoryState) error {
+		viewablePaths := []string{}
+		for _, gv := range state.tenantGroupViewers(tenantID) {
+			if gv.PersonID != search.ViewerID || !GroupViewerActive(gv, memoryNow()) {
+				continue
+			}
+			if g, ok := state.groups[memoryKey{tenantID, gv.GroupID}]; ok && g.GroupPath != "" {
+				viewablePaths = append(viewablePaths, g.GroupPath)
+			}
+		}
+		viewable := func(g *models.Group) bool {
+			if search.ViewerID == "" {
+				return true
+			}
+			for _, path := range viewablePaths {
+				if pathContains(path, g.GroupPath) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if search.People {
+			for _, p := range state.tenantPeople(tenantID) {
+				if search.ViewerID != "" {
+					g, ok := state.groups[memoryKey{tenantID, p.GroupID.String}]
+					if !p.GroupID.Valid || !ok || !viewable(g) {
+						continue
+					}
+				}
+				if score := directoryScore(query, p.Name.String, p.FirstName.String, p.LastName.String, p.Email.String); score > 0 {
+					hits = append(hits, &DirectoryHit{Type: DirectoryPerson, Score: score, Person: copyPerson(p)})
+				}
+			}
+		}
+
+		if search.Groups {
+			for _, g := range state.tenantGroups(tenantID) {
+				if g.Status != "active" || !viewable(g) {
+					continue
+				}
+				score := wordSimilarity(query, strings.ToLower(g.Name))
+				nameMatch := score >= memoryWordSimilarityThreshold
+				crmRoleNames := []string{}
+				for _, cr := range state.tenantCRMRoles(tenantID) {
+					if !containsString(g.CRMRoleIds, cr.ID) {
+						continue
+					}
+					if crScore := wordSimilarity(query, strings.ToLower(cr.Name)); crScore >= memoryWordSimilarityThreshold {
+						crmRoleNames = append(crmRoleNames, cr.Name)
+						if crScore > score {
+							score = crScore
+						}
+					}
+				}
+				if !nameMatch && len(crmRoleNames) == 0 {
+					continue
+				}
+				if strings.HasPrefix(strings.ToLower(g.Name), query) {
+					score += directoryPrefixBoost
+				}
+				sort.Strings(crmRoleNames)
+				hits = append(hits, &DirectoryHit{Type: DirectoryGroup, Score: score, Group: copyGroup(g), CRMRoleNames: crmRoleNames})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rankDirectoryHits(hits, search.Limit), nil
+}
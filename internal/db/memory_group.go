@@ -0,0 +1,810 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/loupe-co/orchard/internal/models"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	null "github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+// MemoryGroupService is the in-memory GroupRepository
+type MemoryGroupService struct {
+	*memoryService
+}
+
+var _ GroupRepository = (*MemoryGroupService)(nil)
+
+func (svc *MemoryGroupService) FromProto(g *orchardPb.Group) *models.Group {
+	return (&GroupService{}).FromProto(g)
+}
+
+func (svc *MemoryGroupService) ToProto(g *models.Group) (*orchardPb.Group, error) {
+	return (&GroupService{}).ToProto(g)
+}
+
+func (svc *MemoryGroupService) Insert(ctx context.Context, g *models.Group) error {
+	g.GroupPath = strings.ReplaceAll(g.GroupPath, "-", "_")
+	currTime := memoryNow()
+	return svc.write(func(state *memoryState) error {
+		key := memoryKey{g.TenantID, g.ID}
+		if _, ok := state.groups[key]; ok {
+			return errMemoryDuplicate("group")
+		}
+		if g.CreatedAt.IsZero() {
+			g.CreatedAt = currTime
+		}
+		if g.UpdatedAt.IsZero() {
+			g.UpdatedAt = currTime
+		}
+		state.groups[key] = copyGroup(g)
+		return nil
+	})
+}
+
+func (svc *MemoryGroupService) GetByID(ctx context.Context, id, tenantID string) (*models.Group, error) {
+	var group *models.Group
+	err := svc.read(func(state *memoryState) error {
+		if g, ok := state.groups[memoryKey{tenantID, id}]; ok {
+			group = copyGroup(g)
+		}
+		return nil
+	})
+	return group, err
+}
+
+func (svc *MemoryGroupService) CheckDuplicateCRMRoleIDs(ctx context.Context, id, tenantID string, crmRolesIDs []string) (bool, error) {
+	hasDups := false
+	err := svc.read(func(state *memoryState) error {
+		for _, g := range state.tenantGroups(tenantID) {
+			if g.ID != id && hasOverlap(g.CRMRoleIds, crmRolesIDs) {
+				hasDups = true
+			}
+		}
+		return nil
+	})
+	return hasDups, err
+}
+
+func (svc *MemoryGroupService) Search(ctx context.Context, tenantID, query string, filters ...Filter) ([]*models.Group, error) {
+	filter := Filter{And: filters}
+	if err := ValidateFilter(filter, GroupFilterColumns); len(filters) > 0 && err != nil {
+		return nil, err
+	}
+
+	groups := []*models.Group{}
+	err := svc.read(func(state *memoryState) error {
+		pattern := "%" + strings.ToLower(query) + "%"
+		for _, g := range state.tenantGroups(tenantID) {
+			if query != "" && !matchLike(strings.ToLower(g.Name), pattern) {
+				continue
+			}
+			if len(filters) > 0 {
+				ok, err := matchFilter(g, filter)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+			}
+			groups = append(groups, copyGroup(g))
+		}
+		return nil
+	})
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Order != groups[j].Order {
+			return groups[i].Order < groups[j].Order
+		}
+		return groups[i].Name < groups[j].Name
+	})
+	return groups, err
+}
+
+func (svc *MemoryGroupService) SearchPage(ctx context.Context, tenantID, query string, page Page, filters ...Filter) ([]*models.Group, int64, error) {
+	if _, ok := GroupSortColumns[page.SortBy]; !ok {
+		return nil, 0, fmt.Errorf("can't sort groups by %q", page.SortBy)
+	}
+	groups, err := svc.Search(ctx, tenantID, query, filters...)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := int64(0)
+	if page.CountTotal {
+		total = int64(len(groups))
+	}
+	return memoryPage(groups, page, GroupSortValue, func(g *models.Group) string { return g.ID }), total, nil
+}
+
+func (svc *MemoryGroupService) SearchAfter(ctx context.Context, tenantID, query, afterID string, limit int) ([]*models.Group, error) {
+	groups := []*models.Group{}
+	err := svc.read(func(state *memoryState) error {
+		pattern := "%" + strings.ToLower(query) + "%"
+		for _, g := range state.tenantGroups(tenantID) {
+			if query != "" && !matchLike(strings.ToLower(g.Name), pattern) {
+				continue
+			}
+			groups = append(groups, copyGroup(g))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return afterIDPage(groups, func(g *models.Group) string { return g.ID }, afterID, limit), nil
+}
+
+func (svc *MemoryGroupService) GetManagerAndParentIDs(ctx context.Context, tenantID, personID string) (managerID, parentID, personType string, err error) {
+	err = svc.read(func(state *memoryState) error {
+		p, ok := state.people[memoryKey{tenantID, personID}]
+		if !ok || !p.GroupID.Valid {
+			return nil
+		}
+		g, ok := state.groups[memoryKey{tenantID, p.GroupID.String}]
+		if !ok {
+			return nil
+		}
+		managerID = p.ManagerID.String
+		parentID = g.ID
+		personType = p.Type
+		return nil
+	})
+	return
+}
+
+func (svc *MemoryGroupService) GetGroupSubTree(ctx context.Context, tenantID, groupID string, maxDepth int, hydrateUsers bool, simplify bool, activeUsers bool, useManagerNames bool, excludeManagerUsers bool, viewableGroups ...string) ([]*GroupTreeNode, error) {
+	if maxDepth < 0 {
+		maxDepth = 1000000
+	}
+
+	results := []*GroupTreeNode{}
+	err := svc.read(func(state *memoryState) error {
+		isSelected := func(g *models.Group) bool {
+			tenantPath := pathLabel(tenantID)
+			return (g.ParentID.Valid && g.ParentID.String == "") ||
+				(pathContains(tenantPath, g.GroupPath) && pathLevel(g.GroupPath)-pathLevel(tenantPath) <= maxDepth+1)
+		}
+		if groupID != "" || len(viewableGroups) > 0 {
+			roots := append([]string{}, viewableGroups...)
+			if groupID != "" {
+				roots = append([]string{groupID}, roots...)
+			}
+			isSelected = func(g *models.Group) bool {
+				for _, rootID := range roots {
+					if g.ID == rootID {
+						return true
+					}
+					root, ok := state.groups[memoryKey{tenantID, rootID}]
+					if ok && pathContains(root.GroupPath, g.GroupPath) && pathLevel(g.GroupPath)-pathLevel(root.GroupPath) <= maxDepth {
+						return true
+					}
+				}
+				return false
+			}
+		}
+
+		for _, g := range state.tenantGroups(tenantID) {
+			if g.Status != "active" || !isSelected(g) {
+				continue
+			}
+			node := &GroupTreeNode{Group: *copyGroup(g), Members: []models.Person{}, MembersRaw: types.StringArray{}}
+			for _, p := range state.tenantPeople(tenantID) {
+				if p.GroupID.String != g.ID || !p.GroupID.Valid || (activeUsers && p.Status != "active") {
+					continue
+				}
+				if p.Status == "active" {
+					node.ActiveMemberCount++
+				}
+				if excludeManagerUsers && g.Type != "ic" && p.Type != models.PersonTypePlayerCoach {
+					continue
+				}
+				member := models.Person{ID: p.ID}
+				if hydrateUsers {
+					member = *copyPerson(p)
+					member.CreatedAt = member.CreatedAt.UTC().Truncate(time.Second)
+					member.UpdatedAt = member.UpdatedAt.UTC().Truncate(time.Second)
+					member.OutreachID = null.String{}
+					member.OutreachIsAdmin = null.Bool{}
+					member.OutreachGUID = null.String{}
+					member.OutreachRoleID = null.String{}
+				} else if useManagerNames {
+					member = models.Person{ID: p.ID, Name: p.Name, Status: p.Status, Type: p.Type, PhotoURL: p.PhotoURL}
+				}
+				node.Members = append(node.Members, member)
+				node.MembersRaw = append(node.MembersRaw, memberRaw(&member, hydrateUsers || useManagerNames))
+			}
+			results = append(results, node)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	if useManagerNames {
+		for _, node := range results {
+			if node.Type == "manager" && len(node.Members) == 1 && node.Members[0].Status == "active" {
+				node.Name = node.Members[0].Name.String
+			}
+			// the manager name query doesn't select the active member count
+			node.ActiveMemberCount = 0
+		}
+	}
+
+	return results, nil
+}
+
+func memberRaw(member *models.Person, hydrated bool) string {
+	if !hydrated {
+		return member.ID
+	}
+	raw, _ := json.Marshal(member)
+	return string(raw)
+}
+
+func (svc *MemoryGroupService) GetFullTenantTree(ctx context.Context, tenantID string, hydrateUsers bool) ([]*GroupTreeNode, error) {
+	results := []*GroupTreeNode{}
+	err := svc.read(func(state *memoryState) error {
+		for _, g := range state.tenantGroups(tenantID) {
+			if g.Status != "active" {
+				continue
+			}
+			node := &GroupTreeNode{Group: *copyGroup(g), Members: []models.Person{}, MembersRaw: types.StringArray{}}
+			for _, p := range state.tenantPeople(tenantID) {
+				if !p.GroupID.Valid || p.GroupID.String != g.ID {
+					continue
+				}
+				member := models.Person{ID: p.ID}
+				if hydrateUsers {
+					member = *copyPerson(p)
+					member.CreatedAt = member.CreatedAt.UTC().Truncate(time.Second)
+					member.UpdatedAt = member.UpdatedAt.UTC().Truncate(time.Second)
+					member.PhotoURL = null.String{}
+					member.Type = ""
+					member.OutreachGUID = null.String{}
+					member.OutreachRoleID = null.String{}
+				}
+				node.Members = append(node.Members, member)
+				node.MembersRaw = append(node.MembersRaw, memberRaw(&member, hydrateUsers))
+			}
+			results = append(results, node)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results, nil
+}
+
+func (svc *MemoryGroupService) Update(ctx context.Context, g *models.Group, onlyFields []string) error {
+	whitelist := defaultGroupUpdateWhitelist
+	if len(onlyFields) > 0 {
+		whitelist = onlyFields
+	}
+	var hasUpdatedAt bool
+	for _, f := range whitelist {
+		if f == "updated_at" {
+			hasUpdatedAt = true
+		}
+	}
+	if !hasUpdatedAt {
+		whitelist = append(whitelist, "updated_at")
+	}
+
+	g.GroupPath = strings.ReplaceAll(g.GroupPath, "-", "_")
+	g.UpdatedAt = memoryNow()
+
+	return svc.write(func(state *memoryState) error {
+		key := memoryKey{g.TenantID, g.ID}
+		existing, ok := state.groups[key]
+		if !ok {
+			return fmt.Errorf("error updating group: update affected 0 rows")
+		}
+		updated := copyGroup(existing)
+		if err := setColumns(updated, copyGroup(g), whitelist); err != nil {
+			return err
+		}
+		state.groups[key] = updated
+		return nil
+	})
+}
+
+func (svc *MemoryGroupService) UpdateGroupPaths(ctx context.Context, tenantID string) error {
+	return svc.write(func(state *memoryState) error {
+		groups := state.tenantGroups(tenantID)
+		queue := []*models.Group{}
+		for _, g := range groups {
+			if g.Status == "active" && g.ParentID.String == "" {
+				g.GroupPath = pathLabel(tenantID) + "." + pathLabel(g.ID)
+				queue = append(queue, g)
+			}
+		}
+		visited := map[string]bool{}
+		for len(queue) > 0 {
+			parent := queue[0]
+			queue = queue[1:]
+			if visited[parent.ID] {
+				continue
+			}
+			visited[parent.ID] = true
+			for _, g := range groups {
+				if g.Status == "active" && g.ParentID.Valid && g.ParentID.String == parent.ID && !visited[g.ID] {
+					g.GroupPath = parent.GroupPath + "." + pathLabel(g.ID)
+					queue = append(queue, g)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (svc *MemoryGroupService) Reload(ctx context.Context, group *models.Group) error {
+	return svc.read(func(state *memoryState) error {
+		g, ok := state.groups[memoryKey{group.TenantID, group.ID}]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		*group = *copyGroup(g)
+		return nil
+	})
+}
+
+func (svc *MemoryGroupService) DeleteByID(ctx context.Context, id, tenantID string) error {
+	return svc.write(func(state *memoryState) error {
+		key := memoryKey{tenantID, id}
+		if _, ok := state.groups[key]; !ok {
+			return fmt.Errorf("error deleting group: delete affected 0 rows")
+		}
+		delete(state.groups, key)
+		return nil
+	})
+}
+
+func (svc *MemoryGroupService) SoftDeleteByID(ctx context.Context, id, tenantID, userID string) error {
+	currTime := memoryNow()
+	return svc.write(func(state *memoryState) error {
+		g, ok := state.groups[memoryKey{tenantID, id}]
+		if !ok {
+			return fmt.Errorf("error soft deleting group: delete affected 0 rows")
+		}
+		g.Status = "inactive"
+		g.CRMRoleIds = types.StringArray{}
+		g.UpdatedBy = userID
+		g.UpdatedAt = currTime
+		return nil
+	})
+}
+
+// subTreeGroupIDs returns the ids of a group and all of its descendants by group path, whatever their status
+func (state *memoryState) subTreeGroupIDs(tenantID, id string) map[string]bool {
+	ids := map[string]bool{}
+	root, ok := state.groups[memoryKey{tenantID, id}]
+	if !ok {
+		return ids
+	}
+	for _, g := range state.tenantGroups(tenantID) {
+		if pathContains(root.GroupPath, g.GroupPath) {
+			ids[g.ID] = true
+		}
+	}
+	return ids
+}
+
+func (svc *MemoryGroupService) SoftDeleteGroupChildren(ctx context.Context, id, tenantID, userID string) error {
+	currTime := memoryNow()
+	return svc.write(func(state *memoryState) error {
+		ids := state.subTreeGroupIDs(tenantID, id)
+		for _, p := range state.tenantPeople(tenantID) {
+			if p.GroupID.Valid && ids[p.GroupID.String] {
+				p.GroupID = null.String{}
+				p.UpdatedAt = currTime
+				p.UpdatedBy = userID
+			}
+		}
+		for _, g := range state.tenantGroups(tenantID) {
+			if ids[g.ID] {
+				g.ParentID = null.String{}
+				g.Status = "inactive"
+				g.CRMRoleIds = types.StringArray{}
+				g.UpdatedAt = currTime
+				g.UpdatedBy = userID
+			}
+		}
+		return nil
+	})
+}
+
+func (svc *MemoryGroupService) SoftDeleteTenantGroups(ctx context.Context, tenantID, userID string) error {
+	currTime := memoryNow()
+	return svc.write(func(state *memoryState) error {
+		for _, g := range state.tenantGroups(tenantID) {
+			g.Status = "inactive"
+			g.CRMRoleIds = types.StringArray{}
+			g.UpdatedBy = userID
+			g.UpdatedAt = currTime
+		}
+		return nil
+	})
+}
+
+func (svc *MemoryGroupService) TransferGroupChildrenParent(ctx context.Context, groupID, tenantID, userID string) error {
+	currTime := memoryNow()
+	return svc.write(func(state *memoryState) error {
+		parentID := null.String{}
+		if g, ok := state.groups[memoryKey{tenantID, groupID}]; ok {
+			parentID = g.ParentID
+		}
+		for _, g := range state.tenantGroups(tenantID) {
+			if g.ParentID.Valid && g.ParentID.String == groupID {
+				g.ParentID = parentID
+				g.UpdatedBy = userID
+				g.UpdatedAt = currTime
+			}
+		}
+		return nil
+	})
+}
+
+func (svc *MemoryGroupService) RemoveGroupMembers(ctx context.Context, groupID, tenantID, userID string) error {
+	currTime := memoryNow()
+	return svc.write(func(state *memoryState) error {
+		for _, p := range state.tenantPeople(tenantID) {
+			if p.GroupID.Valid && p.GroupID.String == groupID {
+				p.GroupID = null.String{}
+				p.UpdatedBy = userID
+				p.UpdatedAt = currTime
+			}
+		}
+		return nil
+	})
+}
+
+func (svc *MemoryGroupService) RemoveAllGroupMembers(ctx context.Context, tenantID, userID string) error {
+	currTime := memoryNow()
+	return svc.write(func(state *memoryState) error {
+		for _, p := range state.tenantPeople(tenantID) {
+			p.GroupID = null.String{}
+			p.UpdatedBy = userID
+			p.UpdatedAt = currTime
+			if p.CreatedBy == DefaultTenantID {
+				p.IsSynced = true
+			}
+		}
+		return nil
+	})
+}
+
+func (svc *MemoryGroupService) IsCRMSynced(ctx context.Context, tenantID string) (bool, error) {
+	isSynced := true
+	err := svc.read(func(state *memoryState) error {
+		groups := state.tenantGroups(tenantID)
+		crmRoles := state.tenantCRMRoles(tenantID)
+
+		userCreated := false
+		for _, g := range groups {
+			if g.CreatedBy != DefaultTenantID {
+				userCreated = true
+			}
+		}
+
+		// mirrors the crm_role FULL OUTER JOIN group rows: a row per matched crm role and group, plus a row for each side without a match
+		groupsWithoutRole := 0
+		outOfSyncRows := 0
+		isOutOfSync := func(g *models.Group) bool {
+			return g.Status == "inactive" || len(g.CRMRoleIds) > 1
+		}
+		for _, g := range groups {
+			matched := 0
+			for _, cr := range crmRoles {
+				if containsString(g.CRMRoleIds, cr.ID) {
+					matched++
+				}
+			}
+			if matched == 0 {
+				groupsWithoutRole++
+				matched = 1
+			}
+			if isOutOfSync(g) {
+				outOfSyncRows += matched
+			}
+		}
+
+		isSynced = !(userCreated || groupsWithoutRole > 0 || outOfSyncRows > 1)
+		return nil
+	})
+	return isSynced, err
+}
+
+func (svc *MemoryGroupService) SyncGroups(ctx context.Context, tenantID string) error {
+	currTime := memoryNow()
+	// New group ids are kept per crm role outside the write, so a replayed commit creates the same groups
+	newIDs := map[string]string{}
+	return svc.write(func(state *memoryState) error {
+		crmRoles := state.tenantCRMRoles(tenantID)
+		groups := state.tenantGroups(tenantID)
+
+		hasChildren := map[string]bool{}
+		for _, cr := range crmRoles {
+			if cr.ParentID.Valid {
+				hasChildren[cr.ParentID.String] = true
+			}
+		}
+
+		type syncedGroup struct {
+			group     *models.Group
+			crmRoleID string
+			parentID  null.String
+		}
+		synced := []*syncedGroup{}
+		for _, cr := range crmRoles {
+			existing := []*models.Group{}
+			for _, g := range groups {
+				if containsString(g.CRMRoleIds, cr.ID) {
+					existing = append(existing, g)
+				}
+			}
+			if len(existing) == 0 {
+				existing = append(existing, nil)
+			}
+			for _, g := range existing {
+				typ := "ic"
+				if hasChildren[cr.ID] {
+					typ = "manager"
+				}
+				if _, ok := newIDs[cr.ID]; !ok {
+					newIDs[cr.ID] = MakeID()
+				}
+				sg := &models.Group{
+					ID:        newIDs[cr.ID],
+					TenantID:  tenantID,
+					Name:      cr.Name,
+					Type:      typ,
+					Status:    "active",
+					RoleIds:   types.StringArray{},
+					CreatedBy: DefaultTenantID,
+					CreatedAt: currTime,
+					UpdatedBy: DefaultTenantID,
+					UpdatedAt: currTime,
+				}
+				if g != nil {
+					sg.ID = g.ID
+					sg.Status = g.Status
+					sg.RoleIds = copyStrings(g.RoleIds)
+					sg.GroupPath = g.GroupPath
+					sg.Order = g.Order
+					sg.SyncFilter = g.SyncFilter
+					sg.OpportunityFilter = g.OpportunityFilter
+				}
+				sg.CRMRoleIds = types.StringArray{cr.ID}
+				synced = append(synced, &syncedGroup{group: sg, crmRoleID: cr.ID, parentID: cr.ParentID})
+			}
+		}
+
+		for _, sg := range synced {
+			sg.group.ParentID = null.String{}
+			for _, parent := range synced {
+				if sg.parentID.Valid && parent.crmRoleID == sg.parentID.String {
+					sg.group.ParentID = null.StringFrom(parent.group.ID)
+					break
+				}
+			}
+			state.groups[memoryKey{tenantID, sg.group.ID}] = sg.group
+		}
+		return nil
+	})
+}
+
+func (svc *MemoryGroupService) DeleteUnSyncedGroups(ctx context.Context, tenantID string) error {
+	return svc.write(func(state *memoryState) error {
+		crmRoleIDs := []string{}
+		for _, cr := range state.tenantCRMRoles(tenantID) {
+			crmRoleIDs = append(crmRoleIDs, cr.ID)
+		}
+		for _, g := range state.tenantGroups(tenantID) {
+			if !hasOverlap(g.CRMRoleIds, crmRoleIDs) {
+				delete(state.groups, memoryKey{tenantID, g.ID})
+			}
+		}
+		return nil
+	})
+}
+
+func (svc *MemoryGroupService) UpdateGroupTypes(ctx context.Context, tenantID string) error {
+	currTime := memoryNow()
+	return svc.write(func(state *memoryState) error {
+		groups := state.tenantGroups(tenantID)
+		hasChildren := map[string]bool{}
+		for _, g := range groups {
+			if g.ParentID.Valid {
+				hasChildren[g.ParentID.String] = true
+			}
+		}
+		for _, g := range groups {
+			g.Type = "ic"
+			if hasChildren[g.ID] {
+				g.Type = "manager"
+			}
+			g.UpdatedBy = DefaultTenantID
+			g.UpdatedAt = currTime
+		}
+		for _, p := range state.tenantPeople(tenantID) {
+			if p.Type == models.PersonTypePlayerCoach {
+				continue
+			}
+			p.Type = "ic"
+			if g, ok := state.groups[memoryKey{tenantID, p.GroupID.String}]; ok && p.GroupID.Valid {
+				p.Type = g.Type
+			}
+			p.UpdatedBy = DefaultTenantID
+			p.UpdatedAt = currTime
+		}
+		return nil
+	})
+}
+
+func (svc *MemoryGroupService) DeleteAllTenantGroups(ctx context.Context, tenantID string) error {
+	return svc.write(func(state *memoryState) error {
+		for _, g := range state.tenantGroups(tenantID) {
+			delete(state.groups, memoryKey{tenantID, g.ID})
+		}
+		return nil
+	})
+}
+
+func (svc *MemoryGroupService) GetLatestModifiedTS(ctx context.Context, tenantID string) (time.Time, error) {
+	latest := time.Time{}
+	err := svc.read(func(state *memoryState) error {
+		for _, g := range state.tenantGroups(tenantID) {
+			if g.UpdatedAt.After(latest) {
+				latest = g.UpdatedAt
+			}
+		}
+		return nil
+	})
+	return latest, err
+}
+
+func (svc *MemoryGroupService) GetTenantGroupCount(ctx context.Context, tenantID string) (int64, error) {
+	var count int64
+	err := svc.read(func(state *memoryState) error {
+		count = int64(len(state.tenantGroups(tenantID)))
+		return nil
+	})
+	return count, err
+}
+
+func (svc *MemoryGroupService) GetTenantActiveGroupCount(ctx context.Context, tenantID string) (int64, error) {
+	var count int64
+	err := svc.read(func(state *memoryState) error {
+		for _, g := range state.tenantGroups(tenantID) {
+			if g.Status == "active" {
+				count++
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (svc *MemoryGroupService) IsDescendant(ctx context.Context, sourceID string, targetID string) (DescendantCode, error) {
+	var code DescendantCode
+	err := svc.read(func(state *memoryState) error {
+		// like the sql, group ids are looked up across tenants
+		var source, target *models.Group
+		for _, g := range state.groups {
+			if g.Status != "active" {
+				continue
+			}
+			if g.ID == sourceID {
+				source = g
+			}
+			if g.ID == targetID {
+				target = g
+			}
+		}
+		switch {
+		case source == nil:
+			// the sql returns no row at all for a missing source
+			return sql.ErrNoRows
+		case target == nil && targetID != "":
+			code = DescendantCode_TARGET_NOT_EXISTS
+		case targetID == "":
+			code = DescendantCode_TARGET_IS_EMPTY
+		case pathContains(source.GroupPath, target.GroupPath):
+			code = DescendantCode_TARGET_IS_DESCENDANT
+		default:
+			code = DescendantCode_TARGET_NOT_DESCENDANT
+		}
+		return nil
+	})
+	return code, err
+}
+
+func (svc *MemoryGroupService) GetSubTreeIDs(ctx context.Context, tenantID, groupID string) ([]string, error) {
+	ids := []string{}
+	err := svc.read(func(state *memoryState) error {
+		root, ok := state.groups[memoryKey{tenantID, groupID}]
+		if !ok || root.GroupPath == "" {
+			return nil
+		}
+		subTree := []*models.Group{}
+		for _, g := range state.tenantGroups(tenantID) {
+			if g.Status == "active" && pathContains(root.GroupPath, g.GroupPath) {
+				subTree = append(subTree, g)
+			}
+		}
+		sort.SliceStable(subTree, func(i, j int) bool { return subTree[i].GroupPath < subTree[j].GroupPath })
+		for _, g := range subTree {
+			ids = append(ids, g.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// roleGroups returns the groups with roleID ordered like the sql, an empty tenantID looks in every tenant
+func (state *memoryState) roleGroups(tenantID, roleID string) []*models.Group {
+	groups := []*models.Group{}
+	for _, g := range state.groups {
+		if (tenantID == "" || g.TenantID == tenantID) && containsString(g.RoleIds, roleID) {
+			groups = append(groups, g)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if a.TenantID != b.TenantID {
+			return a.TenantID < b.TenantID
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	})
+	return groups
+}
+
+func (svc *MemoryGroupService) GetGroupsByRoleID(ctx context.Context, tenantID, roleID string) ([]*models.Group, error) {
+	groups := []*models.Group{}
+	err := svc.read(func(state *memoryState) error {
+		for _, g := range state.roleGroups(tenantID, roleID) {
+			groups = append(groups, copyGroup(g))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (svc *MemoryGroupService) ReplaceRoleID(ctx context.Context, tenantID, roleID, replacementID, userID string) ([]*models.Group, error) {
+	groups := []*models.Group{}
+	currTime := memoryNow()
+	err := svc.write(func(state *memoryState) error {
+		for _, g := range state.roleGroups(tenantID, roleID) {
+			g.RoleIds = replaceRoleID(g.RoleIds, roleID, replacementID)
+			g.UpdatedBy = userID
+			g.UpdatedAt = currTime
+			groups = append(groups, copyGroup(g))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/loupe-co/orchard/internal/models"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	"github.com/volatiletech/null/v8"
+)
+
+// MemoryGroupViewerService is the in-memory GroupViewerRepository
+type MemoryGroupViewerService struct {
+	*memoryService
+}
+
+var _ GroupViewerRepository = (*MemoryGroupViewerService)(nil)
+
+func (svc *MemoryGroupViewerService) FromProto(gv *orchardPb.GroupViewer) *models.GroupViewer {
+	return (&GroupViewerService{}).FromProto(gv)
+}
+
+func (svc *MemoryGroupViewerService) ToProto(gv *models.GroupViewer) (*orchardPb.GroupViewer, error) {
+	return (&GroupViewerService{}).ToProto(gv)
+}
+
+// tenantGroupViewers returns a tenant's group viewers ordered by group and person
+func (state *memoryState) tenantGroupViewers(tenantID string) []*models.GroupViewer {
+	viewers := []*models.GroupViewer{}
+	for _, gv := range state.groupViewers {
+		if gv.TenantID == tenantID {
+			viewers = append(viewers, gv)
+		}
+	}
+	sort.Slice(viewers, func(i, j int) bool {
+		if viewers[i].GroupID != viewers[j].GroupID {
+			return viewers[i].GroupID < viewers[j].GroupID
+		}
+		return viewers[i].PersonID < viewers[j].PersonID
+	})
+	return viewers
+}
+
+func (svc *MemoryGroupViewerService) Insert(ctx context.Context, gv *models.GroupViewer) error {
+	currTime := memoryNow()
+	return svc.write(func(state *memoryState) error {
+		key := memoryGroupViewerKey{gv.TenantID, gv.GroupID, gv.PersonID}
+		if _, ok := state.groupViewers[key]; ok {
+			return errMemoryDuplicate("group_viewer")
+		}
+		if gv.CreatedAt.IsZero() {
+			gv.CreatedAt = currTime
+		}
+		if gv.UpdatedAt.IsZero() {
+			gv.UpdatedAt = currTime
+		}
+		state.groupViewers[key] = copyGroupViewer(gv)
+		return nil
+	})
+}
+
+func (svc *MemoryGroupViewerService) GetGroupViewers(ctx context.Context, tenantID, groupID string) ([]*models.Person, error) {
+	results := []*models.Person{}
+	err := svc.read(func(state *memoryState) error {
+		for _, gv := range state.tenantGroupViewers(tenantID) {
+			if gv.GroupID != groupID || !GroupViewerActive(gv, memoryNow()) {
+				continue
+			}
+			if p, ok := state.people[memoryKey{tenantID, gv.PersonID}]; ok {
+				results = append(results, copyPerson(p))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (svc *MemoryGroupViewerService) GetPersonViewableGroups(ctx context.Context, tenantID, personID string) ([]*models.Group, error) {
+	results := []*models.Group{}
+	err := svc.read(func(state *memoryState) error {
+		for _, gv := range state.tenantGroupViewers(tenantID) {
+			if gv.PersonID != personID || !GroupViewerActive(gv, memoryNow()) {
+				continue
+			}
+			if g, ok := state.groups[memoryKey{tenantID, gv.GroupID}]; ok {
+				results = append(results, copyGroup(g))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (svc *MemoryGroupViewerService) GetPersonsViewableGroups(ctx context.Context, tenantID string, peepIds ...string) ([]*models.GroupViewer, error) {
+	groupViewers := []*models.GroupViewer{}
+	err := svc.read(func(state *memoryState) error {
+		for _, gv := range state.tenantGroupViewers(tenantID) {
+			if containsString(peepIds, gv.PersonID) && GroupViewerActive(gv, memoryNow()) {
+				groupViewers = append(groupViewers, copyGroupViewer(gv))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groupViewers, nil
+}
+
+func (svc *MemoryGroupViewerService) GetPersonGrants(ctx context.Context, tenantID, personID string) ([]*models.GroupViewer, error) {
+	groupViewers := []*models.GroupViewer{}
+	err := svc.read(func(state *memoryState) error {
+		for _, gv := range state.tenantGroupViewers(tenantID) {
+			if gv.PersonID == personID {
+				groupViewers = append(groupViewers, copyGroupViewer(gv))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groupViewers, nil
+}
+
+func (svc *MemoryGroupViewerService) GetGroupGrants(ctx context.Context, tenantID string, groupIDs ...string) ([]*models.GroupViewer, error) {
+	groupViewers := []*models.GroupViewer{}
+	err := svc.read(func(state *memoryState) error {
+		for _, gv := range state.tenantGroupViewers(tenantID) {
+			if containsString(groupIDs, gv.GroupID) {
+				groupViewers = append(groupViewers, copyGroupViewer(gv))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groupViewers, nil
+}
+
+func (svc *MemoryGroupViewerService) Update(ctx context.Context, gv *models.GroupViewer) error {
+	gv.UpdatedAt = memoryNow()
+	return svc.write(func(state *memoryState) error {
+		key := memoryGroupViewerKey{gv.TenantID, gv.GroupID, gv.PersonID}
+		existing, ok := state.groupViewers[key]
+		if !ok {
+			return fmt.Errorf("error updating groupViewer: update affected 0 rows")
+		}
+		updated := copyGroupViewer(existing)
+		if err := setColumns(updated, copyGroupViewer(gv), defaultGroupViewerUpdateWhitelist); err != nil {
+			return err
+		}
+		state.groupViewers[key] = updated
+		return nil
+	})
+}
+
+func (svc *MemoryGroupViewerService) DeleteByID(ctx context.Context, tenantID, groupID, personID string) error {
+	return svc.write(func(state *memoryState) error {
+		key := memoryGroupViewerKey{tenantID, groupID, personID}
+		if _, ok := state.groupViewers[key]; !ok {
+			return fmt.Errorf("error deleting groupViewer: delete affected 0 rows")
+		}
+		delete(state.groupViewers, key)
+		return nil
+	})
+}
+
+// dueGroupViewers returns up to limit grants that match due, ordered by at
+func (state *memoryState) dueGroupViewers(limit int, due func(gv *models.GroupViewer) bool, at func(gv *models.GroupViewer) time.Time) []*models.GroupViewer {
+	results := []*models.GroupViewer{}
+	for _, gv := range state.groupViewers {
+		if due(gv) {
+			results = append(results, gv)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return at(results[i]).Before(at(results[j])) })
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func (svc *MemoryGroupViewerService) DeleteExpired(ctx context.Context, now time.Time, limit int) ([]*models.GroupViewer, error) {
+	results := []*models.GroupViewer{}
+	err := svc.write(func(state *memoryState) error {
+		expired := state.dueGroupViewers(limit,
+			func(gv *models.GroupViewer) bool { return gv.EndsAt.Valid && !gv.EndsAt.Time.After(now) },
+			func(gv *models.GroupViewer) time.Time { return gv.EndsAt.Time },
+		)
+		for _, gv := range expired {
+			delete(state.groupViewers, memoryGroupViewerKey{gv.TenantID, gv.GroupID, gv.PersonID})
+			results = append(results, copyGroupViewer(gv))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (svc *MemoryGroupViewerService) MarkStarted(ctx context.Context, now time.Time, limit int) ([]*models.GroupViewer, error) {
+	results := []*models.GroupViewer{}
+	err := svc.write(func(state *memoryState) error {
+		started := state.dueGroupViewers(limit,
+			func(gv *models.GroupViewer) bool {
+				return gv.StartsAt.Valid && !gv.StartsAt.Time.After(now) && !gv.ActivatedAt.Valid && (!gv.EndsAt.Valid || gv.EndsAt.Time.After(now))
+			},
+			func(gv *models.GroupViewer) time.Time { return gv.StartsAt.Time },
+		)
+		for _, gv := range started {
+			gv.ActivatedAt = null.TimeFrom(now)
+			results = append(results, copyGroupViewer(gv))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
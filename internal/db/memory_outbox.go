@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/volatiletech/null/v8"
+)
+
+// MemoryOutboxService is the in-memory OutboxRepository
+type MemoryOutboxService struct {
+	*memoryService
+}
+
+var _ OutboxRepository = (*MemoryOutboxService)(nil)
+
+// hasPendingOutboxMessage is the partial unique index on pending dedup keys
+func (state *memoryState) hasPendingOutboxMessage(dedupKey, exceptID string) bool {
+	for _, msg := range state.outbox {
+		if msg.ID != exceptID && msg.DedupKey == dedupKey && msg.Status == OutboxStatusPending {
+			return true
+		}
+	}
+	return false
+}
+
+func (svc *MemoryOutboxService) Enqueue(ctx context.Context, msgs ...*OutboxMessage) error {
+	currTime := memoryNow()
+	return svc.write(func(state *memoryState) error {
+		for _, msg := range msgs {
+			if state.hasPendingOutboxMessage(msg.DedupKey, "") {
+				continue
+			}
+			if _, ok := state.outbox[msg.ID]; ok {
+				return errMemoryDuplicate("outbox")
+			}
+			row := copyOutboxMessage(msg)
+			row.Status = OutboxStatusPending
+			row.NextAttemptAt = currTime
+			row.CreatedAt = currTime
+			row.UpdatedAt = currTime
+			state.outbox[row.ID] = row
+		}
+		return nil
+	})
+}
+
+func (svc *MemoryOutboxService) Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxMessage, error) {
+	results := []*OutboxMessage{}
+	currTime := memoryNow()
+	err := svc.write(func(state *memoryState) error {
+		due := []*OutboxMessage{}
+		for _, msg := range state.outbox {
+			if (msg.Status == OutboxStatusPending || msg.Status == OutboxStatusProcessing) && !msg.NextAttemptAt.After(currTime) {
+				due = append(due, msg)
+			}
+		}
+		sort.Slice(due, func(i, j int) bool {
+			if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+				return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+			}
+			return due[i].ID < due[j].ID
+		})
+		for _, msg := range pageRows(due, limit, 0) {
+			msg.Status = OutboxStatusProcessing
+			msg.Attempts++
+			msg.NextAttemptAt = currTime.Add(time.Duration(int(lease.Seconds())) * time.Second)
+			msg.UpdatedAt = currTime
+			results = append(results, copyOutboxMessage(msg))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (svc *MemoryOutboxService) MarkDelivered(ctx context.Context, id string) error {
+	return svc.write(func(state *memoryState) error {
+		if msg, ok := state.outbox[id]; ok {
+			markMemoryOutboxDelivered(msg)
+		}
+		return nil
+	})
+}
+
+func markMemoryOutboxDelivered(msg *OutboxMessage) {
+	currTime := memoryNow()
+	msg.Status = OutboxStatusDelivered
+	msg.LastError = null.String{}
+	msg.DeliveredAt = null.TimeFrom(currTime)
+	msg.UpdatedAt = currTime
+}
+
+func (svc *MemoryOutboxService) MarkFailed(ctx context.Context, id, lastError string, retryAt time.Time, dead bool) error {
+	status := OutboxStatusPending
+	if dead {
+		status = OutboxStatusDead
+	}
+	currTime := memoryNow()
+	return svc.write(func(state *memoryState) error {
+		msg, ok := state.outbox[id]
+		if !ok {
+			return nil
+		}
+		// A newer pending message with the same dedup key supersedes this one, so only the newer one needs to be retried
+		if status == OutboxStatusPending && state.hasPendingOutboxMessage(msg.DedupKey, msg.ID) {
+			markMemoryOutboxDelivered(msg)
+			return nil
+		}
+		msg.Status = status
+		msg.LastError = null.StringFrom(lastError)
+		msg.NextAttemptAt = retryAt
+		msg.UpdatedAt = currTime
+		return nil
+	})
+}
+
+func (svc *MemoryOutboxService) GetDeadLetters(ctx context.Context, tenantID string, limit, offset int) ([]*OutboxMessage, error) {
+	results := []*OutboxMessage{}
+	err := svc.read(func(state *memoryState) error {
+		for _, msg := range state.outbox {
+			if msg.Status == OutboxStatusDead && (tenantID == "" || msg.TenantID == tenantID) {
+				results = append(results, copyOutboxMessage(msg))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].UpdatedAt.Equal(results[j].UpdatedAt) {
+			return results[i].UpdatedAt.After(results[j].UpdatedAt)
+		}
+		return results[i].ID < results[j].ID
+	})
+	return pageRows(results, limit, offset), nil
+}
+
+func (svc *MemoryOutboxService) Requeue(ctx context.Context, id string) error {
+	currTime := memoryNow()
+	return svc.write(func(state *memoryState) error {
+		msg, ok := state.outbox[id]
+		if !ok || msg.Status != OutboxStatusDead || state.hasPendingOutboxMessage(msg.DedupKey, msg.ID) {
+			return nil
+		}
+		msg.Status = OutboxStatusPending
+		msg.Attempts = 0
+		msg.LastError = null.String{}
+		msg.NextAttemptAt = currTime
+		msg.UpdatedAt = currTime
+		return nil
+	})
+}
@@ -0,0 +1,855 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/loupe-co/orchard/internal/models"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	null "github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/types"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// MemoryPersonService is the in-memory PersonRepository
+type MemoryPersonService struct {
+	*memoryService
+}
+
+var _ PersonRepository = (*MemoryPersonService)(nil)
+
+func (svc *MemoryPersonService) FromProto(p *orchardPb.Person) *models.Person {
+	return (&PersonService{}).FromProto(p)
+}
+
+func (svc *MemoryPersonService) ToProto(p *models.Person) (*orchardPb.Person, error) {
+	return (&PersonService{}).ToProto(p)
+}
+
+func (svc *MemoryPersonService) CleanEmail(email string) string {
+	return (&PersonService{}).CleanEmail(email)
+}
+
+func (svc *MemoryPersonService) GetSFSandboxEmail(email string) string {
+	return (&PersonService{}).GetSFSandboxEmail(email)
+}
+
+// allPeople returns every tenant's people ordered by tenant and id
+func (state *memoryState) allPeople() []*models.Person {
+	people := []*models.Person{}
+	for _, p := range state.people {
+		people = append(people, p)
+	}
+	sort.Slice(people, func(i, j int) bool {
+		if people[i].TenantID != people[j].TenantID {
+			return people[i].TenantID < people[j].TenantID
+		}
+		return people[i].ID < people[j].ID
+	})
+	return people
+}
+
+func copyPeople(people []*models.Person) []*models.Person {
+	res := make([]*models.Person, len(people))
+	for i, p := range people {
+		res[i] = copyPerson(p)
+	}
+	return res
+}
+
+func (svc *MemoryPersonService) Insert(ctx context.Context, p *models.Person) error {
+	currTime := memoryNow()
+	return svc.write(func(state *memoryState) error {
+		key := memoryKey{p.TenantID, p.ID}
+		if _, ok := state.people[key]; ok {
+			return errMemoryDuplicate("person")
+		}
+		if p.CreatedAt.IsZero() {
+			p.CreatedAt = currTime
+		}
+		if p.UpdatedAt.IsZero() {
+			p.UpdatedAt = currTime
+		}
+		row := copyPerson(p)
+		if row.Type == "" {
+			row.Type = "ic"
+		}
+		state.people[key] = row
+		return nil
+	})
+}
+
+func (svc *MemoryPersonService) UpsertAll(ctx context.Context, people []*models.Person) error {
+	return svc.write(func(state *memoryState) error {
+		for _, p := range people {
+			if p == nil {
+				continue
+			}
+			key := memoryKey{p.TenantID, p.ID}
+			existing, ok := state.people[key]
+			if !ok {
+				row := &models.Person{
+					ID: p.ID, TenantID: p.TenantID, Name: p.Name, FirstName: p.FirstName, LastName: p.LastName, Email: p.Email,
+					PhotoURL: p.PhotoURL, GroupID: p.GroupID, RoleIds: copyStrings(p.RoleIds), CRMRoleIds: copyStrings(p.CRMRoleIds),
+					IsProvisioned: p.IsProvisioned, IsSynced: p.IsSynced, Status: "inactive", Type: "ic",
+					CreatedAt: p.CreatedAt, CreatedBy: p.CreatedBy, UpdatedAt: p.UpdatedAt, UpdatedBy: p.UpdatedBy,
+				}
+				state.people[key] = row
+				continue
+			}
+
+			protected := existing.CreatedBy == DefaultOutreachSyncID || existing.CreatedBy == DefaultSCIMSyncID
+			outreachLinked := existing.OutreachGUID.String != ""
+			if !protected && !outreachLinked {
+				existing.Name = p.Name
+				existing.FirstName = p.FirstName
+				existing.LastName = p.LastName
+				existing.Email = p.Email
+			}
+			existing.PhotoURL = p.PhotoURL
+			if existing.CreatedBy != DefaultSCIMSyncID {
+				existing.GroupID = p.GroupID
+				existing.IsProvisioned = p.IsProvisioned
+			}
+			if !protected && !existing.RoleIdsLocked {
+				existing.RoleIds = copyStrings(p.RoleIds)
+			}
+			if !protected {
+				existing.IsSynced = p.IsSynced
+			}
+			existing.CRMRoleIds = copyStrings(p.CRMRoleIds)
+			existing.UpdatedAt = p.UpdatedAt
+			existing.UpdatedBy = p.UpdatedBy
+		}
+		return nil
+	})
+}
+
+func (svc *MemoryPersonService) GetByID(ctx context.Context, id, tenantID string) (*models.Person, error) {
+	var person *models.Person
+	err := svc.read(func(state *memoryState) error {
+		p, ok := state.people[memoryKey{tenantID, id}]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		person = copyPerson(p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return person, nil
+}
+
+func (svc *MemoryPersonService) filter(tenantID string, keep func(p *models.Person) bool) ([]*models.Person, error) {
+	people := []*models.Person{}
+	err := svc.read(func(state *memoryState) error {
+		for _, p := range state.tenantPeople(tenantID) {
+			if keep(p) {
+				people = append(people, copyPerson(p))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return people, nil
+}
+
+func (svc *MemoryPersonService) GetByIDs(ctx context.Context, tenantID string, ids ...interface{}) ([]*models.Person, error) {
+	return svc.filter(tenantID, func(p *models.Person) bool {
+		for _, id := range ids {
+			if fmt.Sprint(id) == p.ID {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (svc *MemoryPersonService) GetAllActiveNonVirtualByEmails(ctx context.Context, tenantID string, emails ...interface{}) ([]*models.Person, error) {
+	return svc.filter(tenantID, func(p *models.Person) bool {
+		if !p.Email.Valid || p.CreatedBy != DefaultTenantID || p.Status != "active" {
+			return false
+		}
+		for _, email := range emails {
+			if fmt.Sprint(email) == strings.ToLower(p.Email.String) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (svc *MemoryPersonService) GetByEmail(ctx context.Context, tenantID, email string) (*models.Person, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	people, err := svc.filter(tenantID, func(p *models.Person) bool {
+		return p.Email.Valid && strings.ToLower(p.Email.String) == email
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(people) == 0 || people[0].ID == "" {
+		return nil, nil
+	}
+	return people[0], nil
+}
+
+func (svc *MemoryPersonService) GetAllByEmail(ctx context.Context, email string) ([]*models.Person, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	people := []*models.Person{}
+	err := svc.read(func(state *memoryState) error {
+		for _, p := range state.allPeople() {
+			if p.Email.Valid && strings.ToLower(p.Email.String) == email {
+				people = append(people, copyPerson(p))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(people) == 0 {
+		return nil, nil
+	}
+	return people, nil
+}
+
+func (svc *MemoryPersonService) GetAllByEmailForProvisioning(ctx context.Context, email string) ([]*models.Person, error) {
+	email = strings.ToLower(email)
+	cleanEmail := svc.CleanEmail(email)
+	alternateEmail := svc.GetSFSandboxEmail(email)
+
+	people := []*models.Person{}
+	err := svc.read(func(state *memoryState) error {
+		for _, p := range state.allPeople() {
+			if !p.Email.Valid || p.Status != "active" || !p.IsProvisioned {
+				continue
+			}
+			lower := strings.ToLower(p.Email.String)
+			if matchLike(lower, cleanEmail) || matchLike(lower, alternateEmail) {
+				people = append(people, copyPerson(p))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(people) == 0 {
+		return nil, nil
+	}
+
+	for i, person := range people {
+		if person.Email.Valid && !person.Email.IsZero() {
+			people[i].Email.String = svc.CleanEmail(person.Email.String)
+		}
+	}
+	return people, nil
+}
+
+// compareMemoryValues orders a column value against a filter value, comparing times and numbers by value and anything else as text
+func compareMemoryValues(a, b interface{}) int {
+	if at, ok := a.(time.Time); ok {
+		bt, ok := b.(time.Time)
+		if !ok {
+			parsed, err := time.Parse(time.RFC3339Nano, fmt.Sprint(b))
+			if err != nil {
+				return strings.Compare(at.Format(time.RFC3339Nano), fmt.Sprint(b))
+			}
+			bt = parsed
+		}
+		switch {
+		case at.Before(bt):
+			return -1
+		case at.After(bt):
+			return 1
+		}
+		return 0
+	}
+	av, aNum := toMemoryNumber(a)
+	bv, bNum := toMemoryNumber(b)
+	if aNum && bNum {
+		switch {
+		case av < bv:
+			return -1
+		case av > bv:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toMemoryNumber(v interface{}) (float64, bool) {
+	val := reflect.ValueOf(v)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(val.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(val.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return val.Float(), true
+	}
+	return 0, false
+}
+
+// search returns copies of the tenant's people matching the query and filters, unordered
+func (svc *MemoryPersonService) search(tenantID, query string, filters []PersonFilter) ([]*models.Person, error) {
+	pattern := "%" + strings.ToLower(query) + "%"
+	searchQuery := len(strings.TrimSpace(query)) >= 3
+	filter := Filter{And: filters}
+	if err := ValidateFilter(filter, PersonFilterColumns); len(filters) > 0 && err != nil {
+		return nil, err
+	}
+
+	people := []*models.Person{}
+	err := svc.read(func(state *memoryState) error {
+		for _, p := range state.tenantPeople(tenantID) {
+			if searchQuery {
+				nameMatch := p.Name.Valid && matchLike(strings.ToLower(p.Name.String), pattern)
+				emailMatch := p.Email.Valid && matchLike(strings.ToLower(p.Email.String), pattern)
+				if !nameMatch && !emailMatch {
+					continue
+				}
+			}
+			if len(filters) > 0 {
+				ok, err := matchFilter(p, filter)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					continue
+				}
+			}
+			people = append(people, copyPerson(p))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return people, nil
+}
+
+func (svc *MemoryPersonService) Search(ctx context.Context, tenantID, query string, limit, offset int, filters ...PersonFilter) ([]*models.Person, int64, error) {
+	people, err := svc.search(tenantID, query, filters)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := int64(len(people))
+
+	sort.SliceStable(people, func(i, j int) bool {
+		a, b := people[i].Name, people[j].Name
+		if a.Valid != b.Valid {
+			return a.Valid
+		}
+		return a.String < b.String
+	})
+	return pageRows(people, limit, offset), total, nil
+}
+
+func (svc *MemoryPersonService) SearchPage(ctx context.Context, tenantID, query string, page Page, filters ...PersonFilter) ([]*models.Person, int64, error) {
+	if _, ok := PersonSortColumns[page.SortBy]; !ok {
+		return nil, 0, fmt.Errorf("can't sort people by %q", page.SortBy)
+	}
+	people, err := svc.search(tenantID, query, filters)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := int64(0)
+	if page.CountTotal {
+		total = int64(len(people))
+	}
+	return memoryPage(people, page, PersonSortValue, func(p *models.Person) string { return p.ID }), total, nil
+}
+
+func (svc *MemoryPersonService) SearchAfter(ctx context.Context, tenantID, query, afterID string, limit int, filters ...PersonFilter) ([]*models.Person, error) {
+	people, err := svc.search(tenantID, query, filters)
+	if err != nil {
+		return nil, err
+	}
+	return afterIDPage(people, func(p *models.Person) string { return p.ID }, afterID, limit), nil
+}
+
+func (svc *MemoryPersonService) GetPeopleByGroupId(ctx context.Context, tenantID, groupID string) ([]*models.Person, error) {
+	return svc.filter(tenantID, func(p *models.Person) bool {
+		return p.GroupID.Valid && p.GroupID.String == groupID
+	})
+}
+
+func (svc *MemoryPersonService) GetAllByTenant(ctx context.Context, tenantID string) ([]*models.Person, error) {
+	return svc.filter(tenantID, func(p *models.Person) bool { return true })
+}
+
+// roleHolders returns the people holding roleID ordered by id, an empty tenantID looks in every tenant
+func (state *memoryState) roleHolders(tenantID, roleID string) []*models.Person {
+	people := []*models.Person{}
+	for _, p := range state.people {
+		if (tenantID == "" || p.TenantID == tenantID) && containsString(p.RoleIds, roleID) {
+			people = append(people, p)
+		}
+	}
+	sort.Slice(people, func(i, j int) bool { return people[i].ID < people[j].ID })
+	return people
+}
+
+func (svc *MemoryPersonService) GetPeopleByRoleId(ctx context.Context, tenantID, roleID string, limit, offset int) ([]*models.Person, error) {
+	people := []*models.Person{}
+	err := svc.read(func(state *memoryState) error {
+		for _, p := range state.roleHolders(tenantID, roleID) {
+			people = append(people, copyPerson(p))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(people, func(i, j int) bool {
+		a, b := people[i], people[j]
+		if a.LastName != b.LastName {
+			if a.LastName.Valid != b.LastName.Valid {
+				return a.LastName.Valid
+			}
+			return a.LastName.String < b.LastName.String
+		}
+		if a.FirstName.Valid != b.FirstName.Valid {
+			// nulls sort first when descending
+			return !a.FirstName.Valid
+		}
+		return a.FirstName.String > b.FirstName.String
+	})
+	return pageRows(people, limit, offset), nil
+}
+
+func (svc *MemoryPersonService) CountPeopleByRoleId(ctx context.Context, tenantID, roleID string) (int64, error) {
+	var count int64
+	err := svc.read(func(state *memoryState) error {
+		count = int64(len(state.roleHolders(tenantID, roleID)))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (svc *MemoryPersonService) ReplaceRoleID(ctx context.Context, tenantID, roleID, replacementID, userID string) ([]*models.Person, error) {
+	people := []*models.Person{}
+	currTime := memoryNow()
+	err := svc.write(func(state *memoryState) error {
+		for _, p := range state.roleHolders(tenantID, roleID) {
+			p.RoleIds = replaceRoleID(p.RoleIds, roleID, replacementID)
+			p.UpdatedBy = userID
+			p.UpdatedAt = currTime
+			people = append(people, copyPerson(p))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return people, nil
+}
+
+// replaceRoleID is the ARRAY_REPLACE, or ARRAY_REMOVE when ids already has the replacement, of the replace role id queries
+func replaceRoleID(ids types.StringArray, roleID, replacementID string) types.StringArray {
+	hasReplacement := containsString(ids, replacementID)
+	res := types.StringArray{}
+	for _, id := range ids {
+		switch {
+		case id != roleID:
+			res = append(res, id)
+		case !hasReplacement:
+			res = append(res, replacementID)
+		}
+	}
+	return res
+}
+
+func sinceTime(since *timestamppb.Timestamp) time.Time {
+	t := time.Time{}
+	if since != nil && since.IsValid() {
+		t = since.AsTime()
+	}
+	return t
+}
+
+func (svc *MemoryPersonService) GetVirtualUsers(ctx context.Context, tenantID string, since *timestamppb.Timestamp) ([]*models.Person, error) {
+	t := sinceTime(since)
+	return svc.filter(tenantID, func(p *models.Person) bool {
+		isVirtual := (p.CreatedBy != DefaultTenantID && p.CreatedBy != DefaultOutreachSyncID) ||
+			(p.CreatedBy == DefaultOutreachSyncID && p.OutreachGUID.Valid && p.ID == p.OutreachGUID.String)
+		return isVirtual && !p.UpdatedAt.Before(t)
+	})
+}
+
+func (svc *MemoryPersonService) GetNonOutreachSyncedVirtualUsers(ctx context.Context, tenantID string, since *timestamppb.Timestamp) ([]*models.Person, error) {
+	t := sinceTime(since)
+	return svc.filter(tenantID, func(p *models.Person) bool {
+		return p.CreatedBy != DefaultTenantID && !p.UpdatedAt.Before(t) && p.OutreachGUID.String == ""
+	})
+}
+
+func (svc *MemoryPersonService) Update(ctx context.Context, p *models.Person, onlyFields []string) error {
+	whitelist := defaultPersonUpdateWhitelist
+	if len(onlyFields) > 0 {
+		whitelist = onlyFields
+	}
+	var hasUpdatedAt, hasUpdatedBy bool
+	for _, f := range whitelist {
+		if f == "updated_at" {
+			hasUpdatedAt = true
+		}
+		if f == "updated_by" {
+			hasUpdatedBy = true
+		}
+	}
+	if !hasUpdatedAt {
+		whitelist = append(whitelist, "updated_at")
+	}
+	if !hasUpdatedBy {
+		whitelist = append(whitelist, "updated_by")
+	}
+
+	p.UpdatedAt = memoryNow()
+
+	return svc.write(func(state *memoryState) error {
+		key := memoryKey{p.TenantID, p.ID}
+		existing, ok := state.people[key]
+		if !ok {
+			return fmt.Errorf("error updating person: update affected 0 rows")
+		}
+		updated := copyPerson(existing)
+		if err := setColumns(updated, copyPerson(p), whitelist); err != nil {
+			return err
+		}
+		state.people[key] = updated
+		return nil
+	})
+}
+
+func (svc *MemoryPersonService) UpdatePersonGroups(ctx context.Context, tenantID string) error {
+	return svc.write(func(state *memoryState) error {
+		groups := state.tenantGroups(tenantID)
+		for _, p := range state.tenantPeople(tenantID) {
+			// synced people follow their crm role to its group
+			if p.IsSynced {
+				matched := false
+				for _, g := range groups {
+					if hasOverlap(p.CRMRoleIds, g.CRMRoleIds) {
+						p.GroupID = null.StringFrom(g.ID)
+						matched = true
+						break
+					}
+				}
+				if matched {
+					continue
+				}
+			}
+			// unsynced or manually created people keep their group if it still exists
+			if !p.IsSynced || p.CreatedBy != DefaultTenantID {
+				if _, ok := state.groups[memoryKey{tenantID, p.GroupID.String}]; !ok || !p.GroupID.Valid {
+					p.GroupID = null.String{}
+				}
+				continue
+			}
+			// synced people that lost their crm role lose their group
+			if p.GroupID.Valid && p.GroupID.String != "" && p.CRMRoleIds == nil {
+				p.GroupID = null.String{}
+			}
+		}
+		return nil
+	})
+}
+
+func (svc *MemoryPersonService) DeleteByID(ctx context.Context, id, tenantID string) error {
+	return svc.write(func(state *memoryState) error {
+		key := memoryKey{tenantID, id}
+		if _, ok := state.people[key]; !ok {
+			return fmt.Errorf("error deleting person: delete affected 0 rows")
+		}
+		delete(state.people, key)
+		return nil
+	})
+}
+
+func (svc *MemoryPersonService) SoftDeleteByID(ctx context.Context, id, tenantID, userID string) error {
+	currTime := memoryNow()
+	return svc.write(func(state *memoryState) error {
+		p, ok := state.people[memoryKey{tenantID, id}]
+		if !ok {
+			return fmt.Errorf("error soft deleting person: delete affected 0 rows")
+		}
+		p.Status = "inactive"
+		p.UpdatedBy = userID
+		p.UpdatedAt = currTime
+		return nil
+	})
+}
+
+func (svc *MemoryPersonService) GetPersonGroupIDs(ctx context.Context, tenantID string) (map[string]string, error) {
+	res := map[string]string{}
+	err := svc.read(func(state *memoryState) error {
+		for _, p := range state.tenantPeople(tenantID) {
+			if p.IsProvisioned && p.Status == "active" && p.GroupID.Valid {
+				res[p.ID] = p.GroupID.String
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (svc *MemoryPersonService) GetOutreachIdsFromCommitIds(ctx context.Context, tenantID string, entityID string) ([]string, error) {
+	res := []string{}
+	// like the sql this doesn't run in the transaction
+	err := svc.readCommitted(func(state *memoryState) error {
+		entityLabel := pathLabel(entityID)
+		for _, g := range state.tenantGroups(tenantID) {
+			if g.Status != "active" {
+				continue
+			}
+			labels := strings.Split(g.GroupPath, ".")
+			inPath := false
+			for i, label := range labels {
+				if label == entityLabel && i < len(labels)-1 {
+					inPath = true
+				}
+			}
+			for _, p := range state.tenantPeople(tenantID) {
+				if p.GroupID.Valid && p.GroupID.String == g.ID && p.OutreachID.Valid && (inPath || p.GroupID.String == entityID) {
+					res = append(res, p.OutreachID.String)
+				}
+			}
+		}
+		if p, ok := state.people[memoryKey{tenantID, entityID}]; ok && p.OutreachID.Valid {
+			res = append(res, p.OutreachID.String)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (svc *MemoryPersonService) CleanupCNCUsers(ctx context.Context, tenantID string) ([]*CNCUserCleanupResult, error) {
+	result := []*CNCUserCleanupResult{}
+	err := svc.write(func(state *memoryState) error {
+		people := state.tenantPeople(tenantID)
+
+		// cleanup_set: outreach linked people sharing an email, all but the best record per outreach guid get deleted or unlinked
+		linkedEmailCounts := map[null.String]int{}
+		for _, p := range people {
+			if p.OutreachGUID.String != "" {
+				linkedEmailCounts[p.Email]++
+			}
+		}
+		byGUID := map[string][]*models.Person{}
+		guids := []string{}
+		inCleanupSet := map[string]bool{}
+		for _, p := range people {
+			if p.OutreachGUID.String == "" || linkedEmailCounts[p.Email] <= 1 {
+				continue
+			}
+			inCleanupSet[p.ID] = true
+			if _, ok := byGUID[p.OutreachGUID.String]; !ok {
+				guids = append(guids, p.OutreachGUID.String)
+			}
+			byGUID[p.OutreachGUID.String] = append(byGUID[p.OutreachGUID.String], p)
+		}
+		cleanupRank := func(p *models.Person) []int {
+			rank := []int{0, 0, 0, 0}
+			if p.Status == "active" {
+				rank[0] = 1
+			}
+			if p.IsProvisioned {
+				rank[1] = 1
+			}
+			switch p.CreatedBy {
+			case DefaultTenantID:
+				rank[2] = 2
+			case DefaultOutreachSyncID:
+				rank[2] = 1
+			}
+			if p.ID != p.OutreachGUID.String {
+				rank[3] = 1
+				if p.CreatedBy == DefaultTenantID {
+					rank[3] = 2
+				}
+			}
+			return rank
+		}
+		deleteIDs := []string{}
+		unlinkIDs := []string{}
+		for _, guid := range guids {
+			dupes := byGUID[guid]
+			sort.SliceStable(dupes, func(i, j int) bool {
+				a, b := cleanupRank(dupes[i]), cleanupRank(dupes[j])
+				for k := range a {
+					if a[k] != b[k] {
+						return a[k] > b[k]
+					}
+				}
+				return false
+			})
+			for _, p := range dupes[1:] {
+				if !p.IsProvisioned && p.ID == p.OutreachGUID.String {
+					deleteIDs = append(deleteIDs, p.ID)
+				} else {
+					unlinkIDs = append(unlinkIDs, p.ID)
+				}
+			}
+		}
+
+		// active_dupe_set: crm and outreach records of the same active user, the outreach record takes over the crm record's id
+		type emailStatus struct {
+			email  null.String
+			status string
+		}
+		emailStatusCounts := map[emailStatus]int{}
+		for _, p := range people {
+			emailStatusCounts[emailStatus{p.Email, p.Status}]++
+		}
+		activeDupes := []*models.Person{}
+		for _, p := range people {
+			if emailStatusCounts[emailStatus{p.Email, p.Status}] == 2 && p.Status == "active" && !inCleanupSet[p.ID] {
+				activeDupes = append(activeDupes, p)
+			}
+		}
+		type swapPair struct {
+			source *models.Person
+			target *models.Person
+		}
+		pairs := []swapPair{}
+		for _, target := range activeDupes {
+			if target.CreatedBy != DefaultOutreachSyncID || !target.Email.Valid {
+				continue
+			}
+			for _, source := range activeDupes {
+				if source.CreatedBy == DefaultTenantID && source.Email == target.Email {
+					pairs = append(pairs, swapPair{source: source, target: target})
+					deleteIDs = append([]string{source.ID}, deleteIDs...)
+					break
+				}
+			}
+		}
+
+		for _, id := range deleteIDs {
+			if _, ok := state.people[memoryKey{tenantID, id}]; ok {
+				delete(state.people, memoryKey{tenantID, id})
+				result = append(result, &CNCUserCleanupResult{ID: sql.NullString{String: id, Valid: true}, Action: sql.NullString{String: "delete", Valid: true}})
+			}
+		}
+
+		coalesceString := func(a, b null.String) null.String {
+			if a.Valid {
+				return a
+			}
+			return b
+		}
+		coalesceStrings := func(a, b types.StringArray) types.StringArray {
+			if a != nil {
+				return copyStrings(a)
+			}
+			return copyStrings(b)
+		}
+		for _, pair := range pairs {
+			source, target := pair.source, pair.target
+			delete(state.people, memoryKey{tenantID, target.ID})
+			target.ID = source.ID
+			target.PhotoURL = coalesceString(target.PhotoURL, source.PhotoURL)
+			target.ManagerID = coalesceString(target.ManagerID, source.ManagerID)
+			target.GroupID = coalesceString(target.GroupID, source.GroupID)
+			target.RoleIds = coalesceStrings(source.RoleIds, target.RoleIds)
+			target.CRMRoleIds = coalesceStrings(source.CRMRoleIds, target.CRMRoleIds)
+			target.Type = source.Type
+			target.IsProvisioned = target.IsProvisioned || source.IsProvisioned
+			target.IsSynced = source.IsSynced
+			if source.Status == "active" || target.Status == "active" {
+				target.Status = "active"
+			} else {
+				target.Status = "inactive"
+			}
+			state.people[memoryKey{tenantID, target.ID}] = target
+			result = append(result, &CNCUserCleanupResult{ID: sql.NullString{String: target.ID, Valid: true}, Action: sql.NullString{String: "swap", Valid: true}})
+		}
+
+		for _, id := range unlinkIDs {
+			p, ok := state.people[memoryKey{tenantID, id}]
+			if !ok {
+				continue
+			}
+			p.OutreachID = null.String{}
+			p.OutreachIsAdmin = null.Bool{}
+			p.OutreachGUID = null.String{}
+			p.OutreachRoleID = null.String{}
+			result = append(result, &CNCUserCleanupResult{ID: sql.NullString{String: id, Valid: true}, Action: sql.NullString{String: "unlink", Valid: true}})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (svc *MemoryPersonService) MakeUsersHierarchical(ctx context.Context, tenantID string) error {
+	return svc.write(func(state *memoryState) error {
+		crmRoles := state.tenantCRMRoles(tenantID)
+		// roles synced from outreach can share an outreach id with the crm role they map to, prefer the crm role
+		findByOutreachID := func(outreachID null.String) *models.CRMRole {
+			if !outreachID.Valid {
+				return nil
+			}
+			var found *models.CRMRole
+			for _, cr := range crmRoles {
+				if !cr.OutreachID.Valid || cr.OutreachID.String != outreachID.String {
+					continue
+				}
+				if cr.OutreachID.String != cr.ID {
+					return cr
+				}
+				if found == nil {
+					found = cr
+				}
+			}
+			return found
+		}
+
+		newParents := map[string]string{}
+		for _, target := range crmRoles {
+			source := findByOutreachID(target.OutreachParentID)
+			if source != nil && target.ParentID.String != source.ID {
+				newParents[target.ID] = source.ID
+			}
+		}
+		for id, parentID := range newParents {
+			state.crmRoles[memoryKey{tenantID, id}].ParentID = null.StringFrom(parentID)
+		}
+
+		for _, p := range state.tenantPeople(tenantID) {
+			if !p.OutreachRoleID.Valid {
+				if p.CRMRoleIds != nil {
+					p.CRMRoleIds = nil
+				}
+				continue
+			}
+			role := findByOutreachID(p.OutreachRoleID)
+			if role != nil && (p.CRMRoleIds == nil || !containsString(p.CRMRoleIds, role.ID)) {
+				p.CRMRoleIds = types.StringArray{role.ID}
+			}
+		}
+		return nil
+	})
+}
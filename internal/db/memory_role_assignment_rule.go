@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// MemoryRoleAssignmentRuleService is the in-memory RoleAssignmentRuleRepository
+type MemoryRoleAssignmentRuleService struct {
+	*memoryService
+}
+
+var _ RoleAssignmentRuleRepository = (*MemoryRoleAssignmentRuleService)(nil)
+
+func (svc *MemoryRoleAssignmentRuleService) GetRules(ctx context.Context, tenantID string) ([]*RoleAssignmentRule, error) {
+	results := []*RoleAssignmentRule{}
+	err := svc.read(func(state *memoryState) error {
+		for _, rule := range state.roleAssignmentRules {
+			if rule.TenantID == tenantID {
+				results = append(results, copyRoleAssignmentRule(rule))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Value != b.Value {
+			return a.Value < b.Value
+		}
+		return a.SystemRoleID < b.SystemRoleID
+	})
+	return results, nil
+}
+
+func (svc *MemoryRoleAssignmentRuleService) SetRules(ctx context.Context, tenantID string, rules []*RoleAssignmentRule, at time.Time) error {
+	return svc.write(func(state *memoryState) error {
+		for id, rule := range state.roleAssignmentRules {
+			if rule.TenantID == tenantID {
+				delete(state.roleAssignmentRules, id)
+			}
+		}
+		for _, rule := range rules {
+			if _, ok := state.roleAssignmentRules[rule.ID]; ok {
+				return errMemoryDuplicate("role_assignment_rule")
+			}
+			rule.TenantID, rule.CreatedAt, rule.UpdatedAt = tenantID, at, at
+			state.roleAssignmentRules[rule.ID] = copyRoleAssignmentRule(rule)
+		}
+		return nil
+	})
+}
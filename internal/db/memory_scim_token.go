@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/volatiletech/null/v8"
+)
+
+// MemorySCIMTokenService is the in-memory SCIMTokenRepository
+type MemorySCIMTokenService struct {
+	*memoryService
+}
+
+var _ SCIMTokenRepository = (*MemorySCIMTokenService)(nil)
+
+func (svc *MemorySCIMTokenService) Create(ctx context.Context, tenantID, description string) (string, error) {
+	token, err := newSCIMToken()
+	if err != nil {
+		return "", err
+	}
+	row := &SCIMToken{
+		ID:          MakeID(),
+		TenantID:    tenantID,
+		TokenHash:   hashSCIMToken(token),
+		Description: description,
+		CreatedAt:   memoryNow(),
+	}
+	err = svc.write(func(state *memoryState) error {
+		state.scimTokens[row.ID] = copySCIMToken(row)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (svc *MemorySCIMTokenService) GetTenantID(ctx context.Context, token string) (string, error) {
+	tenantID := ""
+	hash := hashSCIMToken(token)
+	err := svc.read(func(state *memoryState) error {
+		for _, row := range state.scimTokens {
+			if row.TokenHash == hash && !row.RevokedAt.Valid {
+				tenantID = row.TenantID
+			}
+		}
+		return nil
+	})
+	return tenantID, err
+}
+
+func (svc *MemorySCIMTokenService) GetTenantTokens(ctx context.Context, tenantID string) ([]*SCIMToken, error) {
+	results := []*SCIMToken{}
+	err := svc.read(func(state *memoryState) error {
+		for _, row := range state.scimTokens {
+			if row.TenantID == tenantID {
+				results = append(results, copySCIMToken(row))
+			}
+		}
+		return nil
+	})
+	sort.Slice(results, func(i, j int) bool { return results[i].CreatedAt.After(results[j].CreatedAt) })
+	return results, err
+}
+
+func (svc *MemorySCIMTokenService) Revoke(ctx context.Context, tenantID, id string) error {
+	now := memoryNow()
+	return svc.write(func(state *memoryState) error {
+		row, ok := state.scimTokens[id]
+		if !ok || row.TenantID != tenantID || row.RevokedAt.Valid {
+			return fmt.Errorf("error revoking scim token: update affected 0 rows")
+		}
+		row.RevokedAt = null.TimeFrom(now)
+		return nil
+	})
+}
@@ -0,0 +1,232 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/loupe-co/orchard/internal/models"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+)
+
+// MemorySystemRoleService is the in-memory SystemRoleRepository
+type MemorySystemRoleService struct {
+	*memoryService
+}
+
+var _ SystemRoleRepository = (*MemorySystemRoleService)(nil)
+
+func (svc *MemorySystemRoleService) FromProto(sr *orchardPb.SystemRole) *models.SystemRole {
+	return (&SystemRoleService{}).FromProto(sr)
+}
+
+func (svc *MemorySystemRoleService) ToProto(sr *models.SystemRole) (*orchardPb.SystemRole, error) {
+	return (&SystemRoleService{}).ToProto(sr)
+}
+
+// sortedSystemRoles returns the system roles matching keep ordered by id, with their effective permissions
+func (state *memoryState) sortedSystemRoles(keep func(sr *models.SystemRole) bool) []*models.SystemRole {
+	systemRoles := []*models.SystemRole{}
+	for _, sr := range state.systemRoles {
+		if keep(sr) {
+			systemRoles = append(systemRoles, copySystemRole(sr))
+		}
+	}
+	sort.Slice(systemRoles, func(i, j int) bool { return systemRoles[i].ID < systemRoles[j].ID })
+	inheritBasePermissions(systemRoles, state.systemRoles)
+	return systemRoles
+}
+
+func (svc *MemorySystemRoleService) Insert(ctx context.Context, sr *models.SystemRole) error {
+	currTime := memoryNow()
+	sr.CreatedAt = currTime
+	sr.UpdatedAt = currTime
+	return svc.write(func(state *memoryState) error {
+		if _, ok := state.systemRoles[sr.ID]; ok {
+			return errMemoryDuplicate("system_role")
+		}
+		state.systemRoles[sr.ID] = copySystemRole(sr)
+		return nil
+	})
+}
+
+func (svc *MemorySystemRoleService) GetByID(ctx context.Context, id string) (*models.SystemRole, error) {
+	var systemRole *models.SystemRole
+	err := svc.read(func(state *memoryState) error {
+		sr, ok := state.systemRoles[id]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		systemRole = copySystemRole(sr)
+		inheritBasePermissions([]*models.SystemRole{systemRole}, state.systemRoles)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return systemRole, nil
+}
+
+func (svc *MemorySystemRoleService) GetByIDs(ctx context.Context, ids ...string) ([]*models.SystemRole, error) {
+	var systemRoles []*models.SystemRole
+	err := svc.read(func(state *memoryState) error {
+		systemRoles = state.sortedSystemRoles(func(sr *models.SystemRole) bool { return containsString(ids, sr.ID) })
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return systemRoles, nil
+}
+
+func (svc *MemorySystemRoleService) GetByIDWithBaseRole(ctx context.Context, id string) ([]*models.SystemRole, error) {
+	systemRoles := []*models.SystemRole{}
+	err := svc.read(func(state *memoryState) error {
+		seen := map[string]bool{}
+		sr, ok := state.systemRoles[id]
+		for ok && !seen[sr.ID] {
+			seen[sr.ID] = true
+			systemRoles = append(systemRoles, copySystemRole(sr))
+			if !sr.BaseRoleID.Valid {
+				break
+			}
+			sr, ok = state.systemRoles[sr.BaseRoleID.String]
+		}
+		inheritBasePermissions(systemRoles, state.systemRoles)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return systemRoles, nil
+}
+
+func (svc *MemorySystemRoleService) Search(ctx context.Context, tenantID, query string) ([]*models.SystemRole, error) {
+	pattern := "%" + strings.ToLower(query) + "%"
+	var systemRoles []*models.SystemRole
+	err := svc.read(func(state *memoryState) error {
+		systemRoles = state.sortedSystemRoles(func(sr *models.SystemRole) bool {
+			if sr.Type == "internal" || sr.Status != "active" {
+				return false
+			}
+			if sr.TenantID != DefaultTenantID && (tenantID == "" || sr.TenantID != tenantID) {
+				return false
+			}
+			return query == "" || matchLike(strings.ToLower(sr.Name), pattern)
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return systemRoles, nil
+}
+
+func (svc *MemorySystemRoleService) GetClones(ctx context.Context, tenantID string) ([]*models.SystemRole, error) {
+	var systemRoles []*models.SystemRole
+	err := svc.read(func(state *memoryState) error {
+		systemRoles = state.sortedSystemRoles(func(sr *models.SystemRole) bool {
+			return sr.TenantID == tenantID && sr.Status == "active" && sr.BaseRoleID.Valid && sr.BaseRoleID.String != ""
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(systemRoles, func(i, j int) bool { return systemRoles[i].Name < systemRoles[j].Name })
+	return systemRoles, nil
+}
+
+func (svc *MemorySystemRoleService) CountClones(ctx context.Context, baseRoleID string) (int64, error) {
+	var count int64
+	err := svc.read(func(state *memoryState) error {
+		for _, sr := range state.systemRoles {
+			if sr.BaseRoleID.Valid && sr.BaseRoleID.String == baseRoleID && sr.Status == "active" {
+				count++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (svc *MemorySystemRoleService) GetInternalRoleIDs(ctx context.Context) (map[string]struct{}, error) {
+	ids := map[string]struct{}{}
+	err := svc.read(func(state *memoryState) error {
+		for _, sr := range state.systemRoles {
+			if sr.Type == "internal" {
+				ids[sr.ID] = struct{}{}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (svc *MemorySystemRoleService) Update(ctx context.Context, sr *models.SystemRole, onlyFields []string) error {
+	whitelist := defaultSystemRoleUpdateWhitelist
+	if len(onlyFields) > 0 {
+		whitelist = onlyFields
+	}
+	var hasUpdatedAt bool
+	for _, f := range whitelist {
+		if f == "updated_at" {
+			hasUpdatedAt = true
+		}
+	}
+	if !hasUpdatedAt {
+		whitelist = append(whitelist, "updated_at")
+	}
+
+	sr.UpdatedAt = memoryNow()
+
+	return svc.write(func(state *memoryState) error {
+		existing, ok := state.systemRoles[sr.ID]
+		if !ok {
+			return fmt.Errorf("error updating systemRole: update affected 0 rows")
+		}
+		updated := copySystemRole(existing)
+		if err := setColumns(updated, copySystemRole(sr), whitelist); err != nil {
+			return err
+		}
+		state.systemRoles[sr.ID] = updated
+		return nil
+	})
+}
+
+func (svc *MemorySystemRoleService) DeleteByID(ctx context.Context, id string) error {
+	return svc.write(func(state *memoryState) error {
+		if _, ok := state.systemRoles[id]; !ok {
+			return fmt.Errorf("error deleting systemRole: delete affected 0 rows")
+		}
+		delete(state.systemRoles, id)
+		return nil
+	})
+}
+
+// LockByID has nothing to lock, memory transactions replay their writes on commit instead of locking rows
+func (svc *MemorySystemRoleService) LockByID(ctx context.Context, id string) error {
+	return nil
+}
+
+func (svc *MemorySystemRoleService) SoftDeleteByID(ctx context.Context, id, tenantID, userID string) error {
+	currTime := memoryNow()
+	return svc.write(func(state *memoryState) error {
+		sr, ok := state.systemRoles[id]
+		if !ok {
+			return fmt.Errorf("error soft deleting systemRole: delete affected 0 rows")
+		}
+		sr.Status = "inactive"
+		sr.UpdatedBy = userID
+		sr.UpdatedAt = currTime
+		return nil
+	})
+}
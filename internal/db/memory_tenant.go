@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/orchard/internal/models"
+	tenantPb "github.com/loupe-co/protos/src/common/tenant"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+// MemoryTenantService is the in-memory TenantRepository
+type MemoryTenantService struct {
+	*memoryService
+}
+
+var _ TenantRepository = (*MemoryTenantService)(nil)
+
+// SetTenant adds or replaces a tenant, orchard never writes tenants itself so this is how tests seed them
+func (store *MemoryStore) SetTenant(t *models.Tenant) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.state.tenants[t.ID] = copyTenant(t)
+}
+
+func (svc *MemoryTenantService) FromProto(t *tenantPb.Tenant) (*models.Tenant, error) {
+	return (&TenantService{}).FromProto(t)
+}
+
+func (svc *MemoryTenantService) ToProto(t *models.Tenant) (*tenantPb.Tenant, error) {
+	return (&TenantService{}).ToProto(t)
+}
+
+func (svc *MemoryTenantService) GetByID(ctx context.Context, tenantID string) (*models.Tenant, error) {
+	var tenant *models.Tenant
+	err := svc.read(func(state *memoryState) error {
+		t, ok := state.tenants[tenantID]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		tenant = copyTenant(t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tenant, nil
+}
+
+func (svc *MemoryTenantService) GetGroupSyncState(ctx context.Context, tenantID string) (tenantPb.GroupSyncStatus, error) {
+	groupSyncState := ""
+	err := svc.read(func(state *memoryState) error {
+		t, ok := state.tenants[tenantID]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		groupSyncState = t.GroupSyncState
+		return nil
+	})
+	if err != nil {
+		return tenantPb.GroupSyncStatus_Inactive, errors.Wrap(err, "error getting tenant group sync state")
+	}
+
+	state := tenantPb.GroupSyncStatus_Inactive
+	switch groupSyncState {
+	case "active":
+		state = tenantPb.GroupSyncStatus_Active
+	case "people_only", "peopleonly":
+		state = tenantPb.GroupSyncStatus_PeopleOnly
+	}
+	return state, nil
+}
+
+func (svc *MemoryTenantService) CheckPeopleSyncState(ctx context.Context, tenantID string) (peopleSynced bool, err error) {
+	err = svc.read(func(state *memoryState) error {
+		crmRoleCount := 0
+		for _, g := range state.tenantGroups(tenantID) {
+			if g.Status == "active" {
+				crmRoleCount += len(g.CRMRoleIds)
+			}
+		}
+		peopleSynced = crmRoleCount > 0
+		return nil
+	})
+	return peopleSynced, err
+}
+
+func (svc *MemoryTenantService) UpdateGroupSyncState(ctx context.Context, tenantID string, state tenantPb.GroupSyncStatus) error {
+	newState := "inactive"
+	switch state {
+	case tenantPb.GroupSyncStatus_Active:
+		newState = "active"
+	case tenantPb.GroupSyncStatus_PeopleOnly:
+		newState = "people_only"
+	}
+	err := svc.write(func(state *memoryState) error {
+		if t, ok := state.tenants[tenantID]; ok {
+			t.GroupSyncState = newState
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "error updating tenant in sql")
+	}
+	return nil
+}
+
+func (svc *MemoryTenantService) UpdateGroupSyncMetadata(ctx context.Context, tenantID string, metadata *tenantPb.GroupSyncMetadata) error {
+	metadataRaw, err := json.Marshal(metadata)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling metadata")
+	}
+	err = svc.write(func(state *memoryState) error {
+		if t, ok := state.tenants[tenantID]; ok {
+			t.GroupSyncMetadata = types.JSON(metadataRaw)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "error updating tenant in sql")
+	}
+	return nil
+}
+
+func (svc *MemoryTenantService) GetActiveTenants(ctx context.Context) ([]*models.Tenant, error) {
+	tenants := []*models.Tenant{}
+	// like the sql this doesn't run in the transaction
+	err := svc.readCommitted(func(state *memoryState) error {
+		for _, t := range state.tenants {
+			if t.Status != "expired" && t.Status != "deleted" {
+				tenants = append(tenants, copyTenant(t))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error querying for active tenants")
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+	return tenants, nil
+}
+
+func (svc *MemoryTenantService) GetTenantPersonCounts(ctx context.Context, tenantID string) (*TenantPersonCountResponse, error) {
+	res := &TenantPersonCountResponse{}
+	err := svc.read(func(state *memoryState) error {
+		for _, p := range state.tenantPeople(tenantID) {
+			// the sql joins groups by id alone, so a person is counted once per group with their group id in any tenant
+			joined := []*models.Group{}
+			if p.GroupID.Valid {
+				for _, g := range state.groups {
+					if g.ID == p.GroupID.String {
+						joined = append(joined, g)
+					}
+				}
+			}
+			if len(joined) == 0 {
+				joined = append(joined, nil)
+			}
+			for _, g := range joined {
+				if p.Status == "active" && p.GroupID.Valid && g != nil && g.Status == "active" {
+					res.ActiveInGroup++
+				}
+				if p.Status == "inactive" {
+					res.Inactive++
+				}
+				if p.Status == "active" && p.IsProvisioned {
+					res.Provisioned++
+				}
+				res.Total++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting person counts for tenant")
+	}
+	return res, nil
+}
@@ -0,0 +1,368 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/loupe-co/orchard/internal/models"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+func newTestMemoryPerson(name string) *models.Person {
+	return &models.Person{
+		ID:         MakeID(),
+		TenantID:   DefaultTenantID,
+		Name:       null.StringFrom(name),
+		Email:      null.StringFrom(strings.ToLower(strings.ReplaceAll(name, " ", ".")) + "@canopy.io"),
+		Status:     "active",
+		Type:       "ic",
+		RoleIds:    types.StringArray{},
+		CRMRoleIds: types.StringArray{},
+	}
+}
+
+// memoryPersonExists reads the committed state, outside of any transaction
+func memoryPersonExists(store *MemoryStore, id string) (bool, error) {
+	_, err := store.NewPersonService().GetByID(context.Background(), id, DefaultTenantID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func TestMemoryTransactionCommit(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	tx, err := store.NewTransaction(ctx)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	svc := store.NewPersonService()
+	svc.SetTransaction(tx)
+
+	p := newTestMemoryPerson("Commit Person")
+	if err := svc.Insert(ctx, p); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if _, err := svc.GetByID(ctx, p.ID, DefaultTenantID); err != nil {
+		t.Log("expected the transaction to read its own insert, but got", err)
+		t.Fail()
+		return
+	}
+	if ok, err := memoryPersonExists(store, p.ID); err != nil || ok {
+		t.Log("expected the insert to be invisible outside the transaction until commit")
+		t.Fail()
+		return
+	}
+
+	if err := svc.Commit(); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if ok, err := memoryPersonExists(store, p.ID); err != nil || !ok {
+		t.Log("expected the insert to be visible after commit, but got", err)
+		t.Fail()
+		return
+	}
+	if err := svc.Commit(); err != sql.ErrTxDone {
+		t.Log("expected a second commit to fail with sql.ErrTxDone, but got", err)
+		t.Fail()
+		return
+	}
+}
+
+func TestMemoryTransactionRollback(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	tx, err := store.NewTransaction(ctx)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	svc := store.NewPersonService()
+	svc.SetTransaction(tx)
+
+	p := newTestMemoryPerson("Rollback Person")
+	if err := svc.Insert(ctx, p); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if err := svc.Rollback(); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if ok, err := memoryPersonExists(store, p.ID); err != nil || ok {
+		t.Log("expected a rolled back insert to be dropped")
+		t.Fail()
+		return
+	}
+	if _, err := svc.GetByID(ctx, p.ID, DefaultTenantID); err != sql.ErrTxDone {
+		t.Log("expected reads on a rolled back transaction to fail with sql.ErrTxDone, but got", err)
+		t.Fail()
+		return
+	}
+	if err := svc.Insert(ctx, newTestMemoryPerson("After Rollback")); err != sql.ErrTxDone {
+		t.Log("expected writes on a rolled back transaction to fail with sql.ErrTxDone, but got", err)
+		t.Fail()
+		return
+	}
+	if err := svc.Commit(); err != sql.ErrTxDone {
+		t.Log("expected commit after rollback to fail with sql.ErrTxDone, but got", err)
+		t.Fail()
+		return
+	}
+
+	// a failed write outside a transaction leaves the committed state alone
+	dup := newTestMemoryPerson("Duplicate Person")
+	if err := store.NewPersonService().Insert(ctx, dup); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	renamed := *dup
+	renamed.Name = null.StringFrom("Renamed")
+	if err := store.NewPersonService().Insert(ctx, &renamed); err == nil {
+		t.Log("expected inserting the same id twice to fail")
+		t.Fail()
+		return
+	}
+	saved, err := store.NewPersonService().GetByID(ctx, dup.ID, DefaultTenantID)
+	if err != nil || saved.Name.String != "Duplicate Person" {
+		t.Log("expected the failed insert not to change the saved person, but got", saved, err)
+		t.Fail()
+		return
+	}
+}
+
+func TestMemoryTransactionReplay(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	tx, err := store.NewTransaction(ctx)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	svc := store.NewPersonService()
+	svc.SetTransaction(tx)
+	inTx := newTestMemoryPerson("In Transaction")
+	if err := svc.Insert(ctx, inTx); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// something else commits after the transaction took its snapshot
+	outside := newTestMemoryPerson("Outside Transaction")
+	if err := store.NewPersonService().Insert(ctx, outside); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if err := svc.Commit(); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	for _, id := range []string{inTx.ID, outside.ID} {
+		if ok, err := memoryPersonExists(store, id); err != nil || !ok {
+			t.Log("expected both the transaction's insert and the one committed during it to be kept, missing", id)
+			t.Fail()
+			return
+		}
+	}
+
+	// a write replayed on commit fails the commit the way the conflicting sql statement would
+	tx, err = store.NewTransaction(ctx)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	svc.SetTransaction(tx)
+	conflict := newTestMemoryPerson("Conflict")
+	if err := svc.Insert(ctx, conflict); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if err := store.NewPersonService().Insert(ctx, conflict); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if err := svc.Commit(); err == nil {
+		t.Log("expected replaying a duplicate insert on commit to fail")
+		t.Fail()
+		return
+	}
+}
+
+func TestMemoryTransactionReplayKeepsGeneratedIDs(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	crmSvc := store.NewCRMRoleService()
+	parent := &models.CRMRole{ID: MakeID(), TenantID: DefaultTenantID, Name: "Sales"}
+	child := &models.CRMRole{ID: MakeID(), TenantID: DefaultTenantID, Name: "AEs", ParentID: null.StringFrom(parent.ID)}
+	for _, cr := range []*models.CRMRole{parent, child} {
+		if err := crmSvc.Insert(ctx, cr); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+	}
+
+	tx, err := store.NewTransaction(ctx)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	groupSvc := store.NewGroupService()
+	groupSvc.SetTransaction(tx)
+	if err := groupSvc.SyncGroups(ctx, DefaultTenantID); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	before, err := groupSvc.Search(ctx, DefaultTenantID, "")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// forces the sync to be replayed on commit
+	if err := store.NewPersonService().Insert(ctx, newTestMemoryPerson("Replay Person")); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if err := groupSvc.Commit(); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	after, err := store.NewGroupService().Search(ctx, DefaultTenantID, "")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	ids := func(groups []*models.Group) string {
+		res := []string{}
+		for _, g := range groups {
+			res = append(res, g.ID+"/"+g.ParentID.String)
+		}
+		sort.Strings(res)
+		return strings.Join(res, ",")
+	}
+	if len(before) != 2 || ids(before) != ids(after) {
+		t.Logf("expected the committed groups to keep the ids the transaction saw, but got %s then %s", ids(before), ids(after))
+		t.Fail()
+		return
+	}
+}
+
+func TestMemoryConcurrentTransactions(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	const count = 20
+	people := make([]*models.Person, count)
+	errs := make(chan error, count)
+	wg := sync.WaitGroup{}
+	for i := range people {
+		people[i] = newTestMemoryPerson(fmt.Sprintf("Concurrent %d", i))
+		wg.Add(1)
+		go func(p *models.Person) {
+			defer wg.Done()
+			tx, err := store.NewTransaction(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			svc := store.NewPersonService()
+			svc.SetTransaction(tx)
+			if err := svc.Insert(ctx, p); err != nil {
+				svc.Rollback()
+				errs <- err
+				return
+			}
+			errs <- svc.Commit()
+		}(people[i])
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+	}
+
+	all, err := store.NewPersonService().GetAllByTenant(ctx, DefaultTenantID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(all) != count {
+		t.Logf("expected every concurrent transaction's insert to be kept, but got %d of %d", len(all), count)
+		t.Fail()
+		return
+	}
+}
+
+func TestMemoryUnknownFilterColumn(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	if err := store.NewPersonService().Insert(ctx, newTestMemoryPerson("Filter Person")); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	unknown := PersonFilter{Field: "password", Op: "EQ", Values: []interface{}{"hunter2"}}
+	_, _, err := store.NewPersonService().Search(ctx, DefaultTenantID, "", 10, 0, unknown)
+	if err == nil || !strings.Contains(err.Error(), "can't filter on password") {
+		t.Log("expected searching on an unknown column to fail, but got", err)
+		t.Fail()
+		return
+	}
+	nested := PersonFilter{Or: []Filter{{Field: "name", Op: "EQ", Values: []interface{}{"Filter Person"}}, unknown}}
+	if _, _, err := store.NewPersonService().Search(ctx, DefaultTenantID, "", 10, 0, nested); err == nil {
+		t.Log("expected an unknown column nested in an or group to fail")
+		t.Fail()
+		return
+	}
+
+	// matchFilter fails an unknown column itself rather than treating it as no match
+	if _, err := matchFilter(newTestMemoryPerson("Direct"), unknown); err == nil || !strings.Contains(err.Error(), "can't filter on password") {
+		t.Log("expected matchFilter to fail on an unknown column, but got", err)
+		t.Fail()
+		return
+	}
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/loupe-co/go-common/errors"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const (
+	createSchemaMigrationsQuery = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    TEXT PRIMARY KEY,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);`
+	schemaMigrationsExistsQuery = `SELECT to_regclass('schema_migrations') IS NOT NULL;`
+	getAppliedMigrationsQuery   = `SELECT version FROM schema_migrations;`
+	insertMigrationQuery        = `INSERT INTO schema_migrations (version) VALUES ($1);`
+	// migrateLockQuery waits instead of trying, a second migrate run should apply nothing once the first one is done
+	migrateLockQuery   = `SELECT pg_advisory_lock(hashtext('orchard:migrate'));`
+	migrateUnlockQuery = `SELECT pg_advisory_unlock(hashtext('orchard:migrate'));`
+)
+
+// Migration is a sql file under internal/db/migrations, its version is the file name without the extension
+type Migration struct {
+	Version string
+	SQL     string
+}
+
+// Migrations returns every embedded migration in the order they're applied
+func Migrations() ([]Migration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	migrations := make([]Migration, len(names))
+	for i, name := range names {
+		raw, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		migrations[i] = Migration{
+			Version: strings.TrimSuffix(path.Base(name), ".sql"),
+			SQL:     string(raw),
+		}
+	}
+	return migrations, nil
+}
+
+// PendingMigrations returns the migrations that haven't been applied yet, in the order they will be.
+// It only reads, so a dry run against a database that was never migrated doesn't create schema_migrations.
+func (db *DB) PendingMigrations(ctx context.Context) ([]Migration, error) {
+	exists := false
+	if err := db.db.QueryRowContext(ctx, schemaMigrationsExistsQuery).Scan(&exists); err != nil {
+		return nil, errors.Wrap(err, "error checking for schema_migrations")
+	}
+	if !exists {
+		return Migrations()
+	}
+	return pendingMigrations(ctx, db.db)
+}
+
+// Migrate applies every pending migration, each one in a transaction of its own together with its schema_migrations row,
+// and returns the versions it applied. Concurrent runs wait on each other so a migration is only ever applied once.
+func (db *DB) Migrate(ctx context.Context) ([]string, error) {
+	conn, err := db.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, migrateLockQuery); err != nil {
+		return nil, errors.Wrap(err, "error taking the migrate lock")
+	}
+	defer conn.ExecContext(context.Background(), migrateUnlockQuery)
+
+	if _, err := conn.ExecContext(ctx, createSchemaMigrationsQuery); err != nil {
+		return nil, errors.Wrap(err, "error creating schema_migrations")
+	}
+
+	pending, err := pendingMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []string{}
+	for _, migration := range pending {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return applied, err
+		}
+		if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+			tx.Rollback()
+			return applied, errors.Wrapf(err, "error applying migration %s", migration.Version)
+		}
+		if _, err := tx.ExecContext(ctx, insertMigrationQuery, migration.Version); err != nil {
+			tx.Rollback()
+			return applied, errors.Wrapf(err, "error recording migration %s", migration.Version)
+		}
+		if err := tx.Commit(); err != nil {
+			return applied, errors.Wrapf(err, "error commiting migration %s", migration.Version)
+		}
+		applied = append(applied, migration.Version)
+	}
+	return applied, nil
+}
+
+type migrationQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func pendingMigrations(ctx context.Context, q migrationQueryer) ([]Migration, error) {
+	rows, err := q.QueryContext(ctx, getAppliedMigrationsQuery)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting applied migrations")
+	}
+	defer rows.Close()
+
+	applied := map[string]bool{}
+	for rows.Next() {
+		version := ""
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	pending := []Migration{}
+	for _, migration := range migrations {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
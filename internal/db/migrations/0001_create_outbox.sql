@@ -0,0 +1,25 @@
+-- outbox holds side effects (bouncer cache busts, auth0 provisioning) that must only
+-- happen once the transaction that caused them has committed. Rows are written in the
+-- same transaction as the data change and delivered asynchronously by the dispatcher.
+CREATE TABLE IF NOT EXISTS outbox (
+    id              UUID PRIMARY KEY,
+    tenant_id       TEXT NOT NULL DEFAULT '',
+    kind            TEXT NOT NULL,
+    dedup_key       TEXT NOT NULL,
+    payload         JSONB NOT NULL DEFAULT '{}'::JSONB,
+    status          TEXT NOT NULL DEFAULT 'pending',
+    attempts        INT NOT NULL DEFAULT 0,
+    last_error      TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivered_at    TIMESTAMPTZ
+);
+
+-- Only one pending message per dedup key, repeated enqueues collapse into the existing row
+CREATE UNIQUE INDEX IF NOT EXISTS outbox_pending_dedup_key_idx ON outbox (dedup_key) WHERE status = 'pending';
+
+CREATE INDEX IF NOT EXISTS outbox_due_idx ON outbox (next_attempt_at) WHERE status IN ('pending', 'processing');
+
+CREATE OR REPLACE VIEW outbox_dead_letter AS
+    SELECT * FROM outbox WHERE status = 'dead';
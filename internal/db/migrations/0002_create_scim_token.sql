@@ -0,0 +1,14 @@
+-- scim_token holds the per-tenant bearer tokens an IdP (Okta, Azure AD) uses to call the SCIM endpoint.
+-- Only a sha256 hash of the token is stored, the plain token is shown once when it is created.
+CREATE TABLE IF NOT EXISTS scim_token (
+    id          UUID PRIMARY KEY,
+    tenant_id   TEXT NOT NULL,
+    token_hash  TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at  TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS scim_token_hash_idx ON scim_token (token_hash);
+
+CREATE INDEX IF NOT EXISTS scim_token_tenant_idx ON scim_token (tenant_id);
@@ -0,0 +1,12 @@
+-- Directory search matches people and groups with pg_trgm word similarity, which ranks results and tolerates typos
+-- and partial names. These indexes serve its <% operator, their expressions have to stay in step with the queries
+-- in directory.go.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS person_name_trgm_idx ON person USING GIN (LOWER(COALESCE(name, '')) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS person_first_name_trgm_idx ON person USING GIN (LOWER(COALESCE(first_name, '')) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS person_last_name_trgm_idx ON person USING GIN (LOWER(COALESCE(last_name, '')) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS person_email_trgm_idx ON person USING GIN (LOWER(COALESCE(email, '')) gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS group_name_trgm_idx ON "group" USING GIN (LOWER(name) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS crm_role_name_trgm_idx ON crm_role USING GIN (LOWER(name) gin_trgm_ops);
@@ -0,0 +1,14 @@
+-- Group viewer grants can be limited to a window, e.g. a manager delegating their team to a peer while they are on
+-- leave. A grant only counts between starts_at and ends_at, either can be open. activated_at is set by the sweeper
+-- once it has busted bouncer's cache for a grant that started, expired grants are deleted by the sweeper.
+ALTER TABLE group_viewer
+    ADD COLUMN IF NOT EXISTS starts_at    TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS ends_at      TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS delegated_by TEXT,
+    ADD COLUMN IF NOT EXISTS activated_at TIMESTAMPTZ;
+
+ALTER TABLE group_viewer DROP CONSTRAINT IF EXISTS group_viewer_validity_check;
+ALTER TABLE group_viewer ADD CONSTRAINT group_viewer_validity_check CHECK (starts_at IS NULL OR ends_at IS NULL OR starts_at < ends_at);
+
+CREATE INDEX IF NOT EXISTS group_viewer_ends_at_idx ON group_viewer (ends_at) WHERE ends_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS group_viewer_pending_start_idx ON group_viewer (starts_at) WHERE starts_at IS NOT NULL AND activated_at IS NULL;
@@ -0,0 +1,40 @@
+-- Access reviews are periodic attestations that every group viewer grant and elevated system role assignment is still
+-- needed. Opening a campaign snapshots them into access_review_item, each with the person's manager as reviewer.
+-- Closing it revokes every item that wasn't kept and records what happened to it in outcome.
+CREATE TABLE IF NOT EXISTS access_review_campaign (
+    id                UUID PRIMARY KEY,
+    tenant_id         TEXT NOT NULL,
+    name              TEXT NOT NULL DEFAULT '',
+    status            TEXT NOT NULL DEFAULT 'open',
+    system_role_types TEXT[] NOT NULL DEFAULT '{}',
+    due_at            TIMESTAMPTZ,
+    created_by        TEXT NOT NULL DEFAULT '',
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    closed_by         TEXT,
+    closed_at         TIMESTAMPTZ,
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- A tenant has at most one open campaign
+CREATE UNIQUE INDEX IF NOT EXISTS access_review_campaign_open_idx ON access_review_campaign (tenant_id) WHERE status = 'open';
+
+CREATE TABLE IF NOT EXISTS access_review_item (
+    id             UUID PRIMARY KEY,
+    campaign_id    UUID NOT NULL REFERENCES access_review_campaign (id) ON DELETE CASCADE,
+    tenant_id      TEXT NOT NULL,
+    kind           TEXT NOT NULL,
+    person_id      TEXT NOT NULL,
+    group_id       TEXT,
+    system_role_id TEXT,
+    permissions    BIGINT NOT NULL DEFAULT 0,
+    reviewer_id    TEXT NOT NULL DEFAULT '',
+    decision       TEXT NOT NULL DEFAULT 'pending',
+    decided_by     TEXT,
+    decided_at     TIMESTAMPTZ,
+    comment        TEXT,
+    outcome        TEXT,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS access_review_item_campaign_idx ON access_review_item (campaign_id, reviewer_id);
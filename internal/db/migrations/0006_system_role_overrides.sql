@@ -0,0 +1,30 @@
+-- A tenant role cloned from a base role stores only how it differs from the base: the bits it grants on top of the base
+-- role's permissions and the bits it revokes from them, indexed by permission set like permissions. Its effective
+-- permissions are computed when it's read, so changes to the base role reach every clone. permissions is left empty on
+-- clones.
+ALTER TABLE system_role
+    ADD COLUMN IF NOT EXISTS granted_permissions BIGINT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS revoked_permissions BIGINT[] NOT NULL DEFAULT '{}';
+
+-- Clones with their own copy of the permissions keep them as overrides of what their base role has today. Clones without
+-- any permissions already inherited everything from their base role.
+UPDATE system_role sr SET
+    granted_permissions = o.granted,
+    revoked_permissions = o.revoked,
+    permissions = '{}'
+FROM (
+    SELECT c.id,
+        ARRAY(
+            SELECT COALESCE(c.permissions[i], 0) & ~COALESCE(b.permissions[i], 0)
+            FROM GENERATE_SERIES(1, GREATEST(CARDINALITY(c.permissions), CARDINALITY(b.permissions))) AS i
+            ORDER BY i
+        ) AS granted,
+        ARRAY(
+            SELECT COALESCE(b.permissions[i], 0) & ~COALESCE(c.permissions[i], 0)
+            FROM GENERATE_SERIES(1, GREATEST(CARDINALITY(c.permissions), CARDINALITY(b.permissions))) AS i
+            ORDER BY i
+        ) AS revoked
+    FROM system_role c INNER JOIN system_role b ON b.id::TEXT = c.base_role_id::TEXT
+    WHERE CARDINALITY(c.permissions) > 0
+) o
+WHERE sr.id = o.id;
@@ -0,0 +1,18 @@
+-- Role assignment rules give people system roles from where they sit in the hierarchy and who they are: the type of their
+-- group, being in the subtree of a group or their email domain. Every role a tenant's rules mention is managed by the rules,
+-- people get it when a rule matches them and lose it when none does. Roles no rule mentions are left alone.
+CREATE TABLE IF NOT EXISTS role_assignment_rule (
+    id             UUID PRIMARY KEY,
+    tenant_id      TEXT NOT NULL,
+    kind           TEXT NOT NULL,
+    value          TEXT NOT NULL DEFAULT '',
+    system_role_id TEXT NOT NULL,
+    created_by     TEXT NOT NULL DEFAULT '',
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS role_assignment_rule_tenant_idx ON role_assignment_rule (tenant_id, kind, value, system_role_id);
+
+-- People whose system roles were set by hand keep them, neither the rules nor user sync change their role_ids
+ALTER TABLE person ADD COLUMN IF NOT EXISTS role_ids_locked BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,3 @@
+-- Player-coaches are reps who also coach, they get their own person type instead of the 'outreach_playercoach' marker in
+-- manager_id. A new enum value can't be used in the transaction that adds it, so existing markers are converted in 0009.
+ALTER TYPE person_type ADD VALUE IF NOT EXISTS 'player_coach';
@@ -0,0 +1,7 @@
+-- People marked as player-coaches through manager_id become player_coach people. The marker took the place of their manager,
+-- so there's no manager to keep.
+UPDATE person SET
+    "type" = 'player_coach',
+    manager_id = NULL,
+    updated_at = NOW()
+WHERE manager_id = 'outreach_playercoach';
@@ -0,0 +1,224 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+const (
+	OutboxKindBustAuthCache = "bust_auth_cache"
+	OutboxKindProvisionUser = "provision_user"
+
+	OutboxStatusPending    = "pending"
+	OutboxStatusProcessing = "processing"
+	OutboxStatusDelivered  = "delivered"
+	OutboxStatusDead       = "dead"
+
+	uniqueViolationCode = "23505"
+)
+
+// OutboxMessage is a side effect (bouncer cache bust, auth0 provisioning) recorded in the same transaction as the change that caused it
+type OutboxMessage struct {
+	ID            string      `boil:"id" json:"id"`
+	TenantID      string      `boil:"tenant_id" json:"tenant_id"`
+	Kind          string      `boil:"kind" json:"kind"`
+	DedupKey      string      `boil:"dedup_key" json:"dedup_key"`
+	Payload       types.JSON  `boil:"payload" json:"payload"`
+	Status        string      `boil:"status" json:"status"`
+	Attempts      int         `boil:"attempts" json:"attempts"`
+	LastError     null.String `boil:"last_error" json:"last_error,omitempty"`
+	NextAttemptAt time.Time   `boil:"next_attempt_at" json:"next_attempt_at"`
+	CreatedAt     time.Time   `boil:"created_at" json:"created_at"`
+	UpdatedAt     time.Time   `boil:"updated_at" json:"updated_at"`
+	DeliveredAt   null.Time   `boil:"delivered_at" json:"delivered_at,omitempty"`
+}
+
+// OutboxPayload is the json payload stored on an outbox message
+type OutboxPayload struct {
+	PersonID string `json:"person_id,omitempty"`
+	Email    string `json:"email,omitempty"`
+}
+
+func (msg *OutboxMessage) GetPayload() (*OutboxPayload, error) {
+	payload := &OutboxPayload{}
+	if len(msg.Payload) == 0 {
+		return payload, nil
+	}
+	if err := json.Unmarshal(msg.Payload, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func newOutboxMessage(kind, tenantID string, payload OutboxPayload) *OutboxMessage {
+	rawPayload, _ := json.Marshal(payload)
+	return &OutboxMessage{
+		ID:       MakeID(),
+		TenantID: tenantID,
+		Kind:     kind,
+		DedupKey: outboxDedupKey(kind, tenantID, payload.PersonID, payload.Email),
+		Payload:  types.JSON(rawPayload),
+		Status:   OutboxStatusPending,
+	}
+}
+
+// outboxDedupKey includes the email so a pending message for a person's old email doesn't swallow one for their new email
+func outboxDedupKey(kind, tenantID, personID, email string) string {
+	return fmt.Sprintf("%s:%s:%s:%s", kind, tenantID, personID, strings.ToLower(strings.TrimSpace(email)))
+}
+
+// NewBustAuthCacheMessage creates an outbox message that busts a person's auth cache in bouncer. An empty tenantID and personID busts everything.
+func NewBustAuthCacheMessage(tenantID, personID string) *OutboxMessage {
+	return newOutboxMessage(OutboxKindBustAuthCache, tenantID, OutboxPayload{PersonID: personID})
+}
+
+// NewProvisionUserMessage creates an outbox message that re-provisions (or unprovisions) a person in auth0 based on their current person records
+func NewProvisionUserMessage(tenantID, personID, email string) *OutboxMessage {
+	return newOutboxMessage(OutboxKindProvisionUser, tenantID, OutboxPayload{PersonID: personID, Email: email})
+}
+
+// NewProvisionEmailMessage creates an outbox message that re-provisions whoever still has email after personID stopped using it.
+// Unlike NewProvisionUserMessage it never unprovisions personID.
+func NewProvisionEmailMessage(tenantID, personID, email string) *OutboxMessage {
+	msg := newOutboxMessage(OutboxKindProvisionUser, tenantID, OutboxPayload{Email: email})
+	msg.DedupKey = outboxDedupKey(OutboxKindProvisionUser, tenantID, personID, email)
+	return msg
+}
+
+type OutboxService struct {
+	*DBService
+}
+
+func (db *DB) NewOutboxService() OutboxRepository {
+	return &OutboxService{
+		DBService: db.NewDBService(),
+	}
+}
+
+const (
+	enqueueOutboxQuery = `INSERT INTO outbox (id, tenant_id, kind, dedup_key, payload, status, next_attempt_at, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, 'pending', NOW(), NOW(), NOW())
+	ON CONFLICT (dedup_key) WHERE status = 'pending' DO NOTHING;`
+)
+
+// Enqueue writes the messages to the outbox, any message with the same dedup key as a message that is still pending is dropped.
+// Should be called with the same transaction as the change that requires the side effect.
+func (svc *OutboxService) Enqueue(ctx context.Context, msgs ...*OutboxMessage) error {
+	spanCtx, span := log.StartSpan(ctx, "Outbox.Enqueue")
+	defer span.End()
+
+	for _, msg := range msgs {
+		if _, err := queries.Raw(enqueueOutboxQuery, msg.ID, msg.TenantID, msg.Kind, msg.DedupKey, msg.Payload).ExecContext(spanCtx, svc.GetContextExecutor()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const (
+	claimOutboxQuery = `UPDATE outbox SET
+		status = 'processing',
+		attempts = attempts + 1,
+		next_attempt_at = NOW() + ($2 * INTERVAL '1 second'),
+		updated_at = NOW()
+	WHERE id IN (
+		SELECT id FROM outbox
+		WHERE status IN ('pending', 'processing') AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING *;`
+)
+
+// Claim leases up to limit due messages for delivery. Claimed messages that aren't marked delivered or failed before the lease expires are picked up again.
+func (svc *OutboxService) Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxMessage, error) {
+	spanCtx, span := log.StartSpan(ctx, "Outbox.Claim")
+	defer span.End()
+
+	results := []*OutboxMessage{}
+	if err := queries.Raw(claimOutboxQuery, limit, int(lease.Seconds())).Bind(spanCtx, svc.GetContextExecutor(), &results); err != nil {
+		log.WithContext(spanCtx).WithCustom("query", claimOutboxQuery).Error(err)
+		return nil, err
+	}
+
+	return results, nil
+}
+
+const (
+	markOutboxDeliveredQuery = `UPDATE outbox SET status = 'delivered', last_error = NULL, delivered_at = NOW(), updated_at = NOW() WHERE id = $1;`
+)
+
+func (svc *OutboxService) MarkDelivered(ctx context.Context, id string) error {
+	spanCtx, span := log.StartSpan(ctx, "Outbox.MarkDelivered")
+	defer span.End()
+	_, err := queries.Raw(markOutboxDeliveredQuery, id).ExecContext(spanCtx, svc.GetContextExecutor())
+	return err
+}
+
+const (
+	markOutboxFailedQuery = `UPDATE outbox SET status = $2, last_error = $3, next_attempt_at = $4, updated_at = NOW() WHERE id = $1;`
+)
+
+// MarkFailed records a failed delivery attempt, scheduling a retry at retryAt or moving the message to the dead letter view if dead is true
+func (svc *OutboxService) MarkFailed(ctx context.Context, id, lastError string, retryAt time.Time, dead bool) error {
+	spanCtx, span := log.StartSpan(ctx, "Outbox.MarkFailed")
+	defer span.End()
+
+	status := OutboxStatusPending
+	if dead {
+		status = OutboxStatusDead
+	}
+
+	_, err := queries.Raw(markOutboxFailedQuery, id, status, lastError, retryAt).ExecContext(spanCtx, svc.GetContextExecutor())
+	// A newer pending message with the same dedup key supersedes this one, so only the newer one needs to be retried
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolationCode {
+		_, err = queries.Raw(markOutboxDeliveredQuery, id).ExecContext(spanCtx, svc.GetContextExecutor())
+	}
+	return err
+}
+
+const (
+	getDeadLetterQuery = `SELECT * FROM outbox_dead_letter
+	WHERE ($1 = '' OR tenant_id = $1)
+	ORDER BY updated_at DESC
+	LIMIT $2 OFFSET $3;`
+)
+
+// GetDeadLetters lists messages that exhausted their retries, optionally filtered by tenant
+func (svc *OutboxService) GetDeadLetters(ctx context.Context, tenantID string, limit, offset int) ([]*OutboxMessage, error) {
+	spanCtx, span := log.StartSpan(ctx, "Outbox.GetDeadLetters")
+	defer span.End()
+
+	results := []*OutboxMessage{}
+	if err := queries.Raw(getDeadLetterQuery, tenantID, limit, offset).Bind(spanCtx, svc.GetContextExecutor(), &results); err != nil {
+		log.WithTenantID(tenantID).WithCustom("query", getDeadLetterQuery).Error(err)
+		return nil, err
+	}
+
+	return results, nil
+}
+
+const (
+	requeueDeadLetterQuery = `UPDATE outbox o SET status = 'pending', attempts = 0, last_error = NULL, next_attempt_at = NOW(), updated_at = NOW()
+	WHERE o.id = $1 AND o.status = 'dead'
+		AND NOT EXISTS (SELECT 1 FROM outbox p WHERE p.dedup_key = o.dedup_key AND p.status = 'pending');`
+)
+
+// Requeue moves a dead message back to pending so the dispatcher retries it
+func (svc *OutboxService) Requeue(ctx context.Context, id string) error {
+	spanCtx, span := log.StartSpan(ctx, "Outbox.Requeue")
+	defer span.End()
+	_, err := queries.Raw(requeueDeadLetterQuery, id).ExecContext(spanCtx, svc.GetContextExecutor())
+	return err
+}
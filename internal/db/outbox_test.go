@@ -0,0 +1,482 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	configUtil "github.com/loupe-co/go-common/config"
+	"github.com/loupe-co/orchard/internal/config"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+)
+
+var testPostgres struct {
+	once sync.Once
+	db   *DB
+	err  error
+}
+
+// testStores returns the stores the db tests run against, postgres is the one the handler tests use
+// and is left out with ORCHARD_TEST_DB=memory
+func testStores() ([]string, map[string]Store, error) {
+	if os.Getenv("ORCHARD_TEST_DB") == "memory" {
+		return []string{"memory"}, map[string]Store{"memory": NewMemoryStore()}, nil
+	}
+
+	testPostgres.once.Do(func() {
+		cfg := config.Config{}
+		err := configUtil.Load(
+			&cfg,
+			configUtil.FromENV(),
+			configUtil.SetDefaultENV("DB_HOST", "localhost"),
+			configUtil.SetDefaultENV("DB_PASSWORD", "jLariybb1oe5FbDz"),
+			configUtil.SetDefaultENV("DB_MAX_CONNECTIONS", "10"),
+			configUtil.SetDefaultENV("DB_DEBUG", "false"),
+		)
+		if err != nil {
+			testPostgres.err = err
+			return
+		}
+		testPostgres.db, testPostgres.err = New(cfg)
+		if testPostgres.err != nil {
+			return
+		}
+		_, testPostgres.err = testPostgres.db.Migrate(context.Background())
+	})
+	if testPostgres.err != nil {
+		return nil, nil, testPostgres.err
+	}
+	return []string{"memory", "postgres"}, map[string]Store{"memory": NewMemoryStore(), "postgres": testPostgres.db}, nil
+}
+
+func runStoreTest(t *testing.T, test func(t *testing.T, store Store)) {
+	names, stores, err := testStores()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	for _, name := range names {
+		store := stores[name]
+		t.Run(name, func(t *testing.T) { test(t, store) })
+	}
+}
+
+// newTestOutboxTenant gives each test a tenant of its own so dedup keys never collide with other rows in postgres,
+// rows the test commits are deleted once it's done
+func newTestOutboxTenant(t *testing.T, store Store) string {
+	tenantID := MakeID()
+	if pg, ok := store.(*DB); ok {
+		t.Cleanup(func() {
+			if _, err := pg.db.ExecContext(context.Background(), `DELETE FROM outbox WHERE tenant_id = $1;`, tenantID); err != nil {
+				t.Log("error cleaning up outbox rows:", err)
+			}
+		})
+	}
+	return tenantID
+}
+
+// newTestOutboxService returns an outbox service in a transaction of its own that's rolled back when the test is done,
+// claims on postgres can take other tests' due rows and those must not be left processing
+func newTestOutboxService(t *testing.T, store Store) (OutboxRepository, error) {
+	tx, err := store.NewTransaction(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	svc := store.NewOutboxService()
+	svc.SetTransaction(tx)
+	t.Cleanup(func() { svc.Rollback() })
+	return svc, nil
+}
+
+// getTestOutboxMessage reads a message whatever its status, through svc's transaction if it has one
+func getTestOutboxMessage(ctx context.Context, svc OutboxRepository, id string) (*OutboxMessage, error) {
+	switch s := svc.(type) {
+	case *MemoryOutboxService:
+		var res *OutboxMessage
+		err := s.read(func(state *memoryState) error {
+			msg, ok := state.outbox[id]
+			if !ok {
+				return sql.ErrNoRows
+			}
+			res = copyOutboxMessage(msg)
+			return nil
+		})
+		return res, err
+	case *OutboxService:
+		res := &OutboxMessage{}
+		if err := queries.Raw(`SELECT * FROM outbox WHERE id = $1;`, id).Bind(ctx, s.GetContextExecutor(), res); err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("unknown outbox service %T", svc)
+}
+
+// expireTestOutboxMessage moves a message's next attempt back to the epoch, which runs out its lease
+// and puts it ahead of every other due row in postgres
+func expireTestOutboxMessage(ctx context.Context, svc OutboxRepository, id string) error {
+	epoch := time.Unix(0, 0).UTC()
+	switch s := svc.(type) {
+	case *MemoryOutboxService:
+		return s.write(func(state *memoryState) error {
+			if msg, ok := state.outbox[id]; ok {
+				msg.NextAttemptAt = epoch
+			}
+			return nil
+		})
+	case *OutboxService:
+		_, err := queries.Raw(`UPDATE outbox SET next_attempt_at = $2 WHERE id = $1;`, id, epoch).ExecContext(ctx, s.GetContextExecutor())
+		return err
+	}
+	return fmt.Errorf("unknown outbox service %T", svc)
+}
+
+func findOutboxMessage(msgs []*OutboxMessage, id string) *OutboxMessage {
+	for _, msg := range msgs {
+		if msg.ID == id {
+			return msg
+		}
+	}
+	return nil
+}
+
+func TestOutboxEnqueueDedup(t *testing.T) {
+	runStoreTest(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		tenantID := newTestOutboxTenant(t, store)
+		svc, err := newTestOutboxService(t, store)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+
+		personID := MakeID()
+		first := NewBustAuthCacheMessage(tenantID, personID)
+		dup := NewBustAuthCacheMessage(tenantID, personID)
+		oldEmail := NewProvisionUserMessage(tenantID, personID, "old@canopy.io")
+		newEmail := NewProvisionUserMessage(tenantID, personID, "New@canopy.io")
+		if err := svc.Enqueue(ctx, first, dup, oldEmail, newEmail); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+
+		if _, err := getTestOutboxMessage(ctx, svc, dup.ID); err != sql.ErrNoRows {
+			t.Log("expected a message with the same dedup key as a pending one to be dropped, but got", err)
+			t.Fail()
+			return
+		}
+		for _, msg := range []*OutboxMessage{first, oldEmail, newEmail} {
+			saved, err := getTestOutboxMessage(ctx, svc, msg.ID)
+			if err != nil {
+				t.Logf("expected %s to be enqueued, but got %s", msg.DedupKey, err)
+				t.Fail()
+				return
+			}
+			if saved.Status != OutboxStatusPending || saved.Attempts != 0 {
+				t.Logf("expected %s to be pending with no attempts, but got %s with %d", msg.DedupKey, saved.Status, saved.Attempts)
+				t.Fail()
+				return
+			}
+		}
+
+		// the unique index is partial, only pending messages dedup
+		if err := svc.MarkDelivered(ctx, first.ID); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		again := NewBustAuthCacheMessage(tenantID, personID)
+		if err := svc.Enqueue(ctx, again); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if _, err := getTestOutboxMessage(ctx, svc, again.ID); err != nil {
+			t.Log("expected a message to be enqueued once the pending one with its dedup key was delivered, but got", err)
+			t.Fail()
+			return
+		}
+	})
+}
+
+func TestOutboxClaimLease(t *testing.T) {
+	runStoreTest(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		tenantID := newTestOutboxTenant(t, store)
+		svc, err := newTestOutboxService(t, store)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+
+		leased := NewBustAuthCacheMessage(tenantID, MakeID())
+		if err := svc.Enqueue(ctx, leased); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		for attempt := 1; attempt <= 2; attempt++ {
+			// the first time round puts it ahead of every other due row, the second time it's the lease running out
+			if err := expireTestOutboxMessage(ctx, svc, leased.ID); err != nil {
+				t.Log(err)
+				t.Fail()
+				return
+			}
+			claimed, err := svc.Claim(ctx, 1, time.Hour)
+			if err != nil {
+				t.Log(err)
+				t.Fail()
+				return
+			}
+			if len(claimed) != 1 || claimed[0].ID != leased.ID || claimed[0].Status != OutboxStatusProcessing || claimed[0].Attempts != attempt {
+				t.Logf("expected the due message to be claimed as processing for attempt %d, but got %v", attempt, claimed)
+				t.Fail()
+				return
+			}
+
+			claimed, err = svc.Claim(ctx, 1000, time.Hour)
+			if err != nil {
+				t.Log(err)
+				t.Fail()
+				return
+			}
+			if findOutboxMessage(claimed, leased.ID) != nil {
+				t.Log("expected a message to not be claimed again while its lease is running")
+				t.Fail()
+				return
+			}
+		}
+	})
+}
+
+// TestOutboxClaimSkipLocked needs two connections, the memory store serializes its transactions so it only runs on postgres
+func TestOutboxClaimSkipLocked(t *testing.T) {
+	runStoreTest(t, func(t *testing.T, store Store) {
+		pg, ok := store.(*DB)
+		if !ok {
+			t.Skip("skip locked only applies to postgres")
+		}
+		ctx := context.Background()
+		tenantID := newTestOutboxTenant(t, store)
+
+		msgs := []*OutboxMessage{}
+		for i := 0; i < 3; i++ {
+			msgs = append(msgs, NewBustAuthCacheMessage(tenantID, MakeID()))
+		}
+		if err := store.NewOutboxService().Enqueue(ctx, msgs...); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		// claims take the oldest due messages first, backdating these puts them ahead of anything else in the table
+		for i, msg := range msgs {
+			backdated := time.Date(1970, 1, 1, 0, i, 0, 0, time.UTC)
+			if _, err := pg.db.ExecContext(ctx, `UPDATE outbox SET next_attempt_at = $2 WHERE id = $1;`, msg.ID, backdated); err != nil {
+				t.Log(err)
+				t.Fail()
+				return
+			}
+		}
+
+		first, err := newTestOutboxService(t, store)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		second, err := newTestOutboxService(t, store)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+
+		firstClaim, err := first.Claim(ctx, 2, time.Hour)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		// RETURNING doesn't keep the subquery's order
+		if len(firstClaim) != 2 || findOutboxMessage(firstClaim, msgs[0].ID) == nil || findOutboxMessage(firstClaim, msgs[1].ID) == nil {
+			t.Log("expected the first claim to take the two oldest messages, but got", firstClaim)
+			t.Fail()
+			return
+		}
+
+		// the first claim hasn't committed, its rows are still locked and skipped rather than waited on
+		secondClaim, err := second.Claim(ctx, 1, time.Hour)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if len(secondClaim) != 1 || secondClaim[0].ID != msgs[2].ID {
+			t.Log("expected the second claim to skip the locked messages and take the third, but got", secondClaim)
+			t.Fail()
+			return
+		}
+	})
+}
+
+func TestOutboxMarkFailedAndDeadLetter(t *testing.T) {
+	runStoreTest(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		tenantID := newTestOutboxTenant(t, store)
+		// no transaction, a unique violation aborts a postgres transaction and MarkFailed recovers from it with a second statement
+		svc := store.NewOutboxService()
+
+		personID := MakeID()
+		msg := NewBustAuthCacheMessage(tenantID, personID)
+		if err := svc.Enqueue(ctx, msg); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+
+		// postgres keeps microseconds
+		retryAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+		if err := svc.MarkFailed(ctx, msg.ID, "timeout", retryAt, false); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		saved, err := getTestOutboxMessage(ctx, svc, msg.ID)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if saved.Status != OutboxStatusPending || saved.LastError.String != "timeout" || !saved.NextAttemptAt.Equal(retryAt) {
+			t.Logf("expected a retry to be pending until %s with its error, but got %s until %s with %q", retryAt, saved.Status, saved.NextAttemptAt, saved.LastError.String)
+			t.Fail()
+			return
+		}
+
+		if err := svc.MarkFailed(ctx, msg.ID, "boom", retryAt, true); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		dead, err := svc.GetDeadLetters(ctx, tenantID, 10, 0)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if len(dead) != 1 || dead[0].ID != msg.ID || dead[0].LastError.String != "boom" {
+			t.Log("expected the dead letter view to list the dead message with its last error, but got", dead)
+			t.Fail()
+			return
+		}
+
+		// a dead message doesn't block new ones, and isn't requeued next to the newer pending one
+		newer := NewBustAuthCacheMessage(tenantID, personID)
+		if err := svc.Enqueue(ctx, newer); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if _, err := getTestOutboxMessage(ctx, svc, newer.ID); err != nil {
+			t.Log("expected a message to be enqueued next to a dead one with the same dedup key, but got", err)
+			t.Fail()
+			return
+		}
+		if err := svc.Requeue(ctx, msg.ID); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if saved, err := getTestOutboxMessage(ctx, svc, msg.ID); err != nil || saved.Status != OutboxStatusDead {
+			t.Log("expected requeue to leave the message dead while a newer one is pending, but got", saved, err)
+			t.Fail()
+			return
+		}
+
+		if err := svc.MarkDelivered(ctx, newer.ID); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if err := svc.Requeue(ctx, msg.ID); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		saved, err = getTestOutboxMessage(ctx, svc, msg.ID)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if saved.Status != OutboxStatusPending || saved.Attempts != 0 || saved.LastError.Valid {
+			t.Log("expected a requeued message to be pending with its attempts and error cleared, but got", saved)
+			t.Fail()
+			return
+		}
+		if dead, err := svc.GetDeadLetters(ctx, tenantID, 10, 0); err != nil || len(dead) != 0 {
+			t.Log("expected the dead letter view to be empty after requeue, but got", dead, err)
+			t.Fail()
+			return
+		}
+	})
+}
+
+func TestOutboxMarkFailedSuperseded(t *testing.T) {
+	runStoreTest(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		tenantID := newTestOutboxTenant(t, store)
+		svc := store.NewOutboxService()
+
+		personID := MakeID()
+		msg := NewProvisionUserMessage(tenantID, personID, "pat@canopy.io")
+		if err := svc.Enqueue(ctx, msg); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		// takes msg out of pending the way a claim would, without claiming other tests' rows
+		if err := svc.MarkFailed(ctx, msg.ID, "boom", time.Now().UTC(), true); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		newer := NewProvisionUserMessage(tenantID, personID, "pat@canopy.io")
+		if err := svc.Enqueue(ctx, newer); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+
+		// retrying msg would make two pending messages with one dedup key, the newer one covers it instead
+		if err := svc.MarkFailed(ctx, msg.ID, "timeout", time.Now().UTC().Add(time.Minute), false); err != nil {
+			t.Log("expected a superseded retry to not fail on the unique index, but got", err)
+			t.Fail()
+			return
+		}
+		saved, err := getTestOutboxMessage(ctx, svc, msg.ID)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if saved.Status != OutboxStatusDelivered || !saved.DeliveredAt.Valid {
+			t.Log("expected the superseded message to be marked delivered, but got", saved.Status)
+			t.Fail()
+			return
+		}
+		if saved, err := getTestOutboxMessage(ctx, svc, newer.ID); err != nil || saved.Status != OutboxStatusPending {
+			t.Log("expected the newer message to stay pending, but got", saved, err)
+			t.Fail()
+			return
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/loupe-co/orchard/internal/models"
+	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// PageTimeFormat is how timestamps are kept as sort values, fixed width so they compare as strings
+const PageTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// Page selects a page of a sorted search. With AfterID set it is a keyset page of the rows after the row with that id and
+// sort value, otherwise it starts at Offset. Rows are ordered by SortBy with id as the tiebreak, both in the same direction.
+type Page struct {
+	SortBy     string
+	Desc       bool
+	Limit      int
+	Offset     int
+	AfterValue string
+	AfterID    string
+	// CountTotal also counts every matching row, it's a second query so it's skipped unless asked for
+	CountTotal bool
+}
+
+// Text sort columns are compared byte by byte with COLLATE "C" like the memory store does, rather than in the database's
+// collation, so a page token's value compares the same way in both. typeSortColumn also sorts the type enum by its text
+// rather than its declaration order.
+const typeSortColumn = `"type"::text COLLATE "C"`
+
+// PersonSortColumns are the columns people can be sorted by, null text sorts as an empty string so keyset comparisons hold
+var PersonSortColumns = map[string]string{
+	"name":       `COALESCE(name, '') COLLATE "C"`,
+	"email":      `COALESCE(email, '') COLLATE "C"`,
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+	"type":       typeSortColumn,
+}
+
+// GroupSortColumns are the columns groups can be sorted by
+var GroupSortColumns = map[string]string{
+	"name":       `"name" COLLATE "C"`,
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+	"type":       typeSortColumn,
+}
+
+// PersonSortValue is the value of p's sort column as it is kept in a page token
+func PersonSortValue(p *models.Person, sortBy string) string {
+	switch sortBy {
+	case "email":
+		return p.Email.String
+	case "created_at":
+		return p.CreatedAt.UTC().Format(PageTimeFormat)
+	case "updated_at":
+		return p.UpdatedAt.UTC().Format(PageTimeFormat)
+	case "type":
+		return p.Type
+	default:
+		return p.Name.String
+	}
+}
+
+// GroupSortValue is the value of g's sort column as it is kept in a page token
+func GroupSortValue(g *models.Group, sortBy string) string {
+	switch sortBy {
+	case "created_at":
+		return g.CreatedAt.UTC().Format(PageTimeFormat)
+	case "updated_at":
+		return g.UpdatedAt.UTC().Format(PageTimeFormat)
+	case "type":
+		return g.Type
+	default:
+		return g.Name
+	}
+}
+
+// pageQueryMods adds the keyset condition, order and limit of page to a query whose next free param is paramIdx
+func pageQueryMods(page Page, column string, paramIdx int) []qm.QueryMod {
+	dir, cmp := "ASC", ">"
+	if page.Desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	queryParts := []qm.QueryMod{}
+	if page.AfterID != "" {
+		queryParts = append(queryParts, qm.And(fmt.Sprintf("(%s, id) %s ($%d, $%d)", column, cmp, paramIdx, paramIdx+1), page.AfterValue, page.AfterID))
+	}
+	queryParts = append(queryParts, qm.OrderBy(fmt.Sprintf("%s %s, id %s", column, dir, dir)), qm.Limit(page.Limit))
+	if page.AfterID == "" && page.Offset > 0 {
+		queryParts = append(queryParts, qm.Offset(page.Offset))
+	}
+	return queryParts
+}
+
+// memoryPage sorts rows and applies page to them like pageQueryMods does in sql
+func memoryPage[T any](rows []T, page Page, sortValue func(T, string) string, id func(T) string) []T {
+	less := func(aValue, aID, bValue, bID string) bool {
+		if aValue != bValue {
+			return aValue < bValue
+		}
+		return aID < bID
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if page.Desc {
+			return less(sortValue(b, page.SortBy), id(b), sortValue(a, page.SortBy), id(a))
+		}
+		return less(sortValue(a, page.SortBy), id(a), sortValue(b, page.SortBy), id(b))
+	})
+
+	if page.AfterID == "" {
+		return pageRows(rows, page.Limit, page.Offset)
+	}
+	after := []T{}
+	for _, row := range rows {
+		value, rowID := sortValue(row, page.SortBy), id(row)
+		if page.Desc && less(value, rowID, page.AfterValue, page.AfterID) || !page.Desc && less(page.AfterValue, page.AfterID, value, rowID) {
+			after = append(after, row)
+		}
+	}
+	return pageRows(after, page.Limit, 0)
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/loupe-co/orchard/internal/models"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+func TestPersonSearchPageSortsBytewise(t *testing.T) {
+	runStoreTest(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		tenantID := MakeID()
+
+		tx, err := store.NewTransaction(ctx)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		svc := store.NewPersonService()
+		svc.SetTransaction(tx)
+		defer svc.Rollback()
+
+		// a locale collation would interleave the cases and put _zed and Émile elsewhere
+		names := []null.String{null.StringFrom("alice"), null.StringFrom("Bob"), null.StringFrom("bob"), null.StringFrom("_zed"), null.StringFrom("Émile"), null.String{}}
+		for _, name := range names {
+			p := &models.Person{ID: MakeID(), TenantID: tenantID, Name: name, RoleIds: types.StringArray{}, CRMRoleIds: types.StringArray{}, Status: "active", CreatedBy: DefaultTenantID, UpdatedBy: DefaultTenantID}
+			if err := svc.Insert(ctx, p); err != nil {
+				t.Log(err)
+				t.Fail()
+				return
+			}
+		}
+
+		people, _, err := svc.SearchPage(ctx, tenantID, "", Page{SortBy: "name", Limit: 10})
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		sorted := make([]string, len(people))
+		for i, p := range people {
+			sorted[i] = p.Name.String
+		}
+		if strings.Join(sorted, ",") != ",Bob,_zed,alice,bob,Émile" {
+			t.Log("expected names sorted byte by byte with null first, but got", sorted)
+			t.Fail()
+			return
+		}
+
+		// a keyset page resumes after the token's row whichever store made it
+		after, _, err := svc.SearchPage(ctx, tenantID, "", Page{SortBy: "name", Limit: 2, AfterValue: people[2].Name.String, AfterID: people[2].ID})
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if len(after) != 2 || after[0].ID != people[3].ID || after[1].ID != people[4].ID {
+			t.Log("expected the page after _zed to be alice and bob, but got", after)
+			t.Fail()
+			return
+		}
+	})
+}
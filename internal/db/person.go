@@ -7,6 +7,8 @@ import (
 	"strings"
 	"time"
 
+	_ "embed"
+
 	"github.com/loupe-co/go-common/errors"
 	"github.com/loupe-co/go-loupe-logger/log"
 	"github.com/loupe-co/orchard/internal/models"
@@ -23,7 +25,7 @@ type PersonService struct {
 	*DBService
 }
 
-func (db *DB) NewPersonService() *PersonService {
+func (db *DB) NewPersonService() PersonRepository {
 	return &PersonService{
 		DBService: db.NewDBService(),
 	}
@@ -55,6 +57,7 @@ func (svc *PersonService) FromProto(p *orchardPb.Person) *models.Person {
 		UpdatedAt:     updatedAt,
 		UpdatedBy:     p.UpdatedBy,
 		Type:          p.Type,
+		RoleIdsLocked: p.RoleIdsLocked,
 	}
 }
 
@@ -91,13 +94,15 @@ func (svc *PersonService) ToProto(p *models.Person) (*orchardPb.Person, error) {
 		Type:            p.Type,
 		OutreachId:      p.OutreachID.String,
 		IsOutreachAdmin: p.OutreachIsAdmin.Bool,
+		RoleIdsLocked:   p.RoleIdsLocked,
+		IsPlayerCoach:   p.Type == models.PersonTypePlayerCoach,
 	}, nil
 }
 
 var (
 	personInsertWhitelist = []string{
 		"id", "tenant_id", "name", "first_name", "last_name", "email",
-		"photo_url", "manager_id", "group_id", "role_ids", "crm_role_ids",
+		"photo_url", "manager_id", "group_id", "role_ids", "crm_role_ids", "role_ids_locked",
 		"is_provisioned", "is_synced", "status",
 		"created_at", "created_by", "updated_at", "updated_by",
 	}
@@ -114,28 +119,33 @@ const (
 	{SUBS}
 ON CONFLICT (tenant_id, id) DO
 	UPDATE SET
-	name = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000001' OR COALESCE(person.outreach_guid, '') <> '' THEN person.name ELSE EXCLUDED.name END,
-	first_name = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000001' OR COALESCE(person.outreach_guid, '') <> '' THEN person.first_name ELSE EXCLUDED.first_name END,
-	last_name = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000001' OR COALESCE(person.outreach_guid, '') <> '' THEN person.last_name ELSE EXCLUDED.last_name END,
-	email = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000001' OR COALESCE(person.outreach_guid, '') <> '' THEN person.email ELSE EXCLUDED.email END,
-	photo_url = EXCLUDED.photo_url, group_id = EXCLUDED.group_id,
-	role_ids = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000001' THEN person.role_ids ELSE EXCLUDED.role_ids END,
-	crm_role_ids = EXCLUDED.crm_role_ids, is_provisioned = EXCLUDED.is_provisioned,
-	is_synced = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000001' THEN person.is_synced ELSE EXCLUDED.is_synced END,
-	status = EXCLUDED.status, updated_at = EXCLUDED.updated_at, updated_by = EXCLUDED.updated_by;`
+	name = CASE WHEN person.created_by IN ('00000000-0000-0000-0000-000000000001', '00000000-0000-0000-0000-000000000002') OR COALESCE(person.outreach_guid, '') <> '' THEN person.name ELSE EXCLUDED.name END,
+	first_name = CASE WHEN person.created_by IN ('00000000-0000-0000-0000-000000000001', '00000000-0000-0000-0000-000000000002') OR COALESCE(person.outreach_guid, '') <> '' THEN person.first_name ELSE EXCLUDED.first_name END,
+	last_name = CASE WHEN person.created_by IN ('00000000-0000-0000-0000-000000000001', '00000000-0000-0000-0000-000000000002') OR COALESCE(person.outreach_guid, '') <> '' THEN person.last_name ELSE EXCLUDED.last_name END,
+	email = CASE WHEN person.created_by IN ('00000000-0000-0000-0000-000000000001', '00000000-0000-0000-0000-000000000002') OR COALESCE(person.outreach_guid, '') <> '' THEN person.email ELSE EXCLUDED.email END,
+	photo_url = EXCLUDED.photo_url,
+	group_id = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000002' THEN person.group_id ELSE EXCLUDED.group_id END,
+	role_ids = CASE WHEN person.created_by IN ('00000000-0000-0000-0000-000000000001', '00000000-0000-0000-0000-000000000002') OR person.role_ids_locked THEN person.role_ids ELSE EXCLUDED.role_ids END,
+	crm_role_ids = EXCLUDED.crm_role_ids,
+	is_provisioned = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000002' THEN person.is_provisioned ELSE EXCLUDED.is_provisioned END,
+	is_synced = CASE WHEN person.created_by IN ('00000000-0000-0000-0000-000000000001', '00000000-0000-0000-0000-000000000002') THEN person.is_synced ELSE EXCLUDED.is_synced END,
+	status = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000002' THEN person.status ELSE EXCLUDED.status END,
+	updated_at = EXCLUDED.updated_at, updated_by = EXCLUDED.updated_by;`
 
 	personUpsertAllQueryNew = `INSERT INTO person (id, tenant_id, "name", first_name, last_name, email, photo_url, group_id, role_ids, crm_role_ids, is_provisioned, is_synced, status, created_at, created_by, updated_at, updated_by) VALUES
 	{SUBS}
 ON CONFLICT (tenant_id, id) DO
 	UPDATE SET
-	name = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000001' OR COALESCE(person.outreach_guid, '') <> '' THEN person.name ELSE EXCLUDED.name END,
-	first_name = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000001' OR COALESCE(person.outreach_guid, '') <> '' THEN person.first_name ELSE EXCLUDED.first_name END,
-	last_name = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000001' OR COALESCE(person.outreach_guid, '') <> '' THEN person.last_name ELSE EXCLUDED.last_name END,
-	email = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000001' OR COALESCE(person.outreach_guid, '') <> '' THEN person.email ELSE EXCLUDED.email END,
-	photo_url = EXCLUDED.photo_url, group_id = EXCLUDED.group_id,
-	role_ids = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000001' THEN person.role_ids ELSE EXCLUDED.role_ids END,
-	crm_role_ids = EXCLUDED.crm_role_ids, is_provisioned = EXCLUDED.is_provisioned,
-	is_synced = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000001' THEN person.is_synced ELSE EXCLUDED.is_synced END,
+	name = CASE WHEN person.created_by IN ('00000000-0000-0000-0000-000000000001', '00000000-0000-0000-0000-000000000002') OR COALESCE(person.outreach_guid, '') <> '' THEN person.name ELSE EXCLUDED.name END,
+	first_name = CASE WHEN person.created_by IN ('00000000-0000-0000-0000-000000000001', '00000000-0000-0000-0000-000000000002') OR COALESCE(person.outreach_guid, '') <> '' THEN person.first_name ELSE EXCLUDED.first_name END,
+	last_name = CASE WHEN person.created_by IN ('00000000-0000-0000-0000-000000000001', '00000000-0000-0000-0000-000000000002') OR COALESCE(person.outreach_guid, '') <> '' THEN person.last_name ELSE EXCLUDED.last_name END,
+	email = CASE WHEN person.created_by IN ('00000000-0000-0000-0000-000000000001', '00000000-0000-0000-0000-000000000002') OR COALESCE(person.outreach_guid, '') <> '' THEN person.email ELSE EXCLUDED.email END,
+	photo_url = EXCLUDED.photo_url,
+	group_id = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000002' THEN person.group_id ELSE EXCLUDED.group_id END,
+	role_ids = CASE WHEN person.created_by IN ('00000000-0000-0000-0000-000000000001', '00000000-0000-0000-0000-000000000002') OR person.role_ids_locked THEN person.role_ids ELSE EXCLUDED.role_ids END,
+	crm_role_ids = EXCLUDED.crm_role_ids,
+	is_provisioned = CASE WHEN person.created_by = '00000000-0000-0000-0000-000000000002' THEN person.is_provisioned ELSE EXCLUDED.is_provisioned END,
+	is_synced = CASE WHEN person.created_by IN ('00000000-0000-0000-0000-000000000001', '00000000-0000-0000-0000-000000000002') THEN person.is_synced ELSE EXCLUDED.is_synced END,
 	updated_at = EXCLUDED.updated_at, updated_by = EXCLUDED.updated_by;`
 )
 
@@ -302,15 +312,8 @@ func (svc *PersonService) GetAllByEmailForProvisioning(ctx context.Context, emai
 	return people, nil
 }
 
-type PersonFilter struct {
-	Field  string
-	Op     string
-	Values []interface{}
-}
-
-func (svc *PersonService) Search(ctx context.Context, tenantID, query string, limit, offset int, filters ...PersonFilter) ([]*models.Person, int64, error) {
-	spanCtx, span := log.StartSpan(ctx, "Person.Search")
-	defer span.End()
+// searchQueryMods builds the where clauses shared by Search and SearchAfter, it returns the next free param index
+func searchQueryMods(tenantID, query string, filters []PersonFilter) ([]qm.QueryMod, int, error) {
 	queryParts := []qm.QueryMod{}
 	queryParts = append(queryParts, qm.Where("tenant_id=$1", tenantID))
 	paramIdx := 2
@@ -320,32 +323,19 @@ func (svc *PersonService) Search(ctx context.Context, tenantID, query string, li
 		queryParts = append(queryParts, qm.And(searchClause, "%"+strings.ToLower(query)+"%"))
 	}
 
-	for _, filter := range filters {
-		switch filter.Op {
-		case "EQ":
-			queryParts = append(queryParts, qm.And(fmt.Sprintf("%s = $%d", filter.Field, paramIdx), filter.Values...))
-		case "NEQ":
-			queryParts = append(queryParts, qm.And(fmt.Sprintf("%s <> $%d", filter.Field, paramIdx), filter.Values...))
-		case "IN":
-			queryParts = append(queryParts, qm.AndIn(fmt.Sprintf("%s IN ?", filter.Field), filter.Values...))
-			paramIdx += len(filters) - 1
-		case "NIN":
-			queryParts = append(queryParts, qm.AndNotIn(fmt.Sprintf("%s NOT IN ?", filter.Field), filter.Values...))
-			paramIdx += len(filters) - 1
-		case "GT":
-			queryParts = append(queryParts, qm.And(fmt.Sprintf("%s > $%d", filter.Field, paramIdx), filter.Values...))
-		case "GTE":
-			queryParts = append(queryParts, qm.And(fmt.Sprintf("%s >= $%d", filter.Field, paramIdx), filter.Values...))
-		case "LT":
-			queryParts = append(queryParts, qm.And(fmt.Sprintf("%s < $%d", filter.Field, paramIdx), filter.Values...))
-		case "LTE":
-			queryParts = append(queryParts, qm.And(fmt.Sprintf("%s <= $%d", filter.Field, paramIdx), filter.Values...))
-		case "EQANY":
-			queryParts = append(queryParts, qm.And(fmt.Sprintf("$%d = ANY (%s)", paramIdx, filter.Field), filter.Values...))
-		default:
-			queryParts = append(queryParts, qm.And(fmt.Sprintf("%s = $%d", filter.Field, paramIdx), filter.Values...))
-		}
-		paramIdx++
+	filterParts, paramIdx, err := filterQueryMods(filters, PersonFilterColumns, paramIdx)
+	if err != nil {
+		return nil, 0, err
+	}
+	return append(queryParts, filterParts...), paramIdx, nil
+}
+
+func (svc *PersonService) Search(ctx context.Context, tenantID, query string, limit, offset int, filters ...PersonFilter) ([]*models.Person, int64, error) {
+	spanCtx, span := log.StartSpan(ctx, "Person.Search")
+	defer span.End()
+	queryParts, _, err := searchQueryMods(tenantID, query, filters)
+	if err != nil {
+		return nil, 0, err
 	}
 
 	total, err := models.People(queryParts...).Count(spanCtx, svc.GetContextExecutor())
@@ -363,6 +353,57 @@ func (svc *PersonService) Search(ctx context.Context, tenantID, query string, li
 	return people, total, nil
 }
 
+// SearchPage is Search with a sortable, keyset paginated page. The total is only counted when page.CountTotal is set.
+func (svc *PersonService) SearchPage(ctx context.Context, tenantID, query string, page Page, filters ...PersonFilter) ([]*models.Person, int64, error) {
+	spanCtx, span := log.StartSpan(ctx, "Person.SearchPage")
+	defer span.End()
+	queryParts, paramIdx, err := searchQueryMods(tenantID, query, filters)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := int64(0)
+	if page.CountTotal {
+		count, err := models.People(queryParts...).Count(spanCtx, svc.GetContextExecutor())
+		if err != nil {
+			return nil, 0, err
+		}
+		total = count
+	}
+
+	column, ok := PersonSortColumns[page.SortBy]
+	if !ok {
+		return nil, total, fmt.Errorf("can't sort people by %q", page.SortBy)
+	}
+	queryParts = append(queryParts, pageQueryMods(page, column, paramIdx)...)
+
+	people, err := models.People(queryParts...).All(spanCtx, svc.GetContextExecutor())
+	if err != nil && err != sql.ErrNoRows {
+		return nil, total, err
+	}
+	return people, total, nil
+}
+
+// SearchAfter is Search ordered by id for keyset pagination, it returns up to limit people with an id after afterID
+func (svc *PersonService) SearchAfter(ctx context.Context, tenantID, query, afterID string, limit int, filters ...PersonFilter) ([]*models.Person, error) {
+	spanCtx, span := log.StartSpan(ctx, "Person.SearchAfter")
+	defer span.End()
+	queryParts, paramIdx, err := searchQueryMods(tenantID, query, filters)
+	if err != nil {
+		return nil, err
+	}
+	if afterID != "" {
+		queryParts = append(queryParts, qm.And(fmt.Sprintf("id > $%d", paramIdx), afterID))
+	}
+	queryParts = append(queryParts, qm.OrderBy("id"), qm.Limit(limit))
+
+	people, err := models.People(queryParts...).All(spanCtx, svc.GetContextExecutor())
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return people, nil
+}
+
 func (svc *PersonService) GetPeopleByGroupId(ctx context.Context, tenantID, groupID string) ([]*models.Person, error) {
 	spanCtx, span := log.StartSpan(ctx, "Person.GetPeopleByGroupId")
 	defer span.End()
@@ -373,11 +414,23 @@ func (svc *PersonService) GetPeopleByGroupId(ctx context.Context, tenantID, grou
 	return people, nil
 }
 
+// GetAllByTenant returns every person of a tenant whatever their status, ordered by id
+func (svc *PersonService) GetAllByTenant(ctx context.Context, tenantID string) ([]*models.Person, error) {
+	spanCtx, span := log.StartSpan(ctx, "Person.GetAllByTenant")
+	defer span.End()
+	people, err := models.People(qm.Where("tenant_id = $1", tenantID), qm.OrderBy("id")).All(spanCtx, svc.GetContextExecutor())
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return people, nil
+}
+
+// GetPeopleByRoleId returns the people holding a system role, an empty tenantID looks in every tenant for roles of the default tenant
 func (svc *PersonService) GetPeopleByRoleId(ctx context.Context, tenantID, roleID string, limit, offset int) ([]*models.Person, error) {
 	spanCtx, span := log.StartSpan(ctx, "Person.GetPeopleByRoleId")
 	defer span.End()
 	people, err := models.People(
-		qm.Where("tenant_id = $1 AND $2 = ANY (role_ids)", tenantID, roleID),
+		qm.Where("($1 = '' OR tenant_id = $1) AND $2 = ANY (role_ids)", tenantID, roleID),
 		qm.Limit(limit),
 		qm.Offset(offset),
 		qm.OrderBy("last_name, first_name DESC"),
@@ -388,11 +441,12 @@ func (svc *PersonService) GetPeopleByRoleId(ctx context.Context, tenantID, roleI
 	return people, nil
 }
 
+// CountPeopleByRoleId counts the people holding a system role, an empty tenantID counts every tenant
 func (svc *PersonService) CountPeopleByRoleId(ctx context.Context, tenantID, roleID string) (int64, error) {
 	spanCtx, span := log.StartSpan(ctx, "Person.GetPeopleByRoleId")
 	defer span.End()
 	numPeople, err := models.People(
-		qm.Where("tenant_id = $1 AND $2 = ANY (role_ids)", tenantID, roleID),
+		qm.Where("($1 = '' OR tenant_id = $1) AND $2 = ANY (role_ids)", tenantID, roleID),
 	).Count(spanCtx, svc.GetContextExecutor())
 	if err != nil && err != sql.ErrNoRows {
 		return 0, err
@@ -400,6 +454,29 @@ func (svc *PersonService) CountPeopleByRoleId(ctx context.Context, tenantID, rol
 	return numPeople, nil
 }
 
+const (
+	// people who already hold the replacement just lose the role, so nobody ends up with the same role twice
+	replacePersonRoleIDQuery = `UPDATE person SET
+		role_ids = CASE WHEN $3 = ANY (role_ids) THEN ARRAY_REMOVE(role_ids, $2) ELSE ARRAY_REPLACE(role_ids, $2, $3) END,
+		updated_by = $4,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE ($1 = '' OR tenant_id = $1) AND $2 = ANY (role_ids)
+	RETURNING *;`
+)
+
+// ReplaceRoleID gives everyone holding roleID replacementID instead, an empty tenantID replaces it in every tenant. It returns the
+// people that changed.
+func (svc *PersonService) ReplaceRoleID(ctx context.Context, tenantID, roleID, replacementID, userID string) ([]*models.Person, error) {
+	spanCtx, span := log.StartSpan(ctx, "Person.ReplaceRoleID")
+	defer span.End()
+	people := []*models.Person{}
+	err := queries.Raw(replacePersonRoleIDQuery, tenantID, roleID, replacementID, userID).Bind(spanCtx, svc.GetContextExecutor(), &people)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return people, nil
+}
+
 func (svc *PersonService) GetVirtualUsers(ctx context.Context, tenantID string, since *timestamppb.Timestamp) ([]*models.Person, error) {
 	spanCtx, span := log.StartSpan(ctx, "Person.GetVirtualUsers")
 	defer span.End()
@@ -432,7 +509,7 @@ var (
 	defaultPersonUpdateWhitelist = []string{
 		"name", "first_name", "last_name", "email", "photo_url",
 		"manager_id", "group_id", "role_ids", "crm_role_ids",
-		"is_provisioned", "is_synced", "status", "updated_at", "updated_by",
+		"is_provisioned", "is_synced", "status", "role_ids_locked", "updated_at", "updated_by",
 	}
 )
 
@@ -618,3 +695,34 @@ func (svc *PersonService) GetOutreachIdsFromCommitIds(ctx context.Context, tenan
 	}
 	return returnArr, nil
 }
+
+//go:embed queries/cleanupCNCUsers.sql
+var cleanupCNCUsersQuery string
+
+type CNCUserCleanupResult struct {
+	ID     sql.NullString `boil:"id" json:"id"`
+	Action sql.NullString `boil:"action" json:"action"`
+}
+
+// CleanupCNCUsers merges the duplicate person records c&c tenants get when the same user is synced from both the crm and outreach,
+// returning the id of every person it deleted, swapped or unlinked from outreach
+func (svc *PersonService) CleanupCNCUsers(ctx context.Context, tenantID string) ([]*CNCUserCleanupResult, error) {
+	spanCtx, span := log.StartSpan(ctx, "Person.CleanupCNCUsers")
+	defer span.End()
+	result := []*CNCUserCleanupResult{}
+	if err := queries.Raw(cleanupCNCUsersQuery, tenantID).Bind(spanCtx, svc.GetContextExecutor(), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+//go:embed queries/makeUsersHierarchical.sql
+var makeUsersHierarchicalQuery string
+
+// MakeUsersHierarchical re-parents crm roles and re-assigns person crm roles from their outreach ids for c&c tenants
+func (svc *PersonService) MakeUsersHierarchical(ctx context.Context, tenantID string) error {
+	spanCtx, span := log.StartSpan(ctx, "Person.MakeUsersHierarchical")
+	defer span.End()
+	_, err := queries.Raw(makeUsersHierarchicalQuery, tenantID).ExecContext(spanCtx, svc.GetContextExecutor())
+	return err
+}
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/loupe-co/orchard/internal/models"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	tenantPb "github.com/loupe-co/protos/src/common/tenant"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Store is everything the handlers need from the database: transactions and a repository per table.
+// DB implements it against postgres, MemoryStore implements it in memory for tests.
+type Store interface {
+	NewTransaction(ctx context.Context) (*sql.Tx, error)
+	TryLock(ctx context.Context, name string) (unlock func(), ok bool, err error)
+	NewGroupService() GroupRepository
+	NewPersonService() PersonRepository
+	NewCRMRoleService() CRMRoleRepository
+	NewSystemRoleService() SystemRoleRepository
+	NewGroupViewerService() GroupViewerRepository
+	NewTenantService() TenantRepository
+	NewOutboxService() OutboxRepository
+	NewDirectoryService() DirectoryRepository
+	NewAccessReviewService() AccessReviewRepository
+	NewRoleAssignmentRuleService() RoleAssignmentRuleRepository
+	NewSCIMTokenService() SCIMTokenRepository
+}
+
+// Transactional is the transaction handling shared by every repository. A transaction from Store.NewTransaction can be set on
+// several repositories, committing or rolling back any of them finishes it for all of them. Without a transaction every call runs on its own.
+type Transactional interface {
+	GetTransaction() *sql.Tx
+	SetTransaction(tx *sql.Tx)
+	Commit() error
+	Rollback() error
+}
+
+type GroupRepository interface {
+	Transactional
+	FromProto(g *orchardPb.Group) *models.Group
+	ToProto(g *models.Group) (*orchardPb.Group, error)
+	Insert(ctx context.Context, g *models.Group) error
+	GetByID(ctx context.Context, id, tenantID string) (*models.Group, error)
+	CheckDuplicateCRMRoleIDs(ctx context.Context, id, tenantID string, crmRolesIDs []string) (bool, error)
+	Search(ctx context.Context, tenantID, query string, filters ...Filter) ([]*models.Group, error)
+	SearchPage(ctx context.Context, tenantID, query string, page Page, filters ...Filter) ([]*models.Group, int64, error)
+	SearchAfter(ctx context.Context, tenantID, query, afterID string, limit int) ([]*models.Group, error)
+	GetManagerAndParentIDs(ctx context.Context, tenantID, personID string) (managerID, parentID, personType string, err error)
+	GetGroupSubTree(ctx context.Context, tenantID, groupID string, maxDepth int, hydrateUsers bool, simplify bool, activeUsers bool, useManagerNames bool, excludeManagerUsers bool, viewableGroups ...string) ([]*GroupTreeNode, error)
+	GetFullTenantTree(ctx context.Context, tenantID string, hydrateUsers bool) ([]*GroupTreeNode, error)
+	Update(ctx context.Context, g *models.Group, onlyFields []string) error
+	UpdateGroupPaths(ctx context.Context, tenantID string) error
+	Reload(ctx context.Context, group *models.Group) error
+	DeleteByID(ctx context.Context, id, tenantID string) error
+	SoftDeleteByID(ctx context.Context, id, tenantID, userID string) error
+	SoftDeleteGroupChildren(ctx context.Context, id, tenantID, userID string) error
+	SoftDeleteTenantGroups(ctx context.Context, tenantID, userID string) error
+	TransferGroupChildrenParent(ctx context.Context, groupID, tenantID, userID string) error
+	RemoveGroupMembers(ctx context.Context, groupID, tenantID, userID string) error
+	RemoveAllGroupMembers(ctx context.Context, tenantID, userID string) error
+	IsCRMSynced(ctx context.Context, tenantID string) (bool, error)
+	SyncGroups(ctx context.Context, tenantID string) error
+	DeleteUnSyncedGroups(ctx context.Context, tenantID string) error
+	UpdateGroupTypes(ctx context.Context, tenantID string) error
+	DeleteAllTenantGroups(ctx context.Context, tenantID string) error
+	GetLatestModifiedTS(ctx context.Context, tenantID string) (time.Time, error)
+	GetTenantGroupCount(ctx context.Context, tenantID string) (int64, error)
+	GetTenantActiveGroupCount(ctx context.Context, tenantID string) (int64, error)
+	IsDescendant(ctx context.Context, sourceID string, targetID string) (DescendantCode, error)
+	GetSubTreeIDs(ctx context.Context, tenantID, groupID string) ([]string, error)
+	GetGroupsByRoleID(ctx context.Context, tenantID, roleID string) ([]*models.Group, error)
+	ReplaceRoleID(ctx context.Context, tenantID, roleID, replacementID, userID string) ([]*models.Group, error)
+}
+
+type PersonRepository interface {
+	Transactional
+	FromProto(p *orchardPb.Person) *models.Person
+	ToProto(p *models.Person) (*orchardPb.Person, error)
+	Insert(ctx context.Context, p *models.Person) error
+	UpsertAll(ctx context.Context, people []*models.Person) error
+	GetByID(ctx context.Context, id, tenantID string) (*models.Person, error)
+	GetByIDs(ctx context.Context, tenantID string, ids ...interface{}) ([]*models.Person, error)
+	GetAllActiveNonVirtualByEmails(ctx context.Context, tenantID string, emails ...interface{}) ([]*models.Person, error)
+	GetByEmail(ctx context.Context, tenantID, email string) (*models.Person, error)
+	GetAllByEmail(ctx context.Context, email string) ([]*models.Person, error)
+	GetAllByEmailForProvisioning(ctx context.Context, email string) ([]*models.Person, error)
+	Search(ctx context.Context, tenantID, query string, limit, offset int, filters ...PersonFilter) ([]*models.Person, int64, error)
+	SearchPage(ctx context.Context, tenantID, query string, page Page, filters ...PersonFilter) ([]*models.Person, int64, error)
+	SearchAfter(ctx context.Context, tenantID, query, afterID string, limit int, filters ...PersonFilter) ([]*models.Person, error)
+	GetPeopleByGroupId(ctx context.Context, tenantID, groupID string) ([]*models.Person, error)
+	GetAllByTenant(ctx context.Context, tenantID string) ([]*models.Person, error)
+	GetPeopleByRoleId(ctx context.Context, tenantID, roleID string, limit, offset int) ([]*models.Person, error)
+	CountPeopleByRoleId(ctx context.Context, tenantID, roleID string) (int64, error)
+	ReplaceRoleID(ctx context.Context, tenantID, roleID, replacementID, userID string) ([]*models.Person, error)
+	GetVirtualUsers(ctx context.Context, tenantID string, since *timestamppb.Timestamp) ([]*models.Person, error)
+	GetNonOutreachSyncedVirtualUsers(ctx context.Context, tenantID string, since *timestamppb.Timestamp) ([]*models.Person, error)
+	Update(ctx context.Context, p *models.Person, onlyFields []string) error
+	UpdatePersonGroups(ctx context.Context, tenantID string) error
+	DeleteByID(ctx context.Context, id, tenantID string) error
+	SoftDeleteByID(ctx context.Context, id, tenantID, userID string) error
+	GetPersonGroupIDs(ctx context.Context, tenantID string) (map[string]string, error)
+	CleanEmail(email string) string
+	GetSFSandboxEmail(email string) string
+	GetOutreachIdsFromCommitIds(ctx context.Context, tenantID string, entityID string) ([]string, error)
+	CleanupCNCUsers(ctx context.Context, tenantID string) ([]*CNCUserCleanupResult, error)
+	MakeUsersHierarchical(ctx context.Context, tenantID string) error
+}
+
+type CRMRoleRepository interface {
+	Transactional
+	FromProto(cr *orchardPb.CRMRole) *models.CRMRole
+	ToProto(cr *models.CRMRole) (*orchardPb.CRMRole, error)
+	Insert(ctx context.Context, cr *models.CRMRole) error
+	UpsertAll(ctx context.Context, crmRoles []*models.CRMRole) error
+	GetByID(ctx context.Context, id, tenantID string, isOutreach bool) (*models.CRMRole, error)
+	GetByIDs(ctx context.Context, tenantID string, isOutreach bool, ids ...string) ([]*models.CRMRole, error)
+	GetOutreachCommitMappingsByCommitIDs(ctx context.Context, tenantID string, ids ...string) (map[string]string, map[string]string, error)
+	GetOutreachCommitMappingsByOutreachIDs(ctx context.Context, tenantID string, ids ...string) (map[string]string, map[string]string, error)
+	GetUnsynced(ctx context.Context, tenantID string, isOutreach bool) ([]*models.CRMRole, error)
+	Search(ctx context.Context, tenantID, query string, limit, offset int, isOutreach bool) ([]*models.CRMRole, int64, error)
+	DeleteByID(ctx context.Context, id, tenantID string) error
+	DeleteUnSynced(ctx context.Context, tenantID string, syncedIDs ...interface{}) error
+}
+
+type SystemRoleRepository interface {
+	Transactional
+	FromProto(sr *orchardPb.SystemRole) *models.SystemRole
+	ToProto(sr *models.SystemRole) (*orchardPb.SystemRole, error)
+	Insert(ctx context.Context, sr *models.SystemRole) error
+	GetByID(ctx context.Context, id string) (*models.SystemRole, error)
+	GetByIDs(ctx context.Context, ids ...string) ([]*models.SystemRole, error)
+	GetByIDWithBaseRole(ctx context.Context, id string) ([]*models.SystemRole, error)
+	Search(ctx context.Context, tenantID, query string) ([]*models.SystemRole, error)
+	GetClones(ctx context.Context, tenantID string) ([]*models.SystemRole, error)
+	CountClones(ctx context.Context, baseRoleID string) (int64, error)
+	GetInternalRoleIDs(ctx context.Context) (map[string]struct{}, error)
+	Update(ctx context.Context, sr *models.SystemRole, onlyFields []string) error
+	DeleteByID(ctx context.Context, id string) error
+	LockByID(ctx context.Context, id string) error
+	SoftDeleteByID(ctx context.Context, id, tenantID, userID string) error
+}
+
+type GroupViewerRepository interface {
+	Transactional
+	FromProto(gv *orchardPb.GroupViewer) *models.GroupViewer
+	ToProto(gv *models.GroupViewer) (*orchardPb.GroupViewer, error)
+	Insert(ctx context.Context, gv *models.GroupViewer) error
+	GetGroupViewers(ctx context.Context, tenantID, groupID string) ([]*models.Person, error)
+	GetPersonViewableGroups(ctx context.Context, tenantID, personID string) ([]*models.Group, error)
+	GetPersonsViewableGroups(ctx context.Context, tenantID string, peepIds ...string) ([]*models.GroupViewer, error)
+	GetPersonGrants(ctx context.Context, tenantID, personID string) ([]*models.GroupViewer, error)
+	GetGroupGrants(ctx context.Context, tenantID string, groupIDs ...string) ([]*models.GroupViewer, error)
+	Update(ctx context.Context, gv *models.GroupViewer) error
+	DeleteByID(ctx context.Context, tenantID, groupID, personID string) error
+	DeleteExpired(ctx context.Context, now time.Time, limit int) ([]*models.GroupViewer, error)
+	MarkStarted(ctx context.Context, now time.Time, limit int) ([]*models.GroupViewer, error)
+}
+
+type TenantRepository interface {
+	Transactional
+	FromProto(t *tenantPb.Tenant) (*models.Tenant, error)
+	ToProto(t *models.Tenant) (*tenantPb.Tenant, error)
+	GetByID(ctx context.Context, tenantID string) (*models.Tenant, error)
+	GetGroupSyncState(ctx context.Context, tenantID string) (tenantPb.GroupSyncStatus, error)
+	CheckPeopleSyncState(ctx context.Context, tenantID string) (peopleSynced bool, err error)
+	UpdateGroupSyncState(ctx context.Context, tenantID string, state tenantPb.GroupSyncStatus) error
+	UpdateGroupSyncMetadata(ctx context.Context, tenantID string, metadata *tenantPb.GroupSyncMetadata) error
+	GetActiveTenants(ctx context.Context) ([]*models.Tenant, error)
+	GetTenantPersonCounts(ctx context.Context, tenantID string) (*TenantPersonCountResponse, error)
+}
+
+type OutboxRepository interface {
+	Transactional
+	Enqueue(ctx context.Context, msgs ...*OutboxMessage) error
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxMessage, error)
+	MarkDelivered(ctx context.Context, id string) error
+	MarkFailed(ctx context.Context, id, lastError string, retryAt time.Time, dead bool) error
+	GetDeadLetters(ctx context.Context, tenantID string, limit, offset int) ([]*OutboxMessage, error)
+	Requeue(ctx context.Context, id string) error
+}
+
+type AccessReviewRepository interface {
+	Transactional
+	Open(ctx context.Context, campaign *AccessReviewCampaign) ([]*AccessReviewItem, error)
+	GetCampaign(ctx context.Context, tenantID, id string) (*AccessReviewCampaign, error)
+	GetCampaigns(ctx context.Context, tenantID, status string) ([]*AccessReviewCampaign, error)
+	GetItems(ctx context.Context, tenantID, campaignID, reviewerID, decision string) ([]*AccessReviewItem, error)
+	Decide(ctx context.Context, tenantID, campaignID, itemID, decision, decidedBy, comment string, at time.Time) error
+	Close(ctx context.Context, tenantID, campaignID, closedBy string, at time.Time) ([]*AccessReviewItem, error)
+	GetRevokedSystemRoles(ctx context.Context, tenantID string) (map[string][]string, error)
+}
+
+type RoleAssignmentRuleRepository interface {
+	Transactional
+	GetRules(ctx context.Context, tenantID string) ([]*RoleAssignmentRule, error)
+	SetRules(ctx context.Context, tenantID string, rules []*RoleAssignmentRule, at time.Time) error
+}
+
+type SCIMTokenRepository interface {
+	Transactional
+	Create(ctx context.Context, tenantID, description string) (string, error)
+	GetTenantID(ctx context.Context, token string) (string, error)
+	GetTenantTokens(ctx context.Context, tenantID string) ([]*SCIMToken, error)
+	Revoke(ctx context.Context, tenantID, id string) error
+}
+
+// DirectoryRepository searches people and groups together
+type DirectoryRepository interface {
+	Search(ctx context.Context, tenantID string, search DirectorySearch) ([]*DirectoryHit, error)
+}
+
+var (
+	_ Store = (*DB)(nil)
+
+	_ GroupRepository              = (*GroupService)(nil)
+	_ PersonRepository             = (*PersonService)(nil)
+	_ CRMRoleRepository            = (*CRMRoleService)(nil)
+	_ SystemRoleRepository         = (*SystemRoleService)(nil)
+	_ GroupViewerRepository        = (*GroupViewerService)(nil)
+	_ TenantRepository             = (*TenantService)(nil)
+	_ OutboxRepository             = (*OutboxService)(nil)
+	_ DirectoryRepository          = (*DirectoryService)(nil)
+	_ AccessReviewRepository       = (*AccessReviewService)(nil)
+	_ RoleAssignmentRuleRepository = (*RoleAssignmentRuleService)(nil)
+	_ SCIMTokenRepository          = (*SCIMTokenService)(nil)
+)
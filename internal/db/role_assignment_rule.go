@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+)
+
+const (
+	// RoleAssignmentRuleKindGroupType matches people whose person type, their group's type unless they're a player-coach, is the rule's value
+	RoleAssignmentRuleKindGroupType = "group_type"
+	// RoleAssignmentRuleKindSubtree matches people in the group whose id is the rule's value or any group below it
+	RoleAssignmentRuleKindSubtree = "subtree"
+	// RoleAssignmentRuleKindEmailDomain matches people whose email is at the rule's value, a domain like example.com
+	RoleAssignmentRuleKindEmailDomain = "email_domain"
+)
+
+// RoleAssignmentRule gives the people it matches a system role
+type RoleAssignmentRule struct {
+	ID           string    `boil:"id" json:"id"`
+	TenantID     string    `boil:"tenant_id" json:"tenant_id"`
+	Kind         string    `boil:"kind" json:"kind"`
+	Value        string    `boil:"value" json:"value"`
+	SystemRoleID string    `boil:"system_role_id" json:"system_role_id"`
+	CreatedBy    string    `boil:"created_by" json:"created_by"`
+	CreatedAt    time.Time `boil:"created_at" json:"created_at"`
+	UpdatedAt    time.Time `boil:"updated_at" json:"updated_at"`
+}
+
+type RoleAssignmentRuleService struct {
+	*DBService
+}
+
+func (db *DB) NewRoleAssignmentRuleService() RoleAssignmentRuleRepository {
+	return &RoleAssignmentRuleService{
+		DBService: db.NewDBService(),
+	}
+}
+
+const (
+	getRoleAssignmentRulesQuery = `SELECT * FROM role_assignment_rule WHERE tenant_id = $1 ORDER BY kind, value, system_role_id;`
+
+	deleteRoleAssignmentRulesQuery = `DELETE FROM role_assignment_rule WHERE tenant_id = $1;`
+
+	insertRoleAssignmentRulesQuery = `INSERT INTO role_assignment_rule (id, tenant_id, kind, value, system_role_id, created_by, created_at, updated_at)
+	SELECT id, $2, kind, value, system_role_id, created_by, $3, $3
+	FROM UNNEST($1::UUID[], $4::TEXT[], $5::TEXT[], $6::TEXT[], $7::TEXT[]) AS r (id, kind, value, system_role_id, created_by);`
+)
+
+// GetRules returns a tenant's rules ordered by kind, value then system role
+func (svc *RoleAssignmentRuleService) GetRules(ctx context.Context, tenantID string) ([]*RoleAssignmentRule, error) {
+	spanCtx, span := log.StartSpan(ctx, "RoleAssignmentRule.GetRules")
+	defer span.End()
+
+	results := []*RoleAssignmentRule{}
+	if err := queries.Raw(getRoleAssignmentRulesQuery, tenantID).Bind(spanCtx, svc.GetContextExecutor(), &results); err != nil && err != sql.ErrNoRows {
+		log.WithTenantID(tenantID).WithCustom("query", getRoleAssignmentRulesQuery).Error(err)
+		return nil, err
+	}
+	return results, nil
+}
+
+// SetRules replaces a tenant's rules with rules, should run in a transaction. The rules are saved as created at.
+func (svc *RoleAssignmentRuleService) SetRules(ctx context.Context, tenantID string, rules []*RoleAssignmentRule, at time.Time) error {
+	spanCtx, span := log.StartSpan(ctx, "RoleAssignmentRule.SetRules")
+	defer span.End()
+
+	if _, err := queries.Raw(deleteRoleAssignmentRulesQuery, tenantID).ExecContext(spanCtx, svc.GetContextExecutor()); err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	var ids, kinds, values, roleIDs, createdBy []string
+	for _, rule := range rules {
+		rule.TenantID, rule.CreatedAt, rule.UpdatedAt = tenantID, at, at
+		ids = append(ids, rule.ID)
+		kinds = append(kinds, rule.Kind)
+		values = append(values, rule.Value)
+		roleIDs = append(roleIDs, rule.SystemRoleID)
+		createdBy = append(createdBy, rule.CreatedBy)
+	}
+
+	_, err := queries.Raw(insertRoleAssignmentRulesQuery, pq.Array(ids), tenantID, at,
+		pq.Array(kinds), pq.Array(values), pq.Array(roleIDs), pq.Array(createdBy)).
+		ExecContext(spanCtx, svc.GetContextExecutor())
+	return err
+}
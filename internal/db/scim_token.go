@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/queries"
+)
+
+// SCIMToken is a per-tenant bearer token used by an IdP to call the SCIM endpoint, only the hash of the token is stored
+type SCIMToken struct {
+	ID          string    `boil:"id" json:"id"`
+	TenantID    string    `boil:"tenant_id" json:"tenant_id"`
+	TokenHash   string    `boil:"token_hash" json:"-"`
+	Description string    `boil:"description" json:"description"`
+	CreatedAt   time.Time `boil:"created_at" json:"created_at"`
+	RevokedAt   null.Time `boil:"revoked_at" json:"revoked_at,omitempty"`
+}
+
+type SCIMTokenService struct {
+	*DBService
+}
+
+func (db *DB) NewSCIMTokenService() SCIMTokenRepository {
+	return &SCIMTokenService{
+		DBService: db.NewDBService(),
+	}
+}
+
+func hashSCIMToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newSCIMToken returns a random bearer token
+func newSCIMToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+const (
+	insertSCIMTokenQuery = `INSERT INTO scim_token (id, tenant_id, token_hash, description, created_at) VALUES ($1, $2, $3, $4, NOW());`
+)
+
+// Create generates a new bearer token for the tenant. The returned plain token can't be recovered later.
+func (svc *SCIMTokenService) Create(ctx context.Context, tenantID, description string) (string, error) {
+	spanCtx, span := log.StartSpan(ctx, "SCIMToken.Create")
+	defer span.End()
+
+	token, err := newSCIMToken()
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := queries.Raw(insertSCIMTokenQuery, MakeID(), tenantID, hashSCIMToken(token), description).ExecContext(spanCtx, svc.GetContextExecutor()); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+const (
+	getSCIMTokenByHashQuery = `SELECT * FROM scim_token WHERE token_hash = $1 AND revoked_at IS NULL;`
+)
+
+// GetTenantID returns the tenant the bearer token belongs to, or an empty string if the token is unknown or revoked
+func (svc *SCIMTokenService) GetTenantID(ctx context.Context, token string) (string, error) {
+	spanCtx, span := log.StartSpan(ctx, "SCIMToken.GetTenantID")
+	defer span.End()
+
+	result := SCIMToken{}
+	err := queries.Raw(getSCIMTokenByHashQuery, hashSCIMToken(token)).Bind(spanCtx, svc.GetContextExecutor(), &result)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return result.TenantID, nil
+}
+
+const (
+	getTenantSCIMTokensQuery = `SELECT * FROM scim_token WHERE tenant_id = $1 ORDER BY created_at DESC;`
+)
+
+func (svc *SCIMTokenService) GetTenantTokens(ctx context.Context, tenantID string) ([]*SCIMToken, error) {
+	spanCtx, span := log.StartSpan(ctx, "SCIMToken.GetTenantTokens")
+	defer span.End()
+
+	results := []*SCIMToken{}
+	if err := queries.Raw(getTenantSCIMTokensQuery, tenantID).Bind(spanCtx, svc.GetContextExecutor(), &results); err != nil && err != sql.ErrNoRows {
+		log.WithTenantID(tenantID).WithCustom("query", getTenantSCIMTokensQuery).Error(err)
+		return nil, err
+	}
+
+	return results, nil
+}
+
+const (
+	revokeSCIMTokenQuery = `UPDATE scim_token SET revoked_at = NOW() WHERE id = $1 AND tenant_id = $2 AND revoked_at IS NULL;`
+)
+
+func (svc *SCIMTokenService) Revoke(ctx context.Context, tenantID, id string) error {
+	spanCtx, span := log.StartSpan(ctx, "SCIMToken.Revoke")
+	defer span.End()
+
+	res, err := queries.Raw(revokeSCIMTokenQuery, id, tenantID).ExecContext(spanCtx, svc.GetContextExecutor())
+	if err != nil {
+		return err
+	}
+	numAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if numAffected != 1 {
+		return fmt.Errorf("error revoking scim token: update affected 0 rows")
+	}
+
+	return nil
+}
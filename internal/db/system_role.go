@@ -9,11 +9,13 @@ import (
 	"github.com/loupe-co/go-common/errors"
 	"github.com/loupe-co/go-loupe-logger/log"
 	"github.com/loupe-co/orchard/internal/models"
+	"github.com/loupe-co/orchard/internal/permcatalog"
 	orchardPb "github.com/loupe-co/protos/src/common/orchard"
 	null "github.com/volatiletech/null/v8"
 	"github.com/volatiletech/sqlboiler/v4/boil"
 	"github.com/volatiletech/sqlboiler/v4/queries"
 	"github.com/volatiletech/sqlboiler/v4/queries/qm"
+	"github.com/volatiletech/sqlboiler/v4/types"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -21,7 +23,7 @@ type SystemRoleService struct {
 	*DBService
 }
 
-func (db *DB) NewSystemRoleService() *SystemRoleService {
+func (db *DB) NewSystemRoleService() SystemRoleRepository {
 	return &SystemRoleService{
 		DBService: db.NewDBService(),
 	}
@@ -36,37 +38,45 @@ func (svc *SystemRoleService) FromProto(sr *orchardPb.SystemRole) *models.System
 	createdAt := sr.CreatedAt.AsTime()
 	updatedAt := sr.UpdatedAt.AsTime()
 
+	// the overrides of a cloned role are worked out from its permissions when it's saved
 	return &models.SystemRole{
-		ID:          sr.Id,
-		TenantID:    tenantID,
-		Name:        sr.Name,
-		Description: null.NewString(sr.Description, sr.Description != ""),
-		Type:        strings.ToLower(sr.Type.String()),
-		Permissions: sr.Permissions,
-		Status:      strings.ToLower(sr.Status.String()),
-		Priority:    int(sr.Priority),
-		BaseRoleID:  null.NewString(sr.BaseRoleId, sr.BaseRoleId != ""),
-		CreatedBy:   sr.CreatedBy,
-		CreatedAt:   createdAt,
-		UpdatedBy:   sr.UpdatedBy,
-		UpdatedAt:   updatedAt,
+		ID:                 sr.Id,
+		TenantID:           tenantID,
+		Name:               sr.Name,
+		Description:        null.NewString(sr.Description, sr.Description != ""),
+		Type:               strings.ToLower(sr.Type.String()),
+		Permissions:        sr.Permissions,
+		Status:             strings.ToLower(sr.Status.String()),
+		Priority:           int(sr.Priority),
+		BaseRoleID:         null.NewString(sr.BaseRoleId, sr.BaseRoleId != ""),
+		GrantedPermissions: types.Int64Array{},
+		RevokedPermissions: types.Int64Array{},
+		CreatedBy:          sr.CreatedBy,
+		CreatedAt:          createdAt,
+		UpdatedBy:          sr.UpdatedBy,
+		UpdatedAt:          updatedAt,
 	}
 }
 
+// SystemRoleTypeToProto maps the system_role.type column to its proto enum
+func SystemRoleTypeToProto(typ string) orchardPb.SystemRoleType {
+	switch typ {
+	case "ic":
+		return orchardPb.SystemRoleType_IC
+	case "manager":
+		return orchardPb.SystemRoleType_Manager
+	case "internal":
+		return orchardPb.SystemRoleType_Internal
+	}
+	return orchardPb.SystemRoleType_Unknown
+}
+
 func (svc *SystemRoleService) ToProto(sr *models.SystemRole) (*orchardPb.SystemRole, error) {
 	createdAt := timestamppb.New(sr.CreatedAt)
 
 	updatedAt := timestamppb.New(sr.UpdatedAt)
 
-	typ := orchardPb.SystemRoleType_Unknown
-	switch sr.Type {
-	case "ic":
-		typ = orchardPb.SystemRoleType_IC
-	case "manager":
-		typ = orchardPb.SystemRoleType_Manager
-	case "internal":
-		typ = orchardPb.SystemRoleType_Internal
-	}
+	typ := SystemRoleTypeToProto(sr.Type)
 
 	status := orchardPb.BasicStatus_Inactive
 	switch sr.Status {
@@ -80,20 +90,23 @@ func (svc *SystemRoleService) ToProto(sr *models.SystemRole) (*orchardPb.SystemR
 	}
 
 	return &orchardPb.SystemRole{
-		Id:          sr.ID,
-		TenantId:    sr.TenantID,
-		Name:        sr.Name,
-		Description: sr.Description.String,
-		Type:        typ,
-		Permissions: sr.Permissions,
-		Priority:    int32(sr.Priority),
-		Status:      status,
-		IsCustom:    isCustom,
-		BaseRoleId:  sr.BaseRoleID.String,
-		CreatedAt:   createdAt,
-		CreatedBy:   sr.CreatedBy,
-		UpdatedAt:   updatedAt,
-		UpdatedBy:   sr.UpdatedBy,
+		Id:                 sr.ID,
+		TenantId:           sr.TenantID,
+		Name:               sr.Name,
+		Description:        sr.Description.String,
+		Type:               typ,
+		Permissions:        sr.Permissions,
+		NamedPermissions:   permcatalog.NamedSets(sr.Permissions),
+		Priority:           int32(sr.Priority),
+		Status:             status,
+		IsCustom:           isCustom,
+		BaseRoleId:         sr.BaseRoleID.String,
+		GrantedPermissions: sr.GrantedPermissions,
+		RevokedPermissions: sr.RevokedPermissions,
+		CreatedAt:          createdAt,
+		CreatedBy:          sr.CreatedBy,
+		UpdatedAt:          updatedAt,
+		UpdatedBy:          sr.UpdatedBy,
 	}, nil
 }
 
@@ -101,10 +114,107 @@ var (
 	systemRoleInsertWhitelist = []string{
 		"id", "tenant_id", "name", "description",
 		"type", "permissions", "priority", "status", "base_role_id",
+		"granted_permissions", "revoked_permissions",
 		"created_at", "created_by", "updated_at", "updated_by",
 	}
 )
 
+// InheritPermissions is a cloned role's effective permissions: its base role's permissions with the granted bits added and the
+// revoked bits taken away, per permission set
+func InheritPermissions(base, granted, revoked []int64) types.Int64Array {
+	n := len(base)
+	if len(granted) > n {
+		n = len(granted)
+	}
+	at := func(bits []int64, i int) int64 {
+		if i < len(bits) {
+			return bits[i]
+		}
+		return 0
+	}
+	effective := make(types.Int64Array, n)
+	for i := range effective {
+		effective[i] = (at(base, i) | at(granted, i)) &^ at(revoked, i)
+	}
+	return effective
+}
+
+// PermissionOverrides is what a cloned role has to store to end up with want given its base role's permissions
+func PermissionOverrides(base, want []int64) (granted, revoked types.Int64Array) {
+	n := len(base)
+	if len(want) > n {
+		n = len(want)
+	}
+	granted, revoked = types.Int64Array{}, types.Int64Array{}
+	for i := 0; i < n; i++ {
+		var b, w int64
+		if i < len(base) {
+			b = base[i]
+		}
+		if i < len(want) {
+			w = want[i]
+		}
+		granted = append(granted, w&^b)
+		revoked = append(revoked, b&^w)
+	}
+	return trimPermissions(granted), trimPermissions(revoked)
+}
+
+// trimPermissions drops trailing permission sets without any bits, so a role without overrides stores an empty array
+func trimPermissions(bits types.Int64Array) types.Int64Array {
+	for len(bits) > 0 && bits[len(bits)-1] == 0 {
+		bits = bits[:len(bits)-1]
+	}
+	return bits
+}
+
+// HasPermissionOverrides is whether a cloned role differs from its base role
+func HasPermissionOverrides(sr *models.SystemRole) bool {
+	return len(trimPermissions(sr.GrantedPermissions)) > 0 || len(trimPermissions(sr.RevokedPermissions)) > 0
+}
+
+// inheritBasePermissions sets the effective permissions on every cloned role in srs, bases are the roles already loaded by id
+func inheritBasePermissions(srs []*models.SystemRole, bases map[string]*models.SystemRole) {
+	for _, sr := range srs {
+		if !sr.BaseRoleID.Valid || sr.BaseRoleID.String == "" {
+			continue
+		}
+		base, ok := bases[sr.BaseRoleID.String]
+		if !ok || base.ID == sr.ID {
+			continue
+		}
+		sr.Permissions = InheritPermissions(base.Permissions, sr.GrantedPermissions, sr.RevokedPermissions)
+	}
+}
+
+// inheritPermissions loads the base roles of the cloned roles in srs and computes their effective permissions. Base roles
+// belong to the default tenant and don't have a base role of their own, so one level is enough.
+func (svc *SystemRoleService) inheritPermissions(ctx context.Context, srs ...*models.SystemRole) error {
+	bases := map[string]*models.SystemRole{}
+	for _, sr := range srs {
+		if !sr.BaseRoleID.Valid {
+			bases[sr.ID] = sr
+		}
+	}
+	baseIDs := []interface{}{}
+	for _, sr := range srs {
+		if sr.BaseRoleID.Valid && sr.BaseRoleID.String != "" && bases[sr.BaseRoleID.String] == nil {
+			baseIDs = append(baseIDs, sr.BaseRoleID.String)
+		}
+	}
+	if len(baseIDs) > 0 {
+		baseRoles, err := models.SystemRoles(qm.WhereIn("id IN ?", baseIDs...)).All(ctx, svc.GetContextExecutor())
+		if err != nil {
+			return errors.Wrap(err, "error getting base system roles")
+		}
+		for _, base := range baseRoles {
+			bases[base.ID] = base
+		}
+	}
+	inheritBasePermissions(srs, bases)
+	return nil
+}
+
 func (svc *SystemRoleService) Insert(ctx context.Context, sr *models.SystemRole) error {
 	spanCtx, span := log.StartSpan(ctx, "SystemRole.Insert")
 	defer span.End()
@@ -121,6 +231,9 @@ func (svc *SystemRoleService) GetByID(ctx context.Context, id string) (*models.S
 	if err != nil {
 		return nil, err
 	}
+	if err := svc.inheritPermissions(spanCtx, sr); err != nil {
+		return nil, err
+	}
 	return sr, nil
 }
 
@@ -136,6 +249,9 @@ func (svc *SystemRoleService) GetByIDs(ctx context.Context, ids ...string) ([]*m
 	if err != nil {
 		return nil, err
 	}
+	if err := svc.inheritPermissions(spanCtx, srs...); err != nil {
+		return nil, err
+	}
 	return srs, nil
 }
 
@@ -162,6 +278,9 @@ func (svc *SystemRoleService) GetByIDWithBaseRole(ctx context.Context, id string
 	if err := queries.Raw(systemRoleWithBaseQuery, id).Bind(spanCtx, svc.GetContextExecutor(), &systemRoles); err != nil {
 		return nil, errors.Wrap(err, "error querying sql for system role with base role")
 	}
+	if err := svc.inheritPermissions(spanCtx, systemRoles...); err != nil {
+		return nil, err
+	}
 	return systemRoles, nil
 }
 
@@ -190,9 +309,46 @@ func (svc *SystemRoleService) Search(ctx context.Context, tenantID, query string
 		return nil, err
 	}
 
+	if err := svc.inheritPermissions(spanCtx, systemRoles...); err != nil {
+		return nil, err
+	}
+
+	return systemRoles, nil
+}
+
+// GetClones returns a tenant's active roles that were cloned from a base role, with their effective permissions
+func (svc *SystemRoleService) GetClones(ctx context.Context, tenantID string) ([]*models.SystemRole, error) {
+	spanCtx, span := log.StartSpan(ctx, "SystemRole.GetClones")
+	defer span.End()
+
+	systemRoles, err := models.SystemRoles(
+		models.SystemRoleWhere.TenantID.EQ(tenantID),
+		models.SystemRoleWhere.Status.EQ("active"),
+		qm.Where("base_role_id IS NOT NULL"),
+		qm.OrderBy("name, id"),
+	).All(spanCtx, svc.GetContextExecutor())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := svc.inheritPermissions(spanCtx, systemRoles...); err != nil {
+		return nil, err
+	}
+
 	return systemRoles, nil
 }
 
+// CountClones counts the active roles cloned from a base role, in every tenant
+func (svc *SystemRoleService) CountClones(ctx context.Context, baseRoleID string) (int64, error) {
+	spanCtx, span := log.StartSpan(ctx, "SystemRole.CountClones")
+	defer span.End()
+
+	return models.SystemRoles(
+		models.SystemRoleWhere.BaseRoleID.EQ(null.StringFrom(baseRoleID)),
+		models.SystemRoleWhere.Status.EQ("active"),
+	).Count(spanCtx, svc.GetContextExecutor())
+}
+
 func (svc *SystemRoleService) GetInternalRoleIDs(ctx context.Context) (map[string]struct{}, error) {
 	spanCtx, span := log.StartSpan(ctx, "SystemRole.GetInternalRoleIDs")
 	defer span.End()
@@ -217,6 +373,7 @@ var (
 	defaultSystemRoleUpdateWhitelist = []string{
 		"name", "description", "type", "permissions", "priority",
 		"status", "updated_at", "updated_by", "base_role_id",
+		"granted_permissions", "revoked_permissions",
 	}
 )
 
@@ -265,6 +422,18 @@ func (svc *SystemRoleService) DeleteByID(ctx context.Context, id string) error {
 	return nil
 }
 
+const (
+	lockSystemRoleQuery = `SELECT id FROM system_role WHERE id = $1 FOR UPDATE;`
+)
+
+// LockByID locks the role's row until the transaction ends, a role that doesn't exist locks nothing. Should run in a transaction.
+func (svc *SystemRoleService) LockByID(ctx context.Context, id string) error {
+	spanCtx, span := log.StartSpan(ctx, "SystemRole.LockByID")
+	defer span.End()
+	_, err := queries.Raw(lockSystemRoleQuery, id).ExecContext(spanCtx, svc.GetContextExecutor())
+	return err
+}
+
 func (svc *SystemRoleService) SoftDeleteByID(ctx context.Context, id, tenantID, userID string) error {
 	spanCtx, span := log.StartSpan(ctx, "SystemRole.SoftDeleteByID")
 	defer span.End()
@@ -22,7 +22,7 @@ type TenantService struct {
 	*DBService
 }
 
-func (db *DB) NewTenantService() *TenantService {
+func (db *DB) NewTenantService() TenantRepository {
 	return &TenantService{
 		DBService: db.NewDBService(),
 	}
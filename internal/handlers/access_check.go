@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/models"
+	authPb "github.com/loupe-co/protos/src/common/auth"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+)
+
+const maxAccessChecks = 1000
+
+// accessScope is what a viewer can see, their own subtree and the subtrees of the groups they are a viewer of
+type accessScope struct {
+	resolved *resolvedPermissions
+	ownPath  string
+	// grants are the viewer's group viewer grants by the group_path of the granted group
+	grants map[string]int64
+}
+
+// inScope returns how the viewer can see a group with path, the group it's seen through and the group permission bits they have on it
+func (scope *accessScope) inScope(path string) (orchardPb.AccessReason, string, int64) {
+	if path == "" {
+		return orchardPb.AccessReason_NotInScope, "", 0
+	}
+	if scope.ownPath != "" && pathWithin(path, scope.ownPath) {
+		return orchardPb.AccessReason_OwnSubtree, scope.resolved.person.GroupID.String, defaultGroupViewerPermissions()
+	}
+	// grants on several ancestors add up, the nearest one is reported as the group it's seen through
+	reason, nearest, bits := orchardPb.AccessReason_NotInScope, "", int64(0)
+	for grantPath, grantBits := range scope.grants {
+		if pathWithin(path, grantPath) {
+			reason, bits = orchardPb.AccessReason_GroupViewer, bits|grantBits
+			if len(grantPath) > len(nearest) {
+				nearest = grantPath
+			}
+		}
+	}
+	if nearest == "" {
+		return reason, "", 0
+	}
+	return reason, pathGroupID(nearest), bits
+}
+
+// pathWithin is the ltree path <@ ancestor check
+func pathWithin(path, ancestor string) bool {
+	return path == ancestor || strings.HasPrefix(path, ancestor+".")
+}
+
+// pathGroupID is the id of the group a group_path ends in
+func pathGroupID(path string) string {
+	label := path[strings.LastIndex(path, ".")+1:]
+	return strings.ReplaceAll(label, "_", "-")
+}
+
+// CheckAccess answers a batch of "can viewer see this group or person" checks. A target is visible when it is in the viewer's own
+// subtree or under a group they are a viewer of. A check that asks for group permissions also needs those bits on the group, one
+// that asks for any other permission set needs the bits from the viewer's system roles. Every decision comes with the reason for it.
+func (h *Handlers) CheckAccess(ctx context.Context, in *servicePb.CheckAccessRequest) (*servicePb.CheckAccessResponse, error) {
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("checks", len(in.Checks))
+
+	if in.TenantId == "" {
+		err := ErrBadRequest.New("tenantId can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+	if len(in.Checks) > maxAccessChecks {
+		err := ErrBadRequest.New(fmt.Sprintf("can't check more than %d at once", maxAccessChecks))
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+	for i, check := range in.Checks {
+		if check.ViewerId == "" || (check.GroupId == "") == (check.PersonId == "") {
+			err := ErrBadRequest.New(fmt.Sprintf("check %d needs a viewerId and either a groupId or a personId", i))
+			logger.Warn(err.Error())
+			return nil, err.AsGRPC()
+		}
+	}
+
+	scopes, err := h.accessScopes(ctx, in.TenantId, in.Checks)
+	if err != nil {
+		err := errors.Wrap(err, "error resolving viewer access")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	targetPeople := map[string]*models.Person{}
+	personIDs := []interface{}{}
+	for _, check := range in.Checks {
+		if check.PersonId != "" {
+			personIDs = append(personIDs, check.PersonId)
+		}
+	}
+	if len(personIDs) > 0 {
+		people, err := h.db.NewPersonService().GetByIDs(ctx, in.TenantId, personIDs...)
+		if err != nil {
+			err := errors.Wrap(err, "error getting target people")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		for _, p := range people {
+			targetPeople[p.ID] = p
+		}
+	}
+
+	groupIDs := []string{}
+	for _, check := range in.Checks {
+		if check.GroupId != "" {
+			groupIDs = append(groupIDs, check.GroupId)
+		}
+	}
+	for _, p := range targetPeople {
+		if p.GroupID.Valid && p.GroupID.String != "" {
+			groupIDs = append(groupIDs, p.GroupID.String)
+		}
+	}
+	targetGroups, err := h.activeGroupsByID(ctx, in.TenantId, groupIDs)
+	if err != nil {
+		err := errors.Wrap(err, "error getting target groups")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	decisions := make([]*orchardPb.AccessDecision, len(in.Checks))
+	for i, check := range in.Checks {
+		decisions[i] = decideAccess(check, scopes[check.ViewerId], targetPeople, targetGroups)
+	}
+
+	return &servicePb.CheckAccessResponse{
+		Decisions: decisions,
+	}, nil
+}
+
+// accessScopes resolves each distinct viewer once, viewers that aren't in the tenant are left out
+func (h *Handlers) accessScopes(ctx context.Context, tenantID string, checks []*orchardPb.AccessCheck) (map[string]*accessScope, error) {
+	scopes := map[string]*accessScope{}
+	grantGroupIDs := []string{}
+	for _, check := range checks {
+		if _, ok := scopes[check.ViewerId]; ok {
+			continue
+		}
+		resolved, err := h.resolvePermissions(ctx, tenantID, check.ViewerId)
+		if err != nil {
+			return nil, err
+		}
+		scopes[check.ViewerId] = nil
+		if resolved == nil {
+			continue
+		}
+		scopes[check.ViewerId] = &accessScope{resolved: resolved, grants: map[string]int64{}}
+		for id, access := range resolved.groups {
+			if access.direct {
+				grantGroupIDs = append(grantGroupIDs, id)
+			}
+		}
+		if resolved.person.GroupID.Valid {
+			grantGroupIDs = append(grantGroupIDs, resolved.person.GroupID.String)
+		}
+	}
+
+	groups, err := h.activeGroupsByID(ctx, tenantID, grantGroupIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range scopes {
+		if scope == nil {
+			continue
+		}
+		if g, ok := groups[scope.resolved.person.GroupID.String]; ok && scope.resolved.person.GroupID.Valid {
+			scope.ownPath = g.GroupPath
+		}
+		for id, access := range scope.resolved.groups {
+			if g, ok := groups[id]; ok && access.direct && g.GroupPath != "" {
+				scope.grants[g.GroupPath] |= access.permissions
+			}
+		}
+	}
+	return scopes, nil
+}
+
+// activeGroupsByID loads the active groups with ids in one query
+func (h *Handlers) activeGroupsByID(ctx context.Context, tenantID string, ids []string) (map[string]*models.Group, error) {
+	groups := map[string]*models.Group{}
+	if len(ids) == 0 {
+		return groups, nil
+	}
+	values := make([]interface{}, 0, len(ids))
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			values = append(values, id)
+		}
+	}
+	gs, err := h.db.NewGroupService().Search(ctx, tenantID, "",
+		db.Filter{Field: "id", Op: "IN", Values: values},
+		db.Filter{Field: "status", Op: "EQ", Values: []interface{}{"active"}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range gs {
+		groups[g.ID] = g
+	}
+	return groups, nil
+}
+
+func decideAccess(check *orchardPb.AccessCheck, scope *accessScope, people map[string]*models.Person, groups map[string]*models.Group) *orchardPb.AccessDecision {
+	decision := &orchardPb.AccessDecision{
+		ViewerId: check.ViewerId,
+		GroupId:  check.GroupId,
+		PersonId: check.PersonId,
+	}
+	if scope == nil {
+		decision.Reason = orchardPb.AccessReason_ViewerNotFound
+		return decision
+	}
+
+	var reason orchardPb.AccessReason
+	var groupBits int64
+	if check.PersonId != "" {
+		target, ok := people[check.PersonId]
+		switch {
+		case !ok:
+			decision.Reason = orchardPb.AccessReason_TargetNotFound
+			return decision
+		case target.ID == check.ViewerId:
+			reason, groupBits = orchardPb.AccessReason_Self, defaultGroupViewerPermissions()
+		default:
+			g, ok := groups[target.GroupID.String]
+			if !target.GroupID.Valid || !ok {
+				decision.Reason = orchardPb.AccessReason_NotInScope
+				return decision
+			}
+			reason, decision.ViaGroupId, groupBits = scope.inScope(g.GroupPath)
+		}
+	} else {
+		g, ok := groups[check.GroupId]
+		if !ok {
+			decision.Reason = orchardPb.AccessReason_TargetNotFound
+			return decision
+		}
+		reason, decision.ViaGroupId, groupBits = scope.inScope(g.GroupPath)
+	}
+	if reason == orchardPb.AccessReason_NotInScope {
+		decision.Reason = reason
+		return decision
+	}
+
+	if check.Permissions != 0 {
+		have := scope.resolved.sets[check.PermissionSet]
+		missing := orchardPb.AccessReason_MissingRolePermission
+		if check.PermissionSet == authPb.PermissionSet_Group {
+			have, missing = groupBits, orchardPb.AccessReason_MissingGroupPermission
+		}
+		if check.Permissions&^have != 0 {
+			decision.Reason = missing
+			return decision
+		}
+	}
+
+	decision.Allowed = true
+	decision.Reason = reason
+	return decision
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/loupe-co/go-common/fixtures"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/permcatalog"
+	authPb "github.com/loupe-co/protos/src/common/auth"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+)
+
+const (
+	seedEMEAManagersID = "d21c11ba-4d88-4614-b462-74108cde940f"
+	seedGrantID        = "4c763cfe-6406-4221-913c-f5db90224f44"
+)
+
+// accessCheckTestCase is a check and the decision it should get, group permissions are named like the catalog names them
+type accessCheckTestCase struct {
+	Name             string   `json:"name"`
+	ViewerID         string   `json:"viewer_id"`
+	GroupID          string   `json:"group_id"`
+	PersonID         string   `json:"person_id"`
+	GroupPermissions []string `json:"group_permissions"`
+	Allowed          bool     `json:"allowed"`
+	Reason           string   `json:"reason"`
+	ViaGroupID       string   `json:"via_group_id"`
+}
+
+type accessCheckTestData struct {
+	TenantID string                 `json:"tenant_id"`
+	Cases    []*accessCheckTestCase `json:"cases"`
+}
+
+// getAccessCheckTestRequest builds the CheckAccess request for the cases at key of fixtures/access_check.json
+func getAccessCheckTestRequest(key string) (*servicePb.CheckAccessRequest, []*accessCheckTestCase, error) {
+	raw, _, _, err := jsonparser.Get(fixtures.Data["access_check"], key)
+	if err != nil {
+		return nil, nil, err
+	}
+	testData := &accessCheckTestData{}
+	if err := json.Unmarshal(raw, testData); err != nil {
+		return nil, nil, err
+	}
+
+	req := &servicePb.CheckAccessRequest{TenantId: testData.TenantID}
+	for _, c := range testData.Cases {
+		check := &orchardPb.AccessCheck{ViewerId: c.ViewerID, GroupId: c.GroupID, PersonId: c.PersonID}
+		if len(c.GroupPermissions) > 0 {
+			bits, err := permcatalog.Encode(authPb.PermissionSet_Group, c.GroupPermissions)
+			if err != nil {
+				return nil, nil, err
+			}
+			check.PermissionSet, check.Permissions = authPb.PermissionSet_Group, bits
+		}
+		req.Checks = append(req.Checks, check)
+	}
+	return req, testData.Cases, nil
+}
+
+func TestCheckAccessReasons(t *testing.T) {
+	h, _, _, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	req, cases, err := getAccessCheckTestRequest("TestCheckAccessReasons")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	// permission sets other than group are checked against the viewer's roles, the seeded system roles have no permissions
+	req.Checks = append(req.Checks, &orchardPb.AccessCheck{ViewerId: seedWillID, GroupId: seedEMEAAEsID, PermissionSet: authPb.PermissionSet_Group + 1, Permissions: 1})
+	cases = append(cases, &accessCheckTestCase{Name: "missing role permission", Reason: orchardPb.AccessReason_MissingRolePermission.String(), ViaGroupID: seedEMEAManagersID})
+
+	res, err := h.CheckAccess(context.Background(), req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(res.Decisions) != len(cases) {
+		t.Logf("expected a decision per check, but got %d", len(res.Decisions))
+		t.Fail()
+		return
+	}
+	for i, c := range cases {
+		decision := res.Decisions[i]
+		if decision.Allowed != c.Allowed || decision.Reason.String() != c.Reason || decision.ViaGroupId != c.ViaGroupID {
+			t.Logf("%s: expected allowed %t because %s via %q, but got %t because %v via %q", c.Name, c.Allowed, c.Reason, c.ViaGroupID, decision.Allowed, decision.Reason, decision.ViaGroupId)
+			t.Fail()
+			return
+		}
+	}
+
+	rawResult, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if err := fixtures.WriteTestResult("../../fixtures/results/TestCheckAccessReasons.json", rawResult); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+}
+
+func TestCheckAccessThroughAncestorGrants(t *testing.T) {
+	h, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// a viewer grant with Read on Sales Leaders covers every group under it
+	if _, err := insertTestData(store, "access_check", "TestCheckAccessThroughAncestorGrants", "store"); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	req, cases, err := getAccessCheckTestRequest("TestCheckAccessThroughAncestorGrants")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	res, err := h.CheckAccess(context.Background(), req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	for i, c := range cases {
+		decision := res.Decisions[i]
+		if decision.Allowed != c.Allowed || decision.Reason.String() != c.Reason || decision.ViaGroupId != c.ViaGroupID {
+			t.Logf("%s: expected allowed %t because %s via %q, but got %t because %v via %q", c.Name, c.Allowed, c.Reason, c.ViaGroupID, decision.Allowed, decision.Reason, decision.ViaGroupId)
+			t.Fail()
+			return
+		}
+	}
+}
+
+func TestCheckAccessBadRequest(t *testing.T) {
+	h, _, _, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["access_check"], "TestCheckAccessBadRequest")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	requests := []*servicePb.CheckAccessRequest{}
+	if err := json.Unmarshal(testData, &requests); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	requests = append(requests, &servicePb.CheckAccessRequest{TenantId: db.DefaultTenantID, Checks: make([]*orchardPb.AccessCheck, maxAccessChecks+1)})
+
+	for _, req := range requests {
+		_, err := h.CheckAccess(context.Background(), req)
+		if err == nil {
+			t.Logf("expected %d checks to be a bad request, but got nil error", len(req.Checks))
+			t.Fail()
+			return
+		}
+		if !strings.Contains(err.Error(), "Bad Request") {
+			t.Log("expected error to contain 'Bad Request', but got", err)
+			t.Fail()
+			return
+		}
+	}
+}
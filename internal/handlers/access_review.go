@@ -0,0 +1,470 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/models"
+	"github.com/loupe-co/orchard/internal/permcatalog"
+	authPb "github.com/loupe-co/protos/src/common/auth"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/types"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// defaultAccessReviewRoleTypes are the elevated system role types reviewed when a campaign doesn't say which
+var defaultAccessReviewRoleTypes = []string{models.SystemRoleTypeManager, models.SystemRoleTypeInternal}
+
+// OpenAccessReview opens an access review campaign for a tenant. Every group viewer grant and every assignment of an elevated
+// system role is snapshotted as an item for the person's manager to review. A tenant has at most one open campaign.
+func (h *Handlers) OpenAccessReview(ctx context.Context, in *servicePb.OpenAccessReviewRequest) (*servicePb.OpenAccessReviewResponse, error) {
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
+
+	if in.TenantId == "" || in.CreatedBy == "" {
+		err := ErrBadRequest.New("tenantId and createdBy can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	now := time.Now().UTC()
+	campaign := &db.AccessReviewCampaign{
+		ID:              db.MakeID(),
+		TenantID:        in.TenantId,
+		Name:            in.Name,
+		SystemRoleTypes: types.StringArray(defaultAccessReviewRoleTypes),
+		CreatedBy:       in.CreatedBy,
+		CreatedAt:       now,
+	}
+	if len(in.SystemRoleTypes) > 0 {
+		campaign.SystemRoleTypes = types.StringArray{}
+		for _, typ := range in.SystemRoleTypes {
+			campaign.SystemRoleTypes = append(campaign.SystemRoleTypes, strings.ToLower(typ.String()))
+		}
+	}
+	if in.DueAt != nil {
+		if !in.DueAt.AsTime().After(now) {
+			err := ErrBadRequest.New("dueAt must be in the future")
+			logger.Warn(err.Error())
+			return nil, err.AsGRPC()
+		}
+		campaign.DueAt = null.TimeFrom(in.DueAt.AsTime().UTC())
+	}
+	if campaign.Name == "" {
+		campaign.Name = fmt.Sprintf("Access review %s", now.Format("2006-01-02"))
+	}
+
+	svc := h.db.NewAccessReviewService()
+	existing, err := svc.GetCampaigns(ctx, in.TenantId, db.AccessReviewStatusOpen)
+	if err != nil {
+		err := errors.Wrap(err, "error getting open access reviews")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	if len(existing) > 0 {
+		err := ErrBadRequest.New(fmt.Sprintf("access review %s is still open", existing[0].ID))
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	items, err := svc.Open(ctx, campaign)
+	if err != nil {
+		err := errors.Wrap(err, "error opening access review")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	logger.WithCustom("campaignId", campaign.ID).WithCustom("items", len(items)).Info("opened access review")
+
+	return &servicePb.OpenAccessReviewResponse{
+		Campaign: accessReviewCampaignToProto(campaign),
+		Report:   accessReviewReport(campaign, items),
+	}, nil
+}
+
+// GetAccessReviews lists a tenant's access review campaigns newest first, optionally only open or closed ones
+func (h *Handlers) GetAccessReviews(ctx context.Context, in *servicePb.GetAccessReviewsRequest) (*servicePb.GetAccessReviewsResponse, error) {
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
+
+	if in.TenantId == "" {
+		err := ErrBadRequest.New("tenantId can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	campaigns, err := h.db.NewAccessReviewService().GetCampaigns(ctx, in.TenantId, in.Status)
+	if err != nil {
+		err := errors.Wrap(err, "error getting access reviews")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	res := &servicePb.GetAccessReviewsResponse{
+		Campaigns: make([]*orchardPb.AccessReviewCampaign, len(campaigns)),
+	}
+	for i, campaign := range campaigns {
+		res.Campaigns[i] = accessReviewCampaignToProto(campaign)
+	}
+	return res, nil
+}
+
+// GetAccessReviewItems lists the items of a campaign, optionally only the ones a reviewer has to review or still pending
+func (h *Handlers) GetAccessReviewItems(ctx context.Context, in *servicePb.GetAccessReviewItemsRequest) (*servicePb.GetAccessReviewItemsResponse, error) {
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("campaignId", in.CampaignId)
+
+	if in.TenantId == "" || in.CampaignId == "" {
+		err := ErrBadRequest.New("tenantId and campaignId can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	decision := ""
+	if in.OnlyPending {
+		decision = db.AccessReviewDecisionPending
+	}
+	items, err := h.db.NewAccessReviewService().GetItems(ctx, in.TenantId, in.CampaignId, in.ReviewerId, decision)
+	if err != nil {
+		err := errors.Wrap(err, "error getting access review items")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	res := &servicePb.GetAccessReviewItemsResponse{
+		Items: make([]*orchardPb.AccessReviewItem, len(items)),
+	}
+	for i, item := range items {
+		res.Items[i] = accessReviewItemToProto(item)
+	}
+	return res, nil
+}
+
+// RecordAccessReviewDecisions records a reviewer's keep or revoke decisions. Items can only be decided by their reviewer or by
+// whoever opened the campaign, never by the person they grant access to. Decisions can be changed until the campaign closes.
+func (h *Handlers) RecordAccessReviewDecisions(ctx context.Context, in *servicePb.RecordAccessReviewDecisionsRequest) (*servicePb.RecordAccessReviewDecisionsResponse, error) {
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("campaignId", in.CampaignId).WithCustom("reviewerId", in.ReviewerId)
+
+	if in.TenantId == "" || in.CampaignId == "" || in.ReviewerId == "" {
+		err := ErrBadRequest.New("tenantId, campaignId and reviewerId can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	svc := h.db.NewAccessReviewService()
+	campaign, err := svc.GetCampaign(ctx, in.TenantId, in.CampaignId)
+	if err == sql.ErrNoRows || (err == nil && campaign.Status != db.AccessReviewStatusOpen) {
+		err := ErrBadRequest.New("access review not found or already closed")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+	if err != nil {
+		err := errors.Wrap(err, "error getting access review")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	items, err := svc.GetItems(ctx, in.TenantId, in.CampaignId, "", "")
+	if err != nil {
+		err := errors.Wrap(err, "error getting access review items")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	itemsByID := make(map[string]*db.AccessReviewItem, len(items))
+	for _, item := range items {
+		itemsByID[item.ID] = item
+	}
+
+	for _, d := range in.Decisions {
+		item, ok := itemsByID[d.GetItemId()]
+		problem := ""
+		switch {
+		case !ok:
+			problem = fmt.Sprintf("item %s isn't in the access review", d.GetItemId())
+		case item.PersonID == in.ReviewerId:
+			problem = fmt.Sprintf("item %s is the reviewer's own access", item.ID)
+		case item.ReviewerID != in.ReviewerId && campaign.CreatedBy != in.ReviewerId:
+			problem = fmt.Sprintf("item %s isn't assigned to the reviewer", item.ID)
+		case d.GetDecision() != orchardPb.AccessReviewDecision_Keep && d.GetDecision() != orchardPb.AccessReviewDecision_Revoke:
+			problem = fmt.Sprintf("item %s needs a keep or revoke decision", item.ID)
+		}
+		if problem != "" {
+			err := ErrBadRequest.New(problem)
+			logger.Warn(err.Error())
+			return nil, err.AsGRPC()
+		}
+	}
+
+	tx, err := h.db.NewTransaction(ctx)
+	if err != nil {
+		err := errors.Wrap(err, "error creating access review transaction")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	svc.SetTransaction(tx)
+
+	now := time.Now().UTC()
+	for _, d := range in.Decisions {
+		if err := svc.Decide(ctx, in.TenantId, in.CampaignId, d.ItemId, accessReviewDecision(d.Decision), in.ReviewerId, d.Comment, now); err != nil {
+			svc.Rollback()
+			if err == db.ErrAccessReviewNotOpen {
+				err := ErrBadRequest.New("access review was closed")
+				logger.Warn(err.Error())
+				return nil, err.AsGRPC()
+			}
+			err := errors.Wrap(err, "error recording access review decision")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+	}
+
+	if err := svc.Commit(); err != nil {
+		svc.Rollback()
+		err := errors.Wrap(err, "error commiting access review transaction")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	return &servicePb.RecordAccessReviewDecisionsResponse{}, nil
+}
+
+// CloseAccessReview closes an open campaign. Every item that wasn't kept, rejected or left unreviewed, is revoked in the same
+// transaction: group viewer grants are deleted and system roles taken off the person, and the people who lost access get their
+// bouncer cache busted. It returns the campaign's report.
+func (h *Handlers) CloseAccessReview(ctx context.Context, in *servicePb.CloseAccessReviewRequest) (*servicePb.CloseAccessReviewResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("campaignId", in.CampaignId)
+
+	if in.TenantId == "" || in.CampaignId == "" || in.ClosedBy == "" {
+		err := ErrBadRequest.New("tenantId, campaignId and closedBy can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	tx, err := h.db.NewTransaction(ctx)
+	if err != nil {
+		err := errors.Wrap(err, "error creating access review transaction")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	svc := h.db.NewAccessReviewService()
+	svc.SetTransaction(tx)
+	outboxSvc := h.db.NewOutboxService()
+	outboxSvc.SetTransaction(tx)
+
+	items, err := svc.Close(ctx, in.TenantId, in.CampaignId, in.ClosedBy, time.Now().UTC())
+	if err == db.ErrAccessReviewNotOpen {
+		svc.Rollback()
+		err := ErrBadRequest.New("access review not found or already closed")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+	if err != nil {
+		svc.Rollback()
+		err := errors.Wrap(err, "error closing access review")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	msgs := []*db.OutboxMessage{}
+	busted := map[string]bool{}
+	for _, item := range items {
+		if item.Outcome.String != db.AccessReviewOutcomeRevoked || busted[item.PersonID] {
+			continue
+		}
+		busted[item.PersonID] = true
+		msgs = append(msgs, db.NewBustAuthCacheMessage(in.TenantId, item.PersonID))
+	}
+	if err := outboxSvc.Enqueue(ctx, msgs...); err != nil {
+		svc.Rollback()
+		err := errors.Wrap(err, "error enqueueing auth data cache busts")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	campaign, err := svc.GetCampaign(ctx, in.TenantId, in.CampaignId)
+	if err != nil {
+		svc.Rollback()
+		err := errors.Wrap(err, "error getting access review")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	if err := svc.Commit(); err != nil {
+		svc.Rollback()
+		err := errors.Wrap(err, "error commiting access review transaction")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	report := accessReviewReport(campaign, items)
+	logger.WithCustom("revoked", report.Revoked).WithCustom("kept", report.Kept).Info("closed access review")
+
+	return &servicePb.CloseAccessReviewResponse{
+		Campaign: accessReviewCampaignToProto(campaign),
+		Report:   report,
+	}, nil
+}
+
+// GetAccessReviewReport summarizes a campaign: how many items were kept, revoked, left unreviewed or are still pending, overall
+// and per reviewer
+func (h *Handlers) GetAccessReviewReport(ctx context.Context, in *servicePb.GetAccessReviewReportRequest) (*servicePb.GetAccessReviewReportResponse, error) {
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("campaignId", in.CampaignId)
+
+	if in.TenantId == "" || in.CampaignId == "" {
+		err := ErrBadRequest.New("tenantId and campaignId can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	svc := h.db.NewAccessReviewService()
+	campaign, err := svc.GetCampaign(ctx, in.TenantId, in.CampaignId)
+	if err == sql.ErrNoRows {
+		err := ErrBadRequest.New("access review not found")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+	if err != nil {
+		err := errors.Wrap(err, "error getting access review")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	items, err := svc.GetItems(ctx, in.TenantId, in.CampaignId, "", "")
+	if err != nil {
+		err := errors.Wrap(err, "error getting access review items")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	return &servicePb.GetAccessReviewReportResponse{
+		Campaign: accessReviewCampaignToProto(campaign),
+		Report:   accessReviewReport(campaign, items),
+	}, nil
+}
+
+// accessReviewReport counts a campaign's items by decision, or by outcome once it's closed
+func accessReviewReport(campaign *db.AccessReviewCampaign, items []*db.AccessReviewItem) *orchardPb.AccessReviewReport {
+	report := &orchardPb.AccessReviewReport{
+		CampaignId: campaign.ID,
+		Total:      int64(len(items)),
+	}
+	reviewers := map[string]*orchardPb.AccessReviewReviewerSummary{}
+	for _, item := range items {
+		reviewer, ok := reviewers[item.ReviewerID]
+		if !ok {
+			reviewer = &orchardPb.AccessReviewReviewerSummary{ReviewerId: item.ReviewerID}
+			reviewers[item.ReviewerID] = reviewer
+		}
+		reviewer.Total++
+
+		switch item.Decision {
+		case db.AccessReviewDecisionKeep:
+			reviewer.Kept++
+		case db.AccessReviewDecisionRevoke:
+			reviewer.Revoked++
+		default:
+			reviewer.Pending++
+		}
+
+		if campaign.Status == db.AccessReviewStatusOpen {
+			switch item.Decision {
+			case db.AccessReviewDecisionKeep:
+				report.Kept++
+			case db.AccessReviewDecisionRevoke:
+				report.Revoked++
+			default:
+				report.Pending++
+			}
+			continue
+		}
+
+		switch item.Outcome.String {
+		case db.AccessReviewOutcomeKept:
+			report.Kept++
+		case db.AccessReviewOutcomeRevoked:
+			report.Revoked++
+			if item.Decision == db.AccessReviewDecisionPending {
+				report.RevokedUnreviewed++
+			}
+		case db.AccessReviewOutcomeGone:
+			report.AlreadyRemoved++
+		}
+	}
+
+	for _, reviewer := range reviewers {
+		report.Reviewers = append(report.Reviewers, reviewer)
+	}
+	sort.Slice(report.Reviewers, func(i, j int) bool { return report.Reviewers[i].ReviewerId < report.Reviewers[j].ReviewerId })
+
+	return report
+}
+
+func accessReviewCampaignToProto(campaign *db.AccessReviewCampaign) *orchardPb.AccessReviewCampaign {
+	res := &orchardPb.AccessReviewCampaign{
+		Id:        campaign.ID,
+		TenantId:  campaign.TenantID,
+		Name:      campaign.Name,
+		Status:    campaign.Status,
+		CreatedBy: campaign.CreatedBy,
+		CreatedAt: timestamppb.New(campaign.CreatedAt),
+		ClosedBy:  campaign.ClosedBy.String,
+	}
+	for _, typ := range campaign.SystemRoleTypes {
+		res.SystemRoleTypes = append(res.SystemRoleTypes, db.SystemRoleTypeToProto(typ))
+	}
+	if campaign.DueAt.Valid {
+		res.DueAt = timestamppb.New(campaign.DueAt.Time)
+	}
+	if campaign.ClosedAt.Valid {
+		res.ClosedAt = timestamppb.New(campaign.ClosedAt.Time)
+	}
+	return res
+}
+
+func accessReviewItemToProto(item *db.AccessReviewItem) *orchardPb.AccessReviewItem {
+	res := &orchardPb.AccessReviewItem{
+		Id:           item.ID,
+		CampaignId:   item.CampaignID,
+		PersonId:     item.PersonID,
+		GroupId:      item.GroupID.String,
+		SystemRoleId: item.SystemRoleID.String,
+		Permissions:  item.Permissions,
+		ReviewerId:   item.ReviewerID,
+		DecidedBy:    item.DecidedBy.String,
+		Comment:      item.Comment.String,
+		Outcome:      item.Outcome.String,
+	}
+	switch item.Kind {
+	case db.AccessReviewKindGroupViewer:
+		res.Kind = orchardPb.AccessReviewItemKind_GroupViewerGrant
+		res.NamedPermissions = permcatalog.Named(authPb.PermissionSet_Group, item.Permissions)
+	case db.AccessReviewKindSystemRole:
+		res.Kind = orchardPb.AccessReviewItemKind_SystemRoleAssignment
+	}
+	switch item.Decision {
+	case db.AccessReviewDecisionKeep:
+		res.Decision = orchardPb.AccessReviewDecision_Keep
+	case db.AccessReviewDecisionRevoke:
+		res.Decision = orchardPb.AccessReviewDecision_Revoke
+	default:
+		res.Decision = orchardPb.AccessReviewDecision_Pending
+	}
+	if item.DecidedAt.Valid {
+		res.DecidedAt = timestamppb.New(item.DecidedAt.Time)
+	}
+	return res
+}
+
+func accessReviewDecision(decision orchardPb.AccessReviewDecision) string {
+	if decision == orchardPb.AccessReviewDecision_Keep {
+		return db.AccessReviewDecisionKeep
+	}
+	return db.AccessReviewDecisionRevoke
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/loupe-co/go-common/fixtures"
+	"github.com/loupe-co/orchard/internal/db"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+)
+
+const seedPatID = "527470d1-d895-49f3-a9d4-48d8e37f6317"
+
+func TestAccessReviewCloseRevokes(t *testing.T) {
+	h, fakes, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	// two people in CS with a manager role who report to Will, so Will reviews their access, one also views EMEA AEs
+	data, err := insertTestData(store, "access_review", "TestAccessReviewCloseRevokes", "store")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	managerRole, kept, unreviewed := data.SystemRoles[0], data.People[0], data.People[1]
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["access_review"], "TestAccessReviewCloseRevokes", "open_request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	openReq := &servicePb.OpenAccessReviewRequest{}
+	if err := json.Unmarshal(testData, openReq); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	opened, err := h.OpenAccessReview(ctx, openReq)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	campaignID := opened.Campaign.Id
+	if _, err := h.OpenAccessReview(ctx, openReq); err == nil || !strings.Contains(err.Error(), "Bad Request") {
+		t.Log("expected a second open campaign to be a bad request, but got", err)
+		t.Fail()
+		return
+	}
+
+	testData, _, _, err = jsonparser.Get(fixtures.Data["access_review"], "TestAccessReviewCloseRevokes", "items_request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	itemsReq := &servicePb.GetAccessReviewItemsRequest{}
+	if err := json.Unmarshal(testData, itemsReq); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	itemsReq.CampaignId = campaignID
+	itemsRes, err := h.GetAccessReviewItems(ctx, itemsReq)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	var keptGrant, keptRole, unreviewedRole *orchardPb.AccessReviewItem
+	for _, item := range itemsRes.Items {
+		switch {
+		case item.PersonId == kept.ID && item.GroupId == seedEMEAAEsID:
+			keptGrant = item
+		case item.PersonId == kept.ID && item.SystemRoleId == managerRole.ID:
+			keptRole = item
+		case item.PersonId == unreviewed.ID && item.SystemRoleId == managerRole.ID:
+			unreviewedRole = item
+		}
+	}
+	if keptGrant == nil || keptRole == nil || unreviewedRole == nil {
+		t.Log("expected Will to review both people's manager role and the grant, but got", itemsRes.Items)
+		t.Fail()
+		return
+	}
+
+	decide := func(reviewerID string, decisions ...*orchardPb.AccessReviewItemDecision) error {
+		_, err := h.RecordAccessReviewDecisions(ctx, &servicePb.RecordAccessReviewDecisionsRequest{
+			TenantId:   db.DefaultTenantID,
+			CampaignId: campaignID,
+			ReviewerId: reviewerID,
+			Decisions:  decisions,
+		})
+		return err
+	}
+	if err := decide(kept.ID, &orchardPb.AccessReviewItemDecision{ItemId: keptGrant.Id, Decision: orchardPb.AccessReviewDecision_Keep}); err == nil || !strings.Contains(err.Error(), "Bad Request") {
+		t.Log("expected a person deciding on their own access to be a bad request, but got", err)
+		t.Fail()
+		return
+	}
+	if err := decide(seedOliviaID, &orchardPb.AccessReviewItemDecision{ItemId: keptGrant.Id, Decision: orchardPb.AccessReviewDecision_Keep}); err == nil || !strings.Contains(err.Error(), "Bad Request") {
+		t.Log("expected someone who isn't the reviewer to be a bad request, but got", err)
+		t.Fail()
+		return
+	}
+	err = decide(seedWillID,
+		&orchardPb.AccessReviewItemDecision{ItemId: keptGrant.Id, Decision: orchardPb.AccessReviewDecision_Keep},
+		&orchardPb.AccessReviewItemDecision{ItemId: keptRole.Id, Decision: orchardPb.AccessReviewDecision_Revoke, Comment: "not a manager anymore"},
+	)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	testData, _, _, err = jsonparser.Get(fixtures.Data["access_review"], "TestAccessReviewCloseRevokes", "close_request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	closeReq := &servicePb.CloseAccessReviewRequest{}
+	if err := json.Unmarshal(testData, closeReq); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	closeReq.CampaignId = campaignID
+
+	fakes.Reset()
+	closed, err := h.CloseAccessReview(ctx, closeReq)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if closed.Report.Kept < 1 || closed.Report.Revoked < 2 || closed.Report.RevokedUnreviewed < 1 {
+		t.Log("expected the kept grant and both revoked roles in the report, but got", closed.Report)
+		t.Fail()
+		return
+	}
+
+	people, err := store.NewPersonService().GetByIDs(ctx, db.DefaultTenantID, kept.ID, unreviewed.ID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	for _, p := range people {
+		if len(p.RoleIds) != 0 || !p.RoleIdsLocked {
+			t.Logf("expected %s to lose the manager role and have their roles locked, but got %v locked %t", p.Name.String, p.RoleIds, p.RoleIdsLocked)
+			t.Fail()
+			return
+		}
+	}
+	gvs, err := store.NewGroupViewerService().GetPersonsViewableGroups(ctx, db.DefaultTenantID, kept.ID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(gvs) != 1 || gvs[0].GroupID != seedEMEAAEsID {
+		t.Log("expected the kept grant to stay, but got", gvs)
+		t.Fail()
+		return
+	}
+
+	if _, err := h.DispatchOutbox(ctx); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	busted := map[string]bool{}
+	for _, id := range fakes.Bouncer.BustedUserIDs(db.DefaultTenantID) {
+		busted[id] = true
+	}
+	if !busted[kept.ID] || !busted[unreviewed.ID] {
+		t.Log("expected the people who lost a role to be busted, but got", busted)
+		t.Fail()
+		return
+	}
+
+	if err := decide(seedWillID, &orchardPb.AccessReviewItemDecision{ItemId: keptGrant.Id, Decision: orchardPb.AccessReviewDecision_Revoke}); err == nil || !strings.Contains(err.Error(), "Bad Request") {
+		t.Log("expected deciding on a closed campaign to be a bad request, but got", err)
+		t.Fail()
+		return
+	}
+	if _, err := h.CloseAccessReview(ctx, closeReq); err == nil || !strings.Contains(err.Error(), "Bad Request") {
+		t.Log("expected closing a closed campaign to be a bad request, but got", err)
+		t.Fail()
+		return
+	}
+
+	// Unlocked again, the person still doesn't get the revoked role back from a rule that matches them
+	kept.RoleIdsLocked = false
+	if err := store.NewPersonService().Update(ctx, kept, []string{"role_ids_locked"}); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	testData, _, _, err = jsonparser.Get(fixtures.Data["access_review"], "TestAccessReviewCloseRevokes", "preview_request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	previewReq := &servicePb.PreviewRoleAssignmentRulesRequest{}
+	if err := json.Unmarshal(testData, previewReq); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	previewReq.Rules[0].Kind = orchardPb.RoleAssignmentRuleKind_GroupType
+	preview, err := h.PreviewRoleAssignmentRules(ctx, previewReq)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	for _, change := range append(preview.Changes, preview.Locked...) {
+		for _, id := range change.AddedRoleIds {
+			if id == managerRole.ID {
+				t.Logf("expected the rules not to give %s back the role an access review revoked", change.Name)
+				t.Fail()
+				return
+			}
+		}
+	}
+
+	rawResult, err := json.MarshalIndent(closed, "", "  ")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if err := fixtures.WriteTestResult("../../fixtures/results/TestAccessReviewCloseRevokes.json", rawResult); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+}
+
+func TestAccessReviewBadRequest(t *testing.T) {
+	h, _, _, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["access_review"], "TestAccessReviewBadRequest")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	requests := struct {
+		Open   []*servicePb.OpenAccessReviewRequest      `json:"open_requests"`
+		Close  []*servicePb.CloseAccessReviewRequest     `json:"close_requests"`
+		Report []*servicePb.GetAccessReviewReportRequest `json:"report_requests"`
+	}{}
+	if err := json.Unmarshal(testData, &requests); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	errs := []error{}
+	for _, req := range requests.Open {
+		_, err := h.OpenAccessReview(ctx, req)
+		errs = append(errs, err)
+	}
+	for _, req := range requests.Close {
+		_, err := h.CloseAccessReview(ctx, req)
+		errs = append(errs, err)
+	}
+	for _, req := range requests.Report {
+		_, err := h.GetAccessReviewReport(ctx, req)
+		errs = append(errs, err)
+	}
+
+	for i, err := range errs {
+		if err == nil {
+			t.Logf("expected request %d to be a bad request, but got nil error", i)
+			t.Fail()
+			return
+		}
+		if !strings.Contains(err.Error(), "Bad Request") {
+			t.Log("expected error to contain 'Bad Request', but got", err)
+			t.Fail()
+			return
+		}
+	}
+}
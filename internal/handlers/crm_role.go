@@ -11,6 +11,7 @@ import (
 )
 
 func (h *Handlers) SyncCrmRoles(ctx context.Context, in *servicePb.SyncRequest) (*servicePb.SyncResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	ctx, span := log.StartSpan(ctx, "SyncCrmRoles")
 	defer span.End()
 
@@ -46,7 +47,7 @@ func (h *Handlers) SyncCrmRoles(ctx context.Context, in *servicePb.SyncRequest)
 	for {
 		logger.Debug("getting next page of crm roles")
 		var latestCRMRoles []*orchardPb.CRMRole
-		latestCRMRoles, total, nextToken, err = h.crmClient.GetLatestCRMRoles(ctx, in.TenantId, in.SyncSince, batchSize, nextToken)
+		latestCRMRoles, total, nextToken, err = h.crmDataSource.GetLatestCRMRoles(ctx, in.TenantId, in.SyncSince, batchSize, nextToken)
 		if err != nil {
 			err := errors.Wrap(err, "error getting latest crm roles from crm-data-access")
 			logger.Error(err)
@@ -87,6 +88,7 @@ func (h *Handlers) SyncCrmRoles(ctx context.Context, in *servicePb.SyncRequest)
 }
 
 func (h *Handlers) UpsertCRMRoles(ctx context.Context, in *servicePb.UpsertCRMRolesRequest) (*servicePb.UpsertCRMRolesResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
 
 	if in.TenantId == "" {
@@ -270,6 +272,7 @@ func (h *Handlers) GetUnsyncedCRMRoles(ctx context.Context, in *servicePb.GetUns
 }
 
 func (h *Handlers) DeleteCRMRoleById(ctx context.Context, in *servicePb.IdRequest) (*servicePb.Empty, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("id", in.Id)
 
 	if in.Id == "" {
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/db"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+)
+
+const (
+	defaultDirectoryLimit = 20
+	maxDirectoryLimit     = 100
+	minDirectoryQuery     = 2
+)
+
+// SearchDirectory searches the people and groups the caller can see in one ranked list. Matching is fuzzy, so partial names and
+// small typos still match, and every result says which of its fields matched the query and where.
+func (h *Handlers) SearchDirectory(ctx context.Context, in *servicePb.SearchDirectoryRequest) (*servicePb.SearchDirectoryResponse, error) {
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("personId", in.PersonId).WithCustom("query", in.Query)
+
+	if in.TenantId == "" || in.PersonId == "" {
+		err := ErrBadRequest.New("tenantId and personId can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+	query := strings.TrimSpace(in.Query)
+	if utf8.RuneCountInString(query) < minDirectoryQuery {
+		err := ErrBadRequest.New("query must be at least 2 characters")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	limit := defaultDirectoryLimit
+	if in.Limit > 0 {
+		limit = int(in.Limit)
+	}
+	if limit > maxDirectoryLimit {
+		limit = maxDirectoryLimit
+	}
+
+	search := db.DirectorySearch{
+		Query:    query,
+		ViewerID: in.PersonId,
+		People:   len(in.Types) == 0,
+		Groups:   len(in.Types) == 0,
+		Limit:    limit,
+	}
+	for _, t := range in.Types {
+		switch t {
+		case orchardPb.DirectoryEntityType_Person:
+			search.People = true
+		case orchardPb.DirectoryEntityType_Group:
+			search.Groups = true
+		}
+	}
+
+	hits, err := h.db.NewDirectoryService().Search(ctx, in.TenantId, search)
+	if err != nil {
+		err := errors.Wrap(err, "error searching directory")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	personSvc := h.db.NewPersonService()
+	groupSvc := h.db.NewGroupService()
+	results := make([]*orchardPb.DirectoryResult, len(hits))
+	for i, hit := range hits {
+		result := &orchardPb.DirectoryResult{Score: float32(hit.Score)}
+		switch hit.Type {
+		case db.DirectoryPerson:
+			p, err := personSvc.ToProto(hit.Person)
+			if err != nil {
+				err := errors.Wrap(err, "error converting person db model to proto")
+				logger.Error(err)
+				return nil, err.AsGRPC()
+			}
+			result.Type = orchardPb.DirectoryEntityType_Person
+			result.Person = p
+			result.Highlights = directoryHighlights(query,
+				"name", hit.Person.Name.String,
+				"first_name", hit.Person.FirstName.String,
+				"last_name", hit.Person.LastName.String,
+				"email", hit.Person.Email.String,
+			)
+		case db.DirectoryGroup:
+			g, err := groupSvc.ToProto(hit.Group)
+			if err != nil {
+				err := errors.Wrap(err, "error converting group db model to proto")
+				logger.Error(err)
+				return nil, err.AsGRPC()
+			}
+			result.Type = orchardPb.DirectoryEntityType_Group
+			result.Group = g
+			fields := []string{"name", hit.Group.Name}
+			for _, name := range hit.CRMRoleNames {
+				fields = append(fields, "crm_role_name", name)
+			}
+			result.Highlights = directoryHighlights(query, fields...)
+		}
+		results[i] = result
+	}
+
+	return &servicePb.SearchDirectoryResponse{
+		Results: results,
+	}, nil
+}
+
+// directoryHighlights returns where the words of query appear in each of the field name, value pairs, as rune offsets into the value.
+// A fuzzy match that doesn't contain any query word verbatim has no highlight.
+func directoryHighlights(query string, fields ...string) []*orchardPb.DirectoryHighlight {
+	terms := strings.Fields(strings.ToLower(query))
+	highlights := []*orchardPb.DirectoryHighlight{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		ranges := highlightRanges(fields[i+1], terms)
+		if len(ranges) == 0 {
+			continue
+		}
+		highlights = append(highlights, &orchardPb.DirectoryHighlight{
+			Field:   fields[i],
+			Value:   fields[i+1],
+			Matches: ranges,
+		})
+	}
+	return highlights
+}
+
+// highlightRanges finds every case insensitive occurrence of terms in value and merges overlapping ones
+func highlightRanges(value string, terms []string) []*orchardPb.TextRange {
+	runes := []rune(strings.ToLower(value))
+	type span struct{ start, end int }
+	spans := []span{}
+	for _, term := range terms {
+		t := []rune(term)
+		for start := 0; start+len(t) <= len(runes); start++ {
+			if string(runes[start:start+len(t)]) == term {
+				spans = append(spans, span{start, start + len(t)})
+			}
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	ranges := []*orchardPb.TextRange{}
+	for _, s := range spans {
+		if n := len(ranges); n > 0 && int(ranges[n-1].End) >= s.start {
+			if int32(s.end) > ranges[n-1].End {
+				ranges[n-1].End = int32(s.end)
+			}
+			continue
+		}
+		ranges = append(ranges, &orchardPb.TextRange{Start: int32(s.start), End: int32(s.end)})
+	}
+	return ranges
+}
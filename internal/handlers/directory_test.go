@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/loupe-co/go-common/fixtures"
+	"github.com/loupe-co/orchard/internal/models"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+)
+
+// Ids from fixtures/seed.json
+const (
+	seedSalesLeadersID = "ffa46973-1f28-41d8-9cee-dfadb51eeae2"
+	seedCSManagersID   = "dde9266a-bb8b-48a3-bd2c-7b6c15cbf6bf"
+	seedCSID           = "92660e79-1e32-416a-8e76-56786a5b11f0"
+	seedEnterpriseMgrs = "5ae01940-0353-4f93-8d5b-65646a3977d7"
+	seedEnterpriseAEs  = "d75a63b2-6ac8-4216-8bef-42fa788ff5f9"
+	seedEMEAAEsID      = "76fbf5a2-d513-414a-b97e-900e0fbcd90d"
+	seedWillID         = "740adf33-2db0-46f8-924f-4c604408b866"
+	seedOliviaID       = "59a9024b-8466-4c43-b734-b1e2b2907418"
+)
+
+func TestSearchDirectoryRanksAndScopesResults(t *testing.T) {
+	h, _, _, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// Will views the CS Managers subtree
+	testData, _, _, err := jsonparser.Get(fixtures.Data["directory"], "TestSearchDirectoryRanksAndScopesResults")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	req := &servicePb.SearchDirectoryRequest{}
+	if err := json.Unmarshal(testData, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	res, err := h.SearchDirectory(context.Background(), req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	groupIDs := map[string]bool{}
+	for i, result := range res.Results {
+		if i > 0 && result.Score > res.Results[i-1].Score {
+			t.Logf("expected results ordered by score, but result %d scores %f, more than the %f before it", i, result.Score, res.Results[i-1].Score)
+			t.Fail()
+			return
+		}
+		if result.Type != orchardPb.DirectoryEntityType_Group {
+			continue
+		}
+		groupIDs[result.Group.Id] = true
+		if len(result.Highlights) == 0 || result.Highlights[0].Field != "name" || result.Highlights[0].Matches[0].Start != 0 {
+			t.Logf("expected %s to highlight the start of its name, but got %v", result.Group.Name, result.Highlights)
+			t.Fail()
+			return
+		}
+	}
+	if len(groupIDs) != 2 || !groupIDs[seedCSManagersID] || !groupIDs[seedCSID] {
+		t.Log("expected only the CS groups in Will's subtree, but got", groupIDs)
+		t.Fail()
+		return
+	}
+
+	rawResult, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if err := fixtures.WriteTestResult("../../fixtures/results/TestSearchDirectoryRanksAndScopesResults.json", rawResult); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+}
+
+func TestSearchDirectoryHidesGroupsOutsideTheViewersSubtrees(t *testing.T) {
+	h, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["directory"], "TestSearchDirectoryHidesGroupsOutsideTheViewersSubtrees", "request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	req := &servicePb.SearchDirectoryRequest{}
+	if err := json.Unmarshal(testData, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	req.Types = []orchardPb.DirectoryEntityType{orchardPb.DirectoryEntityType_Group}
+
+	res, err := h.SearchDirectory(ctx, req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(res.Results) != 0 {
+		t.Logf("expected no enterprise groups outside of Will's subtrees, but got %d", len(res.Results))
+		t.Fail()
+		return
+	}
+
+	// Will starts viewing Sales Leaders
+	viewerData, _, _, err := jsonparser.Get(fixtures.Data["directory"], "TestSearchDirectoryHidesGroupsOutsideTheViewersSubtrees", "group_viewer")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	viewer := &models.GroupViewer{}
+	if err := json.Unmarshal(viewerData, viewer); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if err := store.NewGroupViewerService().Insert(ctx, viewer); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	res, err = h.SearchDirectory(ctx, req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(res.Results) != 2 {
+		t.Logf("expected both enterprise groups once Will views Sales Leaders, but got %d", len(res.Results))
+		t.Fail()
+		return
+	}
+	for _, result := range res.Results {
+		if result.Group.Id != seedEnterpriseMgrs && result.Group.Id != seedEnterpriseAEs {
+			t.Log("expected only the enterprise groups, but got", result.Group.Name)
+			t.Fail()
+			return
+		}
+	}
+}
+
+func TestSearchDirectoryPeopleHighlights(t *testing.T) {
+	h, _, _, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["directory"], "TestSearchDirectoryPeopleHighlights")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	req := &servicePb.SearchDirectoryRequest{}
+	if err := json.Unmarshal(testData, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	req.Types = []orchardPb.DirectoryEntityType{orchardPb.DirectoryEntityType_Person}
+
+	res, err := h.SearchDirectory(context.Background(), req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(res.Results) != 1 || res.Results[0].Person.Id != seedOliviaID {
+		t.Log("expected Olivia, who is in CS Managers, but got", res.Results)
+		t.Fail()
+		return
+	}
+
+	fields := map[string]*orchardPb.DirectoryHighlight{}
+	for _, highlight := range res.Results[0].Highlights {
+		fields[highlight.Field] = highlight
+	}
+	for _, field := range []string{"name", "first_name", "email"} {
+		highlight, ok := fields[field]
+		if !ok {
+			t.Logf("expected %s to be highlighted", field)
+			t.Fail()
+			return
+		}
+		if len(highlight.Matches) != 1 || highlight.Matches[0].Start != 0 || highlight.Matches[0].End != 6 {
+			t.Logf("expected %s to match its first 6 runes, but got %v", field, highlight.Matches)
+			t.Fail()
+			return
+		}
+	}
+	if _, ok := fields["last_name"]; ok {
+		t.Log("expected last_name not to be highlighted")
+		t.Fail()
+		return
+	}
+
+	rawResult, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if err := fixtures.WriteTestResult("../../fixtures/results/TestSearchDirectoryPeopleHighlights.json", rawResult); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+}
+
+func TestSearchDirectoryBadRequest(t *testing.T) {
+	h, _, _, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["directory"], "TestSearchDirectoryBadRequest")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	requests := []*servicePb.SearchDirectoryRequest{}
+	if err := json.Unmarshal(testData, &requests); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	for _, req := range requests {
+		_, err := h.SearchDirectory(context.Background(), req)
+		if err == nil {
+			t.Logf("expected %v to be a bad request, but got nil error", req)
+			t.Fail()
+			return
+		}
+		if !strings.Contains(err.Error(), "Bad Request") {
+			t.Log("expected error to contain 'Bad Request', but got", err)
+			t.Fail()
+			return
+		}
+	}
+}
+
+func TestHighlightRangesMergesOverlaps(t *testing.T) {
+	ranges := highlightRanges("Anna Annabelle", []string{"ann", "nna"})
+	if len(ranges) != 2 {
+		t.Log("expected 2 merged ranges, but got", ranges)
+		t.Fail()
+		return
+	}
+	if ranges[0].Start != 0 || ranges[0].End != 4 || ranges[1].Start != 5 || ranges[1].End != 9 {
+		t.Log("expected the overlapping matches to be merged, but got", ranges)
+		t.Fail()
+		return
+	}
+}
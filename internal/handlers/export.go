@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/go-loupe-logger/log"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+)
+
+const (
+	defaultExportChunkSize = 500
+	maxExportChunkSize     = 5000
+	exportCursorPeople     = "people"
+	exportCursorGroups     = "groups"
+)
+
+func exportChunkSize(chunkSize int32) int {
+	if chunkSize <= 0 {
+		return defaultExportChunkSize
+	}
+	if chunkSize > maxExportChunkSize {
+		return maxExportChunkSize
+	}
+	return int(chunkSize)
+}
+
+// encodeExportCursor makes the opaque resume cursor sent with every chunk, it is the id of the chunk's last row
+func encodeExportCursor(kind, lastID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(kind + ":" + lastID))
+}
+
+// decodeExportCursor returns the id to resume after, it is false for a cursor that isn't from an export of kind
+func decodeExportCursor(kind, cursor string) (string, bool) {
+	if cursor == "" {
+		return "", true
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", false
+	}
+	return strings.CutPrefix(string(raw), kind+":")
+}
+
+// ExportPeople streams the tenant's people matching the same search and filters as SearchPeople in chunks ordered by id.
+// Each chunk carries a cursor, passing the last received cursor resumes an interrupted export after that chunk. Rows are
+// read page by page rather than in one snapshot, so people created behind the cursor during an export are not included.
+func (h *Handlers) ExportPeople(in *servicePb.ExportPeopleRequest, stream servicePb.Orchard_ExportPeopleServer) error {
+	ctx := stream.Context()
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("search", in.Search).WithCustom("chunkSize", in.ChunkSize)
+
+	if in.TenantId == "" {
+		err := ErrBadRequest.New("tenantId can't be empty")
+		logger.Warn(err.Error())
+		return err.AsGRPC()
+	}
+
+	afterID, ok := decodeExportCursor(exportCursorPeople, in.Cursor)
+	if !ok {
+		err := ErrBadRequest.New("invalid cursor")
+		logger.Warn(err.Error())
+		return err.AsGRPC()
+	}
+
+	dbFilters, err := personFiltersToDB(in.Filters, in.Where)
+	if err != nil {
+		err := ErrBadRequest.New(err.Error())
+		logger.Warn(err.Error())
+		return err.AsGRPC()
+	}
+
+	chunkSize := exportChunkSize(in.ChunkSize)
+	svc := h.db.NewPersonService()
+	gvSvc := h.db.NewGroupViewerService()
+
+	for {
+		peeps, err := svc.SearchAfter(ctx, in.TenantId, in.Search, afterID, chunkSize, dbFilters...)
+		if err != nil {
+			err := errors.Wrap(err, "error reading people for export")
+			logger.Error(err)
+			return err.AsGRPC()
+		}
+		if len(peeps) == 0 {
+			return nil
+		}
+
+		peepIds := make([]string, len(peeps))
+		for i, peep := range peeps {
+			peepIds[i] = peep.ID
+		}
+		peepsViewableGroups, err := gvSvc.GetPersonsViewableGroups(ctx, in.TenantId, peepIds...)
+		if err != nil {
+			err := errors.Wrap(err, "error querying group viewers for export")
+			logger.Error(err)
+			return err.AsGRPC()
+		}
+		peepGroupIds := map[string][]string{}
+		for _, peepViewableGroup := range peepsViewableGroups {
+			peepGroupIds[peepViewableGroup.PersonID] = append(peepGroupIds[peepViewableGroup.PersonID], peepViewableGroup.GroupID)
+		}
+
+		people := make([]*orchardPb.Person, len(peeps))
+		for i, peep := range peeps {
+			p, err := svc.ToProto(peep)
+			if err != nil {
+				err := errors.Wrap(err, "error converting person db model to proto")
+				logger.Error(err)
+				return err.AsGRPC()
+			}
+			p.GroupViewerIds = peepGroupIds[peep.ID]
+			people[i] = p
+		}
+
+		afterID = peeps[len(peeps)-1].ID
+		if err := stream.Send(&servicePb.ExportPeopleResponse{People: people, Cursor: encodeExportCursor(exportCursorPeople, afterID)}); err != nil {
+			return err
+		}
+		if len(peeps) < chunkSize {
+			return nil
+		}
+	}
+}
+
+// ExportGroups streams the tenant's groups whose name matches search in chunks ordered by id, resumable like ExportPeople
+func (h *Handlers) ExportGroups(in *servicePb.ExportGroupsRequest, stream servicePb.Orchard_ExportGroupsServer) error {
+	ctx := stream.Context()
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("search", in.Search).WithCustom("chunkSize", in.ChunkSize)
+
+	if in.TenantId == "" {
+		err := ErrBadRequest.New("tenantId can't be empty")
+		logger.Warn(err.Error())
+		return err.AsGRPC()
+	}
+
+	afterID, ok := decodeExportCursor(exportCursorGroups, in.Cursor)
+	if !ok {
+		err := ErrBadRequest.New("invalid cursor")
+		logger.Warn(err.Error())
+		return err.AsGRPC()
+	}
+
+	chunkSize := exportChunkSize(in.ChunkSize)
+	svc := h.db.NewGroupService()
+
+	for {
+		gs, err := svc.SearchAfter(ctx, in.TenantId, in.Search, afterID, chunkSize)
+		if err != nil {
+			err := errors.Wrap(err, "error reading groups for export")
+			logger.Error(err)
+			return err.AsGRPC()
+		}
+		if len(gs) == 0 {
+			return nil
+		}
+
+		groups := make([]*orchardPb.Group, len(gs))
+		for i, g := range gs {
+			group, err := svc.ToProto(g)
+			if err != nil {
+				err := errors.Wrap(err, "error converting group db model to proto")
+				logger.Error(err)
+				return err.AsGRPC()
+			}
+			groups[i] = group
+		}
+
+		afterID = gs[len(gs)-1].ID
+		if err := stream.Send(&servicePb.ExportGroupsResponse{Groups: groups, Cursor: encodeExportCursor(exportCursorGroups, afterID)}); err != nil {
+			return err
+		}
+		if len(gs) < chunkSize {
+			return nil
+		}
+	}
+}
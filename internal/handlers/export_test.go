@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/loupe-co/go-common/fixtures"
+	"github.com/loupe-co/orchard/internal/db"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testExportPeopleStream struct {
+	grpc.ServerStream
+	chunks []*servicePb.ExportPeopleResponse
+}
+
+func (stream *testExportPeopleStream) Context() context.Context {
+	return context.Background()
+}
+
+func (stream *testExportPeopleStream) Send(res *servicePb.ExportPeopleResponse) error {
+	stream.chunks = append(stream.chunks, res)
+	return nil
+}
+
+func (stream *testExportPeopleStream) ids() []string {
+	ids := []string{}
+	for _, chunk := range stream.chunks {
+		for _, p := range chunk.People {
+			ids = append(ids, p.Id)
+		}
+	}
+	return ids
+}
+
+func (stream *testExportPeopleStream) sizes() string {
+	sizes := make([]string, len(stream.chunks))
+	for i, chunk := range stream.chunks {
+		sizes[i] = fmt.Sprint(len(chunk.People))
+	}
+	return strings.Join(sizes, ",")
+}
+
+type testExportGroupsStream struct {
+	grpc.ServerStream
+	chunks []*servicePb.ExportGroupsResponse
+}
+
+func (stream *testExportGroupsStream) Context() context.Context {
+	return context.Background()
+}
+
+func (stream *testExportGroupsStream) Send(res *servicePb.ExportGroupsResponse) error {
+	stream.chunks = append(stream.chunks, res)
+	return nil
+}
+
+func (stream *testExportGroupsStream) ids() []string {
+	ids := []string{}
+	for _, chunk := range stream.chunks {
+		for _, g := range chunk.Groups {
+			ids = append(ids, g.Id)
+		}
+	}
+	return ids
+}
+
+func (stream *testExportGroupsStream) sizes() string {
+	sizes := make([]string, len(stream.chunks))
+	for i, chunk := range stream.chunks {
+		sizes[i] = fmt.Sprint(len(chunk.Groups))
+	}
+	return strings.Join(sizes, ",")
+}
+
+// setupExport runs the handlers on a memory store holding only the seed, so the chunk boundaries are known
+func setupExport() (*Handlers, error) {
+	store := db.NewMemoryStore()
+	if err := seed(store); err != nil {
+		return nil, err
+	}
+	h, _ := NewWithFakes(testConfig, store)
+	return h, nil
+}
+
+func isSortedUnique(ids []string) bool {
+	for i := 1; i < len(ids); i++ {
+		if ids[i-1] >= ids[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestExportPeopleChunks(t *testing.T) {
+	h, err := setupExport()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// the seed has 10 people in the default tenant, a last chunk that comes back full is followed by no empty chunk
+	expected := map[int32]string{
+		1:  "1,1,1,1,1,1,1,1,1,1",
+		4:  "4,4,2",
+		5:  "5,5",
+		10: "10",
+		11: "10",
+	}
+	for chunkSize, sizes := range expected {
+		stream := &testExportPeopleStream{}
+		if err := h.ExportPeople(&servicePb.ExportPeopleRequest{TenantId: db.DefaultTenantID, ChunkSize: chunkSize}, stream); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if stream.sizes() != sizes {
+			t.Logf("expected chunks of %s with a chunk size of %d, but got %s", sizes, chunkSize, stream.sizes())
+			t.Fail()
+			return
+		}
+		if ids := stream.ids(); len(ids) != 10 || !isSortedUnique(ids) {
+			t.Logf("expected every person once ordered by id with a chunk size of %d, but got %v", chunkSize, ids)
+			t.Fail()
+			return
+		}
+	}
+}
+
+func TestExportPeopleResume(t *testing.T) {
+	h, err := setupExport()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	full := &testExportPeopleStream{}
+	if err := h.ExportPeople(&servicePb.ExportPeopleRequest{TenantId: db.DefaultTenantID, ChunkSize: 4}, full); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// picks up after the first chunk as if the stream broke once it was received
+	resumed := &testExportPeopleStream{}
+	if err := h.ExportPeople(&servicePb.ExportPeopleRequest{TenantId: db.DefaultTenantID, ChunkSize: 4, Cursor: full.chunks[0].Cursor}, resumed); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if resumed.sizes() != "4,2" || strings.Join(resumed.ids(), ",") != strings.Join(full.ids()[4:], ",") {
+		t.Logf("expected the resumed export to continue after the first chunk, but got chunks of %s", resumed.sizes())
+		t.Fail()
+		return
+	}
+	if resumed.chunks[len(resumed.chunks)-1].Cursor != full.chunks[len(full.chunks)-1].Cursor {
+		t.Log("expected the resumed export to end on the same cursor as the full one")
+		t.Fail()
+		return
+	}
+
+	// resuming after the last chunk has nothing left to send
+	done := &testExportPeopleStream{}
+	if err := h.ExportPeople(&servicePb.ExportPeopleRequest{TenantId: db.DefaultTenantID, ChunkSize: 4, Cursor: full.chunks[len(full.chunks)-1].Cursor}, done); err != nil || len(done.chunks) != 0 {
+		t.Log("expected resuming a finished export to send nothing, but got", done.sizes(), err)
+		t.Fail()
+		return
+	}
+}
+
+func TestExportInvalidCursor(t *testing.T) {
+	h, err := setupExport()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	groups := &testExportGroupsStream{}
+	if err := h.ExportGroups(&servicePb.ExportGroupsRequest{TenantId: db.DefaultTenantID, ChunkSize: 2}, groups); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	people := &testExportPeopleStream{}
+	if err := h.ExportPeople(&servicePb.ExportPeopleRequest{TenantId: db.DefaultTenantID, ChunkSize: 2}, people); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	for _, cursor := range []string{groups.chunks[0].Cursor, "not a cursor", encodeExportCursor("persons", people.ids()[1])} {
+		err := h.ExportPeople(&servicePb.ExportPeopleRequest{TenantId: db.DefaultTenantID, Cursor: cursor}, &testExportPeopleStream{})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Logf("expected people export to refuse cursor %q, but got %v", cursor, err)
+			t.Fail()
+			return
+		}
+	}
+	for _, cursor := range []string{people.chunks[0].Cursor, "not a cursor"} {
+		err := h.ExportGroups(&servicePb.ExportGroupsRequest{TenantId: db.DefaultTenantID, Cursor: cursor}, &testExportGroupsStream{})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Logf("expected group export to refuse cursor %q, but got %v", cursor, err)
+			t.Fail()
+			return
+		}
+	}
+
+	if err := h.ExportPeople(&servicePb.ExportPeopleRequest{}, &testExportPeopleStream{}); status.Code(err) != codes.InvalidArgument {
+		t.Log("expected people export without a tenant to be refused, but got", err)
+		t.Fail()
+		return
+	}
+	if err := h.ExportGroups(&servicePb.ExportGroupsRequest{}, &testExportGroupsStream{}); status.Code(err) != codes.InvalidArgument {
+		t.Log("expected group export without a tenant to be refused, but got", err)
+		t.Fail()
+		return
+	}
+}
+
+func TestExportPeopleFilters(t *testing.T) {
+	h, err := setupExport()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	for _, name := range []string{"TestExportPeopleFilters", "TestExportPeopleNestedFilters"} {
+		testData, _, _, err := jsonparser.Get(fixtures.Data["export"], name)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		req := &servicePb.ExportPeopleRequest{}
+		if err := json.Unmarshal(testData, req); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+
+		stream := &testExportPeopleStream{}
+		if err := h.ExportPeople(req, stream); err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+
+		// the export finds exactly what the same search finds
+		searchRes, err := h.SearchPeople(context.Background(), &servicePb.SearchPeopleRequest{
+			TenantId: req.TenantId,
+			Search:   req.Search,
+			Filters:  req.Filters,
+			Where:    req.Where,
+			PageSize: -1,
+		})
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		expected := []string{}
+		for _, p := range searchRes.People {
+			expected = append(expected, p.Id)
+		}
+		sort.Strings(expected)
+
+		ids := stream.ids()
+		if len(expected) == 0 || len(expected) == 10 || strings.Join(ids, ",") != strings.Join(expected, ",") {
+			t.Logf("%s: expected the export to find the %d people the search does, but got %d", name, len(expected), len(ids))
+			t.Fail()
+			return
+		}
+		for _, chunk := range stream.chunks {
+			if len(chunk.People) > int(req.ChunkSize) {
+				t.Logf("%s: expected chunks of at most %d, but got %d", name, req.ChunkSize, len(chunk.People))
+				t.Fail()
+				return
+			}
+		}
+	}
+
+	bad := &servicePb.ExportPeopleRequest{TenantId: db.DefaultTenantID}
+	if err := json.Unmarshal([]byte(`{"filters": [{ "field": 99, "op": 0, "values": ["ZmFsc2U="] }]}`), bad); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if err := h.ExportPeople(bad, &testExportPeopleStream{}); status.Code(err) != codes.InvalidArgument {
+		t.Log("expected an unknown filter field to be refused, but got", err)
+		t.Fail()
+		return
+	}
+}
+
+func TestExportGroups(t *testing.T) {
+	h, err := setupExport()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// the seed has 11 groups in the default tenant
+	all := &testExportGroupsStream{}
+	if err := h.ExportGroups(&servicePb.ExportGroupsRequest{TenantId: db.DefaultTenantID, ChunkSize: 5}, all); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if all.sizes() != "5,5,1" || !isSortedUnique(all.ids()) {
+		t.Log("expected the 11 groups ordered by id in chunks of 5,5,1, but got", all.sizes())
+		t.Fail()
+		return
+	}
+
+	resumed := &testExportGroupsStream{}
+	if err := h.ExportGroups(&servicePb.ExportGroupsRequest{TenantId: db.DefaultTenantID, ChunkSize: 5, Cursor: all.chunks[1].Cursor}, resumed); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if resumed.sizes() != "1" || resumed.ids()[0] != all.ids()[10] {
+		t.Log("expected the resumed export to send only the last group, but got", resumed.sizes())
+		t.Fail()
+		return
+	}
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["export"], "TestExportGroupsSearch")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	req := &servicePb.ExportGroupsRequest{}
+	if err := json.Unmarshal(testData, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	searched := &testExportGroupsStream{}
+	if err := h.ExportGroups(req, searched); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	names := []string{}
+	for _, chunk := range searched.chunks {
+		for _, g := range chunk.Groups {
+			names = append(names, g.Name)
+		}
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "CS Managers,EMEA Managers,Enterprise Managers,SDR Managers" || searched.sizes() != "3,1" {
+		t.Log("expected the four manager groups in chunks of 3,1, but got", names, searched.sizes())
+		t.Fail()
+		return
+	}
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/loupe-co/orchard/internal/db"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+)
+
+// personFieldColumns are the person columns a request can filter on, anything else is rejected
+var personFieldColumns = map[orchardPb.PersonField]string{
+	orchardPb.PersonField_Id:            "id",
+	orchardPb.PersonField_TenantId:      "tenant_id",
+	orchardPb.PersonField_Name:          "name",
+	orchardPb.PersonField_FirstName:     "first_name",
+	orchardPb.PersonField_LastName:      "last_name",
+	orchardPb.PersonField_Email:         "email",
+	orchardPb.PersonField_ManagerId:     "manager_id",
+	orchardPb.PersonField_GroupId:       "group_id",
+	orchardPb.PersonField_RoleIds:       "role_ids",
+	orchardPb.PersonField_CrmRoleIds:    "crm_role_ids",
+	orchardPb.PersonField_IsProvisioned: "is_provisioned",
+	orchardPb.PersonField_IsSynced:      "is_synced",
+	orchardPb.PersonField_Status:        "status",
+	orchardPb.PersonField_CreatedAt:     "created_at",
+	orchardPb.PersonField_CreatedBy:     "created_by",
+	orchardPb.PersonField_UpdatedAt:     "updated_at",
+	orchardPb.PersonField_UpdatedBy:     "updated_by",
+}
+
+// groupFieldColumns are the group columns a request can filter on, anything else is rejected
+var groupFieldColumns = map[orchardPb.GroupField]string{
+	orchardPb.GroupField_Id:         "id",
+	orchardPb.GroupField_Name:       "name",
+	orchardPb.GroupField_Type:       "type",
+	orchardPb.GroupField_Status:     "status",
+	orchardPb.GroupField_ParentId:   "parent_id",
+	orchardPb.GroupField_RoleIds:    "role_ids",
+	orchardPb.GroupField_CrmRoleIds: "crm_role_ids",
+	orchardPb.GroupField_Order:      "order",
+	orchardPb.GroupField_CreatedAt:  "created_at",
+	orchardPb.GroupField_CreatedBy:  "created_by",
+	orchardPb.GroupField_UpdatedAt:  "updated_at",
+	orchardPb.GroupField_UpdatedBy:  "updated_by",
+}
+
+func filterValuesToDB(rawValues [][]byte) ([]interface{}, error) {
+	vals := make([]interface{}, len(rawValues))
+	for j, raw := range rawValues {
+		if err := json.Unmarshal(raw, &vals[j]); err != nil {
+			return nil, fmt.Errorf("invalid filter value %q", raw)
+		}
+	}
+	return vals, nil
+}
+
+// personFilterToDB converts a PersonFilter from a request to the column, operator and json decoded values PersonService.Search expects
+func personFilterToDB(personField orchardPb.PersonField, op string, rawValues [][]byte) (db.PersonFilter, error) {
+	field, ok := personFieldColumns[personField]
+	if !ok {
+		return db.PersonFilter{}, fmt.Errorf("can't filter people on %s", personField)
+	}
+	vals, err := filterValuesToDB(rawValues)
+	if err != nil {
+		return db.PersonFilter{}, err
+	}
+	return db.PersonFilter{
+		Field:  field,
+		Op:     op,
+		Values: vals,
+	}, nil
+}
+
+func personFilterExprToDB(expr *orchardPb.PersonFilterExpr) (db.Filter, error) {
+	if len(expr.And) == 0 && len(expr.Or) == 0 {
+		return personFilterToDB(expr.Field, expr.Op.String(), expr.Values)
+	}
+	filter := db.Filter{}
+	for _, child := range expr.And {
+		f, err := personFilterExprToDB(child)
+		if err != nil {
+			return db.Filter{}, err
+		}
+		filter.And = append(filter.And, f)
+	}
+	for _, child := range expr.Or {
+		f, err := personFilterExprToDB(child)
+		if err != nil {
+			return db.Filter{}, err
+		}
+		filter.Or = append(filter.Or, f)
+	}
+	return filter, nil
+}
+
+// personFiltersToDB converts a request's flat filters and where expression to validated db filters, they are all ANDed.
+// Its errors are the caller's fault.
+func personFiltersToDB(filters []*orchardPb.PersonFilter, where *orchardPb.PersonFilterExpr) ([]db.PersonFilter, error) {
+	dbFilters := make([]db.PersonFilter, 0, len(filters)+1)
+	for _, f := range filters {
+		dbFilter, err := personFilterToDB(f.Field, f.Op.String(), f.Values)
+		if err != nil {
+			return nil, err
+		}
+		dbFilters = append(dbFilters, dbFilter)
+	}
+	if where != nil {
+		dbFilter, err := personFilterExprToDB(where)
+		if err != nil {
+			return nil, err
+		}
+		dbFilters = append(dbFilters, dbFilter)
+	}
+	if len(dbFilters) == 0 {
+		return nil, nil
+	}
+	if err := db.ValidateFilter(db.Filter{And: dbFilters}, db.PersonFilterColumns); err != nil {
+		return nil, err
+	}
+	return dbFilters, nil
+}
+
+func groupFilterExprToDB(expr *orchardPb.GroupFilterExpr) (db.Filter, error) {
+	if len(expr.And) == 0 && len(expr.Or) == 0 {
+		field, ok := groupFieldColumns[expr.Field]
+		if !ok {
+			return db.Filter{}, fmt.Errorf("can't filter groups on %s", expr.Field)
+		}
+		vals, err := filterValuesToDB(expr.Values)
+		if err != nil {
+			return db.Filter{}, err
+		}
+		return db.Filter{Field: field, Op: expr.Op.String(), Values: vals}, nil
+	}
+	filter := db.Filter{}
+	for _, child := range expr.And {
+		f, err := groupFilterExprToDB(child)
+		if err != nil {
+			return db.Filter{}, err
+		}
+		filter.And = append(filter.And, f)
+	}
+	for _, child := range expr.Or {
+		f, err := groupFilterExprToDB(child)
+		if err != nil {
+			return db.Filter{}, err
+		}
+		filter.Or = append(filter.Or, f)
+	}
+	return filter, nil
+}
+
+// groupFiltersToDB converts a request's where expression to validated db filters, its errors are the caller's fault
+func groupFiltersToDB(where *orchardPb.GroupFilterExpr) ([]db.Filter, error) {
+	if where == nil {
+		return nil, nil
+	}
+	dbFilter, err := groupFilterExprToDB(where)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.ValidateFilter(dbFilter, db.GroupFilterColumns); err != nil {
+		return nil, err
+	}
+	return []db.Filter{dbFilter}, nil
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/loupe-co/go-loupe-logger/log"
 	"github.com/loupe-co/orchard/internal/db"
 	"github.com/loupe-co/orchard/internal/helpers"
+	"github.com/loupe-co/orchard/internal/models"
 	orchardPb "github.com/loupe-co/protos/src/common/orchard"
 	tenantPb "github.com/loupe-co/protos/src/common/tenant"
 	servicePb "github.com/loupe-co/protos/src/services/orchard"
@@ -23,7 +24,10 @@ import (
 
 var rollback bool = true
 
+const defaultGroupPageSize = 100
+
 func (h *Handlers) SyncGroups(ctx context.Context, in *servicePb.SyncRequest) (*servicePb.SyncResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	spanCtx, span := log.StartSpan(ctx, "SyncGroups")
 	defer span.End()
 
@@ -65,6 +69,7 @@ func (h *Handlers) SyncGroups(ctx context.Context, in *servicePb.SyncRequest) (*
 }
 
 func (h *Handlers) CreateGroup(spanCtx context.Context, in *servicePb.CreateGroupRequest) (*servicePb.CreateGroupResponse, error) {
+	defer h.hierarchyCache.Invalidate(spanCtx, in.TenantId)
 
 	logger := log.WithContext(spanCtx).WithTenantID(in.TenantId)
 
@@ -212,15 +217,47 @@ func (h *Handlers) GetGroups(spanCtx context.Context, in *servicePb.GetGroupsReq
 		return nil, err.AsGRPC()
 	}
 
-	svc := h.db.NewGroupService()
-
-	gs, err := svc.Search(spanCtx, in.TenantId, in.Search)
+	dbFilters, err := groupFiltersToDB(in.Where)
 	if err != nil {
-		err := errors.Wrap(err, "error getting groups")
-		logger.Error(err)
+		err := ErrBadRequest.New(err.Error())
+		logger.Warn(err.Error())
 		return nil, err.AsGRPC()
 	}
 
+	svc := h.db.NewGroupService()
+
+	// Groups are only paged when asked for, otherwise every matching group is returned in their tree order
+	var gs []*models.Group
+	var total int64
+	var nextPageToken string
+	if in.PageSize > 0 || in.PageToken != "" || in.SortBy != "" {
+		limit := defaultGroupPageSize
+		if in.PageSize > 0 {
+			limit = int(in.PageSize)
+		}
+		page, pageErr := newPage(in.SortBy, in.SortDesc, limit, 0, in.PageToken, !in.SkipTotal, db.GroupSortColumns)
+		if pageErr != nil {
+			err := ErrBadRequest.New(pageErr.Error())
+			logger.Warn(err.Error())
+			return nil, err.AsGRPC()
+		}
+		gs, total, err = svc.SearchPage(spanCtx, in.TenantId, in.Search, page, dbFilters...)
+		if err != nil {
+			err := errors.Wrap(err, "error getting groups")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		gs, nextPageToken = nextPage(gs, page, db.GroupSortValue, func(g *models.Group) string { return g.ID })
+	} else {
+		gs, err = svc.Search(spanCtx, in.TenantId, in.Search, dbFilters...)
+		if err != nil {
+			err := errors.Wrap(err, "error getting groups")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		total = int64(len(gs))
+	}
+
 	groups := make([]*orchardPb.Group, len(gs))
 
 	crmRoleIDs := []string{}
@@ -251,7 +288,9 @@ func (h *Handlers) GetGroups(spanCtx context.Context, in *servicePb.GetGroupsReq
 	}
 
 	return &servicePb.GetGroupsResponse{
-		Groups: groups,
+		Groups:        groups,
+		Total:         int32(total),
+		NextPageToken: nextPageToken,
 	}, nil
 }
 
@@ -278,7 +317,7 @@ func (h *Handlers) GetManagerAndParentIDs(ctx context.Context, in *servicePb.Get
 
 	svc := h.db.NewGroupService()
 
-	managerID, parentID, err := svc.GetManagerAndParentIDs(ctx, tenantID, personID)
+	managerID, parentID, personType, err := svc.GetManagerAndParentIDs(ctx, tenantID, personID)
 	if err != nil {
 		err := errors.Wrap(err, "error getting manager and parent IDs from sql")
 		logger.Error(err)
@@ -286,8 +325,10 @@ func (h *Handlers) GetManagerAndParentIDs(ctx context.Context, in *servicePb.Get
 	}
 
 	return &servicePb.GetManagerAndParentIDsResponse{
-		ManagerId: managerID,
-		ParentId:  parentID,
+		ManagerId:     managerID,
+		ParentId:      parentID,
+		PersonType:    personType,
+		IsPlayerCoach: personType == models.PersonTypePlayerCoach,
 	}, nil
 }
 
@@ -547,6 +588,7 @@ func max(x, y int) int {
 }
 
 func (h *Handlers) UpdateGroup(spanCtx context.Context, in *servicePb.UpdateGroupRequest) (*servicePb.UpdateGroupResponse, error) {
+	defer h.hierarchyCache.Invalidate(spanCtx, in.TenantId)
 
 	logger := log.WithContext(spanCtx).WithTenantID(in.TenantId)
 
@@ -634,6 +676,17 @@ func (h *Handlers) UpdateGroup(spanCtx context.Context, in *servicePb.UpdateGrou
 		return nil, helpers.ErrorHandler(logger, svc, err, "error updating group types", rollback)
 	}
 
+	// Moving a group changes group types, subtrees and who's in the group, so re-run the tenant's role assignment rules
+	if len(in.OnlyFields) == 0 || strUtils.Strings(in.OnlyFields).Intersects([]string{"parent_id", "crm_role_ids"}) {
+		userID := updateableGroup.UpdatedBy
+		if userID == "" {
+			userID = db.DefaultTenantID
+		}
+		if _, err := h.applyRoleAssignmentRules(spanCtx, in.TenantId, userID, svc.GetTransaction()); err != nil {
+			return nil, helpers.ErrorHandler(logger, svc, err, "error applying role assignment rules", rollback)
+		}
+	}
+
 	if err := helpers.CommitTransaction(logger, svc, "update group transaction"); err != nil {
 		return nil, err
 	}
@@ -668,6 +721,7 @@ func (h *Handlers) UpdateGroup(spanCtx context.Context, in *servicePb.UpdateGrou
 }
 
 func (h *Handlers) UpdateGroupTypes(spanCtx context.Context, in *servicePb.UpdateGroupTypesRequest) (*servicePb.UpdateGroupTypesResponse, error) {
+	defer h.hierarchyCache.Invalidate(spanCtx, in.TenantId)
 
 	logger := log.WithContext(spanCtx).WithTenantID(in.TenantId)
 
@@ -689,6 +743,7 @@ func (h *Handlers) UpdateGroupTypes(spanCtx context.Context, in *servicePb.Updat
 }
 
 func (h *Handlers) DeleteGroupById(spanCtx context.Context, in *servicePb.IdRequest) (*servicePb.Empty, error) {
+	defer h.hierarchyCache.Invalidate(spanCtx, in.TenantId)
 
 	logger := log.WithContext(spanCtx).WithTenantID(in.TenantId).WithCustom("groupId", in.GroupId)
 
@@ -759,6 +814,7 @@ func (h *Handlers) DeleteGroupById(spanCtx context.Context, in *servicePb.IdRequ
 }
 
 func (h *Handlers) ResetHierarchy(spanCtx context.Context, in *servicePb.ResetHierarchyRequest) (*servicePb.ResetHierarchyResponse, error) {
+	defer h.hierarchyCache.Invalidate(spanCtx, in.TenantId)
 
 	logger := log.WithContext(spanCtx).WithTenantID(in.TenantId)
 
@@ -460,8 +460,14 @@ func TestGetManagerAndParentIDs(t *testing.T) {
 		return
 	}
 
-	if res.ManagerId != "outreach_playercoach" {
-		t.Log("expected result id to be 'outreach_playercoach', but got '"+res.ManagerId+"'")
+	if res.ManagerId != "" {
+		t.Log("expected result manager id to be empty, but got '"+res.ManagerId+"'")
+		t.Fail()
+		return
+	}
+
+	if res.PersonType != "player_coach" || !res.IsPlayerCoach {
+		t.Log("expected result to be a player_coach, but got '"+res.PersonType+"'")
 		t.Fail()
 		return
 	}
@@ -4,17 +4,70 @@ import (
 	"context"
 	"time"
 
-	perm "github.com/loupe-co/bouncer/pkg/permissions"
 	"github.com/loupe-co/go-common/errors"
 	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/models"
+	"github.com/loupe-co/orchard/internal/permcatalog"
 	authPb "github.com/loupe-co/protos/src/common/auth"
 	orchardPb "github.com/loupe-co/protos/src/common/orchard"
-	bouncerPb "github.com/loupe-co/protos/src/services/bouncer"
 	servicePb "github.com/loupe-co/protos/src/services/orchard"
+	"github.com/volatiletech/null/v8"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// groupViewerGrantProblem is why a group viewer grant can't be saved, empty when it can. Named permissions are encoded into its bits.
+// A grant can be limited to a window, a delegated one has to end and can only pass on access the delegator has to the group.
+func (h *Handlers) groupViewerGrantProblem(ctx context.Context, gv *orchardPb.GroupViewer) (string, error) {
+	if err := resolveGroupViewerPermissions(gv); err != nil {
+		return err.Error(), nil
+	}
+
+	if gv.StartsAt != nil && gv.EndsAt != nil && !gv.EndsAt.AsTime().After(gv.StartsAt.AsTime()) {
+		return "endsAt must be after startsAt", nil
+	}
+	if gv.EndsAt != nil && !gv.EndsAt.AsTime().After(time.Now()) {
+		return "endsAt must be in the future", nil
+	}
+
+	if gv.DelegatedBy == "" {
+		return "", nil
+	}
+	if gv.EndsAt == nil {
+		return "a delegated grant needs an endsAt", nil
+	}
+	if gv.DelegatedBy == gv.PersonId {
+		return "a person can't delegate to themselves", nil
+	}
+
+	scopes, err := h.accessScopes(ctx, gv.TenantId, []*orchardPb.AccessCheck{{ViewerId: gv.DelegatedBy}})
+	if err != nil {
+		return "", err
+	}
+	scope := scopes[gv.DelegatedBy]
+	if scope == nil {
+		return "delegatedBy person not found", nil
+	}
+	groups, err := h.activeGroupsByID(ctx, gv.TenantId, []string{gv.GroupId})
+	if err != nil {
+		return "", err
+	}
+	g, ok := groups[gv.GroupId]
+	if !ok {
+		return "group not found", nil
+	}
+	reason, _, bits := scope.inScope(g.GroupPath)
+	if reason == orchardPb.AccessReason_NotInScope {
+		return "delegatedBy can't view the group", nil
+	}
+	if missing := gv.Permissions &^ bits; missing != 0 {
+		return "delegatedBy doesn't have " + permcatalog.Format(authPb.PermissionSet_Group, missing) + " on the group", nil
+	}
+	return "", nil
+}
+
 func (h *Handlers) InsertGroupViewer(ctx context.Context, in *servicePb.InsertGroupViewerRequest) (*servicePb.InsertGroupViewerResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
 
 	if in.GroupViewer == nil {
@@ -29,13 +82,51 @@ func (h *Handlers) InsertGroupViewer(ctx context.Context, in *servicePb.InsertGr
 		return nil, err.AsGRPC()
 	}
 
+	if problem, err := h.groupViewerGrantProblem(ctx, in.GroupViewer); err != nil {
+		err := errors.Wrap(err, "error checking group viewer grant")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	} else if problem != "" {
+		err := ErrBadRequest.New(problem)
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
 	svc := h.db.NewGroupViewerService()
 
 	gv := svc.FromProto(in.GroupViewer)
 
+	tx, err := h.db.NewTransaction(ctx)
+	if err != nil {
+		err := errors.Wrap(err, "error starting insert group viewer transaction")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	svc.SetTransaction(tx)
+
 	if err := svc.Insert(ctx, gv); err != nil {
 		err := errors.Wrap(err, "error inserting group viewer into sql")
 		logger.Error(err)
+		svc.Rollback()
+		return nil, err.AsGRPC()
+	}
+
+	// a grant that starts later is busted by the sweeper when it starts
+	if db.GroupViewerActive(gv, time.Now()) {
+		outboxSvc := h.db.NewOutboxService()
+		outboxSvc.SetTransaction(tx)
+		if err := outboxSvc.Enqueue(ctx, db.NewBustAuthCacheMessage(gv.TenantID, gv.PersonID)); err != nil {
+			err := errors.Wrap(err, "error enqueueing auth data cache bust for user")
+			logger.Error(err)
+			svc.Rollback()
+			return nil, err.AsGRPC()
+		}
+	}
+
+	if err := svc.Commit(); err != nil {
+		err := errors.Wrap(err, "error commiting transaction, rolling back")
+		logger.Error(err)
+		svc.Rollback()
 		return nil, err.AsGRPC()
 	}
 
@@ -126,6 +217,7 @@ func (h *Handlers) GetPersonViewableGroups(ctx context.Context, in *servicePb.Id
 }
 
 func (h *Handlers) SetPersonViewableGroups(ctx context.Context, in *servicePb.SetPersonViewableGroupsRequest) (*servicePb.SetPersonViewableGroupsResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
 
 	if in.TenantId == "" || in.PersonId == "" {
@@ -134,17 +226,7 @@ func (h *Handlers) SetPersonViewableGroups(ctx context.Context, in *servicePb.Se
 		return nil, err.AsGRPC()
 	}
 
-	permissionSet := authPb.PermissionSet_Group
-
-	perms := []perm.Permission{
-		perm.NewPermission(permissionSet, authPb.Permission_Access),
-		perm.NewPermission(permissionSet, authPb.Permission_Read),
-	}
-
-	p := perm.NewPermissions()
-	p = p.WithPermissions(perms...)
-
-	permissions := p[permissionSet]
+	permissions := defaultGroupViewerPermissions()
 
 	svc := h.db.NewGroupViewerService()
 	viewableGroups, err := svc.GetPersonViewableGroups(ctx, in.TenantId, in.PersonId)
@@ -159,6 +241,20 @@ func (h *Handlers) SetPersonViewableGroups(ctx context.Context, in *servicePb.Se
 		groupIds[vg.ID] = struct{}{}
 	}
 
+	// grants that haven't started or have ended but not been swept yet are made permanent when their group is set again
+	grants, err := svc.GetPersonGrants(ctx, in.TenantId, in.PersonId)
+	if err != nil {
+		err := errors.Wrap(err, "error getting person group viewer grants from sql")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	inactiveGrants := map[string]*models.GroupViewer{}
+	for _, grant := range grants {
+		if _, ok := groupIds[grant.GroupID]; !ok {
+			inactiveGrants[grant.GroupID] = grant
+		}
+	}
+
 	tx, err := h.db.NewTransaction(ctx)
 	if err != nil {
 		err := errors.Wrap(err, "error starting setpersonviewable groups transaction")
@@ -170,6 +266,18 @@ func (h *Handlers) SetPersonViewableGroups(ctx context.Context, in *servicePb.Se
 	now := timestamppb.New(time.Now().UTC())
 
 	for _, gvId := range in.GroupViewerIds {
+		if grant, ok := inactiveGrants[gvId]; ok {
+			grant.StartsAt, grant.EndsAt, grant.DelegatedBy, grant.ActivatedAt = null.Time{}, null.Time{}, null.String{}, null.Time{}
+			grant.UpdatedBy = in.UpdatedBy
+			grant.UpdatedAt = now.AsTime()
+			if err := svc.Update(ctx, grant); err != nil {
+				err := errors.Wrap(err, "error making group viewer grant permanent in sql")
+				logger.Error(err)
+				svc.Rollback()
+				return nil, err.AsGRPC()
+			}
+			continue
+		}
 		if _, ok := groupIds[gvId]; !ok {
 			gvProto := &orchardPb.GroupViewer{
 				GroupId:     gvId,
@@ -215,6 +323,15 @@ func (h *Handlers) SetPersonViewableGroups(ctx context.Context, in *servicePb.Se
 		return nil, err.AsGRPC()
 	}
 
+	outboxSvc := h.db.NewOutboxService()
+	outboxSvc.SetTransaction(tx)
+	if err := outboxSvc.Enqueue(ctx, db.NewBustAuthCacheMessage(in.TenantId, in.PersonId)); err != nil {
+		err := errors.Wrap(err, "error enqueueing auth data cache bust for user")
+		logger.Error(err)
+		svc.Rollback()
+		return nil, err.AsGRPC()
+	}
+
 	if err := svc.Commit(); err != nil {
 		err := errors.Wrap(err, "error commiting transaction, rolling back")
 		logger.Error(err)
@@ -235,18 +352,13 @@ func (h *Handlers) SetPersonViewableGroups(ctx context.Context, in *servicePb.Se
 		}
 	}
 
-	if _, err := h.bouncerClient.BustAuthCache(ctx, &bouncerPb.BustAuthCacheRequest{TenantId: in.TenantId, UserId: in.PersonId}); err != nil {
-		err := errors.Wrap(err, "error busting auth data cache for user")
-		logger.Error(err)
-		return nil, err.AsGRPC()
-	}
-
 	return &servicePb.SetPersonViewableGroupsResponse{
 		Groups: updatedViewableGroups,
 	}, nil
 }
 
 func (h *Handlers) UpdateGroupViewer(ctx context.Context, in *servicePb.UpdateGroupViewerRequest) (*servicePb.UpdateGroupViewerResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
 
 	if in.GroupViewer == nil {
@@ -261,25 +373,54 @@ func (h *Handlers) UpdateGroupViewer(ctx context.Context, in *servicePb.UpdateGr
 		return nil, err.AsGRPC()
 	}
 
+	if problem, err := h.groupViewerGrantProblem(ctx, in.GroupViewer); err != nil {
+		err := errors.Wrap(err, "error checking group viewer grant")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	} else if problem != "" {
+		err := ErrBadRequest.New(problem)
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
 	svc := h.db.NewGroupViewerService()
 
 	gv := svc.FromProto(in.GroupViewer)
 
+	tx, err := h.db.NewTransaction(ctx)
+	if err != nil {
+		err := errors.Wrap(err, "error starting update group viewer transaction")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	svc.SetTransaction(tx)
+
 	if err := svc.Update(ctx, gv); err != nil {
 		err := errors.Wrap(err, "error updating group viewer in sql")
 		logger.Error(err)
+		svc.Rollback()
 		return nil, err.AsGRPC()
 	}
 
-	groupViewer, err := svc.ToProto(gv)
-	if err != nil {
-		err := errors.Wrap(err, "error converting groupViewer db model to proto")
+	outboxSvc := h.db.NewOutboxService()
+	outboxSvc.SetTransaction(tx)
+	if err := outboxSvc.Enqueue(ctx, db.NewBustAuthCacheMessage(gv.TenantID, gv.PersonID)); err != nil {
+		err := errors.Wrap(err, "error enqueueing auth data cache bust for user")
+		logger.Error(err)
+		svc.Rollback()
+		return nil, err.AsGRPC()
+	}
+
+	if err := svc.Commit(); err != nil {
+		err := errors.Wrap(err, "error commiting transaction, rolling back")
 		logger.Error(err)
+		svc.Rollback()
 		return nil, err.AsGRPC()
 	}
 
-	if _, err := h.bouncerClient.BustAuthCache(ctx, &bouncerPb.BustAuthCacheRequest{TenantId: in.TenantId, UserId: in.PersonId}); err != nil {
-		err := errors.Wrap(err, "error busting auth data cache for user")
+	groupViewer, err := svc.ToProto(gv)
+	if err != nil {
+		err := errors.Wrap(err, "error converting groupViewer db model to proto")
 		logger.Error(err)
 		return nil, err.AsGRPC()
 	}
@@ -288,6 +429,7 @@ func (h *Handlers) UpdateGroupViewer(ctx context.Context, in *servicePb.UpdateGr
 }
 
 func (h *Handlers) DeleteGroupViewerById(ctx context.Context, in *servicePb.IdRequest) (*servicePb.Empty, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
 
 	if in.TenantId == "" || in.GroupId == "" || in.PersonId == "" {
@@ -298,15 +440,34 @@ func (h *Handlers) DeleteGroupViewerById(ctx context.Context, in *servicePb.IdRe
 
 	svc := h.db.NewGroupViewerService()
 
+	tx, err := h.db.NewTransaction(ctx)
+	if err != nil {
+		err := errors.Wrap(err, "error starting delete group viewer transaction")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	svc.SetTransaction(tx)
+
 	if err := svc.DeleteByID(ctx, in.TenantId, in.GroupId, in.PersonId); err != nil {
 		err := errors.Wrap(err, "error deleting group viewer in sql")
 		logger.Error(err)
+		svc.Rollback()
+		return nil, err.AsGRPC()
+	}
+
+	outboxSvc := h.db.NewOutboxService()
+	outboxSvc.SetTransaction(tx)
+	if err := outboxSvc.Enqueue(ctx, db.NewBustAuthCacheMessage(in.TenantId, in.PersonId)); err != nil {
+		err := errors.Wrap(err, "error enqueueing auth data cache bust for user")
+		logger.Error(err)
+		svc.Rollback()
 		return nil, err.AsGRPC()
 	}
 
-	if _, err := h.bouncerClient.BustAuthCache(ctx, &bouncerPb.BustAuthCacheRequest{TenantId: in.TenantId, UserId: in.PersonId}); err != nil {
-		err := errors.Wrap(err, "error busting auth data cache for user")
+	if err := svc.Commit(); err != nil {
+		err := errors.Wrap(err, "error commiting transaction, rolling back")
 		logger.Error(err)
+		svc.Rollback()
 		return nil, err.AsGRPC()
 	}
 
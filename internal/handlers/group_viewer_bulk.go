@@ -0,0 +1,484 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/models"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+	"github.com/volatiletech/null/v8"
+)
+
+const maxBulkViewerGrants = 5000
+
+// viewerGrantChanges are the writes of a bulk group viewer change, only grants that actually change are written and only the
+// people they belong to get their bouncer cache busted
+type viewerGrantChanges struct {
+	inserts  []*models.GroupViewer
+	updates  []*models.GroupViewer
+	deletes  []*models.GroupViewer
+	affected map[string]bool
+}
+
+func newViewerGrantChanges() *viewerGrantChanges {
+	return &viewerGrantChanges{affected: map[string]bool{}}
+}
+
+// put makes want the grant for its group and person, existing is the grant that's there now or nil
+func (changes *viewerGrantChanges) put(existing, want *models.GroupViewer) {
+	switch {
+	case existing == nil:
+		changes.inserts = append(changes.inserts, want)
+	case groupViewerChanged(existing, want):
+		want.CreatedAt, want.CreatedBy = existing.CreatedAt, existing.CreatedBy
+		changes.updates = append(changes.updates, want)
+	default:
+		return
+	}
+	changes.affected[want.PersonID] = true
+}
+
+func (changes *viewerGrantChanges) remove(existing *models.GroupViewer) {
+	changes.deletes = append(changes.deletes, existing)
+	changes.affected[existing.PersonID] = true
+}
+
+func (changes *viewerGrantChanges) affectedPersonIDs() []string {
+	ids := make([]string, 0, len(changes.affected))
+	for id := range changes.affected {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// groupViewerChanged is whether saving b over a changes what the grant gives
+func groupViewerChanged(a, b *models.GroupViewer) bool {
+	sameTime := func(x, y null.Time) bool { return x.Valid == y.Valid && (!x.Valid || x.Time.Equal(y.Time)) }
+	return a.Permissions != b.Permissions || !sameTime(a.StartsAt, b.StartsAt) || !sameTime(a.EndsAt, b.EndsAt) || a.DelegatedBy != b.DelegatedBy
+}
+
+// applyViewerGrantChanges writes changes in one transaction along with a bouncer cache bust for each affected person
+func (h *Handlers) applyViewerGrantChanges(ctx context.Context, tenantID string, changes *viewerGrantChanges) error {
+	if len(changes.affected) == 0 {
+		return nil
+	}
+
+	tx, err := h.db.NewTransaction(ctx)
+	if err != nil {
+		return errors.Wrap(err, "error creating group viewer transaction")
+	}
+	svc := h.db.NewGroupViewerService()
+	svc.SetTransaction(tx)
+	outboxSvc := h.db.NewOutboxService()
+	outboxSvc.SetTransaction(tx)
+
+	apply := func() error {
+		for _, gv := range changes.deletes {
+			if err := svc.DeleteByID(ctx, gv.TenantID, gv.GroupID, gv.PersonID); err != nil {
+				return errors.Wrap(err, "error deleting group viewer in sql")
+			}
+		}
+		for _, gv := range changes.updates {
+			if err := svc.Update(ctx, gv); err != nil {
+				return errors.Wrap(err, "error updating group viewer in sql")
+			}
+		}
+		for _, gv := range changes.inserts {
+			if err := svc.Insert(ctx, gv); err != nil {
+				return errors.Wrap(err, "error inserting group viewer into sql")
+			}
+		}
+		msgs := make([]*db.OutboxMessage, 0, len(changes.affected))
+		for _, personID := range changes.affectedPersonIDs() {
+			msgs = append(msgs, db.NewBustAuthCacheMessage(tenantID, personID))
+		}
+		if err := outboxSvc.Enqueue(ctx, msgs...); err != nil {
+			return errors.Wrap(err, "error enqueueing auth data cache busts")
+		}
+		return nil
+	}
+
+	if err := apply(); err != nil {
+		svc.Rollback()
+		return err
+	}
+	if err := svc.Commit(); err != nil {
+		svc.Rollback()
+		return errors.Wrap(err, "error commiting group viewer transaction")
+	}
+	return nil
+}
+
+// viewerGrantFromProto checks a requested grant and makes it the grant for groupID, problem is why it can't be granted
+func (h *Handlers) viewerGrantFromProto(ctx context.Context, tenantID, groupID, updatedBy string, viewer *orchardPb.GroupViewer, now time.Time) (*models.GroupViewer, string, error) {
+	if viewer == nil || viewer.PersonId == "" {
+		return nil, "every viewer needs a personId", nil
+	}
+	viewer.TenantId, viewer.GroupId = tenantID, groupID
+	if viewer.Permissions == 0 && viewer.NamedPermissions == nil {
+		viewer.Permissions = defaultGroupViewerPermissions()
+	}
+	problem, err := h.groupViewerGrantProblem(ctx, viewer)
+	if err != nil || problem != "" {
+		return nil, problem, err
+	}
+	gv := h.db.NewGroupViewerService().FromProto(viewer)
+	gv.CreatedBy, gv.CreatedAt = updatedBy, now
+	gv.UpdatedBy, gv.UpdatedAt = updatedBy, now
+	return gv, "", nil
+}
+
+// missingPeople returns the ids that aren't people in the tenant
+func (h *Handlers) missingPeople(ctx context.Context, tenantID string, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+	people, err := h.db.NewPersonService().GetByIDs(ctx, tenantID, values...)
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]bool{}
+	for _, p := range people {
+		found[p.ID] = true
+	}
+	missing := []string{}
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+// SetGroupViewers replaces the full list of viewers of a group, each with their own permissions and optional validity window.
+// Viewers that aren't in the list lose their grant on the group.
+func (h *Handlers) SetGroupViewers(ctx context.Context, in *servicePb.SetGroupViewersRequest) (*servicePb.SetGroupViewersResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("groupId", in.GroupId)
+
+	if in.TenantId == "" || in.GroupId == "" {
+		err := ErrBadRequest.New("tenantId and groupId can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+	if len(in.Viewers) > maxBulkViewerGrants {
+		err := ErrBadRequest.New(fmt.Sprintf("can't set more than %d viewers at once", maxBulkViewerGrants))
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	groups, err := h.activeGroupsByID(ctx, in.TenantId, []string{in.GroupId})
+	if err != nil {
+		err := errors.Wrap(err, "error getting group")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	if _, ok := groups[in.GroupId]; !ok {
+		err := ErrBadRequest.New("group not found")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	now := time.Now().UTC()
+	wanted := map[string]*models.GroupViewer{}
+	personIDs := []string{}
+	for _, viewer := range in.Viewers {
+		gv, problem, err := h.viewerGrantFromProto(ctx, in.TenantId, in.GroupId, in.UpdatedBy, viewer, now)
+		if err != nil {
+			err := errors.Wrap(err, "error checking group viewer grant")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		if problem == "" && wanted[gv.PersonID] != nil {
+			problem = fmt.Sprintf("person %s is in viewers more than once", gv.PersonID)
+		}
+		if problem != "" {
+			err := ErrBadRequest.New(problem)
+			logger.Warn(err.Error())
+			return nil, err.AsGRPC()
+		}
+		wanted[gv.PersonID] = gv
+		personIDs = append(personIDs, gv.PersonID)
+	}
+
+	if missing, err := h.missingPeople(ctx, in.TenantId, personIDs); err != nil {
+		err := errors.Wrap(err, "error getting viewers")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	} else if len(missing) > 0 {
+		err := ErrBadRequest.New(fmt.Sprintf("people not found: %v", missing))
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	svc := h.db.NewGroupViewerService()
+	existing, err := svc.GetGroupGrants(ctx, in.TenantId, in.GroupId)
+	if err != nil {
+		err := errors.Wrap(err, "error getting group viewer grants")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	changes := newViewerGrantChanges()
+	existingByPerson := map[string]*models.GroupViewer{}
+	for _, gv := range existing {
+		existingByPerson[gv.PersonID] = gv
+		if wanted[gv.PersonID] == nil {
+			changes.remove(gv)
+		}
+	}
+	for _, personID := range personIDs {
+		changes.put(existingByPerson[personID], wanted[personID])
+	}
+
+	if err := h.applyViewerGrantChanges(ctx, in.TenantId, changes); err != nil {
+		err := errors.Wrap(err, "error setting group viewers")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	viewers := make([]*orchardPb.GroupViewer, 0, len(personIDs))
+	for _, personID := range personIDs {
+		gv, err := svc.ToProto(wanted[personID])
+		if err != nil {
+			err := errors.Wrap(err, "error converting groupViewer db model to proto")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		viewers = append(viewers, gv)
+	}
+
+	return &servicePb.SetGroupViewersResponse{
+		GroupViewers:      viewers,
+		Inserted:          int32(len(changes.inserts)),
+		Updated:           int32(len(changes.updates)),
+		Deleted:           int32(len(changes.deletes)),
+		AffectedPersonIds: changes.affectedPersonIDs(),
+	}, nil
+}
+
+// CopyViewerGrants gives one person all of another person's group viewer grants that haven't ended, e.g. when someone is replaced.
+// Grants the target already has on a group are kept unless overwrite is set.
+func (h *Handlers) CopyViewerGrants(ctx context.Context, in *servicePb.CopyViewerGrantsRequest) (*servicePb.CopyViewerGrantsResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("fromPersonId", in.FromPersonId).WithCustom("toPersonId", in.ToPersonId)
+
+	if in.TenantId == "" || in.FromPersonId == "" || in.ToPersonId == "" {
+		err := ErrBadRequest.New("tenantId, fromPersonId and toPersonId can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+	if in.FromPersonId == in.ToPersonId {
+		err := ErrBadRequest.New("fromPersonId and toPersonId must be different people")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	if missing, err := h.missingPeople(ctx, in.TenantId, []string{in.FromPersonId, in.ToPersonId}); err != nil {
+		err := errors.Wrap(err, "error getting people")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	} else if len(missing) > 0 {
+		err := ErrBadRequest.New(fmt.Sprintf("people not found: %v", missing))
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	svc := h.db.NewGroupViewerService()
+	source, err := svc.GetPersonGrants(ctx, in.TenantId, in.FromPersonId)
+	if err != nil {
+		err := errors.Wrap(err, "error getting source group viewer grants")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	target, err := svc.GetPersonGrants(ctx, in.TenantId, in.ToPersonId)
+	if err != nil {
+		err := errors.Wrap(err, "error getting target group viewer grants")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	targetByGroup := map[string]*models.GroupViewer{}
+	for _, gv := range target {
+		targetByGroup[gv.GroupID] = gv
+	}
+
+	now := time.Now().UTC()
+	changes := newViewerGrantChanges()
+	for _, gv := range source {
+		if gv.EndsAt.Valid && !gv.EndsAt.Time.After(now) {
+			continue
+		}
+		existing := targetByGroup[gv.GroupID]
+		if existing != nil && !in.Overwrite {
+			continue
+		}
+		// a grant delegated to the target by the target can't be valid, it becomes a plain grant
+		delegatedBy := gv.DelegatedBy
+		if delegatedBy.String == in.ToPersonId {
+			delegatedBy = null.String{}
+		}
+		changes.put(existing, &models.GroupViewer{
+			TenantID:    in.TenantId,
+			GroupID:     gv.GroupID,
+			PersonID:    in.ToPersonId,
+			Permissions: gv.Permissions,
+			StartsAt:    gv.StartsAt,
+			EndsAt:      gv.EndsAt,
+			DelegatedBy: delegatedBy,
+			CreatedBy:   in.UpdatedBy,
+			CreatedAt:   now,
+			UpdatedBy:   in.UpdatedBy,
+			UpdatedAt:   now,
+		})
+	}
+
+	if err := h.applyViewerGrantChanges(ctx, in.TenantId, changes); err != nil {
+		err := errors.Wrap(err, "error copying group viewer grants")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	return &servicePb.CopyViewerGrantsResponse{
+		Inserted:          int32(len(changes.inserts)),
+		Updated:           int32(len(changes.updates)),
+		AffectedPersonIds: changes.affectedPersonIDs(),
+	}, nil
+}
+
+// ApplySubtreeViewers grants the viewers on a group and every group under it, or with remove set takes their grants on those groups away
+func (h *Handlers) ApplySubtreeViewers(ctx context.Context, in *servicePb.ApplySubtreeViewersRequest) (*servicePb.ApplySubtreeViewersResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("groupId", in.GroupId).WithCustom("remove", in.Remove)
+
+	if in.TenantId == "" || in.GroupId == "" {
+		err := ErrBadRequest.New("tenantId and groupId can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+	if len(in.Viewers) == 0 {
+		err := ErrBadRequest.New("viewers can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	subTree, err := h.db.NewGroupService().GetSubTreeIDs(ctx, in.TenantId, in.GroupId)
+	if err != nil {
+		err := errors.Wrap(err, "error getting group subtree")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	if len(subTree) == 0 {
+		err := ErrBadRequest.New("group not found")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+	if len(subTree)*len(in.Viewers) > maxBulkViewerGrants {
+		err := ErrBadRequest.New(fmt.Sprintf("can't change more than %d grants at once", maxBulkViewerGrants))
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	personIDs := []string{}
+	seen := map[string]bool{}
+	for _, viewer := range in.Viewers {
+		if viewer == nil || viewer.PersonId == "" {
+			err := ErrBadRequest.New("every viewer needs a personId")
+			logger.Warn(err.Error())
+			return nil, err.AsGRPC()
+		}
+		if seen[viewer.PersonId] {
+			err := ErrBadRequest.New(fmt.Sprintf("person %s is in viewers more than once", viewer.PersonId))
+			logger.Warn(err.Error())
+			return nil, err.AsGRPC()
+		}
+		seen[viewer.PersonId] = true
+		personIDs = append(personIDs, viewer.PersonId)
+	}
+
+	if missing, err := h.missingPeople(ctx, in.TenantId, personIDs); err != nil {
+		err := errors.Wrap(err, "error getting viewers")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	} else if len(missing) > 0 {
+		err := ErrBadRequest.New(fmt.Sprintf("people not found: %v", missing))
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	existing, err := h.db.NewGroupViewerService().GetGroupGrants(ctx, in.TenantId, subTree...)
+	if err != nil {
+		err := errors.Wrap(err, "error getting subtree group viewer grants")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	existingByKey := map[[2]string]*models.GroupViewer{}
+	for _, gv := range existing {
+		existingByKey[[2]string{gv.GroupID, gv.PersonID}] = gv
+	}
+
+	now := time.Now().UTC()
+	changes := newViewerGrantChanges()
+	for _, viewer := range in.Viewers {
+		for _, groupID := range subTree {
+			current := existingByKey[[2]string{groupID, viewer.PersonId}]
+			if in.Remove {
+				if current != nil {
+					changes.remove(current)
+				}
+				continue
+			}
+			// every group gets its own copy of the viewer, the checks encode named permissions into it
+			gv, problem, err := h.viewerGrantFromProto(ctx, in.TenantId, groupID, in.UpdatedBy, cloneGroupViewer(viewer), now)
+			if err != nil {
+				err := errors.Wrap(err, "error checking group viewer grant")
+				logger.Error(err)
+				return nil, err.AsGRPC()
+			}
+			if problem != "" {
+				err := ErrBadRequest.New(fmt.Sprintf("group %s: %s", groupID, problem))
+				logger.Warn(err.Error())
+				return nil, err.AsGRPC()
+			}
+			changes.put(current, gv)
+		}
+	}
+
+	if err := h.applyViewerGrantChanges(ctx, in.TenantId, changes); err != nil {
+		err := errors.Wrap(err, "error applying subtree viewers")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	return &servicePb.ApplySubtreeViewersResponse{
+		GroupIds:          subTree,
+		Inserted:          int32(len(changes.inserts)),
+		Updated:           int32(len(changes.updates)),
+		Deleted:           int32(len(changes.deletes)),
+		AffectedPersonIds: changes.affectedPersonIDs(),
+	}, nil
+}
+
+func cloneGroupViewer(gv *orchardPb.GroupViewer) *orchardPb.GroupViewer {
+	c := &orchardPb.GroupViewer{
+		PersonId:    gv.PersonId,
+		Permissions: gv.Permissions,
+		StartsAt:    gv.StartsAt,
+		EndsAt:      gv.EndsAt,
+		DelegatedBy: gv.DelegatedBy,
+	}
+	if gv.NamedPermissions != nil {
+		c.NamedPermissions = &orchardPb.NamedPermissions{PermissionSet: gv.NamedPermissions.PermissionSet, Names: gv.NamedPermissions.Names}
+	}
+	return c
+}
@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/loupe-co/go-common/fixtures"
+	"github.com/loupe-co/orchard/internal/db"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+)
+
+const (
+	seedDanID     = "c9a0300e-a3c2-4ad8-a19e-17e82475936f"
+	seedBrandonID = "2163bf6c-3c94-422a-ab77-3cfbbb37405a"
+)
+
+func groupViewerIDs(store *db.MemoryStore, groupID string) ([]string, error) {
+	gvs, err := store.NewGroupViewerService().GetGroupGrants(context.Background(), db.DefaultTenantID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, gv := range gvs {
+		ids = append(ids, gv.PersonID)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func TestSetGroupViewers(t *testing.T) {
+	h, fakes, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	// CS Managers is viewed by Will and Brandon, both without permission bits
+	testData, _, _, err := jsonparser.Get(fixtures.Data["group_viewer_bulk"], "TestSetGroupViewers")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	req := &servicePb.SetGroupViewersRequest{}
+	if err := json.Unmarshal(testData, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	res, err := h.SetGroupViewers(ctx, req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if res.Inserted != 1 || res.Updated != 1 || res.Deleted != 1 {
+		t.Logf("expected Olivia inserted, Will updated to the default permissions and Brandon deleted, but got %d/%d/%d", res.Inserted, res.Updated, res.Deleted)
+		t.Fail()
+		return
+	}
+	affected := []string{seedWillID, seedOliviaID, seedBrandonID}
+	sort.Strings(affected)
+	if !reflect.DeepEqual(res.AffectedPersonIds, affected) {
+		t.Log("expected", affected, "to be affected, but got", res.AffectedPersonIds)
+		t.Fail()
+		return
+	}
+	for _, gv := range res.GroupViewers {
+		if gv.Permissions != defaultGroupViewerPermissions() {
+			t.Logf("expected %s to get the default permissions, but got %#x", gv.PersonId, gv.Permissions)
+			t.Fail()
+			return
+		}
+	}
+	want := []string{seedWillID, seedOliviaID}
+	sort.Strings(want)
+	ids, err := groupViewerIDs(store, seedCSManagersID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if !reflect.DeepEqual(ids, want) {
+		t.Log("expected the group's viewers to be replaced, but got", ids)
+		t.Fail()
+		return
+	}
+
+	if _, err := h.DispatchOutbox(ctx); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	busted := fakes.Bouncer.BustedUserIDs(db.DefaultTenantID)
+	sort.Strings(busted)
+	if !reflect.DeepEqual(busted, affected) {
+		t.Log("expected only the affected people to be busted, but got", busted)
+		t.Fail()
+		return
+	}
+
+	// Setting the same viewers again changes nothing
+	fakes.Reset()
+	again, err := h.SetGroupViewers(ctx, req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if again.Inserted != 0 || again.Updated != 0 || again.Deleted != 0 || len(again.AffectedPersonIds) != 0 {
+		t.Log("expected no changes, but got", again)
+		t.Fail()
+		return
+	}
+	if _, err := h.DispatchOutbox(ctx); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if busted := fakes.Bouncer.BustedUserIDs(db.DefaultTenantID); len(busted) != 0 {
+		t.Log("expected no busts without changes, but got", busted)
+		t.Fail()
+		return
+	}
+
+	rawResult, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if err := fixtures.WriteTestResult("../../fixtures/results/TestSetGroupViewers.json", rawResult); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+}
+
+func TestSetGroupViewersBadRequest(t *testing.T) {
+	h, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["group_viewer_bulk"], "TestSetGroupViewersBadRequest")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	requests := []*servicePb.SetGroupViewersRequest{}
+	if err := json.Unmarshal(testData, &requests); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	for _, req := range requests {
+		_, err := h.SetGroupViewers(context.Background(), req)
+		if err == nil {
+			t.Log("expected", req, "to be a bad request, but got nil error")
+			t.Fail()
+			return
+		}
+		if !strings.Contains(err.Error(), "Bad Request") {
+			t.Log("expected error to contain 'Bad Request', but got", err)
+			t.Fail()
+			return
+		}
+	}
+
+	want := []string{seedWillID, seedBrandonID}
+	sort.Strings(want)
+	ids, err := groupViewerIDs(store, seedCSManagersID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if !reflect.DeepEqual(ids, want) {
+		t.Log("expected a bad request not to change the viewers, but got", ids)
+		t.Fail()
+		return
+	}
+}
+
+func TestCopyViewerGrants(t *testing.T) {
+	h, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	// an ended grant isn't copied
+	if _, err := insertTestData(store, "group_viewer_bulk", "TestCopyViewerGrants", "store"); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["group_viewer_bulk"], "TestCopyViewerGrants", "request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	req := &servicePb.CopyViewerGrantsRequest{}
+	if err := json.Unmarshal(testData, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	res, err := h.CopyViewerGrants(ctx, req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if res.Inserted != 1 || res.Updated != 0 || !reflect.DeepEqual(res.AffectedPersonIds, []string{seedOliviaID}) {
+		t.Log("expected Will's one current grant to be copied to Olivia, but got", res)
+		t.Fail()
+		return
+	}
+	grants, err := store.NewGroupViewerService().GetPersonGrants(ctx, db.DefaultTenantID, seedOliviaID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(grants) != 1 || grants[0].GroupID != seedCSManagersID {
+		t.Log("expected Olivia to view CS Managers, but got", grants)
+		t.Fail()
+		return
+	}
+
+	// Olivia already has the grant now, it's only rewritten with overwrite and only if it differs
+	if res, err := h.CopyViewerGrants(ctx, req); err != nil || res.Inserted != 0 || res.Updated != 0 {
+		t.Log("expected existing grants to be kept, but got", res, err)
+		t.Fail()
+		return
+	}
+	req.Overwrite = true
+	if res, err := h.CopyViewerGrants(ctx, req); err != nil || res.Updated != 0 || len(res.AffectedPersonIds) != 0 {
+		t.Log("expected an identical grant not to be rewritten, but got", res, err)
+		t.Fail()
+		return
+	}
+
+	testData, _, _, err = jsonparser.Get(fixtures.Data["group_viewer_bulk"], "TestCopyViewerGrants", "bad_requests")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	badRequests := []*servicePb.CopyViewerGrantsRequest{}
+	if err := json.Unmarshal(testData, &badRequests); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	for _, badReq := range badRequests {
+		_, err := h.CopyViewerGrants(ctx, badReq)
+		if err == nil {
+			t.Log("expected", badReq, "to be a bad request, but got nil error")
+			t.Fail()
+			return
+		}
+		if !strings.Contains(err.Error(), "Bad Request") {
+			t.Log("expected error to contain 'Bad Request', but got", err)
+			t.Fail()
+			return
+		}
+	}
+}
+
+func TestApplySubtreeViewers(t *testing.T) {
+	h, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["group_viewer_bulk"], "TestApplySubtreeViewers", "request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	req := &servicePb.ApplySubtreeViewersRequest{}
+	if err := json.Unmarshal(testData, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	res, err := h.ApplySubtreeViewers(ctx, req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(res.GroupIds) != 2 || res.Inserted != 2 {
+		t.Log("expected Dan on Enterprise Managers and Enterprise AEs, but got", res)
+		t.Fail()
+		return
+	}
+	for _, groupID := range []string{seedEnterpriseMgrs, seedEnterpriseAEs} {
+		ids, err := groupViewerIDs(store, groupID)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if !reflect.DeepEqual(ids, []string{seedDanID}) {
+			t.Log("expected Dan to view", groupID, "but got", ids)
+			t.Fail()
+			return
+		}
+	}
+
+	req.Remove = true
+	res, err = h.ApplySubtreeViewers(ctx, req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if res.Deleted != 2 || !reflect.DeepEqual(res.AffectedPersonIds, []string{seedDanID}) {
+		t.Log("expected both of Dan's grants to be removed, but got", res)
+		t.Fail()
+		return
+	}
+	ids, err := groupViewerIDs(store, seedEnterpriseAEs)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(ids) != 0 {
+		t.Log("expected no viewers left, but got", ids)
+		t.Fail()
+		return
+	}
+
+	testData, _, _, err = jsonparser.Get(fixtures.Data["group_viewer_bulk"], "TestApplySubtreeViewers", "bad_requests")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	badRequests := []*servicePb.ApplySubtreeViewersRequest{}
+	if err := json.Unmarshal(testData, &badRequests); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	for _, badReq := range badRequests {
+		_, err := h.ApplySubtreeViewers(ctx, badReq)
+		if err == nil {
+			t.Log("expected", badReq, "to be a bad request, but got nil error")
+			t.Fail()
+			return
+		}
+		if !strings.Contains(err.Error(), "Bad Request") {
+			t.Log("expected error to contain 'Bad Request', but got", err)
+			t.Fail()
+			return
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/models"
+)
+
+const (
+	groupViewerSweepBatchSize = 500
+	groupViewerSweepLock      = "orchard:group-viewer-sweep"
+)
+
+// RunGroupViewerSweeper sweeps group viewer grants on an interval until the context is cancelled, one replica at a time
+func (h *Handlers) RunGroupViewerSweeper(ctx context.Context) error {
+	interval := time.Duration(h.cfg.GroupViewerSweepIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			h.runLocked(ctx, groupViewerSweepLock, func() {
+				// Keep sweeping while full batches are coming back so a backlog doesn't wait on the ticker
+				for {
+					n, err := h.SweepGroupViewers(ctx)
+					if err != nil {
+						log.WithContext(ctx).Error(errors.Wrap(err, "error sweeping group viewers"))
+						return
+					}
+					if n < groupViewerSweepBatchSize || ctx.Err() != nil {
+						return
+					}
+				}
+			})
+		}
+	}
+}
+
+// SweepGroupViewers deletes a batch of expired group viewer grants and marks a batch of grants that have started, queueing a bouncer
+// cache bust for every person whose grants changed in the same transaction. It returns the size of the larger batch.
+func (h *Handlers) SweepGroupViewers(ctx context.Context) (int, error) {
+	spanCtx, span := log.StartSpan(ctx, "SweepGroupViewers")
+	defer span.End()
+
+	tx, err := h.db.NewTransaction(spanCtx)
+	if err != nil {
+		return 0, errors.Wrap(err, "error creating group viewer sweep transaction")
+	}
+
+	svc := h.db.NewGroupViewerService()
+	svc.SetTransaction(tx)
+	outboxSvc := h.db.NewOutboxService()
+	outboxSvc.SetTransaction(tx)
+
+	now := time.Now().UTC()
+
+	expired, err := svc.DeleteExpired(spanCtx, now, groupViewerSweepBatchSize)
+	if err != nil {
+		svc.Rollback()
+		return 0, errors.Wrap(err, "error deleting expired group viewers")
+	}
+
+	started, err := svc.MarkStarted(spanCtx, now, groupViewerSweepBatchSize)
+	if err != nil {
+		svc.Rollback()
+		return 0, errors.Wrap(err, "error marking started group viewers")
+	}
+
+	if err := outboxSvc.Enqueue(spanCtx, groupViewerBusts(expired, started)...); err != nil {
+		svc.Rollback()
+		return 0, errors.Wrap(err, "error enqueueing auth data cache busts for swept group viewers")
+	}
+
+	if err := svc.Commit(); err != nil {
+		svc.Rollback()
+		return 0, errors.Wrap(err, "error commiting group viewer sweep transaction")
+	}
+
+	// Cached viewer reads of every tenant whose grants ended or started are stale now
+	for tenantID := range groupViewerTenants(expired, started) {
+		h.hierarchyCache.Invalidate(spanCtx, tenantID)
+	}
+
+	if len(expired) > 0 || len(started) > 0 {
+		log.WithContext(spanCtx).WithCustom("expired", len(expired)).WithCustom("started", len(started)).Info("swept group viewers")
+	}
+
+	if len(expired) > len(started) {
+		return len(expired), nil
+	}
+	return len(started), nil
+}
+
+// groupViewerBusts is one bouncer cache bust per person in the grants
+func groupViewerBusts(grants ...[]*models.GroupViewer) []*db.OutboxMessage {
+	msgs := []*db.OutboxMessage{}
+	seen := map[[2]string]bool{}
+	for _, gvs := range grants {
+		for _, gv := range gvs {
+			key := [2]string{gv.TenantID, gv.PersonID}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			msgs = append(msgs, db.NewBustAuthCacheMessage(gv.TenantID, gv.PersonID))
+		}
+	}
+	return msgs
+}
+
+// groupViewerTenants is the set of tenants the grants belong to
+func groupViewerTenants(grants ...[]*models.GroupViewer) map[string]struct{} {
+	tenants := map[string]struct{}{}
+	for _, gvs := range grants {
+		for _, gv := range gvs {
+			tenants[gv.TenantID] = struct{}{}
+		}
+	}
+	return tenants
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/loupe-co/go-common/fixtures"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/permcatalog"
+	authPb "github.com/loupe-co/protos/src/common/auth"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// groupViewerWindowTestCase is a group viewer to insert, its window and permissions are given like testGroupViewer's
+type groupViewerWindowTestCase struct {
+	Name             string                 `json:"name"`
+	GroupViewer      *orchardPb.GroupViewer `json:"group_viewer"`
+	GroupPermissions []string               `json:"group_permissions"`
+	StartsIn         string                 `json:"starts_in"`
+	EndsIn           string                 `json:"ends_in"`
+}
+
+// request builds the InsertGroupViewer request for the case with its window relative to now
+func (c *groupViewerWindowTestCase) request(now time.Time) (*servicePb.InsertGroupViewerRequest, error) {
+	gv := c.GroupViewer
+	gv.TenantId = db.DefaultTenantID
+	if len(c.GroupPermissions) > 0 {
+		bits, err := permcatalog.Encode(authPb.PermissionSet_Group, c.GroupPermissions)
+		if err != nil {
+			return nil, err
+		}
+		gv.Permissions = bits
+	}
+	startsAt, err := testTimeIn(now, c.StartsIn)
+	if err != nil {
+		return nil, err
+	}
+	if startsAt.Valid {
+		gv.StartsAt = timestamppb.New(startsAt.Time)
+	}
+	endsAt, err := testTimeIn(now, c.EndsIn)
+	if err != nil {
+		return nil, err
+	}
+	if endsAt.Valid {
+		gv.EndsAt = timestamppb.New(endsAt.Time)
+	}
+	return &servicePb.InsertGroupViewerRequest{TenantId: db.DefaultTenantID, GroupViewer: gv}, nil
+}
+
+func TestGroupViewerValidityWindows(t *testing.T) {
+	_, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// one open ended grant, one inside its window, one that hasn't started and one that has ended
+	data, err := insertTestData(store, "group_viewer_sweeper", "TestGroupViewerValidityWindows")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	gvs, err := store.NewGroupViewerService().GetPersonsViewableGroups(context.Background(), db.DefaultTenantID, data.People[0].ID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ids := map[string]bool{}
+	for _, gv := range gvs {
+		ids[gv.GroupID] = true
+	}
+	if len(ids) != 2 || !ids[seedEMEAAEsID] || !ids[seedEnterpriseAEs] {
+		t.Log("expected only the open ended grant and the one inside its window, but got", ids)
+		t.Fail()
+		return
+	}
+}
+
+func TestSweepGroupViewers(t *testing.T) {
+	h, fakes, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	// an expired, a started and a pending grant
+	data, err := insertTestData(store, "group_viewer_sweeper", "TestSweepGroupViewers")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	expired, started, pending := data.People[0], data.People[1], data.People[2]
+	fakes.Reset()
+
+	n, err := h.SweepGroupViewers(ctx)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if n != 1 {
+		t.Logf("expected batches of 1 expired and 1 started grant, but got %d", n)
+		t.Fail()
+		return
+	}
+
+	svc := store.NewGroupViewerService()
+	grants, err := svc.GetPersonGrants(ctx, db.DefaultTenantID, expired.ID)
+	if err != nil || len(grants) != 0 {
+		t.Log("expected the expired grant to be deleted, but got", grants, err)
+		t.Fail()
+		return
+	}
+	grants, err = svc.GetPersonGrants(ctx, db.DefaultTenantID, started.ID)
+	if err != nil || len(grants) != 1 || !grants[0].ActivatedAt.Valid {
+		t.Log("expected the started grant to be marked activated, but got", grants, err)
+		t.Fail()
+		return
+	}
+	grants, err = svc.GetPersonGrants(ctx, db.DefaultTenantID, pending.ID)
+	if err != nil || len(grants) != 1 || grants[0].ActivatedAt.Valid {
+		t.Log("expected the pending grant to be left alone, but got", grants, err)
+		t.Fail()
+		return
+	}
+
+	if _, err := h.DispatchOutbox(ctx); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	busted := map[string]bool{}
+	for _, id := range fakes.Bouncer.BustedUserIDs(db.DefaultTenantID) {
+		busted[id] = true
+	}
+	if len(busted) != 2 || !busted[expired.ID] || !busted[started.ID] {
+		t.Log("expected the expired and started people's auth caches to be busted, but got", busted)
+		t.Fail()
+		return
+	}
+
+	// Nothing is due anymore, a second sweep doesn't touch the activated grant again
+	if n, err := h.SweepGroupViewers(ctx); err != nil || n != 0 {
+		t.Log("expected nothing left to sweep, but got", n, err)
+		t.Fail()
+		return
+	}
+}
+
+func TestRunLockedSkipsWhileTheLockIsHeld(t *testing.T) {
+	h, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	unlock, ok, err := store.TryLock(ctx, groupViewerSweepLock)
+	if err != nil || !ok {
+		t.Log("expected to take the free lock, but got", ok, err)
+		t.Fail()
+		return
+	}
+
+	ran := false
+	h.runLocked(ctx, groupViewerSweepLock, func() { ran = true })
+	if ran {
+		t.Log("expected the job to be skipped while another run holds the lock")
+		t.Fail()
+		return
+	}
+
+	unlock()
+	h.runLocked(ctx, groupViewerSweepLock, func() { ran = true })
+	if !ran {
+		t.Log("expected the job to run once the lock is released")
+		t.Fail()
+		return
+	}
+	if _, ok, _ := store.TryLock(ctx, groupViewerSweepLock); !ok {
+		t.Log("expected runLocked to release the lock when the job is done")
+		t.Fail()
+		return
+	}
+}
+
+func TestInsertGroupViewerWindowsAndDelegation(t *testing.T) {
+	h, _, _, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+	now := time.Now()
+
+	// Will's seeded grant on CS Managers has no permission bits, so he can't delegate Read under it
+	testData, _, _, err := jsonparser.Get(fixtures.Data["group_viewer_sweeper"], "TestInsertGroupViewerWindowsAndDelegation", "bad_requests")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	badCases := []*groupViewerWindowTestCase{}
+	if err := json.Unmarshal(testData, &badCases); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	for _, c := range badCases {
+		req, err := c.request(now)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		_, err = h.InsertGroupViewer(ctx, req)
+		if err == nil {
+			t.Logf("%s: expected a bad request, but got nil error", c.Name)
+			t.Fail()
+			return
+		}
+		if !strings.Contains(err.Error(), "Bad Request") {
+			t.Logf("%s: expected error to contain 'Bad Request', but got %s", c.Name, err.Error())
+			t.Fail()
+			return
+		}
+	}
+
+	testData, _, _, err = jsonparser.Get(fixtures.Data["group_viewer_sweeper"], "TestInsertGroupViewerWindowsAndDelegation", "request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	c := &groupViewerWindowTestCase{}
+	if err := json.Unmarshal(testData, c); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	req, err := c.request(now)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if _, err := h.InsertGroupViewer(ctx, req); err != nil {
+		t.Log("expected Will to delegate access to his own subtree, but got", err)
+		t.Fail()
+		return
+	}
+}
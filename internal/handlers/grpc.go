@@ -1,8 +1,8 @@
 package handlers
 
 import (
-	bouncer "github.com/loupe-co/bouncer/pkg/client"
 	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/orchard/internal/cache"
 	"github.com/loupe-co/orchard/internal/clients"
 	"github.com/loupe-co/orchard/internal/config"
 	"github.com/loupe-co/orchard/internal/db"
@@ -13,29 +13,42 @@ var (
 	ErrBadRequest = errors.New("bad request").WithCode(codes.InvalidArgument)
 )
 
+// Handlers implement the orchard RPCs and background jobs. Everything that changes groups, people, viewers or roles
+// invalidates the tenant's cached hierarchy reads, whether or not it succeeded since a failed sync can still have written
+// part of its changes. A nil hierarchyCache caches nothing.
 type Handlers struct {
-	cfg           config.Config
-	db            *db.DB
-	tenantClient  *clients.TenantClient
-	crmClient     *clients.CRMClient
-	auth0Client   *clients.Auth0Client
-	bouncerClient *bouncer.BouncerClient
+	cfg              config.Config
+	db               db.Store
+	tenantClient     clients.TenantService
+	crmDataSource    clients.CRMDataSource
+	identityProvider clients.IdentityProvider
+	bouncerClient    clients.AuthCacheBuster
+	hierarchyCache   *cache.HierarchyCache
 }
 
 func New(
 	cfg config.Config,
-	dbClient *db.DB,
-	tenantClient *clients.TenantClient,
-	crmClient *clients.CRMClient,
-	auth0Client *clients.Auth0Client,
-	bouncerClient *bouncer.BouncerClient,
+	dbClient db.Store,
+	tenantClient clients.TenantService,
+	crmDataSource clients.CRMDataSource,
+	identityProvider clients.IdentityProvider,
+	bouncerClient clients.AuthCacheBuster,
+	hierarchyCache *cache.HierarchyCache,
 ) *Handlers {
 	return &Handlers{
-		cfg:           cfg,
-		db:            dbClient,
-		tenantClient:  tenantClient,
-		crmClient:     crmClient,
-		auth0Client:   auth0Client,
-		bouncerClient: bouncerClient,
+		cfg:              cfg,
+		db:               dbClient,
+		tenantClient:     tenantClient,
+		crmDataSource:    crmDataSource,
+		identityProvider: identityProvider,
+		bouncerClient:    bouncerClient,
+		hierarchyCache:   hierarchyCache,
 	}
 }
+
+// NewWithFakes wires handlers with in-memory fakes of every external client (tenant-service, crm-data-access, auth0, bouncer).
+// Only the database is real, the returned fakes record calls so tests can assert on side effects.
+func NewWithFakes(cfg config.Config, dbClient db.Store) (*Handlers, *clients.Fakes) {
+	fakes := clients.NewFakes()
+	return New(cfg, dbClient, fakes.Tenant, fakes.CRM, fakes.Identity, fakes.Bouncer, nil), fakes
+}
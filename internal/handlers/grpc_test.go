@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/buger/jsonparser"
 	configUtil "github.com/loupe-co/go-common/config"
@@ -16,9 +17,15 @@ import (
 	"github.com/loupe-co/orchard/internal/config"
 	"github.com/loupe-co/orchard/internal/db"
 	"github.com/loupe-co/orchard/internal/models"
+	"github.com/loupe-co/orchard/internal/permcatalog"
+	authPb "github.com/loupe-co/protos/src/common/auth"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/types"
 )
 
 var testServer *Handlers
+var testFakes *clients.Fakes
+var testConfig config.Config
 var generatedTestIDs = map[string][]string{
 	"system_role":  {},
 	"crm_role":     {},
@@ -55,13 +62,20 @@ func setup() (*Handlers, error) {
 		panic("Error parsing config from environment")
 	}
 
-	dbClient, err := db.New(cfg)
-	if err != nil {
-		return nil, err
-	}
-	crmClient, err := clients.NewCRMClient(cfg)
-	if err != nil {
-		return nil, err
+	// ORCHARD_TEST_DB=memory runs the handlers against the in-memory store seeded from the fixtures instead of postgres
+	var dbClient db.Store
+	if os.Getenv("ORCHARD_TEST_DB") == "memory" {
+		memoryStore := db.NewMemoryStore()
+		if err := seed(memoryStore); err != nil {
+			return nil, err
+		}
+		dbClient = memoryStore
+	} else {
+		pgClient, err := db.New(cfg)
+		if err != nil {
+			return nil, err
+		}
+		dbClient = pgClient
 	}
 	// tenantClient, err := clients.NewTenantClient(cfg)
 	// if err != nil {
@@ -80,10 +94,151 @@ func setup() (*Handlers, error) {
 	// if err := seed(dbClient); err != nil {
 	// 	return nil, err
 	// }
-	return &Handlers{cfg: cfg, db: dbClient, crmClient: crmClient}, nil
+	testConfig = cfg
+	h, fakes := NewWithFakes(cfg, dbClient)
+	testFakes = fakes
+	return h, nil
+}
+
+// setupMemoryServer runs handlers on a memory store of their own seeded from the fixtures, for tests that change data
+// the tests sharing testServer rely on or that need to know exactly what's in the store
+func setupMemoryServer() (*Handlers, *clients.Fakes, *db.MemoryStore, error) {
+	store := db.NewMemoryStore()
+
+	// postgres has the system roles already, a fresh store needs them seeded as well
+	systemRolesRaw, _, _, err := jsonparser.Get(fixtures.Data["seed"], "system_roles")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	systemRoles := []*models.SystemRole{}
+	if err := json.Unmarshal(systemRolesRaw, &systemRoles); err != nil {
+		return nil, nil, nil, err
+	}
+	for _, sr := range systemRoles {
+		if err := store.NewSystemRoleService().Insert(context.Background(), sr); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if err := seed(store); err != nil {
+		return nil, nil, nil, err
+	}
+	store.SetTenant(&models.Tenant{
+		ID:                db.DefaultTenantID,
+		Status:            "active",
+		Name:              "test",
+		GroupSyncState:    "inactive",
+		GroupSyncMetadata: types.JSON("{}"),
+	})
+
+	h, fakes := NewWithFakes(testConfig, store)
+	return h, fakes, store, nil
+}
+
+// testStoreData is what a test adds to a memory store on top of the seed, loaded from its fixture. Group permissions are
+// given by name so fixtures don't depend on how bouncer lays out the bits.
+type testStoreData struct {
+	Tenant       *testTenant        `json:"tenant"`
+	SystemRoles  []*testSystemRole  `json:"system_roles"`
+	People       []*models.Person   `json:"people"`
+	GroupViewers []*testGroupViewer `json:"group_viewers"`
+}
+
+type testTenant struct {
+	models.Tenant
+	GroupPermissions []string `json:"group_permissions"`
+}
+
+type testSystemRole struct {
+	models.SystemRole
+	GroupPermissions []string `json:"group_permissions"`
+}
+
+// testGroupViewer windows are durations from when the test runs, like "-1h", since a fixed time would go stale
+type testGroupViewer struct {
+	models.GroupViewer
+	GroupPermissions []string `json:"group_permissions"`
+	StartsIn         string   `json:"starts_in"`
+	EndsIn           string   `json:"ends_in"`
+}
+
+// testTimeIn is now moved by the duration d, or null when d is empty
+func testTimeIn(now time.Time, d string) (null.Time, error) {
+	if d == "" {
+		return null.Time{}, nil
+	}
+	offset, err := time.ParseDuration(d)
+	if err != nil {
+		return null.Time{}, err
+	}
+	return null.TimeFrom(now.Add(offset)), nil
+}
+
+// insertTestData inserts the fixture at keys of fixtures/<file>.json into store
+func insertTestData(store *db.MemoryStore, file string, keys ...string) (*testStoreData, error) {
+	raw, _, _, err := jsonparser.Get(fixtures.Data[file], keys...)
+	if err != nil {
+		return nil, err
+	}
+	data := &testStoreData{}
+	if err := json.Unmarshal(raw, data); err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+
+	if data.Tenant != nil {
+		if len(data.Tenant.GroupPermissions) > 0 {
+			bits, err := permcatalog.Encode(authPb.PermissionSet_Group, data.Tenant.GroupPermissions)
+			if err != nil {
+				return nil, err
+			}
+			data.Tenant.Permissions = permissionSets(authPb.PermissionSet_Group, bits)
+		}
+		store.SetTenant(&data.Tenant.Tenant)
+	}
+	for _, sr := range data.SystemRoles {
+		if len(sr.GroupPermissions) > 0 {
+			bits, err := permcatalog.Encode(authPb.PermissionSet_Group, sr.GroupPermissions)
+			if err != nil {
+				return nil, err
+			}
+			sr.Permissions = permissionSets(authPb.PermissionSet_Group, bits)
+		}
+		if err := store.NewSystemRoleService().Insert(ctx, &sr.SystemRole); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range data.People {
+		if err := store.NewPersonService().Insert(ctx, p); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now().UTC()
+	for _, viewer := range data.GroupViewers {
+		if len(viewer.GroupPermissions) > 0 {
+			bits, err := permcatalog.Encode(authPb.PermissionSet_Group, viewer.GroupPermissions)
+			if err != nil {
+				return nil, err
+			}
+			viewer.Permissions = bits
+		}
+		if viewer.StartsIn != "" {
+			if viewer.StartsAt, err = testTimeIn(now, viewer.StartsIn); err != nil {
+				return nil, err
+			}
+		}
+		if viewer.EndsIn != "" {
+			if viewer.EndsAt, err = testTimeIn(now, viewer.EndsIn); err != nil {
+				return nil, err
+			}
+		}
+		if err := store.NewGroupViewerService().Insert(ctx, &viewer.GroupViewer); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
 }
 
-func seed(dbClient *db.DB) error {
+func seed(dbClient db.Store) error {
 	// CRM Role
 	crmRolesRaw, _, _, err := jsonparser.Get(fixtures.Data["seed"], "crm_roles")
 	if err != nil {
@@ -167,7 +322,7 @@ func seed(dbClient *db.DB) error {
 	return nil
 }
 
-func teardown(dbClient *db.DB) error {
+func teardown(dbClient db.Store) error {
 	failedIDs := map[string][]string{
 		"system_role":  {},
 		"crm_role":     {},
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/go-loupe-logger/log"
+)
+
+// Auth0ReconcileLock is the lock the auth0 reconciler holds while it runs, so the cli and the server replicas never fix at the same time
+const Auth0ReconcileLock = "orchard:auth0-reconcile"
+
+// runLocked runs fn only when no other replica holds the job's lock, otherwise this run of the job is skipped
+func (h *Handlers) runLocked(ctx context.Context, job string, fn func()) {
+	unlock, ok, err := h.db.TryLock(ctx, job)
+	if err != nil {
+		log.WithContext(ctx).WithCustom("job", job).Error(errors.Wrap(err, "error taking background job lock"))
+		return
+	}
+	if !ok {
+		log.WithContext(ctx).WithCustom("job", job).Debug("background job is running elsewhere, skipping")
+		return
+	}
+	defer unlock()
+	fn()
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/db"
+	bouncerPb "github.com/loupe-co/protos/src/services/bouncer"
+)
+
+const (
+	outboxLease       = 2 * time.Minute
+	outboxBaseBackoff = 10 * time.Second
+	outboxMaxBackoff  = time.Hour
+)
+
+// RunOutboxDispatcher delivers outbox messages on an interval until the context is cancelled
+func (h *Handlers) RunOutboxDispatcher(ctx context.Context) error {
+	interval := time.Duration(h.cfg.OutboxIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			// Keep draining while full batches are coming back so a backlog doesn't wait on the ticker
+			for {
+				n, err := h.DispatchOutbox(ctx)
+				if err != nil {
+					log.WithContext(ctx).Error(errors.Wrap(err, "error dispatching outbox"))
+					break
+				}
+				if n < h.outboxBatchSize() || ctx.Err() != nil {
+					break
+				}
+			}
+		}
+	}
+}
+
+// DispatchOutbox claims a batch of due outbox messages and delivers them, returning the number of messages claimed
+func (h *Handlers) DispatchOutbox(ctx context.Context) (int, error) {
+	spanCtx, span := log.StartSpan(ctx, "DispatchOutbox")
+	defer span.End()
+
+	svc := h.db.NewOutboxService()
+
+	msgs, err := svc.Claim(spanCtx, h.outboxBatchSize(), outboxLease)
+	if err != nil {
+		return 0, errors.Wrap(err, "error claiming outbox messages")
+	}
+
+	for _, msg := range msgs {
+		logger := log.WithContext(spanCtx).
+			WithTenantID(msg.TenantID).
+			WithCustom("outboxId", msg.ID).
+			WithCustom("kind", msg.Kind).
+			WithCustom("attempts", msg.Attempts)
+
+		if err := h.deliverOutboxMessage(spanCtx, msg); err != nil {
+			dead := msg.Attempts >= h.cfg.OutboxMaxAttempts
+			if dead {
+				logger.Error(errors.Wrap(err, "outbox message exhausted retries, moving to dead letter"))
+			} else {
+				logger.Warn(errors.Wrap(err, "error delivering outbox message, will retry").Error())
+			}
+			if err := svc.MarkFailed(spanCtx, msg.ID, err.Error(), time.Now().UTC().Add(outboxBackoff(msg.Attempts)), dead); err != nil {
+				logger.Error(errors.Wrap(err, "error marking outbox message failed"))
+			}
+			continue
+		}
+
+		if err := svc.MarkDelivered(spanCtx, msg.ID); err != nil {
+			logger.Error(errors.Wrap(err, "error marking outbox message delivered"))
+		}
+	}
+
+	return len(msgs), nil
+}
+
+func (h *Handlers) deliverOutboxMessage(ctx context.Context, msg *db.OutboxMessage) error {
+	payload, err := msg.GetPayload()
+	if err != nil {
+		return errors.Wrap(err, "error parsing outbox payload")
+	}
+
+	switch msg.Kind {
+	case db.OutboxKindBustAuthCache:
+		if _, err := h.bouncerClient.BustAuthCache(ctx, &bouncerPb.BustAuthCacheRequest{TenantId: msg.TenantID, UserId: payload.PersonID}); err != nil {
+			return errors.Wrap(err, "error busting auth data cache in bouncer")
+		}
+	case db.OutboxKindProvisionUser:
+		personSvc := h.db.NewPersonService()
+		// The person's email may have changed again since the message was queued, so provision the email they have now.
+		// The queued email is only used once the person is gone or has no email.
+		email := payload.Email
+		if payload.PersonID != "" {
+			person, err := personSvc.GetByID(ctx, payload.PersonID, msg.TenantID)
+			if err != nil && err != sql.ErrNoRows {
+				return errors.Wrap(err, "error getting person record for provisioning")
+			}
+			if person != nil && !person.Email.IsZero() {
+				email = person.Email.String
+			}
+		}
+		if _, err := updateUserProvisioning(ctx, msg.TenantID, payload.PersonID, email, personSvc, h.identityProvider); err != nil {
+			return errors.Wrap(err, "error provisioning user in auth0")
+		}
+	default:
+		return errors.New(fmt.Sprintf("unknown outbox message kind %s", msg.Kind))
+	}
+
+	return nil
+}
+
+func (h *Handlers) outboxBatchSize() int {
+	if h.cfg.OutboxBatchSize > 0 {
+		return h.cfg.OutboxBatchSize
+	}
+	return 100
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxBaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= outboxMaxBackoff {
+			return outboxMaxBackoff
+		}
+	}
+	return backoff
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/loupe-co/orchard/internal/db"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	expected := map[int]time.Duration{
+		0:  10 * time.Second,
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		3:  40 * time.Second,
+		9:  2560 * time.Second,
+		10: time.Hour,
+		50: time.Hour,
+	}
+	for attempts, backoff := range expected {
+		if res := outboxBackoff(attempts); res != backoff {
+			t.Logf("expected a backoff of %s after %d attempts, but got %s", backoff, attempts, res)
+			t.Fail()
+		}
+	}
+}
+
+type outboxFailure struct {
+	id      string
+	retryAt time.Time
+	dead    bool
+}
+
+// failureRecordingOutbox records when DispatchOutbox asks for a retry but makes the message due straight away,
+// so the test doesn't have to wait out the backoff
+type failureRecordingOutbox struct {
+	db.OutboxRepository
+	failures *[]outboxFailure
+}
+
+func (svc *failureRecordingOutbox) MarkFailed(ctx context.Context, id, lastError string, retryAt time.Time, dead bool) error {
+	*svc.failures = append(*svc.failures, outboxFailure{id: id, retryAt: retryAt, dead: dead})
+	return svc.OutboxRepository.MarkFailed(ctx, id, lastError, time.Now().UTC(), dead)
+}
+
+type failureRecordingStore struct {
+	*db.MemoryStore
+	failures []outboxFailure
+}
+
+func (store *failureRecordingStore) NewOutboxService() db.OutboxRepository {
+	return &failureRecordingOutbox{OutboxRepository: store.MemoryStore.NewOutboxService(), failures: &store.failures}
+}
+
+func TestDispatchOutboxRetriesWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	cfg := testConfig
+	cfg.OutboxMaxAttempts = 3
+	// a store of its own, the dispatcher claims every due message and the fake bouncer is set to fail
+	store := &failureRecordingStore{MemoryStore: db.NewMemoryStore()}
+	h, fakes := NewWithFakes(cfg, store)
+
+	personID := db.MakeID()
+	msg := db.NewBustAuthCacheMessage(db.DefaultTenantID, personID)
+	if err := store.NewOutboxService().Enqueue(ctx, msg); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	fakes.Bouncer.FailWith("BustAuthCache", errors.New("bouncer is down"))
+
+	for attempt := 1; attempt <= cfg.OutboxMaxAttempts; attempt++ {
+		before := time.Now().UTC()
+		n, err := h.DispatchOutbox(ctx)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		after := time.Now().UTC()
+		if n != 1 || len(store.failures) != attempt {
+			t.Logf("expected attempt %d to claim the message and fail it, but got %d claimed and %d failures", attempt, n, len(store.failures))
+			t.Fail()
+			return
+		}
+
+		failure := store.failures[attempt-1]
+		backoff := outboxBackoff(attempt)
+		if failure.id != msg.ID || failure.retryAt.Before(before.Add(backoff)) || failure.retryAt.After(after.Add(backoff)) {
+			t.Logf("expected attempt %d to be retried %s later, but got %s after %s", attempt, backoff, failure.retryAt, before)
+			t.Fail()
+			return
+		}
+		if dead := attempt == cfg.OutboxMaxAttempts; failure.dead != dead {
+			t.Logf("expected attempt %d of %d to be dead %t, but got %t", attempt, cfg.OutboxMaxAttempts, dead, failure.dead)
+			t.Fail()
+			return
+		}
+	}
+	if calls := fakes.Bouncer.CallCount("BustAuthCache"); calls != cfg.OutboxMaxAttempts {
+		t.Logf("expected bouncer to be called once per attempt, but got %d calls", calls)
+		t.Fail()
+		return
+	}
+
+	dead, err := store.NewOutboxService().GetDeadLetters(ctx, db.DefaultTenantID, 10, 0)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(dead) != 1 || dead[0].ID != msg.ID || !strings.Contains(dead[0].LastError.String, "bouncer is down") {
+		t.Log("expected the exhausted message in the dead letter view with its error, but got", dead)
+		t.Fail()
+		return
+	}
+	if n, err := h.DispatchOutbox(ctx); err != nil || n != 0 {
+		t.Log("expected a dead message to not be claimed, but got", n, err)
+		t.Fail()
+		return
+	}
+
+	// once bouncer is back a requeued message is delivered, reset forgets the failed busts as well
+	fakes.Reset()
+	if err := store.NewOutboxService().Requeue(ctx, msg.ID); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if n, err := h.DispatchOutbox(ctx); err != nil || n != 1 {
+		t.Log("expected the requeued message to be claimed, but got", n, err)
+		t.Fail()
+		return
+	}
+	busted := fakes.Bouncer.BustedUserIDs(db.DefaultTenantID)
+	if len(busted) != 1 || busted[0] != personID {
+		t.Log("expected the requeued message to bust the person's auth cache, but got", busted)
+		t.Fail()
+		return
+	}
+	if len(store.failures) != cfg.OutboxMaxAttempts {
+		t.Log("expected the delivered message to not be marked failed again, but got", len(store.failures), "failures")
+		t.Fail()
+		return
+	}
+}
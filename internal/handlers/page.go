@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/loupe-co/orchard/internal/db"
+)
+
+const defaultSortBy = "name"
+
+// pageToken is the opaque next page token of a keyset paginated search, the sort and last row of the page it follows
+type pageToken struct {
+	SortBy string `json:"s"`
+	Desc   bool   `json:"d,omitempty"`
+	Value  string `json:"v"`
+	ID     string `json:"id"`
+}
+
+func encodePageToken(token pageToken) string {
+	raw, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodePageToken(token string) (pageToken, bool) {
+	decoded := pageToken{}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return decoded, false
+	}
+	if err := json.Unmarshal(raw, &decoded); err != nil || decoded.ID == "" {
+		return decoded, false
+	}
+	return decoded, true
+}
+
+// newPage builds the db page of a search request. One more row than limit is asked for, so the handler knows whether
+// there is a next page. A token has to come from a search with the same sort, since the keyset is only valid for it.
+func newPage(sortBy string, desc bool, limit, offset int, token string, countTotal bool, sortColumns map[string]string) (db.Page, error) {
+	sortBy = strings.ToLower(sortBy)
+	if sortBy == "" {
+		sortBy = defaultSortBy
+	}
+	if _, ok := sortColumns[sortBy]; !ok {
+		return db.Page{}, fmt.Errorf("can't sort by %s", sortBy)
+	}
+
+	page := db.Page{
+		SortBy:     sortBy,
+		Desc:       desc,
+		Limit:      limit + 1,
+		Offset:     offset,
+		CountTotal: countTotal,
+	}
+	if token == "" {
+		return page, nil
+	}
+
+	decoded, ok := decodePageToken(token)
+	if !ok {
+		return db.Page{}, fmt.Errorf("invalid page token")
+	}
+	if decoded.SortBy != sortBy || decoded.Desc != desc {
+		return db.Page{}, fmt.Errorf("page token is for a different sort")
+	}
+	if strings.HasSuffix(sortBy, "_at") {
+		if _, err := time.Parse(db.PageTimeFormat, decoded.Value); err != nil {
+			return db.Page{}, fmt.Errorf("invalid page token")
+		}
+	}
+	page.AfterValue = decoded.Value
+	page.AfterID = decoded.ID
+	page.Offset = 0
+	return page, nil
+}
+
+// nextPage trims the extra row newPage asked for and returns the token of the page after rows, or "" on the last page
+func nextPage[T any](rows []T, page db.Page, sortValue func(T, string) string, id func(T) string) ([]T, string) {
+	limit := page.Limit - 1
+	if len(rows) <= limit {
+		return rows, ""
+	}
+	rows = rows[:limit]
+	if limit == 0 {
+		return rows, ""
+	}
+	last := rows[limit-1]
+	return rows, encodePageToken(pageToken{
+		SortBy: page.SortBy,
+		Desc:   page.Desc,
+		Value:  sortValue(last, page.SortBy),
+		ID:     id(last),
+	})
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/loupe-co/go-common/fixtures"
+	"github.com/loupe-co/orchard/internal/db"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+)
+
+// getPageTestTokens is the page tokens at keys of fixtures/page.json
+func getPageTestTokens(keys ...string) ([]pageToken, error) {
+	raw, _, _, err := jsonparser.Get(fixtures.Data["page"], keys...)
+	if err != nil {
+		return nil, err
+	}
+	tokens := []pageToken{}
+	if err := json.Unmarshal(raw, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// getEncodedPageTestToken is the page token at keys of fixtures/page.json, encoded the way it's handed out
+func getEncodedPageTestToken(keys ...string) (string, error) {
+	raw, _, _, err := jsonparser.Get(fixtures.Data["page"], keys...)
+	if err != nil {
+		return "", err
+	}
+	token := pageToken{}
+	if err := json.Unmarshal(raw, &token); err != nil {
+		return "", err
+	}
+	return encodePageToken(token), nil
+}
+
+// getSearchPeopleTestRequest is the SearchPeople request at keys of fixtures/page.json
+func getSearchPeopleTestRequest(keys ...string) (*servicePb.SearchPeopleRequest, error) {
+	raw, _, _, err := jsonparser.Get(fixtures.Data["page"], keys...)
+	if err != nil {
+		return nil, err
+	}
+	req := &servicePb.SearchPeopleRequest{}
+	if err := json.Unmarshal(raw, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// searchPeopleByPages follows page tokens for req until the last page and returns every person it saw
+func searchPeopleByPages(req *servicePb.SearchPeopleRequest, pageSize int32) ([]*orchardPb.Person, error) {
+	people := []*orchardPb.Person{}
+	token := ""
+	for i := 0; i <= 1000; i++ {
+		res, err := testServer.SearchPeople(context.Background(), &servicePb.SearchPeopleRequest{
+			TenantId:  req.TenantId,
+			PageSize:  pageSize,
+			SortBy:    req.SortBy,
+			SortDesc:  req.SortDesc,
+			PageToken: token,
+			SkipTotal: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		people = append(people, res.People...)
+		if res.NextPageToken == "" {
+			return people, nil
+		}
+		token = res.NextPageToken
+	}
+	return nil, errors.New("page tokens never reached the last page")
+}
+
+func TestPageTokenRoundTrip(t *testing.T) {
+	tokens, err := getPageTestTokens("TestPageTokenRoundTrip", "tokens")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	for _, token := range tokens {
+		decoded, ok := decodePageToken(encodePageToken(token))
+		if !ok {
+			t.Logf("expected %+v to decode", token)
+			t.Fail()
+			return
+		}
+		if decoded != token {
+			t.Logf("expected %+v, but got %+v", token, decoded)
+			t.Fail()
+			return
+		}
+	}
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["page"], "TestPageTokenRoundTrip", "bad_tokens")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	badTokens := []string{}
+	if err := json.Unmarshal(testData, &badTokens); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	withoutID, err := getPageTestTokens("TestPageTokenRoundTrip", "tokens_without_id")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	for _, token := range withoutID {
+		badTokens = append(badTokens, encodePageToken(token))
+	}
+	for _, token := range badTokens {
+		if _, ok := decodePageToken(token); ok {
+			t.Logf("expected %q not to decode", token)
+			t.Fail()
+			return
+		}
+	}
+}
+
+func TestNewPageFromToken(t *testing.T) {
+	token, err := getEncodedPageTestToken("TestNewPageFromToken", "token")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	page, err := newPage("TYPE", false, 10, 30, token, false, db.PersonSortColumns)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if page.SortBy != "type" || page.AfterValue != "person" || page.AfterID != "8b1c7a4e-0000-0000-0000-000000000001" {
+		t.Logf("expected the token's keyset, but got %+v", page)
+		t.Fail()
+		return
+	}
+	if page.Offset != 0 || page.Limit != 11 {
+		t.Logf("expected a keyset page to ignore the offset and ask for one extra row, but got %+v", page)
+		t.Fail()
+		return
+	}
+
+	if _, err := newPage("type", true, 10, 0, token, false, db.PersonSortColumns); err == nil {
+		t.Log("expected a token from an ascending sort to be rejected for a descending one")
+		t.Fail()
+		return
+	}
+	if _, err := newPage("name", false, 10, 0, token, false, db.PersonSortColumns); err == nil {
+		t.Log("expected a token from a type sort to be rejected for a name sort")
+		t.Fail()
+		return
+	}
+	if _, err := newPage("email", false, 10, 0, "", false, db.GroupSortColumns); err == nil {
+		t.Log("expected groups not to sort by email")
+		t.Fail()
+		return
+	}
+
+	badTime, err := getEncodedPageTestToken("TestNewPageFromToken", "bad_time_token")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if _, err := newPage("created_at", false, 10, 0, badTime, false, db.PersonSortColumns); err == nil {
+		t.Log("expected a time sort token with an unparsable value to be rejected")
+		t.Fail()
+		return
+	}
+}
+
+func TestSearchPeoplePageTokensByType(t *testing.T) {
+	req, err := getSearchPeopleTestRequest("TestSearchPeoplePageTokensByType", "request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	for _, desc := range []bool{false, true} {
+		req.SortDesc = desc
+		all, err := testServer.SearchPeople(context.Background(), req)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if len(all.People) < 3 {
+			t.Logf("expected the seed tenant to have enough people to page through, but got %d", len(all.People))
+			t.Fail()
+			return
+		}
+
+		paged, err := searchPeopleByPages(req, 2)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if len(paged) != len(all.People) {
+			t.Logf("desc %v: expected %d people across the pages, but got %d", desc, len(all.People), len(paged))
+			t.Fail()
+			return
+		}
+		for i, p := range paged {
+			if p.Id != all.People[i].Id {
+				t.Logf("desc %v: expected person %s at %d, but got %s", desc, all.People[i].Id, i, p.Id)
+				t.Fail()
+				return
+			}
+			if i == 0 {
+				continue
+			}
+			// Types are ordered by their text, the same way the page token's value compares
+			prev := paged[i-1]
+			if !desc && prev.Type > p.Type || desc && prev.Type < p.Type {
+				t.Logf("desc %v: %s (%s) is out of order after %s (%s)", desc, p.Id, p.Type, prev.Id, prev.Type)
+				t.Fail()
+				return
+			}
+		}
+	}
+}
+
+func TestSearchPeoplePageTokensByName(t *testing.T) {
+	req, err := getSearchPeopleTestRequest("TestSearchPeoplePageTokensByName", "request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	all, err := testServer.SearchPeople(context.Background(), req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	paged, err := searchPeopleByPages(req, 3)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	seen := map[string]bool{}
+	for _, p := range paged {
+		if seen[p.Id] {
+			t.Logf("person %s was returned on more than one page", p.Id)
+			t.Fail()
+			return
+		}
+		seen[p.Id] = true
+	}
+	if len(paged) != len(all.People) {
+		t.Logf("expected %d people across the pages, but got %d", len(all.People), len(paged))
+		t.Fail()
+		return
+	}
+}
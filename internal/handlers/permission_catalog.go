@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/loupe-co/orchard/internal/permcatalog"
+	authPb "github.com/loupe-co/protos/src/common/auth"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+)
+
+// GetPermissionCatalog lists every permission set and the permissions in it with their names, descriptions and bits
+func (h *Handlers) GetPermissionCatalog(ctx context.Context, in *servicePb.GetPermissionCatalogRequest) (*servicePb.GetPermissionCatalogResponse, error) {
+	return &servicePb.GetPermissionCatalogResponse{
+		PermissionSets: permcatalog.ToProto(),
+	}, nil
+}
+
+// resolveGroupViewerPermissions encodes a group viewer's named permissions into its bits and checks they are all group permissions
+func resolveGroupViewerPermissions(gv *orchardPb.GroupViewer) error {
+	if gv.NamedPermissions != nil {
+		bits, err := permcatalog.Encode(authPb.PermissionSet_Group, gv.NamedPermissions.Names)
+		if err != nil {
+			return err
+		}
+		if gv.Permissions != 0 && gv.Permissions != bits {
+			return fmt.Errorf("permissions were given as %s and as %#x", permcatalog.Format(authPb.PermissionSet_Group, bits), gv.Permissions)
+		}
+		gv.Permissions = bits
+	}
+	return permcatalog.Validate(authPb.PermissionSet_Group, gv.Permissions)
+}
+
+// resolveSystemRolePermissions encodes a system role's named permissions into its per permission set bits and checks every bit is
+// a permission in its set
+func resolveSystemRolePermissions(sr *orchardPb.SystemRole) error {
+	if len(sr.NamedPermissions) > 0 {
+		permissions, err := permcatalog.EncodeNamedSets(sr.Permissions, sr.NamedPermissions)
+		if err != nil {
+			return err
+		}
+		sr.Permissions = permissions
+	}
+	return permcatalog.ValidateSets(sr.Permissions)
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/loupe-co/go-common/fixtures"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/permcatalog"
+	authPb "github.com/loupe-co/protos/src/common/auth"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+)
+
+func TestPermissionCatalogRoundTrip(t *testing.T) {
+	for _, s := range permcatalog.Sets() {
+		for _, p := range s.Permissions {
+			bits, err := permcatalog.Encode(s.Set, []string{p.Name})
+			if err != nil {
+				t.Log(err)
+				t.Fail()
+				return
+			}
+			if bits != p.Bits {
+				t.Logf("%s %s: expected %#x, but got %#x", s.Name, p.Name, p.Bits, bits)
+				t.Fail()
+				return
+			}
+			names, unknown := permcatalog.Decode(s.Set, bits)
+			if len(names) != 1 || names[0] != p.Name || unknown != 0 {
+				t.Logf("%s %s: expected to decode to its own name, but got %v with unknown bits %#x", s.Name, p.Name, names, unknown)
+				t.Fail()
+				return
+			}
+		}
+		if err := permcatalog.Validate(s.Set, s.Mask); err != nil {
+			t.Logf("%s: expected its whole mask to be valid, but got %s", s.Name, err.Error())
+			t.Fail()
+			return
+		}
+	}
+}
+
+func TestPermissionCatalogGroupPermissions(t *testing.T) {
+	accessRead := groupPermissionBits(authPb.Permission_Access, authPb.Permission_Read)
+
+	bits, err := permcatalog.Encode(authPb.PermissionSet_Group, []string{"access", " READ "})
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if bits != accessRead {
+		t.Logf("expected names to be matched ignoring case and space, but got %#x", bits)
+		t.Fail()
+		return
+	}
+	if formatted := permcatalog.Format(authPb.PermissionSet_Group, accessRead); formatted != "Access|Read" {
+		t.Logf("expected Access|Read, but got %q", formatted)
+		t.Fail()
+		return
+	}
+	if formatted := permcatalog.Format(authPb.PermissionSet_Group, 0); formatted != "none" {
+		t.Logf("expected none, but got %q", formatted)
+		t.Fail()
+		return
+	}
+
+	if _, err := permcatalog.Encode(authPb.PermissionSet_Group, []string{"Access", "Fly"}); err == nil {
+		t.Log("expected an unknown permission name to be an error")
+		t.Fail()
+		return
+	}
+
+	set, err := permcatalog.ParseSet("group")
+	if err != nil || set != authPb.PermissionSet_Group {
+		t.Log("expected group to parse to the Group set, but got", set, err)
+		t.Fail()
+		return
+	}
+	if _, err := permcatalog.ParseSet("nope"); err == nil {
+		t.Log("expected an unknown permission set name to be an error")
+		t.Fail()
+		return
+	}
+}
+
+func TestPermissionCatalogUnknownBits(t *testing.T) {
+	s, ok := permcatalog.GetSet(authPb.PermissionSet_Group)
+	if !ok {
+		t.Log("expected the Group set in the catalog")
+		t.Fail()
+		return
+	}
+	unknownBit := int64(1) << 62
+	if s.Mask&unknownBit != 0 {
+		t.Skip("every bit is a group permission")
+	}
+
+	names, unknown := permcatalog.Decode(authPb.PermissionSet_Group, s.Mask|unknownBit)
+	if len(names) != len(s.Permissions) || unknown != unknownBit {
+		t.Logf("expected every permission and the unknown bit back, but got %v and %#x", names, unknown)
+		t.Fail()
+		return
+	}
+	if err := permcatalog.Validate(authPb.PermissionSet_Group, unknownBit); err == nil {
+		t.Log("expected a bit outside the set to be invalid")
+		t.Fail()
+		return
+	}
+	if err := permcatalog.ValidateSets(permissionSets(authPb.PermissionSet_Group, unknownBit)); err == nil {
+		t.Log("expected ValidateSets to check the bits at each set's index")
+		t.Fail()
+		return
+	}
+}
+
+func TestPermissionCatalogNamedSets(t *testing.T) {
+	accessRead := groupPermissionBits(authPb.Permission_Access, authPb.Permission_Read)
+	named := []*orchardPb.NamedPermissions{{PermissionSet: authPb.PermissionSet_Group, Names: []string{"Access", "Read"}}}
+
+	permissions, err := permcatalog.EncodeNamedSets(nil, named)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if !reflect.DeepEqual(permissions, []int64(permissionSets(authPb.PermissionSet_Group, accessRead))) {
+		t.Log("expected the group bits at the Group index, but got", permissions)
+		t.Fail()
+		return
+	}
+
+	if _, err := permcatalog.EncodeNamedSets(permissionSets(authPb.PermissionSet_Group, groupPermissionBits(authPb.Permission_Access)), named); err == nil {
+		t.Log("expected names and bits that disagree to be an error")
+		t.Fail()
+		return
+	}
+
+	decoded := permcatalog.NamedSets(permissions)
+	if len(decoded) != 1 || decoded[0].PermissionSet != authPb.PermissionSet_Group || !reflect.DeepEqual(decoded[0].Names, []string{"Access", "Read"}) {
+		t.Log("expected the named group set back, but got", decoded)
+		t.Fail()
+		return
+	}
+}
+
+func TestGetPermissionCatalog(t *testing.T) {
+	testData, _, _, err := jsonparser.Get(fixtures.Data["permission_catalog"], "TestGetPermissionCatalog")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	req := &servicePb.GetPermissionCatalogRequest{}
+	if err := json.Unmarshal(testData, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	res, err := testServer.GetPermissionCatalog(context.Background(), req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(res.PermissionSets) != len(permcatalog.Sets()) {
+		t.Logf("expected every set in the catalog, but got %d", len(res.PermissionSets))
+		t.Fail()
+		return
+	}
+
+	var group *orchardPb.PermissionSetDefinition
+	for _, def := range res.PermissionSets {
+		if def.PermissionSet == authPb.PermissionSet_Group {
+			group = def
+		}
+	}
+	if group == nil {
+		t.Log("expected the Group set in the catalog")
+		t.Fail()
+		return
+	}
+	found := map[string]int64{}
+	for _, p := range group.Permissions {
+		found[p.Name] = p.Bits
+	}
+	if found["Access"] != groupPermissionBits(authPb.Permission_Access) || found["Read"] != groupPermissionBits(authPb.Permission_Read) {
+		t.Log("expected Access and Read with their bits, but got", found)
+		t.Fail()
+		return
+	}
+
+	rawResult, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if err := fixtures.WriteTestResult("../../fixtures/results/TestGetPermissionCatalog.json", rawResult); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+}
+
+func TestInsertGroupViewerNamedPermissions(t *testing.T) {
+	h, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["permission_catalog"], "TestInsertGroupViewerNamedPermissions", "bad_requests")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	badRequests := []*servicePb.InsertGroupViewerRequest{}
+	if err := json.Unmarshal(testData, &badRequests); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	// names and bits that disagree
+	badRequests = append(badRequests, &servicePb.InsertGroupViewerRequest{
+		TenantId: db.DefaultTenantID,
+		GroupViewer: &orchardPb.GroupViewer{
+			TenantId:         db.DefaultTenantID,
+			PersonId:         seedOliviaID,
+			GroupId:          seedEMEAAEsID,
+			Permissions:      groupPermissionBits(authPb.Permission_Access),
+			NamedPermissions: &orchardPb.NamedPermissions{Names: []string{"Read"}},
+		},
+	})
+
+	testData, _, _, err = jsonparser.Get(fixtures.Data["permission_catalog"], "TestInsertGroupViewerNamedPermissions", "request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	req := &servicePb.InsertGroupViewerRequest{}
+	if err := json.Unmarshal(testData, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// the fixtures name group permissions
+	for _, r := range append(badRequests, req) {
+		r.GroupViewer.NamedPermissions.PermissionSet = authPb.PermissionSet_Group
+	}
+
+	for _, badReq := range badRequests {
+		_, err := h.InsertGroupViewer(ctx, badReq)
+		if err == nil {
+			t.Log("expected", badReq.GroupViewer.NamedPermissions.Names, "to be a bad request, but got nil error")
+			t.Fail()
+			return
+		}
+		if !strings.Contains(err.Error(), "Bad Request") {
+			t.Log("expected error to contain 'Bad Request', but got", err)
+			t.Fail()
+			return
+		}
+	}
+
+	if _, err := h.InsertGroupViewer(ctx, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	grants, err := store.NewGroupViewerService().GetPersonGrants(ctx, req.TenantId, req.GroupViewer.PersonId)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	for _, gv := range grants {
+		if gv.GroupID != req.GroupViewer.GroupId {
+			continue
+		}
+		if gv.Permissions != groupPermissionBits(authPb.Permission_Access, authPb.Permission_Read) {
+			t.Logf("expected the named permissions to be stored as bits, but got %#x", gv.Permissions)
+			t.Fail()
+		}
+		return
+	}
+	t.Log("expected the grant to be inserted")
+	t.Fail()
+}
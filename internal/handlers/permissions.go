@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	perm "github.com/loupe-co/bouncer/pkg/permissions"
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/models"
+	"github.com/loupe-co/orchard/internal/permcatalog"
+	authPb "github.com/loupe-co/protos/src/common/auth"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+	"google.golang.org/grpc/codes"
+)
+
+// maxBaseRoleDepth bounds how far base_role_id is followed, so a cycle in the data can't hang a request
+const maxBaseRoleDepth = 8
+
+// defaultGroupViewerPermissions is the group permission set a group viewer gets when none is given, and what people get on their own subtree
+func defaultGroupViewerPermissions() int64 {
+	p := perm.NewPermissions().WithPermissions(
+		perm.NewPermission(authPb.PermissionSet_Group, authPb.Permission_Access),
+		perm.NewPermission(authPb.PermissionSet_Group, authPb.Permission_Read),
+	)
+	return p[authPb.PermissionSet_Group]
+}
+
+// roleAssignment is a system role a person has and where it came from
+type roleAssignment struct {
+	roleID string
+	role   *models.SystemRole
+	source orchardPb.PermissionSource
+	// sourceID is the group the role was assigned through, empty for roles on the person
+	sourceID string
+	// chain is the role followed by its base roles, nearest first
+	chain []*models.SystemRole
+	// provider is the role in chain whose permissions the role resolves to, the role itself unless it has none of its own
+	provider *models.SystemRole
+}
+
+// groupAccess is a group a person can view and how
+type groupAccess struct {
+	groupID     string
+	direct      bool
+	implicit    bool
+	permissions int64
+}
+
+// resolvedPermissions is everything that grants a person access in a tenant, combined the way bouncer applies it
+type resolvedPermissions struct {
+	person *models.Person
+	roles  []*roleAssignment
+	// sets are the resolved permission bits per permission set, after the tenant's permissions are applied
+	sets   map[authPb.PermissionSet]int64
+	groups map[string]*groupAccess
+	// grants trace every bit in sets and groups back to where it came from, in the order they were applied
+	grants []*orchardPb.PermissionGrant
+}
+
+// resolvePermissions computes a person's effective permissions. System roles come from the person and from the group they are in,
+// a role without permissions of its own resolves to its nearest base role that has some. Role permissions are ORed per permission set
+// and then limited to the tenant's permissions, when the tenant has any. Groups are viewable directly through group viewers and
+// implicitly through the person's own group and its descendants. It returns nil for a person that isn't in the tenant.
+func (h *Handlers) resolvePermissions(ctx context.Context, tenantID, personID string) (*resolvedPermissions, error) {
+	person, err := h.db.NewPersonService().GetByID(ctx, personID, tenantID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting person")
+	}
+
+	resolved := &resolvedPermissions{
+		person: person,
+		sets:   map[authPb.PermissionSet]int64{},
+		groups: map[string]*groupAccess{},
+	}
+
+	groupSvc := h.db.NewGroupService()
+	var personGroup *models.Group
+	if person.GroupID.Valid && person.GroupID.String != "" {
+		personGroup, err = groupSvc.GetByID(ctx, person.GroupID.String, tenantID)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, errors.Wrap(err, "error getting person group")
+		}
+		if personGroup != nil && personGroup.Status != "active" {
+			personGroup = nil
+		}
+	}
+
+	if err := h.resolveRoles(ctx, resolved, personGroup); err != nil {
+		return nil, err
+	}
+
+	tenant, err := h.db.NewTenantService().GetByID(ctx, tenantID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "error getting tenant")
+	}
+	if tenant != nil && len(tenant.Permissions) > 0 {
+		for set, bits := range resolved.sets {
+			allowed := int64(0)
+			if int(set) < len(tenant.Permissions) {
+				allowed = tenant.Permissions[set]
+			}
+			if denied := bits &^ allowed; denied != 0 {
+				resolved.sets[set] = bits & allowed
+				resolved.grants = append(resolved.grants, &orchardPb.PermissionGrant{
+					PermissionSet: set,
+					Permissions:   denied,
+					Source:        orchardPb.PermissionSource_TenantPermissions,
+					SourceId:      tenantID,
+					Denied:        true,
+					Reason:        "not in the tenant's permissions",
+				})
+			}
+		}
+	}
+
+	viewers, err := h.db.NewGroupViewerService().GetPersonsViewableGroups(ctx, tenantID, personID)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting group viewers")
+	}
+	for _, gv := range viewers {
+		access := resolved.group(gv.GroupID)
+		access.direct = true
+		access.permissions |= gv.Permissions
+		reason := "group viewer"
+		if gv.DelegatedBy.Valid {
+			reason = "group viewer delegated by " + gv.DelegatedBy.String
+		}
+		if gv.EndsAt.Valid {
+			reason += " until " + gv.EndsAt.Time.UTC().Format(time.RFC3339)
+		}
+		resolved.grants = append(resolved.grants, &orchardPb.PermissionGrant{
+			PermissionSet: authPb.PermissionSet_Group,
+			Permissions:   gv.Permissions,
+			Source:        orchardPb.PermissionSource_GroupViewer,
+			SourceId:      gv.GroupID,
+			GroupId:       gv.GroupID,
+			Reason:        reason,
+		})
+	}
+
+	if personGroup != nil {
+		subTree, err := groupSvc.GetSubTreeIDs(ctx, tenantID, personGroup.ID)
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting person group subtree")
+		}
+		bits := defaultGroupViewerPermissions()
+		for _, groupID := range subTree {
+			access := resolved.group(groupID)
+			access.implicit = true
+			access.permissions |= bits
+			resolved.grants = append(resolved.grants, &orchardPb.PermissionGrant{
+				PermissionSet: authPb.PermissionSet_Group,
+				Permissions:   bits,
+				Source:        orchardPb.PermissionSource_OwnSubtree,
+				SourceId:      personGroup.ID,
+				GroupId:       groupID,
+				Reason:        "in the person's own subtree",
+			})
+		}
+	}
+
+	return resolved, nil
+}
+
+func (resolved *resolvedPermissions) group(groupID string) *groupAccess {
+	access, ok := resolved.groups[groupID]
+	if !ok {
+		access = &groupAccess{groupID: groupID}
+		resolved.groups[groupID] = access
+	}
+	return access
+}
+
+// resolveRoles loads the person's and their group's active system roles with their base roles and ORs their permissions into resolved
+func (h *Handlers) resolveRoles(ctx context.Context, resolved *resolvedPermissions, personGroup *models.Group) error {
+	assignments := []*roleAssignment{}
+	seen := map[string]bool{}
+	for _, id := range resolved.person.RoleIds {
+		if !seen[id] {
+			seen[id] = true
+			assignments = append(assignments, &roleAssignment{roleID: id, source: orchardPb.PermissionSource_PersonRole})
+		}
+	}
+	if personGroup != nil {
+		for _, id := range personGroup.RoleIds {
+			if !seen[id] {
+				seen[id] = true
+				assignments = append(assignments, &roleAssignment{roleID: id, source: orchardPb.PermissionSource_GroupRole, sourceID: personGroup.ID})
+			}
+		}
+	}
+	if len(assignments) == 0 {
+		return nil
+	}
+
+	// load the roles and then their base roles a level at a time
+	roles := map[string]*models.SystemRole{}
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	svc := h.db.NewSystemRoleService()
+	for depth := 0; len(ids) > 0 && depth <= maxBaseRoleDepth; depth++ {
+		srs, err := svc.GetByIDs(ctx, ids...)
+		if err != nil {
+			return errors.Wrap(err, "error getting system roles")
+		}
+		ids = []string{}
+		for _, sr := range srs {
+			roles[sr.ID] = sr
+		}
+		for _, sr := range srs {
+			if sr.BaseRoleID.Valid && sr.BaseRoleID.String != "" && roles[sr.BaseRoleID.String] == nil {
+				ids = append(ids, sr.BaseRoleID.String)
+			}
+		}
+	}
+
+	for _, assignment := range assignments {
+		role, ok := roles[assignment.roleID]
+		if !ok || role.Status != "active" {
+			continue
+		}
+		assignment.role = role
+		assignment.chain = []*models.SystemRole{role}
+		for r := role; r.BaseRoleID.Valid && r.BaseRoleID.String != "" && len(assignment.chain) <= maxBaseRoleDepth; {
+			base, ok := roles[r.BaseRoleID.String]
+			if !ok {
+				break
+			}
+			assignment.chain = append(assignment.chain, base)
+			r = base
+		}
+		for _, r := range assignment.chain {
+			if len(r.Permissions) > 0 {
+				assignment.provider = r
+				break
+			}
+		}
+		resolved.roles = append(resolved.roles, assignment)
+
+		if assignment.provider == nil {
+			continue
+		}
+		reason := "system role " + role.Name
+		baseID := baseRoleID(role, assignment.provider)
+		if assignment.provider != role {
+			reason = fmt.Sprintf("system role %s inherited from base role %s", role.Name, assignment.provider.Name)
+		} else if len(assignment.chain) > 1 {
+			// a clone's permissions are its base role's with its overrides applied
+			baseID = assignment.chain[1].ID
+			reason = fmt.Sprintf("system role %s inherited from base role %s", role.Name, assignment.chain[1].Name)
+			if db.HasPermissionOverrides(role) {
+				reason += " with overrides"
+			}
+		}
+		for set, bits := range assignment.provider.Permissions {
+			if bits == 0 {
+				continue
+			}
+			resolved.sets[authPb.PermissionSet(set)] |= bits
+			resolved.grants = append(resolved.grants, &orchardPb.PermissionGrant{
+				PermissionSet: authPb.PermissionSet(set),
+				Permissions:   bits,
+				Source:        assignment.source,
+				SourceId:      assignment.sourceID,
+				SystemRoleId:  role.ID,
+				BaseRoleId:    baseID,
+				Reason:        reason,
+			})
+		}
+	}
+	return nil
+}
+
+func baseRoleID(role, provider *models.SystemRole) string {
+	if provider == role {
+		return ""
+	}
+	return provider.ID
+}
+
+// GetEffectivePermissions resolves what a person can do in a tenant: the system roles that apply to them with their base roles, the
+// groups they can view and the resulting permission bits per permission set. With explain set every grant is traced to its source.
+func (h *Handlers) GetEffectivePermissions(ctx context.Context, in *servicePb.GetEffectivePermissionsRequest) (*servicePb.GetEffectivePermissionsResponse, error) {
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("personId", in.PersonId)
+
+	if in.TenantId == "" || in.PersonId == "" {
+		err := ErrBadRequest.New("tenantId and personId can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	resolved, err := h.resolvePermissions(ctx, in.TenantId, in.PersonId)
+	if err != nil {
+		err := errors.Wrap(err, "error resolving effective permissions")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	if resolved == nil {
+		err := errors.New("person not found").WithCode(codes.NotFound)
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	srSvc := h.db.NewSystemRoleService()
+	res := &servicePb.GetEffectivePermissionsResponse{
+		TenantId: in.TenantId,
+		PersonId: in.PersonId,
+	}
+
+	for _, assignment := range resolved.roles {
+		role, err := srSvc.ToProto(assignment.role)
+		if err != nil {
+			err := errors.Wrap(err, "error converting systemRole db model to proto")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		effectiveRole := &orchardPb.EffectiveSystemRole{
+			SystemRole: role,
+			Source:     assignment.source,
+			SourceId:   assignment.sourceID,
+		}
+		for _, base := range assignment.chain[1:] {
+			effectiveRole.BaseRoleIds = append(effectiveRole.BaseRoleIds, base.ID)
+		}
+		if assignment.provider != nil {
+			effectiveRole.PermissionsFromId = assignment.provider.ID
+		}
+		res.SystemRoles = append(res.SystemRoles, effectiveRole)
+	}
+
+	sets := make([]authPb.PermissionSet, 0, len(resolved.sets))
+	for set := range resolved.sets {
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i] < sets[j] })
+	for _, set := range sets {
+		res.PermissionSets = append(res.PermissionSets, &orchardPb.EffectivePermissionSet{
+			PermissionSet:    set,
+			Permissions:      resolved.sets[set],
+			NamedPermissions: permcatalog.Named(set, resolved.sets[set]),
+		})
+	}
+
+	groupIDs := make([]string, 0, len(resolved.groups))
+	for id := range resolved.groups {
+		groupIDs = append(groupIDs, id)
+	}
+	sort.Strings(groupIDs)
+	for _, id := range groupIDs {
+		access := resolved.groups[id]
+		res.Groups = append(res.Groups, &orchardPb.EffectiveGroupAccess{
+			GroupId:          id,
+			Direct:           access.direct,
+			Implicit:         access.implicit,
+			Permissions:      access.permissions,
+			NamedPermissions: permcatalog.Named(authPb.PermissionSet_Group, access.permissions),
+		})
+	}
+
+	if in.Explain {
+		for _, grant := range resolved.grants {
+			grant.NamedPermissions = permcatalog.Named(grant.PermissionSet, grant.Permissions)
+		}
+		res.Grants = resolved.grants
+	}
+
+	return res, nil
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	perm "github.com/loupe-co/bouncer/pkg/permissions"
+	"github.com/loupe-co/go-common/fixtures"
+	authPb "github.com/loupe-co/protos/src/common/auth"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+// groupPermissionBits are the group permission set bits of permissions
+func groupPermissionBits(permissions ...authPb.Permission) int64 {
+	p := perm.NewPermissions()
+	for _, permission := range permissions {
+		p = p.WithPermissions(perm.NewPermission(authPb.PermissionSet_Group, permission))
+	}
+	return p[authPb.PermissionSet_Group]
+}
+
+// permissionSets is bits stored the way system roles and tenants store them, indexed by permission set
+func permissionSets(set authPb.PermissionSet, bits int64) types.Int64Array {
+	sets := make(types.Int64Array, int(set)+1)
+	sets[set] = bits
+	return sets
+}
+
+func TestGetEffectivePermissions(t *testing.T) {
+	h, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// a person with a role cloned from a base role, who also views a group outside their own
+	data, err := insertTestData(store, "permissions", "TestGetEffectivePermissions", "store")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	base, clone := data.SystemRoles[0], data.SystemRoles[1]
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["permissions"], "TestGetEffectivePermissions", "request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	req := &servicePb.GetEffectivePermissionsRequest{}
+	if err := json.Unmarshal(testData, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	res, err := h.GetEffectivePermissions(context.Background(), req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if len(res.SystemRoles) != 1 {
+		t.Logf("expected the person's one role, but got %d", len(res.SystemRoles))
+		t.Fail()
+		return
+	}
+	role := res.SystemRoles[0]
+	if role.SystemRole.Id != clone.ID || role.Source != orchardPb.PermissionSource_PersonRole {
+		t.Logf("expected %s from the person, but got %s from %v", clone.ID, role.SystemRole.Id, role.Source)
+		t.Fail()
+		return
+	}
+	if len(role.BaseRoleIds) != 1 || role.BaseRoleIds[0] != base.ID {
+		t.Logf("expected the clone's base role %s, but got %v", base.ID, role.BaseRoleIds)
+		t.Fail()
+		return
+	}
+
+	accessRead := groupPermissionBits(authPb.Permission_Access, authPb.Permission_Read)
+	if len(res.PermissionSets) != 1 || res.PermissionSets[0].PermissionSet != authPb.PermissionSet_Group || res.PermissionSets[0].Permissions != accessRead {
+		t.Log("expected the base role's group permissions, but got", res.PermissionSets)
+		t.Fail()
+		return
+	}
+
+	groups := map[string]*orchardPb.EffectiveGroupAccess{}
+	for _, g := range res.Groups {
+		groups[g.GroupId] = g
+	}
+	if g, ok := groups[seedCSID]; !ok || !g.Implicit || g.Direct {
+		t.Log("expected the person's own group to be implicit, but got", g)
+		t.Fail()
+		return
+	}
+	if g, ok := groups[seedEMEAAEsID]; !ok || !g.Direct || g.Implicit || g.Permissions != groupPermissionBits(authPb.Permission_Access) {
+		t.Log("expected the viewed group to be direct with the grant's permissions, but got", g)
+		t.Fail()
+		return
+	}
+
+	if len(res.Grants) != 0 {
+		t.Logf("expected no grants without explain, but got %d", len(res.Grants))
+		t.Fail()
+		return
+	}
+
+	rawResult, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if err := fixtures.WriteTestResult("../../fixtures/results/TestGetEffectivePermissions.json", rawResult); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+}
+
+func TestGetEffectivePermissionsExplain(t *testing.T) {
+	h, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// the same person as TestGetEffectivePermissions, in a tenant that only allows Access so the role's Read is denied
+	data, err := insertTestData(store, "permissions", "TestGetEffectivePermissions", "store")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if _, err := insertTestData(store, "permissions", "TestGetEffectivePermissionsExplain", "store"); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	base, clone := data.SystemRoles[0], data.SystemRoles[1]
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["permissions"], "TestGetEffectivePermissionsExplain", "request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	req := &servicePb.GetEffectivePermissionsRequest{}
+	if err := json.Unmarshal(testData, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	res, err := h.GetEffectivePermissions(context.Background(), req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if len(res.PermissionSets) != 1 || res.PermissionSets[0].Permissions != groupPermissionBits(authPb.Permission_Access) {
+		t.Log("expected the tenant to limit the role to Access, but got", res.PermissionSets)
+		t.Fail()
+		return
+	}
+
+	sources := map[orchardPb.PermissionSource][]*orchardPb.PermissionGrant{}
+	for _, grant := range res.Grants {
+		sources[grant.Source] = append(sources[grant.Source], grant)
+		if grant.NamedPermissions == nil {
+			t.Logf("expected the %v grant's permissions to be named", grant.Source)
+			t.Fail()
+			return
+		}
+	}
+
+	roleGrants := sources[orchardPb.PermissionSource_PersonRole]
+	if len(roleGrants) != 1 {
+		t.Logf("expected one grant from the person's role, but got %d", len(roleGrants))
+		t.Fail()
+		return
+	}
+	accessRead := groupPermissionBits(authPb.Permission_Access, authPb.Permission_Read)
+	if roleGrants[0].SystemRoleId != clone.ID || roleGrants[0].BaseRoleId != base.ID || roleGrants[0].Permissions != accessRead {
+		t.Log("expected the role grant to trace back to the base role, but got", roleGrants[0])
+		t.Fail()
+		return
+	}
+	if !strings.Contains(roleGrants[0].Reason, "inherited from base role Base Viewer") {
+		t.Logf("expected the reason to name the base role, but got %q", roleGrants[0].Reason)
+		t.Fail()
+		return
+	}
+
+	denied := sources[orchardPb.PermissionSource_TenantPermissions]
+	if len(denied) != 1 || !denied[0].Denied || denied[0].Permissions != groupPermissionBits(authPb.Permission_Read) {
+		t.Log("expected the tenant to deny Read, but got", denied)
+		t.Fail()
+		return
+	}
+
+	viewerGrants := sources[orchardPb.PermissionSource_GroupViewer]
+	if len(viewerGrants) != 1 || viewerGrants[0].GroupId != seedEMEAAEsID {
+		t.Log("expected the group viewer grant, but got", viewerGrants)
+		t.Fail()
+		return
+	}
+
+	ownSubtree := sources[orchardPb.PermissionSource_OwnSubtree]
+	if len(ownSubtree) != 1 || ownSubtree[0].GroupId != seedCSID || ownSubtree[0].SourceId != seedCSID {
+		t.Log("expected one own subtree grant for CS, which has no child groups, but got", ownSubtree)
+		t.Fail()
+		return
+	}
+
+	rawResult, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if err := fixtures.WriteTestResult("../../fixtures/results/TestGetEffectivePermissionsExplain.json", rawResult); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+}
+
+func TestGetEffectivePermissionsNotFound(t *testing.T) {
+	h, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if _, err := insertTestData(store, "permissions", "TestGetEffectivePermissions", "store"); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// a person that isn't in the tenant, then no person at all
+	testData, _, _, err := jsonparser.Get(fixtures.Data["permissions"], "TestGetEffectivePermissionsNotFound")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	requests := []*servicePb.GetEffectivePermissionsRequest{}
+	if err := json.Unmarshal(testData, &requests); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	for _, req := range requests {
+		if _, err := h.GetEffectivePermissions(context.Background(), req); err == nil {
+			t.Logf("expected %v to be an error, but got nil error", req)
+			t.Fail()
+			return
+		}
+	}
+}
@@ -3,7 +3,6 @@ package handlers
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"math"
 	"strings"
 	"time"
@@ -15,17 +14,12 @@ import (
 	"github.com/loupe-co/orchard/internal/db"
 	"github.com/loupe-co/orchard/internal/models"
 	orchardPb "github.com/loupe-co/protos/src/common/orchard"
-	bouncerPb "github.com/loupe-co/protos/src/services/bouncer"
 	servicePb "github.com/loupe-co/protos/src/services/orchard"
-	"github.com/volatiletech/sqlboiler/v4/boil"
 	"google.golang.org/grpc/codes"
 )
 
-const (
-	playerCoachValue = "outreach_playercoach"
-)
-
 func (h *Handlers) CreatePerson(ctx context.Context, in *servicePb.CreatePersonRequest) (*servicePb.CreatePersonResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
 
 	if in.TenantId == "" {
@@ -82,7 +76,7 @@ func (h *Handlers) CreatePerson(ctx context.Context, in *servicePb.CreatePersonR
 		return nil, err.AsGRPC()
 	}
 
-	// Get transaction, so we can rollback if user provisioning fails
+	// Get transaction, so the person and their provisioning are saved together
 	tx, err := h.db.NewTransaction(ctx)
 	if err != nil {
 		err := errors.Wrap(err, "error creating transaction for creating person")
@@ -101,21 +95,35 @@ func (h *Handlers) CreatePerson(ctx context.Context, in *servicePb.CreatePersonR
 		return nil, err.AsGRPC()
 	}
 
-	// Commit create person transaction
-	if err := svc.Commit(); err != nil {
-		err := errors.Wrap(err, "error commiting create person transaction")
+	// Give the new person the roles the tenant's role assignment rules say they should have
+	changes, err := h.applyRoleAssignmentRules(ctx, in.TenantId, insertablePerson.CreatedBy, tx, insertablePerson.ID)
+	if err != nil {
+		err := errors.Wrap(err, "error applying role assignment rules")
 		logger.Error(err)
 		if err := svc.Rollback(); err != nil {
 			logger.Error(errors.Wrap(err, "error rolling back transaction"))
 		}
 		return nil, err.AsGRPC()
 	}
+	for _, change := range changes {
+		insertablePerson.RoleIds = change.roleIDs
+	}
 
-	// clear transaction
-	svc.SetTransaction(nil)
+	// Provision the new person in auth0 once the insert is committed
+	outboxSvc := h.db.NewOutboxService()
+	outboxSvc.SetTransaction(tx)
+	if err := outboxSvc.Enqueue(ctx, db.NewProvisionUserMessage(in.TenantId, insertablePerson.ID, in.GetPerson().GetEmail())); err != nil {
+		err := errors.Wrap(err, "error enqueueing create person outbox messages")
+		logger.Error(err)
+		if err := svc.Rollback(); err != nil {
+			logger.Error(errors.Wrap(err, "error rolling back transaction"))
+		}
+		return nil, err.AsGRPC()
+	}
 
-	if _, err := updateUserProvisioning(ctx, in.GetTenantId(), "", in.GetPerson().GetEmail(), svc, h.auth0Client); err != nil {
-		err := errors.Wrap(err, "error provisioning")
+	// Commit create person transaction
+	if err := svc.Commit(); err != nil {
+		err := errors.Wrap(err, "error commiting create person transaction")
 		logger.Error(err)
 		if err := svc.Rollback(); err != nil {
 			logger.Error(errors.Wrap(err, "error rolling back transaction"))
@@ -123,6 +131,9 @@ func (h *Handlers) CreatePerson(ctx context.Context, in *servicePb.CreatePersonR
 		return nil, err.AsGRPC()
 	}
 
+	// clear transaction
+	svc.SetTransaction(nil)
+
 	// Convert updated person model back to proto for response
 	createdRes, err := svc.ToProto(insertablePerson)
 	if err != nil {
@@ -135,6 +146,7 @@ func (h *Handlers) CreatePerson(ctx context.Context, in *servicePb.CreatePersonR
 }
 
 func (h *Handlers) UpsertPeople(ctx context.Context, in *servicePb.UpsertPeopleRequest) (*servicePb.UpsertPeopleResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
 
 	if in.TenantId == "" {
@@ -231,68 +243,29 @@ func (h *Handlers) SearchPeople(ctx context.Context, in *servicePb.SearchPeopleR
 	}
 
 	// Parse generic person filters for db
-	dbFilters := make([]db.PersonFilter, len(in.Filters))
-	for i, f := range in.Filters {
-		vals := make([]interface{}, len(f.Values))
-		for j, raw := range f.Values {
-			if err := json.Unmarshal(raw, &vals[j]); err != nil {
-				err := errors.Wrap(err, "error unmarshaling person filter value")
-				logger.Error(err)
-				return nil, err
-			}
-		}
-		var field string
-		switch f.Field {
-		case orchardPb.PersonField_Id:
-			field = "id"
-		case orchardPb.PersonField_TenantId:
-			field = "tenant_id"
-		case orchardPb.PersonField_Name:
-			field = "name"
-		case orchardPb.PersonField_FirstName:
-			field = "first_name"
-		case orchardPb.PersonField_LastName:
-			field = "last_name"
-		case orchardPb.PersonField_Email:
-			field = "email"
-		case orchardPb.PersonField_ManagerId:
-			field = "manager_id"
-		case orchardPb.PersonField_GroupId:
-			field = "group_id"
-		case orchardPb.PersonField_RoleIds:
-			field = "role_ids"
-		case orchardPb.PersonField_CrmRoleIds:
-			field = "crm_role_ids"
-		case orchardPb.PersonField_IsProvisioned:
-			field = "is_provisioned"
-		case orchardPb.PersonField_IsSynced:
-			field = "is_synced"
-		case orchardPb.PersonField_Status:
-			field = "status"
-		case orchardPb.PersonField_CreatedAt:
-			field = "created_at"
-		case orchardPb.PersonField_CreatedBy:
-			field = "created_by"
-		case orchardPb.PersonField_UpdatedAt:
-			field = "updated_at"
-		case orchardPb.PersonField_UpdatedBy:
-			field = "updated_by"
-		}
-		dbFilters[i] = db.PersonFilter{
-			Field:  field,
-			Op:     f.Op.String(),
-			Values: vals,
-		}
+	dbFilters, err := personFiltersToDB(in.Filters, in.Where)
+	if err != nil {
+		err := ErrBadRequest.New(err.Error())
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	page, err := newPage(in.SortBy, in.SortDesc, limit, offset, in.PageToken, !in.SkipTotal, db.PersonSortColumns)
+	if err != nil {
+		err := ErrBadRequest.New(err.Error())
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
 	}
 
 	svc := h.db.NewPersonService()
 
-	peeps, total, err := svc.Search(ctx, in.TenantId, in.Search, limit, offset, dbFilters...)
+	peeps, total, err := svc.SearchPage(ctx, in.TenantId, in.Search, page, dbFilters...)
 	if err != nil {
 		err := errors.Wrap(err, "error searching people")
 		logger.Error(err)
 		return nil, err.AsGRPC()
 	}
+	peeps, nextPageToken := nextPage(peeps, page, db.PersonSortValue, func(p *models.Person) string { return p.ID })
 
 	gvSvc := h.db.NewGroupViewerService()
 
@@ -406,8 +379,9 @@ func (h *Handlers) SearchPeople(ctx context.Context, in *servicePb.SearchPeopleR
 	}
 
 	return &servicePb.SearchPeopleResponse{
-		People: people,
-		Total:  int32(total),
+		People:        people,
+		Total:         int32(total),
+		NextPageToken: nextPageToken,
 	}, nil
 }
 
@@ -429,15 +403,19 @@ func (h *Handlers) GetGroupMembers(ctx context.Context, in *servicePb.GetGroupMe
 		return nil, err.AsGRPC()
 	}
 
-	people := make([]*orchardPb.Person, len(peeps))
-	for i, peep := range peeps {
+	people := make([]*orchardPb.Person, 0, len(peeps))
+	for _, peep := range peeps {
+		// Same as excludeManagerUsers in subtrees, managers are left out but player-coaches stay
+		if in.ExcludeManagerUsers && peep.Type == models.PersonTypeManager {
+			continue
+		}
 		p, err := svc.ToProto(peep)
 		if err != nil {
 			err := errors.Wrap(err, "error converting person db model to proto")
 			logger.Error(err)
 			return nil, err.AsGRPC()
 		}
-		people[i] = p
+		people = append(people, p)
 	}
 
 	return &servicePb.GetGroupMembersResponse{
@@ -522,6 +500,7 @@ func (h *Handlers) GetVirtualUsers(ctx context.Context, in *servicePb.GetVirtual
 }
 
 func (h *Handlers) UpdatePerson(ctx context.Context, in *servicePb.UpdatePersonRequest) (*servicePb.UpdatePersonResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	ctx, span := log.StartSpan(ctx, "UpdatePerson")
 	defer span.End()
 
@@ -597,6 +576,20 @@ func (h *Handlers) UpdatePerson(ctx context.Context, in *servicePb.UpdatePersonR
 		in.Person.RoleIds = rIDs
 	}
 
+	// Roles set by hand are locked, so role assignment rules and user sync leave them alone. Callers that send role_ids_locked
+	// decide it themselves, which is how a person is handed back to the rules.
+	switch {
+	case strUtil.Strings(in.OnlyFields).Has("role_ids_locked"):
+	case changeRoles && !sameRoleIDs(existingPerson.GetRoleIds(), in.Person.RoleIds):
+		in.Person.RoleIdsLocked = true
+		if len(in.OnlyFields) > 0 {
+			in.OnlyFields = append(in.OnlyFields, "role_ids_locked")
+		}
+	default:
+		in.Person.RoleIdsLocked = existingPerson.GetRoleIdsLocked()
+	}
+	unlockRoles := existingPerson.GetRoleIdsLocked() && !in.Person.RoleIdsLocked
+
 	// Check if groupId changed
 	changeGroup := strUtil.Strings(in.OnlyFields).Has("group_id")
 	if changeGroup {
@@ -604,36 +597,52 @@ func (h *Handlers) UpdatePerson(ctx context.Context, in *servicePb.UpdatePersonR
 		if len(in.OnlyFields) > 0 {
 			in.OnlyFields = append(in.OnlyFields, "is_synced")
 		}
+	}
 
-		if in.GetPerson().GetGroupId() != existingPerson.GetGroupId() &&
-			existingPerson.GetManagerId() == playerCoachValue {
-			groupService := h.db.NewGroupService()
-			g, err := groupService.GetByID(ctx, in.GetPerson().GetGroupId(), in.GetTenantId())
+	// Player-coaches are ICs that also manage people, kept as their person type rather than coming from their group's type.
+	// Callers set or clear it through type. A player-coach moved into a manager group becomes a manager like everyone else there.
+	personType := existingPerson.GetType()
+	if strUtil.Strings(in.OnlyFields).Has("type") {
+		switch in.Person.Type {
+		case models.PersonTypePlayerCoach:
+			personType = models.PersonTypePlayerCoach
+		case models.PersonTypeIc, models.PersonTypeManager:
+			// Everyone else's type comes from their group, so asking for one only clears player-coach
+			if personType == models.PersonTypePlayerCoach {
+				personType = ""
+			}
+		default:
+			err := ErrBadRequest.New("person.type must be ic, manager or player_coach")
+			logger.Warn(err.Error())
+			return nil, err.AsGRPC()
+		}
+	}
+
+	groupID := existingPerson.GetGroupId()
+	if changeGroup {
+		groupID = in.Person.GetGroupId()
+	}
+	if personType == "" || (personType == models.PersonTypePlayerCoach && groupID != existingPerson.GetGroupId()) {
+		groupType := models.PersonTypeIc
+		if groupID != "" {
+			g, err := h.db.NewGroupService().GetByID(ctx, groupID, in.GetTenantId())
 			if err != nil {
 				err := errors.Wrap(err, "error getting group record by Id in update person")
 				logger.Error(err)
-
 				return nil, err.AsGRPC()
 			}
-
 			if g != nil {
-				group, err := groupService.ToProto(g)
-				if err != nil {
-					err := errors.Wrap(err, "error converting group db model to proto")
-					logger.Error(err)
-					return nil, err.AsGRPC()
-				}
-
-				if group.GetType() != orchardPb.SystemRoleType_IC ||
-					strings.ToUpper(existingPerson.GetType()) != orchardPb.SystemRoleType_IC.String() {
-					if !strUtil.Strings(in.OnlyFields).Has("manager_id") {
-						in.OnlyFields = append(in.OnlyFields, "manager_id")
-					}
-
-					in.Person.ManagerId = ""
-				}
+				groupType = g.Type
 			}
 		}
+		if personType == "" || groupType != models.PersonTypeIc {
+			personType = groupType
+		}
+	}
+	in.Person.Type = personType
+	changeType := personType != existingPerson.GetType()
+	if changeType && len(in.OnlyFields) > 0 && !strUtil.Strings(in.OnlyFields).Has("type") {
+		in.OnlyFields = append(in.OnlyFields, "type")
 	}
 
 	if in.Person.CreatedBy != "" {
@@ -654,6 +663,14 @@ func (h *Handlers) UpdatePerson(ctx context.Context, in *servicePb.UpdatePersonR
 		}
 	}
 
+	// Check if the update changes the person's email or status, both change what they can sign in to
+	updates := func(field string) bool {
+		return len(in.OnlyFields) == 0 || strUtil.Strings(in.OnlyFields).Has(field)
+	}
+	changeEmail := updates("email") && !strings.EqualFold(strings.TrimSpace(in.Person.Email), existingPerson.GetEmail())
+	changeStatus := (updates("status") && in.Person.Status != existingPerson.GetStatus()) ||
+		(updates("is_provisioned") && in.Person.IsProvisioned != existingPerson.GetIsProvisioned())
+
 	tx, err := h.db.NewTransaction(ctx)
 	if err != nil {
 		err := errors.Wrap(err, "error creating update person transaction")
@@ -675,36 +692,57 @@ func (h *Handlers) UpdatePerson(ctx context.Context, in *servicePb.UpdatePersonR
 		return nil, err.AsGRPC()
 	}
 
-	// Commit the update person transaction in sql
-	if err := svc.Commit(); err != nil {
-		err := errors.Wrap(err, "error commiting update person transaction")
-		logger.Error(err)
-		if err := svc.Rollback(); err != nil {
-			logger.Error(errors.Wrap(err, "error rolling back transaction"))
+	// Full updates leave type alone, so write it separately
+	if changeType && len(in.OnlyFields) == 0 {
+		if err := svc.Update(ctx, updatePerson, []string{"type"}); err != nil {
+			err := errors.Wrap(err, "error updating person type")
+			logger.Error(err)
+			if err := svc.Rollback(); err != nil {
+				logger.Error(errors.Wrap(err, "error rolling back transaction"))
+			}
+			return nil, err.AsGRPC()
 		}
-		return nil, err.AsGRPC()
 	}
 
-	// clear transaction
-	svc.SetTransaction(nil)
-
-	// If we changed the provisioning of the person, update in Auth0
-	// Due to now being able to update inactive users, need to make sure empty email don't get through here and cause issues
-	if changeProvisioning && in.Person.Email != "" {
-		if _, err := updateUserProvisioning(ctx, updatePerson.TenantID, updatePerson.ID, in.Person.Email, svc, h.auth0Client); err != nil {
-			err := errors.Wrap(err, "error provisioning")
+	// People that moved group, changed type or were unlocked get the roles the tenant's role assignment rules give them
+	if (changeGroup || changeType || unlockRoles) && !updatePerson.RoleIdsLocked {
+		changes, err := h.applyRoleAssignmentRules(ctx, in.TenantId, in.Person.UpdatedBy, tx, updatePerson.ID)
+		if err != nil {
+			err := errors.Wrap(err, "error applying role assignment rules")
 			logger.Error(err)
 			if err := svc.Rollback(); err != nil {
 				logger.Error(errors.Wrap(err, "error rolling back transaction"))
 			}
 			return nil, err.AsGRPC()
 		}
+		for _, change := range changes {
+			updatePerson.RoleIds = change.roleIDs
+		}
+	}
+
+	// Queue up auth0 provisioning and bouncer cache busts in the same transaction, so they are only sent once the update is committed
+	outbox := []*db.OutboxMessage{}
+
+	// If we changed the provisioning of the person, update in Auth0
+	// Due to now being able to update inactive users, need to make sure empty email don't get through here and cause issues
+	if (changeProvisioning || changeEmail) && in.Person.Email != "" {
+		outbox = append(outbox, db.NewProvisionUserMessage(updatePerson.TenantID, updatePerson.ID, in.Person.Email))
+	}
+	// The old email's auth0 user has to be re-provisioned as well so it drops this tenant
+	if changeEmail && existingPerson.GetEmail() != "" {
+		outbox = append(outbox, db.NewProvisionEmailMessage(updatePerson.TenantID, updatePerson.ID, existingPerson.GetEmail()))
 	}
 
-	// If we updated the user's system roles or group, then bust their auth cache in bouncer
-	if changeRoles || changeGroup {
-		if _, err := h.bouncerClient.BustAuthCache(ctx, &bouncerPb.BustAuthCacheRequest{TenantId: in.TenantId, UserId: updatePerson.ID}); err != nil {
-			err := errors.Wrap(err, "error busting auth data cache for user")
+	// If we updated the user's system roles, group, type or status, then bust their auth cache in bouncer
+	if changeRoles || changeGroup || changeType || changeStatus {
+		outbox = append(outbox, db.NewBustAuthCacheMessage(in.TenantId, updatePerson.ID))
+	}
+
+	if len(outbox) > 0 {
+		outboxSvc := h.db.NewOutboxService()
+		outboxSvc.SetTransaction(tx)
+		if err := outboxSvc.Enqueue(ctx, outbox...); err != nil {
+			err := errors.Wrap(err, "error enqueueing update person outbox messages")
 			logger.Error(err)
 			if err := svc.Rollback(); err != nil {
 				logger.Error(errors.Wrap(err, "error rolling back transaction"))
@@ -713,6 +751,19 @@ func (h *Handlers) UpdatePerson(ctx context.Context, in *servicePb.UpdatePersonR
 		}
 	}
 
+	// Commit the update person transaction in sql
+	if err := svc.Commit(); err != nil {
+		err := errors.Wrap(err, "error commiting update person transaction")
+		logger.Error(err)
+		if err := svc.Rollback(); err != nil {
+			logger.Error(errors.Wrap(err, "error rolling back transaction"))
+		}
+		return nil, err.AsGRPC()
+	}
+
+	// clear transaction
+	svc.SetTransaction(nil)
+
 	// Convert the updated person db model to proto for response
 	person, err := svc.ToProto(updatePerson)
 	if err != nil {
@@ -727,6 +778,7 @@ func (h *Handlers) UpdatePerson(ctx context.Context, in *servicePb.UpdatePersonR
 }
 
 func (h *Handlers) UpdatePersonGroups(ctx context.Context, in *servicePb.UpdatePersonGroupsRequest) (*servicePb.UpdatePersonGroupsResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
 
 	if err := h.updatePersonGroups(ctx, in.TenantId, nil); err != nil {
@@ -761,16 +813,20 @@ func (h *Handlers) updatePersonGroups(ctx context.Context, tenantID string, tx *
 		return errors.Wrap(err, "error getting updated person groups")
 	}
 
-	bustReqs := []*bouncerPb.BustAuthCacheRequest{}
+	msgs := []*db.OutboxMessage{}
 	for personID, groupID := range personGroups {
 		if newGroupID, ok := updatedPersonGroups[personID]; newGroupID != groupID || !ok {
-			bustReqs = append(bustReqs, &bouncerPb.BustAuthCacheRequest{TenantId: tenantID, UserId: personID})
+			msgs = append(msgs, db.NewBustAuthCacheMessage(tenantID, personID))
 		}
 	}
 
-	if len(bustReqs) > 0 {
-		if err := h.bouncerClient.MultiBustAuthCache(spanCtx, bustReqs...); err != nil {
-			return errors.Wrap(err, "error busting auth cache for one or more users in bouncer")
+	if len(msgs) > 0 {
+		outboxSvc := h.db.NewOutboxService()
+		if tx != nil {
+			outboxSvc.SetTransaction(tx)
+		}
+		if err := outboxSvc.Enqueue(spanCtx, msgs...); err != nil {
+			return errors.Wrap(err, "error enqueueing auth cache busts for one or more users")
 		}
 	}
 
@@ -778,6 +834,7 @@ func (h *Handlers) updatePersonGroups(ctx context.Context, tenantID string, tx *
 }
 
 func (h *Handlers) DeletePersonById(ctx context.Context, in *servicePb.IdRequest) (*servicePb.Empty, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("personId", in.PersonId)
 
 	if in.TenantId == "" || in.PersonId == "" {
@@ -805,8 +862,10 @@ func (h *Handlers) DeletePersonById(ctx context.Context, in *servicePb.IdRequest
 		return nil, err
 	}
 
-	if _, err := h.bouncerClient.BustAuthCache(ctx, &bouncerPb.BustAuthCacheRequest{TenantId: in.TenantId, UserId: in.PersonId}); err != nil {
-		err := errors.Wrap(err, "error busting auth data cache for user")
+	outboxSvc := h.db.NewOutboxService()
+	outboxSvc.SetTransaction(tx)
+	if err := outboxSvc.Enqueue(ctx, db.NewBustAuthCacheMessage(in.TenantId, in.PersonId)); err != nil {
+		err := errors.Wrap(err, "error enqueueing auth data cache bust for user")
 		logger.Error(err)
 		if err := svc.Rollback(); err != nil {
 			logger.Error(errors.Wrap(err, "error rolling back transaction"))
@@ -828,6 +887,8 @@ func (h *Handlers) DeletePersonById(ctx context.Context, in *servicePb.IdRequest
 }
 
 func (h *Handlers) ClonePerson(ctx context.Context, in *servicePb.ClonePersonRequest) (*servicePb.ClonePersonResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.GetCurrentTenantId())
+	defer h.hierarchyCache.Invalidate(ctx, in.GetNewTenantId())
 	logger := log.WithContext(ctx).WithTenantID(in.GetCurrentTenantId()).WithCustom("personId", in.GetPersonId())
 
 	if in.GetCurrentTenantId() == "" || in.GetPersonId() == "" || in.GetNewTenantId() == "" {
@@ -867,7 +928,7 @@ func (h *Handlers) ClonePerson(ctx context.Context, in *servicePb.ClonePersonReq
 		return nil, err.AsGRPC()
 	}
 
-	// Get transaction, so we can rollback if user provisioning fails
+	// Get transaction, so the clone and its provisioning are saved together
 	tx, err := h.db.NewTransaction(ctx)
 	if err != nil {
 		err := errors.Wrap(err, "error creating transaction for cloning person")
@@ -886,9 +947,11 @@ func (h *Handlers) ClonePerson(ctx context.Context, in *servicePb.ClonePersonReq
 		return nil, err.AsGRPC()
 	}
 
-	// Commit clone person transaction
-	if err := svc.Commit(); err != nil {
-		err := errors.Wrap(err, "error commiting clone person transaction")
+	// Provision the clone in auth0 once the insert is committed
+	outboxSvc := h.db.NewOutboxService()
+	outboxSvc.SetTransaction(tx)
+	if err := outboxSvc.Enqueue(ctx, db.NewProvisionUserMessage(p.TenantID, p.ID, p.Email.String)); err != nil {
+		err := errors.Wrap(err, "error enqueueing clone person outbox messages")
 		logger.Error(err)
 		if err := svc.Rollback(); err != nil {
 			logger.Error(errors.Wrap(err, "error rolling back transaction"))
@@ -896,11 +959,9 @@ func (h *Handlers) ClonePerson(ctx context.Context, in *servicePb.ClonePersonReq
 		return nil, err.AsGRPC()
 	}
 
-	// clear transaction
-	svc.SetTransaction(nil)
-
-	if _, err := updateUserProvisioning(ctx, in.GetNewTenantId(), p.ID, p.Email.String, svc, h.auth0Client); err != nil {
-		err := errors.Wrap(err, "error provisioning")
+	// Commit clone person transaction
+	if err := svc.Commit(); err != nil {
+		err := errors.Wrap(err, "error commiting clone person transaction")
 		logger.Error(err)
 		if err := svc.Rollback(); err != nil {
 			logger.Error(errors.Wrap(err, "error rolling back transaction"))
@@ -908,6 +969,9 @@ func (h *Handlers) ClonePerson(ctx context.Context, in *servicePb.ClonePersonReq
 		return nil, err.AsGRPC()
 	}
 
+	// clear transaction
+	svc.SetTransaction(nil)
+
 	// Convert updated person model back to proto for response
 	createdRes, err := svc.ToProto(p)
 	if err != nil {
@@ -920,6 +984,7 @@ func (h *Handlers) ClonePerson(ctx context.Context, in *servicePb.ClonePersonReq
 }
 
 func (h *Handlers) HardDeletePersonById(ctx context.Context, in *servicePb.IdRequest) (*servicePb.Empty, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("personId", in.PersonId)
 
 	if in.TenantId == "" || in.PersonId == "" {
@@ -964,8 +1029,16 @@ func (h *Handlers) HardDeletePersonById(ctx context.Context, in *servicePb.IdReq
 		return nil, err.AsGRPC()
 	}
 
-	if _, err := h.bouncerClient.BustAuthCache(ctx, &bouncerPb.BustAuthCacheRequest{TenantId: in.TenantId, UserId: in.PersonId}); err != nil {
-		err := errors.Wrap(err, "error busting auth data cache for user")
+	outbox := []*db.OutboxMessage{db.NewBustAuthCacheMessage(in.TenantId, in.PersonId)}
+	// update auth0 (remove user if no additional records, otherwise update existing user)
+	if person.IsProvisioned {
+		outbox = append(outbox, db.NewProvisionUserMessage(in.GetTenantId(), in.GetPersonId(), personEmail))
+	}
+
+	outboxSvc := h.db.NewOutboxService()
+	outboxSvc.SetTransaction(tx)
+	if err := outboxSvc.Enqueue(ctx, outbox...); err != nil {
+		err := errors.Wrap(err, "error enqueueing delete person outbox messages")
 		logger.Error(err)
 		if err := svc.Rollback(); err != nil {
 			logger.Error(errors.Wrap(err, "error rolling back transaction"))
@@ -983,25 +1056,11 @@ func (h *Handlers) HardDeletePersonById(ctx context.Context, in *servicePb.IdReq
 		return nil, err.AsGRPC()
 	}
 
-	// clear transaction
-	svc.SetTransaction(nil)
-
-	// update auth0 (remove user if no additional records, otherwise update existing user)
-	if person.IsProvisioned {
-		if _, err := updateUserProvisioning(ctx, in.GetTenantId(), in.GetPersonId(), personEmail, svc, h.auth0Client); err != nil {
-			err := errors.Wrap(err, "error provisioning")
-			logger.Error(err)
-			if err := svc.Rollback(); err != nil {
-				logger.Error(errors.Wrap(err, "error rolling back transaction"))
-			}
-			return nil, err.AsGRPC()
-		}
-	}
-
 	return &servicePb.Empty{}, nil
 }
 
 func (h *Handlers) ConvertVirtualUsers(ctx context.Context, in *servicePb.ConvertVirtualUsersRequest) (*servicePb.ConvertVirtualUsersResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.GetTenantId())
 	logger := log.WithContext(ctx).WithTenantID(in.GetTenantId())
 
 	if in.GetTenantId() == "" {
@@ -1056,19 +1115,16 @@ func (h *Handlers) ConvertVirtualUsers(ctx context.Context, in *servicePb.Conver
 	svc.SetTransaction(tx)
 
 	updatedPeeps := []*orchardPb.Person{}
-	oldPeepsIDMap := make(map[string]string)
+	outbox := []*db.OutboxMessage{}
 	for _, oldPerson := range peeps {
 		for _, newPerson := range nonVirtualPeeps {
 			if !strings.EqualFold(oldPerson.Email.String, newPerson.Email.String) {
 				continue
 			}
-			// store old id for cache changes later
-			oldPeepsIDMap[oldPerson.Email.String] = oldPerson.ID
-
 			// update the new person with roles & groupids
 			newPerson.RoleIds = oldPerson.RoleIds
 			newPerson.IsProvisioned = true
-			_, err := newPerson.Update(ctx, svc.GetContextExecutor(), boil.Whitelist("role_ids", "group_id", "is_provisioned"))
+			err := svc.Update(ctx, newPerson, []string{"role_ids", "group_id", "is_provisioned"})
 			if err != nil {
 				err := errors.Wrap(err, "error updating new person")
 				logger.Error(err)
@@ -1081,7 +1137,7 @@ func (h *Handlers) ConvertVirtualUsers(ctx context.Context, in *servicePb.Conver
 			// deactivate old person
 			oldPerson.Status = "inactive"
 			oldPerson.IsProvisioned = false
-			_, err = oldPerson.Update(ctx, svc.GetContextExecutor(), boil.Whitelist("status", "is_provisioned"))
+			err = svc.Update(ctx, oldPerson, []string{"status", "is_provisioned"})
 			if err != nil {
 				err := errors.Wrap(err, "error updating old person")
 				logger.Error(err)
@@ -1091,6 +1147,12 @@ func (h *Handlers) ConvertVirtualUsers(ctx context.Context, in *servicePb.Conver
 				return nil, err.AsGRPC()
 			}
 
+			// provision the new user and bust the old user's auth cache once the conversion is committed
+			outbox = append(outbox,
+				db.NewProvisionUserMessage(newPerson.TenantID, newPerson.ID, newPerson.Email.String),
+				db.NewBustAuthCacheMessage(in.TenantId, oldPerson.ID),
+			)
+
 			newP, err := svc.ToProto(newPerson)
 			if err == nil {
 				updatedPeeps = append(updatedPeeps, newP)
@@ -1099,6 +1161,19 @@ func (h *Handlers) ConvertVirtualUsers(ctx context.Context, in *servicePb.Conver
 		}
 	}
 
+	if len(outbox) > 0 {
+		outboxSvc := h.db.NewOutboxService()
+		outboxSvc.SetTransaction(tx)
+		if err := outboxSvc.Enqueue(ctx, outbox...); err != nil {
+			err := errors.Wrap(err, "error enqueueing convert person outbox messages")
+			logger.Error(err)
+			if err := svc.Rollback(); err != nil {
+				logger.Error(errors.Wrap(err, "error rolling back transaction"))
+			}
+			return nil, err.AsGRPC()
+		}
+	}
+
 	// commit the transaction
 	if err := svc.Commit(); err != nil {
 		err := errors.Wrap(err, "error commiting convert person transaction")
@@ -1112,27 +1187,6 @@ func (h *Handlers) ConvertVirtualUsers(ctx context.Context, in *servicePb.Conver
 	// clear transaction
 	svc.SetTransaction(nil)
 
-	for _, newPerson := range updatedPeeps {
-		// provision new user
-		if _, err := updateUserProvisioning(ctx, newPerson.TenantId, newPerson.Id, newPerson.Email, svc, h.auth0Client); err != nil {
-			err := errors.Wrap(err, "error provisioning")
-			logger.Error(err)
-			return nil, err.AsGRPC()
-		}
-
-		// go to next if old id not found
-		if _, ok := oldPeepsIDMap[newPerson.Email]; !ok {
-			continue
-		}
-
-		// bust auth cache for old id
-		if _, err := h.bouncerClient.BustAuthCache(ctx, &bouncerPb.BustAuthCacheRequest{TenantId: in.TenantId, UserId: oldPeepsIDMap[newPerson.Email]}); err != nil {
-			err := errors.Wrap(err, "error busting auth data cache for user")
-			logger.Error(err)
-			return nil, err.AsGRPC()
-		}
-	}
-
 	return &servicePb.ConvertVirtualUsersResponse{
 		People: updatedPeeps,
 	}, nil
@@ -1153,7 +1207,7 @@ func (h *Handlers) ReprovisionPeople(ctx context.Context, in *servicePb.IdReques
 	numUnprovisioned := int64(0)
 
 	if in.GetPersonId() != "" {
-		provisioned, err := updateUserProvisioning(ctx, in.GetTenantId(), in.GetPersonId(), "", svc, h.auth0Client)
+		provisioned, err := updateUserProvisioning(ctx, in.GetTenantId(), in.GetPersonId(), "", svc, h.identityProvider)
 		if err != nil {
 			err = errors.Wrap(err, "error reprovisioning person")
 			return nil, err
@@ -1181,7 +1235,7 @@ func (h *Handlers) ReprovisionPeople(ctx context.Context, in *servicePb.IdReques
 	}
 
 	for _, person := range people {
-		provisioned, err := updateUserProvisioning(ctx, in.GetTenantId(), person.ID, person.Email.String, svc, h.auth0Client)
+		provisioned, err := updateUserProvisioning(ctx, in.GetTenantId(), person.ID, person.Email.String, svc, h.identityProvider)
 		if err != nil {
 			err := errors.Wrap(err, "error provisioning person")
 			logger.Error(err)
@@ -1232,7 +1286,7 @@ func (h *Handlers) GetPeopleByEmail(ctx context.Context, in *servicePb.GetPeople
 	return &servicePb.GetPeopleByEmailResponse{People: finalRes}, nil
 }
 
-func updateUserProvisioning(ctx context.Context, tenantID string, personID string, personEmail string, personSvc *db.PersonService, auth0Client *clients.Auth0Client) (bool, error) {
+func updateUserProvisioning(ctx context.Context, tenantID string, personID string, personEmail string, personSvc db.PersonRepository, identityProvider clients.IdentityProvider) (bool, error) {
 	if len(tenantID) == 0 {
 		return false, errors.New("tenantId is required to provision")
 	}
@@ -1266,7 +1320,7 @@ func updateUserProvisioning(ctx context.Context, tenantID string, personID strin
 
 	// found person records to provision, make it happen
 	if len(personRecords) > 0 {
-		if err := auth0Client.Provision(ctx, personRecords); err != nil {
+		if err := identityProvider.Provision(ctx, personRecords); err != nil {
 			err := errors.Wrap(err, "error provisioning user in auth0")
 			return false, err
 		}
@@ -1275,7 +1329,7 @@ func updateUserProvisioning(ctx context.Context, tenantID string, personID strin
 
 	// no person records, unprovision
 	if len(personID) > 0 {
-		if err := auth0Client.Unprovision(ctx, tenantID, personID); err != nil {
+		if err := identityProvider.Unprovision(ctx, tenantID, personID); err != nil {
 			var ignoreError bool
 			// if not found in auth0, dont return an error
 			if cErr, ok := err.(errors.CommonError); ok && cErr.Code == codes.NotFound {
@@ -270,6 +270,70 @@ func TestSearchPeopleWithFilters(t *testing.T) {
 	}
 }
 
+func TestSearchPeopleWithNestedFilters(t *testing.T) {
+	testData, _, _, err := jsonparser.Get(fixtures.Data["person"], "TestSearchPeopleWithNestedFilters")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	req := &servicePb.SearchPeopleRequest{}
+	if err := json.Unmarshal(testData, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	req.PageSize = -1
+	res, err := testServer.SearchPeople(context.Background(), req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// an or group finds exactly the people either of its filters finds on its own
+	union := map[string]bool{}
+	for _, child := range req.Where.Or {
+		childReq := &servicePb.SearchPeopleRequest{TenantId: req.TenantId, Where: child, PageSize: -1}
+		childRes, err := testServer.SearchPeople(context.Background(), childReq)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		for _, p := range childRes.People {
+			union[p.Id] = true
+		}
+	}
+	if len(res.People) == 0 || len(res.People) != len(union) || int(res.Total) != len(union) {
+		t.Logf("expected the %d people matching either filter, but got %d of %d", len(union), len(res.People), res.Total)
+		t.Fail()
+		return
+	}
+	for _, p := range res.People {
+		if !union[p.Id] {
+			t.Logf("expected only people matching either filter, but got %s", p.Id)
+			t.Fail()
+			return
+		}
+	}
+
+	rawResult, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if err := fixtures.WriteTestResult("../../fixtures/results/TestSearchPeopleWithNestedFilters.json", rawResult); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+}
+
 func TestSearchPeopleBadRequestEmptyTenantID(t *testing.T) {
 	testData, _, _, err := jsonparser.Get(fixtures.Data["person"], "TestSearchPeopleBadRequestEmptyTenantID")
 	if err != nil {
@@ -312,6 +376,48 @@ func TestSearchPeopleBadRequestEmptyTenantID(t *testing.T) {
 	}
 }
 
+func TestSearchPeopleBadRequestUnknownFilterField(t *testing.T) {
+	testData, _, _, err := jsonparser.Get(fixtures.Data["person"], "TestSearchPeopleBadRequestUnknownFilterField")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	req := &servicePb.SearchPeopleRequest{}
+	if err := json.Unmarshal(testData, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	_, err = testServer.SearchPeople(context.Background(), req)
+	if err == nil {
+		t.Log("expected server to return an error, but got nil error")
+		t.Fail()
+		return
+	}
+
+	if !strings.Contains(err.Error(), "Bad Request") {
+		t.Log("expected error to contain 'Bad Request', but didn't")
+		t.Fail()
+		return
+	}
+
+	rawResult, err := json.MarshalIndent(err.Error(), "", "  ")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if err := fixtures.WriteTestResult("../../fixtures/results/TestSearchPeopleBadRequestUnknownFilterField.json", rawResult); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+}
+
 func TestGetGroupMembers(t *testing.T) {
 	testData, _, _, err := jsonparser.Get(fixtures.Data["person"], "TestGetGroupMembers")
 	if err != nil {
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/loupe-co/go-common/fixtures"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/models"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+)
+
+func personType(store *db.MemoryStore, personID string) (string, error) {
+	p, err := store.NewPersonService().GetByID(context.Background(), personID, db.DefaultTenantID)
+	if err != nil {
+		return "", err
+	}
+	return p.Type, nil
+}
+
+// getUpdatePersonTestRequest is the UpdatePerson request at keys of fixtures/player_coach.json
+func getUpdatePersonTestRequest(keys ...string) (*servicePb.UpdatePersonRequest, error) {
+	raw, _, _, err := jsonparser.Get(fixtures.Data["player_coach"], keys...)
+	if err != nil {
+		return nil, err
+	}
+	req := &servicePb.UpdatePersonRequest{}
+	if err := json.Unmarshal(raw, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func TestPlayerCoachType(t *testing.T) {
+	h, fakes, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	// an IC in CS and a rule that gives player-coaches a role
+	data, err := insertTestData(store, "player_coach", "TestPlayerCoachType", "store")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	role, p := data.SystemRoles[0], data.People[0]
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["player_coach"], "TestPlayerCoachType", "rule")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	rule := &orchardPb.RoleAssignmentRule{}
+	if err := json.Unmarshal(testData, rule); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	rule.Kind = orchardPb.RoleAssignmentRuleKind_GroupType
+	if _, err := h.SetRoleAssignmentRules(ctx, &servicePb.SetRoleAssignmentRulesRequest{TenantId: db.DefaultTenantID, Rules: []*orchardPb.RoleAssignmentRule{rule}}); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	badReq, err := getUpdatePersonTestRequest("TestPlayerCoachType", "bad_request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if _, err := h.UpdatePerson(ctx, badReq); err == nil || !strings.Contains(err.Error(), "Bad Request") {
+		t.Log("expected an unknown person type to be a bad request, but got", err)
+		t.Fail()
+		return
+	}
+
+	req, err := getUpdatePersonTestRequest("TestPlayerCoachType", "type_request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	fakes.Reset()
+	res, err := h.UpdatePerson(ctx, req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	typ, err := personType(store, p.ID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if !res.Person.IsPlayerCoach || typ != models.PersonTypePlayerCoach {
+		t.Log("expected the person to become a player-coach, but got", typ)
+		t.Fail()
+		return
+	}
+	roles, err := personRoleIDs(store, p.ID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if !roles[role.ID] {
+		t.Log("expected the player-coach rule to give them its role, but got", roles)
+		t.Fail()
+		return
+	}
+	if _, err := h.DispatchOutbox(ctx); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if busted := fakes.Bouncer.BustedUserIDs(db.DefaultTenantID); len(busted) != 1 || busted[0] != p.ID {
+		t.Log("expected the player-coach's auth cache to be busted, but got", busted)
+		t.Fail()
+		return
+	}
+
+	ids, err := h.GetManagerAndParentIDs(ctx, &servicePb.GetManagerAndParentIDsRequest{TenantId: db.DefaultTenantID, PersonId: p.ID})
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if ids.PersonType != models.PersonTypePlayerCoach || !ids.IsPlayerCoach || ids.ManagerId != "" || ids.ParentId != seedCSID {
+		t.Log("expected the player-coach type and no marker manager id, but got", ids)
+		t.Fail()
+		return
+	}
+
+	rawResult, err := json.MarshalIndent(ids, "", "  ")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if err := fixtures.WriteTestResult("../../fixtures/results/TestPlayerCoachType.json", rawResult); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// Group types being worked out again don't turn a player-coach back into an IC
+	if err := store.NewGroupService().UpdateGroupTypes(ctx, db.DefaultTenantID); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if typ, err := personType(store, p.ID); err != nil || typ != models.PersonTypePlayerCoach {
+		t.Log("expected UpdateGroupTypes to leave the player-coach alone, but got", typ, err)
+		t.Fail()
+		return
+	}
+
+	// Moving to another IC group keeps them a player-coach, moving into a manager group makes them a manager
+	req, err = getUpdatePersonTestRequest("TestPlayerCoachType", "ic_group_request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if _, err := h.UpdatePerson(ctx, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if typ, err := personType(store, p.ID); err != nil || typ != models.PersonTypePlayerCoach {
+		t.Log("expected a move to an IC group to keep the player-coach type, but got", typ, err)
+		t.Fail()
+		return
+	}
+	req, err = getUpdatePersonTestRequest("TestPlayerCoachType", "manager_group_request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if _, err := h.UpdatePerson(ctx, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if typ, err := personType(store, p.ID); err != nil || typ != models.PersonTypeManager {
+		t.Log("expected a move to a manager group to make them a manager, but got", typ, err)
+		t.Fail()
+		return
+	}
+	roles, err = personRoleIDs(store, p.ID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if roles[role.ID] {
+		t.Log("expected the manager to lose the player-coach role, but got", roles)
+		t.Fail()
+		return
+	}
+}
+
+func TestPlayerCoachTypeCleared(t *testing.T) {
+	h, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	// A player-coach in a manager group is still listed with the reps
+	data, err := insertTestData(store, "player_coach", "TestPlayerCoachTypeCleared", "store")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	p, manager := data.People[0], data.People[1]
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["player_coach"], "TestPlayerCoachTypeCleared", "members_request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	membersReq := &servicePb.GetGroupMembersRequest{}
+	if err := json.Unmarshal(testData, membersReq); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	members, err := h.GetGroupMembers(ctx, membersReq)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	listed := map[string]*orchardPb.Person{}
+	for _, member := range members.Members {
+		listed[member.Id] = member
+	}
+	if coach, ok := listed[p.ID]; !ok || !coach.IsPlayerCoach || listed[manager.ID] != nil {
+		t.Log("expected the player-coach but not the manager when managers are excluded, but got", members.Members)
+		t.Fail()
+		return
+	}
+
+	// Asking for ic or manager clears player-coach, the type then comes from the group again
+	req, err := getUpdatePersonTestRequest("TestPlayerCoachTypeCleared", "clear_request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if _, err := h.UpdatePerson(ctx, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if typ, err := personType(store, p.ID); err != nil || typ != models.PersonTypeManager {
+		t.Log("expected the cleared player-coach to take their manager group's type, but got", typ, err)
+		t.Fail()
+		return
+	}
+}
@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/clients"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/models"
+)
+
+const (
+	// ReconcileSourceOrchard fixes auth0 to match the person records in orchard
+	ReconcileSourceOrchard = "orchard"
+	// ReconcileSourceAuth0 fixes person.is_provisioned in orchard to match auth0
+	ReconcileSourceAuth0 = "auth0"
+
+	DiscrepancyMissingInAuth0      = "missing_in_auth0"
+	DiscrepancyLicenseInactive     = "license_inactive"
+	DiscrepancyPersonIDMismatch    = "person_id_mismatch"
+	DiscrepancyPrimaryTenant       = "primary_tenant_mismatch"
+	DiscrepancyTenantContexts      = "tenant_contexts_mismatch"
+	DiscrepancyNotProvisionedInSQL = "not_provisioned_in_orchard"
+
+	reconcileAuth0PageSize = 500
+)
+
+type ReconcileAuth0Options struct {
+	// Fix corrects discrepancies on the side that isn't the source of truth, otherwise only a report is produced
+	Fix bool
+	// SourceOfTruth is either ReconcileSourceOrchard or ReconcileSourceAuth0, defaults to orchard
+	SourceOfTruth string
+}
+
+type Auth0Discrepancy struct {
+	Type        string `json:"type"`
+	PersonID    string `json:"person_id,omitempty"`
+	Email       string `json:"email,omitempty"`
+	Auth0UserID string `json:"auth0_user_id,omitempty"`
+	Expected    string `json:"expected,omitempty"`
+	Actual      string `json:"actual,omitempty"`
+	Fixed       bool   `json:"fixed"`
+	FixError    string `json:"fix_error,omitempty"`
+}
+
+type Auth0ReconcileReport struct {
+	TenantID          string              `json:"tenant_id"`
+	SourceOfTruth     string              `json:"source_of_truth"`
+	Fix               bool                `json:"fix"`
+	PeopleChecked     int                 `json:"people_checked"`
+	Auth0UsersChecked int                 `json:"auth0_users_checked"`
+	Discrepancies     []*Auth0Discrepancy `json:"discrepancies"`
+	StartedAt         time.Time           `json:"started_at"`
+	FinishedAt        time.Time           `json:"finished_at"`
+}
+
+// ReconcileAuth0 compares a tenant's provisioned people with their auth0 users and reports (and optionally fixes) any drift in
+// license state, person_id, tenant_contexts and primary tenant.
+func (h *Handlers) ReconcileAuth0(ctx context.Context, tenantID string, opts ReconcileAuth0Options) (*Auth0ReconcileReport, error) {
+	spanCtx, span := log.StartSpan(ctx, "ReconcileAuth0")
+	defer span.End()
+
+	if tenantID == "" {
+		return nil, ErrBadRequest.New("tenantId can't be empty")
+	}
+
+	if opts.SourceOfTruth == "" {
+		opts.SourceOfTruth = ReconcileSourceOrchard
+	}
+	if opts.SourceOfTruth != ReconcileSourceOrchard && opts.SourceOfTruth != ReconcileSourceAuth0 {
+		return nil, ErrBadRequest.New(fmt.Sprintf("invalid source of truth %s", opts.SourceOfTruth))
+	}
+
+	logger := log.WithContext(spanCtx).
+		WithTenantID(tenantID).
+		WithCustom("sourceOfTruth", opts.SourceOfTruth).
+		WithCustom("fix", opts.Fix)
+
+	report := &Auth0ReconcileReport{
+		TenantID:      tenantID,
+		SourceOfTruth: opts.SourceOfTruth,
+		Fix:           opts.Fix,
+		Discrepancies: []*Auth0Discrepancy{},
+		StartedAt:     time.Now().UTC(),
+	}
+
+	personSvc := h.db.NewPersonService()
+
+	people := []*models.Person{}
+	for offset := 0; ; offset += reconcileAuth0PageSize {
+		page, _, err := personSvc.Search(spanCtx, tenantID, "", reconcileAuth0PageSize, offset,
+			db.PersonFilter{Field: "is_provisioned", Op: "EQ", Values: []interface{}{true}},
+		)
+		if err != nil {
+			err := errors.Wrap(err, "error getting provisioned people")
+			logger.Error(err)
+			return nil, err
+		}
+		people = append(people, page...)
+		if len(page) < reconcileAuth0PageSize {
+			break
+		}
+	}
+
+	identities, err := h.identityProvider.ListTenantIdentities(spanCtx, tenantID)
+	if err != nil {
+		err := errors.Wrap(err, "error listing auth0 users for tenant")
+		logger.Error(err)
+		return nil, err
+	}
+
+	identitiesByEmail := make(map[string]*clients.Identity, len(identities))
+	identitiesByPersonID := make(map[string]*clients.Identity, len(identities))
+	for _, identity := range identities {
+		identitiesByEmail[strings.ToLower(personSvc.CleanEmail(identity.Email))] = identity
+		for _, tc := range identity.TenantContexts {
+			if tc.TenantID == tenantID {
+				identitiesByPersonID[tc.UserID] = identity
+			}
+		}
+	}
+
+	provisionedIDs := make(map[string]struct{}, len(people))
+	seen := make(map[string]struct{}, len(identities))
+
+	for _, person := range people {
+		provisionedIDs[person.ID] = struct{}{}
+		if person.Email.IsZero() {
+			continue
+		}
+		report.PeopleChecked++
+
+		email := strings.ToLower(personSvc.CleanEmail(person.Email.String))
+
+		identity, ok := identitiesByPersonID[person.ID]
+		if !ok {
+			identity, ok = identitiesByEmail[email]
+		}
+		if !ok {
+			// The auth0 user may exist without any context for this tenant, which the tenant listing can't find
+			byEmail, err := h.identityProvider.GetIdentitiesByEmail(spanCtx, tenantID, email)
+			if err != nil {
+				err := errors.Wrap(err, "error getting auth0 users by email")
+				logger.Error(err)
+				return nil, err
+			}
+			if len(byEmail) > 0 {
+				identity, ok = byEmail[0], true
+			}
+		}
+		if !ok {
+			report.Discrepancies = append(report.Discrepancies, &Auth0Discrepancy{
+				Type:     DiscrepancyMissingInAuth0,
+				PersonID: person.ID,
+				Email:    email,
+			})
+			continue
+		}
+		seen[identity.UserID] = struct{}{}
+
+		records, err := personSvc.GetAllByEmailForProvisioning(spanCtx, email)
+		if err != nil {
+			err := errors.Wrap(err, "error getting person records for provisioning")
+			logger.Error(err)
+			return nil, err
+		}
+
+		report.Discrepancies = append(report.Discrepancies, compareAuth0Identity(person, email, identity, records)...)
+	}
+
+	for _, identity := range identities {
+		if _, ok := seen[identity.UserID]; ok || !identity.License.IsActive {
+			continue
+		}
+		report.Auth0UsersChecked++
+		for _, tc := range identity.TenantContexts {
+			if tc.TenantID != tenantID {
+				continue
+			}
+			if _, ok := provisionedIDs[tc.UserID]; !ok {
+				report.Discrepancies = append(report.Discrepancies, &Auth0Discrepancy{
+					Type:        DiscrepancyNotProvisionedInSQL,
+					PersonID:    tc.UserID,
+					Email:       identity.Email,
+					Auth0UserID: identity.UserID,
+				})
+			}
+		}
+	}
+	report.Auth0UsersChecked += len(seen)
+
+	if opts.Fix {
+		h.fixAuth0Discrepancies(spanCtx, tenantID, opts.SourceOfTruth, personSvc, report.Discrepancies)
+	}
+
+	report.FinishedAt = time.Now().UTC()
+
+	logger.
+		WithCustom("peopleChecked", report.PeopleChecked).
+		WithCustom("auth0UsersChecked", report.Auth0UsersChecked).
+		WithCustom("discrepancies", len(report.Discrepancies)).
+		Info("finished auth0 reconciliation")
+
+	return report, nil
+}
+
+func compareAuth0Identity(person *models.Person, email string, identity *clients.Identity, records []*models.Person) []*Auth0Discrepancy {
+	discrepancies := []*Auth0Discrepancy{}
+	newDiscrepancy := func(typ, expected, actual string) {
+		discrepancies = append(discrepancies, &Auth0Discrepancy{
+			Type:        typ,
+			PersonID:    person.ID,
+			Email:       email,
+			Auth0UserID: identity.UserID,
+			Expected:    expected,
+			Actual:      actual,
+		})
+	}
+
+	if !identity.License.IsActive {
+		newDiscrepancy(DiscrepancyLicenseInactive, "true", "false")
+	}
+
+	if len(records) == 0 {
+		return discrepancies
+	}
+
+	tenantContexts, primary := clients.BuildTenantContexts(records)
+
+	if identity.PersonID != primary.ID {
+		newDiscrepancy(DiscrepancyPersonIDMismatch, primary.ID, identity.PersonID)
+	}
+	if identity.TenantID != primary.TenantID {
+		newDiscrepancy(DiscrepancyPrimaryTenant, primary.TenantID, identity.TenantID)
+	}
+
+	expected := make([]string, len(tenantContexts))
+	for i, tc := range tenantContexts {
+		expected[i] = formatTenantContext(tc.TenantID, tc.UserID, tc.IsPrimary)
+	}
+	actual := make([]string, len(identity.TenantContexts))
+	for i, tc := range identity.TenantContexts {
+		actual[i] = formatTenantContext(tc.TenantID, tc.UserID, tc.IsPrimary)
+	}
+	sort.Strings(expected)
+	sort.Strings(actual)
+	if strings.Join(expected, ",") != strings.Join(actual, ",") {
+		newDiscrepancy(DiscrepancyTenantContexts, strings.Join(expected, ","), strings.Join(actual, ","))
+	}
+
+	return discrepancies
+}
+
+func formatTenantContext(tenantID, userID string, isPrimary bool) string {
+	if isPrimary {
+		return fmt.Sprintf("%s/%s*", tenantID, userID)
+	}
+	return fmt.Sprintf("%s/%s", tenantID, userID)
+}
+
+func (h *Handlers) fixAuth0Discrepancies(ctx context.Context, tenantID, sourceOfTruth string, personSvc db.PersonRepository, discrepancies []*Auth0Discrepancy) {
+	// Taking auth0 as the source of truth changes people's provisioning, which cached people reads include
+	defer h.hierarchyCache.Invalidate(ctx, tenantID)
+
+	// A person can have several discrepancies, but a single reprovision fixes all of them
+	fixed := map[string]error{}
+
+	for _, d := range discrepancies {
+		key := d.PersonID + ":" + d.Email
+		if err, ok := fixed[key]; ok {
+			d.Fixed = err == nil
+			if err != nil {
+				d.FixError = err.Error()
+			}
+			continue
+		}
+
+		var err error
+		switch sourceOfTruth {
+		case ReconcileSourceOrchard:
+			// updateUserProvisioning provisions every record for the email, or unprovisions the person if there are none left
+			_, err = updateUserProvisioning(ctx, tenantID, d.PersonID, d.Email, personSvc, h.identityProvider)
+		case ReconcileSourceAuth0:
+			switch d.Type {
+			case DiscrepancyMissingInAuth0, DiscrepancyLicenseInactive:
+				err = setPersonProvisioned(ctx, personSvc, tenantID, d.PersonID, false)
+			case DiscrepancyNotProvisionedInSQL:
+				err = setPersonProvisioned(ctx, personSvc, tenantID, d.PersonID, true)
+			default:
+				// person_id, primary tenant and tenant_contexts are derived from orchard data, so there is nothing to copy back
+				continue
+			}
+		}
+
+		fixed[key] = err
+		d.Fixed = err == nil
+		if err != nil {
+			d.FixError = err.Error()
+			log.WithContext(ctx).WithTenantID(tenantID).WithCustom("personId", d.PersonID).Error(errors.Wrap(err, "error fixing auth0 discrepancy"))
+		}
+	}
+}
+
+func setPersonProvisioned(ctx context.Context, personSvc db.PersonRepository, tenantID, personID string, isProvisioned bool) error {
+	person, err := personSvc.GetByID(ctx, personID, tenantID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("person not found in orchard")
+		}
+		return err
+	}
+
+	person.IsProvisioned = isProvisioned
+	person.UpdatedBy = db.DefaultTenantID
+	person.UpdatedAt = time.Now().UTC()
+
+	return personSvc.Update(ctx, person, []string{"is_provisioned"})
+}
+
+// RunAuth0Reconciler reconciles every active tenant with auth0 on the configured interval until the context is cancelled,
+// skipping a run while another replica holds the reconcile lock
+func (h *Handlers) RunAuth0Reconciler(ctx context.Context) error {
+	if h.cfg.Auth0ReconcileIntervalMinutes <= 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(time.Duration(h.cfg.Auth0ReconcileIntervalMinutes) * time.Minute)
+	defer ticker.Stop()
+
+	opts := ReconcileAuth0Options{
+		Fix:           h.cfg.Auth0ReconcileFix,
+		SourceOfTruth: h.cfg.Auth0ReconcileSourceOfTruth,
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			// Every replica runs this loop, the lock keeps fix mode from provisioning the same people twice
+			h.runLocked(ctx, Auth0ReconcileLock, func() {
+				tenants, err := h.db.NewTenantService().GetActiveTenants(ctx)
+				if err != nil {
+					log.WithContext(ctx).Error(errors.Wrap(err, "error getting active tenants for auth0 reconciliation"))
+					return
+				}
+				for _, tenant := range tenants {
+					if ctx.Err() != nil {
+						return
+					}
+					if _, err := h.ReconcileAuth0(ctx, tenant.ID, opts); err != nil {
+						log.WithContext(ctx).WithTenantID(tenant.ID).Error(errors.Wrap(err, "error reconciling auth0"))
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/loupe-co/go-common/fixtures"
+	"github.com/loupe-co/orchard/internal/clients"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/models"
+	"github.com/volatiletech/null/v8"
+)
+
+type reconcileAuth0TestData struct {
+	TenantID      string              `json:"tenant_id"`
+	People        []*models.Person    `json:"people"`
+	Identities    []*clients.Identity `json:"identities"`
+	Discrepancies []string            `json:"discrepancies"`
+}
+
+// setupReconcileAuth0 runs the handlers on a memory store of their own holding only the fixture's people,
+// reconciling lists every provisioned person in the tenant so it can't share the seeded store
+func setupReconcileAuth0() (*Handlers, *clients.Fakes, db.Store, *reconcileAuth0TestData, error) {
+	raw, _, _, err := jsonparser.Get(fixtures.Data["reconcile_auth0"], "TestReconcileAuth0")
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	testData := &reconcileAuth0TestData{}
+	if err := json.Unmarshal(raw, testData); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	store := db.NewMemoryStore()
+	for _, person := range testData.People {
+		if err := store.NewPersonService().Insert(context.Background(), person); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+
+	h, fakes := NewWithFakes(testConfig, store)
+	for _, identity := range testData.Identities {
+		fakes.Identity.SetIdentity(identity)
+	}
+	return h, fakes, store, testData, nil
+}
+
+func formatDiscrepancies(discrepancies []*Auth0Discrepancy) string {
+	res := make([]string, len(discrepancies))
+	for i, d := range discrepancies {
+		res[i] = d.Type + ":" + d.PersonID
+	}
+	sort.Strings(res)
+	return strings.Join(res, "\n")
+}
+
+func TestReconcileAuth0Report(t *testing.T) {
+	h, fakes, _, testData, err := setupReconcileAuth0()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	report, err := h.ReconcileAuth0(context.Background(), testData.TenantID, ReconcileAuth0Options{})
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	sort.Strings(testData.Discrepancies)
+	if res := formatDiscrepancies(report.Discrepancies); res != strings.Join(testData.Discrepancies, "\n") {
+		t.Logf("expected discrepancies\n%s\nbut got\n%s", strings.Join(testData.Discrepancies, "\n"), res)
+		t.Fail()
+		return
+	}
+	if report.SourceOfTruth != ReconcileSourceOrchard || report.PeopleChecked != 4 || report.Auth0UsersChecked != 4 {
+		t.Logf("expected orchard as the default source of truth with 4 people and 4 auth0 users checked, but got %s with %d and %d", report.SourceOfTruth, report.PeopleChecked, report.Auth0UsersChecked)
+		t.Fail()
+		return
+	}
+	if calls := fakes.Identity.CallCount("Provision") + fakes.Identity.CallCount("Unprovision"); calls != 0 {
+		t.Logf("expected a report without fix to leave auth0 alone, but got %d changes", calls)
+		t.Fail()
+		return
+	}
+
+	rawResult, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if err := fixtures.WriteTestResult("../../fixtures/results/TestReconcileAuth0Report.json", rawResult); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+}
+
+func TestReconcileAuth0FixOrchardSource(t *testing.T) {
+	h, fakes, _, testData, err := setupReconcileAuth0()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	report, err := h.ReconcileAuth0(ctx, testData.TenantID, ReconcileAuth0Options{Fix: true, SourceOfTruth: ReconcileSourceOrchard})
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	for _, d := range report.Discrepancies {
+		if !d.Fixed {
+			t.Logf("expected %s for %s to be fixed, but got %q", d.Type, d.PersonID, d.FixError)
+			t.Fail()
+			return
+		}
+	}
+
+	// alex has two discrepancies but is only reprovisioned once
+	provisioned := fakes.Identity.ProvisionedEmails()
+	sort.Strings(provisioned)
+	if strings.Join(provisioned, ",") != "alex@canopy.io,brandon@canopy.io,dan@canopy.io" {
+		t.Log("expected alex, brandon and dan to be provisioned once each, but got", provisioned)
+		t.Fail()
+		return
+	}
+	if calls := fakes.Identity.CallCount("Unprovision"); calls != 1 {
+		t.Logf("expected the auth0 user without a person to be unprovisioned, but got %d calls", calls)
+		t.Fail()
+		return
+	}
+
+	report, err = h.ReconcileAuth0(ctx, testData.TenantID, ReconcileAuth0Options{})
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(report.Discrepancies) != 0 {
+		t.Logf("expected no discrepancies after fixing, but got\n%s", formatDiscrepancies(report.Discrepancies))
+		t.Fail()
+		return
+	}
+}
+
+func TestReconcileAuth0FixAuth0Source(t *testing.T) {
+	h, fakes, store, testData, err := setupReconcileAuth0()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	report, err := h.ReconcileAuth0(ctx, testData.TenantID, ReconcileAuth0Options{Fix: true, SourceOfTruth: ReconcileSourceAuth0})
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	expected := map[string]struct {
+		fixed    bool
+		fixError string
+	}{
+		DiscrepancyMissingInAuth0 + ":3d1e29d0-79ea-4b77-9daf-f7fd41abd76b":      {fixed: true},
+		DiscrepancyLicenseInactive + ":af588e6a-bd5b-44c0-8b2f-132f7089f560":     {fixed: true},
+		DiscrepancyPersonIDMismatch + ":af588e6a-bd5b-44c0-8b2f-132f7089f560":    {fixed: true},
+		DiscrepancyTenantContexts + ":bc094f95-91b7-42e6-8ee4-5dbb046c4406":      {fixed: false},
+		DiscrepancyNotProvisionedInSQL + ":37984e9d-eb31-4d56-b4a8-bafd137c1208": {fixed: false, fixError: "person not found in orchard"},
+	}
+	for _, d := range report.Discrepancies {
+		want, ok := expected[d.Type+":"+d.PersonID]
+		if !ok || d.Fixed != want.fixed || !strings.Contains(d.FixError, want.fixError) || (want.fixError == "" && d.FixError != "") {
+			t.Logf("expected %s for %s to be fixed %t with %q, but got %t with %q", d.Type, d.PersonID, want.fixed, want.fixError, d.Fixed, d.FixError)
+			t.Fail()
+			return
+		}
+	}
+
+	// auth0 wins, so only orchard changes
+	if calls := fakes.Identity.CallCount("Provision") + fakes.Identity.CallCount("Unprovision"); calls != 0 {
+		t.Logf("expected auth0 to be left alone, but got %d changes", calls)
+		t.Fail()
+		return
+	}
+	for id, isProvisioned := range map[string]bool{
+		"546e7b03-a6f8-42b6-8cd7-521c52bb43e3": true,
+		"3d1e29d0-79ea-4b77-9daf-f7fd41abd76b": false,
+		"af588e6a-bd5b-44c0-8b2f-132f7089f560": false,
+		"bc094f95-91b7-42e6-8ee4-5dbb046c4406": true,
+	} {
+		person, err := store.NewPersonService().GetByID(ctx, id, testData.TenantID)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if person.IsProvisioned != isProvisioned {
+			t.Logf("expected %s to have is_provisioned %t, but got %t", person.Name.String, isProvisioned, person.IsProvisioned)
+			t.Fail()
+			return
+		}
+	}
+}
+
+func TestReconcileAuth0InvalidOptions(t *testing.T) {
+	h, _, _, testData, err := setupReconcileAuth0()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if _, err := h.ReconcileAuth0(context.Background(), "", ReconcileAuth0Options{}); err == nil {
+		t.Log("expected an empty tenant id to be refused")
+		t.Fail()
+		return
+	}
+	if _, err := h.ReconcileAuth0(context.Background(), testData.TenantID, ReconcileAuth0Options{SourceOfTruth: "crm"}); err == nil {
+		t.Log("expected an unknown source of truth to be refused")
+		t.Fail()
+		return
+	}
+}
+
+func TestCompareAuth0Identity(t *testing.T) {
+	tenantID := "00000000-0000-0000-0000-000000000000"
+	otherTenantID := "5aa7aabb-12ea-4c6e-ac71-35a8dcfdb5ac"
+	now := time.Now().UTC()
+	primary := &models.Person{ID: "p1", TenantID: otherTenantID, Email: null.StringFrom("pat@canopy.io"), CreatedAt: now.Add(-time.Hour)}
+	person := &models.Person{ID: "p2", TenantID: tenantID, Email: null.StringFrom("pat@canopy.io"), CreatedAt: now}
+	records := []*models.Person{person, primary}
+
+	matching := &clients.Identity{
+		UserID:   "email|pat",
+		PersonID: "p1",
+		TenantID: otherTenantID,
+		License:  clients.Auth0License{IsActive: true},
+		TenantContexts: []clients.TenantContext{
+			{TenantID: otherTenantID, UserID: "p1", IsPrimary: true},
+			{TenantID: tenantID, UserID: "p2"},
+		},
+	}
+	if res := compareAuth0Identity(person, "pat@canopy.io", matching, records); len(res) != 0 {
+		t.Logf("expected an identity built from the oldest record to match, but got\n%s", formatDiscrepancies(res))
+		t.Fail()
+		return
+	}
+
+	// provisioned from the newer record, so the primary is wrong everywhere
+	drifted := &clients.Identity{
+		UserID:   "email|pat",
+		PersonID: "p2",
+		TenantID: tenantID,
+		License:  clients.Auth0License{IsActive: false},
+		TenantContexts: []clients.TenantContext{
+			{TenantID: tenantID, UserID: "p2", IsPrimary: true},
+		},
+	}
+	res := compareAuth0Identity(person, "pat@canopy.io", drifted, records)
+	expected := []string{
+		DiscrepancyLicenseInactive + ":p2",
+		DiscrepancyPersonIDMismatch + ":p2",
+		DiscrepancyPrimaryTenant + ":p2",
+		DiscrepancyTenantContexts + ":p2",
+	}
+	sort.Strings(expected)
+	if formatDiscrepancies(res) != strings.Join(expected, "\n") {
+		t.Logf("expected\n%s\nbut got\n%s", strings.Join(expected, "\n"), formatDiscrepancies(res))
+		t.Fail()
+		return
+	}
+	for _, d := range res {
+		if d.Type == DiscrepancyTenantContexts && (d.Expected != tenantID+"/p2,"+otherTenantID+"/p1*" || d.Actual != tenantID+"/p2*") {
+			t.Logf("expected the tenant contexts to be reported sorted with the primary marked, but got %s and %s", d.Expected, d.Actual)
+			t.Fail()
+			return
+		}
+		if d.Auth0UserID != "email|pat" || d.Email != "pat@canopy.io" {
+			t.Log("expected every discrepancy to name the auth0 user and email, but got", d)
+			t.Fail()
+			return
+		}
+	}
+
+	// without records only the license can be checked
+	if res := compareAuth0Identity(person, "pat@canopy.io", drifted, nil); formatDiscrepancies(res) != DiscrepancyLicenseInactive+":p2" {
+		t.Logf("expected only the license to be checked without records, but got\n%s", formatDiscrepancies(res))
+		t.Fail()
+		return
+	}
+}
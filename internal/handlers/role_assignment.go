@@ -0,0 +1,529 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	strUtil "github.com/loupe-co/go-common/data-structures/slice/string"
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/models"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+	"github.com/volatiletech/sqlboiler/v4/types"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const maxRoleAssignmentRules = 500
+
+// roleAssignmentChange is a person whose system roles a rule set changes, roleIDs are the roles they end up with
+type roleAssignmentChange struct {
+	person  *models.Person
+	roleIDs types.StringArray
+	added   []string
+	removed []string
+}
+
+// roleAssignmentPlan is what a rule set does to a tenant's people. People whose roles were set by hand are left out of changes,
+// locked has the ones the rules would have changed.
+type roleAssignmentPlan struct {
+	changes []*roleAssignmentChange
+	locked  []*roleAssignmentChange
+}
+
+// planRoleAssignments works out the roles rules give people, only personIDs when there are any, otherwise the whole tenant.
+// Every role a rule mentions is managed by the rules: people get it when one of its rules matches them and lose it otherwise.
+// Roles no rule mentions are kept as they are, and roles an access review revoked aren't given back. It reads in tx when it
+// isn't nil, so it sees the caller's uncommitted changes.
+func (h *Handlers) planRoleAssignments(ctx context.Context, tenantID string, rules []*db.RoleAssignmentRule, tx *sql.Tx, personIDs ...string) (*roleAssignmentPlan, error) {
+	plan := &roleAssignmentPlan{}
+	if len(rules) == 0 {
+		return plan, nil
+	}
+
+	groupSvc := h.db.NewGroupService()
+	groupSvc.SetTransaction(tx)
+	subtrees := map[string]map[string]bool{}
+	for _, rule := range rules {
+		if rule.Kind != db.RoleAssignmentRuleKindSubtree || subtrees[rule.Value] != nil {
+			continue
+		}
+		ids, err := groupSvc.GetSubTreeIDs(ctx, tenantID, rule.Value)
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting role assignment rule subtree")
+		}
+		subtrees[rule.Value] = map[string]bool{}
+		for _, id := range ids {
+			subtrees[rule.Value][id] = true
+		}
+	}
+
+	personSvc := h.db.NewPersonService()
+	personSvc.SetTransaction(tx)
+	var people []*models.Person
+	var err error
+	if len(personIDs) > 0 {
+		ids := make([]interface{}, len(personIDs))
+		for i, id := range personIDs {
+			ids[i] = id
+		}
+		people, err = personSvc.GetByIDs(ctx, tenantID, ids...)
+	} else {
+		people, err = personSvc.GetAllByTenant(ctx, tenantID)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting people for role assignment rules")
+	}
+
+	managed := map[string]bool{}
+	for _, rule := range rules {
+		managed[rule.SystemRoleID] = true
+	}
+
+	reviewSvc := h.db.NewAccessReviewService()
+	reviewSvc.SetTransaction(tx)
+	revoked, err := reviewSvc.GetRevokedSystemRoles(ctx, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting system roles revoked by access reviews")
+	}
+
+	for _, p := range people {
+		change := planPersonRoles(rules, subtrees, managed, revoked[p.ID], p)
+		if change == nil {
+			continue
+		}
+		if p.RoleIdsLocked {
+			plan.locked = append(plan.locked, change)
+		} else {
+			plan.changes = append(plan.changes, change)
+		}
+	}
+	return plan, nil
+}
+
+// planPersonRoles returns how the rules change a person's roles, or nil if they don't. Rules never give back a role in revoked,
+// the roles an access review took off the person.
+func planPersonRoles(rules []*db.RoleAssignmentRule, subtrees map[string]map[string]bool, managed map[string]bool, revoked []string, p *models.Person) *roleAssignmentChange {
+	has := map[string]bool{}
+	roleIDs := types.StringArray{}
+	for _, id := range p.RoleIds {
+		if !managed[id] && !has[id] {
+			has[id] = true
+			roleIDs = append(roleIDs, id)
+		}
+	}
+	for _, rule := range rules {
+		if !has[rule.SystemRoleID] && !strUtil.Strings(revoked).Has(rule.SystemRoleID) && roleAssignmentRuleMatches(rule, subtrees, p) {
+			has[rule.SystemRoleID] = true
+			roleIDs = append(roleIDs, rule.SystemRoleID)
+		}
+	}
+
+	change := &roleAssignmentChange{person: p, roleIDs: roleIDs}
+	current := map[string]bool{}
+	for _, id := range p.RoleIds {
+		current[id] = true
+		if !has[id] {
+			change.removed = append(change.removed, id)
+		}
+	}
+	for _, id := range roleIDs {
+		if !current[id] {
+			change.added = append(change.added, id)
+		}
+	}
+	if len(change.added) == 0 && len(change.removed) == 0 {
+		return nil
+	}
+	return change
+}
+
+// sameRoleIDs is whether a and b hold the same roles, in any order
+func sameRoleIDs(a, b []string) bool {
+	inA, inB := map[string]bool{}, map[string]bool{}
+	for _, id := range a {
+		inA[id] = true
+	}
+	for _, id := range b {
+		if !inA[id] {
+			return false
+		}
+		inB[id] = true
+	}
+	return len(inA) == len(inB)
+}
+
+func roleAssignmentRuleMatches(rule *db.RoleAssignmentRule, subtrees map[string]map[string]bool, p *models.Person) bool {
+	switch rule.Kind {
+	case db.RoleAssignmentRuleKindGroupType:
+		return strings.EqualFold(p.Type, rule.Value)
+	case db.RoleAssignmentRuleKindSubtree:
+		return p.GroupID.Valid && subtrees[rule.Value][p.GroupID.String]
+	case db.RoleAssignmentRuleKindEmailDomain:
+		email := strings.ToLower(strings.TrimSpace(p.Email.String))
+		at := strings.LastIndex(email, "@")
+		return at >= 0 && email[at+1:] == rule.Value
+	}
+	return false
+}
+
+// applyRoleAssignmentPlan saves the planned roles in tx and queues a bouncer cache bust for everyone whose roles changed
+func (h *Handlers) applyRoleAssignmentPlan(ctx context.Context, tenantID, userID string, tx *sql.Tx, plan *roleAssignmentPlan) error {
+	if len(plan.changes) == 0 {
+		return nil
+	}
+
+	personSvc := h.db.NewPersonService()
+	personSvc.SetTransaction(tx)
+	msgs := make([]*db.OutboxMessage, 0, len(plan.changes))
+	for _, change := range plan.changes {
+		change.person.RoleIds = change.roleIDs
+		change.person.UpdatedBy = userID
+		if err := personSvc.Update(ctx, change.person, []string{"role_ids", "updated_at", "updated_by"}); err != nil {
+			return errors.Wrap(err, "error updating person roles from role assignment rules")
+		}
+		msgs = append(msgs, db.NewBustAuthCacheMessage(tenantID, change.person.ID))
+	}
+
+	outboxSvc := h.db.NewOutboxService()
+	outboxSvc.SetTransaction(tx)
+	if err := outboxSvc.Enqueue(ctx, msgs...); err != nil {
+		return errors.Wrap(err, "error enqueueing role assignment cache busts")
+	}
+	return nil
+}
+
+// applyRoleAssignmentRules applies a tenant's saved rules in tx, to personIDs when there are any or else to the whole tenant
+func (h *Handlers) applyRoleAssignmentRules(ctx context.Context, tenantID, userID string, tx *sql.Tx, personIDs ...string) ([]*roleAssignmentChange, error) {
+	ruleSvc := h.db.NewRoleAssignmentRuleService()
+	ruleSvc.SetTransaction(tx)
+	rules, err := ruleSvc.GetRules(ctx, tenantID)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting role assignment rules")
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	plan, err := h.planRoleAssignments(ctx, tenantID, rules, tx, personIDs...)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.applyRoleAssignmentPlan(ctx, tenantID, userID, tx, plan); err != nil {
+		return nil, err
+	}
+	if len(plan.changes) > 0 || len(plan.locked) > 0 {
+		log.WithContext(ctx).WithTenantID(tenantID).
+			WithCustom("changed", len(plan.changes)).
+			WithCustom("locked", len(plan.locked)).
+			Info("applied role assignment rules")
+	}
+	return plan.changes, nil
+}
+
+// roleAssignmentRulesFromProto checks a rule set and turns it into rules for tenantID, duplicate rules are dropped. A non-empty
+// problem means the rule set is invalid.
+func (h *Handlers) roleAssignmentRulesFromProto(ctx context.Context, tenantID, userID string, in []*orchardPb.RoleAssignmentRule) (rules []*db.RoleAssignmentRule, problem string, err error) {
+	if len(in) > maxRoleAssignmentRules {
+		return nil, fmt.Sprintf("a tenant can have at most %d role assignment rules", maxRoleAssignmentRules), nil
+	}
+
+	groupSvc := h.db.NewGroupService()
+	seen := map[string]bool{}
+	roleIDs := []string{}
+	for i, r := range in {
+		if r == nil {
+			return nil, fmt.Sprintf("rule %d is null", i), nil
+		}
+		rule := &db.RoleAssignmentRule{
+			ID:           db.MakeID(),
+			TenantID:     tenantID,
+			Value:        strings.TrimSpace(r.Value),
+			SystemRoleID: r.SystemRoleId,
+			CreatedBy:    userID,
+		}
+		if rule.SystemRoleID == "" {
+			return nil, fmt.Sprintf("rule %d has no systemRoleId", i), nil
+		}
+
+		switch r.Kind {
+		case orchardPb.RoleAssignmentRuleKind_GroupType:
+			rule.Kind = db.RoleAssignmentRuleKindGroupType
+			rule.Value = strings.ToLower(rule.Value)
+			if !strUtil.Strings(models.AllPersonType()).Has(rule.Value) {
+				return nil, fmt.Sprintf("rule %d has unknown group type %q", i, r.Value), nil
+			}
+		case orchardPb.RoleAssignmentRuleKind_Subtree:
+			rule.Kind = db.RoleAssignmentRuleKindSubtree
+			if rule.Value == "" {
+				return nil, fmt.Sprintf("rule %d has no group id", i), nil
+			}
+			g, err := groupSvc.GetByID(ctx, rule.Value, tenantID)
+			if err != nil {
+				return nil, "", errors.Wrap(err, "error getting role assignment rule group")
+			}
+			if g == nil {
+				return nil, fmt.Sprintf("rule %d group %s not found", i, rule.Value), nil
+			}
+		case orchardPb.RoleAssignmentRuleKind_EmailDomain:
+			rule.Kind = db.RoleAssignmentRuleKindEmailDomain
+			rule.Value = strings.ToLower(strings.TrimPrefix(rule.Value, "@"))
+			if rule.Value == "" || strings.Contains(rule.Value, "@") {
+				return nil, fmt.Sprintf("rule %d has invalid email domain %q", i, r.Value), nil
+			}
+		default:
+			return nil, fmt.Sprintf("rule %d has unknown kind %s", i, r.Kind), nil
+		}
+
+		key := rule.Kind + "/" + rule.Value + "/" + rule.SystemRoleID
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if !strUtil.Strings(roleIDs).Has(rule.SystemRoleID) {
+			roleIDs = append(roleIDs, rule.SystemRoleID)
+		}
+		rules = append(rules, rule)
+	}
+
+	if len(roleIDs) > 0 {
+		roles, err := h.db.NewSystemRoleService().GetByIDs(ctx, roleIDs...)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "error getting role assignment rule system roles")
+		}
+		found := map[string]*models.SystemRole{}
+		for _, sr := range roles {
+			found[sr.ID] = sr
+		}
+		for _, id := range roleIDs {
+			sr, ok := found[id]
+			switch {
+			case !ok || (sr.TenantID != tenantID && sr.TenantID != db.DefaultTenantID):
+				return nil, fmt.Sprintf("system role %s not found", id), nil
+			case sr.Status != "active":
+				return nil, fmt.Sprintf("system role %s isn't active", id), nil
+			}
+		}
+	}
+
+	return rules, "", nil
+}
+
+// GetRoleAssignmentRules returns a tenant's role assignment rules
+func (h *Handlers) GetRoleAssignmentRules(ctx context.Context, in *servicePb.GetRoleAssignmentRulesRequest) (*servicePb.GetRoleAssignmentRulesResponse, error) {
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
+
+	if in.TenantId == "" {
+		err := ErrBadRequest.New("tenantId can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	rules, err := h.db.NewRoleAssignmentRuleService().GetRules(ctx, in.TenantId)
+	if err != nil {
+		err := errors.Wrap(err, "error getting role assignment rules")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	res := &servicePb.GetRoleAssignmentRulesResponse{}
+	for _, rule := range rules {
+		res.Rules = append(res.Rules, roleAssignmentRuleToProto(rule))
+	}
+	return res, nil
+}
+
+// SetRoleAssignmentRules replaces a tenant's role assignment rules. With apply set the new rules are applied to everyone in the
+// same transaction, otherwise they take effect the next time people sync, are created or their groups move.
+func (h *Handlers) SetRoleAssignmentRules(ctx context.Context, in *servicePb.SetRoleAssignmentRulesRequest) (*servicePb.SetRoleAssignmentRulesResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("apply", in.Apply)
+
+	if in.TenantId == "" {
+		err := ErrBadRequest.New("tenantId can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+	if in.UserId == "" {
+		in.UserId = db.DefaultTenantID
+	}
+
+	rules, problem, err := h.roleAssignmentRulesFromProto(ctx, in.TenantId, in.UserId, in.Rules)
+	if err != nil {
+		err := errors.Wrap(err, "error checking role assignment rules")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	if problem != "" {
+		err := ErrBadRequest.New(problem)
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	tx, err := h.db.NewTransaction(ctx)
+	if err != nil {
+		err := errors.Wrap(err, "error creating role assignment rules transaction")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	svc := h.db.NewRoleAssignmentRuleService()
+	svc.SetTransaction(tx)
+	defer svc.Rollback()
+
+	if err := svc.SetRules(ctx, in.TenantId, rules, time.Now().UTC()); err != nil {
+		err := errors.Wrap(err, "error saving role assignment rules")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	var changes []*roleAssignmentChange
+	if in.Apply {
+		changes, err = h.applyRoleAssignmentRules(ctx, in.TenantId, in.UserId, tx)
+		if err != nil {
+			err := errors.Wrap(err, "error applying role assignment rules")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+	}
+
+	if err := svc.Commit(); err != nil {
+		err := errors.Wrap(err, "error committing role assignment rules transaction")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	res := &servicePb.SetRoleAssignmentRulesResponse{Changes: roleAssignmentChangesToProto(changes)}
+	for _, rule := range rules {
+		res.Rules = append(res.Rules, roleAssignmentRuleToProto(rule))
+	}
+	return res, nil
+}
+
+// PreviewRoleAssignmentRules shows whose roles a rule set would change without saving anything, the tenant's saved rules are
+// previewed when the request has none. People whose roles were set by hand are listed separately, the rules leave them alone.
+func (h *Handlers) PreviewRoleAssignmentRules(ctx context.Context, in *servicePb.PreviewRoleAssignmentRulesRequest) (*servicePb.PreviewRoleAssignmentRulesResponse, error) {
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
+
+	if in.TenantId == "" {
+		err := ErrBadRequest.New("tenantId can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	var rules []*db.RoleAssignmentRule
+	if len(in.Rules) > 0 {
+		var problem string
+		var err error
+		rules, problem, err = h.roleAssignmentRulesFromProto(ctx, in.TenantId, "", in.Rules)
+		if err != nil {
+			err := errors.Wrap(err, "error checking role assignment rules")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		if problem != "" {
+			err := ErrBadRequest.New(problem)
+			logger.Warn(err.Error())
+			return nil, err.AsGRPC()
+		}
+	} else {
+		var err error
+		rules, err = h.db.NewRoleAssignmentRuleService().GetRules(ctx, in.TenantId)
+		if err != nil {
+			err := errors.Wrap(err, "error getting role assignment rules")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+	}
+
+	plan, err := h.planRoleAssignments(ctx, in.TenantId, rules, nil, in.PersonIds...)
+	if err != nil {
+		err := errors.Wrap(err, "error previewing role assignment rules")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	return &servicePb.PreviewRoleAssignmentRulesResponse{
+		Changes: roleAssignmentChangesToProto(plan.changes),
+		Locked:  roleAssignmentChangesToProto(plan.locked),
+	}, nil
+}
+
+// ApplyRoleAssignmentRules applies a tenant's saved rules now, to the given people or to everyone
+func (h *Handlers) ApplyRoleAssignmentRules(ctx context.Context, in *servicePb.ApplyRoleAssignmentRulesRequest) (*servicePb.ApplyRoleAssignmentRulesResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
+
+	if in.TenantId == "" {
+		err := ErrBadRequest.New("tenantId can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+	if in.UserId == "" {
+		in.UserId = db.DefaultTenantID
+	}
+
+	tx, err := h.db.NewTransaction(ctx)
+	if err != nil {
+		err := errors.Wrap(err, "error creating role assignment rules transaction")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+	svc := h.db.NewPersonService()
+	svc.SetTransaction(tx)
+	defer svc.Rollback()
+
+	changes, err := h.applyRoleAssignmentRules(ctx, in.TenantId, in.UserId, tx, in.PersonIds...)
+	if err != nil {
+		err := errors.Wrap(err, "error applying role assignment rules")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	if err := svc.Commit(); err != nil {
+		err := errors.Wrap(err, "error committing role assignment rules transaction")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	return &servicePb.ApplyRoleAssignmentRulesResponse{Changes: roleAssignmentChangesToProto(changes)}, nil
+}
+
+func roleAssignmentRuleToProto(rule *db.RoleAssignmentRule) *orchardPb.RoleAssignmentRule {
+	res := &orchardPb.RoleAssignmentRule{
+		Id:           rule.ID,
+		TenantId:     rule.TenantID,
+		Value:        rule.Value,
+		SystemRoleId: rule.SystemRoleID,
+		CreatedBy:    rule.CreatedBy,
+		CreatedAt:    timestamppb.New(rule.CreatedAt),
+	}
+	switch rule.Kind {
+	case db.RoleAssignmentRuleKindGroupType:
+		res.Kind = orchardPb.RoleAssignmentRuleKind_GroupType
+	case db.RoleAssignmentRuleKindSubtree:
+		res.Kind = orchardPb.RoleAssignmentRuleKind_Subtree
+	case db.RoleAssignmentRuleKindEmailDomain:
+		res.Kind = orchardPb.RoleAssignmentRuleKind_EmailDomain
+	}
+	return res
+}
+
+func roleAssignmentChangesToProto(changes []*roleAssignmentChange) []*orchardPb.RoleAssignmentChange {
+	res := make([]*orchardPb.RoleAssignmentChange, 0, len(changes))
+	for _, change := range changes {
+		res = append(res, &orchardPb.RoleAssignmentChange{
+			PersonId:       change.person.ID,
+			Name:           change.person.Name.String,
+			Email:          change.person.Email.String,
+			RoleIds:        []string(change.roleIDs),
+			AddedRoleIds:   change.added,
+			RemovedRoleIds: change.removed,
+			RoleIdsLocked:  change.person.RoleIdsLocked,
+		})
+	}
+	return res
+}
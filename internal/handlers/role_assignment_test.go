@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/loupe-co/go-common/fixtures"
+	"github.com/loupe-co/orchard/internal/db"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+)
+
+// roleAssignmentTestRule is a rule with its kind given by name
+type roleAssignmentTestRule struct {
+	Name         string `json:"name"`
+	Kind         string `json:"kind"`
+	Value        string `json:"value"`
+	SystemRoleID string `json:"system_role_id"`
+}
+
+// getRoleAssignmentTestRules builds the rules at keys of fixtures/role_assignment.json
+func getRoleAssignmentTestRules(keys ...string) ([]*orchardPb.RoleAssignmentRule, []*roleAssignmentTestRule, error) {
+	raw, _, _, err := jsonparser.Get(fixtures.Data["role_assignment"], keys...)
+	if err != nil {
+		return nil, nil, err
+	}
+	testRules := []*roleAssignmentTestRule{}
+	if err := json.Unmarshal(raw, &testRules); err != nil {
+		return nil, nil, err
+	}
+	rules := []*orchardPb.RoleAssignmentRule{}
+	for _, r := range testRules {
+		kind, ok := orchardPb.RoleAssignmentRuleKind_value[r.Kind]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown role assignment rule kind %q", r.Kind)
+		}
+		rules = append(rules, &orchardPb.RoleAssignmentRule{Kind: orchardPb.RoleAssignmentRuleKind(kind), Value: r.Value, SystemRoleId: r.SystemRoleID})
+	}
+	return rules, testRules, nil
+}
+
+// personRoleIDs is the set of system roles personID holds
+func personRoleIDs(store *db.MemoryStore, personID string) (map[string]bool, error) {
+	people, err := store.NewPersonService().GetByIDs(context.Background(), db.DefaultTenantID, personID)
+	if err != nil {
+		return nil, err
+	}
+	if len(people) != 1 {
+		return nil, fmt.Errorf("expected person %s to exist", personID)
+	}
+	roles := map[string]bool{}
+	for _, id := range people[0].RoleIds {
+		roles[id] = true
+	}
+	return roles, nil
+}
+
+func TestRoleAssignmentRules(t *testing.T) {
+	h, fakes, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	// an IC, an enterprise manager at example.com, a manager who still has the IC role and a role no rule mentions, and
+	// someone whose roles were set by hand
+	data, err := insertTestData(store, "role_assignment", "TestRoleAssignmentRules", "store")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	icRole, subtreeRole, domainRole, handSet := data.SystemRoles[0], data.SystemRoles[1], data.SystemRoles[2], data.SystemRoles[3]
+	ic, enterprise, stale, locked := data.People[0], data.People[1], data.People[2], data.People[3]
+
+	rules, _, err := getRoleAssignmentTestRules("TestRoleAssignmentRules", "rules")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ours := []string{ic.ID, enterprise.ID, stale.ID, locked.ID}
+
+	preview, err := h.PreviewRoleAssignmentRules(ctx, &servicePb.PreviewRoleAssignmentRulesRequest{TenantId: db.DefaultTenantID, Rules: rules, PersonIds: ours})
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(preview.Changes) != 3 {
+		t.Log("expected the rules to change 3 people, but got", preview.Changes)
+		t.Fail()
+		return
+	}
+	if len(preview.Locked) != 1 || preview.Locked[0].PersonId != locked.ID || !preview.Locked[0].RoleIdsLocked {
+		t.Log("expected the person with hand set roles to be listed as locked, but got", preview.Locked)
+		t.Fail()
+		return
+	}
+	roles, err := personRoleIDs(store, ic.ID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(roles) != 0 {
+		t.Log("expected a preview not to save anything, but got", roles)
+		t.Fail()
+		return
+	}
+
+	fakes.Reset()
+	set, err := h.SetRoleAssignmentRules(ctx, &servicePb.SetRoleAssignmentRulesRequest{TenantId: db.DefaultTenantID, Rules: rules, Apply: true, UserId: seedPatID})
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(set.Rules) != 3 {
+		t.Logf("expected the duplicate rule to be dropped, but got %d rules", len(set.Rules))
+		t.Fail()
+		return
+	}
+
+	want := map[string]map[string]bool{
+		ic.ID:         {icRole.ID: true},
+		enterprise.ID: {subtreeRole.ID: true, domainRole.ID: true},
+		stale.ID:      {handSet.ID: true},
+		locked.ID:     {},
+	}
+	for id, wantRoles := range want {
+		got, err := personRoleIDs(store, id)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		if len(got) != len(wantRoles) {
+			t.Log("expected", id, "to hold", wantRoles, "but got", got)
+			t.Fail()
+			return
+		}
+		for roleID := range wantRoles {
+			if !got[roleID] {
+				t.Log("expected", id, "to hold", roleID, "but got", got)
+				t.Fail()
+				return
+			}
+		}
+	}
+
+	if _, err := h.DispatchOutbox(ctx); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	busted := map[string]bool{}
+	for _, id := range fakes.Bouncer.BustedUserIDs(db.DefaultTenantID) {
+		busted[id] = true
+	}
+	if !busted[ic.ID] || !busted[enterprise.ID] || !busted[stale.ID] || busted[locked.ID] {
+		t.Log("expected everyone but the locked person to be busted, but got", busted)
+		t.Fail()
+		return
+	}
+
+	saved, err := h.GetRoleAssignmentRules(ctx, &servicePb.GetRoleAssignmentRulesRequest{TenantId: db.DefaultTenantID})
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(saved.Rules) != 3 {
+		t.Log("expected the 3 saved rules, but got", saved.Rules)
+		t.Fail()
+		return
+	}
+	for _, rule := range saved.Rules {
+		if rule.Kind == orchardPb.RoleAssignmentRuleKind_GroupType && rule.Value != "ic" {
+			t.Logf("expected the group type to be saved lower case and trimmed, but got %q", rule.Value)
+			t.Fail()
+			return
+		}
+		if rule.Kind == orchardPb.RoleAssignmentRuleKind_EmailDomain && rule.Value != "example.com" {
+			t.Logf("expected the email domain to be saved without its @, but got %q", rule.Value)
+			t.Fail()
+			return
+		}
+	}
+
+	rawResult, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if err := fixtures.WriteTestResult("../../fixtures/results/TestRoleAssignmentRules.json", rawResult); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// Applying the saved rules to some people leaves everyone else alone
+	applyData, err := insertTestData(store, "role_assignment", "TestRoleAssignmentRules", "apply_store")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	first, second := applyData.People[0], applyData.People[1]
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["role_assignment"], "TestRoleAssignmentRules", "apply_request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	applyReq := &servicePb.ApplyRoleAssignmentRulesRequest{}
+	if err := json.Unmarshal(testData, applyReq); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	applied, err := h.ApplyRoleAssignmentRules(ctx, applyReq)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(applied.Changes) != 1 || applied.Changes[0].PersonId != first.ID {
+		t.Log("expected only the asked for person to change, but got", applied.Changes)
+		t.Fail()
+		return
+	}
+	roles, err = personRoleIDs(store, first.ID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if !roles[icRole.ID] {
+		t.Log("expected the asked for person to get the IC role, but got", roles)
+		t.Fail()
+		return
+	}
+	roles, err = personRoleIDs(store, second.ID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(roles) != 0 {
+		t.Log("expected the person who wasn't asked for to be left alone, but got", roles)
+		t.Fail()
+		return
+	}
+}
+
+func TestSetRoleAssignmentRulesBadRequest(t *testing.T) {
+	h, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	// an active and an inactive role
+	data, err := insertTestData(store, "role_assignment", "TestSetRoleAssignmentRulesBadRequest", "store")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	role := data.SystemRoles[0]
+
+	rules, _, err := getRoleAssignmentTestRules("TestSetRoleAssignmentRulesBadRequest", "rules")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	valid := rules[0]
+	if _, err := h.SetRoleAssignmentRules(ctx, &servicePb.SetRoleAssignmentRulesRequest{TenantId: db.DefaultTenantID, Rules: rules}); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	badRules, badTestRules, err := getRoleAssignmentTestRules("TestSetRoleAssignmentRulesBadRequest", "bad_rules")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	// a kind the protos don't have can't be named in the fixture
+	badRules = append(badRules, &orchardPb.RoleAssignmentRule{Kind: orchardPb.RoleAssignmentRuleKind(99), Value: "ic", SystemRoleId: role.ID})
+	badTestRules = append(badTestRules, &roleAssignmentTestRule{Name: "unknown kind"})
+
+	for i, rule := range badRules {
+		req := &servicePb.SetRoleAssignmentRulesRequest{TenantId: db.DefaultTenantID, Rules: []*orchardPb.RoleAssignmentRule{valid, rule}}
+		_, err := h.SetRoleAssignmentRules(ctx, req)
+		if err == nil {
+			t.Logf("%s: expected a bad request, but got nil error", badTestRules[i].Name)
+			t.Fail()
+			return
+		}
+		if !strings.Contains(err.Error(), "Bad Request") {
+			t.Logf("%s: expected error to contain 'Bad Request', but got %s", badTestRules[i].Name, err.Error())
+			t.Fail()
+			return
+		}
+	}
+	_, err = h.SetRoleAssignmentRules(ctx, &servicePb.SetRoleAssignmentRulesRequest{Rules: []*orchardPb.RoleAssignmentRule{valid}})
+	if err == nil || !strings.Contains(err.Error(), "Bad Request") {
+		t.Log("expected a request without a tenant to be a bad request, but got", err)
+		t.Fail()
+		return
+	}
+
+	saved, err := h.GetRoleAssignmentRules(ctx, &servicePb.GetRoleAssignmentRulesRequest{TenantId: db.DefaultTenantID})
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(saved.Rules) != 1 || saved.Rules[0].SystemRoleId != role.ID {
+		t.Log("expected a bad request not to replace the saved rules, but got", saved.Rules)
+		t.Fail()
+		return
+	}
+}
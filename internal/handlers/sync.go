@@ -13,6 +13,7 @@ import (
 )
 
 func (h *Handlers) Sync(ctx context.Context, in *servicePb.SyncRequest) (*servicePb.SyncResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	spanCtx, span := log.StartSpan(ctx, "Sync")
 	defer span.End()
 
@@ -43,6 +44,7 @@ func (h *Handlers) Sync(ctx context.Context, in *servicePb.SyncRequest) (*servic
 }
 
 func (h *Handlers) ReSyncCRM(ctx context.Context, in *servicePb.ReSyncCRMRequest) (*servicePb.ReSyncCRMResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
 
 	logger.Info("Re-Syncing CRM for tenant")
@@ -8,6 +8,8 @@ import (
 
 	"github.com/buger/jsonparser"
 	"github.com/loupe-co/go-common/fixtures"
+	"github.com/loupe-co/orchard/internal/db"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
 	servicePb "github.com/loupe-co/protos/src/services/orchard"
 )
 
@@ -60,3 +62,49 @@ func TestReSyncCRM(t *testing.T) {
 
 	t.Log(res.Status)
 }
+
+// TestSyncCrmRolesPagesThroughCRMDataSource syncs into a memory store of its own, the fake roles would otherwise be left in
+// the tenant the other tests share
+func TestSyncCrmRolesPagesThroughCRMDataSource(t *testing.T) {
+	tenantID := "00000000-0000-0000-0000-000000000000"
+	store := db.NewMemoryStore()
+	if err := seed(store); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	cfg := testConfig
+	cfg.SyncRolesBatchSize = 1
+	h, fakes := NewWithFakes(cfg, store)
+	fakes.CRM.SetCRMRoles(tenantID,
+		&orchardPb.CRMRole{Id: "fake-crm-role-1", TenantId: tenantID, Name: "Fake Role 1"},
+		&orchardPb.CRMRole{Id: "fake-crm-role-2", TenantId: tenantID, Name: "Fake Role 2", ParentId: "fake-crm-role-1"},
+	)
+
+	if _, err := h.SyncCrmRoles(context.Background(), &servicePb.SyncRequest{TenantId: tenantID}); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	calls := fakes.CRM.Calls("GetLatestCRMRoles")
+	if len(calls) != 2 {
+		t.Logf("expected 2 pages of crm roles to be requested, got %d", len(calls))
+		t.Fail()
+		return
+	}
+	if token := calls[1].Args[3]; token != "1" {
+		t.Logf("expected second page to be requested with token 1, got %v", token)
+		t.Fail()
+		return
+	}
+
+	for _, id := range []string{"fake-crm-role-1", "fake-crm-role-2"} {
+		if _, err := store.NewCRMRoleService().GetByID(context.Background(), id, tenantID, false); err != nil {
+			t.Logf("expected crm role %s to be synced from both pages, got %v", id, err)
+			t.Fail()
+			return
+		}
+	}
+}
@@ -2,15 +2,12 @@ package handlers
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"fmt"
 	"math"
 	"strings"
 	"time"
 
-	_ "embed"
-
 	"github.com/loupe-co/go-common/errors"
 	"github.com/loupe-co/go-loupe-logger/log"
 	"github.com/loupe-co/orchard/internal/db"
@@ -19,10 +16,10 @@ import (
 	tenantPb "github.com/loupe-co/protos/src/common/tenant"
 	servicePb "github.com/loupe-co/protos/src/services/orchard"
 	"github.com/volatiletech/null/v8"
-	"github.com/volatiletech/sqlboiler/queries"
 )
 
 func (h *Handlers) SyncUsers(ctx context.Context, in *servicePb.SyncRequest) (*servicePb.SyncResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	ctx, span := log.StartSpan(ctx, "SyncUsers")
 	defer span.End()
 
@@ -74,7 +71,7 @@ func (h *Handlers) SyncUsers(ctx context.Context, in *servicePb.SyncRequest) (*s
 
 	for {
 		var latestCRMUsers []*orchardPb.Person
-		latestCRMUsers, total, nextToken, err = h.crmClient.GetLatestChangedPeople(ctx, in.TenantId, in.SyncSince, batchSize, nextToken)
+		latestCRMUsers, total, nextToken, err = h.crmDataSource.GetLatestChangedPeople(ctx, in.TenantId, in.SyncSince, batchSize, nextToken)
 		if err != nil {
 			err := errors.Wrap(err, "error getting person data from crm-data-access")
 			logger.Error(err)
@@ -125,6 +122,13 @@ func (h *Handlers) SyncUsers(ctx context.Context, in *servicePb.SyncRequest) (*s
 		return nil, err.AsGRPC()
 	}
 
+	// Synced people get their system roles from the CRM mapping, the tenant's role assignment rules correct them
+	if _, err := h.applyRoleAssignmentRules(ctx, in.TenantId, db.DefaultTenantID, svc.GetTransaction()); err != nil {
+		err := errors.Wrap(err, "error applying role assignment rules")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
 	if err := svc.Commit(); err != nil {
 		err := errors.Wrap(err, "error commiting sync users transactions")
 		logger.Error(err)
@@ -231,14 +235,6 @@ func (h *Handlers) batchUpsertUsers(ctx context.Context, people []*models.Person
 	return nil
 }
 
-//go:embed queries/cleanupCNCUsers.sql
-var cleanupCNCUsersQuery string
-
-type CNCUserCleanupResult struct {
-	ID     sql.NullString `boil:"id" json:"id"`
-	Action sql.NullString `boil:"action" json:"action"`
-}
-
 func (h *Handlers) cleanupCNCUsers(ctx context.Context, tenantID string) error {
 	ctx, span := log.StartSpan(ctx, "batchUpsertUsers")
 	defer span.End()
@@ -253,9 +249,11 @@ func (h *Handlers) cleanupCNCUsers(ctx context.Context, tenantID string) error {
 	pSVC := h.db.NewPersonService()
 
 	defer tx.Rollback()
-	result := []*CNCUserCleanupResult{}
 
-	if err := queries.Raw(cleanupCNCUsersQuery, tenantID).Bind(ctx, tx, &result); err != nil {
+	cleanupSVC := h.db.NewPersonService()
+	cleanupSVC.SetTransaction(tx)
+	result, err := cleanupSVC.CleanupCNCUsers(ctx, tenantID)
+	if err != nil {
 		return errors.Wrap(err, "error committing transaction")
 	}
 
@@ -269,7 +267,7 @@ func (h *Handlers) cleanupCNCUsers(ctx context.Context, tenantID string) error {
 			continue
 		}
 		logger.DeepCopy().WithCustom("id", item.ID.String).Debug("reprovisioning swapped user")
-		if _, err := updateUserProvisioning(ctx, tenantID, item.ID.String, "", pSVC, h.auth0Client); err != nil {
+		if _, err := updateUserProvisioning(ctx, tenantID, item.ID.String, "", pSVC, h.identityProvider); err != nil {
 			return errors.Wrap(err, "error updating user provisioning")
 		}
 	}
@@ -277,9 +275,6 @@ func (h *Handlers) cleanupCNCUsers(ctx context.Context, tenantID string) error {
 	return nil
 }
 
-//go:embed queries/makeUsersHierarchicalQuery.sql
-var makeUsersHierarchicalQuery string
-
 func (h *Handlers) makeHierarchyAdjustments(ctx context.Context, tenantID string) error {
 	ctx, span := log.StartSpan(ctx, "batchUpsertUsers")
 	defer span.End()
@@ -291,7 +286,9 @@ func (h *Handlers) makeHierarchyAdjustments(ctx context.Context, tenantID string
 
 	defer tx.Rollback()
 
-	if _, err := queries.Raw(makeUsersHierarchicalQuery, tenantID).ExecContext(ctx, tx); err != nil {
+	svc := h.db.NewPersonService()
+	svc.SetTransaction(tx)
+	if err := svc.MakeUsersHierarchical(ctx, tenantID); err != nil {
 		return errors.Wrap(err, "error committing transaction")
 	}
 	if err := tx.Commit(); err != nil {
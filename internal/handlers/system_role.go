@@ -2,13 +2,15 @@ package handlers
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
 
 	strUtil "github.com/loupe-co/go-common/data-structures/slice/string"
 	"github.com/loupe-co/go-common/errors"
 	"github.com/loupe-co/go-loupe-logger/log"
 	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/models"
 	orchardPb "github.com/loupe-co/protos/src/common/orchard"
-	bouncerPb "github.com/loupe-co/protos/src/services/bouncer"
 	servicePb "github.com/loupe-co/protos/src/services/orchard"
 	"google.golang.org/grpc/codes"
 )
@@ -34,6 +36,12 @@ func (h *Handlers) CreateSystemRole(ctx context.Context, in *servicePb.CreateSys
 		return nil, err.AsGRPC()
 	}
 
+	if err := resolveSystemRolePermissions(in.SystemRole); err != nil {
+		err := ErrBadRequest.New(err.Error())
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
 	in.SystemRole.Id = db.MakeID()
 
 	if in.TenantId != "" {
@@ -44,11 +52,19 @@ func (h *Handlers) CreateSystemRole(ctx context.Context, in *servicePb.CreateSys
 
 	sr := svc.FromProto(in.SystemRole)
 
+	permissions, err := storePermissionOverrides(ctx, svc, sr, sr.BaseRoleID)
+	if err != nil {
+		err := errors.Wrap(err, "error getting base system role")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
 	if err := svc.Insert(ctx, sr); err != nil {
 		err := errors.Wrap(err, "error inserting system role into sql")
 		logger.Error(err)
 		return nil, err.AsGRPC()
 	}
+	sr.Permissions = permissions
 
 	systemRole, err := svc.ToProto(sr)
 	if err != nil {
@@ -137,16 +153,24 @@ func (h *Handlers) CloneSystemRole(ctx context.Context, in *servicePb.CloneSyste
 
 	sr := svc.FromProto(in.NewSystemRole)
 
-	// add data from base role
+	// add data from base role, cloning a clone keeps its overrides
 	sr.Permissions = basePermissions
 	sr.Type = roleType
 	sr.Status = roleStatus
 
+	permissions, err := storePermissionOverrides(ctx, svc, sr, sr.BaseRoleID)
+	if err != nil {
+		err := errors.Wrap(err, "error getting base system role")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
 	if err := svc.Insert(ctx, sr); err != nil {
 		err := errors.Wrap(err, "error inserting system role into sql")
 		logger.Error(err)
 		return nil, err.AsGRPC()
 	}
+	sr.Permissions = permissions
 
 	systemRole, err := svc.ToProto(sr)
 	if err != nil {
@@ -284,6 +308,14 @@ func (h *Handlers) UpdateSystemRole(ctx context.Context, in *servicePb.UpdateSys
 
 	logger := log.WithContext(ctx).WithTenantID(in.SystemRole.TenantId).WithCustom("id", in.Id)
 
+	if len(in.OnlyFields) == 0 || strUtil.Strings(in.OnlyFields).Has("permissions") {
+		if err := resolveSystemRolePermissions(in.SystemRole); err != nil {
+			err := ErrBadRequest.New(err.Error())
+			logger.Warn(err.Error())
+			return nil, err.AsGRPC()
+		}
+	}
+
 	tx, err := h.db.NewTransaction(ctx)
 	if err != nil {
 		err := errors.Wrap(err, "error creating update system role transaction")
@@ -296,16 +328,46 @@ func (h *Handlers) UpdateSystemRole(ctx context.Context, in *servicePb.UpdateSys
 
 	sr := svc.FromProto(in.SystemRole)
 
-	if err := svc.Update(ctx, sr, in.OnlyFields); err != nil {
+	onlyFields := in.OnlyFields
+	permissions := sr.Permissions
+	if len(onlyFields) == 0 || strUtil.Strings(onlyFields).Has("permissions") {
+		// a clone stores its permissions as overrides of its base role, which an update of only some fields may not include
+		baseRoleID := sr.BaseRoleID
+		if len(onlyFields) > 0 && !strUtil.Strings(onlyFields).Has("base_role_id") {
+			existing, err := svc.GetByID(ctx, sr.ID)
+			if err != nil {
+				svc.Rollback()
+				err := errors.Wrap(err, "error getting systemRole by id")
+				logger.Error(err)
+				return nil, err.AsGRPC()
+			}
+			baseRoleID = existing.BaseRoleID
+		}
+		if len(onlyFields) > 0 {
+			onlyFields = append(append([]string{}, onlyFields...), "granted_permissions", "revoked_permissions")
+		}
+		permissions, err = storePermissionOverrides(ctx, svc, sr, baseRoleID)
+		if err != nil {
+			svc.Rollback()
+			err := errors.Wrap(err, "error getting base system role")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+	}
+
+	if err := svc.Update(ctx, sr, onlyFields); err != nil {
 		err := errors.Wrap(err, "error updating systemRole")
 		logger.Error(err)
 		return nil, err.AsGRPC()
 	}
+	sr.Permissions = permissions
 
 	if len(in.OnlyFields) == 0 || strUtil.Strings(in.OnlyFields).Has("permissions") {
 		// TODO: eventually, probably want to check the tenantID on the deleted system_role to see if we can be more specific with our cache bust
-		if _, err := h.bouncerClient.BustAuthCache(ctx, &bouncerPb.BustAuthCacheRequest{}); err != nil {
-			err := errors.Wrap(err, "error busting auth data cache in bouncer")
+		outboxSvc := h.db.NewOutboxService()
+		outboxSvc.SetTransaction(tx)
+		if err := outboxSvc.Enqueue(ctx, db.NewBustAuthCacheMessage("", "")); err != nil {
+			err := errors.Wrap(err, "error enqueueing auth data cache bust")
 			logger.Error(err)
 			if err := svc.Rollback(); err != nil {
 				logger.Error(errors.Wrap(err, "error rolling back transaction"))
@@ -333,8 +395,20 @@ func (h *Handlers) UpdateSystemRole(ctx context.Context, in *servicePb.UpdateSys
 	return &servicePb.UpdateSystemRoleResponse{SystemRole: systemRole}, nil
 }
 
+// DeleteSystemRoleById soft deletes a system role nobody holds
 func (h *Handlers) DeleteSystemRoleById(ctx context.Context, in *servicePb.IdRequest) (*servicePb.Empty, error) {
-	logger := log.WithTenantID(in.TenantId).WithCustom("id", in.Id)
+	if _, err := h.DeleteSystemRole(ctx, &servicePb.DeleteSystemRoleRequest{Id: in.Id, TenantId: in.TenantId, UserId: in.UserId}); err != nil {
+		return nil, err
+	}
+	return &servicePb.Empty{}, nil
+}
+
+// DeleteSystemRole soft deletes a system role. It's refused while people or groups still hold the role unless a replacement role is
+// given, in which case everyone holding it gets the replacement in the same transaction and their bouncer caches are busted. Base
+// roles with active clones can't be deleted.
+func (h *Handlers) DeleteSystemRole(ctx context.Context, in *servicePb.DeleteSystemRoleRequest) (*servicePb.DeleteSystemRoleResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId).WithCustom("id", in.Id).WithCustom("replacementRoleId", in.ReplacementRoleId)
 
 	if in.Id == "" {
 		err := ErrBadRequest.New("id can't be empty")
@@ -346,19 +420,62 @@ func (h *Handlers) DeleteSystemRoleById(ctx context.Context, in *servicePb.IdReq
 		in.UserId = db.DefaultTenantID
 	}
 
-	personSvc := h.db.NewPersonService()
-	numPeople, err := personSvc.CountPeopleByRoleId(ctx, in.TenantId, in.Id)
+	svc := h.db.NewSystemRoleService()
+
+	sr, err := svc.GetByID(ctx, in.Id)
+	if err == sql.ErrNoRows {
+		// nothing holds a role that doesn't exist, the soft delete reports it
+		sr, err = &models.SystemRole{ID: in.Id, TenantID: in.TenantId}, nil
+	}
 	if err != nil {
-		err := errors.Wrap(err, "error checking if role has users attached")
+		err := errors.Wrap(err, "error getting systemRole by id")
 		logger.Error(err)
 		return nil, err.AsGRPC()
 	}
+	if in.TenantId != "" && sr.TenantID != in.TenantId && sr.TenantID != db.DefaultTenantID {
+		err := errors.New("system role not found").WithCode(codes.NotFound)
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
 
-	if numPeople > 0 {
-		err := errors.New("cannot delete role, users are currently attached").WithCode(codes.FailedPrecondition)
+	numClones, err := svc.CountClones(ctx, sr.ID)
+	if err != nil {
+		err := errors.Wrap(err, "error checking if role has clones")
 		logger.Error(err)
 		return nil, err.AsGRPC()
 	}
+	if numClones > 0 {
+		err := errors.New(fmt.Sprintf("cannot delete role, it is the base role of %d roles", numClones)).WithCode(codes.FailedPrecondition)
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	usageTenantID := systemRoleUsageTenantID(sr)
+
+	if in.ReplacementRoleId != "" {
+		replacement, err := svc.GetByID(ctx, in.ReplacementRoleId)
+		if err != nil && err != sql.ErrNoRows {
+			err := errors.Wrap(err, "error getting replacement system role")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		problem := ""
+		switch {
+		case replacement == nil:
+			problem = "replacement role not found"
+		case replacement.ID == sr.ID:
+			problem = "a role can't replace itself"
+		case replacement.Status != "active":
+			problem = "replacement role isn't active"
+		case replacement.TenantID != sr.TenantID && replacement.TenantID != db.DefaultTenantID:
+			problem = "replacement role belongs to another tenant"
+		}
+		if problem != "" {
+			err := ErrBadRequest.New(problem)
+			logger.Warn(err.Error())
+			return nil, err.AsGRPC()
+		}
+	}
 
 	tx, err := h.db.NewTransaction(ctx)
 	if err != nil {
@@ -367,18 +484,91 @@ func (h *Handlers) DeleteSystemRoleById(ctx context.Context, in *servicePb.IdReq
 		return nil, err.AsGRPC()
 	}
 
-	svc := h.db.NewSystemRoleService()
 	svc.SetTransaction(tx)
+	personSvc := h.db.NewPersonService()
+	personSvc.SetTransaction(tx)
+	groupSvc := h.db.NewGroupService()
+	groupSvc.SetTransaction(tx)
+	outboxSvc := h.db.NewOutboxService()
+	outboxSvc.SetTransaction(tx)
+
+	// Lock the role before checking who holds it, so nobody is given it between the check and the delete
+	if err := svc.LockByID(ctx, sr.ID); err != nil {
+		svc.Rollback()
+		err := errors.Wrap(err, "error locking system role")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	if in.ReplacementRoleId == "" {
+		numPeople, err := personSvc.CountPeopleByRoleId(ctx, usageTenantID, sr.ID)
+		if err != nil {
+			svc.Rollback()
+			err := errors.Wrap(err, "error checking if role has users attached")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		groups, err := groupSvc.GetGroupsByRoleID(ctx, usageTenantID, sr.ID)
+		if err != nil {
+			svc.Rollback()
+			err := errors.Wrap(err, "error checking if role has groups attached")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		if numPeople > 0 || len(groups) > 0 {
+			svc.Rollback()
+			err := errors.New(fmt.Sprintf("cannot delete role, %d users and %d groups are currently attached, give a replacement role to reassign them", numPeople, len(groups))).WithCode(codes.FailedPrecondition)
+			logger.Warn(err.Error())
+			return nil, err.AsGRPC()
+		}
+	}
+
+	res := &servicePb.DeleteSystemRoleResponse{}
+	msgs := []*db.OutboxMessage{}
+
+	if in.ReplacementRoleId != "" {
+		people, err := personSvc.ReplaceRoleID(ctx, usageTenantID, sr.ID, in.ReplacementRoleId, in.UserId)
+		if err != nil {
+			svc.Rollback()
+			err := errors.Wrap(err, "error reassigning people to replacement role")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		groups, err := groupSvc.ReplaceRoleID(ctx, usageTenantID, sr.ID, in.ReplacementRoleId, in.UserId)
+		if err != nil {
+			svc.Rollback()
+			err := errors.Wrap(err, "error reassigning groups to replacement role")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		res.ReassignedPeople = int64(len(people))
+		res.ReassignedGroups = int64(len(groups))
+
+		for _, p := range people {
+			msgs = append(msgs, db.NewBustAuthCacheMessage(p.TenantID, p.ID))
+		}
+		// the members of a group get its roles, so a group's whole tenant is busted
+		bustedTenants := map[string]bool{}
+		for _, g := range groups {
+			if !bustedTenants[g.TenantID] {
+				bustedTenants[g.TenantID] = true
+				msgs = append(msgs, db.NewBustAuthCacheMessage(g.TenantID, ""))
+			}
+		}
+	}
 
-	if err := svc.SoftDeleteByID(ctx, in.Id, in.TenantId, in.UserId); err != nil {
+	if err := svc.SoftDeleteByID(ctx, sr.ID, sr.TenantID, in.UserId); err != nil {
+		svc.Rollback()
 		err := errors.Wrap(err, "error deleting systemRole by id")
 		logger.Error(err)
 		return nil, err.AsGRPC()
 	}
 
-	// TODO: eventually, probably want to check the tenantID on the deleted system_role to see if we can be more specific with our cache bust
-	if _, err := h.bouncerClient.BustAuthCache(ctx, &bouncerPb.BustAuthCacheRequest{}); err != nil {
-		err := errors.Wrap(err, "error busting auth data cache in bouncer")
+	if len(msgs) == 0 {
+		msgs = append(msgs, db.NewBustAuthCacheMessage(usageTenantID, ""))
+	}
+	if err := outboxSvc.Enqueue(ctx, msgs...); err != nil {
+		err := errors.Wrap(err, "error enqueueing auth data cache bust")
 		logger.Error(err)
 		if err := svc.Rollback(); err != nil {
 			logger.Error(errors.Wrap(err, "error rolling back transaction"))
@@ -395,5 +585,125 @@ func (h *Handlers) DeleteSystemRoleById(ctx context.Context, in *servicePb.IdReq
 		return nil, err.AsGRPC()
 	}
 
-	return &servicePb.Empty{}, nil
+	if in.ReplacementRoleId != "" {
+		logger.WithCustom("people", res.ReassignedPeople).WithCustom("groups", res.ReassignedGroups).Info("reassigned deleted system role")
+	}
+
+	return res, nil
+}
+
+const (
+	defaultSystemRoleUsageLimit = 100
+	maxSystemRoleUsageLimit     = 1000
+)
+
+// GetSystemRoleUsage lists who holds each system role: the people with the role, up to limit of them, the groups whose members get
+// it and how many roles were cloned from it
+func (h *Handlers) GetSystemRoleUsage(ctx context.Context, in *servicePb.GetSystemRoleUsageRequest) (*servicePb.GetSystemRoleUsageResponse, error) {
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
+
+	if len(in.SystemRoleIds) == 0 {
+		err := ErrBadRequest.New("systemRoleIds can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	limit := int(in.Limit)
+	if limit <= 0 {
+		limit = defaultSystemRoleUsageLimit
+	}
+	if limit > maxSystemRoleUsageLimit {
+		limit = maxSystemRoleUsageLimit
+	}
+
+	svc := h.db.NewSystemRoleService()
+	personSvc := h.db.NewPersonService()
+	groupSvc := h.db.NewGroupService()
+
+	res := &servicePb.GetSystemRoleUsageResponse{}
+	for _, id := range in.SystemRoleIds {
+		sr, err := h.tenantSystemRole(ctx, in.TenantId, id)
+		if err == sql.ErrNoRows {
+			err := errors.New(fmt.Sprintf("system role %s not found", id)).WithCode(codes.NotFound)
+			logger.Warn(err.Error())
+			return nil, err.AsGRPC()
+		}
+		if err != nil {
+			err := errors.Wrap(err, "error getting systemRole by id")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		usageTenantID := systemRoleUsageTenantID(sr)
+
+		usage := &orchardPb.SystemRoleUsage{
+			SystemRoleId: sr.ID,
+			Name:         sr.Name,
+		}
+
+		usage.PersonCount, err = personSvc.CountPeopleByRoleId(ctx, usageTenantID, sr.ID)
+		if err != nil {
+			err := errors.Wrap(err, "error counting people by role id")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+
+		people, err := personSvc.GetPeopleByRoleId(ctx, usageTenantID, sr.ID, limit, 0)
+		if err != nil {
+			err := errors.Wrap(err, "error getting people by role id")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		for _, p := range people {
+			person, err := personSvc.ToProto(p)
+			if err != nil {
+				err := errors.Wrap(err, "error converting person db model to proto")
+				logger.Error(err)
+				return nil, err.AsGRPC()
+			}
+			usage.People = append(usage.People, person)
+		}
+
+		groups, err := groupSvc.GetGroupsByRoleID(ctx, usageTenantID, sr.ID)
+		if err != nil {
+			err := errors.Wrap(err, "error getting groups by role id")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		for _, g := range groups {
+			usage.GroupIds = append(usage.GroupIds, g.ID)
+		}
+
+		usage.CloneCount, err = svc.CountClones(ctx, sr.ID)
+		if err != nil {
+			err := errors.Wrap(err, "error counting system role clones")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+
+		usage.InUse = usage.PersonCount > 0 || len(usage.GroupIds) > 0 || usage.CloneCount > 0
+		res.Usages = append(res.Usages, usage)
+	}
+
+	return res, nil
+}
+
+// tenantSystemRole gets a system role the tenant can see, its own or one of the default tenant's. It returns sql.ErrNoRows for
+// another tenant's role.
+func (h *Handlers) tenantSystemRole(ctx context.Context, tenantID, id string) (*models.SystemRole, error) {
+	sr, err := h.db.NewSystemRoleService().GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if tenantID != "" && sr.TenantID != tenantID && sr.TenantID != db.DefaultTenantID {
+		return nil, sql.ErrNoRows
+	}
+	return sr, nil
+}
+
+// systemRoleUsageTenantID is the tenant people holding a role can be in, anyone in any tenant can hold a default tenant role
+func systemRoleUsageTenantID(sr *models.SystemRole) string {
+	if sr.TenantID == db.DefaultTenantID {
+		return ""
+	}
+	return sr.TenantID
 }
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/loupe-co/go-common/fixtures"
+	"github.com/loupe-co/orchard/internal/db"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+func TestDeleteSystemRoleReassigns(t *testing.T) {
+	h, fakes, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	// a role to delete, its replacement, an inactive role and two people who hold the deleted role
+	data, err := insertTestData(store, "system_role_delete", "TestDeleteSystemRoleReassigns", "store")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	deleted, replacement := data.SystemRoles[0], data.SystemRoles[1]
+	holder, both := data.People[0], data.People[1]
+
+	groupSvc := store.NewGroupService()
+	group, err := groupSvc.GetByID(ctx, seedEMEAAEsID, db.DefaultTenantID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	group.RoleIds = types.StringArray{deleted.ID}
+	if err := groupSvc.Update(ctx, group, []string{"role_ids"}); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	testData, _, _, err := jsonparser.Get(fixtures.Data["system_role_delete"], "TestDeleteSystemRoleReassigns", "held_request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	heldReq := &servicePb.DeleteSystemRoleRequest{}
+	if err := json.Unmarshal(testData, heldReq); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if _, err := h.DeleteSystemRole(ctx, heldReq); err == nil {
+		t.Log("expected deleting a role people still hold without a replacement to fail")
+		t.Fail()
+		return
+	}
+
+	// an unknown replacement, the role itself and an inactive replacement
+	testData, _, _, err = jsonparser.Get(fixtures.Data["system_role_delete"], "TestDeleteSystemRoleReassigns", "bad_requests")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	badRequests := []*servicePb.DeleteSystemRoleRequest{}
+	if err := json.Unmarshal(testData, &badRequests); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	for _, badReq := range badRequests {
+		_, err := h.DeleteSystemRole(ctx, badReq)
+		if err == nil {
+			t.Log("expected replacement", badReq.ReplacementRoleId, "to be a bad request, but got nil error")
+			t.Fail()
+			return
+		}
+		if !strings.Contains(err.Error(), "Bad Request") {
+			t.Log("expected error to contain 'Bad Request', but got", err)
+			t.Fail()
+			return
+		}
+	}
+
+	testData, _, _, err = jsonparser.Get(fixtures.Data["system_role_delete"], "TestDeleteSystemRoleReassigns", "request")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	req := &servicePb.DeleteSystemRoleRequest{}
+	if err := json.Unmarshal(testData, req); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	fakes.Reset()
+	res, err := h.DeleteSystemRole(ctx, req)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if res.ReassignedPeople != 2 || res.ReassignedGroups != 1 {
+		t.Logf("expected 2 people and 1 group reassigned, but got %d and %d", res.ReassignedPeople, res.ReassignedGroups)
+		t.Fail()
+		return
+	}
+
+	people, err := store.NewPersonService().GetByIDs(ctx, db.DefaultTenantID, holder.ID, both.ID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	for _, p := range people {
+		if len(p.RoleIds) != 1 || p.RoleIds[0] != replacement.ID {
+			t.Logf("expected %s to hold only the replacement role once, but got %v", p.Name.String, p.RoleIds)
+			t.Fail()
+			return
+		}
+	}
+	group, err = groupSvc.GetByID(ctx, seedEMEAAEsID, db.DefaultTenantID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(group.RoleIds) != 1 || group.RoleIds[0] != replacement.ID {
+		t.Log("expected the group to hold the replacement role, but got", group.RoleIds)
+		t.Fail()
+		return
+	}
+
+	sr, err := store.NewSystemRoleService().GetByID(ctx, deleted.ID)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if sr.Status != "inactive" {
+		t.Log("expected the role to be soft deleted, but got", sr.Status)
+		t.Fail()
+		return
+	}
+
+	if _, err := h.DispatchOutbox(ctx); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	busted := map[string]bool{}
+	for _, id := range fakes.Bouncer.BustedUserIDs(db.DefaultTenantID) {
+		busted[id] = true
+	}
+	if !busted[holder.ID] || !busted[both.ID] || !busted[""] {
+		t.Log("expected both people and, for the group, the whole tenant to be busted, but got", busted)
+		t.Fail()
+		return
+	}
+
+	rawResult, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if err := fixtures.WriteTestResult("../../fixtures/results/TestDeleteSystemRoleReassigns.json", rawResult); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+}
+
+func TestDeleteSystemRoleRefusesBaseRoles(t *testing.T) {
+	h, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	// a base role and its clone
+	data, err := insertTestData(store, "system_role_delete", "TestDeleteSystemRoleRefusesBaseRoles", "store")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	base, clone := data.SystemRoles[0], data.SystemRoles[1]
+
+	if _, err := h.DeleteSystemRole(ctx, &servicePb.DeleteSystemRoleRequest{TenantId: db.DefaultTenantID, Id: base.ID}); err == nil {
+		t.Log("expected a base role with an active clone not to be deleted")
+		t.Fail()
+		return
+	}
+
+	// Nobody holds the clone, so it's deleted without a replacement and then its base role can go too
+	if _, err := h.DeleteSystemRole(ctx, &servicePb.DeleteSystemRoleRequest{TenantId: db.DefaultTenantID, Id: clone.ID}); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if _, err := h.DeleteSystemRole(ctx, &servicePb.DeleteSystemRoleRequest{TenantId: db.DefaultTenantID, Id: base.ID}); err != nil {
+		t.Log("expected the base role to be deleted once its clone is gone, but got", err)
+		t.Fail()
+		return
+	}
+}
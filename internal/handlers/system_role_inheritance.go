@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/go-loupe-logger/log"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/models"
+	"github.com/loupe-co/orchard/internal/permcatalog"
+	authPb "github.com/loupe-co/protos/src/common/auth"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+	"github.com/volatiletech/null/v8"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+// storePermissionOverrides turns the permissions wanted for a role cloned from baseRoleID into the bits it grants and revokes
+// relative to its base role, which is all a clone stores. Roles without a base role keep their permissions as they are.
+// It returns the wanted permissions, to put back on sr once it's saved.
+func storePermissionOverrides(ctx context.Context, svc db.SystemRoleRepository, sr *models.SystemRole, baseRoleID null.String) (types.Int64Array, error) {
+	want := sr.Permissions
+	if !baseRoleID.Valid || baseRoleID.String == "" {
+		sr.GrantedPermissions, sr.RevokedPermissions = types.Int64Array{}, types.Int64Array{}
+		return want, nil
+	}
+
+	base, err := svc.GetByID(ctx, baseRoleID.String)
+	if err != nil {
+		return nil, err
+	}
+
+	sr.GrantedPermissions, sr.RevokedPermissions = db.PermissionOverrides(base.Permissions, want)
+	sr.Permissions = types.Int64Array{}
+	return want, nil
+}
+
+// GetSystemRoleDiffs shows how each of a tenant's cloned system roles differs from its base role: per permission set the bits the
+// base role has, the bits the clone grants on top and revokes from them, and what it ends up with
+func (h *Handlers) GetSystemRoleDiffs(ctx context.Context, in *servicePb.GetSystemRoleDiffsRequest) (*servicePb.GetSystemRoleDiffsResponse, error) {
+	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
+
+	if in.TenantId == "" {
+		err := ErrBadRequest.New("tenantId can't be empty")
+		logger.Warn(err.Error())
+		return nil, err.AsGRPC()
+	}
+
+	svc := h.db.NewSystemRoleService()
+
+	clones, err := svc.GetClones(ctx, in.TenantId)
+	if err != nil {
+		err := errors.Wrap(err, "error getting cloned system roles")
+		logger.Error(err)
+		return nil, err.AsGRPC()
+	}
+
+	onlyIDs := map[string]bool{}
+	for _, id := range in.SystemRoleIds {
+		onlyIDs[id] = true
+	}
+	baseIDs := []string{}
+	seen := map[string]bool{}
+	filtered := []*models.SystemRole{}
+	for _, sr := range clones {
+		if len(onlyIDs) > 0 && !onlyIDs[sr.ID] {
+			continue
+		}
+		filtered = append(filtered, sr)
+		if !seen[sr.BaseRoleID.String] {
+			seen[sr.BaseRoleID.String] = true
+			baseIDs = append(baseIDs, sr.BaseRoleID.String)
+		}
+	}
+
+	bases := map[string]*models.SystemRole{}
+	if len(baseIDs) > 0 {
+		baseRoles, err := svc.GetByIDs(ctx, baseIDs...)
+		if err != nil {
+			err := errors.Wrap(err, "error getting base system roles")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		for _, base := range baseRoles {
+			bases[base.ID] = base
+		}
+	}
+
+	res := &servicePb.GetSystemRoleDiffsResponse{}
+	for _, sr := range filtered {
+		base, ok := bases[sr.BaseRoleID.String]
+		if !ok {
+			logger.WithCustom("systemRoleId", sr.ID).WithCustom("baseRoleId", sr.BaseRoleID.String).Warn("system role's base role not found")
+			continue
+		}
+
+		systemRole, err := svc.ToProto(sr)
+		if err != nil {
+			err := errors.Wrap(err, "error converting systemRole db model to proto")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+		baseRole, err := svc.ToProto(base)
+		if err != nil {
+			err := errors.Wrap(err, "error converting systemRole db model to proto")
+			logger.Error(err)
+			return nil, err.AsGRPC()
+		}
+
+		res.Diffs = append(res.Diffs, &orchardPb.SystemRoleDiff{
+			SystemRole:   systemRole,
+			BaseRole:     baseRole,
+			HasOverrides: db.HasPermissionOverrides(sr),
+			Sets:         permissionSetDiffs(base.Permissions, sr),
+		})
+	}
+
+	return res, nil
+}
+
+// permissionSetDiffs compares a clone to its base role's permissions set by set, sets neither of them has any bits in are left out
+func permissionSetDiffs(base []int64, sr *models.SystemRole) []*orchardPb.PermissionSetDiff {
+	at := func(bits []int64, i int) int64 {
+		if i < len(bits) {
+			return bits[i]
+		}
+		return 0
+	}
+	n := len(base)
+	for _, bits := range [][]int64{sr.Permissions, sr.GrantedPermissions, sr.RevokedPermissions} {
+		if len(bits) > n {
+			n = len(bits)
+		}
+	}
+
+	diffs := []*orchardPb.PermissionSetDiff{}
+	for i := 0; i < n; i++ {
+		set := authPb.PermissionSet(i)
+		diff := &orchardPb.PermissionSetDiff{
+			PermissionSet: set,
+			Base:          at(base, i),
+			Granted:       at(sr.GrantedPermissions, i),
+			Revoked:       at(sr.RevokedPermissions, i),
+			Effective:     at(sr.Permissions, i),
+		}
+		if diff.Base == 0 && diff.Granted == 0 && diff.Revoked == 0 && diff.Effective == 0 {
+			continue
+		}
+		diff.NamedGranted = permcatalog.Named(set, diff.Granted)
+		diff.NamedRevoked = permcatalog.Named(set, diff.Revoked)
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/loupe-co/go-common/fixtures"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/permcatalog"
+	authPb "github.com/loupe-co/protos/src/common/auth"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+func bitsAt(bits []int64, i int) int64 {
+	if i < len(bits) {
+		return bits[i]
+	}
+	return 0
+}
+
+// groupPermissionSets is the named group permissions stored the way system roles store them, nil without names
+func groupPermissionSets(names []string) (types.Int64Array, error) {
+	if names == nil {
+		return nil, nil
+	}
+	bits, err := permcatalog.Encode(authPb.PermissionSet_Group, names)
+	if err != nil {
+		return nil, err
+	}
+	return permissionSets(authPb.PermissionSet_Group, bits), nil
+}
+
+// getCreateSystemRoleTestRequests builds the CreateSystemRole requests at keys of fixtures/system_role_inheritance.json, the
+// roles are active ICs with their group permissions given by name
+func getCreateSystemRoleTestRequests(keys ...string) ([]*servicePb.CreateSystemRoleRequest, error) {
+	raw, _, _, err := jsonparser.Get(fixtures.Data["system_role_inheritance"], keys...)
+	if err != nil {
+		return nil, err
+	}
+	testData := []struct {
+		TenantID         string                `json:"tenant_id"`
+		SystemRole       *orchardPb.SystemRole `json:"system_role"`
+		GroupPermissions []string              `json:"group_permissions"`
+	}{}
+	if err := json.Unmarshal(raw, &testData); err != nil {
+		return nil, err
+	}
+
+	requests := []*servicePb.CreateSystemRoleRequest{}
+	for _, data := range testData {
+		permissions, err := groupPermissionSets(data.GroupPermissions)
+		if err != nil {
+			return nil, err
+		}
+		data.SystemRole.Type, data.SystemRole.Status, data.SystemRole.Permissions = orchardPb.SystemRoleType_IC, orchardPb.BasicStatus_Active, permissions
+		requests = append(requests, &servicePb.CreateSystemRoleRequest{TenantId: data.TenantID, SystemRole: data.SystemRole})
+	}
+	return requests, nil
+}
+
+func TestPermissionOverridesRoundTrip(t *testing.T) {
+	testData, _, _, err := jsonparser.Get(fixtures.Data["system_role_inheritance"], "TestPermissionOverridesRoundTrip")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	cases := []struct {
+		Name    string   `json:"name"`
+		Base    []string `json:"base"`
+		Want    []string `json:"want"`
+		Granted []string `json:"granted"`
+		Revoked []string `json:"revoked"`
+	}{}
+	if err := json.Unmarshal(testData, &cases); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	group := int(authPb.PermissionSet_Group)
+
+	for _, c := range cases {
+		base, err := groupPermissionSets(c.Base)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		want, err := groupPermissionSets(c.Want)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		wantGranted, err := permcatalog.Encode(authPb.PermissionSet_Group, c.Granted)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		wantRevoked, err := permcatalog.Encode(authPb.PermissionSet_Group, c.Revoked)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+
+		granted, revoked := db.PermissionOverrides(base, want)
+		if bitsAt(granted, group) != wantGranted || bitsAt(revoked, group) != wantRevoked {
+			t.Logf("%s: expected granted %#x and revoked %#x, but got %v and %v", c.Name, wantGranted, wantRevoked, granted, revoked)
+			t.Fail()
+			return
+		}
+		if wantGranted == 0 && wantRevoked == 0 && (len(granted) != 0 || len(revoked) != 0) {
+			t.Logf("%s: expected a role without overrides to store empty arrays, but got %v and %v", c.Name, granted, revoked)
+			t.Fail()
+			return
+		}
+		if effective := db.InheritPermissions(base, granted, revoked); bitsAt(effective, group) != bitsAt(want, group) {
+			t.Logf("%s: expected the overrides to inherit back to %v, but got %v", c.Name, want, effective)
+			t.Fail()
+			return
+		}
+	}
+}
+
+func TestCreateClonedSystemRoleStoresOverrides(t *testing.T) {
+	h, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	// a clone of a base role with Access and Read that asks for only Access
+	if _, err := insertTestData(store, "system_role_inheritance", "TestCreateClonedSystemRoleStoresOverrides", "store"); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	requests, err := getCreateSystemRoleTestRequests("TestCreateClonedSystemRoleStoresOverrides", "requests")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	access := groupPermissionBits(authPb.Permission_Access)
+	read := groupPermissionBits(authPb.Permission_Read)
+
+	res, err := h.CreateSystemRole(ctx, requests[0])
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if bitsAt(res.SystemRole.Permissions, int(authPb.PermissionSet_Group)) != access {
+		t.Log("expected the created role to come back with the permissions asked for, but got", res.SystemRole.Permissions)
+		t.Fail()
+		return
+	}
+
+	stored, err := store.NewSystemRoleService().GetByID(ctx, res.SystemRole.Id)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(stored.GrantedPermissions) != 0 || !reflect.DeepEqual([]int64(stored.RevokedPermissions), []int64(permissionSets(authPb.PermissionSet_Group, read))) {
+		t.Log("expected the clone to store only the revoked Read, but got granted", stored.GrantedPermissions, "revoked", stored.RevokedPermissions)
+		t.Fail()
+		return
+	}
+	if bitsAt(stored.Permissions, int(authPb.PermissionSet_Group)) != access {
+		t.Log("expected the clone to read back as Access, but got", stored.Permissions)
+		t.Fail()
+		return
+	}
+}
+
+func TestClonedSystemRoleFollowsItsBaseRole(t *testing.T) {
+	h, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	// a clone that asks for the same Access its base role has
+	data, err := insertTestData(store, "system_role_inheritance", "TestClonedSystemRoleFollowsItsBaseRole", "store")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	requests, err := getCreateSystemRoleTestRequests("TestClonedSystemRoleFollowsItsBaseRole", "requests")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	access := groupPermissionBits(authPb.Permission_Access)
+	read := groupPermissionBits(authPb.Permission_Read)
+
+	res, err := h.CreateSystemRole(ctx, requests[0])
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	// A clone without overrides picks up what's added to its base role
+	base := &data.SystemRoles[0].SystemRole
+	base.Permissions = permissionSets(authPb.PermissionSet_Group, access|read)
+	if err := store.NewSystemRoleService().Update(ctx, base, []string{"permissions"}); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	clone, err := store.NewSystemRoleService().GetByID(ctx, res.SystemRole.Id)
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if bitsAt(clone.Permissions, int(authPb.PermissionSet_Group)) != access|read {
+		t.Log("expected the clone to inherit the base role's new Read, but got", clone.Permissions)
+		t.Fail()
+		return
+	}
+	if db.HasPermissionOverrides(clone) {
+		t.Log("expected the clone not to have overrides")
+		t.Fail()
+		return
+	}
+}
+
+func TestGetSystemRoleDiffs(t *testing.T) {
+	h, _, store, err := setupMemoryServer()
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	ctx := context.Background()
+
+	// clones of a base role with Access and Read, one that revokes Read and one the same as its base
+	data, err := insertTestData(store, "system_role_inheritance", "TestGetSystemRoleDiffs", "store")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	base := data.SystemRoles[0]
+	requests, err := getCreateSystemRoleTestRequests("TestGetSystemRoleDiffs", "requests")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	access := groupPermissionBits(authPb.Permission_Access)
+	read := groupPermissionBits(authPb.Permission_Read)
+
+	ids := []string{}
+	for _, req := range requests {
+		res, err := h.CreateSystemRole(ctx, req)
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+			return
+		}
+		ids = append(ids, res.SystemRole.Id)
+	}
+	revokedID, sameID := ids[0], ids[1]
+
+	res, err := h.GetSystemRoleDiffs(ctx, &servicePb.GetSystemRoleDiffsRequest{TenantId: db.DefaultTenantID})
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	diffs := map[string]*orchardPb.SystemRoleDiff{}
+	for _, diff := range res.Diffs {
+		diffs[diff.SystemRole.Id] = diff
+	}
+
+	revoked, ok := diffs[revokedID]
+	if !ok {
+		t.Log("expected a diff for the clone that revokes Read")
+		t.Fail()
+		return
+	}
+	if !revoked.HasOverrides || revoked.BaseRole.Id != base.ID || len(revoked.Sets) != 1 {
+		t.Log("expected one overridden set against", base.ID, "but got", revoked)
+		t.Fail()
+		return
+	}
+	set := revoked.Sets[0]
+	if set.PermissionSet != authPb.PermissionSet_Group || set.Base != access|read || set.Granted != 0 || set.Revoked != read || set.Effective != access {
+		t.Log("unexpected group set diff", set)
+		t.Fail()
+		return
+	}
+	if !reflect.DeepEqual(set.NamedRevoked.Names, []string{"Read"}) || len(set.NamedGranted.Names) != 0 {
+		t.Log("expected Read to be named as revoked, but got", set.NamedRevoked, set.NamedGranted)
+		t.Fail()
+		return
+	}
+
+	if same, ok := diffs[sameID]; !ok || same.HasOverrides {
+		t.Log("expected the clone that matches its base role to have no overrides, but got", same)
+		t.Fail()
+		return
+	}
+
+	rawResult, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	if err := fixtures.WriteTestResult("../../fixtures/results/TestGetSystemRoleDiffs.json", rawResult); err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+
+	res, err = h.GetSystemRoleDiffs(ctx, &servicePb.GetSystemRoleDiffsRequest{TenantId: db.DefaultTenantID, SystemRoleIds: []string{sameID}})
+	if err != nil {
+		t.Log(err)
+		t.Fail()
+		return
+	}
+	if len(res.Diffs) != 1 || res.Diffs[0].SystemRole.Id != sameID {
+		t.Logf("expected only the asked for role, but got %d diffs", len(res.Diffs))
+		t.Fail()
+		return
+	}
+}
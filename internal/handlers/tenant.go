@@ -35,6 +35,7 @@ func (h *Handlers) GetGroupSyncSettings(ctx context.Context, in *servicePb.GetGr
 }
 
 func (h *Handlers) UpdateGroupSyncState(ctx context.Context, in *servicePb.UpdateGroupSyncStateRequest) (*servicePb.UpdateGroupSyncStateResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
 
 	svc := h.db.NewTenantService()
@@ -50,6 +51,7 @@ func (h *Handlers) UpdateGroupSyncState(ctx context.Context, in *servicePb.Updat
 }
 
 func (h *Handlers) UpdateGroupSyncMetadata(ctx context.Context, in *servicePb.UpdateGroupSyncMetadataRequest) (*servicePb.UpdateGroupSyncMetadataResponse, error) {
+	defer h.hierarchyCache.Invalidate(ctx, in.TenantId)
 	logger := log.WithContext(ctx).WithTenantID(in.TenantId)
 
 	svc := h.db.NewTenantService()
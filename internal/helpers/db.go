@@ -16,7 +16,7 @@ var (
 )
 
 // Helper function to create transaction
-func CreateTransaction(db *db.DB, logger *log.LogChain, spanCtx context.Context, svc *db.GroupService, txName string) error {
+func CreateTransaction(db db.Store, logger *log.LogChain, spanCtx context.Context, svc db.Transactional, txName string) error {
 	tx, err := db.NewTransaction(spanCtx)
 	if err != nil {
 		err := errors.Wrap(err, fmt.Sprintf("error starting transaction for %s", txName))
@@ -28,7 +28,7 @@ func CreateTransaction(db *db.DB, logger *log.LogChain, spanCtx context.Context,
 }
 
 // Helper function to log error and rollback the transaction based on rollback flag
-func ErrorHandler(logger *log.LogChain, svc *db.GroupService, err error, methodName string, rollback bool) error {
+func ErrorHandler(logger *log.LogChain, svc db.Transactional, err error, methodName string, rollback bool) error {
 	defer func() {
 		if r := recover(); r != nil {
 			fmt.Println("stacktrace from panic:", string(debug.Stack()), "actual panic error", r)
@@ -43,7 +43,7 @@ func ErrorHandler(logger *log.LogChain, svc *db.GroupService, err error, methodN
 }
 
 // Helper function to commit transaction
-func CommitTransaction(logger *log.LogChain, svc *db.GroupService, methodName string) error {
+func CommitTransaction(logger *log.LogChain, svc db.Transactional, methodName string) error {
 	if err := svc.Commit(); err != nil {
 		svc.Rollback()
 		nerr := errors.Wrap(err, fmt.Sprintf("error commiting %s", methodName))
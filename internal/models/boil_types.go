@@ -111,9 +111,10 @@ func AllPersonStatus() []string {
 
 // Enum values for PersonType
 const (
-	PersonTypeInternal string = "internal"
-	PersonTypeManager  string = "manager"
-	PersonTypeIc       string = "ic"
+	PersonTypeInternal    string = "internal"
+	PersonTypeManager     string = "manager"
+	PersonTypeIc          string = "ic"
+	PersonTypePlayerCoach string = "player_coach"
 )
 
 func AllPersonType() []string {
@@ -121,6 +122,7 @@ func AllPersonType() []string {
 		PersonTypeInternal,
 		PersonTypeManager,
 		PersonTypeIc,
+		PersonTypePlayerCoach,
 	}
 }
 
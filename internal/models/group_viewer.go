@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/friendsofgo/errors"
+	"github.com/volatiletech/null/v8"
 	"github.com/volatiletech/sqlboiler/v4/boil"
 	"github.com/volatiletech/sqlboiler/v4/queries"
 	"github.com/volatiletech/sqlboiler/v4/queries/qm"
@@ -23,14 +24,18 @@ import (
 
 // GroupViewer is an object representing the database table.
 type GroupViewer struct {
-	GroupID     string    `boil:"group_id" json:"group_id" toml:"group_id" yaml:"group_id"`
-	PersonID    string    `boil:"person_id" json:"person_id" toml:"person_id" yaml:"person_id"`
-	TenantID    string    `boil:"tenant_id" json:"tenant_id" toml:"tenant_id" yaml:"tenant_id"`
-	Permissions int64     `boil:"permissions" json:"permissions" toml:"permissions" yaml:"permissions"`
-	CreatedBy   string    `boil:"created_by" json:"created_by" toml:"created_by" yaml:"created_by"`
-	CreatedAt   time.Time `boil:"created_at" json:"created_at" toml:"created_at" yaml:"created_at"`
-	UpdatedBy   string    `boil:"updated_by" json:"updated_by" toml:"updated_by" yaml:"updated_by"`
-	UpdatedAt   time.Time `boil:"updated_at" json:"updated_at" toml:"updated_at" yaml:"updated_at"`
+	GroupID     string      `boil:"group_id" json:"group_id" toml:"group_id" yaml:"group_id"`
+	PersonID    string      `boil:"person_id" json:"person_id" toml:"person_id" yaml:"person_id"`
+	TenantID    string      `boil:"tenant_id" json:"tenant_id" toml:"tenant_id" yaml:"tenant_id"`
+	Permissions int64       `boil:"permissions" json:"permissions" toml:"permissions" yaml:"permissions"`
+	CreatedBy   string      `boil:"created_by" json:"created_by" toml:"created_by" yaml:"created_by"`
+	CreatedAt   time.Time   `boil:"created_at" json:"created_at" toml:"created_at" yaml:"created_at"`
+	UpdatedBy   string      `boil:"updated_by" json:"updated_by" toml:"updated_by" yaml:"updated_by"`
+	UpdatedAt   time.Time   `boil:"updated_at" json:"updated_at" toml:"updated_at" yaml:"updated_at"`
+	StartsAt    null.Time   `boil:"starts_at" json:"starts_at,omitempty" toml:"starts_at" yaml:"starts_at,omitempty"`
+	EndsAt      null.Time   `boil:"ends_at" json:"ends_at,omitempty" toml:"ends_at" yaml:"ends_at,omitempty"`
+	DelegatedBy null.String `boil:"delegated_by" json:"delegated_by,omitempty" toml:"delegated_by" yaml:"delegated_by,omitempty"`
+	ActivatedAt null.Time   `boil:"activated_at" json:"activated_at,omitempty" toml:"activated_at" yaml:"activated_at,omitempty"`
 
 	R *groupViewerR `boil:"-" json:"-" toml:"-" yaml:"-"`
 	L groupViewerL  `boil:"-" json:"-" toml:"-" yaml:"-"`
@@ -45,6 +50,10 @@ var GroupViewerColumns = struct {
 	CreatedAt   string
 	UpdatedBy   string
 	UpdatedAt   string
+	StartsAt    string
+	EndsAt      string
+	DelegatedBy string
+	ActivatedAt string
 }{
 	GroupID:     "group_id",
 	PersonID:    "person_id",
@@ -54,6 +63,10 @@ var GroupViewerColumns = struct {
 	CreatedAt:   "created_at",
 	UpdatedBy:   "updated_by",
 	UpdatedAt:   "updated_at",
+	StartsAt:    "starts_at",
+	EndsAt:      "ends_at",
+	DelegatedBy: "delegated_by",
+	ActivatedAt: "activated_at",
 }
 
 var GroupViewerTableColumns = struct {
@@ -65,6 +78,10 @@ var GroupViewerTableColumns = struct {
 	CreatedAt   string
 	UpdatedBy   string
 	UpdatedAt   string
+	StartsAt    string
+	EndsAt      string
+	DelegatedBy string
+	ActivatedAt string
 }{
 	GroupID:     "group_viewer.group_id",
 	PersonID:    "group_viewer.person_id",
@@ -74,6 +91,10 @@ var GroupViewerTableColumns = struct {
 	CreatedAt:   "group_viewer.created_at",
 	UpdatedBy:   "group_viewer.updated_by",
 	UpdatedAt:   "group_viewer.updated_at",
+	StartsAt:    "group_viewer.starts_at",
+	EndsAt:      "group_viewer.ends_at",
+	DelegatedBy: "group_viewer.delegated_by",
+	ActivatedAt: "group_viewer.activated_at",
 }
 
 // Generated where
@@ -110,6 +131,10 @@ var GroupViewerWhere = struct {
 	CreatedAt   whereHelpertime_Time
 	UpdatedBy   whereHelperstring
 	UpdatedAt   whereHelpertime_Time
+	StartsAt    whereHelpernull_Time
+	EndsAt      whereHelpernull_Time
+	DelegatedBy whereHelpernull_String
+	ActivatedAt whereHelpernull_Time
 }{
 	GroupID:     whereHelperstring{field: "\"group_viewer\".\"group_id\""},
 	PersonID:    whereHelperstring{field: "\"group_viewer\".\"person_id\""},
@@ -119,6 +144,10 @@ var GroupViewerWhere = struct {
 	CreatedAt:   whereHelpertime_Time{field: "\"group_viewer\".\"created_at\""},
 	UpdatedBy:   whereHelperstring{field: "\"group_viewer\".\"updated_by\""},
 	UpdatedAt:   whereHelpertime_Time{field: "\"group_viewer\".\"updated_at\""},
+	StartsAt:    whereHelpernull_Time{field: "\"group_viewer\".\"starts_at\""},
+	EndsAt:      whereHelpernull_Time{field: "\"group_viewer\".\"ends_at\""},
+	DelegatedBy: whereHelpernull_String{field: "\"group_viewer\".\"delegated_by\""},
+	ActivatedAt: whereHelpernull_Time{field: "\"group_viewer\".\"activated_at\""},
 }
 
 // GroupViewerRels is where relationship names are stored.
@@ -138,9 +167,9 @@ func (*groupViewerR) NewStruct() *groupViewerR {
 type groupViewerL struct{}
 
 var (
-	groupViewerAllColumns            = []string{"group_id", "person_id", "tenant_id", "permissions", "created_by", "created_at", "updated_by", "updated_at"}
+	groupViewerAllColumns            = []string{"group_id", "person_id", "tenant_id", "permissions", "created_by", "created_at", "updated_by", "updated_at", "starts_at", "ends_at", "delegated_by", "activated_at"}
 	groupViewerColumnsWithoutDefault = []string{"group_id", "person_id", "tenant_id"}
-	groupViewerColumnsWithDefault    = []string{"permissions", "created_by", "created_at", "updated_by", "updated_at"}
+	groupViewerColumnsWithDefault    = []string{"permissions", "created_by", "created_at", "updated_by", "updated_at", "starts_at", "ends_at", "delegated_by", "activated_at"}
 	groupViewerPrimaryKeyColumns     = []string{"tenant_id", "person_id", "group_id"}
 	groupViewerGeneratedColumns      = []string{}
 )
@@ -568,7 +568,7 @@ func testGroupViewersSelect(t *testing.T) {
 }
 
 var (
-	groupViewerDBTypes = map[string]string{`GroupID`: `text`, `PersonID`: `text`, `TenantID`: `uuid`, `Permissions`: `bigint`, `CreatedBy`: `text`, `CreatedAt`: `timestamp without time zone`, `UpdatedBy`: `text`, `UpdatedAt`: `timestamp without time zone`}
+	groupViewerDBTypes = map[string]string{`GroupID`: `text`, `PersonID`: `text`, `TenantID`: `uuid`, `Permissions`: `bigint`, `CreatedBy`: `text`, `CreatedAt`: `timestamp without time zone`, `UpdatedBy`: `text`, `UpdatedAt`: `timestamp without time zone`, `StartsAt`: `timestamp with time zone`, `EndsAt`: `timestamp with time zone`, `DelegatedBy`: `text`, `ActivatedAt`: `timestamp with time zone`}
 	_                  = bytes.MinRead
 )
 
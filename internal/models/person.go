@@ -48,6 +48,7 @@ type Person struct {
 	OutreachIsAdmin null.Bool         `boil:"outreach_is_admin" json:"outreach_is_admin,omitempty" toml:"outreach_is_admin" yaml:"outreach_is_admin,omitempty"`
 	OutreachGUID    null.String       `boil:"outreach_guid" json:"outreach_guid,omitempty" toml:"outreach_guid" yaml:"outreach_guid,omitempty"`
 	OutreachRoleID  null.String       `boil:"outreach_role_id" json:"outreach_role_id,omitempty" toml:"outreach_role_id" yaml:"outreach_role_id,omitempty"`
+	RoleIdsLocked   bool              `boil:"role_ids_locked" json:"role_ids_locked" toml:"role_ids_locked" yaml:"role_ids_locked"`
 
 	R *personR `boil:"-" json:"-" toml:"-" yaml:"-"`
 	L personL  `boil:"-" json:"-" toml:"-" yaml:"-"`
@@ -77,6 +78,7 @@ var PersonColumns = struct {
 	OutreachIsAdmin string
 	OutreachGUID    string
 	OutreachRoleID  string
+	RoleIdsLocked   string
 }{
 	ID:              "id",
 	TenantID:        "tenant_id",
@@ -101,6 +103,7 @@ var PersonColumns = struct {
 	OutreachIsAdmin: "outreach_is_admin",
 	OutreachGUID:    "outreach_guid",
 	OutreachRoleID:  "outreach_role_id",
+	RoleIdsLocked:   "role_ids_locked",
 }
 
 var PersonTableColumns = struct {
@@ -127,6 +130,7 @@ var PersonTableColumns = struct {
 	OutreachIsAdmin string
 	OutreachGUID    string
 	OutreachRoleID  string
+	RoleIdsLocked   string
 }{
 	ID:              "person.id",
 	TenantID:        "person.tenant_id",
@@ -151,6 +155,7 @@ var PersonTableColumns = struct {
 	OutreachIsAdmin: "person.outreach_is_admin",
 	OutreachGUID:    "person.outreach_guid",
 	OutreachRoleID:  "person.outreach_role_id",
+	RoleIdsLocked:   "person.role_ids_locked",
 }
 
 // Generated where
@@ -212,6 +217,7 @@ var PersonWhere = struct {
 	OutreachIsAdmin whereHelpernull_Bool
 	OutreachGUID    whereHelpernull_String
 	OutreachRoleID  whereHelpernull_String
+	RoleIdsLocked   whereHelperbool
 }{
 	ID:              whereHelperstring{field: "\"person\".\"id\""},
 	TenantID:        whereHelperstring{field: "\"person\".\"tenant_id\""},
@@ -236,6 +242,7 @@ var PersonWhere = struct {
 	OutreachIsAdmin: whereHelpernull_Bool{field: "\"person\".\"outreach_is_admin\""},
 	OutreachGUID:    whereHelpernull_String{field: "\"person\".\"outreach_guid\""},
 	OutreachRoleID:  whereHelpernull_String{field: "\"person\".\"outreach_role_id\""},
+	RoleIdsLocked:   whereHelperbool{field: "\"person\".\"role_ids_locked\""},
 }
 
 // PersonRels is where relationship names are stored.
@@ -255,9 +262,9 @@ func (*personR) NewStruct() *personR {
 type personL struct{}
 
 var (
-	personAllColumns            = []string{"id", "tenant_id", "name", "first_name", "last_name", "email", "manager_id", "role_ids", "crm_role_ids", "is_provisioned", "is_synced", "status", "created_by", "created_at", "updated_by", "updated_at", "group_id", "type", "photo_url", "outreach_id", "outreach_is_admin", "outreach_guid", "outreach_role_id"}
+	personAllColumns            = []string{"id", "tenant_id", "name", "first_name", "last_name", "email", "manager_id", "role_ids", "crm_role_ids", "is_provisioned", "is_synced", "status", "created_by", "created_at", "updated_by", "updated_at", "group_id", "type", "photo_url", "outreach_id", "outreach_is_admin", "outreach_guid", "outreach_role_id", "role_ids_locked"}
 	personColumnsWithoutDefault = []string{"id", "tenant_id"}
-	personColumnsWithDefault    = []string{"name", "first_name", "last_name", "email", "manager_id", "role_ids", "crm_role_ids", "is_provisioned", "is_synced", "status", "created_by", "created_at", "updated_by", "updated_at", "group_id", "type", "photo_url", "outreach_id", "outreach_is_admin", "outreach_guid", "outreach_role_id"}
+	personColumnsWithDefault    = []string{"name", "first_name", "last_name", "email", "manager_id", "role_ids", "crm_role_ids", "is_provisioned", "is_synced", "status", "created_by", "created_at", "updated_by", "updated_at", "group_id", "type", "photo_url", "outreach_id", "outreach_is_admin", "outreach_guid", "outreach_role_id", "role_ids_locked"}
 	personPrimaryKeyColumns     = []string{"tenant_id", "id"}
 	personGeneratedColumns      = []string{}
 )
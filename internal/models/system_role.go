@@ -25,82 +25,92 @@ import (
 
 // SystemRole is an object representing the database table.
 type SystemRole struct {
-	ID          string           `boil:"id" json:"id" toml:"id" yaml:"id"`
-	TenantID    string           `boil:"tenant_id" json:"tenant_id" toml:"tenant_id" yaml:"tenant_id"`
-	Name        string           `boil:"name" json:"name" toml:"name" yaml:"name"`
-	Description null.String      `boil:"description" json:"description,omitempty" toml:"description" yaml:"description,omitempty"`
-	Type        string           `boil:"type" json:"type" toml:"type" yaml:"type"`
-	Status      string           `boil:"status" json:"status" toml:"status" yaml:"status"`
-	Priority    int              `boil:"priority" json:"priority" toml:"priority" yaml:"priority"`
-	CreatedBy   string           `boil:"created_by" json:"created_by" toml:"created_by" yaml:"created_by"`
-	CreatedAt   time.Time        `boil:"created_at" json:"created_at" toml:"created_at" yaml:"created_at"`
-	UpdatedBy   string           `boil:"updated_by" json:"updated_by" toml:"updated_by" yaml:"updated_by"`
-	UpdatedAt   time.Time        `boil:"updated_at" json:"updated_at" toml:"updated_at" yaml:"updated_at"`
-	Permissions types.Int64Array `boil:"permissions" json:"permissions" toml:"permissions" yaml:"permissions"`
-	BaseRoleID  null.String      `boil:"base_role_id" json:"base_role_id,omitempty" toml:"base_role_id" yaml:"base_role_id,omitempty"`
+	ID                 string           `boil:"id" json:"id" toml:"id" yaml:"id"`
+	TenantID           string           `boil:"tenant_id" json:"tenant_id" toml:"tenant_id" yaml:"tenant_id"`
+	Name               string           `boil:"name" json:"name" toml:"name" yaml:"name"`
+	Description        null.String      `boil:"description" json:"description,omitempty" toml:"description" yaml:"description,omitempty"`
+	Type               string           `boil:"type" json:"type" toml:"type" yaml:"type"`
+	Status             string           `boil:"status" json:"status" toml:"status" yaml:"status"`
+	Priority           int              `boil:"priority" json:"priority" toml:"priority" yaml:"priority"`
+	CreatedBy          string           `boil:"created_by" json:"created_by" toml:"created_by" yaml:"created_by"`
+	CreatedAt          time.Time        `boil:"created_at" json:"created_at" toml:"created_at" yaml:"created_at"`
+	UpdatedBy          string           `boil:"updated_by" json:"updated_by" toml:"updated_by" yaml:"updated_by"`
+	UpdatedAt          time.Time        `boil:"updated_at" json:"updated_at" toml:"updated_at" yaml:"updated_at"`
+	Permissions        types.Int64Array `boil:"permissions" json:"permissions" toml:"permissions" yaml:"permissions"`
+	BaseRoleID         null.String      `boil:"base_role_id" json:"base_role_id,omitempty" toml:"base_role_id" yaml:"base_role_id,omitempty"`
+	GrantedPermissions types.Int64Array `boil:"granted_permissions" json:"granted_permissions" toml:"granted_permissions" yaml:"granted_permissions"`
+	RevokedPermissions types.Int64Array `boil:"revoked_permissions" json:"revoked_permissions" toml:"revoked_permissions" yaml:"revoked_permissions"`
 
 	R *systemRoleR `boil:"-" json:"-" toml:"-" yaml:"-"`
 	L systemRoleL  `boil:"-" json:"-" toml:"-" yaml:"-"`
 }
 
 var SystemRoleColumns = struct {
-	ID          string
-	TenantID    string
-	Name        string
-	Description string
-	Type        string
-	Status      string
-	Priority    string
-	CreatedBy   string
-	CreatedAt   string
-	UpdatedBy   string
-	UpdatedAt   string
-	Permissions string
-	BaseRoleID  string
+	ID                 string
+	TenantID           string
+	Name               string
+	Description        string
+	Type               string
+	Status             string
+	Priority           string
+	CreatedBy          string
+	CreatedAt          string
+	UpdatedBy          string
+	UpdatedAt          string
+	Permissions        string
+	BaseRoleID         string
+	GrantedPermissions string
+	RevokedPermissions string
 }{
-	ID:          "id",
-	TenantID:    "tenant_id",
-	Name:        "name",
-	Description: "description",
-	Type:        "type",
-	Status:      "status",
-	Priority:    "priority",
-	CreatedBy:   "created_by",
-	CreatedAt:   "created_at",
-	UpdatedBy:   "updated_by",
-	UpdatedAt:   "updated_at",
-	Permissions: "permissions",
-	BaseRoleID:  "base_role_id",
+	ID:                 "id",
+	TenantID:           "tenant_id",
+	Name:               "name",
+	Description:        "description",
+	Type:               "type",
+	Status:             "status",
+	Priority:           "priority",
+	CreatedBy:          "created_by",
+	CreatedAt:          "created_at",
+	UpdatedBy:          "updated_by",
+	UpdatedAt:          "updated_at",
+	Permissions:        "permissions",
+	BaseRoleID:         "base_role_id",
+	GrantedPermissions: "granted_permissions",
+	RevokedPermissions: "revoked_permissions",
 }
 
 var SystemRoleTableColumns = struct {
-	ID          string
-	TenantID    string
-	Name        string
-	Description string
-	Type        string
-	Status      string
-	Priority    string
-	CreatedBy   string
-	CreatedAt   string
-	UpdatedBy   string
-	UpdatedAt   string
-	Permissions string
-	BaseRoleID  string
+	ID                 string
+	TenantID           string
+	Name               string
+	Description        string
+	Type               string
+	Status             string
+	Priority           string
+	CreatedBy          string
+	CreatedAt          string
+	UpdatedBy          string
+	UpdatedAt          string
+	Permissions        string
+	BaseRoleID         string
+	GrantedPermissions string
+	RevokedPermissions string
 }{
-	ID:          "system_role.id",
-	TenantID:    "system_role.tenant_id",
-	Name:        "system_role.name",
-	Description: "system_role.description",
-	Type:        "system_role.type",
-	Status:      "system_role.status",
-	Priority:    "system_role.priority",
-	CreatedBy:   "system_role.created_by",
-	CreatedAt:   "system_role.created_at",
-	UpdatedBy:   "system_role.updated_by",
-	UpdatedAt:   "system_role.updated_at",
-	Permissions: "system_role.permissions",
-	BaseRoleID:  "system_role.base_role_id",
+	ID:                 "system_role.id",
+	TenantID:           "system_role.tenant_id",
+	Name:               "system_role.name",
+	Description:        "system_role.description",
+	Type:               "system_role.type",
+	Status:             "system_role.status",
+	Priority:           "system_role.priority",
+	CreatedBy:          "system_role.created_by",
+	CreatedAt:          "system_role.created_at",
+	UpdatedBy:          "system_role.updated_by",
+	UpdatedAt:          "system_role.updated_at",
+	Permissions:        "system_role.permissions",
+	BaseRoleID:         "system_role.base_role_id",
+	GrantedPermissions: "system_role.granted_permissions",
+	RevokedPermissions: "system_role.revoked_permissions",
 }
 
 // Generated where
@@ -127,33 +137,37 @@ func (w whereHelpertypes_Int64Array) GTE(x types.Int64Array) qm.QueryMod {
 }
 
 var SystemRoleWhere = struct {
-	ID          whereHelperstring
-	TenantID    whereHelperstring
-	Name        whereHelperstring
-	Description whereHelpernull_String
-	Type        whereHelperstring
-	Status      whereHelperstring
-	Priority    whereHelperint
-	CreatedBy   whereHelperstring
-	CreatedAt   whereHelpertime_Time
-	UpdatedBy   whereHelperstring
-	UpdatedAt   whereHelpertime_Time
-	Permissions whereHelpertypes_Int64Array
-	BaseRoleID  whereHelpernull_String
+	ID                 whereHelperstring
+	TenantID           whereHelperstring
+	Name               whereHelperstring
+	Description        whereHelpernull_String
+	Type               whereHelperstring
+	Status             whereHelperstring
+	Priority           whereHelperint
+	CreatedBy          whereHelperstring
+	CreatedAt          whereHelpertime_Time
+	UpdatedBy          whereHelperstring
+	UpdatedAt          whereHelpertime_Time
+	Permissions        whereHelpertypes_Int64Array
+	BaseRoleID         whereHelpernull_String
+	GrantedPermissions whereHelpertypes_Int64Array
+	RevokedPermissions whereHelpertypes_Int64Array
 }{
-	ID:          whereHelperstring{field: "\"system_role\".\"id\""},
-	TenantID:    whereHelperstring{field: "\"system_role\".\"tenant_id\""},
-	Name:        whereHelperstring{field: "\"system_role\".\"name\""},
-	Description: whereHelpernull_String{field: "\"system_role\".\"description\""},
-	Type:        whereHelperstring{field: "\"system_role\".\"type\""},
-	Status:      whereHelperstring{field: "\"system_role\".\"status\""},
-	Priority:    whereHelperint{field: "\"system_role\".\"priority\""},
-	CreatedBy:   whereHelperstring{field: "\"system_role\".\"created_by\""},
-	CreatedAt:   whereHelpertime_Time{field: "\"system_role\".\"created_at\""},
-	UpdatedBy:   whereHelperstring{field: "\"system_role\".\"updated_by\""},
-	UpdatedAt:   whereHelpertime_Time{field: "\"system_role\".\"updated_at\""},
-	Permissions: whereHelpertypes_Int64Array{field: "\"system_role\".\"permissions\""},
-	BaseRoleID:  whereHelpernull_String{field: "\"system_role\".\"base_role_id\""},
+	ID:                 whereHelperstring{field: "\"system_role\".\"id\""},
+	TenantID:           whereHelperstring{field: "\"system_role\".\"tenant_id\""},
+	Name:               whereHelperstring{field: "\"system_role\".\"name\""},
+	Description:        whereHelpernull_String{field: "\"system_role\".\"description\""},
+	Type:               whereHelperstring{field: "\"system_role\".\"type\""},
+	Status:             whereHelperstring{field: "\"system_role\".\"status\""},
+	Priority:           whereHelperint{field: "\"system_role\".\"priority\""},
+	CreatedBy:          whereHelperstring{field: "\"system_role\".\"created_by\""},
+	CreatedAt:          whereHelpertime_Time{field: "\"system_role\".\"created_at\""},
+	UpdatedBy:          whereHelperstring{field: "\"system_role\".\"updated_by\""},
+	UpdatedAt:          whereHelpertime_Time{field: "\"system_role\".\"updated_at\""},
+	Permissions:        whereHelpertypes_Int64Array{field: "\"system_role\".\"permissions\""},
+	BaseRoleID:         whereHelpernull_String{field: "\"system_role\".\"base_role_id\""},
+	GrantedPermissions: whereHelpertypes_Int64Array{field: "\"system_role\".\"granted_permissions\""},
+	RevokedPermissions: whereHelpertypes_Int64Array{field: "\"system_role\".\"revoked_permissions\""},
 }
 
 // SystemRoleRels is where relationship names are stored.
@@ -173,9 +187,9 @@ func (*systemRoleR) NewStruct() *systemRoleR {
 type systemRoleL struct{}
 
 var (
-	systemRoleAllColumns            = []string{"id", "tenant_id", "name", "description", "type", "status", "priority", "created_by", "created_at", "updated_by", "updated_at", "permissions", "base_role_id"}
+	systemRoleAllColumns            = []string{"id", "tenant_id", "name", "description", "type", "status", "priority", "created_by", "created_at", "updated_by", "updated_at", "permissions", "base_role_id", "granted_permissions", "revoked_permissions"}
 	systemRoleColumnsWithoutDefault = []string{"id", "name"}
-	systemRoleColumnsWithDefault    = []string{"tenant_id", "description", "type", "status", "priority", "created_by", "created_at", "updated_by", "updated_at", "permissions", "base_role_id"}
+	systemRoleColumnsWithDefault    = []string{"tenant_id", "description", "type", "status", "priority", "created_by", "created_at", "updated_by", "updated_at", "permissions", "base_role_id", "granted_permissions", "revoked_permissions"}
 	systemRolePrimaryKeyColumns     = []string{"id"}
 	systemRoleGeneratedColumns      = []string{}
 )
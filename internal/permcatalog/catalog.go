@@ -0,0 +1,180 @@
+// Package permcatalog names every permission set and permission bouncer knows about, and converts the permission bitmasks stored
+// on group viewers, system roles and tenants to and from those names.
+package permcatalog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	perm "github.com/loupe-co/bouncer/pkg/permissions"
+	authPb "github.com/loupe-co/protos/src/common/auth"
+)
+
+// Permission is a single named permission in a permission set and the bit it's stored as
+type Permission struct {
+	Set         authPb.PermissionSet
+	Permission  authPb.Permission
+	Name        string
+	Description string
+	Bits        int64
+}
+
+// Set is a permission set and the permissions it can hold, ordered by bit
+type Set struct {
+	Set         authPb.PermissionSet
+	Name        string
+	Description string
+	Permissions []*Permission
+	// Mask is every bit the set's permissions use, anything outside it isn't a permission
+	Mask int64
+}
+
+// setDescriptions and permissionDescriptions say what the sets and permissions orchard itself hands out mean, everything else
+// gets a description built from its name
+var (
+	setDescriptions = map[authPb.PermissionSet]string{
+		authPb.PermissionSet_Group: "what a person can do with a group they can view, stored on group viewers",
+	}
+	permissionDescriptions = map[authPb.Permission]string{
+		authPb.Permission_Access: "can get to it at all",
+		authPb.Permission_Read:   "can see it",
+	}
+)
+
+var (
+	loadOnce sync.Once
+	sets     []*Set
+	setsByID map[authPb.PermissionSet]*Set
+)
+
+// load builds the catalog from the auth proto enums. Bits come from bouncer's own encoding so the catalog can't drift from what
+// bouncer checks, a permission that doesn't encode to a bit in a set isn't part of it.
+func load() {
+	loadOnce.Do(func() {
+		setsByID = map[authPb.PermissionSet]*Set{}
+		for id, name := range authPb.PermissionSet_name {
+			set := authPb.PermissionSet(id)
+			s := &Set{Set: set, Name: name, Description: setDescriptions[set]}
+			if s.Description == "" {
+				s.Description = fmt.Sprintf("%s permissions", strings.ToLower(name))
+			}
+			for pid, pname := range authPb.Permission_name {
+				p := authPb.Permission(pid)
+				bits := perm.NewPermissions().WithPermissions(perm.NewPermission(set, p))[set]
+				if bits == 0 {
+					continue
+				}
+				description := permissionDescriptions[p]
+				if description == "" {
+					description = fmt.Sprintf("%s %s", strings.ToLower(pname), strings.ToLower(name))
+				}
+				s.Permissions = append(s.Permissions, &Permission{Set: set, Permission: p, Name: pname, Description: description, Bits: bits})
+				s.Mask |= bits
+			}
+			sort.Slice(s.Permissions, func(i, j int) bool { return s.Permissions[i].Bits < s.Permissions[j].Bits })
+			sets = append(sets, s)
+			setsByID[set] = s
+		}
+		sort.Slice(sets, func(i, j int) bool { return sets[i].Set < sets[j].Set })
+	})
+}
+
+// Sets is the whole catalog ordered by permission set
+func Sets() []*Set {
+	load()
+	return sets
+}
+
+// GetSet returns the catalog entry for set
+func GetSet(set authPb.PermissionSet) (*Set, bool) {
+	load()
+	s, ok := setsByID[set]
+	return s, ok
+}
+
+// ParseSet finds a permission set by name, ignoring case
+func ParseSet(name string) (authPb.PermissionSet, error) {
+	for _, s := range Sets() {
+		if strings.EqualFold(s.Name, name) {
+			return s.Set, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown permission set %q", name)
+}
+
+// Decode returns the names of the permissions in bits, in bit order, and whatever bits don't belong to a permission in set
+func Decode(set authPb.PermissionSet, bits int64) ([]string, int64) {
+	names := []string{}
+	s, ok := GetSet(set)
+	if !ok {
+		return names, bits
+	}
+	for _, p := range s.Permissions {
+		if bits&p.Bits == p.Bits {
+			names = append(names, p.Name)
+		}
+	}
+	return names, bits &^ s.Mask
+}
+
+// Format is bits as "Access|Read", unknown bits are shown in hex so they aren't silently dropped
+func Format(set authPb.PermissionSet, bits int64) string {
+	names, unknown := Decode(set, bits)
+	if unknown != 0 {
+		names = append(names, fmt.Sprintf("%#x", unknown))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// Encode ORs together the bits of the named permissions in set, names are matched ignoring case
+func Encode(set authPb.PermissionSet, names []string) (int64, error) {
+	s, ok := GetSet(set)
+	if !ok {
+		return 0, fmt.Errorf("unknown permission set %d", set)
+	}
+	bits := int64(0)
+	for _, name := range names {
+		var found *Permission
+		for _, p := range s.Permissions {
+			if strings.EqualFold(p.Name, strings.TrimSpace(name)) {
+				found = p
+				break
+			}
+		}
+		if found == nil {
+			return 0, fmt.Errorf("unknown permission %q in permission set %s", name, s.Name)
+		}
+		bits |= found.Bits
+	}
+	return bits, nil
+}
+
+// Validate checks that every bit in bits is a permission in set
+func Validate(set authPb.PermissionSet, bits int64) error {
+	s, ok := GetSet(set)
+	if !ok {
+		return fmt.Errorf("unknown permission set %d", set)
+	}
+	if bits&^s.Mask != 0 {
+		return fmt.Errorf("permissions %#x aren't permissions in permission set %s", bits&^s.Mask, s.Name)
+	}
+	return nil
+}
+
+// ValidateSets checks permissions stored the way system roles and tenants store them, as bits indexed by permission set
+func ValidateSets(permissions []int64) error {
+	for i, bits := range permissions {
+		if bits == 0 {
+			continue
+		}
+		if err := Validate(authPb.PermissionSet(i), bits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package permcatalog
+
+import (
+	"fmt"
+
+	authPb "github.com/loupe-co/protos/src/common/auth"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+)
+
+// ToProto is the catalog as returned by GetPermissionCatalog
+func ToProto() []*orchardPb.PermissionSetDefinition {
+	defs := []*orchardPb.PermissionSetDefinition{}
+	for _, s := range Sets() {
+		def := &orchardPb.PermissionSetDefinition{
+			PermissionSet: s.Set,
+			Name:          s.Name,
+			Description:   s.Description,
+		}
+		for _, p := range s.Permissions {
+			def.Permissions = append(def.Permissions, &orchardPb.PermissionDefinition{
+				Permission:  p.Permission,
+				Name:        p.Name,
+				Description: p.Description,
+				Bits:        p.Bits,
+			})
+		}
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+// NamedSets decodes permissions stored as bits indexed by permission set, sets without any bits are left out
+func NamedSets(permissions []int64) []*orchardPb.NamedPermissions {
+	named := []*orchardPb.NamedPermissions{}
+	for i, bits := range permissions {
+		if bits == 0 {
+			continue
+		}
+		named = append(named, Named(authPb.PermissionSet(i), bits))
+	}
+	return named
+}
+
+// Named decodes the bits of a single permission set
+func Named(set authPb.PermissionSet, bits int64) *orchardPb.NamedPermissions {
+	names, unknown := Decode(set, bits)
+	return &orchardPb.NamedPermissions{
+		PermissionSet: set,
+		Names:         names,
+		UnknownBits:   unknown,
+	}
+}
+
+// EncodeNamedSets sets the bits of every named permission set on permissions, growing it as needed. A set that's given both as
+// names and as bits has to agree.
+func EncodeNamedSets(permissions []int64, named []*orchardPb.NamedPermissions) ([]int64, error) {
+	out := append([]int64{}, permissions...)
+	for _, n := range named {
+		if n == nil {
+			continue
+		}
+		bits, err := Encode(n.PermissionSet, n.Names)
+		if err != nil {
+			return nil, err
+		}
+		i := int(n.PermissionSet)
+		for len(out) <= i {
+			out = append(out, 0)
+		}
+		if out[i] != 0 && out[i] != bits {
+			return nil, fmt.Errorf("permission set %s was given as %s and as %#x", n.PermissionSet, Format(n.PermissionSet, bits), out[i])
+		}
+		out[i] = bits
+	}
+	return out, nil
+}
@@ -0,0 +1,269 @@
+package client
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/loupe-co/go-common/errors"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultCacheMaxEntries         = 1000
+	defaultCacheRevalidateInterval = 30 * time.Second
+)
+
+type cacheOptions struct {
+	maxEntries         int
+	revalidateInterval time.Duration
+}
+
+type CacheOption func(*cacheOptions)
+
+// WithCacheMaxEntries bounds how many responses are kept across all tenants, the least recently used are evicted first
+func WithCacheMaxEntries(maxEntries int) CacheOption {
+	return func(o *cacheOptions) {
+		o.maxEntries = maxEntries
+	}
+}
+
+// WithCacheRevalidateInterval sets how long a tenant's cached responses are trusted before GetTenantGroupsLastModifiedTS
+// is called again to check that its groups haven't changed. 0 revalidates on every call.
+func WithCacheRevalidateInterval(interval time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		o.revalidateInterval = interval
+	}
+}
+
+// CacheStats are the counters of a HierarchyCache since it was created
+type CacheStats struct {
+	Hits          int64
+	Misses        int64
+	Evictions     int64
+	Revalidations int64
+	// Invalidations counts the times a tenant's responses were dropped because its groups changed or Invalidate was called
+	Invalidations int64
+	Entries       int
+}
+
+type cacheKey struct {
+	tenantID string
+	method   string
+	request  string
+}
+
+type cacheEntry struct {
+	key          cacheKey
+	lastModified time.Time
+	// expiresAt is zero for entries that are only dropped when the tenant's groups change
+	expiresAt time.Time
+	response  proto.Message
+}
+
+type tenantRevalidation struct {
+	lastModified time.Time
+	checkedAt    time.Time
+}
+
+// HierarchyCache caches group and subtree responses per tenant and request. A tenant's responses are kept until
+// GetTenantGroupsLastModifiedTS reports a change, which is checked at most once per revalidate interval, so a caller can
+// see a stale tree for up to that long. Everything else goes straight through to the embedded OrchardClient.
+type HierarchyCache struct {
+	*OrchardClient
+
+	opts *cacheOptions
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[cacheKey]*list.Element
+	tenants map[string]*tenantRevalidation
+	stats   CacheStats
+}
+
+func NewHierarchyCache(client *OrchardClient, opts ...CacheOption) *HierarchyCache {
+	o := &cacheOptions{
+		maxEntries:         defaultCacheMaxEntries,
+		revalidateInterval: defaultCacheRevalidateInterval,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &HierarchyCache{
+		OrchardClient: client,
+		opts:          o,
+		lru:           list.New(),
+		entries:       map[cacheKey]*list.Element{},
+		tenants:       map[string]*tenantRevalidation{},
+	}
+}
+
+func (cache *HierarchyCache) GetGroupSubTree(ctx context.Context, in *servicePb.GetGroupSubTreeRequest) (*servicePb.GetGroupSubTreeResponse, error) {
+	// Person changes don't move the groups last modified time, so subtrees with members also expire after the interval
+	return cached(ctx, cache, "GetGroupSubTree", in.TenantId, in.HydrateUsers, in, cache.OrchardClient.GetGroupSubTree)
+}
+
+func (cache *HierarchyCache) GetGroups(ctx context.Context, in *servicePb.GetGroupsRequest) (*servicePb.GetGroupsResponse, error) {
+	return cached(ctx, cache, "GetGroups", in.TenantId, false, in, cache.OrchardClient.GetGroups)
+}
+
+func (cache *HierarchyCache) GetGroupById(ctx context.Context, in *servicePb.IdRequest) (*orchardPb.Group, error) {
+	return cached(ctx, cache, "GetGroupById", in.TenantId, false, in, cache.OrchardClient.GetGroupById)
+}
+
+// Invalidate drops every cached response for the tenant, e.g. right after the caller changed its groups
+func (cache *HierarchyCache) Invalidate(tenantID string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.dropTenant(tenantID)
+	delete(cache.tenants, tenantID)
+}
+
+// Purge drops every cached response
+func (cache *HierarchyCache) Purge() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.lru.Init()
+	cache.entries = map[cacheKey]*list.Element{}
+	cache.tenants = map[string]*tenantRevalidation{}
+}
+
+func (cache *HierarchyCache) Stats() CacheStats {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	stats := cache.stats
+	stats.Entries = cache.lru.Len()
+	return stats
+}
+
+// cached returns a copy of the cached response for in, or calls fetch and caches its response. Errors are never cached.
+func cached[Req proto.Message, Res proto.Message](ctx context.Context, cache *HierarchyCache, method, tenantID string, expire bool, in Req, fetch func(context.Context, Req) (Res, error)) (Res, error) {
+	var empty Res
+	if tenantID == "" {
+		return fetch(ctx, in)
+	}
+
+	request, err := proto.MarshalOptions{Deterministic: true}.Marshal(in)
+	if err != nil {
+		return empty, errors.Wrap(err, "error building cache key")
+	}
+	key := cacheKey{tenantID: tenantID, method: method, request: string(request)}
+
+	lastModified, err := cache.revalidate(ctx, tenantID)
+	if err != nil {
+		return empty, err
+	}
+
+	if res, ok := cache.get(key); ok {
+		return res.(Res), nil
+	}
+
+	res, err := fetch(ctx, in)
+	if err != nil {
+		return empty, err
+	}
+	expiresAt := time.Time{}
+	if expire {
+		expiresAt = time.Now().Add(cache.opts.revalidateInterval)
+	}
+	cache.put(key, lastModified, expiresAt, res)
+	return proto.Clone(res).(Res), nil
+}
+
+// revalidate returns the tenant's last modified time, asking orchard for it when the interval has passed, and drops the
+// tenant's responses when it changed
+func (cache *HierarchyCache) revalidate(ctx context.Context, tenantID string) (time.Time, error) {
+	cache.mu.Lock()
+	tenant, ok := cache.tenants[tenantID]
+	if ok && time.Since(tenant.checkedAt) < cache.opts.revalidateInterval {
+		lastModified := tenant.lastModified
+		cache.mu.Unlock()
+		return lastModified, nil
+	}
+	cache.mu.Unlock()
+
+	res, err := cache.OrchardClient.GetTenantGroupsLastModifiedTS(ctx, &servicePb.GetTenantGroupsLastModifiedTSRequest{TenantId: tenantID})
+	if err != nil {
+		return time.Time{}, err
+	}
+	lastModified := time.Time{}
+	if res.LastModifiedTs != nil {
+		lastModified = time.Unix(res.LastModifiedTs.Seconds, int64(res.LastModifiedTs.Nanos)).UTC()
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.stats.Revalidations++
+	if tenant, ok := cache.tenants[tenantID]; ok && !tenant.lastModified.Equal(lastModified) {
+		cache.dropTenant(tenantID)
+	}
+	cache.tenants[tenantID] = &tenantRevalidation{lastModified: lastModified, checkedAt: time.Now()}
+	return lastModified, nil
+}
+
+func (cache *HierarchyCache) get(key cacheKey) (proto.Message, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	elem, ok := cache.entries[key]
+	if !ok {
+		cache.stats.Misses++
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	expired := !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt)
+	if tenant, ok := cache.tenants[key.tenantID]; !ok || !tenant.lastModified.Equal(entry.lastModified) || expired {
+		cache.removeElement(elem)
+		cache.stats.Misses++
+		return nil, false
+	}
+
+	cache.lru.MoveToFront(elem)
+	cache.stats.Hits++
+	return proto.Clone(entry.response), true
+}
+
+func (cache *HierarchyCache) put(key cacheKey, lastModified, expiresAt time.Time, res proto.Message) {
+	if cache.opts.maxEntries <= 0 {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry := &cacheEntry{key: key, lastModified: lastModified, expiresAt: expiresAt, response: proto.Clone(res)}
+	if elem, ok := cache.entries[key]; ok {
+		elem.Value = entry
+		cache.lru.MoveToFront(elem)
+		return
+	}
+	cache.entries[key] = cache.lru.PushFront(entry)
+
+	for cache.lru.Len() > cache.opts.maxEntries {
+		cache.removeElement(cache.lru.Back())
+		cache.stats.Evictions++
+	}
+}
+
+// dropTenant expects cache.mu to be held
+func (cache *HierarchyCache) dropTenant(tenantID string) {
+	dropped := false
+	for key, elem := range cache.entries {
+		if key.tenantID == tenantID {
+			cache.removeElement(elem)
+			dropped = true
+		}
+	}
+	if dropped {
+		cache.stats.Invalidations++
+	}
+}
+
+// removeElement expects cache.mu to be held
+func (cache *HierarchyCache) removeElement(elem *list.Element) {
+	cache.lru.Remove(elem)
+	delete(cache.entries, elem.Value.(*cacheEntry).key)
+}
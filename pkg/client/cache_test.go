@@ -0,0 +1,214 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	timestamppb "github.com/golang/protobuf/ptypes/timestamp"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+	"google.golang.org/grpc"
+)
+
+const cacheTestTenantID = "00000000-0000-0000-0000-000000000000"
+
+// fakeHierarchyClient answers the reads the hierarchy cache wraps and counts how often each one reaches orchard
+type fakeHierarchyClient struct {
+	servicePb.OrchardClient
+
+	mu           sync.Mutex
+	lastModified time.Time
+	calls        map[string]int
+}
+
+func newFakeHierarchyClient() *fakeHierarchyClient {
+	return &fakeHierarchyClient{
+		lastModified: time.Unix(1700000000, 0).UTC(),
+		calls:        map[string]int{},
+	}
+}
+
+func (fake *fakeHierarchyClient) called(method string) int {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return fake.calls[method]
+}
+
+func (fake *fakeHierarchyClient) touch() {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.lastModified = fake.lastModified.Add(time.Second)
+}
+
+func (fake *fakeHierarchyClient) GetTenantGroupsLastModifiedTS(ctx context.Context, in *servicePb.GetTenantGroupsLastModifiedTSRequest, opts ...grpc.CallOption) (*servicePb.GetTenantGroupsLastModifiedTSResponse, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.calls["GetTenantGroupsLastModifiedTS"]++
+	return &servicePb.GetTenantGroupsLastModifiedTSResponse{
+		LastModifiedTs: &timestamppb.Timestamp{Seconds: fake.lastModified.Unix(), Nanos: int32(fake.lastModified.Nanosecond())},
+	}, nil
+}
+
+func (fake *fakeHierarchyClient) GetGroupById(ctx context.Context, in *servicePb.IdRequest, opts ...grpc.CallOption) (*orchardPb.Group, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.calls["GetGroupById"]++
+	return &orchardPb.Group{Id: in.GroupId}, nil
+}
+
+func (fake *fakeHierarchyClient) GetGroupSubTree(ctx context.Context, in *servicePb.GetGroupSubTreeRequest, opts ...grpc.CallOption) (*servicePb.GetGroupSubTreeResponse, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.calls["GetGroupSubTree"]++
+	return &servicePb.GetGroupSubTreeResponse{}, nil
+}
+
+func newTestHierarchyCache(fake *fakeHierarchyClient, opts ...CacheOption) *HierarchyCache {
+	return NewHierarchyCache(&OrchardClient{client: fake}, opts...)
+}
+
+func getGroup(t *testing.T, cache *HierarchyCache, id string) {
+	t.Helper()
+	group, err := cache.GetGroupById(context.Background(), &servicePb.IdRequest{TenantId: cacheTestTenantID, GroupId: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.Id != id {
+		t.Fatalf("expected group %s, got %s", id, group.Id)
+	}
+}
+
+func TestHierarchyCacheHitsAndMisses(t *testing.T) {
+	fake := newFakeHierarchyClient()
+	cache := newTestHierarchyCache(fake, WithCacheRevalidateInterval(time.Hour))
+
+	getGroup(t, cache, "a")
+	getGroup(t, cache, "a")
+	getGroup(t, cache, "b")
+
+	if n := fake.called("GetGroupById"); n != 2 {
+		t.Errorf("expected 2 calls to orchard, got %d", n)
+	}
+	if n := fake.called("GetTenantGroupsLastModifiedTS"); n != 1 {
+		t.Errorf("expected the tenant to be revalidated once within the interval, got %d", n)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Revalidations != 1 || stats.Entries != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestHierarchyCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	fake := newFakeHierarchyClient()
+	cache := newTestHierarchyCache(fake, WithCacheMaxEntries(2), WithCacheRevalidateInterval(time.Hour))
+
+	getGroup(t, cache, "a")
+	getGroup(t, cache, "b")
+	// a is now the most recently used, so c evicts b
+	getGroup(t, cache, "a")
+	getGroup(t, cache, "c")
+
+	getGroup(t, cache, "a")
+	if n := fake.called("GetGroupById"); n != 3 {
+		t.Errorf("expected a to still be cached, got %d calls to orchard", n)
+	}
+	getGroup(t, cache, "b")
+	if n := fake.called("GetGroupById"); n != 4 {
+		t.Errorf("expected b to have been evicted, got %d calls to orchard", n)
+	}
+
+	stats := cache.Stats()
+	if stats.Evictions != 2 || stats.Entries != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestHierarchyCacheRevalidationDropsChangedTenant(t *testing.T) {
+	fake := newFakeHierarchyClient()
+	cache := newTestHierarchyCache(fake, WithCacheRevalidateInterval(0))
+
+	getGroup(t, cache, "a")
+	getGroup(t, cache, "a")
+	if n := fake.called("GetGroupById"); n != 1 {
+		t.Errorf("expected an unchanged tenant to be served from the cache, got %d calls to orchard", n)
+	}
+
+	fake.touch()
+	getGroup(t, cache, "a")
+	if n := fake.called("GetGroupById"); n != 2 {
+		t.Errorf("expected a changed tenant to be fetched again, got %d calls to orchard", n)
+	}
+
+	stats := cache.Stats()
+	if stats.Revalidations != 3 || stats.Invalidations != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestHierarchyCacheInvalidate(t *testing.T) {
+	fake := newFakeHierarchyClient()
+	cache := newTestHierarchyCache(fake, WithCacheRevalidateInterval(time.Hour))
+
+	getGroup(t, cache, "a")
+	cache.Invalidate(cacheTestTenantID)
+	getGroup(t, cache, "a")
+
+	if n := fake.called("GetGroupById"); n != 2 {
+		t.Errorf("expected an invalidated tenant to be fetched again, got %d calls to orchard", n)
+	}
+	if n := fake.called("GetTenantGroupsLastModifiedTS"); n != 2 {
+		t.Errorf("expected an invalidated tenant to be revalidated, got %d", n)
+	}
+	if stats := cache.Stats(); stats.Invalidations != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestHierarchyCacheExpiresSubTreesWithMembers(t *testing.T) {
+	fake := newFakeHierarchyClient()
+	cache := newTestHierarchyCache(fake, WithCacheRevalidateInterval(50*time.Millisecond))
+
+	getSubTree := func(hydrateUsers bool) {
+		t.Helper()
+		if _, err := cache.GetGroupSubTree(context.Background(), &servicePb.GetGroupSubTreeRequest{TenantId: cacheTestTenantID, HydrateUsers: hydrateUsers}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	getSubTree(true)
+	getSubTree(false)
+	getSubTree(true)
+	getSubTree(false)
+	if n := fake.called("GetGroupSubTree"); n != 2 {
+		t.Errorf("expected both subtrees to be cached, got %d calls to orchard", n)
+	}
+
+	// The tenant's groups haven't changed, so only the subtree with members expires
+	time.Sleep(100 * time.Millisecond)
+	getSubTree(true)
+	getSubTree(false)
+	if n := fake.called("GetGroupSubTree"); n != 3 {
+		t.Errorf("expected only the subtree with members to expire, got %d calls to orchard", n)
+	}
+}
+
+func TestHierarchyCacheSkipsRequestsWithoutTenant(t *testing.T) {
+	fake := newFakeHierarchyClient()
+	cache := newTestHierarchyCache(fake)
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.GetGroupById(context.Background(), &servicePb.IdRequest{GroupId: "a"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := fake.called("GetGroupById"); n != 2 {
+		t.Errorf("expected requests without a tenant to go straight to orchard, got %d calls", n)
+	}
+	if n := fake.called("GetTenantGroupsLastModifiedTS"); n != 0 {
+		t.Errorf("expected no revalidation without a tenant, got %d", n)
+	}
+}
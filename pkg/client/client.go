@@ -8,6 +8,7 @@ import (
 	orchardPb "github.com/loupe-co/protos/src/common/orchard"
 	servicePb "github.com/loupe-co/protos/src/services/orchard"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
@@ -16,11 +17,14 @@ type OrchardClientConfig struct {
 }
 
 type OrchardClient struct {
-	conn   *grpc.ClientConn
-	client servicePb.OrchardClient
+	conn     *grpc.ClientConn
+	client   servicePb.OrchardClient
+	ownsConn bool
 }
 
-func New(addr string) (*OrchardClient, error) {
+// New dials orchard at addr, or at ORCHARD_ADDR when addr is empty. Calls are attempted once, retries, timeouts and
+// everything else are opt in through opts.
+func New(addr string, opts ...Option) (*OrchardClient, error) {
 	cfg := OrchardClientConfig{
 		Addr: addr,
 	}
@@ -32,16 +36,66 @@ func New(addr string) (*OrchardClient, error) {
 		}
 	}
 
-	// Establish grpc connection with bouncer service, used for refreshing auth data in cache
-	conn, err := grpc.Dial(cfg.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	conn, err := grpc.Dial(cfg.Addr, o.dialOpts()...)
 	if err != nil {
-		return nil, errors.Wrap(err, "error getting bouncer service connection")
+		return nil, errors.Wrap(err, "error getting orchard service connection")
+	}
+
+	return &OrchardClient{
+		conn:     conn,
+		client:   servicePb.NewOrchardClient(conn),
+		ownsConn: true,
+	}, nil
+}
+
+func (o *options) dialOpts() []grpc.DialOption {
+	dialOpts := []grpc.DialOption{}
+	if o.tlsConfig != nil {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(o.tlsConfig)))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	if o.keepalive != nil {
+		dialOpts = append(dialOpts, grpc.WithKeepaliveParams(*o.keepalive))
+	}
+
+	// Caller interceptors run first so they see a call once, with the error of the last attempt
+	unary := append([]grpc.UnaryClientInterceptor{}, o.unaryInterceptors...)
+	if o.timeout > 0 {
+		unary = append(unary, timeoutInterceptor(o.timeout))
+	}
+	if o.retryAttempts > 1 {
+		unary = append(unary, retryInterceptor(o.retryAttempts, o.retryBaseDelay, o.retryMaxDelay))
+	}
+	if len(unary) > 0 {
+		dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(unary...))
 	}
+	if len(o.streamInterceptors) > 0 {
+		dialOpts = append(dialOpts, grpc.WithChainStreamInterceptor(o.streamInterceptors...))
+	}
+
+	return append(dialOpts, o.dialOptions...)
+}
 
+// NewFromConn wraps an existing connection, such as one to an in-process server. Closing the connection is left to the caller.
+func NewFromConn(conn *grpc.ClientConn) *OrchardClient {
 	return &OrchardClient{
 		conn:   conn,
 		client: servicePb.NewOrchardClient(conn),
-	}, nil
+	}
+}
+
+// Close closes the connection opened by New, it is a no-op for clients from NewFromConn
+func (client *OrchardClient) Close() error {
+	if !client.ownsConn {
+		return nil
+	}
+	return client.conn.Close()
 }
 
 func (client *OrchardClient) GetUserTeam(ctx context.Context, in *servicePb.GetUserTeamRequest) (*servicePb.GetUserTeamResponse, error) {
@@ -96,14 +150,27 @@ func (client *OrchardClient) CreateSystemRole(ctx context.Context, in *servicePb
 	return client.client.CreateSystemRole(ctx, in)
 }
 
+func (client *OrchardClient) CloneSystemRole(ctx context.Context, in *servicePb.CloneSystemRoleRequest) (*servicePb.CloneSystemRoleResponse, error) {
+	return client.client.CloneSystemRole(ctx, in)
+}
+
 func (client *OrchardClient) GetSystemRoleById(ctx context.Context, in *servicePb.IdRequest) (*orchardPb.SystemRole, error) {
 	return client.client.GetSystemRoleById(ctx, in)
 }
 
+func (client *OrchardClient) GetSystemRoleWithBaseRole(ctx context.Context, in *servicePb.IdRequest) (*servicePb.GetSystemRoleWithBaseRoleResponse, error) {
+	return client.client.GetSystemRoleWithBaseRole(ctx, in)
+}
+
 func (client *OrchardClient) GetSystemRoles(ctx context.Context, in *servicePb.GetSystemRolesRequest) (*servicePb.GetSystemRolesResponse, error) {
 	return client.client.GetSystemRoles(ctx, in)
 }
 
+// GetSystemRoleDiffs shows how a tenant's cloned system roles differ from their base roles
+func (client *OrchardClient) GetSystemRoleDiffs(ctx context.Context, in *servicePb.GetSystemRoleDiffsRequest) (*servicePb.GetSystemRoleDiffsResponse, error) {
+	return client.client.GetSystemRoleDiffs(ctx, in)
+}
+
 func (client *OrchardClient) UpdateSystemRole(ctx context.Context, in *servicePb.UpdateSystemRoleRequest) (*servicePb.UpdateSystemRoleResponse, error) {
 	return client.client.UpdateSystemRole(ctx, in)
 }
@@ -112,6 +179,35 @@ func (client *OrchardClient) DeleteSystemRoleById(ctx context.Context, in *servi
 	return client.client.DeleteSystemRoleById(ctx, in)
 }
 
+// DeleteSystemRole deletes a system role, reassigning everyone who holds it to a replacement role if one is given
+func (client *OrchardClient) DeleteSystemRole(ctx context.Context, in *servicePb.DeleteSystemRoleRequest) (*servicePb.DeleteSystemRoleResponse, error) {
+	return client.client.DeleteSystemRole(ctx, in)
+}
+
+// GetSystemRoleUsage lists the people and groups holding system roles
+func (client *OrchardClient) GetSystemRoleUsage(ctx context.Context, in *servicePb.GetSystemRoleUsageRequest) (*servicePb.GetSystemRoleUsageResponse, error) {
+	return client.client.GetSystemRoleUsage(ctx, in)
+}
+
+// GetRoleAssignmentRules returns the rules a tenant's people get their system roles from
+func (client *OrchardClient) GetRoleAssignmentRules(ctx context.Context, in *servicePb.GetRoleAssignmentRulesRequest) (*servicePb.GetRoleAssignmentRulesResponse, error) {
+	return client.client.GetRoleAssignmentRules(ctx, in)
+}
+
+// SetRoleAssignmentRules replaces a tenant's role assignment rules, optionally applying them right away
+func (client *OrchardClient) SetRoleAssignmentRules(ctx context.Context, in *servicePb.SetRoleAssignmentRulesRequest) (*servicePb.SetRoleAssignmentRulesResponse, error) {
+	return client.client.SetRoleAssignmentRules(ctx, in)
+}
+
+// PreviewRoleAssignmentRules shows whose system roles a rule set would change
+func (client *OrchardClient) PreviewRoleAssignmentRules(ctx context.Context, in *servicePb.PreviewRoleAssignmentRulesRequest) (*servicePb.PreviewRoleAssignmentRulesResponse, error) {
+	return client.client.PreviewRoleAssignmentRules(ctx, in)
+}
+
+func (client *OrchardClient) ApplyRoleAssignmentRules(ctx context.Context, in *servicePb.ApplyRoleAssignmentRulesRequest) (*servicePb.ApplyRoleAssignmentRulesResponse, error) {
+	return client.client.ApplyRoleAssignmentRules(ctx, in)
+}
+
 func (client *OrchardClient) UpsertCRMRoles(ctx context.Context, in *servicePb.UpsertCRMRolesRequest) (*servicePb.UpsertCRMRolesResponse, error) {
 	return client.client.UpsertCRMRoles(ctx, in)
 }
@@ -152,6 +248,21 @@ func (client *OrchardClient) GetPersonViewableGroups(ctx context.Context, in *se
 	return client.client.GetPersonViewableGroups(ctx, in)
 }
 
+// GetEffectivePermissions resolves a person's system roles, viewable groups and permission bits, set Explain to trace every grant
+func (client *OrchardClient) GetEffectivePermissions(ctx context.Context, in *servicePb.GetEffectivePermissionsRequest) (*servicePb.GetEffectivePermissionsResponse, error) {
+	return client.client.GetEffectivePermissions(ctx, in)
+}
+
+// CheckAccess answers many viewer to group or person access checks in one call, each decision comes with its reason
+func (client *OrchardClient) CheckAccess(ctx context.Context, in *servicePb.CheckAccessRequest) (*servicePb.CheckAccessResponse, error) {
+	return client.client.CheckAccess(ctx, in)
+}
+
+// GetPermissionCatalog lists every permission set and permission with its name, description and bits, for decoding permission masks
+func (client *OrchardClient) GetPermissionCatalog(ctx context.Context, in *servicePb.GetPermissionCatalogRequest) (*servicePb.GetPermissionCatalogResponse, error) {
+	return client.client.GetPermissionCatalog(ctx, in)
+}
+
 func (client *OrchardClient) SetPersonViewableGroups(ctx context.Context, in *servicePb.SetPersonViewableGroupsRequest) (*servicePb.SetPersonViewableGroupsResponse, error) {
 	return client.client.SetPersonViewableGroups(ctx, in)
 }
@@ -164,6 +275,48 @@ func (client *OrchardClient) DeleteGroupViewerById(ctx context.Context, in *serv
 	return client.client.DeleteGroupViewerById(ctx, in)
 }
 
+// SetGroupViewers replaces all of a group's viewers and their permissions in one transaction
+func (client *OrchardClient) SetGroupViewers(ctx context.Context, in *servicePb.SetGroupViewersRequest) (*servicePb.SetGroupViewersResponse, error) {
+	return client.client.SetGroupViewers(ctx, in)
+}
+
+// CopyViewerGrants gives a person all of another person's group viewer grants, e.g. when someone is replaced
+func (client *OrchardClient) CopyViewerGrants(ctx context.Context, in *servicePb.CopyViewerGrantsRequest) (*servicePb.CopyViewerGrantsResponse, error) {
+	return client.client.CopyViewerGrants(ctx, in)
+}
+
+// ApplySubtreeViewers grants or removes viewers on a group and every group under it
+func (client *OrchardClient) ApplySubtreeViewers(ctx context.Context, in *servicePb.ApplySubtreeViewersRequest) (*servicePb.ApplySubtreeViewersResponse, error) {
+	return client.client.ApplySubtreeViewers(ctx, in)
+}
+
+// OpenAccessReview opens an access review campaign, snapshotting a tenant's group viewer grants and elevated system roles for review
+func (client *OrchardClient) OpenAccessReview(ctx context.Context, in *servicePb.OpenAccessReviewRequest) (*servicePb.OpenAccessReviewResponse, error) {
+	return client.client.OpenAccessReview(ctx, in)
+}
+
+func (client *OrchardClient) GetAccessReviews(ctx context.Context, in *servicePb.GetAccessReviewsRequest) (*servicePb.GetAccessReviewsResponse, error) {
+	return client.client.GetAccessReviews(ctx, in)
+}
+
+func (client *OrchardClient) GetAccessReviewItems(ctx context.Context, in *servicePb.GetAccessReviewItemsRequest) (*servicePb.GetAccessReviewItemsResponse, error) {
+	return client.client.GetAccessReviewItems(ctx, in)
+}
+
+// RecordAccessReviewDecisions records a reviewer's keep or revoke decisions on access review items
+func (client *OrchardClient) RecordAccessReviewDecisions(ctx context.Context, in *servicePb.RecordAccessReviewDecisionsRequest) (*servicePb.RecordAccessReviewDecisionsResponse, error) {
+	return client.client.RecordAccessReviewDecisions(ctx, in)
+}
+
+// CloseAccessReview closes an access review campaign, revoking everything that wasn't kept
+func (client *OrchardClient) CloseAccessReview(ctx context.Context, in *servicePb.CloseAccessReviewRequest) (*servicePb.CloseAccessReviewResponse, error) {
+	return client.client.CloseAccessReview(ctx, in)
+}
+
+func (client *OrchardClient) GetAccessReviewReport(ctx context.Context, in *servicePb.GetAccessReviewReportRequest) (*servicePb.GetAccessReviewReportResponse, error) {
+	return client.client.GetAccessReviewReport(ctx, in)
+}
+
 func (client *OrchardClient) CreatePerson(ctx context.Context, in *servicePb.CreatePersonRequest) (*servicePb.CreatePersonResponse, error) {
 	return client.client.CreatePerson(ctx, in)
 }
@@ -180,6 +333,11 @@ func (client *OrchardClient) SearchPeople(ctx context.Context, in *servicePb.Sea
 	return client.client.SearchPeople(ctx, in)
 }
 
+// SearchDirectory searches the people and groups in.PersonId can view, ranked by how well they match the query
+func (client *OrchardClient) SearchDirectory(ctx context.Context, in *servicePb.SearchDirectoryRequest) (*servicePb.SearchDirectoryResponse, error) {
+	return client.client.SearchDirectory(ctx, in)
+}
+
 func (client *OrchardClient) GetGroupMembers(ctx context.Context, in *servicePb.GetGroupMembersRequest) (*servicePb.GetGroupMembersResponse, error) {
 	return client.client.GetGroupMembers(ctx, in)
 }
@@ -196,6 +354,10 @@ func (client *OrchardClient) UpdatePerson(ctx context.Context, in *servicePb.Upd
 	return client.client.UpdatePerson(ctx, in)
 }
 
+func (client *OrchardClient) UpdatePersonGroups(ctx context.Context, in *servicePb.UpdatePersonGroupsRequest) (*servicePb.UpdatePersonGroupsResponse, error) {
+	return client.client.UpdatePersonGroups(ctx, in)
+}
+
 func (client *OrchardClient) DeletePersonById(ctx context.Context, in *servicePb.IdRequest) (*servicePb.Empty, error) {
 	return client.client.DeletePersonById(ctx, in)
 }
@@ -236,6 +398,10 @@ func (client *OrchardClient) DeleteGroupById(ctx context.Context, in *servicePb.
 	return client.client.DeleteGroupById(ctx, in)
 }
 
+func (client *OrchardClient) GetTenantGroupsLastModifiedTS(ctx context.Context, in *servicePb.GetTenantGroupsLastModifiedTSRequest) (*servicePb.GetTenantGroupsLastModifiedTSResponse, error) {
+	return client.client.GetTenantGroupsLastModifiedTS(ctx, in)
+}
+
 func (client *OrchardClient) ClonePerson(ctx context.Context, in *servicePb.ClonePersonRequest) (*servicePb.ClonePersonResponse, error) {
 	return client.client.ClonePerson(ctx, in)
 }
@@ -251,3 +417,21 @@ func (client *OrchardClient) GetPeopleByEmail(ctx context.Context, in *servicePb
 func (client *OrchardClient) ReprovisionPeople(ctx context.Context, in *servicePb.IdRequest) (*servicePb.ReprovisionPeopleResponse, error) {
 	return client.client.ReprovisionPeople(ctx, in)
 }
+
+func (client *OrchardClient) GetTenantPersonCount(ctx context.Context, in *servicePb.GetTenantPersonCountRequest) (*servicePb.GetTenantPersonCountResponse, error) {
+	return client.client.GetTenantPersonCount(ctx, in)
+}
+
+func (client *OrchardClient) GetOutreachUserCommitMappings(ctx context.Context, in *servicePb.GetOutreachUserCommitMappingsRequest) (*servicePb.GetOutreachUserCommitMappingsResponse, error) {
+	return client.client.GetOutreachUserCommitMappings(ctx, in)
+}
+
+// ExportPeople streams the tenant's people in chunks, pass the cursor of the last received chunk to resume after an error
+func (client *OrchardClient) ExportPeople(ctx context.Context, in *servicePb.ExportPeopleRequest) (servicePb.Orchard_ExportPeopleClient, error) {
+	return client.client.ExportPeople(ctx, in)
+}
+
+// ExportGroups streams the tenant's groups in chunks, pass the cursor of the last received chunk to resume after an error
+func (client *OrchardClient) ExportGroups(ctx context.Context, in *servicePb.ExportGroupsRequest) (servicePb.Orchard_ExportGroupsClient, error) {
+	return client.client.ExportGroups(ctx, in)
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// idempotentMethods are the reads that are safe to retry, matched on the method name without the service prefix
+var idempotentMethods = map[string]bool{
+	"GetUserTeam":                   true,
+	"IsHierarchySynced":             true,
+	"GetGroupSyncSettings":          true,
+	"GetLegacyTeamStructure":        true,
+	"GetSystemRoleById":             true,
+	"GetSystemRoleWithBaseRole":     true,
+	"GetSystemRoles":                true,
+	"GetSystemRoleDiffs":            true,
+	"GetSystemRoleUsage":            true,
+	"GetRoleAssignmentRules":        true,
+	"PreviewRoleAssignmentRules":    true,
+	"GetCRMRoleById":                true,
+	"GetCRMRolesByIds":              true,
+	"GetCRMRoles":                   true,
+	"GetUnsyncedCRMRoles":           true,
+	"GetGroupViewers":               true,
+	"GetPersonViewableGroups":       true,
+	"GetEffectivePermissions":       true,
+	"CheckAccess":                   true,
+	"GetPermissionCatalog":          true,
+	"GetAccessReviews":              true,
+	"GetAccessReviewItems":          true,
+	"GetAccessReviewReport":         true,
+	"GetPersonById":                 true,
+	"SearchPeople":                  true,
+	"SearchDirectory":               true,
+	"GetGroupMembers":               true,
+	"GetUngroupedPeople":            true,
+	"GetVirtualUsers":               true,
+	"GetGroupById":                  true,
+	"GetGroups":                     true,
+	"GetManagerAndParentIDs":        true,
+	"GetGroupSubTree":               true,
+	"GetTenantGroupsLastModifiedTS": true,
+	"GetTenantPersonCount":          true,
+	"GetPeopleByEmail":              true,
+	"GetOutreachUserCommitMappings": true,
+}
+
+// retryableCodes are the errors where the request most likely never reached a handler, or the handler gave up without side effects
+var retryableCodes = map[codes.Code]bool{
+	codes.Unavailable:       true,
+	codes.ResourceExhausted: true,
+	codes.Aborted:           true,
+}
+
+func isIdempotent(method string) bool {
+	return idempotentMethods[path.Base(method)]
+}
+
+func timeoutInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func retryInterceptor(attempts int, baseDelay, maxDelay time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !isIdempotent(method) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		delay := baseDelay
+		var err error
+		for attempt := 1; ; attempt++ {
+			err = invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil || attempt >= attempts || !retryableCodes[status.Code(err)] {
+				return err
+			}
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+
+			delay *= 2
+			if delay > maxDelay {
+				delay = maxDelay
+			}
+		}
+	}
+}
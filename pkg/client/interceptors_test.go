@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// countingInvoker fails with the given errors in order and then succeeds, counting every call
+type countingInvoker struct {
+	errs  []error
+	calls int
+}
+
+func (c *countingInvoker) invoke(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+	c.calls++
+	if c.calls <= len(c.errs) {
+		return c.errs[c.calls-1]
+	}
+	return nil
+}
+
+func unavailable() error {
+	return status.Error(codes.Unavailable, "orchard is down")
+}
+
+func TestDefaultOptionsDontRetry(t *testing.T) {
+	o := defaultOptions()
+	if o.retryAttempts > 1 {
+		t.Errorf("expected retries to be opt in, got %d attempts by default", o.retryAttempts)
+	}
+
+	o = defaultOptions()
+	WithRetry(3, time.Millisecond, time.Millisecond)(o)
+	if o.retryAttempts != 3 {
+		t.Errorf("expected WithRetry to set 3 attempts, got %d", o.retryAttempts)
+	}
+}
+
+func TestRetryInterceptorRetriesIdempotentReads(t *testing.T) {
+	invoker := &countingInvoker{errs: []error{unavailable(), unavailable()}}
+	interceptor := retryInterceptor(3, time.Millisecond, time.Millisecond)
+
+	err := interceptor(context.Background(), "/orchard.Orchard/GetPersonById", nil, nil, nil, invoker.invoke)
+	if err != nil {
+		t.Errorf("expected the third attempt to succeed, got %v", err)
+	}
+	if invoker.calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", invoker.calls)
+	}
+}
+
+func TestRetryInterceptorStopsAfterAttempts(t *testing.T) {
+	invoker := &countingInvoker{errs: []error{unavailable(), unavailable(), unavailable()}}
+	interceptor := retryInterceptor(2, time.Millisecond, time.Millisecond)
+
+	err := interceptor(context.Background(), "/orchard.Orchard/GetGroups", nil, nil, nil, invoker.invoke)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected the last attempt's error, got %v", err)
+	}
+	if invoker.calls != 2 {
+		t.Errorf("expected 2 attempts, got %d", invoker.calls)
+	}
+}
+
+func TestRetryInterceptorNeverRetriesWrites(t *testing.T) {
+	invoker := &countingInvoker{errs: []error{unavailable()}}
+	interceptor := retryInterceptor(3, time.Millisecond, time.Millisecond)
+
+	err := interceptor(context.Background(), "/orchard.Orchard/UpdatePerson", nil, nil, nil, invoker.invoke)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected the write's error, got %v", err)
+	}
+	if invoker.calls != 1 {
+		t.Errorf("expected a write to be attempted once, got %d", invoker.calls)
+	}
+}
+
+func TestRetryInterceptorSkipsNonRetryableCodes(t *testing.T) {
+	invoker := &countingInvoker{errs: []error{status.Error(codes.InvalidArgument, "bad request")}}
+	interceptor := retryInterceptor(3, time.Millisecond, time.Millisecond)
+
+	err := interceptor(context.Background(), "/orchard.Orchard/GetPersonById", nil, nil, nil, invoker.invoke)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected the invalid argument error, got %v", err)
+	}
+	if invoker.calls != 1 {
+		t.Errorf("expected a bad request to be attempted once, got %d", invoker.calls)
+	}
+}
+
+func TestRetryInterceptorStopsWhenContextIsDone(t *testing.T) {
+	invoker := &countingInvoker{errs: []error{unavailable(), unavailable(), unavailable()}}
+	interceptor := retryInterceptor(3, time.Hour, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := interceptor(ctx, "/orchard.Orchard/GetPersonById", nil, nil, nil, invoker.invoke)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected the first attempt's error, got %v", err)
+	}
+	if invoker.calls != 1 {
+		t.Errorf("expected no retries once the context is done, got %d attempts", invoker.calls)
+	}
+}
+
+func TestTimeoutInterceptorSetsMissingDeadline(t *testing.T) {
+	interceptor := timeoutInterceptor(time.Minute)
+
+	var deadline time.Time
+	var hasDeadline bool
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		deadline, hasDeadline = ctx.Deadline()
+		return nil
+	}
+
+	if err := interceptor(context.Background(), "/orchard.Orchard/GetPersonById", nil, nil, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+	if !hasDeadline || time.Until(deadline) > time.Minute {
+		t.Errorf("expected a deadline within a minute, got %v (set: %v)", deadline, hasDeadline)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	want, _ := ctx.Deadline()
+	if err := interceptor(ctx, "/orchard.Orchard/GetPersonById", nil, nil, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+	if !deadline.Equal(want) {
+		t.Errorf("expected the caller's deadline %v to be kept, got %v", want, deadline)
+	}
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"crypto/tls"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	defaultRetryAttempts   = 1
+	defaultRetryBaseDelay  = 100 * time.Millisecond
+	defaultRetryMaxDelay   = 2 * time.Second
+	defaultKeepaliveTime   = 30 * time.Second
+	defaultKeepaliveWindow = 10 * time.Second
+)
+
+type options struct {
+	tlsConfig          *tls.Config
+	timeout            time.Duration
+	retryAttempts      int
+	retryBaseDelay     time.Duration
+	retryMaxDelay      time.Duration
+	keepalive          *keepalive.ClientParameters
+	unaryInterceptors  []grpc.UnaryClientInterceptor
+	streamInterceptors []grpc.StreamClientInterceptor
+	dialOptions        []grpc.DialOption
+}
+
+func defaultOptions() *options {
+	return &options{
+		retryAttempts:  defaultRetryAttempts,
+		retryBaseDelay: defaultRetryBaseDelay,
+		retryMaxDelay:  defaultRetryMaxDelay,
+	}
+}
+
+type Option func(*options)
+
+// WithTLS dials with TLS using the given config, a nil config uses the system roots. Without it the client dials insecurely.
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *options) {
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		o.tlsConfig = cfg
+	}
+}
+
+// WithTimeout sets a deadline on every call whose context doesn't already have one
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithRetry turns on retries, setting how many times idempotent reads are attempted when orchard is unavailable and the
+// backoff between attempts which doubles from baseDelay up to maxDelay. Writes are never retried. attempts <= 1 disables retries.
+func WithRetry(attempts int, baseDelay, maxDelay time.Duration) Option {
+	return func(o *options) {
+		o.retryAttempts = attempts
+		o.retryBaseDelay = baseDelay
+		o.retryMaxDelay = maxDelay
+	}
+}
+
+// WithKeepalive pings orchard every interval when the connection is idle and drops it when a ping isn't answered within timeout
+func WithKeepalive(interval, timeout time.Duration) Option {
+	return func(o *options) {
+		o.keepalive = &keepalive.ClientParameters{
+			Time:                interval,
+			Timeout:             timeout,
+			PermitWithoutStream: true,
+		}
+	}
+}
+
+// WithDefaultKeepalive is WithKeepalive with a 30s interval and 10s timeout
+func WithDefaultKeepalive() Option {
+	return WithKeepalive(defaultKeepaliveTime, defaultKeepaliveWindow)
+}
+
+// WithUnaryInterceptors adds interceptors that run outside of the client's own timeout and retry handling
+func WithUnaryInterceptors(interceptors ...grpc.UnaryClientInterceptor) Option {
+	return func(o *options) {
+		o.unaryInterceptors = append(o.unaryInterceptors, interceptors...)
+	}
+}
+
+// WithStreamInterceptors adds stream interceptors
+func WithStreamInterceptors(interceptors ...grpc.StreamClientInterceptor) Option {
+	return func(o *options) {
+		o.streamInterceptors = append(o.streamInterceptors, interceptors...)
+	}
+}
+
+// WithDialOptions passes extra options through to grpc.Dial
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) {
+		o.dialOptions = append(o.dialOptions, opts...)
+	}
+}
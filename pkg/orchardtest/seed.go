@@ -0,0 +1,165 @@
+package orchardtest
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/loupe-co/go-common/errors"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/internal/models"
+	tenantPb "github.com/loupe-co/protos/src/common/tenant"
+	"github.com/volatiletech/sqlboiler/v4/types"
+)
+
+// seedData is the format of fixtures/seed.json, tenants are optional and created as active tenants when a row references one that isn't listed
+type seedData struct {
+	Tenants      []*models.Tenant      `json:"tenants"`
+	SystemRoles  []*models.SystemRole  `json:"system_roles"`
+	CRMRoles     []*models.CRMRole     `json:"crm_roles"`
+	Groups       []*models.Group       `json:"groups"`
+	People       []*models.Person      `json:"people"`
+	GroupViewers []*models.GroupViewer `json:"group_viewers"`
+}
+
+func seedFile(store *db.MemoryStore, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "error reading seed file")
+	}
+	return seed(store, data)
+}
+
+// seed inserts everything in data in a single transaction, so a bad seed leaves the store as it was
+func seed(store *db.MemoryStore, data []byte) error {
+	ctx := context.Background()
+
+	seedData := &seedData{}
+	if err := json.Unmarshal(data, seedData); err != nil {
+		return errors.Wrap(err, "error parsing seed data")
+	}
+
+	tx, err := store.NewTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	systemRoleSvc := store.NewSystemRoleService()
+	crmRoleSvc := store.NewCRMRoleService()
+	groupSvc := store.NewGroupService()
+	personSvc := store.NewPersonService()
+	viewerSvc := store.NewGroupViewerService()
+	for _, svc := range []db.Transactional{systemRoleSvc, crmRoleSvc, groupSvc, personSvc, viewerSvc} {
+		svc.SetTransaction(tx)
+	}
+
+	for _, sr := range seedData.SystemRoles {
+		if err := systemRoleSvc.Insert(ctx, sr); err != nil {
+			return errors.Wrap(err, "error seeding system_role")
+		}
+	}
+	for _, cr := range seedData.CRMRoles {
+		if err := crmRoleSvc.Insert(ctx, cr); err != nil {
+			return errors.Wrap(err, "error seeding crm_role")
+		}
+	}
+
+	unpathedTenants := map[string]bool{}
+	for _, g := range seedData.Groups {
+		if err := groupSvc.Insert(ctx, g); err != nil {
+			return errors.Wrap(err, "error seeding group")
+		}
+		if g.GroupPath == "" {
+			unpathedTenants[g.TenantID] = true
+		}
+	}
+	for tenantID := range unpathedTenants {
+		if err := groupSvc.UpdateGroupPaths(ctx, tenantID); err != nil {
+			return errors.Wrap(err, "error building seeded group paths")
+		}
+	}
+
+	for _, p := range seedData.People {
+		if err := personSvc.Insert(ctx, p); err != nil {
+			return errors.Wrap(err, "error seeding person")
+		}
+	}
+	for _, gv := range seedData.GroupViewers {
+		if err := viewerSvc.Insert(ctx, gv); err != nil {
+			return errors.Wrap(err, "error seeding group_viewer")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	tenants := map[string]*models.Tenant{}
+	for _, t := range seedData.Tenants {
+		tenants[t.ID] = t
+	}
+	addTenant := func(tenantID string) {
+		if _, ok := tenants[tenantID]; !ok && tenantID != "" {
+			now := time.Now().UTC()
+			tenants[tenantID] = &models.Tenant{
+				ID:                tenantID,
+				Status:            "active",
+				Name:              "orchardtest",
+				CreatedAt:         now,
+				UpdatedAt:         now,
+				GroupSyncState:    "inactive",
+				GroupSyncMetadata: types.JSON("{}"),
+			}
+		}
+	}
+	for _, cr := range seedData.CRMRoles {
+		addTenant(cr.TenantID)
+	}
+	for _, g := range seedData.Groups {
+		addTenant(g.TenantID)
+	}
+	for _, p := range seedData.People {
+		addTenant(p.TenantID)
+	}
+	for _, t := range tenants {
+		if _, err := store.NewTenantService().GetByID(ctx, t.ID); err == nil && !containsTenant(seedData.Tenants, t.ID) {
+			// keep tenants set by an earlier seed or SetTenant
+			continue
+		}
+		if len(t.GroupSyncMetadata) == 0 {
+			t.GroupSyncMetadata = types.JSON("{}")
+		}
+		store.SetTenant(t)
+	}
+
+	return nil
+}
+
+func containsTenant(tenants []*models.Tenant, tenantID string) bool {
+	for _, t := range tenants {
+		if t.ID == tenantID {
+			return true
+		}
+	}
+	return false
+}
+
+// seededTenants returns every active tenant in the store as the tenant-service would
+func seededTenants(store *db.MemoryStore) []*tenantPb.Tenant {
+	tenantSvc := store.NewTenantService()
+	tenants, err := tenantSvc.GetActiveTenants(context.Background())
+	if err != nil {
+		return nil
+	}
+	res := []*tenantPb.Tenant{}
+	for _, t := range tenants {
+		pbTenant, err := tenantSvc.ToProto(t)
+		if err != nil {
+			continue
+		}
+		res = append(res, pbTenant)
+	}
+	return res
+}
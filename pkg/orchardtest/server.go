@@ -0,0 +1,242 @@
+// Package orchardtest runs an in-process Orchard gRPC server backed by an in-memory store, so services that depend on
+// pkg/client can run contract tests without postgres or any of the services orchard itself calls.
+package orchardtest
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/loupe-co/go-common/errors"
+	grpcHandlers "github.com/loupe-co/orchard/cmd/server/grpc"
+	"github.com/loupe-co/orchard/internal/cache"
+	"github.com/loupe-co/orchard/internal/clients"
+	"github.com/loupe-co/orchard/internal/config"
+	"github.com/loupe-co/orchard/internal/db"
+	"github.com/loupe-co/orchard/pkg/client"
+	tenantPb "github.com/loupe-co/protos/src/common/tenant"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const bufconnSize = 1024 * 1024
+
+type options struct {
+	seedFiles []string
+	seeds     [][]byte
+	loopback  bool
+}
+
+type Option func(*options)
+
+// WithSeedFile seeds the store from a json file in the format of fixtures/seed.json
+func WithSeedFile(path string) Option {
+	return func(o *options) {
+		o.seedFiles = append(o.seedFiles, path)
+	}
+}
+
+// WithSeed seeds the store from json in the format of fixtures/seed.json
+func WithSeed(data []byte) Option {
+	return func(o *options) {
+		o.seeds = append(o.seeds, data)
+	}
+}
+
+// WithLoopback serves on a random 127.0.0.1 port instead of an in-memory bufconn listener, use Addr to get the address.
+// Needed when the code under test dials orchard by address itself.
+func WithLoopback() Option {
+	return func(o *options) {
+		o.loopback = true
+	}
+}
+
+// Server is an in-process orchard. The external clients orchard uses (tenant-service, crm-data-access, auth0, bouncer)
+// are fakes that record their calls, see Fakes.
+type Server struct {
+	store      *db.MemoryStore
+	fakes      *clients.Fakes
+	cache      *cache.MemoryBackend
+	orchard    *grpcHandlers.OrchardGRPCServer
+	grpcServer *grpc.Server
+	listener   net.Listener
+	bufconn    *bufconn.Listener
+
+	cancelBackground context.CancelFunc
+	wg               sync.WaitGroup
+
+	mu    sync.Mutex
+	conns []*grpc.ClientConn
+}
+
+// New seeds an in-memory store and starts serving on it, call Close when done
+func New(opts ...Option) (*Server, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	store := db.NewMemoryStore()
+	for _, path := range o.seedFiles {
+		if err := seedFile(store, path); err != nil {
+			return nil, err
+		}
+	}
+	for _, data := range o.seeds {
+		if err := seed(store, data); err != nil {
+			return nil, err
+		}
+	}
+
+	fakes := clients.NewFakes()
+	cfg := config.Config{
+		ProjectID:                "local",
+		SyncUsersBatchSize:       1000,
+		SyncRolesBatchSize:       500,
+		OutboxBatchSize:          100,
+		OutboxIntervalSeconds:    1,
+		OutboxMaxAttempts:        10,
+		HierarchyCache:           "memory",
+		HierarchyCacheTTLSeconds: 600,
+	}
+	// Hierarchy reads are cached like in production, so contract tests also catch a missing invalidation
+	cacheBackend := cache.NewMemoryBackend()
+	hierarchyCache := cache.New(cacheBackend, time.Duration(cfg.HierarchyCacheTTLSeconds)*time.Second)
+	server := &Server{
+		store:      store,
+		fakes:      fakes,
+		cache:      cacheBackend,
+		orchard:    grpcHandlers.New(cfg, store, fakes.Tenant, fakes.CRM, fakes.Identity, fakes.Bouncer, hierarchyCache),
+		grpcServer: grpc.NewServer(),
+	}
+	servicePb.RegisterOrchardServer(server.grpcServer, server.orchard)
+
+	if o.loopback {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, errors.Wrap(err, "error listening on loopback")
+		}
+		server.listener = listener
+	} else {
+		server.bufconn = bufconn.Listen(bufconnSize)
+		server.listener = server.bufconn
+	}
+
+	// Seeded tenants also need to exist in the fake tenant-service, handlers read them from both
+	for _, t := range seededTenants(store) {
+		fakes.Tenant.SetTenant(t)
+	}
+
+	bgCtx, cancel := context.WithCancel(context.Background())
+	server.cancelBackground = cancel
+	server.wg.Add(2)
+	go func() {
+		defer server.wg.Done()
+		_ = server.grpcServer.Serve(server.listener)
+	}()
+	go func() {
+		defer server.wg.Done()
+		_ = server.orchard.RunOutboxDispatcher(bgCtx)
+	}()
+
+	return server, nil
+}
+
+// Addr is the address the server listens on, only dialable from outside the process with WithLoopback
+func (server *Server) Addr() string {
+	return server.listener.Addr().String()
+}
+
+// Dial opens a connection to the server, it is closed by Close
+func (server *Server) Dial(ctx context.Context, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if server.bufconn != nil {
+		dialOpts = append(dialOpts, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return server.bufconn.DialContext(ctx)
+		}))
+	}
+	dialOpts = append(dialOpts, opts...)
+
+	conn, err := grpc.DialContext(ctx, server.Addr(), dialOpts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error dialing orchardtest server")
+	}
+	server.mu.Lock()
+	server.conns = append(server.conns, conn)
+	server.mu.Unlock()
+	return conn, nil
+}
+
+// Client returns an OrchardClient connected to the server
+func (server *Server) Client(ctx context.Context) (*client.OrchardClient, error) {
+	conn, err := server.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewFromConn(conn), nil
+}
+
+// Seed adds more data to the running server's store, in the format of fixtures/seed.json
+func (server *Server) Seed(data []byte) error {
+	if err := seed(server.store, data); err != nil {
+		return err
+	}
+	for _, t := range seededTenants(server.store) {
+		server.fakes.Tenant.SetTenant(t)
+	}
+	return nil
+}
+
+// SetTenant adds or replaces a tenant in both the store and the fake tenant-service
+func (server *Server) SetTenant(t *tenantPb.Tenant) error {
+	tenant, err := server.store.NewTenantService().FromProto(t)
+	if err != nil {
+		return err
+	}
+	server.store.SetTenant(tenant)
+	server.fakes.Tenant.SetTenant(t)
+	return nil
+}
+
+// Fakes are the recording fakes of the clients orchard calls, e.g. to assert that a change busted bouncer's cache
+func (server *Server) Fakes() *clients.Fakes {
+	return server.fakes
+}
+
+// DispatchOutbox delivers pending outbox messages (bouncer cache busts, auth0 provisioning) right away instead of
+// waiting for the background dispatcher
+func (server *Server) DispatchOutbox(ctx context.Context) error {
+	for {
+		n, err := server.orchard.DispatchOutbox(ctx)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+}
+
+// Reset drops all data, cached reads and recorded calls, the server keeps serving
+func (server *Server) Reset() {
+	server.store.Reset()
+	server.fakes.Reset()
+	server.cache.Flush()
+}
+
+// Close stops the server and closes every connection opened by Dial or Client
+func (server *Server) Close() {
+	server.mu.Lock()
+	for _, conn := range server.conns {
+		conn.Close()
+	}
+	server.conns = nil
+	server.mu.Unlock()
+
+	server.cancelBackground()
+	server.grpcServer.Stop()
+	server.wg.Wait()
+}
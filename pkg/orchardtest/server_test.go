@@ -0,0 +1,174 @@
+package orchardtest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/loupe-co/orchard/pkg/orchardtest"
+	orchardPb "github.com/loupe-co/protos/src/common/orchard"
+	servicePb "github.com/loupe-co/protos/src/services/orchard"
+)
+
+// Ids from fixtures/seed.json
+const (
+	seedTenantID     = "00000000-0000-0000-0000-000000000000"
+	seedRootGroupID  = "d0766561-8f86-4010-88c7-fe4857811e5a"
+	seedEMEAAEsID    = "76fbf5a2-d513-414a-b97e-900e0fbcd90d"
+	seedPatID        = "527470d1-d895-49f3-a9d4-48d8e37f6317"
+	seedAdamID       = "d945c019-4e17-41ec-9ccd-b4a008b9b853"
+	seedGroupCount   = 11
+	seedPeopleCount  = 10
+	seedFixturesPath = "../../fixtures/seed.json"
+)
+
+func TestContractSeededReads(t *testing.T) {
+	ctx := context.Background()
+	server, err := orchardtest.New(orchardtest.WithSeedFile(seedFixturesPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := client.GetGroups(ctx, &servicePb.GetGroupsRequest{TenantId: seedTenantID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups.Groups) != seedGroupCount {
+		t.Errorf("expected %d seeded groups, got %d", seedGroupCount, len(groups.Groups))
+	}
+
+	person, err := client.GetPersonById(ctx, &servicePb.IdRequest{TenantId: seedTenantID, PersonId: seedPatID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if person.Name != "Pat Rodgers" || person.GroupId != seedRootGroupID {
+		t.Errorf("expected the seeded Pat Rodgers in the root group, got %s in %s", person.Name, person.GroupId)
+	}
+
+	people, err := client.SearchPeople(ctx, &servicePb.SearchPeopleRequest{TenantId: seedTenantID, PageSize: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(people.People) != seedPeopleCount || people.Total != seedPeopleCount {
+		t.Errorf("expected %d seeded people, got %d (total %d)", seedPeopleCount, len(people.People), people.Total)
+	}
+}
+
+func TestContractUpdateGroupIsVisibleThroughTheCache(t *testing.T) {
+	ctx := context.Background()
+	server, err := orchardtest.New(orchardtest.WithSeedFile(seedFixturesPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the tree first so the hierarchy cache has it
+	if _, err := client.GetGroupSubTree(ctx, &servicePb.GetGroupSubTreeRequest{TenantId: seedTenantID, GroupId: seedRootGroupID}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.UpdateGroup(ctx, &servicePb.UpdateGroupRequest{
+		TenantId:   seedTenantID,
+		GroupId:    seedEMEAAEsID,
+		Group:      &orchardPb.Group{Id: seedEMEAAEsID, TenantId: seedTenantID, Name: "EMEA Account Executives"},
+		OnlyFields: []string{"name"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := client.GetGroupById(ctx, &servicePb.IdRequest{TenantId: seedTenantID, GroupId: seedEMEAAEsID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.Name != "EMEA Account Executives" {
+		t.Errorf("expected the renamed group, got %q", group.Name)
+	}
+
+	groups, err := client.GetGroups(ctx, &servicePb.GetGroupsRequest{TenantId: seedTenantID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, g := range groups.Groups {
+		if g.Id == seedEMEAAEsID {
+			found = g.Name == "EMEA Account Executives"
+		}
+	}
+	if !found {
+		t.Error("expected GetGroups to return the renamed group")
+	}
+}
+
+func TestContractInsertGroupViewerBustsBouncer(t *testing.T) {
+	ctx := context.Background()
+	server, err := orchardtest.New(orchardtest.WithSeedFile(seedFixturesPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.InsertGroupViewer(ctx, &servicePb.InsertGroupViewerRequest{
+		TenantId:    seedTenantID,
+		GroupViewer: &orchardPb.GroupViewer{TenantId: seedTenantID, GroupId: seedEMEAAEsID, PersonId: seedAdamID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viewers, err := client.GetGroupViewers(ctx, &servicePb.IdRequest{TenantId: seedTenantID, GroupId: seedEMEAAEsID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, id := range viewers.ViewerIds {
+		found = found || id == seedAdamID
+	}
+	if !found {
+		t.Errorf("expected %s to view the group, got %v", seedAdamID, viewers.ViewerIds)
+	}
+
+	if err := server.DispatchOutbox(ctx); err != nil {
+		t.Fatal(err)
+	}
+	busted := false
+	for _, id := range server.Fakes().Bouncer.BustedUserIDs(seedTenantID) {
+		busted = busted || id == seedAdamID
+	}
+	if !busted {
+		t.Errorf("expected the new viewer's auth cache to be busted")
+	}
+}
+
+func TestContractBadRequest(t *testing.T) {
+	ctx := context.Background()
+	server, err := orchardtest.New(orchardtest.WithSeedFile(seedFixturesPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := server.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.GetPersonById(ctx, &servicePb.IdRequest{PersonId: seedPatID}); err == nil {
+		t.Error("expected a request without a tenant to fail")
+	}
+}